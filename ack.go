@@ -0,0 +1,464 @@
+package gorillaz
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	StreamRedeliveredEvents = "stream_redelivered_events"
+)
+
+// AckStreamMethod is the gRPC method of the streams whose events are acknowledged by the consumers, see WithAck
+const AckStreamMethod = "/stream.Ack/AckStream"
+
+// key of the id of an event sent on an AckStream in the Metadata key values, the consumer acknowledges the event with it
+const ackIdMetadataKey = "ack_id"
+
+const (
+	defaultAckWindow          = 256
+	defaultAckRedeliveryDelay = 5 * time.Second
+	defaultAckRetention       = time.Minute
+	// ackEndTimeout is how long a consumer stopping waits for the provider to end its AckStream before cancelling it
+	ackEndTimeout = time.Second
+)
+
+// WithAck consumes the stream with acknowledgements: the provider keeps the events sent until they are acknowledged
+// with stream.Event.Ack, and sends them again if they are not acknowledged in time, or when the consumer reconnects.
+// The events are delivered at least once, the application must acknowledge every event received.
+// The events not acknowledged when the consumer stops are dropped by the provider, see WithAckConsumerId to keep them.
+func WithAck() ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.Ack = true
+	}
+}
+
+// WithAckConsumerId consumes the stream WithAck, identified by id across its restarts: the events not acknowledged
+// when the consumer stops or its process restarts are sent again to the next consumer with the same id, if it
+// connects within the retention of the provider, see ProviderAckRetention.
+// The id must be unique among the consumers of the stream of the same service, such as the name of the replica.
+func WithAckConsumerId(id string) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.Ack = true
+		c.AckConsumerId = id
+	}
+}
+
+// ProviderAckWindow sets the maximum number of events sent to a consumer WithAck and not acknowledged yet,
+// the next events wait for acknowledgements, and the delay after which an event not acknowledged is sent again
+func ProviderAckWindow(size int, redeliveryDelay time.Duration) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.AckWindow = size
+		p.AckRedeliveryDelay = redeliveryDelay
+	}
+}
+
+// ProviderAckRetention sets how long the events not acknowledged by a consumer which disconnected are kept for its
+// reconnection, and the maximum number of them kept, the oldest are dropped (default: 1 min, the size of the ack window)
+func ProviderAckRetention(ttl time.Duration, maxEvents int) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.AckRetention = ttl
+		p.AckRetainedEvents = maxEvents
+	}
+}
+
+// AckStream serves an AckStream: the stream request is followed by the acknowledgements of the consumer
+func (sr *streamRegistry) AckStream(strm stream.Ack_AckStreamServer) error {
+	req, err := strm.Recv()
+	if err != nil {
+		return err
+	}
+	if req.Request == nil {
+		return status.Error(codes.InvalidArgument, "the first message of an AckStream must be the stream request")
+	}
+	return sr.publishOnStream(req.Request, strm, req)
+}
+
+type pendingAck struct {
+	id     uint64
+	b      []byte // b is the event with its ack id
+	sentAt time.Time
+}
+
+// unackedEvents keeps the events not acknowledged by the consumers which disconnected, by requester,
+// they are sent again to the consumer when it reconnects, see ackRequester.
+// The events of a requester are kept for ttl at most, and max of them at most.
+type unackedEvents struct {
+	ttl    time.Duration
+	max    int
+	clock  Clock
+	mu     sync.Mutex
+	events map[string]*keptEvents
+}
+
+type keptEvents struct {
+	events []*pendingAck
+	keptAt time.Time
+}
+
+func newUnackedEvents(ttl time.Duration, max int, clock Clock) *unackedEvents {
+	if ttl <= 0 {
+		ttl = defaultAckRetention
+	}
+	return &unackedEvents{ttl: ttl, max: max, clock: clockOrSystem(clock)}
+}
+
+// keep keeps the events of the requester sorted by id, the previous ones are replaced
+func (u *unackedEvents) keep(requester string, events []*pendingAck) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := u.clock.Now()
+	u.expire(now)
+	if len(events) == 0 {
+		delete(u.events, requester)
+		return
+	}
+	if u.max > 0 && len(events) > u.max {
+		Log.Warn("too many events not acknowledged, dropping the oldest", zap.String("requester", requester), zap.Int("dropped", len(events)-u.max))
+		events = events[len(events)-u.max:]
+	}
+	if u.events == nil {
+		u.events = make(map[string]*keptEvents)
+	}
+	u.events[requester] = &keptEvents{events: events, keptAt: now}
+}
+
+// take returns the events of the requester, they are not kept anymore
+func (u *unackedEvents) take(requester string) []*pendingAck {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.expire(u.clock.Now())
+	k, ok := u.events[requester]
+	if !ok {
+		return nil
+	}
+	delete(u.events, requester)
+	return k.events
+}
+
+// expire drops the events kept for longer than the ttl, u.mu must be held
+func (u *unackedEvents) expire(now time.Time) {
+	for requester, k := range u.events {
+		if now.Sub(k.keptAt) >= u.ttl {
+			Log.Info("dropping the events not acknowledged by a consumer which did not reconnect", zap.String("requester", requester), zap.Int("events", len(k.events)))
+			delete(u.events, requester)
+		}
+	}
+}
+
+// ackWindow holds the events sent to a consumer and not acknowledged yet
+type ackWindow struct {
+	p               *StreamProvider
+	strm            grpc.ServerStream
+	peer            Peer
+	requester       string // requester identifies the consumer, see ackRequester
+	size            int
+	redeliveryDelay time.Duration
+	redelivered     prometheus.Counter
+	clock           Clock
+	sendMu          sync.Mutex // sendMu serializes the messages sent by the send loop and the redeliveries
+	mu              sync.Mutex
+	nextId          uint64
+	pending         map[uint64]*pendingAck
+	room            chan struct{}   // room is signaled when an event is acknowledged
+	ended           bool            // ended is true once the consumer ended the stream, its events not acknowledged are dropped
+	ctx             context.Context // ctx is done when the stream ends, or when the consumer ends it
+	cancel          context.CancelFunc
+}
+
+// ackRequester identifies the consumer whose events not acknowledged are sent again when it reconnects: the service requesting
+// the stream and the session of the consumer, so that the replicas of a service don't take the events of each other.
+// The session is random unless the consumer sets its id, see WithAckConsumerId.
+// A consumer without session is identified by its connection, its events are not sent again once it reconnects.
+func ackRequester(peer Peer, session string) string {
+	if session == "" {
+		return peer.name()
+	}
+	return peer.serviceName + "/" + session
+}
+
+// newAckSession returns the session of a consumer WithAck without id, unique among the consumers of the stream
+func newAckSession() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// newAckWindow starts reading the acknowledgements of the consumer and sending again the events not acknowledged in time,
+// beginning with the ones left unacknowledged by the previous connection of the consumer
+func newAckWindow(p *StreamProvider, strm grpc.ServerStream, peer Peer, session string) (*ackWindow, error) {
+	w := &ackWindow{
+		p:               p,
+		strm:            strm,
+		peer:            peer,
+		requester:       ackRequester(peer, session),
+		size:            p.config.AckWindow,
+		redeliveryDelay: p.config.AckRedeliveryDelay,
+		redelivered:     redeliveredEventsCounter(p.gaz, p.streamDef.Name),
+		clock:           p.gaz.Clock(),
+		pending:         make(map[uint64]*pendingAck),
+		room:            make(chan struct{}, 1),
+	}
+	if w.size <= 0 {
+		w.size = defaultAckWindow
+	}
+	if w.redeliveryDelay <= 0 {
+		w.redeliveryDelay = defaultAckRedeliveryDelay
+	}
+	unacked := p.unacked.take(w.requester)
+	for _, e := range unacked {
+		w.pending[e.id] = e
+		if e.id >= w.nextId {
+			w.nextId = e.id
+		}
+	}
+	if err := w.redeliver(unacked); err != nil {
+		p.unacked.keep(w.requester, unacked)
+		return nil, err
+	}
+
+	w.ctx, w.cancel = context.WithCancel(strm.Context())
+	p.gaz.goTracked("ack_receiver", p.streamDef.Name, w.receiveAcks)
+	p.gaz.goTracked("ack_redelivery", p.streamDef.Name, func() {
+		w.redeliverExpired(w.ctx)
+	})
+	return w, nil
+}
+
+// send sends the event once there is room in the window
+func (w *ackWindow) send(b []byte) error {
+	for {
+		w.mu.Lock()
+		if len(w.pending) < w.size {
+			break
+		}
+		w.mu.Unlock()
+		select {
+		case <-w.room:
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		}
+	}
+	w.nextId++
+	e := &pendingAck{id: w.nextId, b: withAckId(b, w.nextId), sentAt: w.clock.Now()}
+	w.pending[e.id] = e
+	w.mu.Unlock()
+
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	return w.strm.SendMsg(e.b)
+}
+
+func (w *ackWindow) ack(id uint64) {
+	w.mu.Lock()
+	delete(w.pending, id)
+	w.mu.Unlock()
+	select {
+	case w.room <- struct{}{}:
+	default:
+	}
+}
+
+// receiveAcks reads the acknowledgements of the consumer until the stream ends.
+// The consumer closing its side of the stream stops for good, the stream is ended, see ackStreamClient.closeSend.
+func (w *ackWindow) receiveAcks() {
+	for {
+		var req stream.AckRequest
+		if err := w.strm.RecvMsg(&req); err != nil {
+			if err == io.EOF {
+				w.mu.Lock()
+				w.ended = true
+				w.mu.Unlock()
+				w.cancel()
+			}
+			return
+		}
+		w.ack(req.AckId)
+	}
+}
+
+// redeliverExpired sends again the events not acknowledged within the redelivery delay, until ctx is done
+func (w *ackWindow) redeliverExpired(ctx context.Context) {
+	ticker := w.clock.NewTicker(w.redeliveryDelay / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+		if err := w.redeliver(w.expired(w.clock.Now())); err != nil {
+			return
+		}
+	}
+}
+
+// expired returns the events sent before the redelivery delay and not acknowledged yet, in the order they were sent
+func (w *ackWindow) expired(now time.Time) []*pendingAck {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var events []*pendingAck
+	for _, e := range w.pending {
+		if now.Sub(e.sentAt) >= w.redeliveryDelay {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].id < events[j].id
+	})
+	return events
+}
+
+func (w *ackWindow) redeliver(events []*pendingAck) error {
+	if len(events) == 0 {
+		return nil
+	}
+	Log.Debug("sending again the events not acknowledged", zap.String("stream", w.p.streamDef.Name), zap.String("peer", w.peer.address), zap.Int("events", len(events)))
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	for _, e := range events {
+		w.mu.Lock()
+		_, ok := w.pending[e.id]
+		e.sentAt = w.clock.Now()
+		w.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if err := w.strm.SendMsg(e.b); err != nil {
+			return err
+		}
+		w.redelivered.Inc()
+	}
+	return nil
+}
+
+// close keeps the events not acknowledged, for the next connection of the consumer, unless it ended the stream
+func (w *ackWindow) close() {
+	w.cancel()
+	w.mu.Lock()
+	if w.ended {
+		w.mu.Unlock()
+		Log.Debug("consumer ended the stream, dropping the events not acknowledged", zap.String("stream", w.p.streamDef.Name), zap.String("peer", w.peer.address))
+		w.p.unacked.keep(w.requester, nil)
+		return
+	}
+	events := make([]*pendingAck, 0, len(w.pending))
+	for _, e := range w.pending {
+		events = append(events, e)
+	}
+	w.mu.Unlock()
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].id < events[j].id
+	})
+	if len(events) > 0 {
+		Log.Info("consumer disconnected with events not acknowledged", zap.String("stream", w.p.streamDef.Name), zap.String("peer", w.peer.address), zap.Int("events", len(events)))
+	}
+	w.p.unacked.keep(w.requester, events)
+}
+
+// withAckId returns the marshalled event with its ack id: as the embedded messages of a protobuf message are merged
+// when they appear several times, the ack id is added to the metadata without unmarshalling the event
+func withAckId(b []byte, id uint64) []byte {
+	md := &stream.Metadata{KeyValue: map[string]string{ackIdMetadataKey: strconv.FormatUint(id, 10)}}
+	extra, err := proto.Marshal(&stream.StreamEvent{Metadata: md})
+	if err != nil {
+		// cannot happen with a map of strings
+		panic(err)
+	}
+	res := make([]byte, 0, len(b)+len(extra))
+	return append(append(res, b...), extra...)
+}
+
+// metadataAckId returns the ack id of the event, 0 if it was not sent on an AckStream
+func metadataAckId(m *stream.Metadata) uint64 {
+	if m == nil {
+		return 0
+	}
+	id, _ := strconv.ParseUint(m.KeyValue[ackIdMetadataKey], 10, 64)
+	return id
+}
+
+// ackStreamClient is the consumer side of an AckStream
+type ackStreamClient struct {
+	stream.Ack_AckStreamClient
+	mu sync.Mutex // mu serializes the acknowledgements sent by the application
+}
+
+// openAckStream opens an AckStream on the connection, the request is sent right away along with the session of the consumer
+func openAckStream(ctx context.Context, conn *grpc.ClientConn, req *stream.StreamRequest, session string, opts ...grpc.CallOption) (stream.Stream_StreamClient, error) {
+	cs, err := stream.NewAckClient(conn).AckStream(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.Send(&stream.AckRequest{Request: req, Session: session}); err != nil {
+		return nil, err
+	}
+	return &ackStreamClient{Ack_AckStreamClient: cs}, nil
+}
+
+// closeSend tells the provider that the consumer stops for good, so that it drops the events not acknowledged instead of
+// keeping them for a reconnection, and ends the stream
+func (c *ackStreamClient) closeSend() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.CloseSend()
+}
+
+// ackFunc returns the function acknowledging the event, nil if it has no ack id
+func (c *ackStreamClient) ackFunc(m *stream.Metadata) func() error {
+	id := metadataAckId(m)
+	if id == 0 {
+		return nil
+	}
+	return func() error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.Send(&stream.AckRequest{AckId: id})
+	}
+}
+
+// ackDropped acknowledges an event dropped by the consumer, such as an invalid event, so that it is not sent again
+func ackDropped(ack func() error) {
+	if ack != nil {
+		_ = ack()
+	}
+}
+
+// map of counters registered to Prometheus, by stream
+var redeliveredEventsMu sync.Mutex
+var redeliveredEventsCounters = make(map[string]prometheus.Counter)
+
+func redeliveredEventsCounter(g *Gaz, streamName string) prometheus.Counter {
+	redeliveredEventsMu.Lock()
+	defer redeliveredEventsMu.Unlock()
+
+	if c, ok := redeliveredEventsCounters[streamName]; ok {
+		return c
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: StreamRedeliveredEvents,
+		Help: "The total number of events of the stream sent again because they were not acknowledged in time",
+		ConstLabels: prometheus.Labels{
+			StreamNameLabel: streamName,
+		},
+	})
+	g.prometheusRegistry.MustRegister(c)
+	redeliveredEventsCounters[streamName] = c
+	return c
+}
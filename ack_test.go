@@ -0,0 +1,222 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestWithAckId(t *testing.T) {
+	b, err := proto.Marshal(&stream.StreamEvent{Key: []byte("k"), Value: []byte("v"), Metadata: &stream.Metadata{EventType: "flight", KeyValue: map[string]string{"priority": "1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var evt stream.StreamEvent
+	if err := proto.Unmarshal(withAckId(b, 42), &evt); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "k", string(evt.Key))
+	assert.Equal(t, "v", string(evt.Value))
+	assert.Equal(t, "flight", evt.Metadata.EventType)
+	assert.Equal(t, stream.PriorityHigh, stream.MetadataPriority(evt.Metadata))
+	assert.Equal(t, uint64(42), metadataAckId(evt.Metadata))
+}
+
+func TestAckStream(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("acked", "dummy.type", ProviderAckWindow(10, 200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "acked", WithAck())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["acked"]) == 1
+	})
+
+	provider.Submit(&stream.Event{Key: []byte("k"), Value: []byte("v")})
+	receive := func() *stream.Event {
+		t.Helper()
+		select {
+		case evt := <-consumer.EvtChan():
+			return evt
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+			return nil
+		}
+	}
+	// the event not acknowledged is sent again
+	evt := receive()
+	assert.Equal(t, "v", string(evt.Value))
+	evt = receive()
+	assert.Equal(t, "v", string(evt.Value))
+	assert.Nil(t, evt.Ack())
+
+	select {
+	case evt := <-consumer.EvtChan():
+		// a redelivery may have been sent before the acknowledgement was received
+		assert.Nil(t, evt.Ack())
+	case <-time.After(500 * time.Millisecond):
+	}
+	select {
+	case <-consumer.EvtChan():
+		t.Error("the event acknowledged is not sent again")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestUnackedEventsByConsumer(t *testing.T) {
+	replica1 := ackRequester(Peer{address: "10.0.0.1:40000", serviceName: "svc"}, "s1")
+	replica2 := ackRequester(Peer{address: "10.0.0.2:40000", serviceName: "svc"}, "s2")
+	// a consumer with an id may restart on another host
+	reconnected := ackRequester(Peer{address: "10.0.0.3:40001", serviceName: "svc"}, "s1")
+	assert.Equal(t, replica1, reconnected, "the consumer is identified across its reconnections")
+	assert.NotEqual(t, replica1, replica2, "the replicas of a service are distinct consumers")
+	assert.NotEqual(t, ackRequester(Peer{address: "10.0.0.1:40000", serviceName: "svc"}, ""), ackRequester(Peer{address: "10.0.0.1:40001", serviceName: "svc"}, ""))
+
+	u := newUnackedEvents(time.Minute, 10, nil)
+	u.keep(replica1, []*pendingAck{{id: 1}})
+	u.keep(replica2, []*pendingAck{{id: 2}})
+	// replica2 disconnects with all its events acknowledged
+	u.keep(replica2, nil)
+	assert.Empty(t, u.take(replica2))
+	events := u.take(reconnected)
+	if assert.Len(t, events, 1, "the events of a replica are kept for it only") {
+		assert.Equal(t, uint64(1), events[0].id)
+	}
+	assert.Empty(t, u.take(reconnected), "the events are taken once")
+}
+
+func TestAckStreamWithoutRequest(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	conn, err := grpc.Dial(g.grpcListener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := stream.NewAckClient(conn).AckStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, st.Send(&stream.AckRequest{AckId: 1}))
+	_, err = st.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestUnackedEventsRetention(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	u := newUnackedEvents(time.Minute, 2, clock)
+	u.keep("a", []*pendingAck{{id: 1}, {id: 2}, {id: 3}})
+	events := u.take("a")
+	if assert.Len(t, events, 2, "the oldest events are dropped beyond the limit") {
+		assert.Equal(t, uint64(2), events[0].id)
+		assert.Equal(t, uint64(3), events[1].id)
+	}
+
+	u.keep("a", []*pendingAck{{id: 1}})
+	clock.Advance(30 * time.Second)
+	u.keep("b", []*pendingAck{{id: 2}})
+	clock.Advance(30 * time.Second)
+	assert.Empty(t, u.take("a"), "the events are dropped after the retention")
+	assert.Len(t, u.take("b"), 1)
+	u.keep("c", []*pendingAck{{id: 3}})
+	clock.Advance(time.Minute)
+	u.keep("d", nil)
+	assert.Empty(t, u.events, "the expired events are freed without the consumer reconnecting")
+}
+
+func TestAckStreamEndedByConsumer(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("acked", "dummy.type", ProviderAckWindow(10, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "acked", WithAck())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["acked"]) == 1
+	})
+	provider.Submit(&stream.Event{Key: []byte("k"), Value: []byte("v")})
+	select {
+	case <-consumer.EvtChan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	consumer.Stop()
+	waitUntil(t, 5*time.Second, "events not acknowledged dropped", func() bool {
+		provider.unacked.mu.Lock()
+		defer provider.unacked.mu.Unlock()
+		return len(provider.unacked.events) == 0 && len(provider.broadcaster.Consumers()) == 0
+	})
+}
+
+func TestAckStreamConsumerId(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("acked", "dummy.type", ProviderAckWindow(10, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	consume := func() StreamConsumer {
+		t.Helper()
+		consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "acked", WithAckConsumerId("replica-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+			return len(provider.broadcaster.Consumers()) == 1
+		})
+		return consumer
+	}
+	receive := func(c StreamConsumer) *stream.Event {
+		t.Helper()
+		select {
+		case evt := <-c.EvtChan():
+			return evt
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+			return nil
+		}
+	}
+
+	consumer := consume()
+	provider.Submit(&stream.Event{Key: []byte("k"), Value: []byte("v1")})
+	assert.Equal(t, "v1", string(receive(consumer).Value))
+	consumer.Stop()
+	provider.Submit(&stream.Event{Key: []byte("k"), Value: []byte("v2")})
+	waitUntil(t, 5*time.Second, "consumer disconnected", func() bool {
+		return len(provider.broadcaster.Consumers()) == 0
+	})
+
+	// the restarted consumer receives the events not acknowledged by the previous one
+	restarted := consume()
+	defer restarted.Stop()
+	evt := receive(restarted)
+	assert.Equal(t, "v1", string(evt.Value))
+	assert.Nil(t, evt.Ack())
+}
@@ -0,0 +1,139 @@
+package gorillaz
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+const (
+	AuditSubscribe   = "subscribe"
+	AuditUnsubscribe = "unsubscribe"
+)
+
+// SubscriptionAudit records a subscription to a stream, when it starts and when it ends
+type SubscriptionAudit struct {
+	Action    string            `json:"action"`             // Action is AuditSubscribe or AuditUnsubscribe
+	At        time.Time         `json:"at"`                 // At is when the subscription started or ended
+	Stream    string            `json:"stream"`             // Stream is the name of the stream
+	Identity  string            `json:"identity,omitempty"` // Identity is the authenticated identity of the consumer, if any
+	Requester string            `json:"requester"`          // Requester is the service name declared by the consumer
+	Peer      string            `json:"peer"`               // Peer is the address of the consumer
+	Filters   map[string]string `json:"filters,omitempty"`  // Filters are the sampling, deltas and key subsets requested by the consumer
+	Duration  time.Duration     `json:"duration,omitempty"` // Duration is how long the subscription lasted, on unsubscribe
+	Events    uint64            `json:"events,omitempty"`   // Events is the number of messages sent to the consumer, on unsubscribe
+	Bytes     uint64            `json:"bytes,omitempty"`    // Bytes is the number of bytes sent to the consumer, on unsubscribe
+	Error     string            `json:"error,omitempty"`    // Error is why the subscription ended, if it did not end normally
+}
+
+// AuditSink records the subscription audits
+type AuditSink interface {
+	Audit(a SubscriptionAudit)
+}
+
+// AuditSinkFunc is a function usable as AuditSink
+type AuditSinkFunc func(a SubscriptionAudit)
+
+func (f AuditSinkFunc) Audit(a SubscriptionAudit) {
+	f(a)
+}
+
+// LogAuditSink writes the subscription audits as structured logs of the "audit" logger
+func LogAuditSink() AuditSink {
+	logger := Log.Named("audit")
+	return AuditSinkFunc(func(a SubscriptionAudit) {
+		fields := []zap.Field{
+			zap.String("action", a.Action),
+			zap.Time("at", a.At),
+			zap.String("stream", a.Stream),
+			zap.String("identity", a.Identity),
+			zap.String("requester", a.Requester),
+			zap.String("peer", a.Peer),
+			zap.Any("filters", a.Filters),
+		}
+		if a.Action == AuditUnsubscribe {
+			fields = append(fields, zap.Duration("duration", a.Duration), zap.Uint64("events", a.Events), zap.Uint64("bytes", a.Bytes), zap.String("error", a.Error))
+		}
+		logger.Info("stream subscription", fields...)
+	})
+}
+
+// StreamAuditSink submits the subscription audits as JSON events to a stream, keyed by the name of the audited stream
+func StreamAuditSink(p *StreamProvider) AuditSink {
+	return AuditSinkFunc(func(a SubscriptionAudit) {
+		b, err := json.Marshal(a)
+		if err != nil {
+			Log.Error("could not marshal subscription audit", zap.String("stream", a.Stream), zap.Error(err))
+			return
+		}
+		e := &stream.Event{Key: []byte(a.Stream), Value: b}
+		e.SetEventTime(a.At)
+		e.SetEventTypeStr("gorillaz.SubscriptionAudit")
+		p.Submit(e)
+	})
+}
+
+// WithAudit records the subscriptions to the stream in the sink
+func WithAudit(sink AuditSink) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.Audit = sink
+	}
+}
+
+// GetAndWatchAudit records the subscriptions to the stream in the sink
+func GetAndWatchAudit(sink AuditSink) GetAndWatchConfigOpt {
+	return func(p *GetAndWatchConfig) {
+		p.Audit = sink
+	}
+}
+
+// auditedStream counts the messages and the bytes sent to the consumer
+type auditedStream struct {
+	grpc.ServerStream
+	events uint64
+	bytes  uint64
+}
+
+func (s *auditedStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddUint64(&s.events, 1)
+		if b, ok := m.([]byte); ok {
+			atomic.AddUint64(&s.bytes, uint64(len(b)))
+		}
+	}
+	return err
+}
+
+// auditFilters describes the filters requested by the consumer
+func auditFilters(opts sendLoopOpts) map[string]string {
+	filters := make(map[string]string)
+	if opts.sampleEvery > 1 {
+		filters["sample_every"] = strconv.Itoa(opts.sampleEvery)
+	}
+	if opts.sampleMaxRate > 0 {
+		filters["sample_max_rate"] = strconv.FormatFloat(opts.sampleMaxRate, 'f', -1, 64)
+	}
+	if opts.delta {
+		filters["delta"] = "true"
+	}
+	if opts.keys != nil {
+		filters["watch_keys"] = strconv.Itoa(len(opts.keys.stateKeys))
+		filters["watch_key_prefixes"] = strconv.Itoa(len(opts.keys.prefixes))
+		if opts.keys.expr != nil {
+			filters["key_expression"] = opts.keys.expr.String()
+		}
+	}
+	if opts.disconnectOnBackpressure {
+		filters["disconnect_on_backpressure"] = "true"
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return filters
+}
@@ -0,0 +1,45 @@
+package gorillaz
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+type sendCountingStream struct {
+	grpc.ServerStream
+	err error
+}
+
+func (s *sendCountingStream) SendMsg(m interface{}) error {
+	return s.err
+}
+
+func TestAuditedStream(t *testing.T) {
+	inner := &sendCountingStream{}
+	s := &auditedStream{ServerStream: inner}
+	assert.Nil(t, s.SendMsg([]byte("hello")))
+	assert.Nil(t, s.SendMsg([]byte("abc")))
+	inner.err = errors.New("disconnected")
+	assert.NotNil(t, s.SendMsg([]byte("lost")))
+
+	assert.Equal(t, uint64(2), s.events)
+	assert.Equal(t, uint64(8), s.bytes)
+}
+
+func TestAuditFilters(t *testing.T) {
+	assert.Nil(t, auditFilters(sendLoopOpts{}))
+	filters := auditFilters(sendLoopOpts{
+		sampleEvery: 10,
+		delta:       true,
+		keys:        &keySubset{stateKeys: map[string]struct{}{"a": {}, "b": {}}},
+	})
+	assert.Equal(t, map[string]string{
+		"sample_every":       "10",
+		"delta":              "true",
+		"watch_keys":         "2",
+		"watch_key_prefixes": "0",
+	}, filters)
+}
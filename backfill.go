@@ -0,0 +1,263 @@
+package gorillaz
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	StreamBackfilledEvents = "stream_backfilled_events"
+)
+
+// BackfillMethod is the gRPC method of the backfill command, to use in the authorization rules of the operators
+const BackfillMethod = "/gorillaz.Control/Backfill"
+
+// BackfillRequest selects the events of a stream to re-emit, for instance to repair a consumer which lost data
+type BackfillRequest struct {
+	Stream  string    `json:"stream"`
+	FromKey []byte    `json:"from_key,omitempty"` // FromKey is the first key of the range, inclusive (default: nil, from the first key)
+	ToKey   []byte    `json:"to_key,omitempty"`   // ToKey is the end of the key range, exclusive (default: nil, to the last key)
+	Since   time.Time `json:"since,omitempty"`    // Since is the start of the event time range, inclusive (default: zero, no start)
+	Until   time.Time `json:"until,omitempty"`    // Until is the end of the event time range, exclusive (default: zero, no end)
+	Subject string    `json:"subject,omitempty"`  // Subject is the Nats subject on which the events are published (default: empty, the events are sent to the caller)
+}
+
+// Matches returns true if the event is in the key range and in the event time range of the request.
+// The events without event time are excluded when the request has a time range.
+func (r *BackfillRequest) Matches(e *stream.Event) bool {
+	if len(r.FromKey) > 0 && bytes.Compare(e.Key, r.FromKey) < 0 {
+		return false
+	}
+	if len(r.ToKey) > 0 && bytes.Compare(e.Key, r.ToKey) >= 0 {
+		return false
+	}
+	if r.Since.IsZero() && r.Until.IsZero() {
+		return true
+	}
+	ts := stream.EventTimestamp(e)
+	if ts == 0 {
+		return false
+	}
+	t := time.Unix(0, ts)
+	return !t.Before(r.Since) && (r.Until.IsZero() || t.Before(r.Until))
+}
+
+// BackfillReply is either an event re-emitted to the caller, or the number of events published on the subject of the request
+type BackfillReply struct {
+	Key       []byte           `json:"key,omitempty"`
+	Value     []byte           `json:"value,omitempty"`
+	Metadata  *stream.Metadata `json:"metadata,omitempty"`
+	Published int              `json:"published,omitempty"`
+}
+
+// BackfillSource re-emits the events of a stream from its durable log or compacted state.
+// The GetAndWatch providers are backfill sources of their current state.
+type BackfillSource interface {
+	// Backfill calls emit for each event matching the request, it stops at the first error of emit
+	Backfill(ctx context.Context, req *BackfillRequest, emit func(*stream.Event) error) error
+}
+
+// RegisterBackfillSource sets the source of the backfills of the stream, for instance a Jetstream stream,
+// it takes precedence over the state of the provider of the stream
+func (g *Gaz) RegisterBackfillSource(streamName string, s BackfillSource) {
+	g.backfillSourcesMu.Lock()
+	defer g.backfillSourcesMu.Unlock()
+	if g.backfillSources == nil {
+		g.backfillSources = make(map[string]BackfillSource)
+	}
+	g.backfillSources[streamName] = s
+}
+
+func (g *Gaz) backfillSource(streamName string) (BackfillSource, bool) {
+	g.backfillSourcesMu.Lock()
+	s, ok := g.backfillSources[streamName]
+	g.backfillSourcesMu.Unlock()
+	if ok {
+		return s, true
+	}
+	if g.streamRegistry == nil {
+		return nil, false
+	}
+	if p, ok := g.streamRegistry.find(streamName); ok {
+		s, ok := p.(BackfillSource)
+		return s, ok
+	}
+	return nil, false
+}
+
+// Backfill re-emits the events of the current state matching the request, in the order of their keys
+func (p *GetAndWatchStreamProvider) Backfill(ctx context.Context, req *BackfillRequest, emit func(*stream.Event) error) error {
+	state := p.broadcaster.GetCurrentState()
+	events := make([]*stream.Event, 0, len(state))
+	for _, v := range state {
+		if e, ok := v.(*stream.Event); ok && req.Matches(e) {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return bytes.Compare(events[i].Key, events[j].Key) < 0
+	})
+	for _, e := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := emit(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// map of counters registered to Prometheus, by stream
+var backfilledEventsMu sync.Mutex
+var backfilledEventsCounters = make(map[string]prometheus.Counter)
+
+func backfilledEventsCounter(g *Gaz, streamName string) prometheus.Counter {
+	backfilledEventsMu.Lock()
+	defer backfilledEventsMu.Unlock()
+
+	if c, ok := backfilledEventsCounters[streamName]; ok {
+		return c
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: StreamBackfilledEvents,
+		Help: "The total number of events of the stream re-emitted by backfill commands",
+		ConstLabels: prometheus.Labels{
+			StreamNameLabel: streamName,
+		},
+	})
+	g.prometheusRegistry.MustRegister(c)
+	backfilledEventsCounters[streamName] = c
+	return c
+}
+
+var backfillServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gorillaz.Control",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Backfill",
+			Handler:       backfillHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gorillaz/backfill.go",
+}
+
+func backfillHandler(srv interface{}, ss grpc.ServerStream) error {
+	var req BackfillRequest
+	if err := ss.RecvMsg(&req); err != nil {
+		return err
+	}
+	return srv.(*Gaz).backfill(ss.Context(), &req, func(r *BackfillReply) error {
+		return ss.SendMsg(r)
+	})
+}
+
+// backfill serves a backfill command, only when the authorization of the gRPC calls is enabled
+// so that the identity of the operator is checked against the rules of BackfillMethod
+func (g *Gaz) backfill(ctx context.Context, req *BackfillRequest, reply func(*BackfillReply) error) error {
+	if g.authorizer == nil {
+		return status.Error(codes.FailedPrecondition, "backfill commands require grpc.server.authz.enabled")
+	}
+	source, ok := g.backfillSource(req.Stream)
+	if !ok {
+		return status.Errorf(codes.NotFound, "no backfill source for stream %s", req.Stream)
+	}
+	operator, _ := PeerIdentity(ctx)
+	Log.Info("backfill requested", zap.String("stream", req.Stream), zap.String("operator", operator), zap.String("subject", req.Subject))
+
+	counter := backfilledEventsCounter(g, req.Stream)
+	published := 0
+	err := source.Backfill(ctx, req, func(e *stream.Event) error {
+		if req.Subject != "" {
+			if err := g.NatsPublish(req.Subject, e); err != nil {
+				return err
+			}
+			published++
+			counter.Inc()
+			return nil
+		}
+		md, err := stream.EventMetadata(e)
+		if err != nil {
+			return err
+		}
+		md.KeyValue[stream.HeaderPrefix+BackfillHeader] = "true"
+		if err := reply(&BackfillReply{Key: e.Key, Value: e.Value, Metadata: md}); err != nil {
+			return err
+		}
+		counter.Inc()
+		return nil
+	})
+	if err != nil {
+		Log.Warn("backfill failed", zap.String("stream", req.Stream), zap.String("operator", operator), zap.Error(err))
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	if req.Subject != "" {
+		Log.Info("backfill published", zap.String("stream", req.Stream), zap.String("subject", req.Subject), zap.Int("events", published))
+		return reply(&BackfillReply{Published: published})
+	}
+	return nil
+}
+
+// BackfillHeader marks the events re-emitted by a backfill command sent to the caller
+const BackfillHeader = "Gorillaz-Backfill"
+
+// Backfill asks the provider of the stream, reachable with the connection, to re-emit the events matching the request.
+// Without subject the events are passed to emit, otherwise they are published on the subject by the provider.
+// It returns the number of events emitted or published.
+func Backfill(ctx context.Context, conn *grpc.ClientConn, req *BackfillRequest, emit func(*stream.Event) error) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	desc := &backfillServiceDesc.Streams[0]
+	st, err := conn.NewStream(ctx, desc, BackfillMethod, grpc.CallContentSubtype(JSONCodecName))
+	if err != nil {
+		return 0, err
+	}
+	if err := st.SendMsg(req); err != nil {
+		return 0, err
+	}
+	if err := st.CloseSend(); err != nil {
+		return 0, err
+	}
+	emitted := 0
+	for {
+		var r BackfillReply
+		err := st.RecvMsg(&r)
+		if err == io.EOF {
+			return emitted, nil
+		}
+		if err != nil {
+			return emitted, err
+		}
+		if r.Metadata == nil {
+			emitted += r.Published
+			continue
+		}
+		e := &stream.Event{
+			Ctx:     stream.Ctx(r.Metadata),
+			Key:     r.Key,
+			Value:   r.Value,
+			Headers: stream.MetadataHeaders(r.Metadata),
+			Error:   stream.MetadataError(r.Metadata),
+		}
+		if err := emit(e); err != nil {
+			return emitted, err
+		}
+		emitted++
+	}
+}
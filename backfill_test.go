@@ -0,0 +1,76 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type backfillSourceFunc func(ctx context.Context, req *BackfillRequest, emit func(*stream.Event) error) error
+
+func (f backfillSourceFunc) Backfill(ctx context.Context, req *BackfillRequest, emit func(*stream.Event) error) error {
+	return f(ctx, req, emit)
+}
+
+func TestBackfillRequestMatches(t *testing.T) {
+	at := func(key string, ts int64) *stream.Event {
+		e := &stream.Event{Key: []byte(key)}
+		if ts > 0 {
+			e.SetEventTime(time.Unix(ts, 0))
+		}
+		return e
+	}
+	keys := &BackfillRequest{FromKey: []byte("b"), ToKey: []byte("d")}
+	assert.False(t, keys.Matches(at("a", 0)))
+	assert.True(t, keys.Matches(at("b", 0)))
+	assert.True(t, keys.Matches(at("c", 0)))
+	assert.False(t, keys.Matches(at("d", 0)))
+
+	times := &BackfillRequest{Since: time.Unix(10, 0), Until: time.Unix(20, 0)}
+	assert.False(t, times.Matches(at("a", 0)), "no event time")
+	assert.False(t, times.Matches(at("a", 9)))
+	assert.True(t, times.Matches(at("a", 10)))
+	assert.False(t, times.Matches(at("a", 20)))
+}
+
+func TestBackfill(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	g.RegisterBackfillSource("backfilled", backfillSourceFunc(func(ctx context.Context, req *BackfillRequest, emit func(*stream.Event) error) error {
+		for _, k := range []string{"a", "b", "c"} {
+			e := &stream.Event{Key: []byte(k), Value: []byte("v" + k)}
+			if req.Matches(e) {
+				if err := emit(e); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+	var replies []*BackfillReply
+	reply := func(r *BackfillReply) error {
+		replies = append(replies, r)
+		return nil
+	}
+
+	err := g.backfill(context.Background(), &BackfillRequest{Stream: "backfilled"}, reply)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "backfill requires the authorization")
+
+	g.authorizer, _ = NewAuthorizer(nil)
+	err = g.backfill(context.Background(), &BackfillRequest{Stream: "unknown"}, reply)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	err = g.backfill(context.Background(), &BackfillRequest{Stream: "backfilled", FromKey: []byte("b")}, reply)
+	assert.Nil(t, err)
+	if assert.Len(t, replies, 2) {
+		assert.Equal(t, []byte("b"), replies[0].Key)
+		assert.Equal(t, []byte("vc"), replies[1].Value)
+		assert.Equal(t, "true", stream.MetadataHeaders(replies[0].Metadata)[BackfillHeader])
+	}
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "backfilled"}, StreamBackfilledEvents, 2)
+}
@@ -0,0 +1,151 @@
+package gorillaz
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/mux"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	StreamBridgedEvents       = "stream_bridged_events"
+	StreamBridgeDroppedEvents = "stream_bridge_dropped_events"
+	StreamBridgeLagMs         = "stream_bridge_lag_ms"
+)
+
+type BridgeConfig struct {
+	DropOnBackpressure bool                // DropOnBackpressure drops the events the broadcaster cannot take, instead of slowing down the consumer (default: false)
+	OnDropped          func(*stream.Event) // OnDropped is called for each event dropped on backpressure (default: log)
+}
+
+type BridgeOpt func(c *BridgeConfig)
+
+// BridgeDropOnBackpressure drops the events the broadcaster cannot take, instead of slowing down the consumer
+func BridgeDropOnBackpressure() BridgeOpt {
+	return func(c *BridgeConfig) {
+		c.DropOnBackpressure = true
+	}
+}
+
+// BridgeOnDropped sets the function called for each event dropped on backpressure
+func BridgeOnDropped(onDropped func(*stream.Event)) BridgeOpt {
+	return func(c *BridgeConfig) {
+		c.OnDropped = onDropped
+		c.DropOnBackpressure = true
+	}
+}
+
+type bridgeMetrics struct {
+	bridgedCounter prometheus.Counter
+	droppedCounter prometheus.Counter
+	lagSummary     prometheus.Summary
+}
+
+// map of metrics registered to Prometheus, by stream
+var bridgeMetricsMu sync.Mutex
+var bridgeMonitorings = make(map[string]*bridgeMetrics)
+
+func bridgeMonitoring(g *Gaz, streamName string) *bridgeMetrics {
+	bridgeMetricsMu.Lock()
+	defer bridgeMetricsMu.Unlock()
+
+	if m, ok := bridgeMonitorings[streamName]; ok {
+		return m
+	}
+	labels := prometheus.Labels{StreamNameLabel: streamName}
+	m := &bridgeMetrics{
+		bridgedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamBridgedEvents,
+			Help:        "The total number of events of the stream submitted to a broadcaster",
+			ConstLabels: labels,
+		}),
+		droppedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamBridgeDroppedEvents,
+			Help:        "The total number of events of the stream dropped because the broadcaster could not take them",
+			ConstLabels: labels,
+		}),
+		lagSummary: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name:        StreamBridgeLagMs,
+			Help:        "distribution of delay between when events are sent by the provider and when they are submitted to the broadcaster, in milliseconds",
+			Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.bridgedCounter)
+	g.prometheusRegistry.MustRegister(m.droppedCounter)
+	g.prometheusRegistry.MustRegister(m.lagSummary)
+	bridgeMonitorings[streamName] = m
+	return m
+}
+
+// Broadcast submits the events of the consumer to the broadcaster, until the consumer is stopped, the broadcaster is closed
+// or the returned function is called.
+// By default the bridge waits for the broadcaster to take each event, so the backpressure of the broadcaster slows down
+// the consumer, which applies its own backpressure policy to the provider. With BridgeDropOnBackpressure the events
+// the broadcaster cannot take are dropped instead.
+// The lag between when the events are sent by the provider and when they are submitted to the broadcaster is monitored.
+func (c *consumer) Broadcast(b *mux.Broadcaster, opts ...BridgeOpt) (stop func()) {
+	return bridge(c.endpoint.g, c.streamName, c.evtChan, b, opts...)
+}
+
+func bridge(g *Gaz, streamName string, events <-chan *stream.Event, b *mux.Broadcaster, opts ...BridgeOpt) (stop func()) {
+	config := &BridgeConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	metrics := bridgeMonitoring(g, streamName)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	g.goTracked("bridge", streamName, func() {
+		defer close(done)
+		for {
+			var e *stream.Event
+			var ok bool
+			select {
+			case e, ok = <-events:
+				if !ok {
+					return
+				}
+				e = e.StampLineage(streamName, int64(e.StreamSeq()), g.ServiceName, false)
+			case <-ctx.Done():
+				return
+			}
+			if config.DropOnBackpressure {
+				if err := b.SubmitNonBlocking(e); err != nil {
+					if b.Closed() {
+						return
+					}
+					metrics.droppedCounter.Inc()
+					if config.OnDropped != nil {
+						config.OnDropped(e)
+					} else {
+						Log.Warn("event dropped, the broadcaster cannot take it", zap.String("stream", streamName), RedactedKey(e.Key))
+					}
+					continue
+				}
+			} else if err := b.SubmitContext(ctx, e); err != nil {
+				if ctx.Err() == nil {
+					Log.Info("broadcaster closed, stopping the bridge", zap.String("stream", streamName))
+				}
+				return
+			}
+			metrics.bridgedCounter.Inc()
+			if ts := stream.StreamTimestamp(e); ts > 0 {
+				nowMs := float64(time.Now().UnixNano()) / 1000000.0
+				metrics.lagSummary.Observe(math.Max(0, nowMs-float64(ts)/1000000.0))
+			}
+		}
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
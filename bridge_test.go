@@ -0,0 +1,79 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/mux"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBridge(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	b := mux.NewNonBlockingBroadcaster(10)
+	out := make(chan interface{}, 10)
+	b.Register(out)
+
+	events := make(chan *stream.Event, 2)
+	e := &stream.Event{Ctx: context.Background(), Key: []byte("k")}
+	e.SetStreamTime(time.Now())
+	events <- e
+	events <- &stream.Event{Ctx: context.Background(), Key: []byte("k2")}
+	close(events)
+
+	stop := bridge(g, "bridged", events, b)
+	for _, key := range []string{"k", "k2"} {
+		select {
+		case v := <-out:
+			assert.Equal(t, key, string(v.(*stream.Event).Key))
+		case <-time.After(time.Second):
+			t.Fatal("event not broadcasted")
+		}
+	}
+	stop()
+	assertCounterEquals(t, g, prometheus.Labels{StreamNameLabel: "bridged"}, StreamBridgedEvents, 2)
+}
+
+func TestBridgeDropOnBackpressure(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	// lazy broadcaster without subscriber, it takes no event
+	b := mux.NewNonBlockingBroadcaster(0, mux.LazyBroadcast)
+	events := make(chan *stream.Event, 1)
+	events <- &stream.Event{Ctx: context.Background(), Key: []byte("k")}
+	close(events)
+
+	dropped := make(chan *stream.Event, 1)
+	stop := bridge(g, "dropping", events, b, BridgeOnDropped(func(e *stream.Event) {
+		dropped <- e
+	}))
+	defer stop()
+	select {
+	case e := <-dropped:
+		assert.Equal(t, "k", string(e.Key))
+	case <-time.After(time.Second):
+		t.Fatal("event not dropped")
+	}
+}
+
+func TestBridgeStop(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	b := mux.NewNonBlockingBroadcaster(0, mux.LazyBroadcast)
+	events := make(chan *stream.Event, 1)
+	events <- &stream.Event{Ctx: context.Background(), Key: []byte("k")}
+
+	stop := bridge(g, "blocked", events, b)
+	done := make(chan struct{})
+	go func() {
+		// the bridge is waiting for the broadcaster, stopping it must not block
+		stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("bridge not stopped")
+	}
+}
@@ -0,0 +1,105 @@
+package gorillaz
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// ProtocolVersion is the version of the stream protocol implemented by this version of gorillaz,
+// it is incremented when the wire format changes in a way the capabilities cannot describe
+const ProtocolVersion = 1
+
+// the protocol version and the capabilities of a consumer are sent in the metadata of the stream request,
+// the provider answers with the ones both sides support in the header of the stream.
+// Consumers and providers predating the negotiation send neither, their peers then fall back to version 0, without capabilities.
+const (
+	protocolVersionMetadataKey = "gorillaz-protocol-version"
+	capabilitiesMetadataKey    = "gorillaz-capabilities"
+)
+
+// the capabilities of the stream protocol, a feature is only used on a stream if both its consumer and its provider support it
+const (
+	CapabilityDeltaEncoding = "delta"      // CapabilityDeltaEncoding is the encoding of the GetAndWatch updates as deltas, see WithDeltaEncoding
+	CapabilityKeySubset     = "key-subset" // CapabilityKeySubset is the filtering of the keys by the provider, see WatchKeys
+	CapabilitySampling      = "sampling"   // CapabilitySampling is the sampling of the events by the provider, see SampleEvery
+	CapabilityGzip          = "gzip"       // CapabilityGzip is the gzip compression of the events
+)
+
+// supportedCapabilities are the capabilities implemented by this version of gorillaz
+var supportedCapabilities = Capabilities{
+	Version: ProtocolVersion,
+	Flags:   []string{CapabilityDeltaEncoding, CapabilityGzip, CapabilityKeySubset, CapabilitySampling},
+}
+
+// Capabilities are the protocol version and the capabilities of a peer of a stream
+type Capabilities struct {
+	Version int      // Version is the protocol version, 0 for the peers predating the negotiation
+	Flags   []string // Flags are the capabilities, sorted
+}
+
+// Has returns true if the capability is supported
+func (c Capabilities) Has(flag string) bool {
+	for _, f := range c.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate returns the capabilities supported by both peers: the lowest protocol version and the common flags
+func (c Capabilities) negotiate(peer Capabilities) Capabilities {
+	n := Capabilities{Version: c.Version}
+	if peer.Version < n.Version {
+		n.Version = peer.Version
+	}
+	for _, f := range c.Flags {
+		if peer.Has(f) {
+			n.Flags = append(n.Flags, f)
+		}
+	}
+	sort.Strings(n.Flags)
+	return n
+}
+
+func (c Capabilities) metadata() metadata.MD {
+	md := metadata.Pairs(protocolVersionMetadataKey, strconv.Itoa(c.Version))
+	if len(c.Flags) > 0 {
+		md.Append(capabilitiesMetadataKey, c.Flags...)
+	}
+	return md
+}
+
+// capabilitiesFromMetadata returns the capabilities of the peer which sent the metadata, version 0 if it did not send any
+func capabilitiesFromMetadata(md metadata.MD) Capabilities {
+	var c Capabilities
+	if v := md.Get(protocolVersionMetadataKey); len(v) > 0 {
+		c.Version, _ = strconv.Atoi(v[0])
+	}
+	if c.Version > 0 {
+		c.Flags = append(c.Flags, md.Get(capabilitiesMetadataKey)...)
+		sort.Strings(c.Flags)
+	}
+	return c
+}
+
+// peerCapabilities holds the capabilities negotiated by a consumer with the provider of its current stream
+type peerCapabilities struct {
+	mu   sync.Mutex
+	caps Capabilities
+}
+
+func (p *peerCapabilities) get() Capabilities {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.caps
+}
+
+func (p *peerCapabilities) set(c Capabilities) {
+	p.mu.Lock()
+	p.caps = c
+	p.mu.Unlock()
+}
@@ -0,0 +1,41 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestNegotiateCapabilities(t *testing.T) {
+	newer := Capabilities{Version: ProtocolVersion + 1, Flags: []string{"acks", CapabilityGzip, CapabilitySampling}}
+	n := supportedCapabilities.negotiate(capabilitiesFromMetadata(newer.metadata()))
+	assert.Equal(t, Capabilities{Version: ProtocolVersion, Flags: []string{CapabilityGzip, CapabilitySampling}}, n)
+	assert.True(t, n.Has(CapabilityGzip))
+	assert.False(t, n.Has("acks"))
+
+	// a consumer predating the negotiation sends no capabilities
+	old := supportedCapabilities.negotiate(capabilitiesFromMetadata(metadata.Pairs("gorillaz-delta", "1")))
+	assert.Equal(t, 0, old.Version)
+	assert.Empty(t, old.Flags)
+}
+
+func TestStreamCapabilities(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("capabilities", "dummy.type")
+	assert.Nil(t, err)
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not mater", "capabilities")
+	assert.Nil(t, err)
+	waitUntil(t, 5*time.Second, "stream connected", func() bool {
+		return consumer.Capabilities().Version == ProtocolVersion
+	})
+	assert.Equal(t, supportedCapabilities.Flags, consumer.Capabilities().Flags)
+
+	provider.Submit(&stream.Event{Value: []byte("value")})
+	assertReceived(t, "capabilities", consumer.EvtChan(), &stream.Event{Value: []byte("value")})
+}
@@ -0,0 +1,208 @@
+package gorillaz
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"google.golang.org/grpc"
+)
+
+// TestChaosReconnection repeatedly kills and restarts the gRPC server of a provider, and checks the invariants of the
+// reconnection of its consumer: the events are delivered in order without duplicates, they are lost only while
+// the provider is down, the connection metrics follow the restarts, and no goroutine is leaked.
+// It runs GORILLAZ_SOAK_CYCLES restarts, 5 by default, and is skipped in short mode.
+func TestChaosReconnection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	cycles := 5
+	if s := os.Getenv("GORILLAZ_SOAK_CYCLES"); s != "" {
+		var err error
+		if cycles, err = strconv.Atoi(s); err != nil {
+			t.Fatalf("invalid GORILLAZ_SOAK_CYCLES %s", s)
+		}
+	}
+	const streamName = "chaos-soak"
+
+	g := New(WithServiceName("chaos"), WithStreamEndpointOptions(BackoffMaxDelay(200*time.Millisecond)))
+	<-g.Run()
+	defer g.Shutdown()
+	provider, err := g.NewStreamProvider(streamName, "dummy.type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newChaosServer(t, g.streamRegistry)
+	defer server.kill()
+	baseline := runtime.NumGoroutine()
+
+	// the provider submits numbered events, until stopped
+	var submitted int64
+	stopProducer := make(chan struct{})
+	producerDone := make(chan struct{})
+	go func() {
+		defer close(producerDone)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopProducer:
+				return
+			case <-ticker.C:
+				seq := atomic.AddInt64(&submitted, 1)
+				provider.Submit(&stream.Event{Key: []byte("chaos"), Value: []byte(strconv.FormatInt(seq, 10))})
+			}
+		}
+	}()
+
+	var connections int64
+	connected := make(chan struct{}, 1)
+	consumer, err := g.ConsumeStream([]string{server.addr}, streamName, func(c *ConsumerConfig) {
+		c.OnConnected = func(string) {
+			atomic.AddInt64(&connections, 1)
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the consumer checks the sequence of the events
+	var received, last, gaps, disorders int64
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		for evt := range consumer.EvtChan() {
+			seq, err := strconv.ParseInt(string(evt.Value), 10, 64)
+			if err != nil {
+				t.Errorf("unexpected event %s", evt.Value)
+				continue
+			}
+			l := atomic.LoadInt64(&last)
+			switch {
+			case seq <= l:
+				atomic.AddInt64(&disorders, 1)
+			case l > 0 && seq != l+1:
+				atomic.AddInt64(&gaps, 1)
+			}
+			atomic.StoreInt64(&last, seq)
+			atomic.AddInt64(&received, 1)
+		}
+	}()
+
+	waitConnected := func(cycle int) {
+		select {
+		case <-connected:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("consumer not reconnected after 10 sec, cycle %d", cycle)
+		}
+	}
+	waitConnected(0)
+	for i := 1; i <= cycles; i++ {
+		time.Sleep(time.Duration(100+rand.Intn(200)) * time.Millisecond)
+		server.kill()
+		time.Sleep(time.Duration(rand.Intn(300)) * time.Millisecond)
+		server.restart(t)
+		waitConnected(i)
+	}
+	connectedGoroutines := runtime.NumGoroutine()
+
+	close(stopProducer)
+	<-producerDone
+	total := atomic.LoadInt64(&submitted)
+	waitUntil(t, 5*time.Second, "the events submitted after the last restart must be received", func() bool {
+		return atomic.LoadInt64(&last) == total
+	})
+
+	if d := atomic.LoadInt64(&disorders); d > 0 {
+		t.Errorf("%d events duplicated or out of order", d)
+	}
+	if gp := atomic.LoadInt64(&gaps); gp > int64(cycles) {
+		t.Errorf("%d gaps in the events for %d restarts, the events must only be lost while the provider is down", gp, cycles)
+	}
+	if c := atomic.LoadInt64(&connections); c != int64(cycles+1) {
+		t.Errorf("%d connections for %d restarts", c, cycles)
+	}
+	labels := map[string]string{StreamNameLabel: streamName, StreamEndpointsLabel: server.addr}
+	assertCounterEquals(t, g, labels, StreamConsumerReceivedEvents, float64(atomic.LoadInt64(&received)))
+	assertCounterEquals(t, g, labels, StreamConsumerConnectionSuccess, float64(cycles+1))
+	assertCounterEquals(t, g, labels, StreamConsumerDisconnections, float64(cycles))
+
+	// the consumer stops without waiting for another event
+	consumer.Stop()
+	<-consumerDone
+	waitUntil(t, 5*time.Second, "the goroutines of the consumer must stop", func() bool {
+		return runtime.NumGoroutine() <= baseline
+	})
+	t.Logf("%d restarts, %d events submitted, %d received, %d gaps, %d goroutines while connected, %d before the consumer, %d after",
+		cycles, total, atomic.LoadInt64(&received), atomic.LoadInt64(&gaps), connectedGoroutines, baseline, runtime.NumGoroutine())
+}
+
+// chaosServer serves the streams of a registry on a fixed address, it can be killed and restarted
+type chaosServer struct {
+	registry stream.StreamServer
+	addr     string
+	mu       sync.Mutex
+	srv      *grpc.Server
+}
+
+func newChaosServer(t *testing.T, registry stream.StreamServer) *chaosServer {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &chaosServer{registry: registry, addr: lis.Addr().String()}
+	s.serve(lis)
+	return s
+}
+
+func (s *chaosServer) serve(lis net.Listener) {
+	srv := grpc.NewServer()
+	stream.RegisterStreamServer(srv, s.registry)
+	s.mu.Lock()
+	s.srv = srv
+	s.mu.Unlock()
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+}
+
+func (s *chaosServer) kill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.srv.Stop()
+}
+
+func (s *chaosServer) restart(t *testing.T) {
+	var lis net.Listener
+	var err error
+	for i := 0; i < 50; i++ {
+		if lis, err = net.Listen("tcp", s.addr); err == nil {
+			s.serve(lis)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("cannot listen again on %s: %v", s.addr, err)
+}
+
+func waitUntil(t *testing.T, timeout time.Duration, msg string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Error(msg)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
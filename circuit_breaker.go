@@ -0,0 +1,300 @@
+package gorillaz
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	CircuitBreakerState    = "circuit_breaker_state"
+	CircuitBreakerOpened   = "circuit_breaker_opened"
+	CircuitBreakerRejected = "circuit_breaker_rejected"
+)
+
+const CircuitBreakerTargetLabel = "target"
+
+// ErrCircuitOpen is returned when a call is rejected because the circuit breaker of its target is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitBreakerConfig struct {
+	FailureThreshold int           // FailureThreshold is the number of failures in Window opening the circuit
+	Window           time.Duration // Window is the rolling window in which the failures are counted
+	OpenDuration     time.Duration // OpenDuration is the time the circuit stays open before letting a trial call through
+}
+
+type CircuitBreakerConfigOpt func(*CircuitBreakerConfig)
+
+func defaultCircuitBreakerConfig() *CircuitBreakerConfig {
+	return &CircuitBreakerConfig{
+		FailureThreshold: 5,
+		Window:           10 * time.Second,
+		OpenDuration:     5 * time.Second,
+	}
+}
+
+func CircuitBreakerFailureThreshold(n int) CircuitBreakerConfigOpt {
+	return func(c *CircuitBreakerConfig) {
+		c.FailureThreshold = n
+	}
+}
+
+func CircuitBreakerWindow(d time.Duration) CircuitBreakerConfigOpt {
+	return func(c *CircuitBreakerConfig) {
+		c.Window = d
+	}
+}
+
+func CircuitBreakerOpenDuration(d time.Duration) CircuitBreakerConfigOpt {
+	return func(c *CircuitBreakerConfig) {
+		c.OpenDuration = d
+	}
+}
+
+// WithCircuitBreaker enables a circuit breaker on target.
+// Targets are the endpoints of stream consumers, as given to ConsumeStream or "sd://<service>" for discovered services,
+// and the subjects of Nats requests. The empty target configures the circuit breaker of the targets without a dedicated one.
+func WithCircuitBreaker(target string, opts ...CircuitBreakerConfigOpt) Option {
+	return Option{Opt: func(g *Gaz) error {
+		config := defaultCircuitBreakerConfig()
+		for _, opt := range opts {
+			opt(config)
+		}
+		g.circuitBreakersMu.Lock()
+		defer g.circuitBreakersMu.Unlock()
+		if g.circuitBreakerConfigs == nil {
+			g.circuitBreakerConfigs = make(map[string]*CircuitBreakerConfig)
+		}
+		g.circuitBreakerConfigs[target] = config
+		return nil
+	}}
+}
+
+// CircuitBreaker stops the calls to a failing target for a while, then lets a single trial call through (half-open state)
+// to decide whether the circuit can be closed again.
+// A nil CircuitBreaker allows every call.
+type CircuitBreaker struct {
+	sync.Mutex
+	target   string
+	config   *CircuitBreakerConfig
+	state    circuitState
+	failures []time.Time
+	openedAt time.Time
+	trial    bool
+	metrics  *circuitBreakerMetrics
+	reported *dependencyMetrics
+	clock    Clock
+}
+
+func newCircuitBreaker(target string, config *CircuitBreakerConfig, metrics *circuitBreakerMetrics, clock Clock) *CircuitBreaker {
+	return &CircuitBreaker{target: target, config: config, metrics: metrics, clock: clock}
+}
+
+// circuitBreaker returns the circuit breaker of target, nil if none is configured
+func (g *Gaz) circuitBreaker(target string) *CircuitBreaker {
+	g.circuitBreakersMu.Lock()
+	defer g.circuitBreakersMu.Unlock()
+	if cb, ok := g.circuitBreakers[target]; ok {
+		return cb
+	}
+	config, ok := g.circuitBreakerConfigs[target]
+	if !ok {
+		if config, ok = g.circuitBreakerConfigs[""]; !ok {
+			return nil
+		}
+	}
+	if g.circuitBreakers == nil {
+		g.circuitBreakers = make(map[string]*CircuitBreaker)
+	}
+	cb := newCircuitBreaker(target, config, circuitBreakerMonitoring(g, target), g.Clock())
+	g.circuitBreakers[target] = cb
+	return cb
+}
+
+// Allow returns true if a call can be made.
+// In half-open state, only one call is allowed until its outcome is reported with Success or Failure
+func (cb *CircuitBreaker) Allow() bool {
+	if cb == nil {
+		return true
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.state == circuitOpen && cb.clock.Now().Sub(cb.openedAt) >= cb.config.OpenDuration {
+		cb.setState(circuitHalfOpen)
+	}
+	switch cb.state {
+	case circuitOpen:
+		cb.rejected()
+		return false
+	case circuitHalfOpen:
+		if cb.trial {
+			cb.rejected()
+			return false
+		}
+		cb.trial = true
+	}
+	return true
+}
+
+// RetryAfter returns how long the circuit stays open
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	if cb == nil {
+		return 0
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.state != circuitOpen {
+		return 0
+	}
+	if d := cb.config.OpenDuration - cb.clock.Now().Sub(cb.openedAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Success reports a successful call, closing the circuit if it was half-open
+func (cb *CircuitBreaker) Success() {
+	if cb == nil {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	cb.trial = false
+	if cb.state == circuitHalfOpen {
+		cb.failures = cb.failures[:0]
+		cb.setState(circuitClosed)
+	}
+}
+
+// Failure reports a failed call, opening the circuit if the trial call failed or if too many calls failed in the window
+func (cb *CircuitBreaker) Failure() {
+	if cb == nil {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	cb.trial = false
+	now := cb.clock.Now()
+	switch cb.state {
+	case circuitHalfOpen:
+		cb.open(now)
+	case circuitClosed:
+		valid := cb.failures[:0]
+		for _, f := range cb.failures {
+			if now.Sub(f) < cb.config.Window {
+				valid = append(valid, f)
+			}
+		}
+		cb.failures = append(valid, now)
+		if len(cb.failures) >= cb.config.FailureThreshold {
+			cb.open(now)
+		}
+	}
+}
+
+func (cb *CircuitBreaker) open(now time.Time) {
+	cb.openedAt = now
+	cb.failures = cb.failures[:0]
+	cb.setState(circuitOpen)
+	if cb.metrics != nil {
+		cb.metrics.openedCounter.Inc()
+	}
+}
+
+func (cb *CircuitBreaker) setState(s circuitState) {
+	if cb.state != s {
+		Log.Info("circuit breaker state changed", zap.String("target", cb.target), zap.String("state", s.String()))
+	}
+	cb.state = s
+	if cb.metrics != nil {
+		cb.metrics.stateGauge.Set(float64(s))
+	}
+	if cb.reported != nil {
+		cb.reported.circuitStateGauge.Set(float64(s))
+	}
+}
+
+// reportTo reports the state of the circuit breaker in the metrics of its dependency
+func (cb *CircuitBreaker) reportTo(m *dependencyMetrics) {
+	if cb == nil {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	cb.reported = m
+	m.circuitStateGauge.Set(float64(cb.state))
+}
+
+func (cb *CircuitBreaker) rejected() {
+	if cb.metrics != nil {
+		cb.metrics.rejectedCounter.Inc()
+	}
+}
+
+type circuitBreakerMetrics struct {
+	stateGauge      prometheus.Gauge
+	openedCounter   prometheus.Counter
+	rejectedCounter prometheus.Counter
+}
+
+var circuitBreakerMetricsMu sync.Mutex
+var circuitBreakerMonitorings = make(map[string]*circuitBreakerMetrics)
+
+func circuitBreakerMonitoring(g *Gaz, target string) *circuitBreakerMetrics {
+	circuitBreakerMetricsMu.Lock()
+	defer circuitBreakerMetricsMu.Unlock()
+
+	if m, ok := circuitBreakerMonitorings[target]; ok {
+		return m
+	}
+	m := &circuitBreakerMetrics{
+		stateGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: CircuitBreakerState,
+			Help: "State of the circuit breaker: 0 closed, 1 open, 2 half-open",
+			ConstLabels: prometheus.Labels{
+				CircuitBreakerTargetLabel: target,
+			},
+		}),
+		openedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: CircuitBreakerOpened,
+			Help: "The total number of times the circuit breaker opened",
+			ConstLabels: prometheus.Labels{
+				CircuitBreakerTargetLabel: target,
+			},
+		}),
+		rejectedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: CircuitBreakerRejected,
+			Help: "The total number of calls rejected by the circuit breaker",
+			ConstLabels: prometheus.Labels{
+				CircuitBreakerTargetLabel: target,
+			},
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.stateGauge)
+	g.prometheusRegistry.MustRegister(m.openedCounter)
+	g.prometheusRegistry.MustRegister(m.rejectedCounter)
+	circuitBreakerMonitorings[target] = m
+	return m
+}
@@ -0,0 +1,74 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := NewManualClock(time.Date(2020, time.November, 13, 10, 0, 0, 0, time.UTC))
+	cb := newCircuitBreaker("target", &CircuitBreakerConfig{
+		FailureThreshold: 2,
+		Window:           time.Minute,
+		OpenDuration:     50 * time.Millisecond,
+	}, nil, clock)
+
+	assert.True(t, cb.Allow())
+	cb.Failure()
+	assert.True(t, cb.Allow(), "below the threshold the circuit stays closed")
+	cb.Failure()
+	assert.False(t, cb.Allow(), "the circuit must open once the threshold is reached")
+	assert.Equal(t, 50*time.Millisecond, cb.RetryAfter())
+
+	clock.Advance(60 * time.Millisecond)
+	assert.True(t, cb.Allow(), "a trial call is allowed once half-open")
+	assert.False(t, cb.Allow(), "only one trial call is allowed")
+	cb.Failure()
+	assert.False(t, cb.Allow(), "a failed trial opens the circuit again")
+
+	clock.Advance(60 * time.Millisecond)
+	assert.True(t, cb.Allow())
+	cb.Success()
+	assert.True(t, cb.Allow())
+	assert.True(t, cb.Allow(), "a successful trial closes the circuit")
+}
+
+func TestNilCircuitBreakerAllowsCalls(t *testing.T) {
+	var cb *CircuitBreaker
+	cb.Failure()
+	assert.True(t, cb.Allow())
+	assert.Equal(t, time.Duration(0), cb.RetryAfter())
+}
+
+func TestWaitForCircuitStopped(t *testing.T) {
+	clock := NewManualClock(time.Date(2020, time.November, 13, 10, 0, 0, 0, time.UTC))
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry(), clock: clock}
+	g.circuitBreakerConfigs = map[string]*CircuitBreakerConfig{
+		"circuit-wait": {FailureThreshold: 1, Window: time.Minute, OpenDuration: time.Minute},
+	}
+	se := &streamEndpoint{g: g, target: "circuit-wait", breaker: g.dependency(ProtocolGrpc, "circuit-wait")}
+	se.breaker.Failure()
+
+	stopped := make(chan struct{})
+	waited := make(chan struct{})
+	go func() {
+		se.waitForCircuit("stream", stopped)
+		close(waited)
+	}()
+	waitForWaiters(t, clock)
+	select {
+	case <-waited:
+		t.Fatal("the circuit is still open")
+	default:
+	}
+
+	close(stopped)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("stopping the consumer must interrupt the wait for the circuit")
+	}
+}
@@ -0,0 +1,181 @@
+package gorillaz
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the time dependent logic: delay metrics, caches expiry, schedules and deadlines.
+// It is the system clock by default, a ManualClock makes this logic deterministic in tests,
+// and a clock following the event times can replay a recorded stream in virtual time.
+type Clock interface {
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a ticker sending the time on its channel at every period
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is a time.Ticker of a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock of the system
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTicker) Stop() {
+	t.t.Stop()
+}
+
+// WithClock sets the clock of the time dependent logic of gorillaz, the components configured with their own clock use it instead
+func WithClock(c Clock) Option {
+	return Option{func(g *Gaz) error {
+		g.clock = c
+		return nil
+	}}
+}
+
+// Clock returns the clock of gorillaz, SystemClock if none was set
+func (g *Gaz) Clock() Clock {
+	return clockOrSystem(g.clock)
+}
+
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// ManualClock is a Clock whose time only changes when it is set or advanced
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*manualWaiter
+}
+
+// manualWaiter is a pending After, or a ticker if period is positive
+type manualWaiter struct {
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+// NewManualClock returns a ManualClock set at the given time
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	return c.wait(d, 0).ch
+}
+
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return &manualTicker{clock: c, w: c.wait(d, d)}
+}
+
+func (c *ManualClock) wait(d, period time.Duration) *manualWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &manualWaiter{at: c.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	if d <= 0 && period == 0 {
+		w.ch <- c.now
+		return w
+	}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward by d, firing the timers and tickers due in between
+func (c *ManualClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to the given time, firing the timers and tickers due until then.
+// Like time.Ticker, a ticker whose channel is full drops the ticks.
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Before(c.now) {
+		c.now = now
+		return
+	}
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool {
+			return c.waiters[i].at.Before(c.waiters[j].at)
+		})
+		if len(c.waiters) == 0 || c.waiters[0].at.After(now) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.at
+		select {
+		case w.ch <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	c.now = now
+}
+
+func (c *ManualClock) stop(w *manualWaiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, cw := range c.waiters {
+		if cw == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type manualTicker struct {
+	clock *ManualClock
+	w     *manualWaiter
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t *manualTicker) Stop() {
+	t.clock.stop(t.w)
+}
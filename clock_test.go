@@ -0,0 +1,92 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForWaiters waits until something waits on the clock, so that advancing it fires the timer
+func waitForWaiters(t *testing.T, c *ManualClock) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		n := len(c.waiters)
+		c.mu.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("nothing waits on the clock")
+}
+
+func TestManualClockAfter(t *testing.T) {
+	start := time.Date(2020, time.November, 13, 10, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+	after := c.After(time.Minute)
+
+	c.Advance(30 * time.Second)
+	assert.Len(t, after, 0)
+	c.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), <-after)
+	assert.Equal(t, start.Add(90*time.Second), c.Now())
+
+	assert.Len(t, c.After(0), 1)
+}
+
+func TestManualClockTicker(t *testing.T) {
+	start := time.Date(2020, time.November, 13, 10, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+	ticker := c.NewTicker(time.Second)
+
+	c.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Second), <-ticker.C())
+	// the ticks are dropped while the channel is full
+	c.Advance(3 * time.Second)
+	assert.Equal(t, start.Add(2*time.Second), <-ticker.C())
+	assert.Len(t, ticker.C(), 0)
+
+	ticker.Stop()
+	c.Advance(time.Second)
+	assert.Len(t, ticker.C(), 0)
+}
+
+func TestScheduleWithManualClock(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	c := NewManualClock(time.Date(2020, time.November, 13, 10, 7, 30, 0, time.UTC))
+	runs := make(chan struct{}, 1)
+	stop, err := g.Schedule("clock-test", "* * * * *", func(ctx context.Context) error {
+		runs <- struct{}{}
+		return nil
+	}, ScheduleLocal(), ScheduleClock(c))
+	assert.Nil(t, err)
+	defer stop()
+
+	waitForWaiters(t, c)
+	c.Advance(29 * time.Second)
+	assert.Len(t, runs, 0)
+	c.Advance(time.Second)
+	select {
+	case <-runs:
+	case <-time.After(time.Second):
+		t.Fatal("the job did not run")
+	}
+}
+
+func TestMemoryProcessedStoreWithManualClock(t *testing.T) {
+	c := NewManualClock(time.Now())
+	store := NewMemoryProcessedStore(time.Hour)
+	store.SetClock(c)
+	ctx := context.Background()
+	assert.Nil(t, store.MarkProcessed(ctx, "1"))
+	c.Advance(59 * time.Minute)
+	processed, _ := store.Processed(ctx, "1")
+	assert.True(t, processed)
+	c.Advance(time.Minute)
+	processed, _ = store.Processed(ctx, "1")
+	assert.False(t, processed)
+}
@@ -0,0 +1,401 @@
+// gaz is a command line tool to inspect and feed the gorillaz streams, the Nats subjects and the Jetstream resources
+//
+//	gaz tail -endpoints localhost:9000 -stream flights
+//	gaz tail -nats nats://localhost:4222 -subject flights
+//	gaz publish -nats nats://localhost:4222 -subject flights -key AF123 -value '{"alt":35000}' -count 10
+//	gaz publish -grpc.port 9000 -stream flights -key AF123 -value '{"alt":35000}' -interval 1s
+//	gaz state -endpoints localhost:9000 -stream aircrafts
+//	gaz lag -http localhost:8080
+//	gaz jetstream -nats nats://localhost:4222 -stream FLIGHTS -subjects flights.>
+//	gaz kv -nats nats://localhost:4222 -bucket positions -max-age 1h
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	gaz "github.com/skysoft-atm/gorillaz"
+	"github.com/skysoft-atm/gorillaz/mux"
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"tail":      {"print the events of a gRPC stream or a Nats subject", tail},
+	"publish":   {"publish test events to a Nats subject, or to a gRPC stream served by gaz", publish},
+	"state":     {"print the state of a GetAndWatch stream", state},
+	"lag":       {"print the consumers of the streams of a service and their backlog", lag},
+	"jetstream": {"create or update a Jetstream stream", provisionJetstream},
+	"kv":        {"create or update a Jetstream key value bucket", provisionKeyValue},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	c, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	args := os.Args[2:]
+	// the flags of the command are not gorillaz flags
+	os.Args = os.Args[:1]
+	if err := c.run(args); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gaz <command> [flags]")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "run gaz <command> -h for the flags of the command")
+}
+
+// gazFlags are the flags configuring gorillaz, common to the commands
+type gazFlags struct {
+	env      string
+	tenant   string
+	natsAddr string
+	grpcPort int
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *gazFlags) {
+	fs := flag.NewFlagSet("gaz "+name, flag.ExitOnError)
+	gf := &gazFlags{}
+	fs.StringVar(&gf.env, "env", "dev", "environment")
+	fs.StringVar(&gf.tenant, "tenant", "", "tenant scoping the subjects and the streams")
+	fs.StringVar(&gf.natsAddr, "nats", "", "address of the Nats server")
+	fs.IntVar(&gf.grpcPort, "grpc.port", 0, "port of the gRPC server")
+	return fs, gf
+}
+
+// start starts gorillaz, connected to Nats if an address is given
+func (gf *gazFlags) start() *gaz.Gaz {
+	g := gaz.New(gaz.WithServiceName("gaz"), gaz.InitOption{Init: func(g *gaz.Gaz) error {
+		g.Viper.Set("env", gf.env)
+		g.Viper.Set("tenant", gf.tenant)
+		g.Viper.Set("nats.addr", gf.natsAddr)
+		g.Viper.Set("grpc.port", gf.grpcPort)
+		g.Viper.Set("log.level", "warn")
+		g.Viper.Set("healthcheck.enabled", false)
+		g.Viper.Set("prometheus.enabled", false)
+		return nil
+	}})
+	<-g.Run()
+	return g
+}
+
+// interrupted returns a context done on SIGINT or SIGTERM
+func interrupted() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	return ctx
+}
+
+func tail(args []string) error {
+	fs, gf := newFlagSet("tail")
+	endpoints := fs.String("endpoints", "", "comma separated endpoints of the provider, sd://<service> to discover it")
+	streamName := fs.String("stream", "", "name of the stream")
+	subject := fs.String("subject", "", "Nats subject, instead of a stream")
+	jsonOutput := fs.Bool("json", false, "print the events as JSON lines")
+	_ = fs.Parse(args)
+
+	var show func(e *stream.Event)
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		show = func(e *stream.Event) {
+			_ = enc.Encode(&gaz.ArchivedEvent{Key: e.Key, Value: e.Value, Headers: e.Headers, EventTime: stream.EventTimestamp(e)})
+		}
+	} else {
+		show = printEvent
+	}
+
+	ctx := interrupted()
+	switch {
+	case *subject != "":
+		if gf.natsAddr == "" {
+			return fmt.Errorf("-nats is required to tail a subject")
+		}
+		g := gf.start()
+		sub, err := g.SubscribeNatsSubject(*subject, func(_ string, e *stream.Event) (*stream.Event, error) {
+			show(e)
+			return nil, nil
+		})
+		if err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return sub.Unsubscribe()
+	case *endpoints != "" && *streamName != "":
+		g := gf.start()
+		c, err := g.ConsumeStream(strings.Split(*endpoints, ","), *streamName)
+		if err != nil {
+			return err
+		}
+		defer c.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case e, ok := <-c.EvtChan():
+				if !ok {
+					return c.Err()
+				}
+				show(e)
+			}
+		}
+	default:
+		return fmt.Errorf("either -subject, or -endpoints and -stream are required")
+	}
+}
+
+func printEvent(e *stream.Event) {
+	var lag string
+	if ts := stream.StreamTimestamp(e); ts > 0 {
+		lag = " lag=" + time.Duration(time.Now().UnixNano()-ts).Round(time.Microsecond).String()
+	}
+	var headers []string
+	for k, v := range e.Headers {
+		headers = append(headers, k+"="+v)
+	}
+	sort.Strings(headers)
+	fmt.Printf("%s key=%q value=%q%s %s\n", time.Now().Format(time.RFC3339Nano), e.Key, e.Value, lag, strings.Join(headers, " "))
+}
+
+func publish(args []string) error {
+	fs, gf := newFlagSet("publish")
+	subject := fs.String("subject", "", "Nats subject")
+	streamName := fs.String("stream", "", "name of the gRPC stream served by gaz, instead of a subject")
+	key := fs.String("key", "", "key of the events, %d is replaced by the number of the event")
+	value := fs.String("value", "", "value of the events, %d is replaced by the number of the event")
+	count := fs.Int("count", 1, "number of events, 0 publishes until interrupted")
+	interval := fs.Duration("interval", 0, "interval between the events")
+	_ = fs.Parse(args)
+
+	var submit func(e *stream.Event) error
+	g := gf.start()
+	switch {
+	case *subject != "":
+		if gf.natsAddr == "" {
+			return fmt.Errorf("-nats is required to publish to a subject")
+		}
+		submit = func(e *stream.Event) error {
+			return g.NatsPublish(*subject, e)
+		}
+	case *streamName != "":
+		p, err := g.NewStreamProvider(*streamName, "bytes")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "serving stream %s on port %d\n", *streamName, g.GrpcPort())
+		submit = func(e *stream.Event) error {
+			p.Submit(e)
+			return nil
+		}
+	default:
+		return fmt.Errorf("either -subject or -stream is required")
+	}
+
+	ctx := interrupted()
+	for i := 1; *count == 0 || i <= *count; i++ {
+		e := &stream.Event{Key: []byte(numbered(*key, i)), Value: []byte(numbered(*value, i))}
+		e.SetEventTime(time.Now())
+		if err := submit(e); err != nil {
+			return err
+		}
+		if *interval > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(*interval):
+			}
+		} else if ctx.Err() != nil {
+			return nil
+		}
+	}
+	if *streamName != "" {
+		// let the consumers connected to the stream receive the events
+		<-ctx.Done()
+	}
+	return nil
+}
+
+func numbered(s string, i int) string {
+	return strings.Replace(s, "%d", strconv.Itoa(i), -1)
+}
+
+func state(args []string) error {
+	fs, gf := newFlagSet("state")
+	endpoints := fs.String("endpoints", "", "comma separated endpoints of the provider, sd://<service> to discover it")
+	streamName := fs.String("stream", "", "name of the GetAndWatch stream")
+	watch := fs.Bool("watch", false, "keep printing the updates after the initial state")
+	quiet := fs.Duration("quiet", time.Second, "without -watch, the initial state is complete once no event is received for this duration")
+	timeout := fs.Duration("timeout", 10*time.Second, "maximum wait for the first event, the state may be empty or the provider unreachable")
+	_ = fs.Parse(args)
+	if *endpoints == "" || *streamName == "" {
+		return fmt.Errorf("-endpoints and -stream are required")
+	}
+
+	g := gf.start()
+	c, err := g.ConsumeGetAndWatchStream(strings.Split(*endpoints, ","), *streamName)
+	if err != nil {
+		return err
+	}
+	defer c.Stop()
+
+	ctx := interrupted()
+	// the provider does not tell when its initial state is sent, it is complete once it stops sending events
+	idle := time.NewTimer(*quiet)
+	defer idle.Stop()
+	start := time.Now()
+	var received bool
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-idle.C:
+			if *watch {
+				continue
+			}
+			if !received {
+				if time.Since(start) > *timeout {
+					return fmt.Errorf("no state received after %s", *timeout)
+				}
+				idle.Reset(*quiet)
+				continue
+			}
+			return nil
+		case e, ok := <-c.EvtChan():
+			if !ok {
+				return c.Err()
+			}
+			received = true
+			fmt.Printf("%-13s key=%q value=%q\n", e.EventType, e.Key, e.Value)
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(*quiet)
+		}
+	}
+}
+
+func lag(args []string) error {
+	fs, _ := newFlagSet("lag")
+	addr := fs.String("http", "", "HTTP address of the service, host:port")
+	_ = fs.Parse(args)
+	if *addr == "" {
+		return fmt.Errorf("-http is required")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get("http://" + *addr + "/streams/consumers")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var consumers map[string][]mux.ConsumerStats
+	if err := json.NewDecoder(resp.Body).Decode(&consumers); err != nil {
+		return err
+	}
+	var streams []string
+	for s := range consumers {
+		streams = append(streams, s)
+	}
+	sort.Strings(streams)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STREAM\tCONSUMER\tDELIVERED\tDROPPED\tBUFFERED\tPENDING")
+	for _, s := range streams {
+		for _, c := range consumers[s] {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d/%d\t%d\n", s, c.Name, c.Delivered, c.Dropped, c.BufferLen, c.BufferCap, c.Pending)
+		}
+	}
+	return w.Flush()
+}
+
+func provisionJetstream(args []string) error {
+	fs, gf := newFlagSet("jetstream")
+	streamName := fs.String("stream", "", "name of the Jetstream stream, prefixed by the env if missing")
+	subjects := fs.String("subjects", "", "comma separated subjects captured by the stream")
+	opts := jetstreamFlags(fs)
+	_ = fs.Parse(args)
+	if gf.natsAddr == "" || *streamName == "" || *subjects == "" {
+		return fmt.Errorf("-nats, -stream and -subjects are required")
+	}
+	g := gf.start()
+	ctx, cancel := context.WithTimeout(interrupted(), 30*time.Second)
+	defer cancel()
+	if err := g.ProvisionJetstream(ctx, *streamName, strings.Split(*subjects, ","), opts()...); err != nil {
+		return err
+	}
+	fmt.Printf("stream %s provisioned\n", g.AddStreamEnvIfMissing(*streamName))
+	return nil
+}
+
+func provisionKeyValue(args []string) error {
+	fs, gf := newFlagSet("kv")
+	bucket := fs.String("bucket", "", "name of the key value bucket, prefixed by the env if missing")
+	opts := jetstreamFlags(fs)
+	_ = fs.Parse(args)
+	if gf.natsAddr == "" || *bucket == "" {
+		return fmt.Errorf("-nats and -bucket are required")
+	}
+	g := gf.start()
+	ctx, cancel := context.WithTimeout(interrupted(), 30*time.Second)
+	defer cancel()
+	if err := g.ProvisionKeyValue(ctx, *bucket, opts()...); err != nil {
+		return err
+	}
+	fmt.Printf("bucket %s provisioned\n", *bucket)
+	return nil
+}
+
+// jetstreamFlags declares the flags of the Jetstream configuration, the returned function gives the options once parsed
+func jetstreamFlags(fs *flag.FlagSet) func() []gaz.JetstreamConfigOpt {
+	memory := fs.Bool("memory", false, "store the messages in memory instead of files")
+	replicas := fs.Int("replicas", 1, "number of replicas in a cluster")
+	maxAge := fs.Duration("max-age", 0, "maximum age of the messages, 0 means no limit")
+	return func() []gaz.JetstreamConfigOpt {
+		opts := []gaz.JetstreamConfigOpt{gaz.JetstreamReplicas(*replicas), gaz.JetstreamMaxAge(*maxAge)}
+		if *memory {
+			opts = append(opts, gaz.JetstreamMemoryStorage())
+		}
+		return opts
+	}
+}
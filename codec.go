@@ -0,0 +1,102 @@
+package gorillaz
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/encoding"
+)
+
+const protoCodecName = "proto"
+
+// WithCodecs registers gRPC codecs, so that the gRPC server and the connections of gorillaz can carry domain services
+// with other encodings next to the Stream service, which always uses the StreamEncoding codec.
+// A client selects the codec of a call with grpc.CallContentSubtype(codec.Name()), the calls without content subtype use proto.
+// A codec named "proto" replaces the default protobuf codec, for instance with a gogoproto codec, it is also used by the
+// Stream service for the messages other than raw bytes and protobuf v2 messages.
+// Like encoding.RegisterCodec, the codecs must be registered at initialization, before any call.
+func WithCodecs(codecs ...encoding.Codec) InitOption {
+	return InitOption{func(g *Gaz) error {
+		for _, c := range codecs {
+			if c == nil || c.Name() == "" {
+				return errors.New("cannot register a codec without name")
+			}
+			if c.Name() == StreamEncoding {
+				return errors.New("cannot replace the codec of the gorillaz streams " + StreamEncoding)
+			}
+			Log.Debug("registering gRPC codec", zap.String("codec", c.Name()))
+			encoding.RegisterCodec(c)
+		}
+		return nil
+	}}
+}
+
+// WithStreamTypeCodec encodes the messages of the types of samples with codec when they cross a call with the StreamEncoding
+// content subtype, for instance flatbuffers or msgpack messages of a service sharing the connection of the streams.
+// The samples are values of the types sent and received, such as &MyMessage{}, the pointer types being distinct from the value types.
+// The messages of the other types are encoded as raw bytes, protobuf v2 messages or with the "proto" codec, see WithCodecs.
+// Like encoding.RegisterCodec, the codecs must be registered at initialization, before any call.
+func WithStreamTypeCodec(codec encoding.Codec, samples ...interface{}) InitOption {
+	return InitOption{func(g *Gaz) error {
+		if codec == nil {
+			return errors.New("cannot register a nil codec")
+		}
+		for _, s := range samples {
+			if s == nil {
+				return errors.New("cannot register a codec for a nil sample")
+			}
+			Log.Debug("registering stream codec", zap.String("codec", codec.Name()), zap.String("type", reflect.TypeOf(s).String()))
+			streamTypeCodecs.register(reflect.TypeOf(s), codec)
+		}
+		return nil
+	}}
+}
+
+// typeCodecs maps the Go types of messages to their codec
+type typeCodecs struct {
+	mu     sync.RWMutex
+	codecs map[reflect.Type]encoding.Codec
+}
+
+var streamTypeCodecs = &typeCodecs{codecs: make(map[reflect.Type]encoding.Codec)}
+
+func (t *typeCodecs) register(typ reflect.Type, codec encoding.Codec) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.codecs[typ] = codec
+}
+
+// get returns the codec of the type of v, nil if there is none
+func (t *typeCodecs) get(v interface{}) encoding.Codec {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if len(t.codecs) == 0 {
+		return nil
+	}
+	return t.codecs[reflect.TypeOf(v)]
+}
+
+// JSONCodecName is the content subtype of the gRPC calls encoded in JSON, such as the control calls of gorillaz
+const JSONCodecName = "gorillaz-json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes the messages of the gRPC services without protobuf definition
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return JSONCodecName
+}
@@ -0,0 +1,68 @@
+package gorillaz
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+)
+
+type jsonTestCodec struct{}
+
+func (jsonTestCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonTestCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonTestCodec) Name() string                               { return "gorillaz-test-json" }
+
+func TestWithCodecs(t *testing.T) {
+	g := &Gaz{}
+	assert.Nil(t, WithCodecs(jsonTestCodec{}).Init(g))
+	assert.Equal(t, jsonTestCodec{}, encoding.GetCodec("gorillaz-test-json"))
+
+	assert.NotNil(t, WithCodecs(&binaryCodec{}).Init(g), "the stream codec cannot be replaced")
+	_, ok := encoding.GetCodec(StreamEncoding).(*binaryCodec)
+	assert.True(t, ok)
+}
+
+func TestStreamCodec(t *testing.T) {
+	c := encoding.GetCodec(StreamEncoding)
+
+	raw := []byte("already encoded")
+	b, err := c.Marshal(raw)
+	assert.Nil(t, err)
+	assert.Equal(t, raw, b)
+
+	req := &stream.StreamRequest{Name: "stream", RequesterName: "service"}
+	b, err = c.Marshal(req)
+	assert.Nil(t, err)
+	var decoded stream.StreamRequest
+	assert.Nil(t, c.Unmarshal(b, &decoded))
+	assert.True(t, proto.Equal(req, &decoded))
+}
+
+type streamTypeCodecMessage struct {
+	Text string
+}
+
+func TestWithStreamTypeCodec(t *testing.T) {
+	g := &Gaz{}
+	assert.Nil(t, WithStreamTypeCodec(jsonTestCodec{}, &streamTypeCodecMessage{}).Init(g))
+	assert.NotNil(t, WithStreamTypeCodec(jsonTestCodec{}, nil).Init(g))
+
+	c := encoding.GetCodec(StreamEncoding)
+	b, err := c.Marshal(&streamTypeCodecMessage{Text: "hello"})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"Text":"hello"}`, string(b))
+	var decoded streamTypeCodecMessage
+	assert.Nil(t, c.Unmarshal(b, &decoded))
+	assert.Equal(t, "hello", decoded.Text)
+
+	// the other messages keep their encoding
+	req := &stream.StreamRequest{Name: "stream"}
+	b, err = c.Marshal(req)
+	assert.Nil(t, err)
+	expected, _ := proto.Marshal(req)
+	assert.Equal(t, expected, b)
+}
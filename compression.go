@@ -0,0 +1,239 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	StreamConsumerCompressedBytes   = "stream_consumer_compressed_bytes"
+	StreamConsumerDecompressedBytes = "stream_consumer_decompressed_bytes"
+)
+
+// the compressions accepted by a consumer and the one of its stream request are sent in the metadata of the request,
+// the provider answers with the compression it prefers in the header of the stream, or in the trailer if it rejects the stream
+const (
+	acceptCompressionMetadataKey = "gorillaz-accept-compression"
+	compressionMetadataKey       = "gorillaz-compression"
+)
+
+// NoCompression is the name of the uncompressed encoding, a provider accepting only it sends the events uncompressed
+const NoCompression = encoding.Identity
+
+// WithCompressors registers other gRPC compressors, so that the streams can be compressed with them, see WithCompression.
+// gzip, Zstd and Snappy are always registered. Both the consumers and the providers must register them.
+// Like encoding.RegisterCompressor, the compressors must be registered at initialization, before any call.
+func WithCompressors(compressors ...encoding.Compressor) InitOption {
+	return InitOption{func(g *Gaz) error {
+		for _, c := range compressors {
+			if c == nil || c.Name() == "" || c.Name() == NoCompression {
+				return errors.New("cannot register a compressor without name")
+			}
+			Log.Debug("registering gRPC compressor", zap.String("compressor", c.Name()))
+			encoding.RegisterCompressor(c)
+		}
+		return nil
+	}}
+}
+
+// WithCompression compresses the stream with the first of the compressions accepted by the provider, in order of preference.
+// The consumer advertises them to the provider, which answers with the one it prefers: it is used from the next connection on.
+// The compressions must be registered, see WithCompressors, the others are ignored.
+func WithCompression(names ...string) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.Compression = names
+	}
+}
+
+// ProviderCompression restricts the compressions of the streams to names, in order of preference, NoCompression to send
+// the events uncompressed. The consumers requesting another compression are rejected and told to use the preferred one
+// accepted by both, they reconnect with it after their backoff.
+func ProviderCompression(names ...string) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.Compression = names
+	}
+}
+
+// GetAndWatchCompression restricts the compressions of the streams to names, in order of preference, see ProviderCompression
+func GetAndWatchCompression(names ...string) GetAndWatchConfigOpt {
+	return func(p *GetAndWatchConfig) {
+		p.Compression = names
+	}
+}
+
+// registeredCompressions returns the names of the compressions registered, in order
+func registeredCompressions(names []string) []string {
+	var res []string
+	for _, n := range names {
+		if n == NoCompression || encoding.GetCompressor(n) != nil {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// negotiateCompression returns the compression of a stream: the first of the provider compressions accepted by the consumer,
+// the first of the consumer compressions registered if the provider accepts any, NoCompression if there is none
+func negotiateCompression(provider, accepted []string) string {
+	if len(provider) == 0 {
+		if r := registeredCompressions(accepted); len(r) > 0 {
+			return r[0]
+		}
+		return NoCompression
+	}
+	for _, p := range registeredCompressions(provider) {
+		for _, a := range accepted {
+			if p == a {
+				return p
+			}
+		}
+	}
+	return NoCompression
+}
+
+// compressionAllowed returns true if the provider accepts to send the events with the compression
+func compressionAllowed(provider []string, compression string) bool {
+	if len(provider) == 0 {
+		return true
+	}
+	for _, p := range provider {
+		if p == compression {
+			return true
+		}
+	}
+	return false
+}
+
+// requestedCompression returns the compressions accepted by the consumer and the one of its request, in the metadata of the request
+func requestedCompression(md metadata.MD) (accepted []string, used string) {
+	used = NoCompression
+	if v := md.Get(compressionMetadataKey); len(v) > 0 {
+		used = v[0]
+	}
+	return md.Get(acceptCompressionMetadataKey), used
+}
+
+// compressionNegotiation holds the compression of the stream requests of a consumer
+type compressionNegotiation struct {
+	mu       sync.Mutex
+	accepted []string
+	current  string
+}
+
+func newCompressionNegotiation(config *ConsumerConfig) *compressionNegotiation {
+	names := config.Compression
+	if len(names) == 0 && config.UseGzip {
+		names = []string{gzip.Name}
+	}
+	accepted := registeredCompressions(names)
+	n := &compressionNegotiation{accepted: accepted, current: NoCompression}
+	if len(accepted) > 0 {
+		n.current = accepted[0]
+	}
+	return n
+}
+
+// callOptions returns the call options of the next stream request along with its metadata, and its context tagged with its compression
+func (n *compressionNegotiation) callOptions(ctx context.Context) (context.Context, []grpc.CallOption, metadata.MD) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.accepted) == 0 {
+		return ctx, nil, nil
+	}
+	md := metadata.Pairs(compressionMetadataKey, n.current)
+	md.Append(acceptCompressionMetadataKey, n.accepted...)
+	ctx = withCompression(ctx, n.current)
+	if n.current == NoCompression {
+		return ctx, nil, md
+	}
+	return ctx, []grpc.CallOption{grpc.UseCompressor(n.current)}, md
+}
+
+// update uses the compression preferred by the provider in its header or trailer, if any, from the next stream request on
+func (n *compressionNegotiation) update(md metadata.MD) {
+	v := md.Get(compressionMetadataKey)
+	if len(v) == 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.accepted) > 0 && (v[0] == NoCompression || encoding.GetCompressor(v[0]) != nil) {
+		n.current = v[0]
+	}
+}
+
+// checkCompression negotiates the compression of a stream request, the request is rejected if its compression is not allowed
+func checkCompression(p provider, streamName string, md metadata.MD, strm grpc.ServerStream) (metadata.MD, error) {
+	accepted, used := requestedCompression(md)
+	if len(accepted) == 0 {
+		// a consumer predating the negotiation
+		return nil, nil
+	}
+	preferred := negotiateCompression(p.compressions(), accepted)
+	res := metadata.Pairs(compressionMetadataKey, preferred)
+	if !compressionAllowed(p.compressions(), used) {
+		strm.SetTrailer(res)
+		return nil, status.Errorf(codes.FailedPrecondition, "compression %s not accepted on stream %s, use %s", used, streamName, preferred)
+	}
+	return res, nil
+}
+
+// key of the compression of a stream request in its context, read by the traffic stats handler
+type compressionKey struct{}
+
+// withCompression returns the context of a stream request sent with the compression
+func withCompression(ctx context.Context, compression string) context.Context {
+	return context.WithValue(ctx, compressionKey{}, compression)
+}
+
+// compressedCall returns true if the stream request of ctx is sent with a compression
+func compressedCall(ctx context.Context) bool {
+	c, ok := ctx.Value(compressionKey{}).(string)
+	return ok && c != NoCompression
+}
+
+// compressionMetrics accounts the messages received compressed by the consumers of a stream, as received and once decompressed,
+// unlike the traffic metrics which account the messages received compressed or not
+type compressionMetrics struct {
+	compressed   prometheus.Counter
+	decompressed prometheus.Counter
+}
+
+// map of metrics registered to Prometheus, by stream
+var compressionMetricsMu sync.Mutex
+var compressionMonitorings = make(map[string]*compressionMetrics)
+
+func compressionMonitoring(g *Gaz, streamName string) *compressionMetrics {
+	compressionMetricsMu.Lock()
+	defer compressionMetricsMu.Unlock()
+
+	if m, ok := compressionMonitorings[streamName]; ok {
+		return m
+	}
+	labels := prometheus.Labels{StreamNameLabel: streamName}
+	m := &compressionMetrics{
+		compressed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamConsumerCompressedBytes,
+			Help:        "The total number of bytes of the events received compressed, on the wire",
+			ConstLabels: labels,
+		}),
+		decompressed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamConsumerDecompressedBytes,
+			Help:        "The total number of bytes of the events received compressed, once decompressed",
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.compressed, m.decompressed)
+	compressionMonitorings[streamName] = m
+	return m
+}
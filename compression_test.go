@@ -0,0 +1,196 @@
+package gorillaz
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+)
+
+// passThroughCompressor is a compressor registered for the tests, it does not compress
+type passThroughCompressor struct{}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func (passThroughCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (passThroughCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+func (passThroughCompressor) Name() string {
+	return "test-passthrough"
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	g := &Gaz{}
+	assert.NoError(t, WithCompressors(passThroughCompressor{}).apply(g))
+
+	assert.Equal(t, gzip.Name, negotiateCompression(nil, []string{gzip.Name, "test-passthrough"}))
+	assert.Equal(t, "test-passthrough", negotiateCompression(nil, []string{"unknown", "test-passthrough"}), "not registered")
+	assert.Equal(t, NoCompression, negotiateCompression(nil, []string{"unknown"}))
+	assert.Equal(t, "test-passthrough", negotiateCompression([]string{"test-passthrough", gzip.Name}, []string{gzip.Name, "test-passthrough"}), "provider preference")
+	assert.Equal(t, NoCompression, negotiateCompression([]string{NoCompression}, []string{gzip.Name, NoCompression}))
+	assert.Equal(t, NoCompression, negotiateCompression([]string{"test-passthrough"}, []string{gzip.Name}), "nothing in common")
+
+	assert.True(t, compressionAllowed(nil, gzip.Name))
+	assert.True(t, compressionAllowed([]string{gzip.Name}, gzip.Name))
+	assert.False(t, compressionAllowed([]string{gzip.Name}, NoCompression))
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	n := newCompressionNegotiation(&ConsumerConfig{})
+	ctx, opts, md := n.callOptions(context.Background())
+	assert.Empty(t, opts)
+	assert.Nil(t, md, "nothing advertised without compression")
+	assert.False(t, compressedCall(ctx))
+
+	n = newCompressionNegotiation(&ConsumerConfig{UseGzip: true})
+	ctx, opts, md = n.callOptions(context.Background())
+	assert.Len(t, opts, 1)
+	assert.Equal(t, []string{gzip.Name}, md.Get(acceptCompressionMetadataKey))
+	assert.Equal(t, []string{gzip.Name}, md.Get(compressionMetadataKey))
+	assert.True(t, compressedCall(ctx))
+
+	n = newCompressionNegotiation(&ConsumerConfig{Compression: []string{"unknown", gzip.Name, NoCompression}})
+	n.update(metadata.Pairs(compressionMetadataKey, NoCompression))
+	ctx, opts, md = n.callOptions(context.Background())
+	assert.Empty(t, opts)
+	assert.Equal(t, []string{gzip.Name, NoCompression}, md.Get(acceptCompressionMetadataKey), "unknown compressions are not advertised")
+	assert.Equal(t, []string{NoCompression}, md.Get(compressionMetadataKey))
+	assert.False(t, compressedCall(ctx))
+
+	n.update(metadata.Pairs(compressionMetadataKey, "unknown"))
+	_, opts, _ = n.callOptions(context.Background())
+	assert.Empty(t, opts, "unknown compression ignored")
+}
+
+func TestCompressionTraffic(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	h := &streamTrafficHandler{g: g}
+
+	ctx := h.TagRPC(withCompression(context.Background(), gzip.Name), &stats.RPCTagInfo{FullMethodName: "/stream.Stream/Stream"})
+	h.HandleRPC(ctx, &stats.OutPayload{Client: true, Payload: &stream.StreamRequest{Name: "compression-traffic"}})
+	h.HandleRPC(ctx, &stats.InPayload{Client: true, Length: 100, WireLength: 40})
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "compression-traffic"}, StreamConsumerDecompressedBytes, 100)
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "compression-traffic"}, StreamConsumerCompressedBytes, 40)
+
+	// the uncompressed messages are counted by the traffic metrics only
+	ctx = h.TagRPC(withCompression(context.Background(), NoCompression), &stats.RPCTagInfo{FullMethodName: "/stream.Stream/Stream"})
+	h.HandleRPC(ctx, &stats.OutPayload{Client: true, Payload: &stream.StreamRequest{Name: "compression-traffic"}})
+	h.HandleRPC(ctx, &stats.InPayload{Client: true, Length: 100, WireLength: 100})
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "compression-traffic"}, StreamConsumerDecompressedBytes, 100)
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "compression-traffic"}, StreamConsumerReceivedBytes, 200)
+}
+
+func TestProviderCompression(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	interceptor := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod == "/stream.Stream/Stream" {
+			md, _ := metadata.FromIncomingContext(ss.Context())
+			mu.Lock()
+			requested = append(requested, md.Get(compressionMetadataKey)...)
+			mu.Unlock()
+		}
+		return handler(srv, ss)
+	}
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery(), WithGrpcServerOptions(grpc.StreamInterceptor(interceptor)))
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("provider-compression", "dummy.type", ProviderCompression(NoCompression))
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "provider-compression", WithCompression(gzip.Name, NoCompression))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["provider-compression"]) == 1
+	})
+
+	provider.Submit(&stream.Event{Key: []byte("k"), Value: []byte("v")})
+	assertReceived(t, "provider-compression", consumer.EvtChan(), &stream.Event{Key: []byte("k"), Value: []byte("v")})
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{gzip.Name, NoCompression}, requested, "the consumer is rejected with gzip then reconnects uncompressed")
+}
+
+func TestCompressorsRoundTrip(t *testing.T) {
+	msg := bytes.Repeat([]byte("flight AF123 "), 1000)
+	for _, name := range []string{Zstd, Snappy} {
+		c := encoding.GetCompressor(name)
+		if !assert.NotNil(t, c, name) {
+			continue
+		}
+		// twice, so that the pooled encoders and decoders are reused
+		for i := 0; i < 2; i++ {
+			var buf bytes.Buffer
+			w, err := c.Compress(&buf)
+			assert.NoError(t, err)
+			_, err = w.Write(msg)
+			assert.NoError(t, err)
+			assert.NoError(t, w.Close())
+			assert.True(t, buf.Len() < len(msg), "%s compresses", name)
+
+			r, err := c.Decompress(&buf)
+			assert.NoError(t, err)
+			b, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, msg, b, name)
+		}
+	}
+}
+
+func TestCompressedStream(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("compressed-stream", "dummy.type", ProviderCompression(Zstd, Snappy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// snappy is accepted by the provider, the consumer uses it
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "compressed-stream", WithCompression(Snappy, Zstd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["compressed-stream"]) == 1
+	})
+
+	value := bytes.Repeat([]byte("v"), 1000)
+	provider.Submit(&stream.Event{Key: []byte("k"), Value: value})
+	assertReceived(t, "compressed-stream", consumer.EvtChan(), &stream.Event{Key: []byte("k"), Value: value})
+	m := compressionMonitoring(g, "compressed-stream")
+	waitUntil(t, time.Second, "compressed bytes counted", func() bool {
+		return testutil.ToFloat64(m.decompressed) > 1000
+	})
+	assert.True(t, testutil.ToFloat64(m.compressed) < testutil.ToFloat64(m.decompressed))
+}
@@ -15,8 +15,10 @@ const defaultConfigPath = "configs"
 //Define flags supported by gorillaz
 func init() {
 	flag.String("env", "dev", "Environment")
+	flag.String("tenant", "", "Tenant scoping the nats subjects, the jetstream streams and the gRPC streams, empty means no tenant")
 	flag.String("conf", defaultConfigPath, "config folder. default: configs")
 	flag.String("log.level", "", "Log level")
+	flag.String("log.redaction", "none", "Redaction of the event keys and values logged and traced: none, hash, drop, truncate or truncate:<n>")
 	flag.String("service.name", "", "Service name")
 	flag.String("service.address", "", "Service address")
 	flag.Bool("tracing.enabled", false, "Tracing enabled")
@@ -28,10 +30,44 @@ func init() {
 	flag.Bool("prometheus.enabled", true, "Prometheus enabled")
 	flag.Int("http.port", 0, "http port")
 	flag.Int("grpc.port", 0, "grpc port")
+	flag.Int("grpc.client.max.attempts", 3, "maximum number of attempts of the unary gRPC calls of the idempotent methods failing with Unavailable")
+	flag.String("grpc.client.idempotent.methods", "", "comma separated gRPC methods (/package.Service/Method) or services (package.Service) whose unary calls are retried, none by default")
+	flag.String("grpc.client.service.config", "", "gRPC service config in JSON applied to the dialed connections, overrides the other grpc.client.* keys")
+	flag.Int("grpc.client.timeout.ms", 0, "timeout of the gRPC calls, 0 means no timeout")
+	flag.Int("grpc.client.retry.max.attempts", 0, "maximum number of attempts of the gRPC retry policy, requires GRPC_GO_RETRY=on")
+	flag.Int("grpc.client.retry.initial.backoff.ms", 100, "initial backoff of the gRPC retry policy")
+	flag.Int("grpc.client.retry.max.backoff.ms", 1000, "maximum backoff of the gRPC retry policy")
+	flag.Float64("grpc.client.retry.backoff.multiplier", 2, "backoff multiplier of the gRPC retry policy")
+	flag.String("grpc.client.retry.codes", "UNAVAILABLE", "comma separated status codes retried by the gRPC retry policy")
+	flag.Int("grpc.client.hedging.max.attempts", 0, "maximum number of attempts of the gRPC hedging policy, requires GRPC_GO_RETRY=on")
+	flag.Int("grpc.client.hedging.delay.ms", 0, "delay between the hedged gRPC calls")
+	flag.String("grpc.client.hedging.codes", "", "comma separated status codes on which the gRPC hedging policy sends the next call immediately")
+	flag.Int("grpc.client.default.deadline.ms", 0, "deadline applied to the unary gRPC calls made without deadline, 0 means no default deadline")
+	flag.Int("http.client.timeout.ms", 10000, "timeout of the requests of the HTTP clients, overridden per client by http.client.<name>.timeout.ms, 0 means no timeout")
+	flag.Int("http.client.max.attempts", 3, "maximum number of attempts of the idempotent requests of the HTTP clients failing without response or with 502, 503 or 504")
+	flag.Int("http.client.retry.backoff.ms", 100, "initial backoff between the attempts of the requests of the HTTP clients, doubled after each attempt")
+	flag.String("grpc.server.tls.cert", "", "certificate file of the gRPC server, enables mutual TLS")
+	flag.String("grpc.server.tls.key", "", "private key file of the gRPC server certificate")
+	flag.String("grpc.server.tls.ca", "", "CA file used to verify the client certificates")
+	flag.String("grpc.client.tls.cert", "", "client certificate file of the stream consumers, enables mutual TLS")
+	flag.String("grpc.client.tls.key", "", "private key file of the client certificate")
+	flag.String("grpc.client.tls.ca", "", "CA file used by the stream consumers to verify the provider certificates, enables TLS")
+	flag.Bool("grpc.client.tls.insecure.skip.verify", false, "connect the stream consumers over TLS without verifying the provider certificates, for tests only")
+	flag.Int("grpc.client.dns.refresh.ms", 30000, "period of the re-resolution of the host names of the gRPC endpoints, 0 to resolve them again only on connection failures")
+	flag.Int("grpc.client.dns.min.interval.ms", 1000, "minimum time between two resolutions of the host names of the gRPC endpoints")
+	flag.String("zone", "", "zone of the service, such as an availability zone, its stream endpoints created with EndpointZoneAware prefer the providers of the same zone")
+	flag.Int("weight", 1, "weight of the service among the providers of the other zones, for the zone-aware stream endpoints")
+	flag.Bool("grpc.client.dns.resolve.on.failure", true, "resolve again the host names of the gRPC endpoints when a connection fails, to pick up the new addresses after a failover")
+	flag.Bool("grpc.server.authz.enabled", false, "authorize the gRPC calls and streams according to the identity of the peer certificate and the grpc.server.authz.rules list")
 	flag.Int("metrics.publication.interval.ms", 400, "interval of prometheus metrics publication over gRPC stream")
 	flag.String("nats.addr", "", "nats broker address")
+	flag.Int("nats.request.default.deadline.ms", 5000, "deadline applied to the Nats requests made without deadline, 0 means no default deadline")
 	flag.Bool("nats.add.env.prefix", true, "configure whether or not the nats subjects should be prefixed by the gorillaz env")
+	flag.String("naming.subject.pattern", "", "regular expression the Nats subjects must match, before the env and tenant prefixes, see also the naming.subject.allowed list")
+	flag.String("naming.stream.pattern", "", "regular expression the stream names must match, before the tenant prefix, see also the naming.stream.allowed list")
 	flag.Uint64("nats.connect_timeout_ms", 5000, "nats connection timeout")
+	flag.Bool("nats.scheduler.enabled", false, "run the scheduler publishing the delayed Nats events")
+	flag.String("pipelines", "", "comma separated names of the pipelines declared by the pipeline.<name>.* keys, started by Run")
 }
 
 func parseConfiguration(g *Gaz, configPath string) {
@@ -0,0 +1,94 @@
+package gorillaz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const defaultConsulAddr = "http://127.0.0.1:8500"
+
+// ConsulResolver is an EndpointResolver discovering the healthy instances of the services of the Consul catalog,
+// with blocking queries so that the instances coming and going are known as soon as Consul knows them
+type ConsulResolver struct {
+	Addr   string        // Addr is the URL of the Consul agent (default: http://127.0.0.1:8500)
+	Tag    string        // Tag keeps only the instances with this tag, such as the environment (default: all the instances)
+	Wait   time.Duration // Wait is the maximum duration of the blocking queries (default: 5 min)
+	Client *http.Client  // Client queries Consul (default: http.DefaultClient)
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+func (r *ConsulResolver) Watch(ctx context.Context, name string, update func(addrs []string)) error {
+	addr, wait, client := r.Addr, r.Wait, r.Client
+	if addr == "" {
+		addr = defaultConsulAddr
+	}
+	if wait <= 0 {
+		wait = 5 * time.Minute
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var index uint64
+	for {
+		q := url.Values{}
+		q.Set("passing", "true")
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", strconv.Itoa(int(wait.Seconds()))+"s")
+		if r.Tag != "" {
+			q.Set("tag", r.Tag)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/health/service/"+url.PathEscape(name)+"?"+q.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		var entries []consulServiceEntry
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("consul returned status %d for service %s", resp.StatusCode, name)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid consul index for service %s: %w", name, err)
+		}
+		if next != index {
+			addrs := make([]string, 0, len(entries))
+			for _, e := range entries {
+				host := e.Service.Address
+				if host == "" {
+					host = e.Node.Address
+				}
+				addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+			}
+			update(addrs)
+		}
+		// the index may go backwards, for instance after a restart of Consul, the query then starts again from 0
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
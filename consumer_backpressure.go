@@ -0,0 +1,77 @@
+package gorillaz
+
+import (
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+// BackpressureStrategy is what a consumer does with the events received when its channel is full,
+// because the application does not read them fast enough
+type BackpressureStrategy uint8
+
+const (
+	// BackpressureBlock waits for the application to read the channel, the provider then applies its own backpressure policy
+	BackpressureBlock BackpressureStrategy = iota
+	// BackpressureDropOldest drops the oldest event of the channel to make room for the event received
+	BackpressureDropOldest
+	// BackpressureDropNewest drops the event received
+	BackpressureDropNewest
+	// BackpressureReconnect drops the event received and reconnects to the provider, a GetAndWatch consumer then receives the state again
+	BackpressureReconnect
+)
+
+func (s BackpressureStrategy) String() string {
+	switch s {
+	case BackpressureDropOldest:
+		return "drop_oldest"
+	case BackpressureDropNewest:
+		return "drop_newest"
+	case BackpressureReconnect:
+		return "reconnect"
+	default:
+		return "block"
+	}
+}
+
+// WithBackpressure sets what the consumer does with the events received when its channel is full.
+// Dropping the updates of a state would leave it inconsistent, so GetAndWatch consumers block with the drop strategies.
+func WithBackpressure(s BackpressureStrategy) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.Backpressure = s
+	}
+}
+
+// mustReconnect returns true if the consumer must reconnect because its channel is full, an unbuffered channel is never full
+func (c *consumer) mustReconnect() bool {
+	return c.config.Backpressure == BackpressureReconnect && cap(c.evtChan) > 0 && len(c.evtChan) == cap(c.evtChan)
+}
+
+// deliver sends the event to the application according to the backpressure strategy of the consumer,
+// it returns false if the application closed the event channel
+func (c *consumer) deliver(evt *stream.Event) (open bool) {
+	switch c.config.Backpressure {
+	case BackpressureDropNewest:
+		defer c.guard.recoverClosed(c.streamName)
+		select {
+		case c.evtChan <- evt:
+		default:
+			c.cMetrics.droppedCounter.Inc()
+		}
+		return true
+	case BackpressureDropOldest:
+		defer c.guard.recoverClosed(c.streamName)
+		for {
+			select {
+			case c.evtChan <- evt:
+				return true
+			default:
+			}
+			select {
+			case <-c.evtChan:
+				c.cMetrics.droppedCounter.Inc()
+			default:
+			}
+		}
+	default:
+		return c.send(evt)
+	}
+}
@@ -0,0 +1,54 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumerBackpressure(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	newConsumer := func(streamName string, s BackpressureStrategy) *consumer {
+		config := defaultConsumerConfig()
+		config.BufferLen = 2
+		WithBackpressure(s)(config)
+		return &consumer{
+			streamName: streamName,
+			evtChan:    make(chan *stream.Event, config.BufferLen),
+			config:     config,
+			cMetrics:   consumerMonitoring(g, streamName, []string{"localhost:1"}),
+			guard:      &evtChanGuard{},
+		}
+	}
+	received := func(c *consumer) []string {
+		var keys []string
+		for len(c.evtChan) > 0 {
+			keys = append(keys, string((<-c.evtChan).Key))
+		}
+		return keys
+	}
+	deliverAll := func(c *consumer) {
+		for _, k := range []string{"1", "2", "3"} {
+			assert.True(t, c.deliver(&stream.Event{Ctx: context.Background(), Key: []byte(k)}))
+		}
+	}
+
+	c := newConsumer("backpressure-drop-newest", BackpressureDropNewest)
+	deliverAll(c)
+	assert.Equal(t, []string{"1", "2"}, received(c))
+	assertCounterEquals(t, g, prometheus.Labels{StreamNameLabel: c.streamName}, StreamConsumerDroppedEvents, 1)
+
+	c = newConsumer("backpressure-drop-oldest", BackpressureDropOldest)
+	deliverAll(c)
+	assert.Equal(t, []string{"2", "3"}, received(c))
+	assertCounterEquals(t, g, prometheus.Labels{StreamNameLabel: c.streamName}, StreamConsumerDroppedEvents, 1)
+
+	c = newConsumer("backpressure-reconnect", BackpressureReconnect)
+	assert.False(t, c.mustReconnect())
+	c.evtChan <- &stream.Event{}
+	c.evtChan <- &stream.Event{}
+	assert.True(t, c.mustReconnect())
+}
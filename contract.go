@@ -0,0 +1,193 @@
+package gorillaz
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+// Ordering is the ordering guaranteed by the provider of a stream
+type Ordering int
+
+const (
+	// Unordered guarantees no ordering
+	Unordered Ordering = iota
+	// OrderedByKey guarantees that the events of a same key are ordered
+	OrderedByKey
+	// Ordered guarantees that all the events are ordered
+	Ordered
+)
+
+// StreamContract describes the events of a stream that the consumers rely on.
+// The provider verifies in its tests that the events it produces respect the contract, and records samples of them;
+// the consumers verify in their tests that they handle the recorded samples.
+// So a change of the provider breaking its consumers fails the build of the provider, or of the consumers.
+type StreamContract struct {
+	Stream     string
+	EventTypes []string                    // EventTypes are the allowed event types (default: any)
+	KeyFormat  *regexp.Regexp              // KeyFormat is the format of the keys (default: any key, including none)
+	Schema     func(value []byte) error    // Schema checks that a value can be decoded (default: any value), see ProtoSchema
+	Ordering   Ordering                    // Ordering is the ordering guarantee of the events
+	OrderBy    func(e *stream.Event) int64 // OrderBy returns the position of the event used to verify the ordering (default: stream.EventTimestamp)
+}
+
+// ProtoSchema checks that the values are the serialization of messages of the same type as msg
+func ProtoSchema(msg proto.Message) func(value []byte) error {
+	return func(value []byte) error {
+		m := proto.Clone(msg)
+		m.Reset()
+		return proto.Unmarshal(value, m)
+	}
+}
+
+// Validator returns a Validator rejecting the events violating the contract,
+// it can also be used by the provider and the consumers at runtime
+func (c StreamContract) Validator() Validator {
+	var validators []Validator
+	if len(c.EventTypes) > 0 {
+		validators = append(validators, AllowedEventTypes(c.EventTypes...))
+	}
+	if c.KeyFormat != nil {
+		validators = append(validators, ValidatorFunc(func(e *stream.Event) error {
+			if !c.KeyFormat.Match(e.Key) {
+				return fmt.Errorf("key %q does not match %s", e.Key, c.KeyFormat)
+			}
+			return nil
+		}))
+	}
+	if c.Schema != nil {
+		validators = append(validators, ValidatorFunc(func(e *stream.Event) error {
+			if err := c.Schema(e.Value); err != nil {
+				return fmt.Errorf("value does not match the schema: %w", err)
+			}
+			return nil
+		}))
+	}
+	return Validators(validators...)
+}
+
+// Verify returns an error describing all the violations of the contract by the events, which are in the order they were produced
+func (c StreamContract) Verify(events []*stream.Event) error {
+	var violations []string
+	v := c.Validator()
+	for i, e := range events {
+		if err := v.Validate(e); err != nil {
+			violations = append(violations, fmt.Sprintf("event %d: %v", i, err))
+		}
+	}
+	violations = append(violations, c.orderingViolations(events)...)
+	if len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("stream %s breaks its contract:\n%s", c.Stream, strings.Join(violations, "\n"))
+}
+
+func (c StreamContract) orderingViolations(events []*stream.Event) []string {
+	if c.Ordering == Unordered {
+		return nil
+	}
+	orderBy := c.OrderBy
+	if orderBy == nil {
+		orderBy = stream.EventTimestamp
+	}
+	var violations []string
+	last := make(map[string]int64)
+	for i, e := range events {
+		var k string
+		if c.Ordering == OrderedByKey {
+			k = string(e.Key)
+		}
+		pos := orderBy(e)
+		if prev, ok := last[k]; ok && pos < prev {
+			violations = append(violations, fmt.Sprintf("event %d: out of order, %d is before the previous %d", i, pos, prev))
+		}
+		last[k] = pos
+	}
+	return violations
+}
+
+// ContractT is the subset of testing.TB used to verify contracts
+type ContractT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// VerifyContract fails the test if the events violate the contract
+func VerifyContract(t ContractT, c StreamContract, events []*stream.Event) {
+	t.Helper()
+	if err := c.Verify(events); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// contractSample is the recorded form of an event
+type contractSample struct {
+	Key              []byte            `json:"key,omitempty"`
+	Value            []byte            `json:"value,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	EventType        string            `json:"event_type,omitempty"`
+	EventTypeVersion string            `json:"event_type_version,omitempty"`
+	EventTime        int64             `json:"event_time,omitempty"`
+}
+
+// WriteContractSamples records the events as samples, one JSON object per line, for the tests of the consumers
+func WriteContractSamples(w io.Writer, events []*stream.Event) error {
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		s := contractSample{
+			Key:              e.Key,
+			Value:            e.Value,
+			Headers:          e.Headers,
+			EventType:        e.EventTypeStr(),
+			EventTypeVersion: e.EventTypeVersionStr(),
+			EventTime:        stream.EventTimestamp(e),
+		}
+		if err := enc.Encode(&s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadContractSamples reads the samples recorded by WriteContractSamples
+func ReadContractSamples(r io.Reader) ([]*stream.Event, error) {
+	var events []*stream.Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var s contractSample
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("invalid sample at line %d: %w", line, err)
+		}
+		e := &stream.Event{Ctx: context.Background(), Key: s.Key, Value: s.Value, Headers: s.Headers}
+		if s.EventType != "" {
+			e.SetEventTypeStr(s.EventType)
+		}
+		if s.EventTypeVersion != "" {
+			e.SetEventTypeVersionStr(s.EventTypeVersion)
+		}
+		if s.EventTime != 0 {
+			e.SetEventTime(time.Unix(0, s.EventTime))
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, errors.New("no sample recorded")
+	}
+	return events, nil
+}
@@ -0,0 +1,97 @@
+package gorillaz
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/skysoft-atm/gorillaz/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func pingEvent(t *testing.T, key, eventType string, at time.Time) *stream.Event {
+	b, err := proto.Marshal(&test.Ping{Name: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &stream.Event{Ctx: context.Background(), Key: []byte(key), Value: b}
+	e.SetEventTypeStr(eventType)
+	e.SetEventTime(at)
+	return e
+}
+
+var pingContract = StreamContract{
+	Stream:     "pings",
+	EventTypes: []string{"ping"},
+	KeyFormat:  regexp.MustCompile(`^[a-z]+$`),
+	Schema:     ProtoSchema(&test.Ping{}),
+	Ordering:   OrderedByKey,
+}
+
+func TestContractVerify(t *testing.T) {
+	now := time.Now()
+	events := []*stream.Event{
+		pingEvent(t, "a", "ping", now),
+		pingEvent(t, "b", "ping", now.Add(-time.Second)),
+		pingEvent(t, "a", "ping", now.Add(time.Second)),
+	}
+	assert.Nil(t, pingContract.Verify(events))
+
+	total := pingContract
+	total.Ordering = Ordered
+	assert.NotNil(t, total.Verify(events))
+
+	bad := append(events,
+		pingEvent(t, "A1", "ping", now.Add(2*time.Second)),
+		pingEvent(t, "c", "pong", now.Add(2*time.Second)),
+		&stream.Event{Ctx: context.Background(), Key: []byte("d"), Value: []byte{0xff}},
+	)
+	err := pingContract.Verify(bad)
+	assert.NotNil(t, err)
+	for _, violation := range []string{"event 3", "event 4", "event 5"} {
+		assert.Contains(t, err.Error(), violation)
+	}
+	assert.False(t, strings.Contains(err.Error(), "event 2"))
+}
+
+type contractT struct {
+	errors []string
+}
+
+func (t *contractT) Helper() {}
+
+func (t *contractT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestContractSamples(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	events := []*stream.Event{pingEvent(t, "a", "ping", now), pingEvent(t, "b", "ping", now)}
+	events[1].SetHeader("source", "radar")
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteContractSamples(&buf, events))
+	samples, err := ReadContractSamples(&buf)
+	assert.Nil(t, err)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, events[1].Value, samples[1].Value)
+	assert.Equal(t, "radar", samples[1].Header("source"))
+	assert.Equal(t, "ping", samples[0].EventTypeStr())
+	assert.Equal(t, now.UnixNano(), stream.EventTimestamp(samples[0]))
+
+	ct := &contractT{}
+	VerifyContract(ct, pingContract, samples)
+	assert.Len(t, ct.errors, 0)
+	samples[0].SetEventTypeStr("pong")
+	VerifyContract(ct, pingContract, samples)
+	assert.Len(t, ct.errors, 1)
+
+	_, err = ReadContractSamples(strings.NewReader(""))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,103 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// TokenSource returns the bearer token authenticating the stream requests, such as a JWT or an OAuth2 access token.
+// forceRefresh is true when the provider rejected the previous token: a cached token must not be returned again.
+type TokenSource func(ctx context.Context, forceRefresh bool) (string, error)
+
+// WithPerRPCCredentials attaches the credentials to the stream requests of the endpoint.
+// The credentials are attached to the calls rather than to the connections, which are shared with the other endpoints
+// and gRPC clients targeting the same providers. Credentials requiring transport security need EndpointTLSConfig or alike.
+func WithPerRPCCredentials(creds credentials.PerRPCCredentials) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.perRPCCredentials = creds
+	}
+}
+
+// WithBearerToken authenticates the stream requests of the endpoint with the token of source, sent in the
+// "authorization" metadata as "Bearer <token>". The token is reused until a provider rejects a stream with
+// codes.Unauthenticated, then the consumer reconnects right away with a token refreshed by source.
+// The token is sent even without TLS, the endpoint should be configured with EndpointTLSConfig or alike outside of tests.
+func WithBearerToken(source TokenSource) StreamEndpointConfigOpt {
+	return WithPerRPCCredentials(&bearerCredentials{source: source})
+}
+
+// refreshableCredentials are credentials whose token can be refreshed after a rejection
+type refreshableCredentials interface {
+	credentials.PerRPCCredentials
+	// invalidate forces the refresh of the token of the next request
+	invalidate()
+}
+
+// bearerCredentials caches the token of its source until it is invalidated
+type bearerCredentials struct {
+	source  TokenSource
+	mu      sync.Mutex
+	token   string
+	refresh bool
+}
+
+func (b *bearerCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token == "" || b.refresh {
+		token, err := b.source(ctx, b.refresh)
+		if err != nil {
+			return nil, err
+		}
+		if token == "" {
+			return nil, errors.New("empty bearer token")
+		}
+		b.token, b.refresh = token, false
+	}
+	return map[string]string{"authorization": "Bearer " + b.token}, nil
+}
+
+func (b *bearerCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+func (b *bearerCredentials) invalidate() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh = true
+}
+
+// credentialsCallOptions returns the call options attaching the credentials of the endpoint to a stream request
+func (se *streamEndpoint) credentialsCallOptions() []grpc.CallOption {
+	if se.config.perRPCCredentials == nil {
+		return nil
+	}
+	return []grpc.CallOption{grpc.PerRPCCredentials(se.config.perRPCCredentials)}
+}
+
+// retryWithRefreshedToken returns true if the stream was rejected with codes.Unauthenticated and must be retried
+// right away with a refreshed token. It is retried once in a row only, retried tracks the retries of a consumer
+// and is reset once it connects, so that a provider rejecting every token does not make the consumer spin.
+func (se *streamEndpoint) retryWithRefreshedToken(err error, retried *bool) bool {
+	if status.Code(err) != codes.Unauthenticated {
+		return false
+	}
+	creds, ok := se.config.perRPCCredentials.(refreshableCredentials)
+	if !ok {
+		return false
+	}
+	creds.invalidate()
+	if *retried {
+		return false
+	}
+	*retried = true
+	Log.Info("stream rejected as unauthenticated, retrying with a refreshed token", zap.String("target", se.target))
+	return true
+}
@@ -0,0 +1,78 @@
+package gorillaz
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBearerToken(t *testing.T) {
+	interceptor := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod == "/stream.Stream/Stream" {
+			md, _ := metadata.FromIncomingContext(ss.Context())
+			if v := md.Get("authorization"); len(v) == 0 || v[0] != "Bearer fresh" {
+				return status.Error(codes.Unauthenticated, "invalid token")
+			}
+		}
+		return handler(srv, ss)
+	}
+	var mu sync.Mutex
+	var refreshes int
+	source := func(ctx context.Context, forceRefresh bool) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if forceRefresh {
+			refreshes++
+			return "fresh", nil
+		}
+		return "expired", nil
+	}
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery(),
+		WithGrpcServerOptions(grpc.StreamInterceptor(interceptor)),
+		WithStreamEndpointOptions(WithBearerToken(source)))
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("bearer-token", "dummy.type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the consumer would wait 800ms at least before retrying after a failure
+	start := time.Now()
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "bearer-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["bearer-token"]) == 1
+	})
+	assert.True(t, time.Since(start) < 700*time.Millisecond, "the consumer retries right away with a refreshed token")
+
+	provider.Submit(&stream.Event{Key: []byte("k"), Value: []byte("v")})
+	assertReceived(t, "bearer-token", consumer.EvtChan(), &stream.Event{Key: []byte("k"), Value: []byte("v")})
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, refreshes)
+}
+
+func TestRetryWithRefreshedToken(t *testing.T) {
+	se := &streamEndpoint{config: &StreamEndpointConfig{perRPCCredentials: &bearerCredentials{}}}
+	retried := false
+	unauthenticated := status.Error(codes.Unauthenticated, "invalid token")
+	assert.False(t, se.retryWithRefreshedToken(status.Error(codes.Unavailable, "down"), &retried))
+	assert.True(t, se.retryWithRefreshedToken(unauthenticated, &retried))
+	assert.False(t, se.retryWithRefreshedToken(unauthenticated, &retried), "retried once in a row only")
+
+	se = &streamEndpoint{config: &StreamEndpointConfig{}}
+	retried = false
+	assert.False(t, se.retryWithRefreshedToken(unauthenticated, &retried), "nothing to refresh")
+}
@@ -0,0 +1,42 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2020, time.November, 13, 10, 7, 30, 0, time.UTC) // friday
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2020, time.November, 13, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, time.November, 13, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2020, time.November, 13, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2020, time.November, 14, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2020, time.November, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2020, time.November, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * 1", time.Date(2020, time.November, 16, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2020, time.December, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 10m", time.Date(2020, time.November, 13, 10, 10, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if assert.NoError(t, err, tt.spec) {
+			assert.Equal(t, tt.next, s.next(from), tt.spec)
+		}
+	}
+}
+
+func TestCronInvalidSpecs(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "a * * * *", "5-1 * * * *", "@every 10ms", "@every x"} {
+		_, err := parseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,38 @@
+package gorillaz
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// withDefaultDeadline returns a context with the given timeout if ctx has no deadline.
+// The returned boolean is true if the default deadline has been applied
+func withDefaultDeadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc, bool) {
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return ctx, func() {}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, true
+}
+
+// DeadlineClientInterceptor applies timeout to the unary calls made without a deadline,
+// so that a stuck dependency does not keep the calling goroutines forever
+func (g *Gaz) DeadlineClientInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel, applied := withDefaultDeadline(ctx, timeout)
+		defer cancel()
+		err := invoker(ctx, method, req, resp, cc, opts...)
+		if applied && status.Code(err) == codes.DeadlineExceeded {
+			grpcClientMonitoring(g, method).defaultDeadlineCounter.Inc()
+		}
+		return err
+	}
+}
+
+func (g *Gaz) natsRequestDefaultDeadline() time.Duration {
+	return time.Duration(g.Viper.GetInt("nats.request.default.deadline.ms")) * time.Millisecond
+}
@@ -0,0 +1,67 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDefaultDeadline(t *testing.T) {
+	ctx, cancel, applied := withDefaultDeadline(context.Background(), time.Second)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.True(t, applied)
+	assert.True(t, ok)
+
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Minute)
+	defer parentCancel()
+	ctx, cancel, applied = withDefaultDeadline(parent, time.Second)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	parentDeadline, _ := parent.Deadline()
+	assert.False(t, applied, "the caller deadline must be kept")
+	assert.Equal(t, parentDeadline, deadline)
+
+	_, cancel, applied = withDefaultDeadline(context.Background(), 0)
+	defer cancel()
+	assert.False(t, applied)
+}
+
+func TestNatsHandlerExpiredDeadline(t *testing.T) {
+	now := time.Date(2020, time.November, 13, 10, 0, 0, 0, time.UTC)
+	clock := NewManualClock(now)
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry(), clock: clock}
+	metrics := natsHandlerMonitoring(g, "test.deadline")
+	var handled []string
+	h := &natsMsgHandler{
+		g:       g,
+		subject: "test.deadline",
+		handler: func(subject string, e *stream.Event) (*stream.Event, error) {
+			handled = append(handled, string(e.Key))
+			return nil, nil
+		},
+		opts:    &NatsConsumerOpts{},
+		metrics: metrics,
+		chunks:  newChunkAssembler("test.deadline", metrics, 1024*1024, clock),
+	}
+	msg := func(key string, deadline time.Time) *nats.Msg {
+		b, err := proto.Marshal(&stream.StreamEvent{Key: []byte(key), Metadata: &stream.Metadata{Deadline: deadline.UnixNano()}})
+		assert.NoError(t, err)
+		return &nats.Msg{Subject: "test.deadline", Data: b}
+	}
+
+	h.handle(msg("expired", now.Add(-time.Second)))
+	assert.Empty(t, handled, "the handler is not called once the deadline passed")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.expiredCounter))
+
+	h.handle(msg("valid", now.Add(time.Minute)))
+	assert.Equal(t, []string{"valid"}, handled)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.expiredCounter))
+}
@@ -0,0 +1,150 @@
+package gorillaz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"google.golang.org/grpc/metadata"
+)
+
+// the delta encoding requested by a GetAndWatch consumer is sent in the metadata of the stream request,
+// so that providers not supporting it simply send the full values
+const deltaMetadataKey = "gorillaz-delta"
+
+// deltaKeyValue marks the events whose value is a delta against the previous value of their key
+const deltaKeyValue = "delta"
+
+// WithDeltaEncoding asks the provider of a GetAndWatch stream to send the updates as binary deltas
+// against the previous value of their key, when the delta is smaller than the value.
+// It cuts the bandwidth of streams of large values frequently updated, the consumer delivers the full values anyway.
+func WithDeltaEncoding() ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.DeltaEncoding = true
+	}
+}
+
+func deltaMetadata(c *ConsumerConfig) metadata.MD {
+	if !c.DeltaEncoding {
+		return nil
+	}
+	return metadata.Pairs(deltaMetadataKey, "1")
+}
+
+func requestedDelta(md metadata.MD) bool {
+	v := md.Get(deltaMetadataKey)
+	return len(v) > 0 && v[0] == "1"
+}
+
+// encodeDelta returns the delta transforming previous into value: the length of their common prefix,
+// the length of their common suffix, then the bytes in between of value
+func encodeDelta(previous, value []byte) []byte {
+	prefix := 0
+	for prefix < len(previous) && prefix < len(value) && previous[prefix] == value[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(previous)-prefix && suffix < len(value)-prefix &&
+		previous[len(previous)-1-suffix] == value[len(value)-1-suffix] {
+		suffix++
+	}
+	middle := value[prefix : len(value)-suffix]
+	d := make([]byte, 2*binary.MaxVarintLen64, 2*binary.MaxVarintLen64+len(middle))
+	n := binary.PutUvarint(d, uint64(prefix))
+	n += binary.PutUvarint(d[n:], uint64(suffix))
+	return append(d[:n], middle...)
+}
+
+var errInvalidDelta = errors.New("invalid delta")
+
+func applyDelta(previous, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	prefix, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errInvalidDelta
+	}
+	suffix, err := binary.ReadUvarint(r)
+	if err != nil || prefix+suffix > uint64(len(previous)) {
+		return nil, errInvalidDelta
+	}
+	middle := delta[len(delta)-r.Len():]
+	value := make([]byte, 0, int(prefix)+len(middle)+int(suffix))
+	value = append(value, previous[:prefix]...)
+	value = append(value, middle...)
+	return append(value, previous[len(previous)-int(suffix):]...), nil
+}
+
+// deltaEncoder encodes the values sent to a subscriber, a nil encoder sends the full values
+type deltaEncoder struct {
+	sent map[string][]byte
+}
+
+func newDeltaEncoder(enabled bool) *deltaEncoder {
+	if !enabled {
+		return nil
+	}
+	return &deltaEncoder{sent: make(map[string][]byte)}
+}
+
+// encode replaces the value of the event by its delta if it is smaller
+func (d *deltaEncoder) encode(gwe *stream.GetAndWatchEvent) {
+	if d == nil {
+		return
+	}
+	key := string(gwe.Key)
+	if gwe.EventType == stream.EventType_DELETE {
+		delete(d.sent, key)
+		return
+	}
+	previous, ok := d.sent[key]
+	d.sent[key] = gwe.Value
+	if !ok || gwe.EventType != stream.EventType_UPDATE {
+		return
+	}
+	if delta := encodeDelta(previous, gwe.Value); len(delta) < len(gwe.Value) {
+		gwe.Value = delta
+		if gwe.Metadata.KeyValue == nil {
+			gwe.Metadata.KeyValue = make(map[string]string)
+		}
+		gwe.Metadata.KeyValue[deltaKeyValue] = "1"
+	}
+}
+
+// deltaDecoder restores the full values received by a consumer, a nil decoder expects full values
+type deltaDecoder struct {
+	received map[string][]byte
+}
+
+func newDeltaDecoder(enabled bool) *deltaDecoder {
+	if !enabled {
+		return nil
+	}
+	return &deltaDecoder{received: make(map[string][]byte)}
+}
+
+func (d *deltaDecoder) decode(gwe *stream.GetAndWatchEvent) error {
+	if d == nil {
+		return nil
+	}
+	key := string(gwe.Key)
+	if gwe.EventType == stream.EventType_DELETE {
+		delete(d.received, key)
+		return nil
+	}
+	if gwe.Metadata != nil && gwe.Metadata.KeyValue[deltaKeyValue] != "" {
+		previous, ok := d.received[key]
+		if !ok {
+			return fmt.Errorf("delta received without previous value for key %s", Redact(gwe.Key))
+		}
+		value, err := applyDelta(previous, gwe.Value)
+		if err != nil {
+			return err
+		}
+		gwe.Value = value
+		delete(gwe.Metadata.KeyValue, deltaKeyValue)
+	}
+	d.received[key] = gwe.Value
+	return nil
+}
@@ -0,0 +1,103 @@
+package gorillaz
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	cases := []struct {
+		previous, value string
+	}{
+		{"", ""},
+		{"", "value"},
+		{"value", ""},
+		{"same", "same"},
+		{"position=1;speed=10", "position=2;speed=10"},
+		{"abc", "abcdef"},
+		{"abcdef", "def"},
+		{"aaaa", "aa"},
+		{"aa", "aaaa"},
+		{"xyz", "123"},
+	}
+	for _, c := range cases {
+		delta := encodeDelta([]byte(c.previous), []byte(c.value))
+		v, err := applyDelta([]byte(c.previous), delta)
+		assert.Nil(t, err)
+		assert.Equal(t, c.value, string(v), "%q -> %q", c.previous, c.value)
+	}
+}
+
+func TestApplyInvalidDelta(t *testing.T) {
+	_, err := applyDelta([]byte("abc"), nil)
+	assert.Equal(t, errInvalidDelta, err)
+
+	// the prefix and the suffix are longer than the previous value
+	_, err = applyDelta([]byte("abc"), encodeDelta([]byte("abcdef"), []byte("abcdef")))
+	assert.Equal(t, errInvalidDelta, err)
+}
+
+func TestDeltaEncoderDecoder(t *testing.T) {
+	enc := newDeltaEncoder(true)
+	dec := newDeltaDecoder(true)
+	large := bytes.Repeat([]byte("x"), 100)
+	updated := append(append([]byte{}, large...), 'y')
+
+	send := func(evtType stream.EventType, key string, value []byte) *stream.GetAndWatchEvent {
+		gwe := &stream.GetAndWatchEvent{EventType: evtType, Key: []byte(key), Value: value, Metadata: &stream.Metadata{}}
+		enc.encode(gwe)
+		return gwe
+	}
+	receive := func(gwe *stream.GetAndWatchEvent) []byte {
+		assert.Nil(t, dec.decode(gwe))
+		assert.Empty(t, gwe.Metadata.KeyValue[deltaKeyValue])
+		return gwe.Value
+	}
+
+	// the initial state is always sent in full
+	gwe := send(stream.EventType_INITIAL_STATE, "k", large)
+	assert.Equal(t, large, gwe.Value)
+	assert.Equal(t, large, receive(gwe))
+
+	gwe = send(stream.EventType_UPDATE, "k", updated)
+	assert.Equal(t, "1", gwe.Metadata.KeyValue[deltaKeyValue])
+	assert.True(t, len(gwe.Value) < len(updated))
+	assert.Equal(t, updated, receive(gwe))
+
+	// a delta larger than the value is not sent
+	gwe = send(stream.EventType_UPDATE, "k", []byte("z"))
+	assert.Empty(t, gwe.Metadata.KeyValue[deltaKeyValue])
+	assert.Equal(t, "z", string(receive(gwe)))
+
+	// a deleted key starts over with a full value
+	receive(send(stream.EventType_DELETE, "k", nil))
+	gwe = send(stream.EventType_UPDATE, "k", large)
+	assert.Empty(t, gwe.Metadata.KeyValue[deltaKeyValue])
+	assert.Equal(t, large, receive(gwe))
+}
+
+func TestDeltaWithoutPreviousValue(t *testing.T) {
+	gwe := &stream.GetAndWatchEvent{
+		EventType: stream.EventType_UPDATE,
+		Key:       []byte("k"),
+		Value:     encodeDelta([]byte("abc"), []byte("abd")),
+		Metadata:  &stream.Metadata{KeyValue: map[string]string{deltaKeyValue: "1"}},
+	}
+	assert.NotNil(t, newDeltaDecoder(true).decode(gwe))
+}
+
+func TestDeltaDisabled(t *testing.T) {
+	gwe := &stream.GetAndWatchEvent{EventType: stream.EventType_UPDATE, Key: []byte("k"), Value: []byte("v")}
+	newDeltaEncoder(false).encode(gwe)
+	assert.Nil(t, newDeltaDecoder(false).decode(gwe))
+	assert.Equal(t, "v", string(gwe.Value))
+
+	assert.Nil(t, deltaMetadata(&ConsumerConfig{}))
+	md := deltaMetadata(&ConsumerConfig{DeltaEncoding: true})
+	assert.True(t, requestedDelta(md))
+	assert.False(t, requestedDelta(metadata.MD{}))
+}
@@ -0,0 +1,148 @@
+package gorillaz
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The dependency metrics describe every outbound target of a service the same way, whatever its protocol,
+// so that a single dashboard covers all the dependencies of all the services
+const (
+	DependencyCalls        = "dependency_calls"
+	DependencyRetries      = "dependency_retries"
+	DependencyFailureRatio = "dependency_failure_ratio"
+	DependencyCircuitState = "dependency_circuit_state"
+)
+
+const DependencyProtocolLabel = "protocol"
+const DependencyTargetLabel = "target"
+const DependencyOutcomeLabel = "outcome"
+
+const (
+	ProtocolGrpc = "grpc"
+	ProtocolHttp = "http"
+	ProtocolNats = "nats"
+)
+
+const (
+	OutcomeSuccess  = "success"
+	OutcomeFailure  = "failure"
+	OutcomeCanceled = "canceled" // canceled by the caller, neither a success nor a failure of the target
+	OutcomeRejected = "rejected" // rejected by the open circuit breaker of the target
+)
+
+// dependencyFailureWeight is the weight of the last call in the failure ratio, which is averaged over about the last 20 calls
+const dependencyFailureWeight = 0.05
+
+type dependencyMetrics struct {
+	callCounter       *prometheus.CounterVec
+	retryCounter      prometheus.Counter
+	failureRatioGauge prometheus.Gauge
+	circuitStateGauge prometheus.Gauge
+	mu                sync.Mutex
+	failureRatio      float64
+}
+
+// observe counts a call, the failure ratio is an exponentially weighted average of the successes and the failures
+func (m *dependencyMetrics) observe(outcome string) {
+	m.callCounter.WithLabelValues(outcome).Inc()
+	var failed float64
+	switch outcome {
+	case OutcomeSuccess:
+	case OutcomeFailure:
+		failed = 1
+	default:
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failureRatio += (failed - m.failureRatio) * dependencyFailureWeight
+	m.failureRatioGauge.Set(m.failureRatio)
+}
+
+// map of metrics registered to Prometheus, by protocol and target
+var dependencyMetricsMu sync.Mutex
+var dependencyMonitorings = make(map[string]*dependencyMetrics)
+
+func dependencyMonitoring(g *Gaz, protocol, target string) *dependencyMetrics {
+	dependencyMetricsMu.Lock()
+	defer dependencyMetricsMu.Unlock()
+
+	k := protocol + "/" + target
+	if m, ok := dependencyMonitorings[k]; ok {
+		return m
+	}
+	labels := prometheus.Labels{DependencyProtocolLabel: protocol, DependencyTargetLabel: target}
+	m := &dependencyMetrics{
+		callCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        DependencyCalls,
+			Help:        "The total number of calls to the dependency, by outcome: success, failure, canceled or rejected",
+			ConstLabels: labels,
+		}, []string{DependencyOutcomeLabel}),
+		retryCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        DependencyRetries,
+			Help:        "The total number of calls to the dependency retried after a failure",
+			ConstLabels: labels,
+		}),
+		failureRatioGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        DependencyFailureRatio,
+			Help:        "The ratio of failed calls to the dependency, averaged over about the last 20 calls",
+			ConstLabels: labels,
+		}),
+		circuitStateGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        DependencyCircuitState,
+			Help:        "State of the circuit breaker of the dependency: 0 closed or no circuit breaker, 1 open, 2 half-open",
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.callCounter)
+	g.prometheusRegistry.MustRegister(m.retryCounter)
+	g.prometheusRegistry.MustRegister(m.failureRatioGauge)
+	g.prometheusRegistry.MustRegister(m.circuitStateGauge)
+	dependencyMonitorings[k] = m
+	return m
+}
+
+// dependency is an outbound target, with its circuit breaker if one is configured
+type dependency struct {
+	breaker *CircuitBreaker
+	metrics *dependencyMetrics
+}
+
+func (g *Gaz) dependency(protocol, target string) *dependency {
+	m := dependencyMonitoring(g, protocol, target)
+	cb := g.circuitBreaker(target)
+	cb.reportTo(m)
+	return &dependency{breaker: cb, metrics: m}
+}
+
+// Allow returns true if the circuit breaker lets a call through, see CircuitBreaker.Allow
+func (d *dependency) Allow() bool {
+	if !d.breaker.Allow() {
+		d.metrics.observe(OutcomeRejected)
+		return false
+	}
+	return true
+}
+
+func (d *dependency) RetryAfter() time.Duration {
+	return d.breaker.RetryAfter()
+}
+
+func (d *dependency) Success() {
+	d.metrics.observe(OutcomeSuccess)
+	d.breaker.Success()
+}
+
+func (d *dependency) Failure() {
+	d.metrics.observe(OutcomeFailure)
+	d.breaker.Failure()
+}
+
+// Canceled reports a call canceled by the caller, it releases the trial call of a half-open circuit
+func (d *dependency) Canceled() {
+	d.metrics.observe(OutcomeCanceled)
+	d.breaker.Success()
+}
@@ -0,0 +1,50 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencyMetrics(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	m := dependencyMonitoring(g, ProtocolGrpc, "dependency-metrics")
+
+	m.observe(OutcomeSuccess)
+	m.observe(OutcomeFailure)
+	m.observe(OutcomeCanceled)
+
+	labels := map[string]string{DependencyProtocolLabel: ProtocolGrpc, DependencyTargetLabel: "dependency-metrics"}
+	assertCounterEquals(t, g, withLabel(labels, DependencyOutcomeLabel, OutcomeSuccess), DependencyCalls, 1)
+	assertCounterEquals(t, g, withLabel(labels, DependencyOutcomeLabel, OutcomeFailure), DependencyCalls, 1)
+	assertCounterEquals(t, g, withLabel(labels, DependencyOutcomeLabel, OutcomeCanceled), DependencyCalls, 1)
+	assertGaugeValue(t, g, DependencyFailureRatio, dependencyFailureWeight)
+}
+
+func TestDependencyCircuitState(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	g.circuitBreakerConfigs = map[string]*CircuitBreakerConfig{
+		"dependency-circuit": {FailureThreshold: 1, Window: time.Minute, OpenDuration: time.Minute},
+	}
+	d := g.dependency(ProtocolNats, "dependency-circuit")
+	assertGaugeValue(t, g, DependencyCircuitState, float64(circuitClosed))
+
+	assert.True(t, d.Allow())
+	d.Failure()
+	assert.False(t, d.Allow())
+	assertGaugeValue(t, g, DependencyCircuitState, float64(circuitOpen))
+
+	labels := map[string]string{DependencyProtocolLabel: ProtocolNats, DependencyTargetLabel: "dependency-circuit"}
+	assertCounterEquals(t, g, withLabel(labels, DependencyOutcomeLabel, OutcomeRejected), DependencyCalls, 1)
+}
+
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	l := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		l[k] = v
+	}
+	l[name] = value
+	return l
+}
@@ -0,0 +1,259 @@
+package gorillaz
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/resolver"
+)
+
+// dnsConfig is how often the host names of the endpoints are resolved again
+type dnsConfig struct {
+	refresh          time.Duration // refresh is the period of the re-resolutions, 0 to resolve again only on failures
+	minInterval      time.Duration // minInterval is the minimum time between two resolutions
+	resolveOnFailure bool          // resolveOnFailure resolves again when the connection fails, such as with Unavailable errors
+}
+
+func (g *Gaz) dnsConfig() dnsConfig {
+	c := dnsConfig{
+		refresh:          30 * time.Second,
+		minInterval:      time.Second,
+		resolveOnFailure: true,
+	}
+	if g.Viper == nil {
+		return c
+	}
+	c.refresh = time.Duration(g.Viper.GetInt("grpc.client.dns.refresh.ms")) * time.Millisecond
+	c.minInterval = time.Duration(g.Viper.GetInt("grpc.client.dns.min.interval.ms")) * time.Millisecond
+	c.resolveOnFailure = g.Viper.GetBool("grpc.client.dns.resolve.on.failure")
+	return c
+}
+
+// DNSPrefix and SRVPrefix mark the endpoints resolved with DNS, such as "dns:///flights.ns.svc.cluster.local:9000",
+// or with the SRV records of a name, such as "srv:///_grpc._tcp.flights.ns.svc.cluster.local".
+// The authority, such as "dns://10.96.0.10:53/flights:9000", is the DNS server queried instead of the one of the system.
+const (
+	DNSPrefix = "dns://"
+	SRVPrefix = "srv://"
+)
+
+// WithEndpointType sets how the endpoints of the stream endpoint are resolved, see EndpointType
+func WithEndpointType(t EndpointType) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.endpointType = t
+	}
+}
+
+// SetDNSAddr sets the DNS server, "host:port", resolving the DNS and SRV endpoints, such as the DNS of a Kubernetes cluster
+// to consume the streams of a headless service
+func SetDNSAddr(addr string) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.dnsAddr = addr
+	}
+}
+
+// dnsEndpoints returns the endpoints with the prefix of their type, the endpoints already prefixed are kept as is
+func dnsEndpoints(endpoints []string, t EndpointType, dnsAddr string) []string {
+	prefix := ""
+	switch t {
+	case DNSEndpoint:
+		prefix = DNSPrefix + dnsAddr + "/"
+	case SRVEndpoint:
+		prefix = SRVPrefix + dnsAddr + "/"
+	default:
+		return endpoints
+	}
+	result := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		if strings.HasPrefix(e, DNSPrefix) || strings.HasPrefix(e, SRVPrefix) || strings.HasPrefix(e, SdPrefix) {
+			result[i] = e
+		} else {
+			result[i] = prefix + e
+		}
+	}
+	return result
+}
+
+// parseDNSEndpoint returns the DNS server and the name to resolve of an endpoint, the name is "host:port" unless it is a SRV record
+func parseDNSEndpoint(e string) (authority, name string, srv bool) {
+	switch {
+	case strings.HasPrefix(e, DNSPrefix):
+		e = strings.TrimPrefix(e, DNSPrefix)
+	case strings.HasPrefix(e, SRVPrefix):
+		e, srv = strings.TrimPrefix(e, SRVPrefix), true
+	default:
+		return "", e, false
+	}
+	if i := strings.Index(e, "/"); i >= 0 {
+		return e[:i], e[i+1:], srv
+	}
+	return "", e, srv
+}
+
+// hasHostNames returns true if one of the endpoints is a host name and not an IP address
+func hasHostNames(endpoints []string) bool {
+	for _, e := range endpoints {
+		if strings.HasPrefix(e, DNSPrefix) || strings.HasPrefix(e, SRVPrefix) {
+			return true
+		}
+		if host, _, err := net.SplitHostPort(e); err == nil && net.ParseIP(host) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// netResolver returns the resolver querying the DNS server, the one of the system if it is empty
+func netResolver(authority string) *net.Resolver {
+	if authority == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(authority); err != nil {
+		authority = net.JoinHostPort(authority, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, authority)
+		},
+	}
+}
+
+func lookupHost(ctx context.Context, authority, host string) ([]string, error) {
+	return netResolver(authority).LookupHost(ctx, host)
+}
+
+func lookupSRV(ctx context.Context, authority, name string) ([]*net.SRV, error) {
+	_, srvs, err := netResolver(authority).LookupSRV(ctx, "", "", name)
+	return srvs, err
+}
+
+// dnsResolver is a
+// Resolver(https://godoc.org/google.golang.org/grpc/resolver#Resolver)
+// resolving the host names of the endpoints, periodically and when gRPC asks for it after a connection failure,
+// so that the consumers pick up the new addresses of the providers after a failover.
+type dnsResolver struct {
+	cc         resolver.ClientConn
+	endpoints  []string
+	config     dnsConfig
+	lookup     func(ctx context.Context, authority, host string) ([]string, error)
+	lookupSRV  func(ctx context.Context, authority, name string) ([]*net.SRV, error)
+	resolveNow chan struct{}
+	closeChan  chan struct{}
+}
+
+func newDNSResolver(cc resolver.ClientConn, endpoints []string, config dnsConfig) *dnsResolver {
+	return &dnsResolver{
+		cc:         cc,
+		endpoints:  endpoints,
+		config:     config,
+		lookup:     lookupHost,
+		lookupSRV:  lookupSRV,
+		resolveNow: make(chan struct{}, 1),
+		closeChan:  make(chan struct{}),
+	}
+}
+
+func (r *dnsResolver) updater() {
+	var refresh <-chan time.Time
+	if r.config.refresh > 0 {
+		ticker := time.NewTicker(r.config.refresh)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+	for {
+		r.sendUpdate()
+		// the resolutions are rate limited, gRPC may ask for them on each failed connection attempt
+		select {
+		case <-time.After(r.config.minInterval):
+		case <-r.closeChan:
+			return
+		}
+		select {
+		case <-refresh:
+		case <-r.resolveNow:
+		case <-r.closeChan:
+			return
+		}
+	}
+}
+
+func (r *dnsResolver) sendUpdate() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var addrs []resolver.Address
+	var lastErr error
+	for _, e := range r.endpoints {
+		a, err := r.resolve(ctx, e)
+		if err != nil {
+			Log.Warn("Error while resolving", zap.String("endpoint", e), zap.Error(err))
+			lastErr = err
+			continue
+		}
+		addrs = append(addrs, a...)
+	}
+	if len(addrs) == 0 {
+		// the previous addresses are kept
+		r.cc.ReportError(lastErr)
+		return
+	}
+	Log.Debug("Endpoints resolved", zap.Strings("endpoints", r.endpoints), zap.Int("addresses", len(addrs)))
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+// resolve returns the addresses of an endpoint
+func (r *dnsResolver) resolve(ctx context.Context, e string) ([]resolver.Address, error) {
+	authority, name, srv := parseDNSEndpoint(e)
+	if srv {
+		records, err := r.lookupSRV(ctx, authority, name)
+		if err != nil {
+			return nil, err
+		}
+		var addrs []resolver.Address
+		for _, rec := range records {
+			a, err := r.resolveHost(ctx, authority, strings.TrimSuffix(rec.Target, "."), strconv.Itoa(int(rec.Port)))
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, a...)
+		}
+		return addrs, nil
+	}
+	host, port, err := net.SplitHostPort(name)
+	if err != nil || net.ParseIP(host) != nil {
+		return []resolver.Address{{Addr: name}}, nil
+	}
+	return r.resolveHost(ctx, authority, host, port)
+}
+
+func (r *dnsResolver) resolveHost(ctx context.Context, authority, host, port string) ([]resolver.Address, error) {
+	ips, err := r.lookup(ctx, authority, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]resolver.Address, len(ips))
+	for i, ip := range ips {
+		// the host name is kept to verify the certificate of the provider
+		addrs[i] = resolver.Address{Addr: net.JoinHostPort(ip, port), ServerName: host}
+	}
+	return addrs, nil
+}
+
+func (r *dnsResolver) ResolveNow(o resolver.ResolveNowOptions) {
+	if !r.config.resolveOnFailure {
+		return
+	}
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+func (r *dnsResolver) Close() {
+	close(r.closeChan)
+}
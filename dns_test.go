@@ -0,0 +1,110 @@
+package gorillaz
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/resolver"
+)
+
+type fakeResolverConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (c *fakeResolverConn) UpdateState(s resolver.State) {
+	c.states <- s
+}
+
+func (c *fakeResolverConn) ReportError(error) {}
+
+func TestDNSResolverResolvesAgainOnFailure(t *testing.T) {
+	assert.False(t, hasHostNames([]string{"10.0.0.1:8080", "[::1]:8080"}))
+	assert.True(t, hasHostNames([]string{"10.0.0.1:8080", "provider.svc:8080"}))
+
+	cc := &fakeResolverConn{states: make(chan resolver.State, 10)}
+	r := newDNSResolver(cc, []string{"provider.svc:8080", "10.0.0.9:9090"}, dnsConfig{resolveOnFailure: true})
+	var lookups int32
+	r.lookup = func(ctx context.Context, authority, host string) ([]string, error) {
+		if atomic.AddInt32(&lookups, 1) == 1 {
+			return []string{"10.0.0.1"}, nil
+		}
+		// failover
+		return []string{"10.0.0.2"}, nil
+	}
+	go r.updater()
+	defer r.Close()
+
+	expectAddresses := func(expected ...resolver.Address) {
+		select {
+		case s := <-cc.states:
+			assert.Equal(t, expected, s.Addresses)
+		case <-time.After(time.Second):
+			t.Fatal("endpoints not resolved")
+		}
+	}
+	expectAddresses(resolver.Address{Addr: "10.0.0.1:8080", ServerName: "provider.svc"}, resolver.Address{Addr: "10.0.0.9:9090"})
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	expectAddresses(resolver.Address{Addr: "10.0.0.2:8080", ServerName: "provider.svc"}, resolver.Address{Addr: "10.0.0.9:9090"})
+}
+
+func TestDNSResolverWithoutResolveOnFailure(t *testing.T) {
+	cc := &fakeResolverConn{states: make(chan resolver.State, 10)}
+	r := newDNSResolver(cc, []string{"provider.svc:8080"}, dnsConfig{})
+	r.lookup = func(ctx context.Context, authority, host string) ([]string, error) {
+		return []string{"10.0.0.1"}, nil
+	}
+	go r.updater()
+	defer r.Close()
+
+	<-cc.states
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	select {
+	case <-cc.states:
+		t.Error("the endpoints must not be resolved again")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDNSEndpoints(t *testing.T) {
+	assert.Equal(t, []string{"provider:8080"}, dnsEndpoints([]string{"provider:8080"}, IPEndpoint, ""))
+	assert.Equal(t, []string{"dns://10.96.0.10:53/provider:8080", "sd://other"},
+		dnsEndpoints([]string{"provider:8080", "sd://other"}, DNSEndpoint, "10.96.0.10:53"))
+	assert.Equal(t, []string{"srv:///_grpc._tcp.provider"}, dnsEndpoints([]string{"_grpc._tcp.provider"}, SRVEndpoint, ""))
+
+	authority, name, srv := parseDNSEndpoint("srv://10.96.0.10:53/_grpc._tcp.provider")
+	assert.Equal(t, "10.96.0.10:53", authority)
+	assert.Equal(t, "_grpc._tcp.provider", name)
+	assert.True(t, srv)
+	assert.True(t, hasHostNames([]string{"srv:///_grpc._tcp.provider"}))
+}
+
+func TestDNSResolverSRV(t *testing.T) {
+	cc := &fakeResolverConn{states: make(chan resolver.State, 10)}
+	r := newDNSResolver(cc, []string{"srv://10.96.0.10:53/_grpc._tcp.provider", "dns://10.96.0.10:53/other:9090"}, dnsConfig{})
+	r.lookupSRV = func(ctx context.Context, authority, name string) ([]*net.SRV, error) {
+		assert.Equal(t, "10.96.0.10:53", authority)
+		return []*net.SRV{{Target: "provider-0.provider.", Port: 8080}, {Target: "provider-1.provider.", Port: 8081}}, nil
+	}
+	r.lookup = func(ctx context.Context, authority, host string) ([]string, error) {
+		assert.Equal(t, "10.96.0.10:53", authority)
+		return map[string][]string{"provider-0.provider": {"10.0.0.1"}, "provider-1.provider": {"10.0.0.2"}, "other": {"10.0.0.3"}}[host], nil
+	}
+	go r.updater()
+	defer r.Close()
+
+	select {
+	case s := <-cc.states:
+		assert.Equal(t, []resolver.Address{
+			{Addr: "10.0.0.1:8080", ServerName: "provider-0.provider"},
+			{Addr: "10.0.0.2:8081", ServerName: "provider-1.provider"},
+			{Addr: "10.0.0.3:9090", ServerName: "other"},
+		}, s.Addresses)
+	case <-time.After(time.Second):
+		t.Fatal("endpoints not resolved")
+	}
+}
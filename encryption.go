@@ -0,0 +1,143 @@
+package gorillaz
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+// EncryptionKeyIdHeader is the header carrying the id of the key which encrypted the value of the event
+const EncryptionKeyIdHeader = "Gorillaz-Encryption-Key-Id"
+
+// ErrUnknownKey is returned by a KeyProvider which does not know the requested key
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyProvider provides the AES keys, of 16, 24 or 32 bytes, used to encrypt and decrypt the event values
+type KeyProvider interface {
+	// CurrentKey returns the key used to encrypt the new values, and its id
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given id, used to decrypt the values
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider holding its keys in memory.
+// Keys can be rotated with Rotate, the previous keys stay available to decrypt the values they encrypted.
+type StaticKeyProvider struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeyProvider returns a KeyProvider encrypting with the key of id current, and decrypting with any of the keys
+func NewStaticKeyProvider(current string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if err := p.add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := p.keys[current]; !ok {
+		return nil, fmt.Errorf("current key %s: %w", current, ErrUnknownKey)
+	}
+	p.current = current
+	return p, nil
+}
+
+func (p *StaticKeyProvider) add(id string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("invalid encryption key %s: %w", id, err)
+	}
+	p.keys[id] = key
+	return nil
+}
+
+// Rotate adds the key and uses it to encrypt the new values
+func (p *StaticKeyProvider) Rotate(id string, key []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.add(id, key); err != nil {
+		return err
+	}
+	p.current = id
+	return nil
+}
+
+func (p *StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *StaticKeyProvider) Key(id string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %s: %w", id, ErrUnknownKey)
+	}
+	return key, nil
+}
+
+// EncryptEvent returns a copy of the event whose value is encrypted with AES-GCM by the current key of the provider.
+// The id of the key is set in the EncryptionKeyIdHeader, the key of the event is authenticated but stays in clear.
+func EncryptEvent(kp KeyProvider, e *stream.Event) (*stream.Event, error) {
+	id, key, err := kp.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(e.Value)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := e.Clone()
+	out.Value = aead.Seal(nonce, nonce, e.Value, e.Key)
+	out.SetHeader(EncryptionKeyIdHeader, id)
+	return out, nil
+}
+
+// DecryptEvent returns a copy of the event whose value is decrypted with the key identified by its EncryptionKeyIdHeader.
+// Events without this header are not encrypted, they are returned as is.
+func DecryptEvent(kp KeyProvider, e *stream.Event) (*stream.Event, error) {
+	id := e.Header(EncryptionKeyIdHeader)
+	if id == "" {
+		return e, nil
+	}
+	key, err := kp.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Value) < aead.NonceSize() {
+		return nil, errors.New("encrypted value too short")
+	}
+	nonce, ciphertext := e.Value[:aead.NonceSize()], e.Value[aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, e.Key)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt value with key %s: %w", id, err)
+	}
+	out := e.Clone()
+	out.Value = value
+	delete(out.Headers, EncryptionKeyIdHeader)
+	return out, nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,51 @@
+package gorillaz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventEncryption(t *testing.T) {
+	kp, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &stream.Event{Ctx: context.Background(), Key: []byte("flight"), Value: []byte("secret position")}
+
+	encrypted, err := EncryptEvent(kp, e)
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", encrypted.Header(EncryptionKeyIdHeader))
+	assert.False(t, bytes.Contains(encrypted.Value, e.Value))
+	assert.Equal(t, "secret position", string(e.Value), "the original event must not be modified")
+
+	assert.Nil(t, kp.Rotate("k2", bytes.Repeat([]byte{2}, 16)))
+	decrypted, err := DecryptEvent(kp, encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret position", string(decrypted.Value))
+	assert.Equal(t, "", decrypted.Header(EncryptionKeyIdHeader))
+
+	encrypted.Key = []byte("another flight")
+	_, err = DecryptEvent(kp, encrypted)
+	assert.NotNil(t, err, "the key of the event is authenticated")
+
+	encrypted.Headers[EncryptionKeyIdHeader] = "unknown"
+	_, err = DecryptEvent(kp, encrypted)
+	assert.True(t, errors.Is(err, ErrUnknownKey))
+
+	plain := &stream.Event{Value: []byte("clear")}
+	decrypted, err = DecryptEvent(kp, plain)
+	assert.Nil(t, err)
+	assert.Equal(t, "clear", string(decrypted.Value))
+}
+
+func TestStaticKeyProviderRejectsInvalidKeys(t *testing.T) {
+	_, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": []byte("too short")})
+	assert.NotNil(t, err)
+	_, err = NewStaticKeyProvider("k2", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,141 @@
+package gorillaz
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/resolver"
+)
+
+// EndpointResolver discovers the providers of the endpoints "scheme://name" of the scheme it is registered with,
+// for instance in the Consul catalog or with a Kubernetes Endpoints watch, see RegisterEndpointResolver
+type EndpointResolver interface {
+	// Watch calls update with the addresses, "host:port", of the providers of the name each time they change, until ctx is done.
+	// It is called again, after a delay, if it returns before ctx is done.
+	Watch(ctx context.Context, name string, update func(addrs []string)) error
+}
+
+// EndpointResolverFunc is a function implementing EndpointResolver
+type EndpointResolverFunc func(ctx context.Context, name string, update func(addrs []string)) error
+
+func (f EndpointResolverFunc) Watch(ctx context.Context, name string, update func(addrs []string)) error {
+	return f(ctx, name, update)
+}
+
+// endpointResolverRetryDelay is the delay before watching again an endpoint whose watch returned
+const endpointResolverRetryDelay = time.Second
+
+// RegisterEndpointResolver resolves the endpoints "scheme://name" with the resolver, for instance
+// RegisterEndpointResolver("consul", &ConsulResolver{}) to consume the endpoints "consul://flights".
+// The addresses of the providers are pushed to the gRPC balancer as soon as the resolver reports them.
+func (g *Gaz) RegisterEndpointResolver(scheme string, r EndpointResolver) {
+	g.endpointResolversMu.Lock()
+	defer g.endpointResolversMu.Unlock()
+	if g.endpointResolvers == nil {
+		g.endpointResolvers = make(map[string]EndpointResolver)
+	}
+	g.endpointResolvers[scheme] = r
+}
+
+// endpointResolver returns the resolver of the endpoints, if they all have the scheme of a registered resolver
+func (g *Gaz) endpointResolver(endpoints []string) (EndpointResolver, string, bool) {
+	g.endpointResolversMu.Lock()
+	defer g.endpointResolversMu.Unlock()
+	var scheme string
+	for _, e := range endpoints {
+		i := strings.Index(e, "://")
+		if i < 0 || (scheme != "" && e[:i] != scheme) {
+			return nil, "", false
+		}
+		scheme = e[:i]
+	}
+	r, ok := g.endpointResolvers[scheme]
+	return r, scheme, ok
+}
+
+// pluggableResolver is a
+// Resolver(https://godoc.org/google.golang.org/grpc/resolver#Resolver)
+// watching the endpoints with an EndpointResolver, the addresses of all the endpoints are merged
+type pluggableResolver struct {
+	cc        resolver.ClientConn
+	resolver  EndpointResolver
+	names     []string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	addresses map[string][]string // addresses are the addresses of the providers, by endpoint name
+}
+
+func newPluggableResolver(cc resolver.ClientConn, r EndpointResolver, scheme string, endpoints []string) *pluggableResolver {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pluggableResolver{
+		cc:        cc,
+		resolver:  r,
+		ctx:       ctx,
+		cancel:    cancel,
+		addresses: make(map[string][]string, len(endpoints)),
+	}
+	for _, e := range endpoints {
+		p.names = append(p.names, strings.TrimPrefix(e, scheme+"://"))
+	}
+	return p
+}
+
+func (p *pluggableResolver) start(g *Gaz) {
+	for _, name := range p.names {
+		name := name
+		g.goTracked("endpoint_resolver", name, func() {
+			p.watch(name)
+		})
+	}
+}
+
+// watch watches the endpoint until the resolver is closed
+func (p *pluggableResolver) watch(name string) {
+	for {
+		err := p.resolver.Watch(p.ctx, name, func(addrs []string) {
+			p.update(name, addrs)
+		})
+		if p.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			Log.Warn("Error while watching the endpoint", zap.String("name", name), zap.Error(err))
+			p.cc.ReportError(err)
+		}
+		select {
+		case <-time.After(endpointResolverRetryDelay):
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *pluggableResolver) update(name string, addrs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx.Err() != nil {
+		return
+	}
+	p.addresses[name] = addrs
+	var state resolver.State
+	for _, n := range p.names {
+		for _, a := range p.addresses[n] {
+			state.Addresses = append(state.Addresses, resolver.Address{Addr: a})
+		}
+	}
+	Log.Debug("Endpoints resolved", zap.Strings("endpoints", p.names), zap.Int("addresses", len(state.Addresses)))
+	p.cc.UpdateState(state)
+}
+
+// ResolveNow does nothing, the resolver pushes the updates of the addresses as soon as they happen
+func (*pluggableResolver) ResolveNow(o resolver.ResolveNowOptions) {}
+
+func (p *pluggableResolver) Close() {
+	p.mu.Lock()
+	p.cancel()
+	p.mu.Unlock()
+}
@@ -0,0 +1,99 @@
+package gorillaz
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/resolver"
+)
+
+func expectState(t *testing.T, cc *fakeResolverConn, expected ...string) {
+	t.Helper()
+	select {
+	case s := <-cc.states:
+		var addrs []string
+		for _, a := range s.Addresses {
+			addrs = append(addrs, a.Addr)
+		}
+		assert.Equal(t, expected, addrs)
+	case <-time.After(time.Second):
+		t.Fatal("endpoints not resolved")
+	}
+}
+
+func TestPluggableResolver(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	updates := make(chan func(addrs []string), 2)
+	g.RegisterEndpointResolver("fake", EndpointResolverFunc(func(ctx context.Context, name string, update func(addrs []string)) error {
+		updates <- update
+		<-ctx.Done()
+		return nil
+	}))
+	_, _, ok := g.endpointResolver([]string{"fake://flights", "other://weather"})
+	assert.False(t, ok, "the endpoints of different schemes are not resolved together")
+	r, scheme, ok := g.endpointResolver([]string{"fake://flights"})
+	assert.True(t, ok)
+
+	cc := &fakeResolverConn{states: make(chan resolver.State, 10)}
+	pr := newPluggableResolver(cc, r, scheme, []string{"fake://flights"})
+	pr.start(g)
+	defer pr.Close()
+
+	update := <-updates
+	update([]string{"10.0.0.1:8080"})
+	expectState(t, cc, "10.0.0.1:8080")
+	update([]string{"10.0.0.1:8080", "10.0.0.2:8080"})
+	expectState(t, cc, "10.0.0.1:8080", "10.0.0.2:8080")
+}
+
+func TestConsulResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/health/service/flights", r.URL.Path)
+		assert.Equal(t, "prod", r.URL.Query().Get("tag"))
+		// the queries block on the last index, the port of the second instance changes each time
+		index, _ := strconv.Atoi(r.URL.Query().Get("index"))
+		w.Header().Set("X-Consul-Index", strconv.Itoa(index+1))
+		_, _ = fmt.Fprintf(w, `[{"Node":{"Address":"10.0.0.1"},"Service":{"Address":"","Port":8080}},
+			{"Node":{"Address":"10.0.0.2"},"Service":{"Address":"10.0.1.2","Port":%d}}]`, 8081+index)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var updates [][]string
+	err := (&ConsulResolver{Addr: srv.URL, Tag: "prod"}).Watch(ctx, "flights", func(addrs []string) {
+		updates = append(updates, addrs)
+		if len(updates) == 2 {
+			cancel()
+		}
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, [][]string{{"10.0.0.1:8080", "10.0.1.2:8081"}, {"10.0.0.1:8080", "10.0.1.2:8082"}}, updates)
+}
+
+func TestKubernetesResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/namespaces/atm/endpoints", r.URL.Path)
+		assert.Equal(t, "metadata.name=flights", r.URL.Query().Get("fieldSelector"))
+		_, _ = fmt.Fprintln(w, `{"type":"ADDED","object":{"subsets":[{"addresses":[{"ip":"10.0.0.1"}],"ports":[{"name":"http","port":8080},{"name":"grpc","port":9000}]}]}}`)
+		_, _ = fmt.Fprintln(w, `{"type":"MODIFIED","object":{"subsets":[{"addresses":[{"ip":"10.0.0.1"},{"ip":"10.0.0.2"}],"ports":[{"name":"grpc","port":9000}]}]}}`)
+		_, _ = fmt.Fprintln(w, `{"type":"DELETED","object":{}}`)
+	}))
+	defer srv.Close()
+
+	var updates [][]string
+	err := (&KubernetesResolver{APIServer: srv.URL, Client: srv.Client()}).Watch(context.Background(), "atm/flights:grpc", func(addrs []string) {
+		updates = append(updates, addrs)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"10.0.0.1:9000"}, {"10.0.0.1:9000", "10.0.0.2:9000"}, nil}, updates)
+
+	_, _, _, err = parseKubernetesName("flights")
+	assert.NotNil(t, err)
+}
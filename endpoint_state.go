@@ -0,0 +1,88 @@
+package gorillaz
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	StreamEndpointState            = "stream_endpoint_state"
+	StreamEndpointStateTransitions = "stream_endpoint_state_transitions"
+
+	StreamEndpointStateLabel = "state"
+)
+
+type endpointStateMetrics struct {
+	stateGauge         *prometheus.GaugeVec
+	transitionsCounter *prometheus.CounterVec
+}
+
+// map of metrics registered to Prometheus, by endpoint target
+var endpointStateMetricsMu sync.Mutex
+var endpointStateMonitorings = make(map[string]*endpointStateMetrics)
+
+func endpointStateMonitoring(g *Gaz, target string) *endpointStateMetrics {
+	endpointStateMetricsMu.Lock()
+	defer endpointStateMetricsMu.Unlock()
+
+	if m, ok := endpointStateMonitorings[target]; ok {
+		return m
+	}
+	labels := prometheus.Labels{StreamEndpointsLabel: target}
+	m := &endpointStateMetrics{
+		stateGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        StreamEndpointState,
+			Help:        "1 for the current gRPC connectivity state of the stream endpoint, such as READY or TRANSIENT_FAILURE, otherwise 0",
+			ConstLabels: labels,
+		}, []string{StreamEndpointStateLabel}),
+		transitionsCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        StreamEndpointStateTransitions,
+			Help:        "The total number of transitions of the stream endpoint to the gRPC connectivity state",
+			ConstLabels: labels,
+		}, []string{StreamEndpointStateLabel}),
+	}
+	g.prometheusRegistry.MustRegister(m.stateGauge)
+	g.prometheusRegistry.MustRegister(m.transitionsCounter)
+	endpointStateMonitorings[target] = m
+	return m
+}
+
+var connectivityStates = []connectivity.State{connectivity.Idle, connectivity.Connecting, connectivity.Ready, connectivity.TransientFailure, connectivity.Shutdown}
+
+// set sets the gauge of the state to 1 and the gauges of the other states to 0
+func (m *endpointStateMetrics) set(state connectivity.State) {
+	for _, s := range connectivityStates {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		m.stateGauge.WithLabelValues(s.String()).Set(v)
+	}
+}
+
+// watchState monitors the connectivity state of the connection of the endpoint and logs its changes, until ctx is done
+// or the connection is shut down.
+// gRPC does not give the reason of a state change, the errors of the connection are logged by gRPC itself.
+func (se *streamEndpoint) watchState(ctx context.Context) {
+	metrics := endpointStateMonitoring(se.g, se.target)
+	state := se.conn.GetState()
+	metrics.set(state)
+	for state != connectivity.Shutdown && se.conn.WaitForStateChange(ctx, state) {
+		previous := state
+		state = se.conn.GetState()
+		metrics.set(state)
+		metrics.transitionsCounter.WithLabelValues(state.String()).Inc()
+		if state == connectivity.TransientFailure {
+			Log.Warn("Stream endpoint connection failed", zap.String("target", se.target), zap.Stringer("previous state", previous))
+		} else {
+			Log.Info("Stream endpoint connection state changed", zap.String("target", se.target),
+				zap.Stringer("previous state", previous), zap.Stringer("state", state))
+		}
+	}
+	// the endpoint is closed, its state is no longer exported
+	metrics.stateGauge.Reset()
+}
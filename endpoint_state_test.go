@@ -0,0 +1,31 @@
+package gorillaz
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestEndpointStateMetrics(t *testing.T) {
+	g := New(WithServiceName("test"))
+	defer g.Shutdown()
+	<-g.Run()
+	if _, err := g.NewStreamProvider("endpoint-state", "dummy.type"); err != nil {
+		t.Fatal(err)
+	}
+	target := fmt.Sprintf("localhost:%d", g.GrpcPort())
+	consumer, err := g.ConsumeStream([]string{target}, "endpoint-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+
+	ready := map[string]string{StreamEndpointsLabel: target, StreamEndpointStateLabel: connectivity.Ready.String()}
+	waitUntil(t, 5*time.Second, "the endpoint must be exported as ready", func() bool {
+		m, err := findMetric(g, StreamEndpointState, ready)
+		return err == nil && m.Gauge.GetValue() == 1
+	})
+	assertCounterEquals(t, g, ready, StreamEndpointStateTransitions, 1)
+}
@@ -0,0 +1,119 @@
+package gorillaz
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// ErrStreamEnded is the error of the ConsumerErrorEvent of a stream ended by its provider
+var ErrStreamEnded = errors.New("stream ended by the provider")
+
+// ErrStateUpdateLost is the error of a ConsumerErrorEvent of a GetAndWatch consumer which received a state update it could not deliver,
+// such as an oversize update or one it could not decrypt. Its state is incomplete, it is received again once resubscribed.
+var ErrStateUpdateLost = errors.New("state update received but not delivered")
+
+// ConsumerErrorEvent is emitted on the ErrChan of a consumer in error events mode when it fails, see WithErrorEvents
+type ConsumerErrorEvent struct {
+	StreamName string
+	Err        error     // Err is the failure, such as ErrReconnectAttemptsExhausted, ErrStreamEnded or ErrStateUpdateLost
+	Time       time.Time // Time is when the consumer failed
+}
+
+// WithErrorEvents emits a ConsumerErrorEvent on the ErrChan of the consumer when it fails, instead of closing its EvtChan.
+// The EvtChan stays open, the application then calls Resubscribe to consume the stream again, or Stop to close it.
+// The failures are not emitted if the EvtChan was closed by the application or the connection of the endpoint was closed.
+func WithErrorEvents() ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.ErrorEvents = true
+	}
+}
+
+// errorEvents holds the error events of a consumer, nil if the mode is disabled
+type errorEvents struct {
+	ch          chan *ConsumerErrorEvent
+	resubscribe chan struct{}
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+func newErrorEvents(config *ConsumerConfig) *errorEvents {
+	if !config.ErrorEvents {
+		return nil
+	}
+	return &errorEvents{
+		ch:          make(chan *ConsumerErrorEvent, 1),
+		resubscribe: make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+}
+
+func (e *errorEvents) errChan() <-chan *ConsumerErrorEvent {
+	if e == nil {
+		return nil
+	}
+	return e.ch
+}
+
+// stopped wakes up the consumer waiting for a decision of the application
+func (e *errorEvents) stopped() {
+	if e == nil {
+		return
+	}
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+}
+
+// requestResubscribe returns false if the consumer is not waiting for a decision of the application
+func (e *errorEvents) requestResubscribe() bool {
+	if e == nil {
+		return false
+	}
+	select {
+	case e.resubscribe <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// await is called by the goroutine of the consumer when its run loop ends.
+// It emits the failure and returns true if the application asked to resubscribe, false if the consumer must be closed.
+func (e *errorEvents) await(g *Gaz, streamName string, conn *grpc.ClientConn, guard *evtChanGuard, stopped func() bool) bool {
+	if e == nil || stopped() || conn.GetState() == connectivity.Shutdown {
+		return false
+	}
+	err := guard.Err()
+	if err == ErrEvtChanClosed {
+		return false
+	}
+	if err == nil {
+		err = ErrStreamEnded
+	}
+	Log.Warn("Stream failed, waiting for the application to resubscribe", zap.String("stream", streamName), zap.Error(err))
+	select {
+	case e.ch <- &ConsumerErrorEvent{StreamName: streamName, Err: err, Time: g.Clock().Now()}:
+	case <-e.stop:
+		return false
+	}
+	select {
+	case <-e.resubscribe:
+		guard.reset()
+		Log.Info("Stream resubscribed", zap.String("stream", streamName))
+		return true
+	case <-e.stop:
+		return false
+	}
+}
+
+// close closes the error channel once the consumer is closed
+func (e *errorEvents) close() {
+	if e != nil {
+		close(e.ch)
+	}
+}
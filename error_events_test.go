@@ -0,0 +1,62 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestErrorEvents(t *testing.T) {
+	assert.Nil(t, newErrorEvents(defaultConsumerConfig()).errChan(), "the error events are disabled by default")
+
+	config := defaultConsumerConfig()
+	WithErrorEvents()(config)
+	e := newErrorEvents(config)
+	g := &Gaz{}
+	conn, err := grpc.Dial("localhost:1", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	guard := &evtChanGuard{}
+	notStopped := func() bool { return false }
+
+	awaitAsync := func() chan bool {
+		result := make(chan bool, 1)
+		go func() {
+			result <- e.await(g, "failing", conn, guard, notStopped)
+		}()
+		return result
+	}
+	expectErrorEvent := func(expected error) {
+		t.Helper()
+		select {
+		case evt := <-e.errChan():
+			assert.Equal(t, "failing", evt.StreamName)
+			assert.Equal(t, expected, evt.Err)
+		case <-time.After(time.Second):
+			t.Fatal("error event not emitted")
+		}
+	}
+
+	// the application resubscribes after the failure
+	guard.fail(ErrReconnectAttemptsExhausted)
+	result := awaitAsync()
+	expectErrorEvent(ErrReconnectAttemptsExhausted)
+	waitUntil(t, time.Second, "resubscribed", e.requestResubscribe)
+	assert.True(t, <-result)
+	assert.Nil(t, guard.Err())
+	assert.False(t, e.requestResubscribe(), "the consumer is not waiting for a decision anymore")
+
+	// the application stops the consumer after the stream ended
+	result = awaitAsync()
+	expectErrorEvent(ErrStreamEnded)
+	e.stopped()
+	assert.False(t, <-result)
+
+	// a channel closed by the application cannot be resubscribed
+	guard.fail(ErrEvtChanClosed)
+	assert.False(t, e.await(g, "failing", conn, guard, notStopped))
+}
@@ -0,0 +1,141 @@
+package gorillaz
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+const (
+	StreamEventSentByType              = "stream_event_sent_by_type"
+	StreamConsumerReceivedEventsByType = "stream_consumer_received_events_by_type"
+	StreamConsumerDelayMsByType        = "stream_consumer_delay_ms_by_type"
+)
+
+const EventTypeLabel = "event_type"
+
+// OtherEventType is the label value of the event types which are not in the allowlist
+const OtherEventType = "other"
+
+// ProviderEventTypeMetrics breaks down the events sent by the provider by event type.
+// Only the given event types get their own label value, the others are counted as OtherEventType,
+// so that the cardinality of the metrics stays bounded.
+func ProviderEventTypeMetrics(eventTypes ...string) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.EventTypeMetrics = eventTypes
+	}
+}
+
+// WithEventTypeMetrics breaks down the events received and their delays by event type.
+// Only the given event types get their own label value, the others are counted as OtherEventType.
+func WithEventTypeMetrics(eventTypes ...string) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.EventTypeMetrics = eventTypes
+	}
+}
+
+// eventTypeMetrics counts the events by type, a nil eventTypeMetrics counts nothing
+type eventTypeMetrics struct {
+	allowed map[string]struct{}
+	counter *prometheus.CounterVec
+	delay   *prometheus.SummaryVec
+}
+
+func (m *eventTypeMetrics) label(eventType string) string {
+	if _, ok := m.allowed[eventType]; ok {
+		return eventType
+	}
+	return OtherEventType
+}
+
+func (m *eventTypeMetrics) sent(eventType string) {
+	if m == nil {
+		return
+	}
+	m.counter.WithLabelValues(m.label(eventType)).Inc()
+}
+
+func (m *eventTypeMetrics) received(metadata *stream.Metadata) {
+	if m == nil || metadata == nil {
+		return
+	}
+	l := m.label(metadata.EventType)
+	m.counter.WithLabelValues(l).Inc()
+	if metadata.StreamTimestamp > 0 {
+		nowMs := float64(time.Now().UnixNano()) / 1000000.0
+		m.delay.WithLabelValues(l).Observe(math.Max(0, nowMs-float64(metadata.StreamTimestamp)/1000000.0))
+	}
+}
+
+// map of the metrics registered to Prometheus, by stream
+// the allowlist of the first provider or consumer of a stream applies to the others
+var eventTypeMetricsMu sync.Mutex
+var providerEventTypeMetrics = make(map[string]*eventTypeMetrics)
+var consumerEventTypeMetrics = make(map[string]*eventTypeMetrics)
+
+func newEventTypeMetrics(eventTypes []string) *eventTypeMetrics {
+	m := &eventTypeMetrics{allowed: make(map[string]struct{}, len(eventTypes))}
+	for _, t := range eventTypes {
+		m.allowed[t] = struct{}{}
+	}
+	return m
+}
+
+// providerEventTypeMonitoring returns the metrics by event type of the provider, nil if eventTypes is empty
+func providerEventTypeMonitoring(g *Gaz, streamName string, eventTypes []string) *eventTypeMetrics {
+	if len(eventTypes) == 0 {
+		return nil
+	}
+	eventTypeMetricsMu.Lock()
+	defer eventTypeMetricsMu.Unlock()
+
+	if m, ok := providerEventTypeMetrics[streamName]; ok {
+		return m
+	}
+	m := newEventTypeMetrics(eventTypes)
+	m.counter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        StreamEventSentByType,
+		Help:        "The total number of messages sent, by event type",
+		ConstLabels: prometheus.Labels{StreamNameLabel: streamName},
+	}, []string{EventTypeLabel})
+	g.prometheusRegistry.MustRegister(m.counter)
+	providerEventTypeMetrics[streamName] = m
+	return m
+}
+
+// consumerEventTypeMonitoring returns the metrics by event type of the consumer, nil if eventTypes is empty
+func consumerEventTypeMonitoring(g *Gaz, streamName string, endpoints []string, eventTypes []string) *eventTypeMetrics {
+	if len(eventTypes) == 0 {
+		return nil
+	}
+	eventTypeMetricsMu.Lock()
+	defer eventTypeMetricsMu.Unlock()
+
+	if m, ok := consumerEventTypeMetrics[streamName]; ok {
+		return m
+	}
+	labels := prometheus.Labels{
+		StreamNameLabel:      streamName,
+		StreamEndpointsLabel: strings.Join(endpoints, ","),
+	}
+	m := newEventTypeMetrics(eventTypes)
+	m.counter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        StreamConsumerReceivedEventsByType,
+		Help:        "The total number of events received, by event type",
+		ConstLabels: labels,
+	}, []string{EventTypeLabel})
+	m.delay = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name:        StreamConsumerDelayMsByType,
+		Help:        "distribution of delay between when messages are sent and when they are received, by event type, in milliseconds",
+		Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		ConstLabels: labels,
+	}, []string{EventTypeLabel})
+	g.prometheusRegistry.MustRegister(m.counter)
+	g.prometheusRegistry.MustRegister(m.delay)
+	consumerEventTypeMetrics[streamName] = m
+	return m
+}
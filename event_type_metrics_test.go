@@ -0,0 +1,42 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventTypeMetrics(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	assert.Nil(t, providerEventTypeMonitoring(g, "typed", nil))
+
+	p := providerEventTypeMonitoring(g, "typed", []string{"created", "updated"})
+	assert.True(t, p == providerEventTypeMonitoring(g, "typed", []string{"deleted"}), "the metrics are registered once per stream")
+	p.sent("created")
+	p.sent("created")
+	p.sent("deleted")
+	p.sent("")
+	var disabled *eventTypeMetrics
+	disabled.sent("created")
+
+	m, err := findMetric(g, StreamEventSentByType, map[string]string{StreamNameLabel: "typed", EventTypeLabel: "created"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, *m.Counter.Value)
+	m, err = findMetric(g, StreamEventSentByType, map[string]string{StreamNameLabel: "typed", EventTypeLabel: OtherEventType})
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, *m.Counter.Value)
+
+	c := consumerEventTypeMonitoring(g, "typed", []string{"localhost:1234"}, []string{"created"})
+	c.received(&stream.Metadata{EventType: "created", StreamTimestamp: time.Now().Add(-time.Second).UnixNano()})
+	c.received(nil)
+	m, err = findMetric(g, StreamConsumerReceivedEventsByType, map[string]string{StreamNameLabel: "typed", EventTypeLabel: "created"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, *m.Counter.Value)
+	m, err = findMetric(g, StreamConsumerDelayMsByType, map[string]string{StreamNameLabel: "typed", EventTypeLabel: "created"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), *m.Summary.SampleCount)
+	assert.True(t, *m.Summary.SampleSum >= 1000)
+}
@@ -0,0 +1,53 @@
+package gorillaz
+
+import (
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ErrEvtChanClosed is the error of a consumer whose event channel was closed by the application.
+// The event channel belongs to gorillaz, it is closed when the consumer stops.
+var ErrEvtChanClosed = errors.New("event channel closed by the application, call Stop to stop the consumer instead")
+
+// evtChanGuard records the misuse of the event channel of a consumer, instead of crashing the process
+type evtChanGuard struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (g *evtChanGuard) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// fail stops the consumer with the error, unless it already failed
+func (g *evtChanGuard) fail(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// reset clears the failure of a consumer resubscribed by the application, see WithErrorEvents
+func (g *evtChanGuard) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.err = nil
+}
+
+// recoverClosed must be deferred by the functions writing to or closing the event channel, and only by them,
+// so that the panic it recovers comes from a channel closed by the application
+func (g *evtChanGuard) recoverClosed(streamName string) {
+	if r := recover(); r != nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.err == nil {
+			Log.Error("the event channel of the consumer was closed by the application, stopping the consumer", zap.String("stream", streamName), zap.Any("panic", r))
+			g.err = ErrEvtChanClosed
+		}
+	}
+}
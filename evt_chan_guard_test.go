@@ -0,0 +1,30 @@
+package gorillaz
+
+import (
+	"testing"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendOnEvtChanClosedByApplication(t *testing.T) {
+	c := &consumer{streamName: "guarded", evtChan: make(chan *stream.Event, 1), guard: &evtChanGuard{}}
+	assert.True(t, c.send(&stream.Event{Key: []byte("k")}))
+	assert.Nil(t, c.Err())
+
+	close(c.evtChan)
+	assert.False(t, c.send(&stream.Event{Key: []byte("k")}))
+	assert.Equal(t, ErrEvtChanClosed, c.Err())
+
+	// closing the channel on stop must not panic either
+	c.closeEvtChan()
+	assert.Equal(t, ErrEvtChanClosed, c.Err())
+}
+
+func TestCloseEvtChanOnStop(t *testing.T) {
+	c := &getAndWatchConsumer{streamName: "guarded", evtChan: make(chan *stream.GetAndWatchEvent, 1), guard: &evtChanGuard{}}
+	c.closeEvtChan()
+	_, ok := <-c.evtChan
+	assert.False(t, ok)
+	assert.Nil(t, c.Err())
+}
@@ -2,6 +2,7 @@ package gorillaz
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"strings"
 	"sync/atomic"
@@ -11,16 +12,26 @@ import (
 	"github.com/skysoft-atm/gorillaz/stream"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
-	"google.golang.org/grpc/encoding/gzip"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
 )
 
 type GetAndWatchStreamConsumer interface {
 	streamConsumer
+	// EvtChan returns the channel of the events, it is closed by gorillaz when the consumer stops and must not be closed by the application
 	EvtChan() chan *stream.GetAndWatchEvent
 	Stop() bool //return previous 'stopped' state
+	// Err returns the error that stopped the consumer, such as ErrEvtChanClosed, nil if it has not failed
+	Err() error
+	// Capabilities returns the capabilities negotiated with the provider of the stream, version 0 before the first connection
+	Capabilities() Capabilities
+	// LastEventTime returns when the last event of the stream was received, the zero time if none was received
+	LastEventTime() time.Time
+	// ErrChan returns the channel of the failures of a consumer created WithErrorEvents, nil otherwise
+	ErrChan() <-chan *ConsumerErrorEvent
+	// Resubscribe consumes the stream again after a failure emitted on ErrChan, it returns false if the consumer is not waiting for it
+	Resubscribe() bool
 }
 
 type registeredGetAndWatchConsumer struct {
@@ -39,28 +50,103 @@ func (c *registeredGetAndWatchConsumer) Stop() bool {
 }
 
 type getAndWatchConsumer struct {
-	endpoint   *streamEndpoint
-	streamName string
-	evtChan    chan *stream.GetAndWatchEvent
-	config     *ConsumerConfig
-	stopped    *int32
-	cMetrics   *consumerMetrics
+	endpoint    *streamEndpoint
+	conn        *grpc.ClientConn // conn is the connection of the endpoint the stream is consumed on
+	streamName  string
+	evtChan     chan *stream.GetAndWatchEvent
+	config      *ConsumerConfig
+	stopped     *int32
+	done        chan struct{} // done is closed when the consumer is stopped
+	cMetrics    *consumerMetrics
+	tMetrics    *eventTypeMetrics
+	traffic     *trafficMetrics
+	ordering    *OrderingChecker
+	guard       *evtChanGuard
+	backoff     *reconnectBackoff
+	peerCaps    *peerCapabilities
+	errEvents   *errorEvents
+	authRetried bool // authRetried is true after retrying with a refreshed token, until the stream connects
+	compression *compressionNegotiation
 }
 
 func (c *getAndWatchConsumer) streamEndpoint() *streamEndpoint {
 	return c.endpoint
 }
 
+func (c *getAndWatchConsumer) clientConn() *grpc.ClientConn {
+	return c.conn
+}
+
 func (c *getAndWatchConsumer) StreamName() string {
 	return c.streamName
 }
 
+func (c *getAndWatchConsumer) LastEventTime() time.Time {
+	return c.cMetrics.lastEventTime()
+}
+
+func (c *getAndWatchConsumer) Capabilities() Capabilities {
+	return c.peerCaps.get()
+}
+
 func (c *getAndWatchConsumer) EvtChan() chan *stream.GetAndWatchEvent {
 	return c.evtChan
 }
 
+func (c *getAndWatchConsumer) Err() error {
+	return c.guard.Err()
+}
+
+func (c *getAndWatchConsumer) ErrChan() <-chan *ConsumerErrorEvent {
+	return c.errEvents.errChan()
+}
+
+func (c *getAndWatchConsumer) Resubscribe() bool {
+	return c.errEvents.requestResubscribe()
+}
+
+// send delivers the event to the application, it returns false if the application closed the event channel
+func (c *getAndWatchConsumer) send(evt *stream.GetAndWatchEvent) (sent bool) {
+	defer c.guard.recoverClosed(c.streamName)
+	c.evtChan <- evt
+	return true
+}
+
+func (c *getAndWatchConsumer) closeEvtChan() {
+	defer c.guard.recoverClosed(c.streamName)
+	close(c.evtChan)
+}
+
+// checkEventSize returns an error if the event received exceeds the maximum event size of the consumer
+func (c *getAndWatchConsumer) checkEventSize(size int, key []byte) error {
+	err := checkEventSize(size, c.config.MaxEventSize)
+	if err != nil {
+		Log.Warn("oversize event not delivered", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), RedactedKey(key), zap.Error(err))
+		oversizeEventsCounter(c.endpoint.g, StreamConsumerOversizeEvents, StreamNameLabel, c.streamName).Inc()
+	}
+	return err
+}
+
+// updateLost records a state update received but not delivered, the state of the consumer lacks it.
+// It returns false if the consumer must stop: with error events, it fails with ErrStateUpdateLost
+// and the application resubscribes to receive the state again.
+func (c *getAndWatchConsumer) updateLost(key []byte, err error) bool {
+	c.cMetrics.lostUpdatesCounter.Inc()
+	if c.errEvents == nil {
+		Log.Error("state update not delivered, the state of the consumer is incomplete", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), RedactedKey(key), zap.Error(err))
+		return true
+	}
+	c.guard.fail(fmt.Errorf("%w: %v", ErrStateUpdateLost, err))
+	return false
+}
+
 func (c *getAndWatchConsumer) Stop() bool {
-	return atomic.SwapInt32(c.stopped, 1) == 1
+	if atomic.SwapInt32(c.stopped, 1) == 1 {
+		return true
+	}
+	close(c.done)
+	c.errEvents.stopped()
+	return false
 }
 
 func (c *getAndWatchConsumer) isStopped() bool {
@@ -75,7 +161,12 @@ func (c *getAndWatchConsumer) metrics() *consumerMetrics {
 // The service name is resolved via service discovery
 // Under the hood we make sure that only 1 subscription is done for a service, even if multiple streams are created on the same service
 func (g *Gaz) GetAndWatchStream(service, stream string, opts ...ConsumerConfigOpt) (GetAndWatchStreamConsumer, error) {
-	return g.createGetAndWatchConsumer([]string{SdPrefix + service}, stream, opts...)
+	return g.createGetAndWatchConsumer([]string{SdPrefix + service}, g.tenantStreamName(stream), opts...)
+}
+
+// Call this method to create a GetAndWatch stream consumer with the service endpoints and the stream name
+func (g *Gaz) ConsumeGetAndWatchStream(endpoints []string, stream string, opts ...ConsumerConfigOpt) (GetAndWatchStreamConsumer, error) {
+	return g.createGetAndWatchConsumer(endpoints, g.tenantStreamName(stream), opts...)
 }
 
 func (g *Gaz) createGetAndWatchConsumer(endpoints []string, streamName string, opts ...ConsumerConfigOpt) (GetAndWatchStreamConsumer, error) {
@@ -112,26 +203,46 @@ func (se *streamEndpoint) getAndWatch(streamName string, opts ...ConsumerConfigO
 
 	ch := make(chan *stream.GetAndWatchEvent, config.BufferLen)
 	c := &getAndWatchConsumer{
-		endpoint:   se,
-		streamName: streamName,
-		evtChan:    ch,
-		config:     config,
-		stopped:    new(int32),
-		cMetrics:   consumerMonitoring(se.g, streamName, se.endpoints),
+		endpoint:    se,
+		conn:        se.pickConn(),
+		streamName:  streamName,
+		evtChan:     ch,
+		config:      config,
+		stopped:     new(int32),
+		done:        make(chan struct{}),
+		cMetrics:    consumerMonitoring(se.g, streamName, se.endpoints),
+		tMetrics:    consumerEventTypeMonitoring(se.g, streamName, se.endpoints, config.EventTypeMetrics),
+		traffic:     consumerTrafficMonitoring(se.g, streamName),
+		ordering:    newConsumerOrderingChecker(se.g, streamName, config.OrderingCheck),
+		guard:       &evtChanGuard{},
+		peerCaps:    &peerCapabilities{},
+		errEvents:   newErrorEvents(config),
+		compression: newCompressionNegotiation(config),
 	}
+	c.backoff = newReconnectBackoff(config.ReconnectBackoff, se.g.Clock(), c.done)
 
-	go func() {
+	se.g.goTracked("getandwatch_consumer", streamName, func() {
 		c.reconnectGetAndWatchWhileNotStopped()
+		for c.errEvents.await(se.g, streamName, c.conn, c.guard, c.isStopped) {
+			c.backoff.reset()
+			c.reconnectGetAndWatchWhileNotStopped()
+		}
 		Log.Info("Stream closed", zap.String("stream", c.streamName))
-		close(c.evtChan)
-	}()
+		c.closeEvtChan()
+		c.errEvents.close()
+		releaseConsumerMonitoring(se.g, streamName, config.KeepMetrics)
+	})
 	return c
 }
 
 func (c *getAndWatchConsumer) reconnectGetAndWatchWhileNotStopped() {
-	for c.endpoint.conn.GetState() != connectivity.Shutdown && !c.isStopped() {
+	for c.conn.GetState() != connectivity.Shutdown && !c.isStopped() && c.Err() == nil {
+		if !c.endpoint.breaker.Allow() {
+			c.endpoint.waitForCircuit(c.streamName, c.done)
+			continue
+		}
 		waitTillConnReadyOrShutdown(c)
-		if c.endpoint.conn.GetState() == connectivity.Shutdown {
+		if c.conn.GetState() == connectivity.Shutdown {
 			break
 		}
 		retry := c.readGetAndWatchStream()
@@ -142,55 +253,82 @@ func (c *getAndWatchConsumer) reconnectGetAndWatchWhileNotStopped() {
 }
 
 func (c *getAndWatchConsumer) readGetAndWatchStream() (retry bool) {
-	client := stream.NewStreamClient(c.endpoint.conn)
+	client := stream.NewStreamClient(c.conn)
 	req := &stream.GetAndWatchRequest{
 		Name:                     c.streamName,
 		RequesterName:            c.endpoint.g.ServiceName,
 		ExpectHello:              true,
 		DisconnectOnBackpressure: c.config.DisconnectOnBackpressure,
+		WatchKeys:                c.config.WatchKeys,
+		WatchKeyPrefixes:         c.config.WatchKeyPrefixes,
+		KeyExpression:            c.config.KeyExpression,
 	}
 
-	var callOpts []grpc.CallOption
-	if c.config.UseGzip {
-		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
-	}
-	callOpts = append(callOpts, grpc.CallContentSubtype(StreamEncoding))
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	ctx, callOpts, compressionMd := c.compression.callOptions(ctx)
+	callOpts = append(callOpts, grpc.CallContentSubtype(StreamEncoding))
+	callOpts = append(callOpts, c.endpoint.credentialsCallOptions()...)
+	md, err := c.config.callMetadata()
+	if err != nil {
+		Log.Warn("Error while getting the metadata of the stream request", zap.String("stream", c.streamName), zap.Error(err))
+		c.backoff.wait(c.guard, c.streamName, 0)
+		return true
+	}
+	ctx, cancelConnect, established := c.config.connectDeadline(ctx)
+	defer cancelConnect()
+	defer established()
+	ctx = metadata.NewOutgoingContext(ctx, metadata.Join(md, supportedCapabilities.metadata(), deltaMetadata(c.config), compressionMd))
 
 	st, err := client.GetAndWatch(ctx, req, callOpts...)
 	if err != nil {
+		c.endpoint.breaker.Failure()
 		Log.Warn("Error while creating stream", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
+		if !c.endpoint.retryWithRefreshedToken(err, &c.authRetried) {
+			c.backoff.wait(c.guard, c.streamName, 0)
+		}
 		return true
 	}
+	defer c.endpoint.cancelOnStop(c.streamName, c.done, cancel, nil)()
 
 	//without this hack we do not know if the stream is really connected
 	mds, err := st.Header()
 	if err == nil && mds != nil {
+		established()
+		c.authRetried = false
+		c.endpoint.breaker.Success()
+		c.peerCaps.set(capabilitiesFromMetadata(mds))
+		c.compression.update(mds)
 
 		if c.config.OnConnected != nil {
 			c.config.OnConnected(c.streamName)
 		}
 		Log.Debug("Stream connected", zap.String("streamName", c.streamName), zap.String("target", c.endpoint.target))
+		c.backoff.reset()
 
 		// at this point, the GRPC connection is established with the server
+		deltas := newDeltaDecoder(c.config.DeltaEncoding)
 		for !c.isStopped() {
 			c.cMetrics.conGauge.Set(1)
 			gwEvt, err := st.Recv()
 
 			if err != nil {
+				if c.isStopped() {
+					c.cMetrics.conGauge.Set(0)
+					return false
+				}
 				if err == io.EOF {
 					Log.Info("received EOF, stream closed", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target))
 					c.cMetrics.conGauge.Set(0)
 					return false //standard error for closed stream
 				}
 				Log.Warn("received error on stream", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
-				if e, ok := status.FromError(err); ok {
-					switch e.Code() {
-					case codes.PermissionDenied, codes.ResourceExhausted, codes.Unavailable,
-						codes.Unimplemented, codes.NotFound, codes.Unauthenticated, codes.Unknown:
-						time.Sleep(5 * time.Second)
-					}
+				// a provider accepting streams then failing them is flapping
+				c.endpoint.breaker.Failure()
+				// the provider rejects the streams with a compression it does not accept once they are established
+				c.compression.update(st.Trailer())
+				if !c.endpoint.retryWithRefreshedToken(err, &c.authRetried) {
+					c.backoff.wait(c.guard, c.streamName, 0)
 				}
 				break
 			}
@@ -205,18 +343,55 @@ func (c *getAndWatchConsumer) readGetAndWatchStream() (retry bool) {
 					KeyValue: make(map[string]string),
 				}
 			}
-			Log.Debug("event received", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target))
+			Log.Debug("event received", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), RedactedKey(gwEvt.Key))
 			monitorDelays(c, gwEvt)
+			c.tMetrics.received(gwEvt.Metadata)
+			c.traffic.payload.Add(float64(len(gwEvt.Value)))
+			if c.config.MaxEventSize > 0 {
+				if err := c.checkEventSize(proto.Size(gwEvt), gwEvt.Key); err != nil {
+					if !c.updateLost(gwEvt.Key, err) {
+						break
+					}
+					continue
+				}
+			}
+			if err := deltas.decode(gwEvt); err != nil {
+				// the state is resent on reconnection
+				Log.Warn("could not decode delta, reconnecting", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
+				break
+			}
+			if err := decryptGetAndWatchEvent(c.endpoint.g, c.config.Decryption, c.streamName, gwEvt); err != nil {
+				if !c.updateLost(gwEvt.Key, err) {
+					break
+				}
+				continue
+			}
+			if gwEvt.EventType != stream.EventType_DELETE {
+				c.ordering.checkMetadata(gwEvt.Key, gwEvt.Metadata)
+			}
 
-			c.evtChan <- gwEvt
+			if c.config.Backpressure == BackpressureReconnect && cap(c.evtChan) > 0 && len(c.evtChan) == cap(c.evtChan) {
+				// the state is resent on reconnection
+				Log.Warn("consumer channel full, reconnecting", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target))
+				c.cMetrics.droppedCounter.Inc()
+				break
+			}
+			if !c.send(gwEvt) {
+				return false
+			}
 		}
 	} else {
+		c.endpoint.breaker.Failure()
 		if mds == nil {
 			Log.Warn("Stream created but not connected, no header received", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
 		} else {
 			Log.Warn("Stream created but not connected", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
 		}
-		time.Sleep(5 * time.Second)
+		// the provider may ask to use another compression if it rejected the stream
+		c.compression.update(st.Trailer())
+		if !c.endpoint.retryWithRefreshedToken(err, &c.authRetried) {
+			c.backoff.wait(c.guard, c.streamName, retryAfter(st.Trailer(), 0))
+		}
 	}
 	c.cMetrics.conGauge.Set(0)
 	if c.config.OnDisconnected != nil {
@@ -2,6 +2,7 @@ package gorillaz
 
 import (
 	"encoding/base64"
+	"sync/atomic"
 	"time"
 
 	"github.com/skysoft-atm/gorillaz/mux"
@@ -18,13 +19,41 @@ type GetAndWatchStreamProvider struct {
 	config      *GetAndWatchConfig
 	broadcaster *mux.StateBroadcaster
 	metrics     providerMetricsHolder
+	typeMetrics *eventTypeMetrics
+	traffic     *trafficMetrics
 	gaz         *Gaz
+	limiter     *subscriberLimiter
+	lineage     *lineageStamper
+	seq         int64 // seq is the sequence of the last event submitted, accessed atomically
+
+	snapshotStop    chan struct{}
+	snapshotStopped chan struct{}
 }
 
 func (p *GetAndWatchStreamProvider) streamType() stream.StreamType {
 	return stream.StreamType_GET_AND_WATCH
 }
 
+func (p *GetAndWatchStreamProvider) consumers() []mux.ConsumerStats {
+	return p.broadcaster.Consumers()
+}
+
+func (p *GetAndWatchStreamProvider) subscriberLimiter() *subscriberLimiter {
+	return p.limiter
+}
+
+func (p *GetAndWatchStreamProvider) subscriberRetryAfter() time.Duration {
+	return p.config.SubscriberRetryAfter
+}
+
+func (p *GetAndWatchStreamProvider) auditSink() AuditSink {
+	return p.config.Audit
+}
+
+func (p *GetAndWatchStreamProvider) compressions() []string {
+	return p.config.Compression
+}
+
 type GetAndWatchConfigOpt func(p *GetAndWatchConfig)
 
 // ProviderConfig is the configuration that will be applied for the stream StreamProvider
@@ -34,6 +63,19 @@ type GetAndWatchConfig struct {
 	OnBackPressure           func(streamName string) // OnBackPressure is the function called when a customer cannot consume fast enough and event are dropped. (default: log)
 	Ttl                      time.Duration
 	TracingEnabled           bool
+	MaxSubscribers           int                 // MaxSubscribers is the maximum number of concurrent subscribers, the others are rejected with ResourceExhausted (default: 0, unlimited)
+	MaxSendRate              float64             // MaxSendRate is the maximum number of events per second sent to each subscriber (default: 0, unlimited)
+	SubscriberRetryAfter     time.Duration       // SubscriberRetryAfter is the delay rejected subscribers are asked to wait before subscribing again (default: 5s)
+	Validator                Validator           // Validator rejects the invalid events submitted (default: nil, no validation)
+	Encryption               KeyProvider         // Encryption encrypts the values of the events submitted end to end (default: nil, values in clear)
+	EventTypeMetrics         []string            // EventTypeMetrics are the event types broken down in the metrics, the others are counted together (default: nil, no breakdown)
+	SlowConsumer             *SlowConsumerConfig // SlowConsumer detects the subscribers not keeping up with the events (default: nil, no detection)
+	Snapshot                 *SnapshotConfig     // Snapshot persists the state and restores it at startup (default: nil, not persisted)
+	DerivedEvents            bool                // DerivedEvents counts the events submitted without origin stream timestamp, see DerivedEvents (default: false)
+	StampLineage             bool                // StampLineage starts the lineage of the events submitted without lineage, see GetAndWatchStampLineage (default: false)
+	Audit                    AuditSink           // Audit records the subscriptions to the stream (default: nil, not recorded)
+	MaxEventSize             int                 // MaxEventSize rejects the events whose marshalled size exceeds it, see GetAndWatchMaxEventSize (default: 0, unlimited)
+	Compression              []string            // Compression are the compressions accepted, in order of preference, see GetAndWatchCompression (default: nil, any compression registered)
 }
 
 func defaultGetAndWatchConfig() *GetAndWatchConfig {
@@ -43,14 +85,24 @@ func defaultGetAndWatchConfig() *GetAndWatchConfig {
 		OnBackPressure: func(streamName string) {
 			Log.Warn("backpressure applied, an event won't be delivered because it can't consume fast enough", zap.String("stream", streamName))
 		},
-		Ttl:            0,
-		TracingEnabled: true,
+		Ttl:                  0,
+		TracingEnabled:       true,
+		SubscriberRetryAfter: defaultSubscriberRetryAfter,
 	}
 }
 
 // NewStreamProvider returns a new provider ready to be used.
 // only one instance of provider should be created for a given streamName
+// The stream name is prefixed by the tenant of the service, if any
+// It panics if the stream name does not follow the stream name policy, see WithStreamNamePolicy
 func (g *Gaz) NewGetAndWatchStreamProvider(streamName, dataType string, opts ...GetAndWatchConfigOpt) *GetAndWatchStreamProvider {
+	if err := g.checkStreamName(streamName); err != nil {
+		panic(err)
+	}
+	return g.newGetAndWatchStreamProvider(g.tenantStreamName(streamName), dataType, opts...)
+}
+
+func (g *Gaz) newGetAndWatchStreamProvider(streamName, dataType string, opts ...GetAndWatchConfigOpt) *GetAndWatchStreamProvider {
 	Log.Info("creating stream", zap.String("stream", streamName))
 
 	config := defaultGetAndWatchConfig()
@@ -65,7 +117,19 @@ func (g *Gaz) NewGetAndWatchStreamProvider(streamName, dataType string, opts ...
 		config:      config,
 		broadcaster: broadcaster,
 		metrics:     pMetricHolder(g, streamName),
+		typeMetrics: providerEventTypeMonitoring(g, streamName, config.EventTypeMetrics),
+		traffic:     providerTrafficMonitoring(g, streamName),
 		gaz:         g,
+		limiter:     newSubscriberLimiter(config.MaxSubscribers),
+		lineage:     newLineageStamper(g, streamName, config.StampLineage),
+	}
+	if config.Snapshot != nil {
+		p.restoreSnapshot()
+		p.snapshotStop = make(chan struct{})
+		p.snapshotStopped = make(chan struct{})
+		g.goTracked("snapshot", streamName, func() {
+			p.snapshotLoop(p.snapshotStop, p.snapshotStopped)
+		})
 	}
 	g.streamRegistry.register(p)
 	return p
@@ -73,14 +137,49 @@ func (g *Gaz) NewGetAndWatchStreamProvider(streamName, dataType string, opts ...
 
 // Submit pushes the event to all subscribers and stores it by its key for new subscribers appearing on the stream
 func (p *GetAndWatchStreamProvider) Submit(evt *stream.Event) {
+	p.SubmitSeq(evt)
+}
+
+// SubmitSeq submits the event like Submit, and returns its sequence, 0 if it was rejected.
+// The events submitted are numbered from 1 in the order they are submitted, the StateCache entries carry the sequence
+// of their value, see StateCache.WaitForSeq.
+func (p *GetAndWatchStreamProvider) SubmitSeq(evt *stream.Event) int {
+	if p.config.Validator != nil {
+		if err := p.config.Validator.Validate(evt); err != nil {
+			Log.Warn("invalid event not submitted", zap.String("stream", p.streamDef.Name), RedactedKey(evt.Key), zap.Error(err))
+			invalidEventsCounter(p.gaz, StreamInvalidEvents, p.streamDef.Name).Inc()
+			return 0
+		}
+	}
+	evt, err := encryptSubmitted(p.gaz, p.config.Encryption, p.streamDef.Name, p.lineage.stamp(evt))
+	if err != nil {
+		return 0
+	}
+	if p.config.MaxEventSize > 0 {
+		if err := checkEventSize(streamEventSize(evt), p.config.MaxEventSize); err != nil {
+			Log.Warn("oversize event not submitted", zap.String("stream", p.streamDef.Name), RedactedKey(evt.Key), zap.Error(err))
+			oversizeEventsCounter(p.gaz, StreamOversizeEvents, StreamNameLabel, p.streamDef.Name).Inc()
+			return 0
+		}
+	}
+	checkOrigin(p.gaz, p.config.DerivedEvents, p.streamDef.Name, evt)
 	p.metrics.sentCounter.Inc()
 	p.metrics.lastEventTimestamp.SetToCurrentTime()
+	p.typeMetrics.sent(evt.EventTypeStr())
+	p.traffic.payload.Add(float64(len(evt.Value)))
 
-	p.broadcaster.Submit(base64.StdEncoding.EncodeToString(evt.Key), evt)
+	seq := int(atomic.AddInt64(&p.seq, 1))
+	p.broadcaster.Submit(stateKey(evt.Key), withStateSeq(evt, seq))
+	return seq
 }
 
 func (p *GetAndWatchStreamProvider) Delete(key []byte) {
-	p.broadcaster.Delete(base64.StdEncoding.EncodeToString(key))
+	p.broadcaster.Delete(stateKey(key))
+}
+
+// stateKey is the key of the event in the state broadcaster
+func stateKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
 }
 
 func (p *GetAndWatchStreamProvider) sendHelloMessage(strm grpc.ServerStream, peer Peer) error {
@@ -108,6 +207,7 @@ func (p *GetAndWatchStreamProvider) sendLoop(strm grpc.ServerStream, peer Peer,
 	broadcaster := p.broadcaster
 	streamCh := make(chan *mux.StateUpdate, p.config.SubscriberInputBufferLen)
 	broadcaster.Register(streamCh, func(config *mux.ConsumerConfig) error {
+		config.Name(peer.name())
 		config.OnBackpressure(func(interface{}) {
 			p.config.OnBackPressure(streamName)
 			p.metrics.backPressureCounter.Inc()
@@ -115,9 +215,17 @@ func (p *GetAndWatchStreamProvider) sendLoop(strm grpc.ServerStream, peer Peer,
 		if opts.disconnectOnBackpressure {
 			config.DisconnectOnBackpressure()
 		}
+		if opts.keys != nil {
+			config.KeyFilter(opts.keys.containsStateKey)
+		}
 		return nil
 	})
 	defer broadcaster.Unregister(streamCh)
+	rateLimiter := newSendRateLimiter(p.config.MaxSendRate)
+	slow := newSlowConsumerDetector(p.gaz, streamName, peer, p.config.SlowConsumer)
+	defer slow.close()
+	clock := p.gaz.Clock()
+	deltas := newDeltaEncoder(opts.delta)
 
 	for {
 		select {
@@ -159,16 +267,29 @@ func (p *GetAndWatchStreamProvider) sendLoop(strm grpc.ServerStream, peer Peer,
 				if err != nil {
 					Log.Error("failed to inject context data into metadata", zap.Error(err))
 				}
+				stampStateSeq(se, gwe.Metadata)
 			}
+			deltas.encode(&gwe)
 			evt, err := proto.Marshal(&gwe)
 			if err != nil {
 				Log.Error("Error while marshalling GetAndWatchEvent", zap.Error(err))
 				return err
 			}
+			if err := rateLimiter.wait(strm.Context()); err != nil {
+				return err
+			}
+			if err := opts.quota.wait(strm.Context(), len(evt)); err != nil {
+				return err
+			}
+			sendStart := clock.Now()
 			if err := strm.(grpc.ServerStream).SendMsg(evt); err != nil {
 				Log.Info("consumer disconnected", zap.Error(err), zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("peer service", peer.serviceName))
 				return err
 			}
+			now := clock.Now()
+			if err := slow.observe(len(streamCh), now.Sub(sendStart), now); err != nil {
+				return err
+			}
 		case <-strm.Context().Done():
 			Log.Info("consumer disconnected", zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("peer service", peer.serviceName))
 			return strm.Context().Err()
@@ -183,6 +304,11 @@ func (p *GetAndWatchStreamProvider) CloseStream() error {
 }
 
 func (p *GetAndWatchStreamProvider) close() {
+	if p.snapshotStop != nil {
+		// the state is saved a last time before the broadcaster is closed
+		close(p.snapshotStop)
+		<-p.snapshotStopped
+	}
 	p.broadcaster.Close()
 }
 
@@ -35,6 +35,7 @@ type Gaz struct {
 	NatsConn           *nats.Conn
 	ViperRemoteConfig  func(g *Gaz) error
 	Env                string
+	Tenant             string // Tenant scopes the Nats subjects, the Jetstream streams and the gRPC streams, empty means no tenant
 	Viper              *viper.Viper
 	// use int32 because sync.atomic package doesn't support boolean out of the box
 	isReady               *int32
@@ -51,6 +52,30 @@ type Gaz struct {
 	bindConfigKeysAsFlag  bool
 	streamDefinitions     *GetAndWatchStreamProvider
 	addEnvPrefixToNats    bool
+	natsPublishBuffer     *natsPublishBuffer
+	natsMaxEventSize      int // natsMaxEventSize is the maximum marshalled size of the events published on Nats, see WithNatsMaxEventSize
+	subjectPolicy         *NamePolicy
+	streamNamePolicy      *NamePolicy
+	grpcConnsMu           sync.Mutex
+	grpcConns             map[grpcConnKey]*sharedGrpcConn
+	grpcConnsSeq          uint64
+	authorizer            *Authorizer
+	circuitBreakersMu     sync.Mutex
+	circuitBreakerConfigs map[string]*CircuitBreakerConfig
+	circuitBreakers       map[string]*CircuitBreaker
+	clock                 Clock
+	runGroupOnce          sync.Once
+	runGroup              *runGroup
+	backfillSourcesMu     sync.Mutex
+	backfillSources       map[string]BackfillSource
+	quotas                *quotas
+	pipelineComponents    *pipelineComponents
+	goroutineTracker      goroutineTracker
+	endpointResolversMu   sync.Mutex
+	endpointResolvers     map[string]EndpointResolver
+	healthServer          *health.Server
+	supervisorOnce        sync.Once
+	supervisor            *Supervisor
 }
 
 type streamConsumerRegistry struct {
@@ -139,7 +164,7 @@ func (g *Gaz) tracingEnabled() bool {
 // It takes root at the current folder for properties file and a map of properties
 func New(options ...GazOption) *Gaz {
 	GracefulStop()
-	gaz := Gaz{Router: mux.NewRouter(), isReady: new(int32), Viper: viper.New(), prometheusRegistry: prometheus.NewRegistry()}
+	gaz := Gaz{Router: mux.NewRouter(), isReady: new(int32), Viper: viper.New(), prometheusRegistry: prometheus.NewRegistry(), grpcConns: make(map[grpcConnKey]*sharedGrpcConn)}
 
 	// expose Go metrics and process metrics as Prometheus DefaultRegistry would
 	// https://github.com/prometheus/client_golang/blob/v1.1.0/prometheus/registry.go#L60
@@ -178,6 +203,7 @@ func New(options ...GazOption) *Gaz {
 		panic(errors.New("please provide an environment with the \"env\" configuration key"))
 	}
 	gaz.Env = env
+	gaz.Tenant = gaz.Viper.GetString("tenant")
 
 	if gaz.ViperRemoteConfig != nil {
 		err := gaz.ViperRemoteConfig(&gaz)
@@ -186,6 +212,17 @@ func New(options ...GazOption) *Gaz {
 		}
 	}
 
+	subjectPolicy, err := gaz.configNamePolicy("naming.subject")
+	if err != nil {
+		panic(err)
+	}
+	gaz.subjectPolicy = subjectPolicy
+	streamNamePolicy, err := gaz.configNamePolicy("naming.stream")
+	if err != nil {
+		panic(err)
+	}
+	gaz.streamNamePolicy = streamNamePolicy
+
 	// then apply non-init options
 	for _, o := range options {
 		_, ok := o.(InitOption)
@@ -200,10 +237,15 @@ func New(options ...GazOption) *Gaz {
 	serviceAddress := gaz.Viper.GetString("service.address")
 	gaz.serviceAddress = serviceAddress
 
-	err := gaz.InitLogs(gaz.Viper.GetString("log.level"))
+	err = gaz.InitLogs(gaz.Viper.GetString("log.level"))
+	if err != nil {
+		panic(err)
+	}
+	r, err := ParseRedactor(gaz.Viper.GetString("log.redaction"))
 	if err != nil {
 		panic(err)
 	}
+	SetRedactor(r)
 
 	if gaz.tracingEnabled() {
 		gaz.InitTracingFromConfig()
@@ -229,23 +271,46 @@ func New(options ...GazOption) *Gaz {
 
 	serverOptions = append(serverOptions, gaz.grpcServerOptions...)
 
+	if certFile := gaz.Viper.GetString("grpc.server.tls.cert"); certFile != "" {
+		creds, err := mutualTLSCredentials(certFile, gaz.Viper.GetString("grpc.server.tls.key"), gaz.Viper.GetString("grpc.server.tls.ca"))
+		if err != nil {
+			panic(err)
+		}
+		serverOptions = append(serverOptions, grpc.Creds(creds))
+	}
+
 	if gaz.tracingEnabled() {
-		serverOptions = append(serverOptions, grpc.UnaryInterceptor(TracingServerInterceptor()))
+		serverOptions = append(serverOptions, grpc.ChainUnaryInterceptor(TracingServerInterceptor()))
 	}
 
+	if gaz.Viper.GetBool("grpc.server.authz.enabled") {
+		authorizer, err := NewAuthorizer(gaz.Viper.GetStringSlice("grpc.server.authz.rules"))
+		if err != nil {
+			panic(err)
+		}
+		gaz.authorizer = authorizer
+		serverOptions = append(serverOptions, grpc.ChainUnaryInterceptor(IdentityServerInterceptor(authorizer)))
+		serverOptions = append(serverOptions, grpc.ChainStreamInterceptor(IdentityStreamServerInterceptor(authorizer)))
+	}
+
+	serverOptions = append(serverOptions, grpc.StatsHandler(&streamTrafficHandler{g: &gaz}))
+
 	gaz.GrpcServer = grpc.NewServer(serverOptions...)
 	reflection.Register(gaz.GrpcServer)
 	gaz.streamRegistry = newStreamRegistry(&gaz)
-	sdProvider := gaz.NewGetAndWatchStreamProvider(streamDefinitions, "stream.StreamDefinition", func(p *GetAndWatchConfig) {
+	sdProvider := gaz.newGetAndWatchStreamProvider(streamDefinitions, "stream.StreamDefinition", func(p *GetAndWatchConfig) {
 		p.TracingEnabled = false
 	})
 	gaz.streamDefinitions = sdProvider
 	stream.RegisterStreamServer(gaz.GrpcServer, gaz.streamRegistry)
+	gaz.GrpcServer.RegisterService(&backfillServiceDesc, &gaz)
+	stream.RegisterAckServer(gaz.GrpcServer, gaz.streamRegistry)
 
 	Log.Info("Registering gRPC health server")
 	healthServer := health.NewServer()
 	healthServer.SetServingStatus("Stream", grpc_health_v1.HealthCheckResponse_SERVING)
 	grpc_health_v1.RegisterHealthServer(gaz.GrpcServer, healthServer)
+	gaz.healthServer = healthServer
 
 	Log.Info("Registering gorillaz gRPC resolver")
 	resolver.Register(&gorillazResolverBuilder{gaz: &gaz})
@@ -285,6 +350,14 @@ func (g *Gaz) Run() <-chan struct{} {
 	if addr := g.Viper.GetString("nats.addr"); addr != "" {
 		g.mustInitNats(addr)
 		g.addEnvPrefixToNats = g.Viper.GetBool("nats.add.env.prefix")
+		if g.Viper.GetBool("nats.scheduler.enabled") {
+			// stopped on Shutdown, restarted if it fails such as when Jetstream is not available yet
+			g.Go("nats_scheduler", g.RunNatsScheduler, GoRestart(time.Second, time.Minute))
+		}
+	}
+
+	if err := g.StartPipelines(); err != nil {
+		Log.Panic("could not start the pipelines", zap.Error(err))
 	}
 
 	var waitgroup sync.WaitGroup
@@ -308,6 +381,12 @@ func (g *Gaz) Run() <-chan struct{} {
 	go func() {
 		// register /info to return the build version
 		g.Router.HandleFunc("/info", versionInfoHandler()).Methods("GET")
+		// register /streams/consumers to inspect the consumers of the provided streams
+		g.Router.HandleFunc("/streams/consumers", streamConsumersHandler(g)).Methods("GET")
+		// register /debug/goroutines to list the live goroutines of gorillaz
+		g.Router.HandleFunc("/debug/goroutines", liveGoroutinesHandler(g)).Methods("GET")
+		// register /processing/dag to inspect the processing nodes of the supervisor
+		g.Router.HandleFunc("/processing/dag", processingDAGHandler(g)).Methods("GET")
 		httpPort := g.HttpPort()
 		Sugar.Infof("Starting HTTP server on :%d", httpPort)
 		waitgroup.Done()
@@ -329,6 +408,12 @@ func (g *Gaz) Run() <-chan struct{} {
 			Tags: []string{grpcTag, httpTag, StreamProviderTag, g.Env},
 			Meta: map[string]string{httpPortMetadata: strconv.Itoa(g.HttpPort()), "env": g.Env},
 		}
+		if zone := g.Viper.GetString("zone"); zone != "" {
+			serviceDefinition.Meta[zoneMetadata] = zone
+		}
+		if weight := g.Viper.GetInt("weight"); weight > 0 {
+			serviceDefinition.Meta[weightMetadata] = strconv.Itoa(weight)
+		}
 
 		g.registrationHandle, err = g.Register(serviceDefinition)
 		if err != nil {
@@ -370,8 +455,12 @@ func (g *Gaz) GrpcDialService(serviceName string, opts ...grpc.DialOption) (*grp
 }
 
 func (g *Gaz) GrpcDial(target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	serviceConfig, err := g.grpcServiceConfig()
+	if err != nil {
+		return nil, err
+	}
 	options := make([]grpc.DialOption, len(opts)+3)
-	options[0] = grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`)
+	options[0] = grpc.WithDefaultServiceConfig(serviceConfig)
 
 	options[1] = grpc.WithConnectParams(grpc.ConnectParams{
 		MinConnectTimeout: 2 * time.Second,
@@ -389,9 +478,20 @@ func (g *Gaz) GrpcDial(target string, opts ...grpc.DialOption) (*grpc.ClientConn
 	for i, o := range opts {
 		options[3+i] = o
 	}
+	interceptors := []grpc.UnaryClientInterceptor{g.MetricsClientInterceptor()}
+	if ms := g.Viper.GetInt("grpc.client.default.deadline.ms"); ms > 0 {
+		interceptors = append(interceptors, g.DeadlineClientInterceptor(time.Duration(ms)*time.Millisecond))
+	}
 	if g.tracingEnabled() {
-		options = append(options, grpc.WithUnaryInterceptor(TracingClientInterceptor()))
+		interceptors = append(interceptors, TracingClientInterceptor())
+	}
+	// the retry interceptor is not needed if gRPC retries the calls itself
+	idempotent := g.idempotentGrpcMethods()
+	if attempts := g.Viper.GetInt("grpc.client.max.attempts"); attempts > 1 && len(idempotent) > 0 && !g.serviceConfigRetries() {
+		interceptors = append(interceptors, g.RetryClientInterceptor(attempts, 100*time.Millisecond, idempotent...))
 	}
+	options = append(options, grpc.WithChainUnaryInterceptor(interceptors...))
+	options = append(options, grpc.WithStatsHandler(&streamTrafficHandler{g: g}))
 
 	return grpc.Dial("gorillaz:///"+target, options...)
 }
@@ -409,6 +509,9 @@ func (g *Gaz) Shutdown() {
 		}
 	}
 
+	Log.Info("Stopping background goroutines")
+	g.stopGoroutines(defaultGoroutineStopTimeout)
+
 	Log.Info("Stopping gRPC server")
 	g.GrpcServer.Stop()
 
@@ -0,0 +1,241 @@
+package gorillaz
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	GrpcClientLatencyMs               = "grpc_client_latency_ms"
+	GrpcClientErrors                  = "grpc_client_errors"
+	GrpcClientRetries                 = "grpc_client_retries"
+	GrpcClientDefaultDeadlineExceeded = "grpc_client_default_deadline_exceeded"
+)
+
+const GrpcMethodLabel = "method"
+const GrpcCodeLabel = "code"
+
+type grpcClientMetrics struct {
+	latencySummary         prometheus.Summary
+	errorCounter           *prometheus.CounterVec
+	retryCounter           prometheus.Counter
+	defaultDeadlineCounter prometheus.Counter
+}
+
+var grpcClientMetricsMu sync.Mutex
+var grpcClientMonitorings = make(map[string]*grpcClientMetrics)
+
+func grpcClientMonitoring(g *Gaz, method string) *grpcClientMetrics {
+	grpcClientMetricsMu.Lock()
+	defer grpcClientMetricsMu.Unlock()
+
+	if m, ok := grpcClientMonitorings[method]; ok {
+		return m
+	}
+	m := &grpcClientMetrics{
+		latencySummary: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name:       GrpcClientLatencyMs,
+			Help:       "distribution of the duration of unary gRPC calls, in milliseconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			ConstLabels: prometheus.Labels{
+				GrpcMethodLabel: method,
+			},
+		}),
+		errorCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: GrpcClientErrors,
+			Help: "The total number of failed unary gRPC calls, by status code",
+			ConstLabels: prometheus.Labels{
+				GrpcMethodLabel: method,
+			},
+		}, []string{GrpcCodeLabel}),
+		retryCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: GrpcClientRetries,
+			Help: "The total number of retried unary gRPC calls",
+			ConstLabels: prometheus.Labels{
+				GrpcMethodLabel: method,
+			},
+		}),
+		defaultDeadlineCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: GrpcClientDefaultDeadlineExceeded,
+			Help: "The total number of unary gRPC calls that exceeded the default deadline",
+			ConstLabels: prometheus.Labels{
+				GrpcMethodLabel: method,
+			},
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.latencySummary)
+	g.prometheusRegistry.MustRegister(m.errorCounter)
+	g.prometheusRegistry.MustRegister(m.retryCounter)
+	g.prometheusRegistry.MustRegister(m.defaultDeadlineCounter)
+	grpcClientMonitorings[method] = m
+	return m
+}
+
+// MetricsClientInterceptor monitors the latency and the errors of unary calls per method
+func (g *Gaz) MetricsClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		m := grpcClientMonitoring(g, method)
+		start := time.Now()
+		err := invoker(ctx, method, req, resp, cc, opts...)
+		m.latencySummary.Observe(float64(time.Since(start).Nanoseconds()) / 1000000.0)
+		if err != nil {
+			m.errorCounter.WithLabelValues(status.Code(err).String()).Inc()
+		}
+		dependencyMonitoring(g, ProtocolGrpc, cc.Target()).observe(grpcOutcome(err))
+		return err
+	}
+}
+
+// RetryClientInterceptor retries the unary calls of the idempotent methods failing with codes.Unavailable, up to maxAttempts attempts in total.
+// The idempotent methods are given by their full name ("/package.Service/Method"), or by their service name ("package.Service")
+// to retry all its methods. The calls of the other methods are not retried, they may have been executed by the server.
+// The delay between attempts starts at baseDelay and doubles after each attempt
+func (g *Gaz) RetryClientInterceptor(maxAttempts int, baseDelay time.Duration, idempotentMethods ...string) grpc.UnaryClientInterceptor {
+	idempotent := make(map[string]struct{}, len(idempotentMethods))
+	for _, m := range idempotentMethods {
+		idempotent[m] = struct{}{}
+	}
+	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !isIdempotentMethod(idempotent, method) {
+			return invoker(ctx, method, req, resp, cc, opts...)
+		}
+		delay := baseDelay
+		var err error
+		for attempt := 1; ; attempt++ {
+			err = invoker(ctx, method, req, resp, cc, opts...)
+			if err == nil || status.Code(err) != codes.Unavailable || attempt >= maxAttempts {
+				return err
+			}
+			grpcClientMonitoring(g, method).retryCounter.Inc()
+			dependencyMonitoring(g, ProtocolGrpc, cc.Target()).retryCounter.Inc()
+			Log.Debug("retrying gRPC call", zap.String("method", method), zap.Int("attempt", attempt), zap.Error(err))
+			select {
+			case <-time.After(delay):
+				delay *= 2
+			case <-ctx.Done():
+				return err
+			}
+		}
+	}
+}
+
+// idempotentGrpcMethods returns the methods and services listed by the "grpc.client.idempotent.methods" key
+func (g *Gaz) idempotentGrpcMethods() []string {
+	var methods []string
+	for _, m := range strings.Split(g.Viper.GetString("grpc.client.idempotent.methods"), ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// isIdempotentMethod returns true if the full method name, or its service name, is in the idempotent set
+func isIdempotentMethod(idempotent map[string]struct{}, method string) bool {
+	if _, ok := idempotent[method]; ok {
+		return true
+	}
+	service := strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(service, "/"); i >= 0 {
+		service = service[:i]
+	}
+	_, ok := idempotent[service]
+	return ok
+}
+
+func grpcOutcome(err error) string {
+	switch status.Code(err) {
+	case codes.OK:
+		return OutcomeSuccess
+	case codes.Canceled:
+		return OutcomeCanceled
+	default:
+		return OutcomeFailure
+	}
+}
+
+// sharedGrpcConn is a connection shared by all the clients and stream endpoints targeting the same endpoints with the same options
+type sharedGrpcConn struct {
+	conn *grpc.ClientConn
+	refs int
+}
+
+// grpcConnKey identifies a shared connection, the connections are only shared between the users dialing the same target
+// with the same options, so that for instance a TLS connection is never used by an endpoint expecting an insecure one
+type grpcConnKey struct {
+	target  string
+	options string // options identifies the dial options, empty if they can't be compared and the connection is not shared
+}
+
+// GrpcServiceConn returns a connection to the service resolved via service discovery.
+// It is dialed over TLS if the grpc.client.tls.* keys are configured, see EndpointMutualTLS, then with the options.
+// Connections are shared by service between the callers with the same TLS configuration and no options,
+// and with the consumers of the streams of the service created with the default endpoint options.
+// The options can't be compared so that a connection dialed with options is not shared.
+// The connection must not be closed by the caller, call ReleaseGrpcConn once it is no longer used instead.
+func (g *Gaz) GrpcServiceConn(serviceName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	config := defaultStreamEndpointConfig()
+	for _, opt := range g.endpointTLSFromConfig() {
+		opt(config)
+	}
+	if config.err != nil {
+		return nil, config.err
+	}
+	key := grpcConnKey{target: SdPrefix + serviceName}
+	if len(opts) == 0 {
+		key.options = config.connKey()
+	}
+	return g.acquireGrpcConn(key, func() (*grpc.ClientConn, error) {
+		return g.GrpcDialService(serviceName, append(config.dialOptions(), opts...)...)
+	})
+}
+
+// acquireGrpcConn returns the connection shared for the key, it is dialed if it doesn't exist yet or can't be shared
+func (g *Gaz) acquireGrpcConn(key grpcConnKey, dial func() (*grpc.ClientConn, error)) (*grpc.ClientConn, error) {
+	g.grpcConnsMu.Lock()
+	defer g.grpcConnsMu.Unlock()
+	if sc, ok := g.grpcConns[key]; ok && key.options != "" {
+		sc.refs++
+		return sc.conn, nil
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	if key.options == "" {
+		// not shared, the connection is only kept to be released
+		g.grpcConnsSeq++
+		key.options = "unshared#" + strconv.FormatUint(g.grpcConnsSeq, 10)
+	}
+	g.grpcConns[key] = &sharedGrpcConn{conn: conn, refs: 1}
+	return conn, nil
+}
+
+// ReleaseGrpcConn releases a connection returned by GrpcServiceConn, it is closed once released by all its users
+func (g *Gaz) ReleaseGrpcConn(conn *grpc.ClientConn) error {
+	g.grpcConnsMu.Lock()
+	defer g.grpcConnsMu.Unlock()
+	for key, sc := range g.grpcConns {
+		if sc.conn != conn {
+			continue
+		}
+		sc.refs--
+		if sc.refs > 0 {
+			return nil
+		}
+		Log.Debug("Closing shared gRPC connection", zap.String("target", key.target))
+		delete(g.grpcConns, key)
+		return conn.Close()
+	}
+	return fmt.Errorf("gRPC connection to %s is not shared by gorillaz", conn.Target())
+}
@@ -0,0 +1,151 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestEndpointConnSharing(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	insecure, err := g.newStreamEndpoint([]string{"localhost:7777"})
+	assert.NoError(t, err)
+	defer insecure.close()
+	other, err := g.newStreamEndpoint([]string{"localhost:7777"})
+	assert.NoError(t, err)
+	assert.True(t, insecure.conn == other.conn, "the endpoints with the same options share their connection")
+	assert.NoError(t, other.close())
+
+	secure, err := g.newStreamEndpoint([]string{"localhost:7777"}, EndpointInsecureSkipVerify())
+	assert.NoError(t, err)
+	assert.False(t, insecure.conn == secure.conn, "a TLS endpoint must not use an insecure connection")
+	otherSecure, err := g.newStreamEndpoint([]string{"localhost:7777"}, EndpointInsecureSkipVerify())
+	assert.NoError(t, err)
+	assert.True(t, secure.conn == otherSecure.conn)
+
+	keepalive, err := g.newStreamEndpoint([]string{"localhost:7777"}, KeepaliveTime(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, insecure.conn == keepalive.conn, "the endpoints with other options have their own connection")
+
+	for _, e := range []*streamEndpoint{secure, otherSecure, keepalive} {
+		assert.NoError(t, e.close())
+	}
+	g.grpcConnsMu.Lock()
+	defer g.grpcConnsMu.Unlock()
+	assert.Len(t, g.grpcConns, 1, "the connections are closed once released by all the endpoints")
+}
+
+func TestGrpcServiceConn(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+	check := func(conn *grpc.ClientConn) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "Stream"})
+		return err
+	}
+
+	conn, err := g.GrpcServiceConn("health")
+	assert.NoError(t, err)
+	shared, err := g.GrpcServiceConn("health")
+	assert.NoError(t, err)
+	assert.True(t, conn == shared, "the connections without options are shared")
+	assert.NoError(t, check(conn))
+
+	// the options of the caller are used, the connection is not shared as they can't be compared
+	intercepted := 0
+	withOpts, err := g.GrpcServiceConn("health", grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		intercepted++
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}))
+	assert.NoError(t, err)
+	assert.False(t, conn == withOpts)
+	assert.NoError(t, check(withOpts))
+	assert.Equal(t, 1, intercepted)
+
+	// the TLS configuration of gorillaz is used, the server of the test only accepts insecure connections
+	g.Viper.Set("grpc.client.tls.insecure.skip.verify", true)
+	secure, err := g.GrpcServiceConn("health")
+	assert.NoError(t, err)
+	assert.False(t, conn == secure, "a TLS caller must not use an insecure connection")
+	assert.Equal(t, codes.Unavailable, status.Code(check(secure)))
+
+	for _, c := range []*grpc.ClientConn{conn, shared, withOpts, secure} {
+		assert.NoError(t, g.ReleaseGrpcConn(c))
+	}
+	assert.Error(t, g.ReleaseGrpcConn(conn), "the connection is closed once released by all its users")
+	g.grpcConnsMu.Lock()
+	defer g.grpcConnsMu.Unlock()
+	assert.Empty(t, g.grpcConns)
+}
+
+func TestGrpcServiceConnSharedWithConsumer(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	if _, err := g.NewStreamProvider("shared", "dummy.type"); err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := g.DiscoverAndConsumeServiceStream("test", "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := g.GrpcServiceConn("test")
+	assert.NoError(t, err)
+	g.streamConsumers.Lock()
+	endpoint := g.streamConsumers.endpointsByName[SdPrefix+"test"]
+	g.streamConsumers.Unlock()
+	if assert.NotNil(t, endpoint) {
+		assert.True(t, conn == endpoint.conn, "the consumer and the service client share their connection")
+	}
+
+	// released by the client, the connection is still used by the consumer
+	assert.NoError(t, g.ReleaseGrpcConn(conn))
+	g.grpcConnsMu.Lock()
+	sc := g.grpcConns[grpcConnKey{target: SdPrefix + "test", options: "insecure"}]
+	g.grpcConnsMu.Unlock()
+	if assert.NotNil(t, sc) {
+		assert.Equal(t, 1, sc.refs)
+	}
+
+	consumer.Stop()
+	waitUntil(t, 5*time.Second, "the connection released by the consumer", func() bool {
+		g.grpcConnsMu.Lock()
+		defer g.grpcConnsMu.Unlock()
+		_, ok := g.grpcConns[grpcConnKey{target: SdPrefix + "test", options: "insecure"}]
+		return !ok
+	})
+}
+
+func TestRetryClientInterceptorIdempotentMethods(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	cc, err := grpc.Dial("passthrough:///retry-test", grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer cc.Close()
+	interceptor := g.RetryClientInterceptor(3, time.Millisecond, "/test.Orders/Get", "test.Catalog")
+
+	calls := make(map[string]int)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls[method]++
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	for _, method := range []string{"/test.Orders/Get", "/test.Orders/Create", "/test.Catalog/List"} {
+		err := interceptor(context.Background(), method, nil, nil, cc, invoker)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	}
+	assert.Equal(t, 3, calls["/test.Orders/Get"], "the idempotent methods are retried")
+	assert.Equal(t, 3, calls["/test.Catalog/List"], "the methods of an idempotent service are retried")
+	assert.Equal(t, 1, calls["/test.Orders/Create"], "the other methods are not retried")
+}
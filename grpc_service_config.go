@@ -0,0 +1,141 @@
+package gorillaz
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type grpcServiceConfig struct {
+	LoadBalancingPolicy string             `json:"loadBalancingPolicy"`
+	MethodConfig        []grpcMethodConfig `json:"methodConfig,omitempty"`
+}
+
+type grpcMethodConfig struct {
+	Name          []grpcMethodName   `json:"name"`
+	Timeout       string             `json:"timeout,omitempty"`
+	RetryPolicy   *grpcRetryPolicy   `json:"retryPolicy,omitempty"`
+	HedgingPolicy *grpcHedgingPolicy `json:"hedgingPolicy,omitempty"`
+}
+
+type grpcMethodName struct {
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+}
+
+type grpcRetryPolicy struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+type grpcHedgingPolicy struct {
+	MaxAttempts         int      `json:"maxAttempts"`
+	HedgingDelay        string   `json:"hedgingDelay,omitempty"`
+	NonFatalStatusCodes []string `json:"nonFatalStatusCodes,omitempty"`
+}
+
+func serviceConfigDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+func statusCodes(s string) []string {
+	codes := make([]string, 0)
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			codes = append(codes, c)
+		}
+	}
+	return codes
+}
+
+// streamServices are the services of the long-lived streams, they are excluded from the timeout and the policies of the method config:
+// a stream would otherwise be cut after the timeout, and its subscription duplicated by the hedging
+var streamServices = []grpcMethodName{{Service: "stream.Stream"}, {Service: "stream.Ack"}}
+
+// retryPolicyEnabled returns true if a retry policy is configured in the gRPC service config
+func (g *Gaz) retryPolicyEnabled() bool {
+	return g.Viper.GetInt("grpc.client.retry.max.attempts") > 1
+}
+
+// serviceConfigRetries returns true if the gRPC service config retries or hedges the calls,
+// the calls are then not retried by the RetryClientInterceptor too, which would multiply the attempts
+func (g *Gaz) serviceConfigRetries() bool {
+	if raw := g.Viper.GetString("grpc.client.service.config"); raw != "" {
+		return strings.Contains(raw, `"retryPolicy"`) || strings.Contains(raw, `"hedgingPolicy"`)
+	}
+	return g.retryPolicyEnabled() || g.Viper.GetInt("grpc.client.hedging.max.attempts") > 1
+}
+
+// grpcServiceConfig returns the default gRPC service config applied to the dialed connections.
+// It is either the raw JSON given with the "grpc.client.service.config" key, or built from the retry, hedging and timeout keys.
+// The timeout and the policies apply to all the methods but the streams of gorillaz, which share the connections of the unary calls.
+// Note that gRPC only applies retry and hedging policies if the environment variable GRPC_GO_RETRY=on is set
+func (g *Gaz) grpcServiceConfig() (string, error) {
+	return g.grpcServiceConfigWithPolicy("round_robin")
+}
+
+// grpcServiceConfigWithPolicy returns the default gRPC service config with the given load balancing policy,
+// the raw JSON given with the "grpc.client.service.config" key is returned as is, it must exclude the streams itself
+func (g *Gaz) grpcServiceConfigWithPolicy(loadBalancingPolicy string) (string, error) {
+	if raw := g.Viper.GetString("grpc.client.service.config"); raw != "" {
+		return raw, nil
+	}
+	sc := grpcServiceConfig{LoadBalancingPolicy: loadBalancingPolicy}
+	mc := grpcMethodConfig{Name: []grpcMethodName{{}}}
+	configured := false
+
+	if timeout := g.Viper.GetInt("grpc.client.timeout.ms"); timeout > 0 {
+		mc.Timeout = serviceConfigDuration(time.Duration(timeout) * time.Millisecond)
+		configured = true
+	}
+
+	if g.retryPolicyEnabled() {
+		rp := &grpcRetryPolicy{
+			MaxAttempts:          g.Viper.GetInt("grpc.client.retry.max.attempts"),
+			InitialBackoff:       serviceConfigDuration(100 * time.Millisecond),
+			MaxBackoff:           serviceConfigDuration(time.Second),
+			BackoffMultiplier:    2,
+			RetryableStatusCodes: []string{"UNAVAILABLE"},
+		}
+		if ms := g.Viper.GetInt("grpc.client.retry.initial.backoff.ms"); ms > 0 {
+			rp.InitialBackoff = serviceConfigDuration(time.Duration(ms) * time.Millisecond)
+		}
+		if ms := g.Viper.GetInt("grpc.client.retry.max.backoff.ms"); ms > 0 {
+			rp.MaxBackoff = serviceConfigDuration(time.Duration(ms) * time.Millisecond)
+		}
+		if m := g.Viper.GetFloat64("grpc.client.retry.backoff.multiplier"); m > 0 {
+			rp.BackoffMultiplier = m
+		}
+		if c := statusCodes(g.Viper.GetString("grpc.client.retry.codes")); len(c) > 0 {
+			rp.RetryableStatusCodes = c
+		}
+		mc.RetryPolicy = rp
+		configured = true
+	}
+
+	if attempts := g.Viper.GetInt("grpc.client.hedging.max.attempts"); attempts > 1 {
+		if mc.RetryPolicy != nil {
+			return "", fmt.Errorf("gRPC retry and hedging policies cannot be configured together")
+		}
+		mc.HedgingPolicy = &grpcHedgingPolicy{
+			MaxAttempts:         attempts,
+			HedgingDelay:        serviceConfigDuration(time.Duration(g.Viper.GetInt("grpc.client.hedging.delay.ms")) * time.Millisecond),
+			NonFatalStatusCodes: statusCodes(g.Viper.GetString("grpc.client.hedging.codes")),
+		}
+		configured = true
+	}
+
+	if configured {
+		// the config of a service prevails over the default one
+		sc.MethodConfig = []grpcMethodConfig{mc, {Name: streamServices}}
+	}
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
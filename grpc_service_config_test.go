@@ -0,0 +1,60 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultGrpcServiceConfig(t *testing.T) {
+	g := &Gaz{Viper: viper.New()}
+	sc, err := g.grpcServiceConfig()
+	failIf(t, err)
+	assert.Equal(t, `{"loadBalancingPolicy":"round_robin"}`, sc)
+}
+
+func TestGrpcServiceConfigWithRetryPolicy(t *testing.T) {
+	g := &Gaz{Viper: viper.New()}
+	g.Viper.Set("grpc.client.timeout.ms", 1500)
+	g.Viper.Set("grpc.client.retry.max.attempts", 4)
+	g.Viper.Set("grpc.client.retry.codes", "unavailable, aborted")
+	sc, err := g.grpcServiceConfig()
+	failIf(t, err)
+	assert.Equal(t, `{"loadBalancingPolicy":"round_robin","methodConfig":[{"name":[{}],"timeout":"1.500s",`+
+		`"retryPolicy":{"maxAttempts":4,"initialBackoff":"0.100s","maxBackoff":"1.000s","backoffMultiplier":2,"retryableStatusCodes":["UNAVAILABLE","ABORTED"]}},`+
+		`{"name":[{"service":"stream.Stream"},{"service":"stream.Ack"}]}]}`, sc)
+	assert.True(t, g.serviceConfigRetries(), "the calls retried by gRPC are not retried by the interceptor")
+
+	g.Viper.Set("grpc.client.hedging.max.attempts", 2)
+	_, err = g.grpcServiceConfig()
+	assert.True(t, err != nil, "retry and hedging policies are exclusive")
+}
+
+func TestGrpcServiceConfigTimeoutSparesStreams(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+	g.Viper.Set("grpc.client.timeout.ms", 200)
+
+	provider, err := g.NewStreamProvider("long_lived", "dummy.type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := g.DiscoverAndConsumeServiceStream("test", "long_lived")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["long_lived"]) == 1
+	})
+
+	time.Sleep(500 * time.Millisecond)
+	evt := &stream.Event{Key: []byte("k"), Value: []byte("after the timeout")}
+	provider.Submit(evt)
+	assertReceived(t, "long_lived", consumer.EvtChan(), evt)
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "long_lived"}, StreamConsumerDisconnections, 0)
+}
@@ -0,0 +1,172 @@
+package gorillaz
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	StreamHandledEvents     = "stream_handled_events"
+	StreamHandlerErrors     = "stream_handler_errors"
+	StreamHandlerPanics     = "stream_handler_panics"
+	StreamHandlerDurationMs = "stream_handler_duration_ms"
+
+	StreamHandlerLabel = "handler"
+)
+
+// EventHandler handles an event received by a consumer, the error it returns is counted and given to HandlerConfig.OnError
+type EventHandler func(e *stream.Event) error
+
+type HandlerConfig struct {
+	Name        string                           // Name distinguishes the metrics of the handlers of the same stream (default: "default")
+	Concurrency int                              // Concurrency is the number of events handled at the same time, they are handled in order only if it is 1 (default: 1)
+	OnError     func(e *stream.Event, err error) // OnError is called with the error returned by the handler, or its panic (default: log)
+}
+
+type HandlerOpt func(c *HandlerConfig)
+
+// HandlerName distinguishes the metrics of the handlers of the same stream
+func HandlerName(name string) HandlerOpt {
+	return func(c *HandlerConfig) {
+		c.Name = name
+	}
+}
+
+// HandlerConcurrency handles up to n events at the same time, the events are then no longer handled in order
+func HandlerConcurrency(n int) HandlerOpt {
+	return func(c *HandlerConfig) {
+		c.Concurrency = n
+	}
+}
+
+// HandlerOnError sets the function called with the error returned by the handler, or its panic
+func HandlerOnError(onError func(e *stream.Event, err error)) HandlerOpt {
+	return func(c *HandlerConfig) {
+		c.OnError = onError
+	}
+}
+
+type handlerMetrics struct {
+	handledCounter  prometheus.Counter
+	errorsCounter   prometheus.Counter
+	panicsCounter   prometheus.Counter
+	durationSummary prometheus.Summary
+}
+
+// map of metrics registered to Prometheus, by stream and handler
+var handlerMetricsMu sync.Mutex
+var handlerMonitorings = make(map[string]*handlerMetrics)
+
+func handlerMonitoring(g *Gaz, streamName, handler string) *handlerMetrics {
+	handlerMetricsMu.Lock()
+	defer handlerMetricsMu.Unlock()
+
+	k := streamName + "/" + handler
+	if m, ok := handlerMonitorings[k]; ok {
+		return m
+	}
+	labels := prometheus.Labels{StreamNameLabel: streamName, StreamHandlerLabel: handler}
+	m := &handlerMetrics{
+		handledCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamHandledEvents,
+			Help:        "The total number of events of the stream handled",
+			ConstLabels: labels,
+		}),
+		errorsCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamHandlerErrors,
+			Help:        "The total number of events of the stream for which the handler returned an error or panicked",
+			ConstLabels: labels,
+		}),
+		panicsCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamHandlerPanics,
+			Help:        "The total number of events of the stream for which the handler panicked",
+			ConstLabels: labels,
+		}),
+		durationSummary: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name:        StreamHandlerDurationMs,
+			Help:        "distribution of the time spent handling the events, in milliseconds",
+			Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.handledCounter)
+	g.prometheusRegistry.MustRegister(m.errorsCounter)
+	g.prometheusRegistry.MustRegister(m.panicsCounter)
+	g.prometheusRegistry.MustRegister(m.durationSummary)
+	handlerMonitorings[k] = m
+	return m
+}
+
+// Handle calls the handler with the events of the consumer, until the consumer is stopped or the returned function is called,
+// which waits for the events being handled. It replaces reading EvtChan, which must not be read as well.
+// The panics of the handler are recovered, they are reported to HandlerConfig.OnError like the errors it returns.
+func (c *consumer) Handle(h EventHandler, opts ...HandlerOpt) (stop func()) {
+	return handle(c.endpoint.g, c.streamName, c.evtChan, h, opts...)
+}
+
+func handle(g *Gaz, streamName string, events <-chan *stream.Event, h EventHandler, opts ...HandlerOpt) (stop func()) {
+	config := &HandlerConfig{Name: "default", Concurrency: 1}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	metrics := handlerMonitoring(g, streamName, config.Name)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(config.Concurrency)
+	for i := 0; i < config.Concurrency; i++ {
+		g.goTracked("handler", streamName, func() {
+			defer wg.Done()
+			for {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						return
+					}
+					handleEvent(streamName, e, h, config, metrics)
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			wg.Wait()
+		})
+	}
+}
+
+func handleEvent(streamName string, e *stream.Event, h EventHandler, config *HandlerConfig, metrics *handlerMetrics) {
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				metrics.panicsCounter.Inc()
+				err = fmt.Errorf("handler panicked: %v", r)
+			}
+		}()
+		return h(e)
+	}()
+	metrics.durationSummary.Observe(float64(time.Since(start).Nanoseconds()) / 1000000.0)
+	metrics.handledCounter.Inc()
+	if err == nil {
+		return
+	}
+	metrics.errorsCounter.Inc()
+	if config.OnError != nil {
+		config.OnError(e, err)
+	} else {
+		Log.Warn("error while handling event", zap.String("stream", streamName), zap.String("handler", config.Name), RedactedKey(e.Key), zap.Error(err))
+	}
+}
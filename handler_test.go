@@ -0,0 +1,76 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandle(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	events := make(chan *stream.Event, 3)
+	events <- &stream.Event{Ctx: context.Background(), Key: []byte("ok")}
+	events <- &stream.Event{Ctx: context.Background(), Key: []byte("error")}
+	events <- &stream.Event{Ctx: context.Background(), Key: []byte("panic")}
+	close(events)
+
+	failed := make(chan string, 2)
+	stop := handle(g, "handled", events, func(e *stream.Event) error {
+		switch string(e.Key) {
+		case "error":
+			return errors.New("failed")
+		case "panic":
+			panic("boom")
+		}
+		return nil
+	}, HandlerOnError(func(e *stream.Event, err error) {
+		failed <- string(e.Key)
+	}))
+	for _, key := range []string{"error", "panic"} {
+		select {
+		case k := <-failed:
+			assert.Equal(t, key, k)
+		case <-time.After(time.Second):
+			t.Fatal("error not reported")
+		}
+	}
+	stop()
+	labels := prometheus.Labels{StreamNameLabel: "handled", StreamHandlerLabel: "default"}
+	assertCounterEquals(t, g, labels, StreamHandledEvents, 3)
+	assertCounterEquals(t, g, labels, StreamHandlerErrors, 2)
+	assertCounterEquals(t, g, labels, StreamHandlerPanics, 1)
+}
+
+func TestHandleConcurrency(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	events := make(chan *stream.Event)
+	var running, maxRunning int32
+	release := make(chan struct{})
+	stop := handle(g, "handled-concurrently", events, func(e *stream.Event) error {
+		r := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if r <= m || atomic.CompareAndSwapInt32(&maxRunning, m, r) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return nil
+	}, HandlerConcurrency(3), HandlerName("concurrent"))
+
+	for i := 0; i < 3; i++ {
+		events <- &stream.Event{Ctx: context.Background()}
+	}
+	waitUntil(t, time.Second, "the events must be handled concurrently", func() bool {
+		return atomic.LoadInt32(&maxRunning) == 3
+	})
+	close(release)
+	stop()
+}
@@ -0,0 +1,175 @@
+package gorillaz
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultHandoffBucket = "gorillaz-handoffs"
+const defaultHandoffTimeout = 30 * time.Second
+
+type HandoffConfig struct {
+	Bucket       string        // Bucket is the Jetstream key value bucket holding the checkpoints (default: gorillaz-handoffs)
+	Timeout      time.Duration // Timeout is how long the replacement instance waits for the checkpoint of the terminating one (default: 30s)
+	PollInterval time.Duration // PollInterval is the delay between two reads of the checkpoint while waiting for it (default: 200ms)
+}
+
+type HandoffOpt func(c *HandoffConfig)
+
+// HandoffBucket stores the checkpoints in the given key value bucket
+func HandoffBucket(bucket string) HandoffOpt {
+	return func(c *HandoffConfig) {
+		c.Bucket = bucket
+	}
+}
+
+// HandoffTimeout configures how long the replacement instance waits for the checkpoint of the terminating one,
+// after which it starts from the last checkpoint saved, the previous instance is then considered dead
+func HandoffTimeout(timeout time.Duration) HandoffOpt {
+	return func(c *HandoffConfig) {
+		c.Timeout = timeout
+	}
+}
+
+// handoffCheckpoint is the value of the key of a consumer in the handoff bucket
+type handoffCheckpoint struct {
+	Owner    string    `json:"owner"`
+	Position []byte    `json:"position,omitempty"`
+	Released bool      `json:"released"`
+	At       time.Time `json:"at"`
+}
+
+func encodeHandoffCheckpoint(c handoffCheckpoint) ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func decodeHandoffCheckpoint(b []byte) (handoffCheckpoint, error) {
+	var c handoffCheckpoint
+	err := json.Unmarshal(b, &c)
+	return c, err
+}
+
+// Handoff passes the position of a consumer from the terminating instance to its replacement during rolling restarts,
+// so that the replacement starts where the terminating instance stopped, instead of reprocessing or skipping events.
+// The position is opaque, it can be a Jetstream sequence, a timestamp or the key of the last event processed.
+//
+// The replacement calls Acquire before consuming, it waits until the running instance calls Release with its last position.
+// The running instance can save its position with Checkpoint, it is used if the instance dies without releasing it.
+type Handoff struct {
+	g        *Gaz
+	name     string
+	config   *HandoffConfig
+	owner    string
+	revision uint64
+}
+
+// NewHandoff provisions the bucket of the checkpoints and returns the handoff of the consumer with the given name
+func (g *Gaz) NewHandoff(ctx context.Context, name string, opts ...HandoffOpt) (*Handoff, error) {
+	config := &HandoffConfig{
+		Bucket:       defaultHandoffBucket,
+		Timeout:      defaultHandoffTimeout,
+		PollInterval: 200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if err := g.ProvisionKeyValue(ctx, config.Bucket); err != nil {
+		return nil, err
+	}
+	return &Handoff{
+		g:      g,
+		name:   name,
+		config: config,
+		owner:  g.ServiceName + "@" + g.serviceAddress,
+	}, nil
+}
+
+// Acquire waits for the position released by the previous instance and takes over the consumer.
+// It returns nil if the consumer never saved a position. If the previous instance doesn't release its position
+// within the timeout, its last checkpoint is returned.
+func (h *Handoff) Acquire(ctx context.Context) ([]byte, error) {
+	deadline := time.Now().Add(h.config.Timeout)
+	for {
+		entry, err := h.g.kvGet(ctx, h.config.Bucket, h.name)
+		if err != nil {
+			return nil, err
+		}
+		var previous handoffCheckpoint
+		if entry != nil && entry.Operation == "" {
+			if previous, err = decodeHandoffCheckpoint(entry.Value); err != nil {
+				Log.Warn("invalid checkpoint, ignoring it", zap.String("consumer", h.name), zap.Error(err))
+				previous = handoffCheckpoint{Released: true}
+			}
+		} else {
+			previous.Released = true
+		}
+
+		timedOut := !time.Now().Before(deadline)
+		if previous.Released || previous.Owner == h.owner || timedOut {
+			if !previous.Released && previous.Owner != h.owner {
+				Log.Warn("checkpoint not released in time, starting from the last one saved", zap.String("consumer", h.name), zap.String("previous owner", previous.Owner))
+			}
+			var expected uint64
+			if entry != nil {
+				expected = entry.Revision
+			}
+			rev, err := h.put(ctx, previous.Position, false, expected)
+			if err == nil {
+				h.revision = rev
+				Log.Info("consumer handed off", zap.String("consumer", h.name), zap.String("previous owner", previous.Owner))
+				return previous.Position, nil
+			}
+			if err != errWrongRevision && err != ErrKeyExists {
+				return nil, err
+			}
+			// the checkpoint changed meanwhile, read it again
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(h.config.PollInterval):
+		}
+	}
+}
+
+// Checkpoint saves the position processed so far, it is used by the replacement if the instance dies without releasing it
+func (h *Handoff) Checkpoint(ctx context.Context, position []byte) error {
+	rev, err := h.put(ctx, position, false, h.revision)
+	if err == errWrongRevision {
+		Log.Warn("consumer taken over by another instance", zap.String("consumer", h.name))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	h.revision = rev
+	return nil
+}
+
+// Release publishes the last position processed by the terminating instance, the replacement waiting in Acquire starts from it.
+// The instance must not process events anymore once released.
+func (h *Handoff) Release(ctx context.Context, position []byte) error {
+	rev, err := h.put(ctx, position, true, h.revision)
+	if err != nil {
+		return err
+	}
+	h.revision = rev
+	Log.Info("consumer released", zap.String("consumer", h.name))
+	return nil
+}
+
+// put writes the checkpoint if its revision is still the expected one
+func (h *Handoff) put(ctx context.Context, position []byte, released bool, revision uint64) (uint64, error) {
+	b, err := encodeHandoffCheckpoint(handoffCheckpoint{Owner: h.owner, Position: position, Released: released, At: time.Now()})
+	if err != nil {
+		return 0, err
+	}
+	if revision == 0 {
+		return h.g.kvCreate(ctx, h.config.Bucket, h.name, b)
+	}
+	return h.g.kvUpdate(ctx, h.config.Bucket, h.name, b, revision)
+}
@@ -0,0 +1,20 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandoffCheckpointEncoding(t *testing.T) {
+	c := handoffCheckpoint{Owner: "billing@10.0.0.1:8080", Position: []byte("42"), Released: true, At: time.Unix(1600000000, 0).UTC()}
+	b, err := encodeHandoffCheckpoint(c)
+	assert.Nil(t, err)
+	decoded, err := decodeHandoffCheckpoint(b)
+	assert.Nil(t, err)
+	assert.Equal(t, c, decoded)
+
+	_, err = decodeHandoffCheckpoint([]byte("not json"))
+	assert.NotNil(t, err)
+}
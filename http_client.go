@@ -0,0 +1,193 @@
+package gorillaz
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	HttpClientLatencyMs = "http_client_latency_ms"
+	HttpClientErrors    = "http_client_errors"
+	HttpClientRetries   = "http_client_retries"
+)
+
+const HttpClientLabel = "client"
+const HttpHostLabel = "host"
+const HttpStatusLabel = "status"
+
+type httpClientMetrics struct {
+	latencySummary prometheus.Summary
+	errorCounter   *prometheus.CounterVec
+	retryCounter   prometheus.Counter
+}
+
+// map of metrics registered to Prometheus, by client and host
+var httpClientMetricsMu sync.Mutex
+var httpClientMonitorings = make(map[string]*httpClientMetrics)
+
+func httpClientMonitoring(g *Gaz, name, host string) *httpClientMetrics {
+	httpClientMetricsMu.Lock()
+	defer httpClientMetricsMu.Unlock()
+
+	k := name + "/" + host
+	if m, ok := httpClientMonitorings[k]; ok {
+		return m
+	}
+	labels := prometheus.Labels{HttpClientLabel: name, HttpHostLabel: host}
+	m := &httpClientMetrics{
+		latencySummary: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name:        HttpClientLatencyMs,
+			Help:        "distribution of the duration of outbound HTTP requests, in milliseconds",
+			Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			ConstLabels: labels,
+		}),
+		errorCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        HttpClientErrors,
+			Help:        "The total number of failed outbound HTTP requests, by status code, \"error\" if no response was received",
+			ConstLabels: labels,
+		}, []string{HttpStatusLabel}),
+		retryCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        HttpClientRetries,
+			Help:        "The total number of retried outbound HTTP requests",
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.latencySummary)
+	g.prometheusRegistry.MustRegister(m.errorCounter)
+	g.prometheusRegistry.MustRegister(m.retryCounter)
+	httpClientMonitorings[k] = m
+	return m
+}
+
+// HTTPClient returns a http.Client for the outbound requests of the service, named after the called service or API.
+// The requests are traced, monitored per host, and the idempotent ones failing without response or with 502, 503 or 504
+// are retried with an exponential backoff.
+// The timeout, the attempts and the backoff are read from the http.client.<name>.* keys, or else the http.client.* keys.
+func (g *Gaz) HTTPClient(name string) *http.Client {
+	return &http.Client{
+		Timeout: time.Duration(g.httpClientInt(name, "timeout.ms")) * time.Millisecond,
+		Transport: &observedTransport{
+			g:           g,
+			name:        name,
+			base:        http.DefaultTransport,
+			maxAttempts: g.httpClientInt(name, "max.attempts"),
+			backoff:     time.Duration(g.httpClientInt(name, "retry.backoff.ms")) * time.Millisecond,
+		},
+	}
+}
+
+func (g *Gaz) httpClientInt(name, key string) int {
+	if k := fmt.Sprintf("http.client.%s.%s", name, key); g.Viper.IsSet(k) {
+		return g.Viper.GetInt(k)
+	}
+	return g.Viper.GetInt("http.client." + key)
+}
+
+// observedTransport traces, monitors and retries the requests of a HTTPClient
+type observedTransport struct {
+	g           *Gaz
+	name        string
+	base        http.RoundTripper
+	maxAttempts int
+	backoff     time.Duration
+}
+
+func (t *observedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	m := httpClientMonitoring(t.g, t.name, req.URL.Host)
+	dep := dependencyMonitoring(t.g, ProtocolHttp, req.URL.Host)
+	if tracer != nil {
+		span, ctx := StartChildSpan(req.Context(), "HTTP "+req.Method)
+		defer span.Finish()
+		ext.SpanKindRPCClient.Set(span)
+		ext.HTTPMethod.Set(span, req.Method)
+		ext.HTTPUrl.Set(span, req.URL.String())
+		req = req.WithContext(ctx)
+		req.Header = req.Header.Clone()
+		if err := span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header)); err != nil {
+			Log.Debug("could not inject the span in the HTTP headers", zap.Error(err))
+		}
+	}
+
+	delay := t.backoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		resp, err := t.base.RoundTrip(req)
+		m.latencySummary.Observe(float64(time.Since(start).Nanoseconds()) / 1000000.0)
+		if err != nil {
+			m.errorCounter.WithLabelValues("error").Inc()
+		} else if resp.StatusCode >= 400 {
+			m.errorCounter.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+		}
+		dep.observe(httpOutcome(req, resp, err))
+		if !retriable(resp, err) || attempt >= t.maxAttempts || !rewindable(req) {
+			return resp, err
+		}
+		if resp != nil {
+			// the connection can be reused once the body is read
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		m.retryCounter.Inc()
+		dep.retryCounter.Inc()
+		Log.Debug("retrying HTTP request", zap.String("client", t.name), zap.String("host", req.URL.Host), zap.Int("attempt", attempt), zap.Error(err))
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// httpOutcome tells whether the target failed, the client errors are successes of the target
+func httpOutcome(req *http.Request, resp *http.Response, err error) string {
+	switch {
+	case err != nil && req.Context().Err() == context.Canceled:
+		return OutcomeCanceled
+	case err != nil || resp.StatusCode >= 500:
+		return OutcomeFailure
+	default:
+		return OutcomeSuccess
+	}
+}
+
+// retriable returns true if the request failed without response, or with a response telling the server is unavailable
+func retriable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// rewindable returns true if the request is idempotent and its body can be sent again
+func rewindable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
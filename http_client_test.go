@@ -0,0 +1,57 @@
+package gorillaz
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPClientRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	g := &Gaz{Viper: viper.New(), prometheusRegistry: prometheus.NewRegistry()}
+	g.Viper.Set("http.client.max.attempts", 3)
+	g.Viper.Set("http.client.retry.backoff.ms", 1)
+	g.Viper.Set("http.client.http-test.timeout.ms", 5000)
+	c := g.HTTPClient("http-test")
+	assert.Equal(t, 5000, int(c.Timeout.Milliseconds()))
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("body"))
+	assert.Nil(t, err)
+	resp, err := c.Do(req)
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "body", string(b), "the body is sent again")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	u, _ := url.Parse(srv.URL)
+	labels := map[string]string{HttpClientLabel: "http-test", HttpHostLabel: u.Host}
+	assertCounterEquals(t, g, labels, HttpClientRetries, 1)
+
+	// POST is not idempotent, it is not retried
+	atomic.StoreInt32(&calls, 0)
+	resp, err = c.Post(srv.URL, "text/plain", strings.NewReader("body"))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,168 @@
+package gorillaz
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+// ProcessedStore records the ids of the events already handled, for a retention window
+type ProcessedStore interface {
+	// Processed returns true if the id was recorded within the retention window
+	Processed(ctx context.Context, id string) (bool, error)
+	// MarkProcessed records the id
+	MarkProcessed(ctx context.Context, id string) error
+}
+
+// MemoryProcessedStore is a ProcessedStore local to the instance, suitable when a single instance consumes the events
+type MemoryProcessedStore struct {
+	mu        sync.Mutex
+	retention time.Duration
+	clock     Clock
+	processed map[string]time.Time
+	lastPurge time.Time
+}
+
+// NewMemoryProcessedStore returns a ProcessedStore keeping the ids in memory for the retention window
+func NewMemoryProcessedStore(retention time.Duration) *MemoryProcessedStore {
+	return &MemoryProcessedStore{
+		retention: retention,
+		clock:     SystemClock,
+		processed: make(map[string]time.Time),
+		lastPurge: SystemClock.Now(),
+	}
+}
+
+// SetClock sets the clock telling when the ids expire, for instance a ManualClock in tests
+func (s *MemoryProcessedStore) SetClock(c Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+	s.lastPurge = c.Now()
+}
+
+func (s *MemoryProcessedStore) Processed(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, ok := s.processed[id]
+	return ok && s.clock.Now().Sub(at) < s.retention, nil
+}
+
+func (s *MemoryProcessedStore) MarkProcessed(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	s.processed[id] = now
+	if now.Sub(s.lastPurge) >= s.retention {
+		for k, at := range s.processed {
+			if now.Sub(at) >= s.retention {
+				delete(s.processed, k)
+			}
+		}
+		s.lastPurge = now
+	}
+	return nil
+}
+
+// KeyValueProcessedStore is a ProcessedStore shared by the instances, backed by a Jetstream key value bucket
+type KeyValueProcessedStore struct {
+	g      *Gaz
+	bucket string
+}
+
+// NewKeyValueProcessedStore provisions the key value bucket keeping the ids for the retention window
+func (g *Gaz) NewKeyValueProcessedStore(ctx context.Context, bucket string, retention time.Duration) (*KeyValueProcessedStore, error) {
+	if err := g.ProvisionKeyValue(ctx, bucket, JetstreamMaxAge(retention)); err != nil {
+		return nil, err
+	}
+	return &KeyValueProcessedStore{g: g, bucket: bucket}, nil
+}
+
+// kvKey encodes the id so that it is a valid key whatever its characters
+func (s *KeyValueProcessedStore) kvKey(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func (s *KeyValueProcessedStore) Processed(ctx context.Context, id string) (bool, error) {
+	entry, err := s.g.kvGet(ctx, s.bucket, s.kvKey(id))
+	if err != nil {
+		return false, err
+	}
+	return entry != nil && entry.Operation == "", nil
+}
+
+func (s *KeyValueProcessedStore) MarkProcessed(ctx context.Context, id string) error {
+	_, err := s.g.kvPut(ctx, s.bucket, s.kvKey(id), nil)
+	return err
+}
+
+type IdempotencyConfig struct {
+	// EventId returns the id identifying the duplicates of the event, an empty id disables the deduplication of the event
+	// (default: the Nats message id, or the Jetstream stream and sequence, see DefaultEventId)
+	EventId func(subject string, e *stream.Event) string
+}
+
+type IdempotencyOpt func(c *IdempotencyConfig)
+
+// WithEventId identifies the duplicates with the given function
+func WithEventId(eventId func(subject string, e *stream.Event) string) IdempotencyOpt {
+	return func(c *IdempotencyConfig) {
+		c.EventId = eventId
+	}
+}
+
+// DefaultEventId returns the Nats message id of the event if it was published with one, see WithMsgId,
+// otherwise its Jetstream stream and sequence if it was consumed from Jetstream, otherwise an empty id
+func DefaultEventId(_ string, e *stream.Event) string {
+	if id := e.MsgId(); id != "" {
+		return id
+	}
+	if seq := e.StreamSeq(); seq > 0 {
+		return e.Stream() + "." + strconv.Itoa(seq)
+	}
+	return ""
+}
+
+// Idempotent wraps the handler so that the duplicates of the events already handled successfully are skipped.
+// Skipped duplicates are acknowledged. The id of an event is recorded in the store once the handler returns no error,
+// so an event whose handling fails is handled again when it is redelivered.
+func Idempotent(store ProcessedStore, handler MsgHandler, opts ...IdempotencyOpt) MsgHandler {
+	c := &IdempotencyConfig{
+		EventId: DefaultEventId,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return func(subject string, e *stream.Event) (*stream.Event, error) {
+		id := c.EventId(subject, e)
+		if id == "" {
+			return handler(subject, e)
+		}
+		ctx := e.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		processed, err := store.Processed(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if processed {
+			Log.Debug("duplicate event skipped", zap.String("subject", subject), zap.String("id", id))
+			return nil, e.Ack()
+		}
+		reply, err := handler(subject, e)
+		if err != nil {
+			return reply, err
+		}
+		if err := store.MarkProcessed(ctx, id); err != nil {
+			// the event is handled anyway, its duplicates won't be detected
+			Log.Warn("could not record processed event", zap.String("subject", subject), zap.String("id", id), zap.Error(err))
+		}
+		return reply, nil
+	}
+}
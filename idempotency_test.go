@@ -0,0 +1,65 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentHandler(t *testing.T) {
+	store := NewMemoryProcessedStore(time.Minute)
+	calls := 0
+	fail := true
+	h := Idempotent(store, func(subject string, e *stream.Event) (*stream.Event, error) {
+		calls++
+		if fail {
+			return nil, errors.New("failed")
+		}
+		return nil, nil
+	})
+
+	newEvent := func(id string) *stream.Event {
+		e := &stream.Event{Ctx: context.Background()}
+		e.SetMsgId(id)
+		return e
+	}
+
+	_, err := h("orders", newEvent("1"))
+	assert.NotNil(t, err)
+	fail = false
+	_, err = h("orders", newEvent("1"))
+	assert.Nil(t, err)
+	_, err = h("orders", newEvent("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls, "the duplicate of an event handled successfully must be skipped")
+
+	_, _ = h("orders", newEvent("2"))
+	_, _ = h("orders", &stream.Event{Ctx: context.Background()})
+	_, _ = h("orders", &stream.Event{Ctx: context.Background()})
+	assert.Equal(t, 5, calls, "events without id are not deduplicated")
+}
+
+func TestDefaultEventId(t *testing.T) {
+	e := &stream.Event{Ctx: context.Background()}
+	assert.Equal(t, "", DefaultEventId("orders", e))
+	e.SetStream("dev-orders")
+	e.SetStreamSeq(42)
+	assert.Equal(t, "dev-orders.42", DefaultEventId("orders", e))
+	e.SetMsgId("abc")
+	assert.Equal(t, "abc", DefaultEventId("orders", e))
+}
+
+func TestMemoryProcessedStoreRetention(t *testing.T) {
+	store := NewMemoryProcessedStore(10 * time.Millisecond)
+	ctx := context.Background()
+	assert.Nil(t, store.MarkProcessed(ctx, "1"))
+	processed, _ := store.Processed(ctx, "1")
+	assert.True(t, processed)
+	time.Sleep(20 * time.Millisecond)
+	processed, _ = store.Processed(ctx, "1")
+	assert.False(t, processed)
+}
@@ -0,0 +1,167 @@
+package gorillaz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// StreamResourcePrefix prefixes the name of a stream in the authorization rules
+const StreamResourcePrefix = "stream:"
+
+type peerIdentityKey struct{}
+
+// PeerIdentity returns the identity of the peer authenticated with a client certificate.
+// The identity is the SPIFFE ID of the certificate if any, its common name otherwise
+func PeerIdentity(ctx context.Context) (string, bool) {
+	if id, ok := ctx.Value(peerIdentityKey{}).(string); ok {
+		return id, true
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return identityFromPeer(p)
+}
+
+func identityFromPeer(p *peer.Peer) (string, bool) {
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return "", false
+	}
+	return certificateIdentity(tlsInfo.State.PeerCertificates[0])
+}
+
+func certificateIdentity(cert *x509.Certificate) (string, bool) {
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			return u.String(), true
+		}
+	}
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName, true
+	}
+	return "", false
+}
+
+// Authorizer holds the identities allowed per resource.
+// A resource is either a full gRPC method name, like "/stream.Stream/Stream", or a stream name prefixed by StreamResourcePrefix.
+// The "*" resource applies to the resources without rule and the "*" identity allows every authenticated peer
+type Authorizer struct {
+	rules map[string]map[string]struct{}
+}
+
+// NewAuthorizer parses the rules, formatted as "<resource> <identity>[,<identity>...]"
+func NewAuthorizer(rules []string) (*Authorizer, error) {
+	a := &Authorizer{rules: make(map[string]map[string]struct{})}
+	for _, r := range rules {
+		fields := strings.Fields(r)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid authorization rule %q, expected \"<resource> <identity>[,<identity>...]\"", r)
+		}
+		ids, ok := a.rules[fields[0]]
+		if !ok {
+			ids = make(map[string]struct{})
+			a.rules[fields[0]] = ids
+		}
+		for _, id := range strings.Split(fields[1], ",") {
+			if id != "" {
+				ids[id] = struct{}{}
+			}
+		}
+	}
+	return a, nil
+}
+
+// Allowed returns true if identity is allowed to access the resource
+func (a *Authorizer) Allowed(resource, identity string) bool {
+	ids, ok := a.rules[resource]
+	if !ok {
+		if ids, ok = a.rules["*"]; !ok {
+			return false
+		}
+	}
+	_, all := ids["*"]
+	_, allowed := ids[identity]
+	return all || allowed
+}
+
+func (a *Authorizer) authorize(ctx context.Context, resource string) error {
+	id, ok := PeerIdentity(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "no peer certificate identity")
+	}
+	if !a.Allowed(resource, id) {
+		Log.Warn("peer not allowed", zap.String("resource", resource), zap.String("identity", id))
+		return status.Errorf(codes.PermissionDenied, "%s is not allowed to access %s", id, resource)
+	}
+	return nil
+}
+
+// IdentityServerInterceptor exposes the peer identity on the context of unary calls and rejects the ones not allowed by the authorizer
+func IdentityServerInterceptor(a *Authorizer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = withPeerIdentity(ctx)
+		if err := a.authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// IdentityStreamServerInterceptor is the streaming counterpart of IdentityServerInterceptor
+func IdentityStreamServerInterceptor(a *Authorizer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := withPeerIdentity(ss.Context())
+		if err := a.authorize(ctx, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, &identityServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func withPeerIdentity(ctx context.Context) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if id, ok := identityFromPeer(p); ok {
+		return context.WithValue(ctx, peerIdentityKey{}, id)
+	}
+	return ctx
+}
+
+type identityServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// mutualTLSCredentials returns server credentials requiring a client certificate signed by the CA in caFile
+func mutualTLSCredentials(certFile, keyFile, caFile string) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}), nil
+}
@@ -0,0 +1,42 @@
+package gorillaz
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizer(t *testing.T) {
+	a, err := NewAuthorizer([]string{
+		"/svc.Service/Get spiffe://prod/reader,spiffe://prod/writer",
+		"stream:positions *",
+		"* spiffe://prod/admin",
+	})
+	failIf(t, err)
+
+	assert.True(t, a.Allowed("/svc.Service/Get", "spiffe://prod/reader"))
+	assert.False(t, a.Allowed("/svc.Service/Get", "spiffe://prod/admin"), "the method rule overrides the wildcard rule")
+	assert.True(t, a.Allowed("stream:positions", "spiffe://prod/anyone"))
+	assert.True(t, a.Allowed("/svc.Service/Put", "spiffe://prod/admin"))
+	assert.False(t, a.Allowed("/svc.Service/Put", "spiffe://prod/writer"))
+
+	_, err = NewAuthorizer([]string{"/svc.Service/Get"})
+	assert.True(t, err != nil)
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://prod/reader")
+	id, ok := certificateIdentity(&x509.Certificate{Subject: pkix.Name{CommonName: "reader"}, URIs: []*url.URL{spiffe}})
+	assert.True(t, ok)
+	assert.Equal(t, "spiffe://prod/reader", id)
+
+	id, ok = certificateIdentity(&x509.Certificate{Subject: pkix.Name{CommonName: "reader"}})
+	assert.True(t, ok)
+	assert.Equal(t, "reader", id)
+
+	_, ok = certificateIdentity(&x509.Certificate{})
+	assert.False(t, ok)
+}
@@ -0,0 +1,110 @@
+package gorillaz
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	StreamConsumerInterceptorErrors = "stream_consumer_interceptor_errors"
+)
+
+// ConsumerInterceptor wraps the delivery of the events received by a stream consumer, for instance to decompress them,
+// validate their schema or measure them. The interceptor delivers the event, possibly modified, by calling next,
+// and drops it by returning without calling next. The error it returns drops the event as well, it is logged and counted.
+// next must be called synchronously, before the interceptor returns.
+type ConsumerInterceptor func(next EventHandler) EventHandler
+
+// WithInterceptors applies the interceptors to the events received by the consumer, in order: the first one is the outermost.
+// They run after the interceptors of the stream endpoint, see EndpointInterceptors.
+// They do not apply to the GetAndWatch consumers, whose events are not stream.Event.
+func WithInterceptors(interceptors ...ConsumerInterceptor) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.Interceptors = append(c.Interceptors, interceptors...)
+	}
+}
+
+// EndpointInterceptors applies the interceptors to the events received by all the consumers of the stream endpoint,
+// before the interceptors of each consumer, see WithInterceptors
+func EndpointInterceptors(interceptors ...ConsumerInterceptor) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.interceptors = append(config.interceptors, interceptors...)
+	}
+}
+
+// errDeliveryStopped is returned by the delivery of an event when the event channel was closed by the application
+var errDeliveryStopped = errors.New("delivery stopped")
+
+// interceptorChain wraps the delivery of the events of a consumer with its interceptors, it is used by the consumer goroutine only
+type interceptorChain struct {
+	handler   EventHandler
+	delivered bool // delivered is true once the event handled was delivered
+}
+
+func newInterceptorChain(deliver func(*stream.Event) bool, interceptors ...[]ConsumerInterceptor) *interceptorChain {
+	chain := &interceptorChain{}
+	chain.handler = func(e *stream.Event) error {
+		if !deliver(e) {
+			return errDeliveryStopped
+		}
+		chain.delivered = true
+		return nil
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		for j := len(interceptors[i]) - 1; j >= 0; j-- {
+			chain.handler = interceptors[i][j](chain.handler)
+		}
+	}
+	return chain
+}
+
+// handle runs the interceptors and delivers the event, it returns whether the event was delivered,
+// and errDeliveryStopped if the event channel was closed
+func (c *interceptorChain) handle(e *stream.Event) (delivered bool, err error) {
+	c.delivered = false
+	err = c.handler(e)
+	return c.delivered, err
+}
+
+// intercept handles the event with the interceptors of the consumer, it returns false if the event channel was closed.
+// The events dropped by the interceptors are acknowledged with ack, if any, so that they are not sent again.
+func (c *consumer) intercept(evt *stream.Event, ack func() error) (open bool) {
+	delivered, err := c.interceptors.handle(evt)
+	if err == errDeliveryStopped {
+		return false
+	}
+	if err != nil {
+		Log.Warn("event rejected by an interceptor", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), RedactedKey(evt.Key), zap.Error(err))
+		interceptorErrorsCounter(c.endpoint.g, c.streamName).Inc()
+	}
+	if !delivered {
+		ackDropped(ack)
+	}
+	return true
+}
+
+var interceptorErrorsMu sync.Mutex
+var interceptorErrorsCounters = make(map[string]prometheus.Counter)
+
+func interceptorErrorsCounter(g *Gaz, streamName string) prometheus.Counter {
+	interceptorErrorsMu.Lock()
+	defer interceptorErrorsMu.Unlock()
+
+	if c, ok := interceptorErrorsCounters[streamName]; ok {
+		return c
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: StreamConsumerInterceptorErrors,
+		Help: "The total number of events received rejected by an interceptor with an error",
+		ConstLabels: prometheus.Labels{
+			StreamNameLabel: streamName,
+		},
+	})
+	g.prometheusRegistry.MustRegister(c)
+	interceptorErrorsCounters[streamName] = c
+	return c
+}
@@ -0,0 +1,85 @@
+package gorillaz
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptorChain(t *testing.T) {
+	var calls []string
+	tag := func(name string) ConsumerInterceptor {
+		return func(next EventHandler) EventHandler {
+			return func(e *stream.Event) error {
+				calls = append(calls, name)
+				return next(e)
+			}
+		}
+	}
+	var delivered []*stream.Event
+	chain := newInterceptorChain(func(e *stream.Event) bool {
+		delivered = append(delivered, e)
+		return true
+	}, []ConsumerInterceptor{tag("endpoint")}, []ConsumerInterceptor{tag("first"), tag("second")})
+
+	ok, err := chain.handle(&stream.Event{})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"endpoint", "first", "second"}, calls)
+	assert.Len(t, delivered, 1)
+
+	closed := newInterceptorChain(func(e *stream.Event) bool { return false })
+	_, err = closed.handle(&stream.Event{})
+	assert.Equal(t, errDeliveryStopped, err)
+}
+
+func TestConsumerInterceptors(t *testing.T) {
+	upper := func(next EventHandler) EventHandler {
+		return func(e *stream.Event) error {
+			e.Value = []byte(strings.ToUpper(string(e.Value)))
+			return next(e)
+		}
+	}
+	dropEmpty := func(next EventHandler) EventHandler {
+		return func(e *stream.Event) error {
+			if len(e.Value) == 0 {
+				return nil
+			}
+			return next(e)
+		}
+	}
+	rejectInvalid := func(next EventHandler) EventHandler {
+		return func(e *stream.Event) error {
+			if string(e.Value) == "INVALID" {
+				return errors.New("invalid schema")
+			}
+			return next(e)
+		}
+	}
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery(), WithStreamEndpointOptions(EndpointInterceptors(upper)))
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("intercepted", "dummy.type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "intercepted", WithInterceptors(dropEmpty, rejectInvalid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["intercepted"]) == 1
+	})
+
+	provider.Submit(&stream.Event{Key: []byte("1")})
+	provider.Submit(&stream.Event{Key: []byte("2"), Value: []byte("invalid")})
+	provider.Submit(&stream.Event{Key: []byte("3"), Value: []byte("valid")})
+	assertReceived(t, "intercepted", consumer.EvtChan(), &stream.Event{Key: []byte("3"), Value: []byte("VALID")})
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "intercepted"}, StreamConsumerInterceptorErrors, 1)
+}
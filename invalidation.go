@@ -0,0 +1,146 @@
+package gorillaz
+
+import (
+	"math"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+const (
+	CacheInvalidations     = "cache_invalidations"
+	CacheInvalidationLagMs = "cache_invalidation_lag_ms"
+
+	CacheInvalidationLabel = "cache"
+)
+
+// name of the handler of the invalidation events, distinguishing its metrics
+const invalidationHandlerName = "invalidation"
+
+// Invalidator is a local cache whose entries are invalidated by the events of a stream or a Nats subject
+type Invalidator interface {
+	Invalidate(key []byte)
+}
+
+// InvalidatorFunc is a function implementing Invalidator
+type InvalidatorFunc func(key []byte)
+
+func (f InvalidatorFunc) Invalidate(key []byte) {
+	f(key)
+}
+
+type InvalidationConfig struct {
+	Match func(e *stream.Event) bool     // Match selects the events invalidating the cache (default: nil, every event)
+	Keys  func(e *stream.Event) [][]byte // Keys returns the keys invalidated by an event (default: the key of the event)
+}
+
+type InvalidationOpt func(c *InvalidationConfig)
+
+// InvalidateMatching invalidates the cache with the events selected by match only
+func InvalidateMatching(match func(e *stream.Event) bool) InvalidationOpt {
+	return func(c *InvalidationConfig) {
+		c.Match = match
+	}
+}
+
+// InvalidateKeys invalidates the keys returned by keys for each event, instead of the key of the event
+func InvalidateKeys(keys func(e *stream.Event) [][]byte) InvalidationOpt {
+	return func(c *InvalidationConfig) {
+		c.Keys = keys
+	}
+}
+
+// InvalidateOnStream invalidates the entries of the cache named name with the events of the consumer, until the consumer
+// is stopped or the returned function is called. It handles the events of the consumer, see StreamConsumer.Handle.
+// The invalidations are counted in cache_invalidations, and their lag behind the events in cache_invalidation_lag_ms.
+func (g *Gaz) InvalidateOnStream(name string, consumer StreamConsumer, cache Invalidator, opts ...InvalidationOpt) (stop func()) {
+	inv := newCacheInvalidation(g, name, cache, opts)
+	return consumer.Handle(func(e *stream.Event) error {
+		inv.invalidate(e)
+		return nil
+	}, HandlerName(invalidationHandlerName))
+}
+
+// InvalidateOnNatsSubject invalidates the entries of the cache named name with the events published on the subject,
+// see InvalidateOnStream
+func (g *Gaz) InvalidateOnNatsSubject(name, subject string, cache Invalidator, opts ...InvalidationOpt) (*NatsSubscription, error) {
+	inv := newCacheInvalidation(g, name, cache, opts)
+	return g.SubscribeNatsSubject(subject, func(subject string, e *stream.Event) (*stream.Event, error) {
+		inv.invalidate(e)
+		return nil, nil
+	})
+}
+
+type cacheInvalidation struct {
+	g       *Gaz
+	cache   Invalidator
+	config  *InvalidationConfig
+	metrics *invalidationMetrics
+}
+
+func newCacheInvalidation(g *Gaz, name string, cache Invalidator, opts []InvalidationOpt) *cacheInvalidation {
+	config := &InvalidationConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return &cacheInvalidation{g: g, cache: cache, config: config, metrics: invalidationMonitoring(g, name)}
+}
+
+func (i *cacheInvalidation) invalidate(e *stream.Event) {
+	if i.config.Match != nil && !i.config.Match(e) {
+		return
+	}
+	keys := [][]byte{e.Key}
+	if i.config.Keys != nil {
+		keys = i.config.Keys(e)
+	}
+	for _, k := range keys {
+		i.cache.Invalidate(k)
+		i.metrics.invalidationsCounter.Inc()
+	}
+	// the lag is measured from when the event happened, or was streamed if unknown
+	ts := stream.EventTimestamp(e)
+	if ts <= 0 {
+		ts = stream.StreamTimestamp(e)
+	}
+	if ts > 0 && len(keys) > 0 {
+		nowMs := float64(i.g.Clock().Now().UnixNano()) / 1000000.0
+		i.metrics.lagSummary.Observe(math.Max(0, nowMs-float64(ts)/1000000.0))
+	}
+}
+
+type invalidationMetrics struct {
+	invalidationsCounter prometheus.Counter
+	lagSummary           prometheus.Summary
+}
+
+// map of metrics registered to Prometheus, by cache
+var invalidationMetricsMu sync.Mutex
+var invalidationMonitorings = make(map[string]*invalidationMetrics)
+
+func invalidationMonitoring(g *Gaz, name string) *invalidationMetrics {
+	invalidationMetricsMu.Lock()
+	defer invalidationMetricsMu.Unlock()
+
+	if m, ok := invalidationMonitorings[name]; ok {
+		return m
+	}
+	labels := prometheus.Labels{CacheInvalidationLabel: name}
+	m := &invalidationMetrics{
+		invalidationsCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        CacheInvalidations,
+			Help:        "The total number of cache entries invalidated",
+			ConstLabels: labels,
+		}),
+		lagSummary: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name:        CacheInvalidationLagMs,
+			Help:        "distribution of the time between the events and the invalidation of the cache, in milliseconds",
+			Objectives:  map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.invalidationsCounter, m.lagSummary)
+	invalidationMonitorings[name] = m
+	return m
+}
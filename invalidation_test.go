@@ -0,0 +1,59 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvalidateOnStream(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("invalidations", "dummy.type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "invalidations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["invalidations"]) == 1
+	})
+
+	invalidated := make(chan string, 10)
+	cache := InvalidatorFunc(func(key []byte) {
+		invalidated <- string(key)
+	})
+	stop := g.InvalidateOnStream("flights", consumer, cache,
+		InvalidateMatching(func(e *stream.Event) bool {
+			return e.EventTypeStr() == "flight.updated"
+		}),
+		InvalidateKeys(func(e *stream.Event) [][]byte {
+			return [][]byte{e.Key, e.Value}
+		}))
+	defer stop()
+
+	ignored := &stream.Event{Key: []byte("af123"), Value: []byte("af123-summary")}
+	ignored.SetEventTypeStr("flight.created")
+	provider.Submit(ignored)
+	updated := &stream.Event{Key: []byte("lh456"), Value: []byte("lh456-summary")}
+	updated.SetEventTypeStr("flight.updated")
+	updated.SetEventTime(time.Now())
+	provider.Submit(updated)
+
+	for _, k := range []string{"lh456", "lh456-summary"} {
+		select {
+		case key := <-invalidated:
+			assert.Equal(t, k, key)
+		case <-time.After(5 * time.Second):
+			t.Fatal("cache not invalidated")
+		}
+	}
+	assertCounterEquals(t, g, map[string]string{CacheInvalidationLabel: "flights"}, CacheInvalidations, 2)
+}
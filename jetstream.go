@@ -0,0 +1,272 @@
+package gorillaz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// jsDefaultApiPrefix is the prefix of the Jetstream API of the account and domain gorillaz is connected to
+const jsDefaultApiPrefix = "$JS.API"
+
+// jsApiTimeout is the timeout applied on Jetstream API requests when the context has no deadline
+const jsApiTimeout = 5 * time.Second
+
+// JetstreamApiError is the error returned by the Jetstream API
+type JetstreamApiError struct {
+	Code        int    `json:"code"`
+	Description string `json:"description,omitempty"`
+}
+
+func (e *JetstreamApiError) Error() string {
+	return fmt.Sprintf("jetstream api error %d: %s", e.Code, e.Description)
+}
+
+type jsApiResponse struct {
+	Type  string             `json:"type,omitempty"`
+	Error *JetstreamApiError `json:"error,omitempty"`
+}
+
+type jsStreamConfig struct {
+	Name             string                     `json:"name"`
+	Subjects         []string                   `json:"subjects,omitempty"`
+	Retention        string                     `json:"retention"`
+	MaxConsumers     int                        `json:"max_consumers"`
+	MaxMsgs          int64                      `json:"max_msgs"`
+	MaxBytes         int64                      `json:"max_bytes"`
+	Discard          string                     `json:"discard"`
+	MaxAge           time.Duration              `json:"max_age"`
+	Storage          string                     `json:"storage"`
+	Replicas         int                        `json:"num_replicas"`
+	DuplicateWindow  time.Duration              `json:"duplicate_window,omitempty"`
+	Republish        *JetstreamRepublish        `json:"republish,omitempty"`
+	SubjectTransform *JetstreamSubjectTransform `json:"subject_transform,omitempty"`
+	MaxMsgsPerSubj   int64                      `json:"max_msgs_per_subject,omitempty"`
+}
+
+type jsConsumerConfig struct {
+	Durable        string        `json:"durable_name,omitempty"`
+	DeliverSubject string        `json:"deliver_subject,omitempty"`
+	DeliverPolicy  string        `json:"deliver_policy"`
+	AckPolicy      string        `json:"ack_policy"`
+	AckWait        time.Duration `json:"ack_wait,omitempty"`
+	FilterSubject  string        `json:"filter_subject,omitempty"`
+}
+
+type jsPubAck struct {
+	jsApiResponse
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+}
+
+type jsCreateConsumerRequest struct {
+	Stream string           `json:"stream_name"`
+	Config jsConsumerConfig `json:"config"`
+}
+
+// jsApiRequest sends a JSON request to the Jetstream API and unmarshals the reply in resp if it is not nil
+// If the Jetstream API replies with an error, a *JetstreamApiError is returned
+func (g *Gaz) jsApiRequest(ctx context.Context, subject string, req interface{}, resp interface{}) error {
+	if g.NatsConn == nil {
+		return fmt.Errorf("gorillaz nats connection is nil, cannot call jetstream api")
+	}
+	var data []byte
+	if req != nil {
+		var err error
+		data, err = json.Marshal(req)
+		if err != nil {
+			return err
+		}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jsApiTimeout)
+		defer cancel()
+	}
+	msg, err := g.NatsConn.RequestWithContext(ctx, subject, data)
+	if err != nil {
+		return err
+	}
+	var r jsApiResponse
+	if err := json.Unmarshal(msg.Data, &r); err != nil {
+		return fmt.Errorf("could not unmarshal jetstream api response: %w", err)
+	}
+	if r.Error != nil {
+		return r.Error
+	}
+	if resp != nil {
+		return json.Unmarshal(msg.Data, resp)
+	}
+	return nil
+}
+
+// jsPublish publishes the message on a subject captured by a stream and waits for the acknowledgment of Jetstream.
+// It returns the sequence of the message in the stream, or a *JetstreamApiError if Jetstream rejected it.
+func (g *Gaz) jsPublish(ctx context.Context, subject string, data []byte, header map[string][]string) (uint64, error) {
+	if g.NatsConn == nil {
+		return 0, fmt.Errorf("gorillaz nats connection is nil, cannot publish on %s", subject)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jsApiTimeout)
+		defer cancel()
+	}
+	sub, err := g.NatsConn.SubscribeSync(nats.NewInbox())
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := sub.Unsubscribe(); err != nil {
+			Log.Debug("Could not unsubscribe", zap.Error(err))
+		}
+	}()
+	err = g.NatsConn.PublishMsg(&nats.Msg{Subject: subject, Reply: sub.Subject, Data: data, Header: header})
+	if err != nil {
+		return 0, err
+	}
+	msg, err := sub.NextMsgWithContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var ack jsPubAck
+	if err := json.Unmarshal(msg.Data, &ack); err != nil {
+		return 0, fmt.Errorf("could not unmarshal jetstream publish ack: %w", err)
+	}
+	if ack.Error != nil {
+		return 0, ack.Error
+	}
+	return ack.Seq, nil
+}
+
+// JetstreamRepublish republishes the messages stored in the stream matching Source to Destination
+type JetstreamRepublish struct {
+	Source      string `json:"src"`
+	Destination string `json:"dest"`
+	HeadersOnly bool   `json:"headers_only,omitempty"`
+}
+
+// JetstreamSubjectTransform transforms the subjects matching Source to Destination before storing the messages
+type JetstreamSubjectTransform struct {
+	Source      string `json:"src"`
+	Destination string `json:"dest"`
+}
+
+// JetstreamConfig is the configuration applied when provisioning a Jetstream stream
+type JetstreamConfig struct {
+	Storage          string        // Storage is either "file" or "memory" (default: file)
+	Replicas         int           // Replicas is the number of replicas of the stream in a cluster (default: 1)
+	MaxAge           time.Duration // MaxAge is the maximum age of the messages in the stream, 0 means no limit
+	DuplicateWindow  time.Duration // DuplicateWindow is the window in which messages published with the same id are discarded, see WithMsgId
+	Republish        *JetstreamRepublish
+	SubjectTransform *JetstreamSubjectTransform
+}
+
+type JetstreamConfigOpt func(c *JetstreamConfig)
+
+func defaultJetstreamConfig() *JetstreamConfig {
+	return &JetstreamConfig{
+		Storage:  "file",
+		Replicas: 1,
+	}
+}
+
+func JetstreamMemoryStorage() JetstreamConfigOpt {
+	return func(c *JetstreamConfig) {
+		c.Storage = "memory"
+	}
+}
+
+func JetstreamReplicas(replicas int) JetstreamConfigOpt {
+	return func(c *JetstreamConfig) {
+		c.Replicas = replicas
+	}
+}
+
+func JetstreamMaxAge(maxAge time.Duration) JetstreamConfigOpt {
+	return func(c *JetstreamConfig) {
+		c.MaxAge = maxAge
+	}
+}
+
+// JetstreamDuplicateWindow configures the window in which messages published with the same id are discarded
+func JetstreamDuplicateWindow(window time.Duration) JetstreamConfigOpt {
+	return func(c *JetstreamConfig) {
+		c.DuplicateWindow = window
+	}
+}
+
+// JetstreamRepublishTo republishes the messages stored in the stream matching source to destination.
+// If headersOnly is true, only the headers are republished, not the payload
+func JetstreamRepublishTo(source, destination string, headersOnly bool) JetstreamConfigOpt {
+	return func(c *JetstreamConfig) {
+		c.Republish = &JetstreamRepublish{Source: source, Destination: destination, HeadersOnly: headersOnly}
+	}
+}
+
+// JetstreamTransformSubject transforms the subjects matching source to destination before storing the messages in the stream
+func JetstreamTransformSubject(source, destination string) JetstreamConfigOpt {
+	return func(c *JetstreamConfig) {
+		c.SubjectTransform = &JetstreamSubjectTransform{Source: source, Destination: destination}
+	}
+}
+
+// ProvisionJetstream creates the Jetstream stream capturing the given subjects, or updates it if it already exists.
+// The stream name is prefixed by the env if missing, subjects are prefixed the same way as in NatsPublish
+func (g *Gaz) ProvisionJetstream(ctx context.Context, streamName string, subjects []string, opts ...JetstreamConfigOpt) error {
+	return g.provisionStream(ctx, g.jetstreamConfig(streamName, subjects, opts...))
+}
+
+// jetstreamConfig returns the configuration of the Jetstream stream provisioned by ProvisionJetstream
+func (g *Gaz) jetstreamConfig(streamName string, subjects []string, opts ...JetstreamConfigOpt) jsStreamConfig {
+	config := defaultJetstreamConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+	name := g.AddStreamEnvIfMissing(streamName)
+	prefixed := make([]string, len(subjects))
+	for i, s := range subjects {
+		prefixed[i] = g.natsSubject(s)
+	}
+	sc := jsStreamConfig{
+		Name:            name,
+		Subjects:        prefixed,
+		Retention:       "limits",
+		MaxConsumers:    -1,
+		MaxMsgs:         -1,
+		MaxBytes:        -1,
+		Discard:         "old",
+		MaxAge:          config.MaxAge,
+		Storage:         config.Storage,
+		Replicas:        config.Replicas,
+		DuplicateWindow: config.DuplicateWindow,
+	}
+	if r := config.Republish; r != nil {
+		sc.Republish = &JetstreamRepublish{Source: g.natsSubject(r.Source), Destination: g.natsSubject(r.Destination), HeadersOnly: r.HeadersOnly}
+	}
+	if t := config.SubjectTransform; t != nil {
+		sc.SubjectTransform = &JetstreamSubjectTransform{Source: g.natsSubject(t.Source), Destination: g.natsSubject(t.Destination)}
+	}
+	return sc
+}
+
+// provisionStream creates the stream with the given configuration, or updates it if it already exists
+func (g *Gaz) provisionStream(ctx context.Context, sc jsStreamConfig) error {
+	name := sc.Name
+	action := "CREATE"
+	err := g.jsApiRequest(ctx, jsDefaultApiPrefix+".STREAM.INFO."+name, nil, nil)
+	if err == nil {
+		action = "UPDATE"
+	} else if apiErr, ok := err.(*JetstreamApiError); !ok || apiErr.Code != 404 {
+		return fmt.Errorf("could not get info of stream %s: %w", name, err)
+	}
+	Log.Info("provisioning jetstream", zap.String("stream", name), zap.String("action", action))
+	err = g.jsApiRequest(ctx, jsDefaultApiPrefix+".STREAM."+action+"."+name, sc, nil)
+	if err != nil {
+		return fmt.Errorf("could not provision stream %s: %w", name, err)
+	}
+	return nil
+}
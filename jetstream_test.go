@@ -0,0 +1,57 @@
+package gorillaz
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJetstreamConfig(t *testing.T) {
+	g := &Gaz{Env: "dev", addEnvPrefixToNats: true}
+	tests := []struct {
+		name     string
+		opts     []JetstreamConfigOpt
+		expected string
+	}{
+		{
+			name: "default",
+			expected: `{"name":"dev-orders","subjects":["dev.orders.>"],"retention":"limits","max_consumers":-1,"max_msgs":-1,"max_bytes":-1,
+				"discard":"old","max_age":0,"storage":"file","num_replicas":1}`,
+		},
+		{
+			name: "dedupe window",
+			opts: []JetstreamConfigOpt{JetstreamDuplicateWindow(2 * time.Minute)},
+			expected: `{"name":"dev-orders","subjects":["dev.orders.>"],"retention":"limits","max_consumers":-1,"max_msgs":-1,"max_bytes":-1,
+				"discard":"old","max_age":0,"storage":"file","num_replicas":1,"duplicate_window":120000000000}`,
+		},
+		{
+			name: "republish",
+			opts: []JetstreamConfigOpt{JetstreamRepublishTo("orders.>", "audit.orders.>", true)},
+			expected: `{"name":"dev-orders","subjects":["dev.orders.>"],"retention":"limits","max_consumers":-1,"max_msgs":-1,"max_bytes":-1,
+				"discard":"old","max_age":0,"storage":"file","num_replicas":1,
+				"republish":{"src":"dev.orders.>","dest":"dev.audit.orders.>","headers_only":true}}`,
+		},
+		{
+			name: "subject transform",
+			opts: []JetstreamConfigOpt{JetstreamTransformSubject("orders.*", "orders.v2.{{wildcard(1)}}")},
+			expected: `{"name":"dev-orders","subjects":["dev.orders.>"],"retention":"limits","max_consumers":-1,"max_msgs":-1,"max_bytes":-1,
+				"discard":"old","max_age":0,"storage":"file","num_replicas":1,
+				"subject_transform":{"src":"dev.orders.*","dest":"dev.orders.v2.{{wildcard(1)}}"}}`,
+		},
+		{
+			name: "memory storage, replicas and max age",
+			opts: []JetstreamConfigOpt{JetstreamMemoryStorage(), JetstreamReplicas(3), JetstreamMaxAge(time.Hour)},
+			expected: `{"name":"dev-orders","subjects":["dev.orders.>"],"retention":"limits","max_consumers":-1,"max_msgs":-1,"max_bytes":-1,
+				"discard":"old","max_age":3600000000000,"storage":"memory","num_replicas":3}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := json.Marshal(g.jetstreamConfig("orders", []string{"orders.>"}, test.opts...))
+			assert.NoError(t, err)
+			assert.JSONEq(t, test.expected, string(b))
+		})
+	}
+}
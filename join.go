@@ -0,0 +1,334 @@
+package gorillaz
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	JoinJoinedEvents    = "join_joined_events"
+	JoinUnmatchedEvents = "join_unmatched_events"
+	JoinPublishErrors   = "join_publish_errors"
+	JoinPendingEvents   = "join_pending_events"
+)
+
+const JoinLabel = "join"
+
+// JoinSide tells from which of the two joined streams an event comes
+type JoinSide int
+
+const (
+	JoinLeft JoinSide = iota
+	JoinRight
+)
+
+func (s JoinSide) String() string {
+	if s == JoinLeft {
+		return "left"
+	}
+	return "right"
+}
+
+// JoinedEvent is the pair of events of the two streams sharing a join key.
+// With JoinEmitUnmatched, one of the events is nil when it didn't arrive within the window.
+type JoinedEvent struct {
+	Key   []byte
+	Left  *stream.Event
+	Right *stream.Event
+}
+
+// Derive returns a new event with the key and the value derived from the joined events, see stream.Event.Derive.
+// Its origin is the oldest origin of the joined events.
+func (j JoinedEvent) Derive(key, value []byte) *stream.Event {
+	parent := j.Left
+	if l, r := stream.Origin(j.Left), stream.Origin(j.Right); parent == nil || (r > 0 && (l == 0 || r < l)) {
+		parent = j.Right
+	}
+	return parent.Derive(key, value)
+}
+
+// JoinPublisher publishes a joined event, for instance to a StreamProvider
+type JoinPublisher func(j JoinedEvent) error
+
+type JoinOpt func(*JoinConfig)
+
+type JoinConfig struct {
+	Window        time.Duration                 // Window is how long an event waits for the events of the other stream with the same key (default: 1 minute)
+	LeftKey       func(*stream.Event) []byte    // LeftKey returns the join key of the events of the left stream (default: the event key)
+	RightKey      func(*stream.Event) []byte    // RightKey returns the join key of the events of the right stream (default: the event key)
+	MaxPending    int                           // MaxPending is the maximum number of events waiting on each side, the oldest are expired beyond (default: 0, unlimited)
+	EmitUnmatched bool                          // EmitUnmatched publishes the events expired without a match, with a nil counterpart (default: false, dropped)
+	OnUnmatched   func(JoinSide, *stream.Event) // OnUnmatched is called for each event expired without a match (default: log)
+	Clock         Clock                         // Clock tells when the events arrive and expire (default: the clock of gorillaz)
+}
+
+func defaultJoinConfig() *JoinConfig {
+	return &JoinConfig{
+		Window: time.Minute,
+	}
+}
+
+// JoinWindow sets how long an event waits for the events of the other stream with the same key
+func JoinWindow(window time.Duration) JoinOpt {
+	return func(c *JoinConfig) {
+		c.Window = window
+	}
+}
+
+// JoinKeys sets the functions returning the join key of the events of each stream, when it is not the event key
+func JoinKeys(left, right func(*stream.Event) []byte) JoinOpt {
+	return func(c *JoinConfig) {
+		c.LeftKey = left
+		c.RightKey = right
+	}
+}
+
+// JoinMaxPending bounds the number of events waiting on each side, the oldest are expired beyond
+func JoinMaxPending(n int) JoinOpt {
+	return func(c *JoinConfig) {
+		c.MaxPending = n
+	}
+}
+
+// JoinEmitUnmatched publishes the events expired without a match, with a nil counterpart, like an outer join
+func JoinEmitUnmatched() JoinOpt {
+	return func(c *JoinConfig) {
+		c.EmitUnmatched = true
+	}
+}
+
+// JoinClock sets the clock telling when the events arrive and expire, for instance to join recorded streams in virtual time
+func JoinClock(clock Clock) JoinOpt {
+	return func(c *JoinConfig) {
+		c.Clock = clock
+	}
+}
+
+// JoinOnUnmatched sets the function called for each event expired without a match, such as an order update
+// arrived too late after its order
+func JoinOnUnmatched(onUnmatched func(JoinSide, *stream.Event)) JoinOpt {
+	return func(c *JoinConfig) {
+		c.OnUnmatched = onUnmatched
+	}
+}
+
+type joinMetrics struct {
+	joinedCounter    prometheus.Counter
+	unmatchedCounter prometheus.Counter
+	errorCounter     prometheus.Counter
+	pendingGauge     prometheus.Gauge
+}
+
+// map of metrics registered to Prometheus, by join
+var joinMetricsMu sync.Mutex
+var joinMonitorings = make(map[string]*joinMetrics)
+
+func joinMonitoring(g *Gaz, name string) *joinMetrics {
+	joinMetricsMu.Lock()
+	defer joinMetricsMu.Unlock()
+
+	if m, ok := joinMonitorings[name]; ok {
+		return m
+	}
+	labels := prometheus.Labels{JoinLabel: name}
+	m := &joinMetrics{
+		joinedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        JoinJoinedEvents,
+			Help:        "The total number of joined events published",
+			ConstLabels: labels,
+		}),
+		unmatchedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        JoinUnmatchedEvents,
+			Help:        "The total number of events expired without a match in the other stream",
+			ConstLabels: labels,
+		}),
+		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        JoinPublishErrors,
+			Help:        "The total number of joined events that could not be published",
+			ConstLabels: labels,
+		}),
+		pendingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        JoinPendingEvents,
+			Help:        "The number of events waiting for a match in the other stream",
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.joinedCounter)
+	g.prometheusRegistry.MustRegister(m.unmatchedCounter)
+	g.prometheusRegistry.MustRegister(m.errorCounter)
+	g.prometheusRegistry.MustRegister(m.pendingGauge)
+	joinMonitorings[name] = m
+	return m
+}
+
+// pendingEvent is the last event of a key on one side, waiting for the events of the other side
+type pendingEvent struct {
+	evt       *stream.Event
+	arrivedAt time.Time
+	joined    bool
+}
+
+type joiner struct {
+	name    string
+	config  *JoinConfig
+	metrics *joinMetrics
+	publish JoinPublisher
+	pending [2]map[string]*pendingEvent
+}
+
+// Join consumes two streams, for instance orders and order updates, and publishes the events of the two streams sharing a join key.
+// Each side keeps the last event of every key for the window: an event is joined with the last event of the other side
+// with the same key, if it arrived within the window. So an order is joined with each of its updates, and an update
+// arrived before its order is joined once the order arrives.
+// The events expired without a match are reported with JoinOnUnmatched, and published with JoinEmitUnmatched.
+// Events are acknowledged once they leave the window. Join blocks until both streams are closed or ctx is done.
+func (g *Gaz) Join(ctx context.Context, name string, left, right <-chan *stream.Event, publish JoinPublisher, opts ...JoinOpt) error {
+	config := defaultJoinConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.Window <= 0 {
+		config.Window = defaultJoinConfig().Window
+	}
+	if config.Clock == nil {
+		config.Clock = g.Clock()
+	}
+	clock := config.Clock
+	j := newJoiner(name, config, joinMonitoring(g, name), publish)
+	Log.Info("join started", zap.String("join", name))
+	defer Log.Info("join stopped", zap.String("join", name))
+
+	ticker := clock.NewTicker(config.Window / 10)
+	defer ticker.Stop()
+	for left != nil || right != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-left:
+			if !ok {
+				left = nil
+				continue
+			}
+			j.add(JoinLeft, e, clock.Now())
+		case e, ok := <-right:
+			if !ok {
+				right = nil
+				continue
+			}
+			j.add(JoinRight, e, clock.Now())
+		case now := <-ticker.C():
+			j.expire(now)
+		}
+	}
+	// no more events can match the pending ones
+	j.expire(clock.Now().Add(config.Window))
+	return nil
+}
+
+func newJoiner(name string, config *JoinConfig, metrics *joinMetrics, publish JoinPublisher) *joiner {
+	return &joiner{
+		name:    name,
+		config:  config,
+		metrics: metrics,
+		publish: publish,
+		pending: [2]map[string]*pendingEvent{make(map[string]*pendingEvent), make(map[string]*pendingEvent)},
+	}
+}
+
+func (j *joiner) key(side JoinSide, e *stream.Event) []byte {
+	keyFn := j.config.LeftKey
+	if side == JoinRight {
+		keyFn = j.config.RightKey
+	}
+	if keyFn == nil {
+		return e.Key
+	}
+	return keyFn(e)
+}
+
+func (j *joiner) add(side JoinSide, e *stream.Event, now time.Time) {
+	key := j.key(side, e)
+	k := string(key)
+	pending := j.pending[side]
+	p := &pendingEvent{evt: e, arrivedAt: now}
+	if other, ok := j.pending[1-side][k]; ok && now.Sub(other.arrivedAt) < j.config.Window {
+		p.joined = true
+		other.joined = true
+		joined := JoinedEvent{Key: key, Left: other.evt, Right: e}
+		if side == JoinLeft {
+			joined.Left, joined.Right = e, other.evt
+		}
+		j.emit(joined)
+	}
+	if previous, ok := pending[k]; ok {
+		// replaced by the last event of the key
+		j.evict(side, previous)
+	} else {
+		j.metrics.pendingGauge.Inc()
+	}
+	pending[k] = p
+
+	if j.config.MaxPending > 0 && len(pending) > j.config.MaxPending {
+		var oldestKey string
+		var oldest *pendingEvent
+		for k, p := range pending {
+			if oldest == nil || p.arrivedAt.Before(oldest.arrivedAt) {
+				oldestKey, oldest = k, p
+			}
+		}
+		delete(pending, oldestKey)
+		j.metrics.pendingGauge.Dec()
+		j.evict(side, oldest)
+	}
+}
+
+// expire evicts the events which arrived more than a window before now
+func (j *joiner) expire(now time.Time) {
+	for side, pending := range j.pending {
+		for k, p := range pending {
+			if now.Sub(p.arrivedAt) >= j.config.Window {
+				delete(pending, k)
+				j.metrics.pendingGauge.Dec()
+				j.evict(JoinSide(side), p)
+			}
+		}
+	}
+}
+
+// evict reports the event if it was never joined, then acknowledges it
+func (j *joiner) evict(side JoinSide, p *pendingEvent) {
+	if !p.joined {
+		j.metrics.unmatchedCounter.Inc()
+		if j.config.OnUnmatched != nil {
+			j.config.OnUnmatched(side, p.evt)
+		} else {
+			Log.Debug("event expired without match", zap.String("join", j.name), zap.Stringer("side", side), RedactedKey(p.evt.Key))
+		}
+		if j.config.EmitUnmatched {
+			joined := JoinedEvent{Key: j.key(side, p.evt)}
+			if side == JoinLeft {
+				joined.Left = p.evt
+			} else {
+				joined.Right = p.evt
+			}
+			j.emit(joined)
+		}
+	}
+	if err := p.evt.Ack(); err != nil {
+		Log.Warn("could not ack joined event", zap.String("join", j.name), zap.Error(err))
+	}
+}
+
+func (j *joiner) emit(joined JoinedEvent) {
+	if err := j.publish(joined); err != nil {
+		Log.Warn("could not publish joined event", zap.String("join", j.name), zap.Error(err))
+		j.metrics.errorCounter.Inc()
+		return
+	}
+	j.metrics.joinedCounter.Inc()
+}
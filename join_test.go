@@ -0,0 +1,112 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func joinEvent(key, value string) *stream.Event {
+	return &stream.Event{Ctx: context.Background(), Key: []byte(key), Value: []byte(value)}
+}
+
+func TestJoiner(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	var joined []JoinedEvent
+	var unmatched []*stream.Event
+	config := defaultJoinConfig()
+	JoinOnUnmatched(func(side JoinSide, e *stream.Event) {
+		unmatched = append(unmatched, e)
+	})(config)
+	j := newJoiner("orders", config, joinMonitoring(g, "orders"), func(e JoinedEvent) error {
+		joined = append(joined, e)
+		return nil
+	})
+	start := time.Now()
+
+	// the order is joined with each of its updates
+	j.add(JoinLeft, joinEvent("1", "order"), start)
+	j.add(JoinRight, joinEvent("1", "update1"), start.Add(time.Second))
+	j.add(JoinRight, joinEvent("1", "update2"), start.Add(2*time.Second))
+	// an update arrived before its order is joined once the order arrives
+	j.add(JoinRight, joinEvent("2", "update"), start)
+	j.add(JoinLeft, joinEvent("2", "order"), start.Add(time.Second))
+	if assert.Len(t, joined, 3) {
+		assert.Equal(t, "order", string(joined[0].Left.Value))
+		assert.Equal(t, "update1", string(joined[0].Right.Value))
+		assert.Equal(t, "update2", string(joined[1].Right.Value))
+		assert.Equal(t, "2", string(joined[2].Key))
+		assert.Equal(t, "order", string(joined[2].Left.Value))
+		assert.Equal(t, "update", string(joined[2].Right.Value))
+	}
+
+	// the update arrives after the window of its order
+	j.add(JoinLeft, joinEvent("3", "order"), start)
+	j.add(JoinRight, joinEvent("3", "late update"), start.Add(2*time.Minute))
+	assert.Len(t, joined, 3)
+	j.expire(start.Add(3 * time.Minute))
+	if assert.Len(t, unmatched, 2) {
+		assert.ElementsMatch(t, []string{"order", "late update"}, []string{string(unmatched[0].Value), string(unmatched[1].Value)})
+	}
+	assertCounterEquals(t, g, prometheus.Labels{JoinLabel: "orders"}, JoinJoinedEvents, 3)
+	assertCounterEquals(t, g, prometheus.Labels{JoinLabel: "orders"}, JoinUnmatchedEvents, 2)
+	assertGaugeValue(t, g, JoinPendingEvents, 0)
+}
+
+func TestJoinEmitUnmatched(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	left := make(chan *stream.Event, 2)
+	right := make(chan *stream.Event, 2)
+	acked := 0
+	order := joinEvent("order-1", "order")
+	order.AckFunc = func() error {
+		acked++
+		return nil
+	}
+	left <- order
+	right <- &stream.Event{Ctx: context.Background(), Key: []byte("update-1"), Value: []byte("order-2")}
+	close(left)
+	close(right)
+
+	var joined []JoinedEvent
+	err := g.Join(context.Background(), "outer", left, right, func(e JoinedEvent) error {
+		joined = append(joined, e)
+		return nil
+	}, JoinEmitUnmatched(), JoinKeys(nil, func(e *stream.Event) []byte {
+		return e.Value
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, acked)
+	if assert.Len(t, joined, 2) {
+		for _, e := range joined {
+			if e.Left != nil {
+				assert.Equal(t, "order-1", string(e.Key))
+				assert.Nil(t, e.Right)
+			} else {
+				assert.Equal(t, "order-2", string(e.Key))
+				assert.Equal(t, "update-1", string(e.Right.Key))
+			}
+		}
+	}
+}
+
+func TestJoinMaxPending(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	config := defaultJoinConfig()
+	JoinMaxPending(2)(config)
+	j := newJoiner("bounded", config, joinMonitoring(g, "bounded"), func(e JoinedEvent) error {
+		return nil
+	})
+	start := time.Now()
+	j.add(JoinLeft, joinEvent("1", "order"), start)
+	j.add(JoinLeft, joinEvent("2", "order"), start.Add(time.Second))
+	j.add(JoinLeft, joinEvent("3", "order"), start.Add(2*time.Second))
+
+	assert.Len(t, j.pending[JoinLeft], 2)
+	assert.NotContains(t, j.pending[JoinLeft], "1")
+	assertCounterEquals(t, g, prometheus.Labels{JoinLabel: "bounded"}, JoinUnmatchedEvents, 1)
+}
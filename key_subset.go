@@ -0,0 +1,156 @@
+package gorillaz
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// WatchKeys restricts a consumer to the given keys, the provider sends neither the state nor the updates of the other keys,
+// nor the events of the other keys on a Stream. It can be combined with WatchKeyPrefixes, the keys matching either of them are watched.
+func WatchKeys(keys ...[]byte) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.WatchKeys = append(c.WatchKeys, keys...)
+	}
+}
+
+// WatchKeyPrefixes restricts a consumer to the keys starting with one of the given prefixes,
+// the provider does not send the events of the other keys.
+func WatchKeyPrefixes(prefixes ...[]byte) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.WatchKeyPrefixes = append(c.WatchKeyPrefixes, prefixes...)
+	}
+}
+
+// WithKeyPrefix restricts a consumer to the keys starting with one of the given prefixes, such as ConsumeStream(endpoints, "prices", WithKeyPrefix("EUR")),
+// see WatchKeyPrefixes
+func WithKeyPrefix(prefixes ...string) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		for _, p := range prefixes {
+			c.WatchKeyPrefixes = append(c.WatchKeyPrefixes, []byte(p))
+		}
+	}
+}
+
+// WithKeyExpression restricts a consumer to the keys matching the regular expression, in the RE2 syntax of the regexp package,
+// such as ConsumeStream(endpoints, "prices", WithKeyExpression("^(EUR|USD)/")). It can be combined with WatchKeys and
+// WatchKeyPrefixes, the keys matching either of them are watched. The provider rejects the stream if the expression is invalid.
+func WithKeyExpression(expr string) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.KeyExpression = expr
+	}
+}
+
+// keySubsetRequest is a stream request restricting the keys watched by the consumer, see stream.proto.
+// The providers not supporting it ignore it and send all the keys.
+type keySubsetRequest interface {
+	GetWatchKeys() [][]byte
+	GetWatchKeyPrefixes() [][]byte
+}
+
+// keyExpressionRequest is a stream request restricting the keys watched by the consumer with an expression
+type keyExpressionRequest interface {
+	GetKeyExpression() string
+}
+
+// keySubset is the subset of the keys of a state watched by a subscriber, a nil subset holds all the keys
+type keySubset struct {
+	stateKeys map[string]struct{} // the watched keys, as keys of the state broadcaster
+	prefixes  [][]byte
+	expr      *regexp.Regexp // the keys matching expr are watched, if not nil
+}
+
+// requestedKeySubset returns the keys watched by the consumer in its stream request, an error if its expression is invalid
+func requestedKeySubset(np StreamRequest) (*keySubset, error) {
+	var keys, prefixes [][]byte
+	if req, ok := np.(keySubsetRequest); ok {
+		keys, prefixes = req.GetWatchKeys(), req.GetWatchKeyPrefixes()
+	}
+	var expr *regexp.Regexp
+	if req, ok := np.(keyExpressionRequest); ok && req.GetKeyExpression() != "" {
+		var err error
+		if expr, err = regexp.Compile(req.GetKeyExpression()); err != nil {
+			return nil, fmt.Errorf("invalid key expression: %w", err)
+		}
+	}
+	if len(keys) == 0 && len(prefixes) == 0 && expr == nil {
+		return nil, nil
+	}
+	s := &keySubset{stateKeys: make(map[string]struct{}, len(keys)), prefixes: prefixes, expr: expr}
+	for _, k := range keys {
+		s.stateKeys[stateKey(k)] = struct{}{}
+	}
+	return s, nil
+}
+
+// containsStateKey returns true if the key of the state broadcaster is watched
+func (s *keySubset) containsStateKey(key interface{}) bool {
+	sk, ok := key.(string)
+	if !ok {
+		return false
+	}
+	if _, ok := s.stateKeys[sk]; ok {
+		return true
+	}
+	if len(s.prefixes) == 0 && s.expr == nil {
+		return false
+	}
+	k, err := base64.StdEncoding.DecodeString(sk)
+	if err != nil {
+		return false
+	}
+	return s.matches(k)
+}
+
+// containsEvent returns true if the key of the event, marshalled by a stream provider, is watched.
+// Only the key is decoded, the event is marshalled once for all the subscribers.
+func (s *keySubset) containsEvent(b []byte) bool {
+	key, ok := eventKey(b)
+	if !ok {
+		return false
+	}
+	if _, ok := s.stateKeys[stateKey(key)]; ok {
+		return true
+	}
+	return s.matches(key)
+}
+
+// matches returns true if the key has one of the prefixes or matches the expression
+func (s *keySubset) matches(k []byte) bool {
+	for _, p := range s.prefixes {
+		if bytes.HasPrefix(k, p) {
+			return true
+		}
+	}
+	return s.expr != nil && s.expr.Match(k)
+}
+
+// streamEventKeyField is the field number of the key of a stream.StreamEvent
+var streamEventKeyField = (&stream.StreamEvent{}).ProtoReflect().Descriptor().Fields().ByName("Key").Number()
+
+// eventKey returns the key of a marshalled stream.StreamEvent, skipping its other fields without decoding them.
+// ok is false if the event is malformed.
+func eventKey(b []byte) (key []byte, ok bool) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, false
+		}
+		b = b[n:]
+		if num == streamEventKeyField && typ == protowire.BytesType {
+			// as when unmarshalling, the last occurrence of the field wins
+			key, n = protowire.ConsumeBytes(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, false
+		}
+		b = b[n:]
+	}
+	return key, true
+}
@@ -0,0 +1,155 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestKeySubsetRequest(t *testing.T) {
+	s, err := requestedKeySubset(&stream.GetAndWatchRequest{})
+	assert.NoError(t, err)
+	assert.Nil(t, s)
+
+	c := &ConsumerConfig{}
+	WatchKeys([]byte("flight/AF123"), []byte{0, 255})(c)
+	WatchKeyPrefixes([]byte("airport/"))(c)
+	s, err = requestedKeySubset(&stream.GetAndWatchRequest{WatchKeys: c.WatchKeys, WatchKeyPrefixes: c.WatchKeyPrefixes})
+	assert.NoError(t, err)
+
+	assert.True(t, s.containsStateKey(stateKey([]byte("flight/AF123"))))
+	assert.True(t, s.containsStateKey(stateKey([]byte{0, 255})))
+	assert.True(t, s.containsStateKey(stateKey([]byte("airport/LFPG"))))
+	assert.False(t, s.containsStateKey(stateKey([]byte("flight/AF124"))))
+	assert.False(t, s.containsStateKey(stateKey([]byte("airport"))))
+	assert.False(t, s.containsStateKey(42))
+}
+
+func TestKeySubsetWithoutPrefixes(t *testing.T) {
+	c := &ConsumerConfig{}
+	WatchKeys([]byte("k1"))(c)
+	s, err := requestedKeySubset(&stream.StreamRequest{WatchKeys: c.WatchKeys})
+	assert.NoError(t, err)
+
+	assert.True(t, s.containsStateKey(stateKey([]byte("k1"))))
+	assert.False(t, s.containsStateKey(stateKey([]byte("k10"))))
+}
+
+func TestStreamKeyPrefixFilter(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("prices", "dummy.type", LazyBroadcast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Submit(&stream.Event{Key: []byte("USD/JPY"), Value: []byte("1")})
+	provider.Submit(&stream.Event{Key: []byte("EUR/USD"), Value: []byte("2")})
+	provider.Submit(&stream.Event{Key: []byte("GBP/EUR"), Value: []byte("3")})
+	provider.Submit(&stream.Event{Key: []byte("EUR/JPY"), Value: []byte("4")})
+
+	consumer, err := g.DiscoverAndConsumeServiceStream("test", "prices", WithKeyPrefix("EUR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceived(t, "prices", consumer.EvtChan(), &stream.Event{Key: []byte("EUR/USD"), Value: []byte("2")})
+	assertReceived(t, "prices", consumer.EvtChan(), &stream.Event{Key: []byte("EUR/JPY"), Value: []byte("4")})
+}
+
+func TestKeySubsetExpression(t *testing.T) {
+	c := &ConsumerConfig{}
+	WatchKeys([]byte("GBP/EUR"))(c)
+	WithKeyExpression("^(EUR|USD)/")(c)
+	s, err := requestedKeySubset(&stream.GetAndWatchRequest{WatchKeys: c.WatchKeys, KeyExpression: c.KeyExpression})
+	assert.NoError(t, err)
+
+	assert.True(t, s.containsStateKey(stateKey([]byte("EUR/USD"))))
+	assert.True(t, s.containsStateKey(stateKey([]byte("GBP/EUR"))))
+	assert.False(t, s.containsStateKey(stateKey([]byte("JPY/USD"))))
+
+	_, err = requestedKeySubset(&stream.StreamRequest{KeyExpression: "(EUR"})
+	assert.Error(t, err)
+}
+
+func TestEventKey(t *testing.T) {
+	marshal := func(evt *stream.StreamEvent) []byte {
+		b, err := proto.Marshal(evt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	key, ok := eventKey(marshal(&stream.StreamEvent{Key: []byte("k1"), Value: []byte("v1"), Metadata: &stream.Metadata{EventTimestamp: 42, KeyValue: map[string]string{"a": "b"}}}))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("k1"), key)
+
+	// the fields are decoded in any order, such as the metadata before the key
+	b := append(marshal(&stream.StreamEvent{Value: []byte("v1"), Metadata: &stream.Metadata{EventTimestamp: 42}}), marshal(&stream.StreamEvent{Key: []byte("k2")})...)
+	key, ok = eventKey(b)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("k2"), key)
+
+	key, ok = eventKey(marshal(&stream.StreamEvent{Value: []byte("v1")}))
+	assert.True(t, ok)
+	assert.Empty(t, key)
+
+	_, ok = eventKey([]byte{0x0a, 0x05, 'k'})
+	assert.False(t, ok, "truncated event")
+
+	s := &keySubset{stateKeys: map[string]struct{}{stateKey([]byte("k1")): {}}}
+	assert.True(t, s.containsEvent(marshal(&stream.StreamEvent{Key: []byte("k1"), Value: []byte("v1")})))
+	assert.False(t, s.containsEvent(marshal(&stream.StreamEvent{Key: []byte("k2"), Value: []byte("v1")})))
+}
+
+func TestStreamKeyExpressionFilter(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("prices", "dummy.type", LazyBroadcast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Submit(&stream.Event{Key: []byte("USD/JPY"), Value: []byte("1")})
+	provider.Submit(&stream.Event{Key: []byte("EUR/USD"), Value: []byte("2")})
+	provider.Submit(&stream.Event{Key: []byte("GBP/EUR"), Value: []byte("3")})
+	provider.Submit(&stream.Event{Key: []byte("EUR/JPY"), Value: []byte("4")})
+
+	consumer, err := g.DiscoverAndConsumeServiceStream("test", "prices", WithKeyExpression("/(USD|EUR)$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertReceived(t, "prices", consumer.EvtChan(), &stream.Event{Key: []byte("EUR/USD"), Value: []byte("2")})
+	assertReceived(t, "prices", consumer.EvtChan(), &stream.Event{Key: []byte("GBP/EUR"), Value: []byte("3")})
+}
+
+func TestStreamInvalidKeyExpression(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	if _, err := g.NewStreamProvider("prices", "dummy.type"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(g.grpcListener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := stream.NewStreamClient(conn).Stream(ctx, &stream.StreamRequest{Name: "prices", KeyExpression: "(EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = st.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
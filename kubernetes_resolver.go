@@ -0,0 +1,152 @@
+package gorillaz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultKubernetesAPIServer = "https://kubernetes.default.svc"
+	defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultKubernetesCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// KubernetesResolver is an EndpointResolver watching the Endpoints of the services of the Kubernetes cluster it runs in.
+// The names are "namespace/service:port", the port being the name or the number of a port of the service, the first one if omitted.
+// Only the ready addresses are resolved.
+type KubernetesResolver struct {
+	APIServer string       // APIServer is the URL of the Kubernetes API server (default: https://kubernetes.default.svc)
+	TokenFile string       // TokenFile is the file of the token of the service account, read on each watch as it is rotated (default: the one mounted in the pod)
+	Client    *http.Client // Client queries the API server (default: a client trusting the CA mounted in the pod)
+}
+
+type kubernetesEndpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+type kubernetesWatchEvent struct {
+	Type   string              `json:"type"`
+	Object kubernetesEndpoints `json:"object"`
+}
+
+// parseKubernetesName returns the namespace, the service and the port of "namespace/service:port"
+func parseKubernetesName(name string) (namespace, service, port string, err error) {
+	i := strings.Index(name, "/")
+	if i <= 0 {
+		return "", "", "", fmt.Errorf("invalid kubernetes endpoint %s, expected namespace/service:port", name)
+	}
+	namespace, service = name[:i], name[i+1:]
+	if j := strings.LastIndex(service, ":"); j >= 0 {
+		service, port = service[:j], service[j+1:]
+	}
+	return namespace, service, port, nil
+}
+
+// addresses returns the ready addresses of the endpoints on the port
+func (e kubernetesEndpoints) addresses(port string) []string {
+	var addrs []string
+	for _, s := range e.Subsets {
+		p := -1
+		for i, sp := range s.Ports {
+			if (port == "" && i == 0) || sp.Name == port || strconv.Itoa(sp.Port) == port {
+				p = sp.Port
+				break
+			}
+		}
+		if p < 0 {
+			continue
+		}
+		for _, a := range s.Addresses {
+			addrs = append(addrs, net.JoinHostPort(a.IP, strconv.Itoa(p)))
+		}
+	}
+	return addrs
+}
+
+func (r *KubernetesResolver) client() (*http.Client, error) {
+	if r.Client != nil {
+		return r.Client, nil
+	}
+	ca, err := ioutil.ReadFile(defaultKubernetesCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid kubernetes CA %s", defaultKubernetesCAFile)
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}, nil
+}
+
+// Watch watches the Endpoints of the service until the API server ends the watch, it is then watched again
+func (r *KubernetesResolver) Watch(ctx context.Context, name string, update func(addrs []string)) error {
+	namespace, service, port, err := parseKubernetesName(name)
+	if err != nil {
+		return err
+	}
+	apiServer, tokenFile := r.APIServer, r.TokenFile
+	if apiServer == "" {
+		apiServer = defaultKubernetesAPIServer
+	}
+	if tokenFile == "" {
+		tokenFile = defaultKubernetesTokenFile
+	}
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	q.Set("watch", "true")
+	q.Set("fieldSelector", "metadata.name="+service)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiServer+"/api/v1/namespaces/"+url.PathEscape(namespace)+"/endpoints?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if token, err := ioutil.ReadFile(tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kubernetes returned status %d for endpoints %s", resp.StatusCode, name)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var evt kubernetesWatchEvent
+		if err := dec.Decode(&evt); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch evt.Type {
+		case "ADDED", "MODIFIED":
+			update(evt.Object.addresses(port))
+		case "DELETED":
+			update(nil)
+		case "ERROR":
+			return fmt.Errorf("kubernetes watch error for endpoints %s", name)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package gorillaz
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// lastEventAgeInterval is the period of the updates of the stream_consumer_last_event_age_seconds gauge
+const lastEventAgeInterval = time.Second
+
+// lastEventTime returns when the last event was received, the zero time if none was received
+func (m *consumerMetrics) lastEventTime() time.Time {
+	if t := atomic.LoadInt64(&m.lastEvent); t > 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// updateLastEventAge sets the age of the last event, or the time since the stream is consumed if none was received,
+// so that a stream connected but silent can be detected
+func (m *consumerMetrics) updateLastEventAge(now time.Time) {
+	last := m.lastEventTime()
+	if last.IsZero() {
+		last = m.created
+	}
+	m.lastEventAge.Set(now.Sub(last).Seconds())
+}
+
+// watchLastEventAge updates the age of the last event of the stream periodically, until stop is called
+func watchLastEventAge(g *Gaz, streamName string, m *consumerMetrics) (stop func()) {
+	done := make(chan struct{})
+	ticker := g.Clock().NewTicker(lastEventAgeInterval)
+	g.goTracked("last_event_age", streamName, func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C():
+				m.updateLastEventAge(now)
+			case <-done:
+				return
+			}
+		}
+	})
+	return func() {
+		close(done)
+	}
+}
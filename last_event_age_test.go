@@ -0,0 +1,37 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastEventAge(t *testing.T) {
+	clock := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry(), clock: clock}
+	m := consumerMonitoring(g, "silent", []string{"localhost:1"})
+	defer releaseConsumerMonitoring(g, "silent", false)
+	labels := map[string]string{StreamNameLabel: "silent"}
+	ageEquals := func(expected float64) func() bool {
+		return func() bool {
+			metric, err := findMetric(g, StreamConsumerLastEventAge, labels)
+			return err == nil && metric.GetGauge().GetValue() == expected
+		}
+	}
+
+	assert.True(t, m.lastEventTime().IsZero())
+	// no event received yet, the age is the time since the stream is consumed
+	clock.Advance(lastEventAgeInterval)
+	waitUntil(t, time.Second, "age updated", ageEquals(1))
+
+	c := &consumer{cMetrics: m, endpoint: &streamEndpoint{g: g}}
+	monitorDelays(c, &stream.StreamEvent{Metadata: &stream.Metadata{}})
+	assert.Equal(t, clock.Now(), c.LastEventTime().UTC())
+	for i := 1; i <= 3; i++ {
+		clock.Advance(lastEventAgeInterval)
+		waitUntil(t, time.Second, "age updated", ageEquals(float64(i)))
+	}
+}
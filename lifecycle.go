@@ -0,0 +1,101 @@
+package gorillaz
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// GoroutineInfo describes a goroutine started by gorillaz
+type GoroutineInfo struct {
+	Name      string    `json:"name"`            // Name is what the goroutine does, such as "stream_consumer"
+	Owner     string    `json:"owner,omitempty"` // Owner is the stream, the target or the job the goroutine works for
+	StartedAt time.Time `json:"started_at"`
+}
+
+// goroutineTracker lists the live goroutines of gorillaz, so that the leaks can be found
+type goroutineTracker struct {
+	mu   sync.Mutex
+	next uint64
+	live map[uint64]GoroutineInfo
+}
+
+// goTracked runs f in a goroutine listed by LiveGoroutines until f returns
+func (g *Gaz) goTracked(name, owner string, f func()) {
+	done := g.trackGoroutine(name, owner)
+	go func() {
+		defer done()
+		f()
+	}()
+}
+
+// trackGoroutine lists a goroutine by LiveGoroutines and counts it in the goroutine_running gauge, until done is called
+func (g *Gaz) trackGoroutine(name, owner string) (done func()) {
+	t := &g.goroutineTracker
+	t.mu.Lock()
+	if t.live == nil {
+		t.live = make(map[uint64]GoroutineInfo)
+	}
+	t.next++
+	id := t.next
+	t.live[id] = GoroutineInfo{Name: name, Owner: owner, StartedAt: time.Now()}
+	t.mu.Unlock()
+
+	var gauge prometheus.Gauge
+	if g.prometheusRegistry != nil {
+		gauge = goroutineMonitoring(g, name).runningGauge
+		gauge.Inc()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if gauge != nil {
+				gauge.Dec()
+			}
+			t.mu.Lock()
+			delete(t.live, id)
+			t.mu.Unlock()
+		})
+	}
+}
+
+// LiveGoroutines lists the goroutines started by gorillaz which are still running, sorted by name and owner.
+// The goroutines of a stopped consumer, provider, bridge or job must disappear from the list once they have stopped.
+func (g *Gaz) LiveGoroutines() []GoroutineInfo {
+	t := &g.goroutineTracker
+	t.mu.Lock()
+	live := make([]GoroutineInfo, 0, len(t.live))
+	for _, info := range t.live {
+		live = append(live, info)
+	}
+	t.mu.Unlock()
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].Name != live[j].Name {
+			return live[i].Name < live[j].Name
+		}
+		if live[i].Owner != live[j].Owner {
+			return live[i].Owner < live[j].Owner
+		}
+		return live[i].StartedAt.Before(live[j].StartedAt)
+	})
+	return live
+}
+
+func liveGoroutinesHandler(g *Gaz) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.MarshalIndent(g.LiveGoroutines(), "", " ")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if _, err := w.Write(b); err != nil {
+			Log.Error("failed to write response", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package gorillaz
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveGoroutines(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	assert.Empty(t, g.LiveGoroutines())
+
+	release := make(chan struct{})
+	stopped := make(chan struct{})
+	started := make(chan struct{})
+	g.goTracked("test-lifecycle", "owner", func() {
+		defer close(stopped)
+		close(started)
+		<-release
+	})
+	<-started
+	live := g.LiveGoroutines()
+	if assert.Len(t, live, 1) {
+		assert.Equal(t, "test-lifecycle", live[0].Name)
+		assert.Equal(t, "owner", live[0].Owner)
+	}
+	assertGaugeValue(t, g, GoroutineRunning, 1)
+
+	rec := httptest.NewRecorder()
+	liveGoroutinesHandler(g)(rec, httptest.NewRequest("GET", "/debug/goroutines", nil))
+	assert.True(t, strings.Contains(rec.Body.String(), `"name": "test-lifecycle"`), rec.Body.String())
+
+	close(release)
+	<-stopped
+	waitUntil(t, time.Second, "the stopped goroutine must not be listed", func() bool {
+		return len(g.LiveGoroutines()) == 0
+	})
+	assertGaugeValue(t, g, GoroutineRunning, 0)
+}
+
+func TestTrackGoroutineDoneTwice(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	done := g.trackGoroutine("test-lifecycle-twice", "")
+	done()
+	done()
+	assert.Empty(t, g.LiveGoroutines())
+	assertGaugeValue(t, g, GoroutineRunning, 0)
+}
+
+func TestStoppedConsumerGoroutines(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	if _, err := g.NewStreamProvider("idle", "dummy.type"); err != nil {
+		t.Fatal(err)
+	}
+	consumerGoroutines := func() int {
+		n := 0
+		for _, info := range g.LiveGoroutines() {
+			if strings.HasPrefix(info.Name, "stream_consumer") {
+				n++
+			}
+		}
+		return n
+	}
+	consumer, err := g.DiscoverAndConsumeServiceStream("test", "idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["idle"]) == 1
+	})
+	assert.True(t, consumerGoroutines() > 0)
+
+	// no event is submitted, the stream is cancelled by Stop
+	consumer.Stop()
+	waitUntil(t, 5*time.Second, "the goroutines of the stopped consumer must stop", func() bool {
+		return consumerGoroutines() == 0
+	})
+	select {
+	case _, ok := <-consumer.EvtChan():
+		assert.False(t, ok, "the event channel is closed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event channel is not closed")
+	}
+}
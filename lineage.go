@@ -0,0 +1,43 @@
+package gorillaz
+
+import (
+	"sync/atomic"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+// StampLineage starts the lineage of the events submitted without lineage, with the stream of the provider as source
+// and their rank in the stream as source sequence, see stream.Lineage.
+// The lineage of the events which already have one is stamped by all the providers, bridges and relays.
+func StampLineage() ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.StampLineage = true
+	}
+}
+
+// GetAndWatchStampLineage starts the lineage of the events submitted without lineage, see StampLineage
+func GetAndWatchStampLineage() GetAndWatchConfigOpt {
+	return func(p *GetAndWatchConfig) {
+		p.StampLineage = true
+	}
+}
+
+// lineageStamper stamps the lineage of the events submitted to a provider
+type lineageStamper struct {
+	seq        int64 // first field, 64-bit aligned for atomic operations
+	streamName string
+	service    string
+	start      bool
+}
+
+func newLineageStamper(g *Gaz, streamName string, start bool) *lineageStamper {
+	return &lineageStamper{streamName: streamName, service: g.ServiceName, start: start}
+}
+
+func (s *lineageStamper) stamp(evt *stream.Event) *stream.Event {
+	var seq int64
+	if s.start {
+		seq = atomic.AddInt64(&s.seq, 1)
+	}
+	return evt.StampLineage(s.streamName, seq, s.service, s.start)
+}
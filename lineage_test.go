@@ -0,0 +1,48 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineageStamper(t *testing.T) {
+	g := &Gaz{ServiceName: "gateway"}
+	s := newLineageStamper(g, "orders", true)
+	s.stamp(&stream.Event{})
+	l, ok := s.stamp(&stream.Event{}).Lineage()
+	assert.True(t, ok)
+	assert.Equal(t, stream.Lineage{SourceStream: "orders", SourceSeq: 2, Service: "gateway"}, l)
+
+	_, ok = newLineageStamper(g, "orders", false).stamp(&stream.Event{}).Lineage()
+	assert.False(t, ok)
+}
+
+func TestRelayLineage(t *testing.T) {
+	g := &Gaz{ServiceName: "relay-service", prometheusRegistry: prometheus.NewRegistry()}
+	source := make(chan *stream.Event, 2)
+
+	fromJetstream := &stream.Event{Ctx: context.Background(), Key: []byte("1")}
+	fromJetstream.SetStream("dev-orders")
+	fromJetstream.SetStreamSeq(42)
+	source <- fromJetstream
+	source <- &stream.Event{Ctx: context.Background(), Key: []byte("2")}
+	close(source)
+
+	var relayed []*stream.Event
+	err := g.Relay(context.Background(), "lineage-relay", source, func(e *stream.Event) error {
+		relayed = append(relayed, e)
+		return nil
+	})
+	assert.Nil(t, err)
+	if assert.Len(t, relayed, 2) {
+		l, ok := relayed[0].Lineage()
+		assert.True(t, ok)
+		assert.Equal(t, stream.Lineage{SourceStream: "dev-orders", SourceSeq: 42, Service: "relay-service"}, l)
+		_, ok = relayed[1].Lineage()
+		assert.False(t, ok, "no lineage without source stream")
+	}
+}
@@ -0,0 +1,234 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultLockBucket = "gorillaz-locks"
+const defaultLockTtl = 10 * time.Second
+
+// ErrLockNotHeld is returned when unlocking a lock that has been lost or already released
+var ErrLockNotHeld = errors.New("lock not held")
+
+type LockConfig struct {
+	Bucket string        // Bucket is the Jetstream key value bucket holding the leases (default: gorillaz-locks)
+	Ttl    time.Duration // Ttl is the duration of the lease, it is renewed every third of it. All the locks of a bucket share the same Ttl (default: 10s)
+}
+
+type LockOpt func(c *LockConfig)
+
+// LockBucket stores the lease in the given key value bucket
+func LockBucket(bucket string) LockOpt {
+	return func(c *LockConfig) {
+		c.Bucket = bucket
+	}
+}
+
+// LockTtl configures the duration of the lease, after which a lock held by a dead instance is released
+func LockTtl(ttl time.Duration) LockOpt {
+	return func(c *LockConfig) {
+		c.Ttl = ttl
+	}
+}
+
+// Lock is a distributed lock held by a lease in a Jetstream key value bucket.
+// The lease is renewed in the background until Unlock is called or the lease is lost.
+type Lock struct {
+	g        *Gaz
+	name     string
+	config   *LockConfig
+	mu       sync.Mutex
+	revision uint64
+	lost     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Lock blocks until the distributed lock with the given name is acquired, or until ctx is done.
+// The lock must be released with Unlock, Lost is closed if the lease could not be renewed in time.
+func (g *Gaz) Lock(ctx context.Context, name string, opts ...LockOpt) (*Lock, error) {
+	config := &LockConfig{
+		Bucket: defaultLockBucket,
+		Ttl:    defaultLockTtl,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if err := g.ProvisionKeyValue(ctx, config.Bucket, JetstreamMaxAge(config.Ttl)); err != nil {
+		return nil, err
+	}
+	owner := []byte(g.ServiceName + "@" + g.serviceAddress)
+	for {
+		rev, err := g.acquireLease(ctx, config.Bucket, name, owner)
+		if err == nil {
+			l := &Lock{
+				g:        g,
+				name:     name,
+				config:   config,
+				revision: rev,
+				lost:     make(chan struct{}),
+				stop:     make(chan struct{}),
+				done:     make(chan struct{}),
+			}
+			Log.Debug("lock acquired", zap.String("lock", name))
+			g.goTracked("lock_renewal", name, func() {
+				l.renew(owner)
+			})
+			return l, nil
+		}
+		if err != ErrKeyExists {
+			Log.Warn("could not acquire lock", zap.String("lock", name), zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-g.Clock().After(config.Ttl / 3):
+		}
+	}
+}
+
+// acquireLease creates the lease, or takes it over if it was released by its previous owner
+func (g *Gaz) acquireLease(ctx context.Context, bucket, name string, owner []byte) (uint64, error) {
+	rev, err := g.kvCreate(ctx, bucket, name, owner)
+	if err != ErrKeyExists {
+		return rev, err
+	}
+	entry, err := g.kvGet(ctx, bucket, name)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil || entry.Operation == "" {
+		return 0, ErrKeyExists
+	}
+	rev, err = g.kvUpdate(ctx, bucket, name, owner, entry.Revision)
+	if err == errWrongRevision {
+		return 0, ErrKeyExists
+	}
+	return rev, err
+}
+
+func (l *Lock) renew(owner []byte) {
+	defer close(l.done)
+	clock := l.g.Clock()
+	ticker := clock.NewTicker(l.config.Ttl / 3)
+	defer ticker.Stop()
+	renewedAt := clock.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C():
+		}
+		l.mu.Lock()
+		ctx, cancel := context.WithTimeout(context.Background(), l.config.Ttl/3)
+		rev, err := l.g.kvUpdate(ctx, l.config.Bucket, l.name, owner, l.revision)
+		cancel()
+		if err == nil {
+			l.revision = rev
+			renewedAt = clock.Now()
+		}
+		l.mu.Unlock()
+		if err == errWrongRevision || err != nil && clock.Now().Sub(renewedAt) >= l.config.Ttl {
+			Log.Warn("lock lost", zap.String("lock", l.name), zap.Error(err))
+			close(l.lost)
+			return
+		}
+		if err != nil {
+			Log.Warn("could not renew lock", zap.String("lock", l.name), zap.Error(err))
+		}
+	}
+}
+
+// Lost is closed when the lease of the lock could not be renewed, the lock may then be held by another instance
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock stops renewing the lease and releases it.
+// ErrLockNotHeld is returned if the lock was lost or already released.
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	select {
+	case <-l.stop:
+		l.mu.Unlock()
+		return ErrLockNotHeld
+	default:
+		close(l.stop)
+	}
+	l.mu.Unlock()
+	<-l.done
+	select {
+	case <-l.lost:
+		return ErrLockNotHeld
+	default:
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), jsApiTimeout)
+	defer cancel()
+	err := l.g.kvDelete(ctx, l.config.Bucket, l.name, l.revision)
+	if err == errWrongRevision {
+		return ErrLockNotHeld
+	}
+	return err
+}
+
+// LeaderCallbacks are called by LeaderElection when the instance becomes or stops being the leader
+type LeaderCallbacks struct {
+	OnElected func(ctx context.Context) // OnElected is called in a new goroutine, ctx is cancelled when the leadership is lost
+	OnRevoked func()                    // OnRevoked is called when the leadership is lost or the election is stopped while leader
+}
+
+// LeaderElection campaigns for the leadership of the given name among the instances, using a distributed lock.
+// The instance which holds the lock is the leader until it stops or loses its lease, another instance is then elected.
+// The returned function stops the election and releases the leadership if it is held.
+func (g *Gaz) LeaderElection(name string, callbacks LeaderCallbacks, opts ...LockOpt) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	g.goTracked("leader_election", name, func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			l, err := g.Lock(ctx, name, opts...)
+			if err != nil {
+				if ctx.Err() == nil {
+					Log.Warn("leader election failed, retrying", zap.String("election", name), zap.Error(err))
+					select {
+					case <-ctx.Done():
+					case <-g.Clock().After(time.Second):
+					}
+				}
+				continue
+			}
+			Log.Info("elected leader", zap.String("election", name))
+			leaderCtx, revoke := context.WithCancel(ctx)
+			if callbacks.OnElected != nil {
+				go callbacks.OnElected(leaderCtx)
+			}
+			select {
+			case <-l.Lost():
+			case <-ctx.Done():
+				if err := l.Unlock(); err != nil {
+					Log.Warn("could not release leadership", zap.String("election", name), zap.Error(err))
+				}
+			}
+			revoke()
+			Log.Info("leadership revoked", zap.String("election", name))
+			if callbacks.OnRevoked != nil {
+				callbacks.OnRevoked()
+			}
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package gorillaz
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	StreamOversizeEvents         = "stream_oversize_events"
+	StreamConsumerOversizeEvents = "stream_consumer_oversize_events"
+	NatsOversizeEvents           = "nats_oversize_events"
+)
+
+// ErrEventTooLarge is the error of an event whose marshalled size exceeds the configured maximum
+var ErrEventTooLarge = errors.New("event too large")
+
+// ProviderMaxEventSize rejects the events submitted whose marshalled size exceeds maxBytes with ErrEventTooLarge,
+// instead of sending them and exceeding the maximum message size of the gRPC subscribers mid-stream
+func ProviderMaxEventSize(maxBytes int) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.MaxEventSize = maxBytes
+	}
+}
+
+// GetAndWatchMaxEventSize rejects the events submitted whose marshalled size exceeds maxBytes, they are neither sent nor stored
+func GetAndWatchMaxEventSize(maxBytes int) GetAndWatchConfigOpt {
+	return func(p *GetAndWatchConfig) {
+		p.MaxEventSize = maxBytes
+	}
+}
+
+// WithMaxEventSize drops the events received whose marshalled size exceeds maxBytes, they are not delivered
+func WithMaxEventSize(maxBytes int) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.MaxEventSize = maxBytes
+	}
+}
+
+// WithNatsMaxEventSize makes NatsPublish return ErrEventTooLarge for the events whose marshalled size exceeds maxBytes,
+// instead of publishing them in chunks
+func WithNatsMaxEventSize(maxBytes int) Option {
+	return Option{func(g *Gaz) error {
+		if maxBytes <= 0 {
+			return fmt.Errorf("nats max event size must be positive, got %d", maxBytes)
+		}
+		g.natsMaxEventSize = maxBytes
+		return nil
+	}}
+}
+
+// checkEventSize returns ErrEventTooLarge if size exceeds maxBytes, 0 meaning unlimited
+func checkEventSize(size, maxBytes int) error {
+	if maxBytes > 0 && size > maxBytes {
+		return fmt.Errorf("%w: %d bytes, maximum %d", ErrEventTooLarge, size, maxBytes)
+	}
+	return nil
+}
+
+// streamEventSize returns the marshalled size of the event, as sent by a stream provider
+func streamEventSize(evt *stream.Event) int {
+	metadata, _ := stream.EventMetadata(evt)
+	return proto.Size(&stream.StreamEvent{Metadata: metadata, Key: evt.Key, Value: evt.Value})
+}
+
+var oversizeEventsMu sync.Mutex
+var oversizeEventsCounters = make(map[string]prometheus.Counter)
+
+// oversizeEventsCounter returns the counter of oversize events named name, labelled with the stream or the subject
+func oversizeEventsCounter(g *Gaz, name, label, value string) prometheus.Counter {
+	oversizeEventsMu.Lock()
+	defer oversizeEventsMu.Unlock()
+
+	k := name + "/" + value
+	if c, ok := oversizeEventsCounters[k]; ok {
+		return c
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: name,
+		Help: "The total number of events rejected because they exceed the maximum event size",
+		ConstLabels: prometheus.Labels{
+			label: value,
+		},
+	})
+	g.prometheusRegistry.MustRegister(c)
+	oversizeEventsCounters[k] = c
+	return c
+}
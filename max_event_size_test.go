@@ -0,0 +1,85 @@
+package gorillaz
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxEventSize(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	small := &stream.Event{Key: []byte("k"), Value: []byte("small")}
+	large := &stream.Event{Key: []byte("k"), Value: bytes.Repeat([]byte("x"), 1024)}
+
+	// the provider rejects the oversize events
+	limited, err := g.NewStreamProvider("size_limited", "dummy.type", ProviderMaxEventSize(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = limited.SubmitNonBlocking(large)
+	assert.True(t, errors.Is(err, ErrEventTooLarge), "unexpected error %v", err)
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "size_limited"}, StreamOversizeEvents, 1)
+
+	// the consumer drops the oversize events received
+	provider, err := g.NewStreamProvider("size_unlimited", "dummy.type", LazyBroadcast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Submit(large)
+	provider.Submit(small)
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "size_unlimited", WithMaxEventSize(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	assertReceived(t, "size_unlimited", consumer.EvtChan(), small)
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "size_unlimited"}, StreamConsumerOversizeEvents, 1)
+
+	assert.Nil(t, checkEventSize(512, 512))
+	assert.Nil(t, checkEventSize(1024, 0), "the size is unlimited by default")
+}
+
+func TestGetAndWatchMaxEventSize(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider := g.NewGetAndWatchStreamProvider("state_size_unlimited", "dummy.type")
+	provider.Submit(&stream.Event{Key: []byte("large"), Value: bytes.Repeat([]byte("x"), 1024)})
+	provider.Submit(&stream.Event{Key: []byte("small"), Value: []byte("small")})
+
+	// the consumer counts the state updates it drops
+	consumer, err := g.GetAndWatchStream("does not matter", "state_size_unlimited", WithMaxEventSize(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	select {
+	case evt := <-consumer.EvtChan():
+		assert.Equal(t, "small", string(evt.Key))
+	case <-time.After(5 * time.Second):
+		t.Fatal("state not received")
+	}
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "state_size_unlimited"}, StreamConsumerLostStateUpdates, 1)
+
+	// with error events, the consumer fails as its state is incomplete
+	failing, err := g.GetAndWatchStream("does not matter", "state_size_unlimited", WithMaxEventSize(512), WithErrorEvents())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer failing.Stop()
+	select {
+	case evt := <-failing.ErrChan():
+		assert.True(t, errors.Is(evt.Err, ErrStateUpdateLost), "unexpected error %v", evt.Err)
+		assert.True(t, errors.Is(failing.Err(), ErrStateUpdateLost))
+	case <-time.After(5 * time.Second):
+		t.Fatal("error event not emitted")
+	}
+}
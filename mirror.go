@@ -0,0 +1,95 @@
+package gorillaz
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	NatsMirroredEvents = "nats_mirrored_events"
+	NatsMirrorErrors   = "nats_mirror_errors"
+)
+
+const MirrorEnvLabel = "env"
+
+type natsMirror struct {
+	env  string
+	rate float64
+}
+
+// WithMirror publishes the event on the subject of the environment env as well, for instance to mirror the production
+// traffic into a staging environment. Only a fraction rate of the events are mirrored, chosen at random: 1 mirrors every event.
+// The mirrored subject is prefixed by env, even if the env prefix of the Nats subjects is disabled.
+// The mirrors are published independently of the event and of each other: their failures are logged and counted
+// in nats_mirror_errors but not returned by NatsPublish, which returns the error of the event only.
+func WithMirror(env string, rate float64) NatsPublishOpt {
+	return func(o *NatsPublishOpts) {
+		o.mirrors = append(o.mirrors, natsMirror{env: env, rate: rate})
+	}
+}
+
+// publishMirrors publishes the event on the subjects of the mirror environments sampled
+func (g *Gaz) publishMirrors(subject string, e *stream.Event, opts []NatsPublishOpt) {
+	conf := &NatsPublishOpts{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	for _, m := range conf.mirrors {
+		if !m.sampled(g.Env) {
+			continue
+		}
+		mirrorSubject := g.mirrorSubject(m.env, subject)
+		if err := g.natsPublish(mirrorSubject, e, opts...); err != nil {
+			Log.Warn("failed to publish the mirrored event", zap.String("subject", mirrorSubject), zap.Error(err))
+			mirrorCounter(g, NatsMirrorErrors, subject, m.env).Inc()
+			continue
+		}
+		mirrorCounter(g, NatsMirroredEvents, subject, m.env).Inc()
+	}
+}
+
+// sampled returns true if the event must be mirrored, never into the environment of the service
+func (m natsMirror) sampled(env string) bool {
+	if m.env == env || m.rate <= 0 {
+		return false
+	}
+	return m.rate >= 1 || rand.Float64() < m.rate
+}
+
+// mirrorSubject returns the subject of the environment env
+func (g *Gaz) mirrorSubject(env, subject string) string {
+	return env + "." + addTenantToSubject(g.Tenant, subject)
+}
+
+var mirrorCountersMu sync.Mutex
+var mirrorCounters = make(map[string]prometheus.Counter)
+
+// mirrorCounter returns the counter named name of the events of the subject mirrored into env
+func mirrorCounter(g *Gaz, name, subject, env string) prometheus.Counter {
+	mirrorCountersMu.Lock()
+	defer mirrorCountersMu.Unlock()
+
+	k := name + "/" + subject + "/" + env
+	if c, ok := mirrorCounters[k]; ok {
+		return c
+	}
+	help := "The total number of events mirrored into another environment"
+	if name == NatsMirrorErrors {
+		help = "The total number of events which failed to be mirrored into another environment"
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: name,
+		Help: help,
+		ConstLabels: prometheus.Labels{
+			NatsSubjectLabel: subject,
+			MirrorEnvLabel:   env,
+		},
+	})
+	g.prometheusRegistry.MustRegister(c)
+	mirrorCounters[k] = c
+	return c
+}
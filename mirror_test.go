@@ -0,0 +1,30 @@
+package gorillaz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorSubject(t *testing.T) {
+	g := &Gaz{Env: "prod", Tenant: "acme", addEnvPrefixToNats: true}
+	assert.Equal(t, "staging.acme.orders.created", g.mirrorSubject("staging", "orders.created"))
+
+	g = &Gaz{Env: "prod"}
+	assert.Equal(t, "staging.orders.created", g.mirrorSubject("staging", "orders.created"), "the mirrored subject is always prefixed by the env")
+}
+
+func TestMirrorSampling(t *testing.T) {
+	assert.True(t, natsMirror{env: "staging", rate: 1}.sampled("prod"))
+	assert.False(t, natsMirror{env: "staging", rate: 0}.sampled("prod"))
+	assert.False(t, natsMirror{env: "prod", rate: 1}.sampled("prod"), "the events are not mirrored into their own env")
+
+	sampled := 0
+	m := natsMirror{env: "staging", rate: 0.1}
+	for i := 0; i < 10000; i++ {
+		if m.sampled("prod") {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 1000, sampled, 200)
+}
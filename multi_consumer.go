@@ -0,0 +1,229 @@
+package gorillaz
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+const (
+	StreamConsumerGroupReceivedEvents   = "stream_consumer_group_received_events"
+	StreamConsumerGroupStreams          = "stream_consumer_group_streams"
+	StreamConsumerGroupConnectedStreams = "stream_consumer_group_connected_streams"
+)
+
+// MultiStreamEvent is an event of one of the streams of a MultiStreamConsumer
+type MultiStreamEvent struct {
+	*stream.Event
+	StreamName string
+}
+
+type MultiStreamConsumer interface {
+	// EvtChan returns the events of all the streams, it is closed by gorillaz once all the consumers stopped
+	EvtChan() <-chan *MultiStreamEvent
+	// Consumers returns the consumer of each stream, by stream name, for instance to read their Err
+	Consumers() map[string]StreamConsumer
+	// Stop stops the consumers of all the streams
+	Stop()
+}
+
+type multiStreamConsumer struct {
+	consumers map[string]StreamConsumer
+	evtChan   chan *MultiStreamEvent
+	done      chan struct{}
+	stopOnce  sync.Once
+	metrics   *consumerGroupMetrics
+
+	mu        sync.Mutex
+	connected map[string]bool
+}
+
+func (m *multiStreamConsumer) EvtChan() <-chan *MultiStreamEvent {
+	return m.evtChan
+}
+
+func (m *multiStreamConsumer) Consumers() map[string]StreamConsumer {
+	consumers := make(map[string]StreamConsumer, len(m.consumers))
+	for name, c := range m.consumers {
+		consumers[name] = c
+	}
+	return consumers
+}
+
+func (m *multiStreamConsumer) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+		for _, c := range m.consumers {
+			c.Stop()
+		}
+	})
+}
+
+// setConnected updates the number of streams connected, the consumers call OnDisconnected even if they did not connect
+func (m *multiStreamConsumer) setConnected(streamName string, connected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.connected[streamName] == connected {
+		return
+	}
+	m.connected[streamName] = connected
+	if connected {
+		m.metrics.connectedGauge.Inc()
+	} else {
+		m.metrics.connectedGauge.Dec()
+	}
+}
+
+// disconnectAll removes the streams of the stopped group from the streams connected
+func (m *multiStreamConsumer) disconnectAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, connected := range m.connected {
+		if connected {
+			m.connected[name] = false
+			m.metrics.connectedGauge.Dec()
+		}
+	}
+}
+
+// ConsumeStreams consumes several streams of the same endpoints, and merges their events in a single channel.
+// The streams share the connections of the endpoints, they keep their own metrics and are also counted together
+// in the metrics of the group, labelled with the endpoints.
+func (g *Gaz) ConsumeStreams(endpoints []string, streamNames []string, opts ...ConsumerConfigOpt) (MultiStreamConsumer, error) {
+	config := defaultConsumerConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+	m := &multiStreamConsumer{
+		consumers: make(map[string]StreamConsumer, len(streamNames)),
+		evtChan:   make(chan *MultiStreamEvent, config.BufferLen),
+		done:      make(chan struct{}),
+		metrics:   consumerGroupMonitoring(g, endpoints),
+		connected: make(map[string]bool, len(streamNames)),
+	}
+	onConnected, onDisconnected := config.OnConnected, config.OnDisconnected
+	opts = append(opts, func(c *ConsumerConfig) {
+		c.OnConnected = func(streamName string) {
+			m.setConnected(streamName, true)
+			if onConnected != nil {
+				onConnected(streamName)
+			}
+		}
+		c.OnDisconnected = func(streamName string) {
+			m.setConnected(streamName, false)
+			if onDisconnected != nil {
+				onDisconnected(streamName)
+			}
+		}
+	})
+
+	for _, name := range streamNames {
+		sc, err := g.ConsumeStream(endpoints, name, opts...)
+		if err != nil {
+			m.Stop()
+			releaseConsumerGroupMonitoring(g, endpoints)
+			return nil, err
+		}
+		m.consumers[name] = sc
+	}
+	m.metrics.streamsGauge.Add(float64(len(streamNames)))
+
+	var wg sync.WaitGroup
+	wg.Add(len(m.consumers))
+	for name, sc := range m.consumers {
+		name, sc := name, sc
+		g.goTracked("multi_consumer", name, func() {
+			defer wg.Done()
+			m.forward(name, sc)
+		})
+	}
+	g.goTracked("multi_consumer", strings.Join(endpoints, ","), func() {
+		wg.Wait()
+		m.disconnectAll()
+		m.metrics.streamsGauge.Sub(float64(len(streamNames)))
+		releaseConsumerGroupMonitoring(g, endpoints)
+		close(m.evtChan)
+	})
+	return m, nil
+}
+
+// forward sends the events of the stream to the merged channel until the consumer stops, they are dropped once the group is stopped
+func (m *multiStreamConsumer) forward(streamName string, sc StreamConsumer) {
+	for e := range sc.EvtChan() {
+		m.metrics.receivedCounter.Inc()
+		select {
+		case m.evtChan <- &MultiStreamEvent{Event: e, StreamName: streamName}:
+		case <-m.done:
+		}
+	}
+}
+
+type consumerGroupMetrics struct {
+	refs            int
+	receivedCounter prometheus.Counter
+	streamsGauge    prometheus.Gauge
+	connectedGauge  prometheus.Gauge
+}
+
+func (m *consumerGroupMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.receivedCounter, m.streamsGauge, m.connectedGauge}
+}
+
+// map of metrics registered to Prometheus, by endpoints
+var consumerGroupMetricsMu sync.Mutex
+var consumerGroupMonitorings = make(map[string]*consumerGroupMetrics)
+
+func consumerGroupMonitoring(g *Gaz, endpoints []string) *consumerGroupMetrics {
+	consumerGroupMetricsMu.Lock()
+	defer consumerGroupMetricsMu.Unlock()
+
+	target := strings.Join(endpoints, ",")
+	if m, ok := consumerGroupMonitorings[target]; ok {
+		m.refs++
+		return m
+	}
+	labels := prometheus.Labels{StreamEndpointsLabel: target}
+	m := &consumerGroupMetrics{
+		refs: 1,
+		receivedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamConsumerGroupReceivedEvents,
+			Help:        "The total number of events received on all the streams consumed together from the endpoints",
+			ConstLabels: labels,
+		}),
+		streamsGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        StreamConsumerGroupStreams,
+			Help:        "The number of streams consumed together from the endpoints",
+			ConstLabels: labels,
+		}),
+		connectedGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        StreamConsumerGroupConnectedStreams,
+			Help:        "The number of streams consumed together from the endpoints which are connected",
+			ConstLabels: labels,
+		}),
+	}
+	for _, c := range m.collectors() {
+		g.prometheusRegistry.MustRegister(c)
+	}
+	consumerGroupMonitorings[target] = m
+	return m
+}
+
+func releaseConsumerGroupMonitoring(g *Gaz, endpoints []string) {
+	consumerGroupMetricsMu.Lock()
+	defer consumerGroupMetricsMu.Unlock()
+
+	target := strings.Join(endpoints, ",")
+	m, ok := consumerGroupMonitorings[target]
+	if !ok {
+		return
+	}
+	if m.refs--; m.refs > 0 {
+		return
+	}
+	for _, c := range m.collectors() {
+		g.prometheusRegistry.Unregister(c)
+	}
+	delete(consumerGroupMonitorings, target)
+}
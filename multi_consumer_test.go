@@ -0,0 +1,57 @@
+package gorillaz
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumeStreams(t *testing.T) {
+	gp := New(WithServiceName("multi_provider"))
+	<-gp.Run()
+	defer gp.Shutdown()
+	nonLazy := func(conf *ProviderConfig) {
+		conf.LazyBroadcast = false
+	}
+	flights, err := gp.NewStreamProvider("multi_flights", "dummy.type", nonLazy)
+	assert.Nil(t, err)
+	weather, err := gp.NewStreamProvider("multi_weather", "dummy.type", nonLazy)
+	assert.Nil(t, err)
+
+	gc := New(WithServiceName("multi_consumer"))
+	<-gc.Run()
+	defer gc.Shutdown()
+	pAddr := fmt.Sprintf("localhost:%d", gp.GrpcPort())
+	mc, err := gc.ConsumeStreams([]string{pAddr}, []string{"multi_flights", "multi_weather"})
+	assert.Nil(t, err)
+	assert.Len(t, mc.Consumers(), 2)
+
+	labels := map[string]string{StreamEndpointsLabel: pAddr}
+	waitUntil(t, 5*time.Second, "streams connected", func() bool {
+		m, err := findMetric(gc, StreamConsumerGroupConnectedStreams, labels)
+		return err == nil && m.GetGauge().GetValue() == 2
+	})
+
+	flights.Submit(&stream.Event{Key: []byte("AFR123")})
+	weather.Submit(&stream.Event{Key: []byte("LFPG")})
+	received := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-mc.EvtChan():
+			received[e.StreamName] = string(e.Key)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events not received, got %v", received)
+		}
+	}
+	assert.Equal(t, map[string]string{"multi_flights": "AFR123", "multi_weather": "LFPG"}, received)
+	assertCounterEquals(t, gc, labels, StreamConsumerGroupReceivedEvents, 2)
+
+	mc.Stop()
+	for range mc.EvtChan() {
+	}
+	_, err = findMetric(gc, StreamConsumerGroupStreams, labels)
+	assert.NotNil(t, err, "the metrics of the group are unregistered once it stopped")
+}
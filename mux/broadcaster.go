@@ -12,8 +12,10 @@ Once a broadcaster is closed, SubmitBlocking will panic and SubmitNonBlocking re
 package mux
 
 import (
+	"context"
 	"fmt"
 	"sync/atomic"
+	"time"
 )
 
 type Broadcaster struct {
@@ -23,8 +25,13 @@ type Broadcaster struct {
 	reg      chan registration
 	unreg    chan unregistration
 	outputs  map[chan<- interface{}]ConsumerConfig
+	counters map[chan<- interface{}]*consumerCounters
+	elastic  map[chan<- interface{}]*elasticBuffer
+	stats    chan chan []ConsumerStats
 	*BroadcasterConfig
-	closed chan interface{}
+	closed         chan interface{}
+	lastConsumerID uint64
+	flushTicker    *time.Ticker
 }
 
 // Register a new channel to receive broadcasts
@@ -64,6 +71,38 @@ func (b *Broadcaster) Close() {
 	<-b.closed
 }
 
+// Consumers returns the stats of the registered consumers, nil once the broadcaster is closed
+func (b *Broadcaster) Consumers() []ConsumerStats {
+	callback := make(chan []ConsumerStats, 1)
+	select {
+	case b.stats <- callback:
+		return <-callback
+	case <-b.closed:
+		return nil
+	}
+}
+
+func (b *Broadcaster) consumerStats() []ConsumerStats {
+	stats := make([]ConsumerStats, 0, len(b.outputs))
+	for ch, config := range b.outputs {
+		c := b.counters[ch]
+		s := ConsumerStats{
+			ID:        config.id,
+			Name:      config.name,
+			Delivered: c.delivered,
+			Dropped:   c.dropped,
+			BufferLen: len(ch),
+			BufferCap: cap(ch),
+		}
+		if e, ok := b.elastic[ch]; ok {
+			s.Pending = len(e.queue)
+			s.QueueCap = e.capacity
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
 func (b *Broadcaster) Closed() bool {
 	select {
 	case <-b.closed:
@@ -78,7 +117,7 @@ func (b *Broadcaster) SubmitBlocking(i interface{}) {
 	if closing := atomic.LoadUint32(&b.closing); closing > 0 {
 		panic("writing to a closing broadcaster")
 	}
-	b.input <- i
+	b.input <- b.submitted(i)
 }
 
 // Submit a new object to all subscribers, this call will drop the message if the input channel is full
@@ -88,27 +127,109 @@ func (b *Broadcaster) SubmitNonBlocking(i interface{}) error {
 	}
 	select {
 	// try to insert the message into the broadcaster.
-	case b.input <- i:
+	case b.input <- b.submitted(i):
 		return nil
 	default:
 		return fmt.Errorf("value dropped")
 	}
 }
 
+// SubmitContext submits a new object to all subscribers, waiting for room in the input channel.
+// It returns an error if ctx is done or the broadcaster is closed before the object could be submitted.
+func (b *Broadcaster) SubmitContext(ctx context.Context, i interface{}) error {
+	if closing := atomic.LoadUint32(&b.closing); closing > 0 {
+		return fmt.Errorf("writing to a closing broadcaster")
+	}
+	select {
+	case b.input <- b.submitted(i):
+		return nil
+	case <-b.closed:
+		return fmt.Errorf("writing to a closed broadcaster")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SubmitGuaranteed submits a new object to all subscribers, waiting for room in the input channel.
+// Unlike the other values, it is not dropped on backpressure: the broadcaster waits up to timeout for the subscribers
+// to receive it, after the values queued for each subscriber. The subscribers still not ready by then are disconnected.
+// The other values are not broadcast meanwhile, it is meant for rare values such as markers.
+func (b *Broadcaster) SubmitGuaranteed(i interface{}, timeout time.Duration) error {
+	if closing := atomic.LoadUint32(&b.closing); closing > 0 {
+		return fmt.Errorf("writing to a closing broadcaster")
+	}
+	select {
+	case b.input <- guaranteedValue{value: b.submitted(i), timeout: timeout}:
+		return nil
+	case <-b.closed:
+		return fmt.Errorf("writing to a closed broadcaster")
+	}
+}
+
+// submitted calls the OnSubmit hook and timestamps the value if the deliveries are observed
+func (b *Broadcaster) submitted(i interface{}) interface{} {
+	if b.onSubmit != nil {
+		b.onSubmit(i)
+	}
+	if b.onDeliver != nil {
+		return timedValue{value: i, submittedAt: time.Now()}
+	}
+	return i
+}
+
 func (b *Broadcaster) broadcast(m interface{}) {
+	if g, ok := m.(guaranteedValue); ok {
+		b.broadcastGuaranteed(g)
+		return
+	}
+	var submittedAt time.Time
+	if tv, ok := m.(timedValue); ok {
+		m = tv.value
+		submittedAt = tv.submittedAt
+	}
 	for ch := range b.outputs {
+		e := b.elastic[ch]
+		if e != nil && !b.flushPending(ch, e) {
+			// values are already waiting for this consumer, the value is queued behind them to keep the order
+			if !e.push(timedValue{value: m, submittedAt: submittedAt}) {
+				b.dropped(ch, m)
+			}
+			continue
+		}
 		select {
 		case ch <- m:
 			//message sent
+			b.delivered(ch, m, submittedAt)
 		default:
-			//consumer is not ready to receive a message, drop it and execute provided action on backpressure
-			subConfig := b.outputs[ch]
-			if subConfig.onBackpressure != nil {
-				subConfig.onBackpressure(m)
+			//consumer is not ready to receive a message, queue it if possible, otherwise drop it
+			if e == nil || !e.push(timedValue{value: m, submittedAt: submittedAt}) {
+				b.dropped(ch, m)
 			}
-			if subConfig.disconnectOnBackpressure {
-				b.unregister(ch)
+		}
+	}
+	if b.postBroadcast != nil {
+		b.postBroadcast(m)
+	}
+}
+
+// broadcastGuaranteed delivers the value to every subscriber after the values queued for it, disconnecting the subscribers
+// not receiving it in time
+func (b *Broadcaster) broadcastGuaranteed(g guaranteedValue) {
+	m := g.value
+	var submittedAt time.Time
+	if tv, ok := m.(timedValue); ok {
+		m = tv.value
+		submittedAt = tv.submittedAt
+	}
+	deadline := newFanoutDeadline(g.timeout)
+	defer deadline.stop()
+	for ch := range b.outputs {
+		if !b.deliverBlocking(ch, m, submittedAt, deadline) {
+			b.counters[ch].dropped++
+			if onBackpressure := b.outputs[ch].onBackpressure; onBackpressure != nil {
+				onBackpressure(m)
 			}
+			b.unregister(ch)
 		}
 	}
 	if b.postBroadcast != nil {
@@ -116,8 +237,87 @@ func (b *Broadcaster) broadcast(m interface{}) {
 	}
 }
 
+// deliverBlocking sends the values queued for the subscriber then the value, returns false if it is not done before the deadline
+func (b *Broadcaster) deliverBlocking(ch chan<- interface{}, m interface{}, submittedAt time.Time, deadline *fanoutDeadline) bool {
+	if e, ok := b.elastic[ch]; ok && len(e.queue) > 0 {
+		for len(e.queue) > 0 {
+			v := e.queue[0]
+			if !deadline.send(ch, v.value) {
+				return false
+			}
+			e.queue[0] = timedValue{}
+			e.queue = e.queue[1:]
+			b.delivered(ch, v.value, v.submittedAt)
+		}
+		e.queue = nil
+		e.emptySince = time.Now()
+	}
+	if !deadline.send(ch, m) {
+		return false
+	}
+	b.delivered(ch, m, submittedAt)
+	return true
+}
+
+// fanoutDeadline bounds the time spent delivering a value to all the subscribers: they are waited for until the deadline,
+// then the values are only sent to the subscribers with room left in their channel
+type fanoutDeadline struct {
+	timer   *time.Timer
+	expired bool
+}
+
+func newFanoutDeadline(timeout time.Duration) *fanoutDeadline {
+	return &fanoutDeadline{timer: time.NewTimer(timeout)}
+}
+
+// send sends the value to the subscriber, it returns false if the subscriber is not ready by the deadline
+func (d *fanoutDeadline) send(ch chan<- interface{}, v interface{}) bool {
+	if !d.expired {
+		select {
+		case ch <- v:
+			return true
+		case <-d.timer.C:
+			d.expired = true
+		}
+	}
+	select {
+	case ch <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *fanoutDeadline) stop() {
+	d.timer.Stop()
+}
+
+func (b *Broadcaster) delivered(ch chan<- interface{}, m interface{}, submittedAt time.Time) {
+	b.counters[ch].delivered++
+	if b.onDeliver != nil {
+		b.onDeliver(b.outputs[ch].id, m, time.Since(submittedAt))
+	}
+}
+
+// dropped executes the action provided on backpressure
+func (b *Broadcaster) dropped(ch chan<- interface{}, m interface{}) {
+	b.counters[ch].dropped++
+	subConfig := b.outputs[ch]
+	if subConfig.onBackpressure != nil {
+		subConfig.onBackpressure(m)
+	}
+	if subConfig.disconnectOnBackpressure {
+		b.unregister(ch)
+	}
+}
+
 // onBackPressureState can be nil
 func (b *Broadcaster) run() {
+	defer func() {
+		if b.flushTicker != nil {
+			b.flushTicker.Stop()
+		}
+	}()
 	for {
 		// if lazy, if there is no more subscriber, do not consume any value until there is at least 1 subscriber
 		if !b.eagerBroadcast && len(b.outputs) == 0 {
@@ -127,6 +327,8 @@ func (b *Broadcaster) run() {
 				u.done <- struct{}{}
 			case r := <-b.reg:
 				b.addSubscriber(r)
+			case s := <-b.stats:
+				s <- b.consumerStats()
 			case <-b.closeReq:
 				// notify all listeners that the broadcaster is now closed
 				close(b.closed)
@@ -141,8 +343,10 @@ func (b *Broadcaster) run() {
 				// finish delivering messages to subscribers before terminating
 				if len(b.outputs) > 0 {
 					// if there are still messages to broadcast, do it
-					for m := range b.input {
-						b.broadcast(m)
+					b.drainInput()
+					b.flushAll(time.Now())
+					if b.terminal != nil {
+						b.deliverTerminalValue()
 					}
 					// close all subscribers
 					for sub := range b.outputs {
@@ -151,6 +355,8 @@ func (b *Broadcaster) run() {
 					// cleanup b.outputs
 					for sub := range b.outputs {
 						delete(b.outputs, sub)
+						delete(b.counters, sub)
+						delete(b.elastic, sub)
 					}
 				}
 				return
@@ -159,6 +365,10 @@ func (b *Broadcaster) run() {
 			case u := <-b.unreg:
 				b.unregister(u.channel)
 				u.done <- struct{}{}
+			case s := <-b.stats:
+				s <- b.consumerStats()
+			case now := <-b.flushTick():
+				b.flushAll(now)
 			case m := <-b.input:
 				b.broadcast(m)
 			}
@@ -166,16 +376,65 @@ func (b *Broadcaster) run() {
 	}
 }
 
+// deliverTerminalValue sends the terminal value to every consumer, after the values still queued for it.
+// Once the timeout elapsed, the values are only sent to the consumers with room left in their channel.
+func (b *Broadcaster) deliverTerminalValue() {
+	deadline := newFanoutDeadline(b.terminal.timeout)
+	defer deadline.stop()
+	for ch := range b.outputs {
+		if e, ok := b.elastic[ch]; ok {
+			queued := e.queue
+			e.queue = nil
+			sent := 0
+			for _, v := range queued {
+				if !deadline.send(ch, v.value) {
+					break
+				}
+				b.delivered(ch, v.value, v.submittedAt)
+				sent++
+			}
+			if sent < len(queued) {
+				// the terminal value must not overtake the values not delivered
+				continue
+			}
+		}
+		deadline.send(ch, b.terminal.value)
+	}
+}
+
+// drainInput broadcasts the values remaining in the input channel, which is never closed as submitters may still be writing to it
+func (b *Broadcaster) drainInput() {
+	for {
+		select {
+		case m := <-b.input:
+			b.broadcast(m)
+		default:
+			return
+		}
+	}
+}
+
 func (b *Broadcaster) unregister(ch chan<- interface{}) {
 	// check if the channel was not already unregistered
 	if _, ok := b.outputs[ch]; ok {
 		delete(b.outputs, ch)
+		delete(b.counters, ch)
+		delete(b.elastic, ch)
 		close(ch)
 	}
 }
 
 func (b *Broadcaster) addSubscriber(r registration) {
+	b.lastConsumerID++
+	r.consumer.config.id = b.lastConsumerID
 	b.outputs[r.consumer.channel] = r.consumer.config
+	b.counters[r.consumer.channel] = &consumerCounters{}
+	if r.consumer.config.elastic != nil {
+		b.elastic[r.consumer.channel] = newElasticBuffer(*r.consumer.config.elastic)
+		if b.flushTicker == nil {
+			b.flushTicker = time.NewTicker(elasticFlushInterval)
+		}
+	}
 	r.done <- struct{}{}
 }
 
@@ -189,6 +448,9 @@ func NewNonBlockingBroadcaster(bufLen int, options ...BroadcasterOptionFunc) *Br
 		reg:               make(chan registration),
 		unreg:             make(chan unregistration),
 		outputs:           make(map[chan<- interface{}]ConsumerConfig),
+		counters:          make(map[chan<- interface{}]*consumerCounters),
+		elastic:           make(map[chan<- interface{}]*elasticBuffer),
+		stats:             make(chan chan []ConsumerStats),
 		BroadcasterConfig: &BroadcasterConfig{eagerBroadcast: true},
 		closed:            make(chan interface{}),
 	}
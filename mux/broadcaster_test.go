@@ -1,6 +1,7 @@
 package mux
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -125,6 +126,17 @@ func TestProducerDropsMessageOnBackpressure(t *testing.T) {
 	}
 }
 
+func TestSubmitContext(t *testing.T) {
+	b := NewNonBlockingBroadcaster(0, LazyBroadcast)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// no subscriber, the lazy broadcaster doesn't consume the value
+	assert.Equal(t, context.DeadlineExceeded, b.SubmitContext(ctx, "someValue"))
+
+	b.Close()
+	assert.NotNil(t, b.SubmitContext(context.Background(), "someValue"))
+}
+
 func TestDisconnectOnBackPressure(t *testing.T) {
 	b := NewNonBlockingBroadcaster(0)
 	ch1 := make(chan interface{}, 1)
@@ -235,3 +247,274 @@ func TestUnsubscribeAfterClose(t *testing.T) {
 		t.Log("Unregistered successfully")
 	}
 }
+
+func TestTee(t *testing.T) {
+	src := NewNonBlockingBroadcaster(10)
+	dst1 := NewNonBlockingBroadcaster(10)
+	dst2 := NewNonBlockingBroadcaster(10)
+	Tee(src, dst1, dst2)
+
+	ch1 := make(chan interface{}, 10)
+	ch2 := make(chan interface{}, 10)
+	dst1.Register(ch1)
+	dst2.Register(ch2)
+
+	for i := 0; i < 3; i++ {
+		src.SubmitBlocking(i)
+	}
+	for _, ch := range []chan interface{}{ch1, ch2} {
+		for i := 0; i < 3; i++ {
+			select {
+			case v := <-ch:
+				assert.Equal(t, i, v)
+			case <-time.After(time.Second):
+				t.Fatalf("value %d not forwarded", i)
+			}
+		}
+	}
+
+	src.Close()
+	for _, dst := range []*Broadcaster{dst1, dst2} {
+		select {
+		case <-dst.closed:
+		case <-time.After(time.Second):
+			t.Fatal("destinations must be closed with the source")
+		}
+	}
+}
+
+func TestForwardToStops(t *testing.T) {
+	src := NewNonBlockingBroadcaster(10)
+	dst := NewNonBlockingBroadcaster(10)
+	ch := make(chan interface{}, 10)
+	dst.Register(ch)
+
+	stop := src.ForwardTo(dst)
+	src.SubmitBlocking("forwarded")
+	select {
+	case v := <-ch:
+		assert.Equal(t, "forwarded", v)
+	case <-time.After(time.Second):
+		t.Fatal("value not forwarded")
+	}
+
+	stop()
+	stop()
+	src.SubmitBlocking("not forwarded")
+	select {
+	case v := <-ch:
+		t.Fatalf("unexpected value forwarded after stop: %v", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.False(t, dst.Closed(), "ForwardTo must not close the destination")
+	src.Close()
+	dst.Close()
+}
+
+func TestSubmitAndDeliverHooks(t *testing.T) {
+	submitted := make(chan interface{}, 10)
+	delivered := make(chan uint64, 10)
+	b := NewNonBlockingBroadcaster(10, WithOnSubmit(func(v interface{}) {
+		submitted <- v
+	}), WithOnDeliver(func(consumerID uint64, v interface{}, latency time.Duration) {
+		assert.Equal(t, "value", v)
+		assert.True(t, latency >= 0)
+		delivered <- consumerID
+	}))
+
+	ch1 := make(chan interface{}, 1)
+	ch2 := make(chan interface{}, 1)
+	b.Register(ch1)
+	b.Register(ch2)
+	b.SubmitBlocking("value")
+
+	assert.Equal(t, "value", <-ch1, "the consumers must receive the value, not its timestamped wrapper")
+	assert.Equal(t, "value", <-ch2)
+	assert.Equal(t, "value", <-submitted)
+	ids := map[uint64]bool{<-delivered: true, <-delivered: true}
+	assert.Equal(t, map[uint64]bool{1: true, 2: true}, ids)
+	b.Close()
+}
+
+func TestConsumerStats(t *testing.T) {
+	b := NewNonBlockingBroadcaster(10)
+	fast := make(chan interface{}, 10)
+	slow := make(chan interface{}, 1)
+	b.Register(fast, WithName("fast"))
+	b.Register(slow, WithName("slow"))
+
+	for i := 0; i < 3; i++ {
+		b.SubmitBlocking(i)
+	}
+	// wait for the broadcast of all the values
+	for i := 0; i < 3; i++ {
+		<-fast
+	}
+
+	stats := make(map[string]ConsumerStats)
+	for _, s := range b.Consumers() {
+		stats[s.Name] = s
+	}
+	assert.Equal(t, ConsumerStats{ID: 1, Name: "fast", Delivered: 3, BufferCap: 10}, stats["fast"])
+	assert.Equal(t, ConsumerStats{ID: 2, Name: "slow", Delivered: 1, Dropped: 2, BufferLen: 1, BufferCap: 1}, stats["slow"])
+
+	b.Close()
+	assert.Equal(t, 0, len(b.Consumers()))
+}
+
+func TestElasticBuffer(t *testing.T) {
+	b := NewNonBlockingBroadcaster(10)
+	ch := make(chan interface{}, 1)
+	var dropped []interface{}
+	b.Register(ch, WithElasticBuffer(1, 4, 50*time.Millisecond), WithOnBackPressure(func(v interface{}) {
+		dropped = append(dropped, v)
+	}))
+
+	for i := 0; i < 7; i++ {
+		b.SubmitBlocking(i)
+	}
+	waitFor(t, func() bool {
+		s := b.Consumers()
+		return len(s) == 1 && s[0].Pending == 4
+	})
+	stats := b.Consumers()[0]
+	assert.Equal(t, 4, stats.QueueCap, "the queue must grow up to its maximum")
+	assert.Equal(t, uint64(2), stats.Dropped)
+
+	// the queued values are delivered in order once the consumer catches up
+	for i := 0; i < 5; i++ {
+		select {
+		case v := <-ch:
+			assert.Equal(t, i, v)
+		case <-time.After(time.Second):
+			t.Fatalf("value %d not delivered", i)
+		}
+	}
+	assert.Equal(t, []interface{}{5, 6}, dropped)
+
+	// the queue must shrink once drained
+	waitFor(t, func() bool {
+		return b.Consumers()[0].QueueCap == 1
+	})
+	b.Close()
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type endOfStream struct{}
+
+func TestTerminalValue(t *testing.T) {
+	b := NewNonBlockingBroadcaster(10, WithTerminalValue(endOfStream{}, time.Second))
+	ch := make(chan interface{}, 1)
+	b.Register(ch)
+	b.SubmitBlocking(1)
+	b.SubmitBlocking(2)
+
+	received := make(chan []interface{})
+	go func() {
+		var values []interface{}
+		for v := range ch {
+			values = append(values, v)
+		}
+		received <- values
+	}()
+	b.Close()
+
+	select {
+	case values := <-received:
+		assert.Equal(t, endOfStream{}, values[len(values)-1], "the terminal value must be the last value received")
+	case <-time.After(2 * time.Second):
+		t.Fatal("consumer channel not closed")
+	}
+}
+
+func TestTerminalValueStuckConsumer(t *testing.T) {
+	b := NewNonBlockingBroadcaster(10, WithTerminalValue(endOfStream{}, 100*time.Millisecond))
+	stuck := make(chan interface{})
+	ready := make(chan interface{}, 10)
+	b.Register(stuck)
+	b.Register(ready)
+	b.Close()
+
+	// whatever the order the consumers are served in, the one with room in its channel receives the terminal value
+	select {
+	case v := <-ready:
+		assert.Equal(t, endOfStream{}, v)
+	case <-time.After(2 * time.Second):
+		t.Fatal("terminal value not received")
+	}
+	_, ok := <-ready
+	assert.False(t, ok, "the consumer channel is closed after the terminal value")
+	_, ok = <-stuck
+	assert.False(t, ok, "the consumer not ready is closed without the terminal value")
+}
+
+func TestSubmitGuaranteed(t *testing.T) {
+	b := NewNonBlockingBroadcaster(10)
+	defer b.Close()
+	ch := make(chan interface{}, 1)
+	var dropped []interface{}
+	b.Register(ch, WithElasticBuffer(1, 1, time.Second), WithOnBackPressure(func(v interface{}) {
+		dropped = append(dropped, v)
+	}))
+	b.SubmitBlocking(1)
+	b.SubmitBlocking(2)
+	b.SubmitBlocking(3)
+	waitFor(t, func() bool {
+		return b.Consumers()[0].Dropped == 1
+	})
+	assert.NoError(t, b.SubmitGuaranteed("marker", time.Second))
+
+	// the marker is delivered after the queued values, although the consumer is lagging
+	var values []interface{}
+	for len(values) < 3 {
+		select {
+		case v := <-ch:
+			values = append(values, v)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("marker not delivered, received %v", values)
+		}
+	}
+	assert.Equal(t, []interface{}{1, 2, "marker"}, values)
+	assert.Equal(t, []interface{}{3}, dropped)
+
+	// a consumer not receiving the marker in time is disconnected
+	b.SubmitBlocking(4)
+	assert.NoError(t, b.SubmitGuaranteed("marker", 10*time.Millisecond))
+	waitFor(t, func() bool {
+		return len(b.Consumers()) == 0
+	})
+	assert.Equal(t, []interface{}{3, "marker"}, dropped)
+}
+
+func TestSubmitGuaranteedSingleDeadline(t *testing.T) {
+	b := NewNonBlockingBroadcaster(10)
+	defer b.Close()
+	for i := 0; i < 5; i++ {
+		b.Register(make(chan interface{}))
+	}
+	ready := make(chan interface{}, 1)
+	b.Register(ready)
+
+	start := time.Now()
+	assert.NoError(t, b.SubmitGuaranteed("marker", 100*time.Millisecond))
+	select {
+	case v := <-ready:
+		assert.Equal(t, "marker", v)
+	case <-time.After(2 * time.Second):
+		t.Fatal("marker not delivered")
+	}
+	// the stuck subscribers are waited for once, not one after another
+	waitFor(t, func() bool {
+		return len(b.Consumers()) == 1
+	})
+	assert.True(t, time.Since(start) < 400*time.Millisecond, "the fan-out took %v", time.Since(start))
+}
@@ -25,11 +25,52 @@ type ttlValue struct {
 type BroadcasterConfig struct {
 	postBroadcast  func(interface{})
 	eagerBroadcast bool
+	onSubmit       func(interface{})
+	onDeliver      func(consumerID uint64, value interface{}, latency time.Duration)
+	terminal       *terminalValue
+}
+
+type terminalValue struct {
+	value   interface{}
+	timeout time.Duration
 }
 
 type ConsumerConfig struct {
 	onBackpressure           func(value interface{})
 	disconnectOnBackpressure bool
+	id                       uint64
+	name                     string
+	elastic                  *elasticBufferConfig
+	keyFilter                func(key interface{}) bool
+}
+
+// ConsumerStats describes a registered consumer
+type ConsumerStats struct {
+	ID        uint64 `json:"id"`
+	Name      string `json:"name,omitempty"`
+	Delivered uint64 `json:"delivered"`  // Delivered is the number of values sent to the consumer channel
+	Dropped   uint64 `json:"dropped"`    // Dropped is the number of values dropped on backpressure
+	BufferLen int    `json:"buffer_len"` // BufferLen is the number of values waiting in the consumer channel
+	BufferCap int    `json:"buffer_cap"`
+	Pending   int    `json:"pending"`   // Pending is the number of values queued by the elastic buffer of the consumer
+	QueueCap  int    `json:"queue_cap"` // QueueCap is the current capacity of the elastic buffer of the consumer
+}
+
+type consumerCounters struct {
+	delivered uint64
+	dropped   uint64
+}
+
+// timedValue is a submitted value along with its submission time, used to measure the delivery latency
+type timedValue struct {
+	value       interface{}
+	submittedAt time.Time
+}
+
+// guaranteedValue is a submitted value which is not dropped on backpressure, see Broadcaster.SubmitGuaranteed
+type guaranteedValue struct {
+	value   interface{}
+	timeout time.Duration
 }
 
 type BroadcasterOptionFunc func(*BroadcasterConfig)
@@ -44,6 +85,29 @@ func (s *ConsumerConfig) DisconnectOnBackpressure() {
 	s.disconnectOnBackpressure = true
 }
 
+// Name identifies the consumer in its stats
+func (s *ConsumerConfig) Name(name string) {
+	s.name = name
+}
+
+// KeyFilter restricts the consumer to the keys accepted by keyFilter, the consumer doesn't receive the state nor the updates of the other keys.
+// It is called from the broadcaster goroutine and must not block.
+// It is only supported by StateBroadcaster
+func (s *ConsumerConfig) KeyFilter(keyFilter func(key interface{}) bool) {
+	s.keyFilter = keyFilter
+}
+
+func (s *ConsumerConfig) acceptsKey(key interface{}) bool {
+	return s.keyFilter == nil || s.keyFilter(key)
+}
+
+func WithName(name string) ConsumerOptionFunc {
+	return func(c *ConsumerConfig) error {
+		c.name = name
+		return nil
+	}
+}
+
 func WithOnBackPressure(onBackpressure func(value interface{})) ConsumerOptionFunc {
 	return func(c *ConsumerConfig) error {
 		c.onBackpressure = onBackpressure
@@ -51,6 +115,13 @@ func WithOnBackPressure(onBackpressure func(value interface{})) ConsumerOptionFu
 	}
 }
 
+func WithKeyFilter(keyFilter func(key interface{}) bool) ConsumerOptionFunc {
+	return func(c *ConsumerConfig) error {
+		c.keyFilter = keyFilter
+		return nil
+	}
+}
+
 func DisconnectOnBackPressure() ConsumerOptionFunc {
 	return func(c *ConsumerConfig) error {
 		c.disconnectOnBackpressure = true
@@ -63,6 +134,46 @@ func (b *BroadcasterConfig) PostBroadcast(postBroadcast func(interface{})) {
 	b.postBroadcast = postBroadcast
 }
 
+// Defines an action done for each submitted value, before it is queued.
+// It is called from the goroutine submitting the value.
+func (b *BroadcasterConfig) OnSubmit(onSubmit func(value interface{})) {
+	b.onSubmit = onSubmit
+}
+
+// Defines an action done each time a value is delivered to a consumer, with the time elapsed since its submission.
+// Consumers are identified by an id assigned when they register.
+// It is called from the broadcaster goroutine and must not block.
+func (b *BroadcasterConfig) OnDeliver(onDeliver func(consumerID uint64, value interface{}, latency time.Duration)) {
+	b.onDeliver = onDeliver
+}
+
+func WithOnSubmit(onSubmit func(value interface{})) BroadcasterOptionFunc {
+	return func(b *BroadcasterConfig) {
+		b.onSubmit = onSubmit
+	}
+}
+
+func WithOnDeliver(onDeliver func(consumerID uint64, value interface{}, latency time.Duration)) BroadcasterOptionFunc {
+	return func(b *BroadcasterConfig) {
+		b.onDeliver = onDeliver
+	}
+}
+
+// Defines a value delivered to every consumer when the broadcaster is closed, after the values submitted before Close
+// and before the consumer channels are closed.
+// Consumers can then tell a clean end of stream, when they receive this value, from a closed channel.
+// Delivering the value can wait up to timeout for slow consumers, the ones still not ready after timeout don't receive it.
+// It is only supported by Broadcaster
+func (b *BroadcasterConfig) TerminalValue(value interface{}, timeout time.Duration) {
+	b.terminal = &terminalValue{value: value, timeout: timeout}
+}
+
+func WithTerminalValue(value interface{}, timeout time.Duration) BroadcasterOptionFunc {
+	return func(b *BroadcasterConfig) {
+		b.TerminalValue(value, timeout)
+	}
+}
+
 // If true, the broadcaster will start broadcasting eagerly, otherwise the first consumer will trigger the broadcast.
 // A lazy broadcast can be used to apply backpressure on the producer if no consumer is present.
 func (b *BroadcasterConfig) EagerBroadcast(eager bool) {
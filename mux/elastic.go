@@ -0,0 +1,120 @@
+package mux
+
+import "time"
+
+// interval at which the values queued for lagging consumers are flushed to their channel
+const elasticFlushInterval = 10 * time.Millisecond
+
+type elasticBufferConfig struct {
+	min         int
+	max         int
+	shrinkAfter time.Duration
+}
+
+// WithElasticBuffer queues the values a consumer can't receive yet instead of dropping them.
+// The queue capacity starts at min and doubles, up to max, each time the queue is full.
+// It is halved, down to min, each time the queue stays empty for shrinkAfter.
+// Values are dropped on backpressure only once the queue reaches max values.
+// It is only supported by Broadcaster
+func WithElasticBuffer(min, max int, shrinkAfter time.Duration) ConsumerOptionFunc {
+	return func(c *ConsumerConfig) error {
+		c.ElasticBuffer(min, max, shrinkAfter)
+		return nil
+	}
+}
+
+// ElasticBuffer queues the values the consumer can't receive yet, see WithElasticBuffer
+func (s *ConsumerConfig) ElasticBuffer(min, max int, shrinkAfter time.Duration) {
+	if min < 0 {
+		min = 0
+	}
+	if max < min {
+		max = min
+	}
+	s.elastic = &elasticBufferConfig{min: min, max: max, shrinkAfter: shrinkAfter}
+}
+
+type elasticBuffer struct {
+	config     elasticBufferConfig
+	capacity   int
+	queue      []timedValue
+	emptySince time.Time
+}
+
+func newElasticBuffer(config elasticBufferConfig) *elasticBuffer {
+	return &elasticBuffer{config: config, capacity: config.min, emptySince: time.Now()}
+}
+
+// push queues the value, growing the queue if needed, returns false if the queue is full
+func (e *elasticBuffer) push(v timedValue) bool {
+	if len(e.queue) >= e.capacity {
+		if e.capacity >= e.config.max {
+			return false
+		}
+		e.capacity *= 2
+		if e.capacity == 0 {
+			e.capacity = 1
+		}
+		if e.capacity > e.config.max {
+			e.capacity = e.config.max
+		}
+	}
+	e.queue = append(e.queue, v)
+	return true
+}
+
+// shrink halves the capacity if the queue has been empty for long enough
+func (e *elasticBuffer) shrink(now time.Time) {
+	if len(e.queue) > 0 || e.capacity <= e.config.min || now.Sub(e.emptySince) < e.config.shrinkAfter {
+		return
+	}
+	e.capacity /= 2
+	if e.capacity < e.config.min {
+		e.capacity = e.config.min
+	}
+	e.emptySince = now
+}
+
+// flushPending sends the values queued for ch without blocking, returns true if the queue is empty
+func (b *Broadcaster) flushPending(ch chan<- interface{}, e *elasticBuffer) bool {
+	if len(e.queue) == 0 {
+		return true
+	}
+	sent := 0
+	for _, v := range e.queue {
+		select {
+		case ch <- v.value:
+			b.delivered(ch, v.value, v.submittedAt)
+			sent++
+			continue
+		default:
+		}
+		break
+	}
+	// release the references to the sent values
+	for i := 0; i < sent; i++ {
+		e.queue[i] = timedValue{}
+	}
+	e.queue = e.queue[sent:]
+	if len(e.queue) == 0 {
+		e.queue = nil
+		e.emptySince = time.Now()
+		return true
+	}
+	return false
+}
+
+// flushTick returns the channel ticking when pending values should be flushed, nil if there is no elastic consumer
+func (b *Broadcaster) flushTick() <-chan time.Time {
+	if b.flushTicker == nil {
+		return nil
+	}
+	return b.flushTicker.C
+}
+
+func (b *Broadcaster) flushAll(now time.Time) {
+	for ch, e := range b.elastic {
+		b.flushPending(ch, e)
+		e.shrink(now)
+	}
+}
@@ -19,16 +19,18 @@ import (
 )
 
 type keyValue struct {
-	key   interface{}
-	value interface{}
+	key         interface{}
+	value       interface{}
+	submittedAt time.Time
 }
 
 type StateUpdateChan chan<- *StateUpdate
 type updateFunc func(interface{}) interface{}
 
 type update struct {
-	key        interface{}
-	updateFunc updateFunc
+	key         interface{}
+	updateFunc  updateFunc
+	submittedAt time.Time
 }
 
 type clearAll string
@@ -36,16 +38,19 @@ type clearAll string
 const clearAllValues clearAll = "ALL"
 
 type StateBroadcaster struct {
-	input   chan keyValue
-	delete  chan interface{}
-	get     chan getCurrentState
-	reg     chan stateRegistration
-	unreg   chan stateUnregistration
-	outputs map[StateUpdateChan]ConsumerConfig
-	state   map[interface{}]ttlValue
-	update  chan update
-	closed  chan interface{}
+	input    chan keyValue
+	delete   chan interface{}
+	get      chan getCurrentState
+	reg      chan stateRegistration
+	unreg    chan stateUnregistration
+	outputs  map[StateUpdateChan]ConsumerConfig
+	counters map[StateUpdateChan]*consumerCounters
+	stats    chan chan []ConsumerStats
+	state    map[interface{}]ttlValue
+	update   chan update
+	closed   chan interface{}
 	*BroadcasterConfig
+	lastConsumerID uint64
 }
 
 type UpdateType int
@@ -114,6 +119,33 @@ func (b *StateBroadcaster) Close() {
 	close(b.reg)
 }
 
+// Consumers returns the stats of the registered consumers, nil once the broadcaster is closed
+func (b *StateBroadcaster) Consumers() []ConsumerStats {
+	callback := make(chan []ConsumerStats, 1)
+	select {
+	case b.stats <- callback:
+		return <-callback
+	case <-b.closed:
+		return nil
+	}
+}
+
+func (b *StateBroadcaster) consumerStats() []ConsumerStats {
+	stats := make([]ConsumerStats, 0, len(b.outputs))
+	for ch, config := range b.outputs {
+		c := b.counters[ch]
+		stats = append(stats, ConsumerStats{
+			ID:        config.id,
+			Name:      config.name,
+			Delivered: c.delivered,
+			Dropped:   c.dropped,
+			BufferLen: len(ch),
+			BufferCap: cap(ch),
+		})
+	}
+	return stats
+}
+
 func (b *StateBroadcaster) Closed() bool {
 	select {
 	case <-b.closed:
@@ -132,13 +164,16 @@ func (b *StateBroadcaster) Submit(k interface{}, v interface{}) {
 		panic("cannot broadcast nil key")
 	}
 
-	b.input <- keyValue{k, v}
+	if b.onSubmit != nil {
+		b.onSubmit(v)
+	}
+	b.input <- keyValue{k, v, time.Now()}
 
 }
 
 func (b *StateBroadcaster) Update(key interface{}, uf func(interface{}) interface{}) {
 	if b != nil && uf != nil && key != nil {
-		b.update <- update{key, uf}
+		b.update <- update{key, uf, time.Now()}
 	}
 }
 
@@ -156,14 +191,21 @@ func (b *StateBroadcaster) ClearState() {
 	}
 }
 
-func (b *StateBroadcaster) broadcast(m *StateUpdate) {
-	for ch := range b.outputs {
+func (b *StateBroadcaster) broadcast(key interface{}, m *StateUpdate, submittedAt time.Time) {
+	for ch, config := range b.outputs {
+		if !config.acceptsKey(key) {
+			continue
+		}
 		select {
 		case ch <- m:
 			//message sent
+			b.counters[ch].delivered++
+			if b.onDeliver != nil {
+				b.onDeliver(b.outputs[ch].id, m, time.Since(submittedAt))
+			}
 		default:
 			//consumer is not ready to receive a message, drop it and execute provided action on backpressure
-			config := b.outputs[ch]
+			b.counters[ch].dropped++
 			if config.onBackpressure != nil {
 				config.onBackpressure(m)
 			}
@@ -191,12 +233,12 @@ func (b *StateBroadcaster) run(ttl time.Duration) {
 		case k := <-b.delete:
 			if _, isClearAll := k.(clearAll); isClearAll {
 				for k := range b.state {
-					b.broadcast(&StateUpdate{Delete, k})
+					b.broadcast(k, &StateUpdate{Delete, k}, time.Now())
 				}
 				b.state = make(map[interface{}]ttlValue)
 			} else {
 				delete(b.state, k)
-				b.broadcast(&StateUpdate{Delete, k})
+				b.broadcast(k, &StateUpdate{Delete, k}, time.Now())
 			}
 		case g := <-b.get:
 			result := make(map[interface{}]interface{}, len(b.state))
@@ -206,12 +248,25 @@ func (b *StateBroadcaster) run(ttl time.Duration) {
 			g.callback <- result
 		case r, ok := <-b.reg:
 			if ok {
+				b.lastConsumerID++
+				r.consumer.config.id = b.lastConsumerID
 				b.outputs[r.consumer.channel] = r.consumer.config
-				for _, v := range b.state {
+				counters := &consumerCounters{}
+				b.counters[r.consumer.channel] = counters
+				for k, v := range b.state {
+					if !r.consumer.config.acceptsKey(k) {
+						continue
+					}
+					initial := &StateUpdate{InitialState, v.value}
 					select {
-					case r.consumer.channel <- &StateUpdate{InitialState, v.value}:
-					//sent
+					case r.consumer.channel <- initial:
+						//sent, the initial state has no delivery latency
+						counters.delivered++
+						if b.onDeliver != nil {
+							b.onDeliver(r.consumer.config.id, initial, 0)
+						}
 					default:
+						counters.dropped++
 						if r.consumer.config.onBackpressure != nil {
 							r.consumer.config.onBackpressure(v.value)
 						}
@@ -229,6 +284,8 @@ func (b *StateBroadcaster) run(ttl time.Duration) {
 		case u := <-b.unreg:
 			b.unregister(u.channel)
 			u.done <- struct{}{}
+		case s := <-b.stats:
+			s <- b.consumerStats()
 		case m := <-b.input:
 			key := m.key
 			var expiresAt time.Time
@@ -236,12 +293,12 @@ func (b *StateBroadcaster) run(ttl time.Duration) {
 				expiresAt = time.Now().Add(ttl)
 			}
 			b.state[key] = ttlValue{expiresAt: expiresAt, value: m.value}
-			b.broadcast(&StateUpdate{Update, m.value})
+			b.broadcast(key, &StateUpdate{Update, m.value}, m.submittedAt)
 		case u := <-b.update:
 			currentVal := b.state[u.key]
 			newVal := u.updateFunc(currentVal.value)
 			b.state[u.key] = ttlValue{expiresAt: currentVal.expiresAt, value: newVal}
-			b.broadcast(&StateUpdate{Update, newVal})
+			b.broadcast(u.key, &StateUpdate{Update, newVal}, u.submittedAt)
 		}
 	}
 }
@@ -256,6 +313,7 @@ func (b *StateBroadcaster) GetCurrentState() map[interface{}]interface{} {
 func (b *StateBroadcaster) unregister(c StateUpdateChan) {
 	if _, found := b.outputs[c]; found {
 		delete(b.outputs, c)
+		delete(b.counters, c)
 		close(c)
 	}
 }
@@ -270,6 +328,8 @@ func NewNonBlockingStateBroadcaster(bufLen int, ttl time.Duration, options ...Br
 		delete:            make(chan interface{}),
 		unreg:             make(chan stateUnregistration),
 		outputs:           make(map[StateUpdateChan]ConsumerConfig),
+		counters:          make(map[StateUpdateChan]*consumerCounters),
+		stats:             make(chan chan []ConsumerStats),
 		state:             make(map[interface{}]ttlValue),
 		update:            make(chan update, bufLen),
 		closed:            make(chan interface{}),
@@ -199,6 +199,29 @@ func TestDelete(t *testing.T) {
 
 }
 
+func TestKeyFilter(t *testing.T) {
+	b := NewNonBlockingStateBroadcaster(50, 0)
+
+	b.Submit("A", "A1")
+	b.Submit("B", "B1")
+	time.Sleep(50 * time.Millisecond)
+
+	ch := make(chan *StateUpdate, 20)
+	b.Register(ch, WithKeyFilter(func(key interface{}) bool {
+		return key == "A"
+	}))
+	result := consumeAvailableMessages(ch)
+	assert.Equal(t, []*StateUpdate{{InitialState, "A1"}}, result)
+
+	b.Submit("A", "A2")
+	b.Submit("B", "B2")
+	b.Delete("B")
+	b.Delete("A")
+	time.Sleep(50 * time.Millisecond)
+	result = consumeAvailableMessages(ch)
+	assert.Equal(t, []*StateUpdate{{Update, "A2"}, {Delete, "A"}}, result)
+}
+
 func TestStateCleared(t *testing.T) {
 	b := NewNonBlockingStateBroadcaster(50, 0)
 
@@ -239,3 +262,17 @@ func TestUnsubscribeAfterCloseOnStateBroadcaster(t *testing.T) {
 		t.Log("Unregistered successfully")
 	}
 }
+
+func TestStateConsumerStats(t *testing.T) {
+	b := NewNonBlockingStateBroadcaster(10, 0)
+	b.Submit("a", 1)
+	b.Submit("b", 2)
+	ch := make(chan *StateUpdate, 10)
+	// the registration is handled after the submissions, the consumer gets the state
+	time.Sleep(10 * time.Millisecond)
+	b.Register(ch, WithName("consumer"))
+
+	stats := b.Consumers()
+	assert.Equal(t, []ConsumerStats{{ID: 1, Name: "consumer", Delivered: 2, BufferLen: 2, BufferCap: 10}}, stats)
+	b.Close()
+}
@@ -0,0 +1,50 @@
+package mux
+
+import "sync"
+
+// ForwardTo submits all the values broadcasted by b to dst, until b or dst is closed or the returned function is called.
+// Values are forwarded through a subscriber channel of the size of the input buffer of b, the options apply to this subscriber.
+func (b *Broadcaster) ForwardTo(dst *Broadcaster, options ...ConsumerOptionFunc) (stop func()) {
+	return b.forward(dst, false, options...)
+}
+
+// Tee forwards all the values broadcasted by src to every destination.
+// Destinations are closed once src is closed, the returned function stops the forwarding without closing them.
+func Tee(src *Broadcaster, dsts ...*Broadcaster) (stop func()) {
+	stops := make([]func(), len(dsts))
+	for i, dst := range dsts {
+		stops[i] = src.forward(dst, true)
+	}
+	return func() {
+		for _, s := range stops {
+			s()
+		}
+	}
+}
+
+func (b *Broadcaster) forward(dst *Broadcaster, closeDst bool, options ...ConsumerOptionFunc) func() {
+	ch := make(chan interface{}, cap(b.input))
+	b.Register(ch, options...)
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			b.Unregister(ch)
+		})
+	}
+
+	go func() {
+		for v := range ch {
+			select {
+			case dst.input <- dst.submitted(v):
+			case <-dst.closed:
+				stop()
+				return
+			}
+		}
+		// the channel is closed either because b is closed or because the forwarding was stopped
+		if closeDst && b.Closed() {
+			dst.Close()
+		}
+	}()
+	return stop
+}
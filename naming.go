@@ -0,0 +1,102 @@
+package gorillaz
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidName is the error of a Nats subject or a stream name not following the naming policy, see WithSubjectPolicy
+var ErrInvalidName = errors.New("invalid name")
+
+// NamePolicy checks the names of the Nats subjects or of the streams, to keep the namespace shared by the services consistent.
+// A name is valid if it matches Pattern, when set, and one of the Allowed entries, when any. An entry ending with '*'
+// allows the names starting with the rest of the entry, the other entries allow the name itself.
+type NamePolicy struct {
+	Pattern *regexp.Regexp
+	Allowed []string
+}
+
+// ParseNamePolicy returns the policy of the names matching the regular expression and the allowlist, both optional.
+// The expression must match the whole name.
+func ParseNamePolicy(pattern string, allowed []string) (*NamePolicy, error) {
+	p := &NamePolicy{Allowed: allowed}
+	if pattern != "" {
+		r, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q: %w", pattern, err)
+		}
+		p.Pattern = r
+	}
+	return p, nil
+}
+
+// Check returns an error wrapping ErrInvalidName if the name does not follow the policy, a nil policy allows any name
+func (p *NamePolicy) Check(name string) error {
+	if p == nil {
+		return nil
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(name) {
+		return fmt.Errorf("%w: %q does not match %s", ErrInvalidName, name, p.Pattern)
+	}
+	if len(p.Allowed) == 0 {
+		return nil
+	}
+	for _, a := range p.Allowed {
+		if a == name || (strings.HasSuffix(a, "*") && strings.HasPrefix(name, strings.TrimSuffix(a, "*"))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q is not allowed", ErrInvalidName, name)
+}
+
+// WithSubjectPolicy checks the subjects given to NatsPublish, NatsPublishDelayed, NatsRequest and SubscribeNatsSubject
+// in the environment env, any environment if env is empty. The subjects are checked as given, before the env and tenant prefixes.
+// It overrides the policy of the configuration keys "naming.subject.pattern" and "naming.subject.allowed".
+func WithSubjectPolicy(env string, p *NamePolicy) Option {
+	return Option{func(g *Gaz) error {
+		if env == "" || env == g.Env {
+			g.subjectPolicy = p
+		}
+		return nil
+	}}
+}
+
+// WithStreamNamePolicy checks the names given to NewStreamProvider and NewGetAndWatchStreamProvider in the environment env,
+// any environment if env is empty. The names are checked as given, before the tenant prefix.
+// It overrides the policy of the configuration keys "naming.stream.pattern" and "naming.stream.allowed".
+func WithStreamNamePolicy(env string, p *NamePolicy) Option {
+	return Option{func(g *Gaz) error {
+		if env == "" || env == g.Env {
+			g.streamNamePolicy = p
+		}
+		return nil
+	}}
+}
+
+// configNamePolicy returns the policy of the configuration keys prefix.pattern and prefix.allowed, nil if none is set
+func (g *Gaz) configNamePolicy(prefix string) (*NamePolicy, error) {
+	pattern := g.Viper.GetString(prefix + ".pattern")
+	allowed := g.Viper.GetStringSlice(prefix + ".allowed")
+	if pattern == "" && len(allowed) == 0 {
+		return nil, nil
+	}
+	return ParseNamePolicy(pattern, allowed)
+}
+
+// checkSubject returns an error if the subject does not follow the subject policy
+func (g *Gaz) checkSubject(subject string) error {
+	if err := g.subjectPolicy.Check(subject); err != nil {
+		return fmt.Errorf("nats subject: %w", err)
+	}
+	return nil
+}
+
+// checkStreamName returns an error if the stream name does not follow the stream name policy
+func (g *Gaz) checkStreamName(streamName string) error {
+	if err := g.streamNamePolicy.Check(streamName); err != nil {
+		return fmt.Errorf("stream name: %w", err)
+	}
+	return nil
+}
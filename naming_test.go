@@ -0,0 +1,44 @@
+package gorillaz
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamePolicy(t *testing.T) {
+	p, err := ParseNamePolicy(`[a-z]+(\.[a-z]+)*`, []string{"flights.*", "weather"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, p.Check("flights.positions"))
+	assert.NoError(t, p.Check("weather"))
+	assert.True(t, errors.Is(p.Check("Flights.positions"), ErrInvalidName), "the pattern must match the whole name")
+	assert.True(t, errors.Is(p.Check("radar.tracks"), ErrInvalidName), "the name must be allowed")
+	assert.True(t, errors.Is(p.Check("weather.wind"), ErrInvalidName), "the entries without '*' allow the name only")
+
+	var none *NamePolicy
+	assert.NoError(t, none.Check("Any Name"))
+
+	_, err = ParseNamePolicy("[", nil)
+	assert.Error(t, err)
+}
+
+func TestStreamNamePolicy(t *testing.T) {
+	p, _ := ParseNamePolicy(`[a-z]+(-[a-z]+)*`, nil)
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery(), WithStreamNamePolicy("", p), WithSubjectPolicy("other-env", p))
+	defer g.Shutdown()
+	<-g.Run()
+
+	_, err := g.NewStreamProvider("Invalid_Name", "dummy.type")
+	assert.True(t, errors.Is(err, ErrInvalidName))
+	_, err = g.NewStreamProvider("naming-policy", "dummy.type")
+	assert.NoError(t, err)
+	assert.Panics(t, func() {
+		g.NewGetAndWatchStreamProvider("Invalid_Name", "dummy.type")
+	})
+
+	// the subject policy applies to another environment only
+	assert.NoError(t, g.checkSubject("Invalid_Subject"))
+}
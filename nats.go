@@ -17,7 +17,11 @@ import (
 // If the event is processed successfully, it must be acknowledge to get a new message. If the message is not acknowledge, then PullJetstream will return the same event multiple times
 // If no message is available after the ctx timeout, then an error nats.ErrTimeout is returned with an empty subject and an event nil
 func (g *Gaz) PullJetstream(ctx context.Context, stream string, consumer string) (subject string, event *stream.Event, err error) {
-	subj := "$JS.API.CONSUMER.MSG.NEXT." + g.AddStreamEnvIfMissing(stream) + "." + consumer
+	return g.pullJetstream(ctx, jsDefaultApiPrefix, g.AddStreamEnvIfMissing(stream), consumer)
+}
+
+func (g *Gaz) pullJetstream(ctx context.Context, apiPrefix string, stream string, consumer string) (subject string, event *stream.Event, err error) {
+	subj := apiPrefix + ".CONSUMER.MSG.NEXT." + stream + "." + consumer
 	msg, err := g.NatsConn.RequestWithContext(ctx, subj, nil)
 	if err != nil {
 		return "", nil, err
@@ -61,16 +65,26 @@ func BatchSize(s int) func(o *pullOptions) {
 	}
 }
 
+// AddStreamEnvIfMissing returns the Jetstream stream name prefixed by the env and the tenant, if any,
+// names already prefixed by the env are considered fully qualified and returned as is
 func (g *Gaz) AddStreamEnvIfMissing(streamName string) string {
-	if !strings.HasPrefix(streamName, g.Env) {
-		return g.Env + "-" + streamName
+	return addStreamScopeIfMissing(g.Env, g.Tenant, streamName)
+}
+
+func addStreamEnvIfMissing(env, streamName string) string {
+	if !strings.HasPrefix(streamName, env) {
+		return env + "-" + streamName
 	}
 	return streamName
 }
 
 func (g *Gaz) AddConsumerEnvIfMissing(consumerName string) string {
-	if !strings.HasPrefix(consumerName, g.Env) {
-		return g.Env + consumerName
+	return addConsumerEnvIfMissing(g.Env, consumerName)
+}
+
+func addConsumerEnvIfMissing(env, consumerName string) string {
+	if !strings.HasPrefix(consumerName, env) {
+		return env + consumerName
 	}
 	return consumerName
 }
@@ -78,6 +92,10 @@ func (g *Gaz) AddConsumerEnvIfMissing(consumerName string) string {
 // Pulls messages from a stream by batch, the batch size is configurable
 // A consumer with the given name must exists before calling this method.
 func (g *Gaz) PullJetstreamBatch(ctx context.Context, streamName string, consumer string, options ...PullOption) (<-chan *stream.Event, <-chan error) {
+	return g.pullJetstreamBatch(ctx, jsDefaultApiPrefix, g.AddStreamEnvIfMissing(streamName), g.AddConsumerEnvIfMissing(consumer), options...)
+}
+
+func (g *Gaz) pullJetstreamBatch(ctx context.Context, apiPrefix string, streamName string, consumer string, options ...PullOption) (<-chan *stream.Event, <-chan error) {
 	o := pullOptions{
 		batchSize:                 100,
 		closeOnEndOfStreamReached: false,
@@ -89,7 +107,7 @@ func (g *Gaz) PullJetstreamBatch(ctx context.Context, streamName string, consume
 	eventChan := make(chan *stream.Event, o.batchSize)
 	errChan := make(chan error, 1)
 
-	subj := "$JS.API.CONSUMER.MSG.NEXT." + g.AddStreamEnvIfMissing(streamName) + "." + g.AddConsumerEnvIfMissing(consumer)
+	subj := apiPrefix + ".CONSUMER.MSG.NEXT." + streamName + "." + consumer
 
 	req := JSApiConsumerGetNextRequest{
 		Batch: o.batchSize,
@@ -100,7 +118,7 @@ func (g *Gaz) PullJetstreamBatch(ctx context.Context, streamName string, consume
 		return eventChan, errChan
 	}
 
-	go func() {
+	g.goTracked("jetstream_pull", streamName, func() {
 		sub, err := g.NatsConn.SubscribeSync(nats.NewInbox())
 		if err != nil {
 			Log.Warn("subscribe failed", zap.Error(err))
@@ -163,12 +181,15 @@ func (g *Gaz) PullJetstreamBatch(ctx context.Context, streamName string, consume
 				received = 0
 			}
 		}
-	}()
+	})
 	return eventChan, errChan
 }
 
 // MsgHandler handles received events from Nats
 // If NatsConsumerOpts.AutoAck is set, if MsgHandler returns no error, the message will be acknowledged. If an error is returned, the event won't be acknowledged.
+// If the event carries a deadline, the event context is cancelled once the deadline is reached.
+// Events received after their deadline are rejected without calling the handler.
+// To signal that a request failed, the handler can reply with an event carrying an error, see stream.ErrorEvent.
 type MsgHandler func(subject string, event *stream.Event) (reply *stream.Event, err error)
 
 type NatsConsumerOpts struct {
@@ -201,10 +222,15 @@ func WithQueue(queue string) NatsConsumerOpt {
 
 // SubscribeNatsSubject subscribes to a Nats stream, and forward received messages to handler
 // An error is returned if the subscription fails, but not when the connection with Nats is interrupted
+// Events published in chunks by NatsPublish because they exceed the Nats max payload are reassembled before calling the handler
 func (g *Gaz) SubscribeNatsSubject(subject string, handler MsgHandler, opts ...NatsConsumerOpt) (*NatsSubscription, error) {
-	if g.addEnvPrefixToNats {
-		subject = g.Env + "." + subject
+	if err := g.checkSubject(subject); err != nil {
+		return nil, err
 	}
+	return g.subscribeNatsSubject(g.natsSubject(subject), handler, opts...)
+}
+
+func (g *Gaz) subscribeNatsSubject(subject string, handler MsgHandler, opts ...NatsConsumerOpt) (*NatsSubscription, error) {
 	c := &NatsConsumerOpts{
 		autoAck:        false,
 		tracingEnabled: false,
@@ -216,75 +242,114 @@ func (g *Gaz) SubscribeNatsSubject(subject string, handler MsgHandler, opts ...N
 		return nil, fmt.Errorf("gorillaz nats connection is nil, cannot consume stream")
 	}
 
-	do := func(m *nats.Msg) {
-		e := msgToEvent(m)
+	h := &natsMsgHandler{
+		g:       g,
+		subject: subject,
+		handler: handler,
+		opts:    c,
+		metrics: natsHandlerMonitoring(g, subject),
+	}
+	h.chunks = newChunkAssembler(subject, h.metrics, g.NatsConn.MaxPayload(), g.Clock())
 
-		// if there is no auto ack, then the user is responsible for calling event.Ack
-		if !c.autoAck && m.Reply != "" {
-			e.AckFunc = func() error {
-				return m.Respond(nil)
-			}
-		}
+	var err error
+	var sub *nats.Subscription
 
-		response, err := handler(m.Subject, e)
+	if c.queue == "" {
+		sub, err = g.NatsConn.Subscribe(subject, h.handle)
+	} else {
+		sub, err = g.NatsConn.QueueSubscribe(subject, c.queue, h.handle)
+	}
 
-		if err == nil {
-			if m.Reply != "" && c.autoAck {
-				Log.Debug("ack", zap.String("subject", subject), zap.String("reply", m.Reply))
-				if err := m.Respond(nil); err != nil {
-					// TODO: not great for consumer, he may receive the same event multiple times and really be aware
-					Log.Error("failed to ack event", zap.Error(err))
-				}
-				return
-			}
+	if err == nil {
+		return &NatsSubscription{n: sub}, nil
+	}
+	return nil, err
+}
+
+// natsMsgHandler calls the handler of a subscription with the events received, and replies with the events it returns
+type natsMsgHandler struct {
+	g       *Gaz
+	subject string
+	handler MsgHandler
+	opts    *NatsConsumerOpts
+	metrics *natsHandlerMetrics
+	chunks  *chunkAssembler
+}
+
+func (h *natsMsgHandler) handle(m *nats.Msg) {
+	if isChunk(m) {
+		m = h.chunks.add(m)
+		if m == nil {
+			// waiting for the other chunks
+			return
 		}
+	}
+	e := msgToEvent(m)
 
-		if response != nil && m.Reply != "" {
-			if response.Ctx == nil {
-				response.Ctx = context.Background()
-			}
-			stream.FillTracingSpan(response, e)
+	if deadline, ok := e.Deadline(); ok {
+		if deadline <= h.g.Clock().Now().UnixNano() {
+			// the caller already gave up, no need to process the event
+			Log.Debug("deadline passed, event rejected", zap.String("subject", h.subject))
+			h.metrics.expiredCounter.Inc()
+			return
+		}
+		var cancel context.CancelFunc
+		e.Ctx, cancel = e.CtxWithDeadline()
+		defer cancel()
+	}
 
-			metadata, err := stream.EventMetadata(response)
-			if err != nil {
-				Log.Error("failed to create metadata from event", zap.Error(err))
-			}
+	// if there is no auto ack, then the user is responsible for calling event.Ack
+	if !h.opts.autoAck && m.Reply != "" {
+		e.AckFunc = func() error {
+			return m.Respond(nil)
+		}
+	}
 
-			r := &stream.StreamEvent{Metadata: metadata, Key: response.Key, Value: response.Value}
-			b, err := proto.Marshal(r)
-			if err != nil {
-				Log.Error("failed to marshal response", zap.Error(err))
-				return
-			}
+	response, err := h.handler(m.Subject, e)
 
-			Log.Debug("reply", zap.String("subject", subject), zap.String("reply", m.Reply))
-			if err := m.Respond(b); err != nil {
+	if err == nil {
+		if m.Reply != "" && h.opts.autoAck {
+			Log.Debug("ack", zap.String("subject", h.subject), zap.String("reply", m.Reply))
+			if err := m.Respond(nil); err != nil {
+				// TODO: not great for consumer, he may receive the same event multiple times and really be aware
 				Log.Error("failed to ack event", zap.Error(err))
 			}
+			return
 		}
 	}
 
-	var err error
-	var sub *nats.Subscription
+	if response != nil && m.Reply != "" {
+		if response.Ctx == nil {
+			response.Ctx = context.Background()
+		}
+		stream.FillTracingSpan(response, e)
 
-	if c.queue == "" {
-		sub, err = g.NatsConn.Subscribe(subject, func(m *nats.Msg) {
-			do(m)
-		})
-	} else {
-		sub, err = g.NatsConn.QueueSubscribe(subject, c.queue, func(m *nats.Msg) {
-			do(m)
-		})
-	}
+		metadata, err := stream.EventMetadata(response)
+		if err != nil {
+			Log.Error("failed to create metadata from event", zap.Error(err))
+		}
 
-	if err == nil {
-		return &NatsSubscription{n: sub}, nil
+		r := &stream.StreamEvent{Metadata: metadata, Key: response.Key, Value: response.Value}
+		b, err := proto.Marshal(r)
+		if err != nil {
+			Log.Error("failed to marshal response", zap.Error(err))
+			return
+		}
+
+		Log.Debug("reply", zap.String("subject", h.subject), zap.String("reply", m.Reply))
+		if err := m.Respond(b); err != nil {
+			Log.Error("failed to ack event", zap.Error(err))
+		}
 	}
-	return nil, err
 }
 
+// natsMsgIdHeader is the header carrying the message id used by Jetstream to discard duplicates
+const natsMsgIdHeader = "Nats-Msg-Id"
+
 type NatsPublishOpts struct {
 	tracingEnabled bool
+	msgId          string
+	mirrors        []natsMirror
 }
 
 type NatsPublishOpt func(opts *NatsPublishOpts)
@@ -295,31 +360,137 @@ func WithNatsTracingEnabled() NatsPublishOpt {
 	}
 }
 
-func (g *Gaz) NatsPublish(subject string, e *stream.Event, opts ...NatsPublishOpt) error {
+// WithMsgId sets the message id used by Jetstream to discard duplicates published within the stream duplicate window
+func WithMsgId(id string) NatsPublishOpt {
+	return func(o *NatsPublishOpts) {
+		o.msgId = id
+	}
+}
+
+// natsSubject returns the subject prefixed by the env if configured, and by the tenant if any
+func (g *Gaz) natsSubject(subject string) string {
+	return g.tenantNatsSubject(g.Tenant, subject)
+}
+
+func (g *Gaz) tenantNatsSubject(tenant, subject string) string {
+	subject = addTenantToSubject(tenant, subject)
 	if g.addEnvPrefixToNats {
-		subject = g.Env + "." + subject
+		return g.Env + "." + subject
+	}
+	return subject
+}
+
+// addTenantToSubject returns the subject prefixed by the tenant, if any
+func addTenantToSubject(tenant, subject string) string {
+	if tenant != "" {
+		return tenant + "." + subject
 	}
+	return subject
+}
+
+func (g *Gaz) NatsPublish(subject string, e *stream.Event, opts ...NatsPublishOpt) error {
+	if err := g.checkSubject(subject); err != nil {
+		return err
+	}
+	err := g.natsPublish(g.natsSubject(subject), e, opts...)
+	g.publishMirrors(subject, e, opts)
+	return err
+}
+
+func (g *Gaz) natsPublish(subject string, e *stream.Event, opts ...NatsPublishOpt) error {
 	conf := &NatsPublishOpts{}
 
 	for _, opt := range opts {
 		opt(conf)
 	}
-	metadata, err := stream.EventMetadata(e)
+	b, header, err := natsPayload(e, conf.msgId)
 	if err != nil {
 		return err
 	}
+	if err := checkEventSize(len(b), g.natsMaxEventSize); err != nil {
+		oversizeEventsCounter(g, NatsOversizeEvents, NatsSubjectLabel, subject).Inc()
+		return err
+	}
+	return g.natsPublishPayload(subject, b, header)
+}
+
+// natsPublishPayload publishes the marshalled event, in chunks if it exceeds the Nats max payload
+func (g *Gaz) natsPublishPayload(subject string, b []byte, header map[string][]string) error {
+	if g.needsChunks(b) {
+		return g.publishChunks(subject, b, header)
+	}
+	return g.natsPublishMsg(&nats.Msg{Subject: subject, Data: b, Header: header})
+}
+
+// natsPayload marshals the event and returns the Nats headers to publish along with it
+func natsPayload(e *stream.Event, msgId string) ([]byte, map[string][]string, error) {
+	metadata, err := stream.EventMetadata(e)
+	if err != nil {
+		return nil, nil, err
+	}
 	evt := stream.StreamEvent{Key: e.Key, Value: e.Value, Metadata: metadata}
 	b, err := proto.Marshal(&evt)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	var header map[string][]string
+	if msgId != "" || len(e.Headers) > 0 {
+		header = make(map[string][]string, len(e.Headers)+1)
+	}
+	// the event headers are also Nats headers, so subscribers not using gorillaz can read them
+	for k, v := range e.Headers {
+		header[k] = []string{v}
+	}
+	if msgId != "" {
+		header[natsMsgIdHeader] = []string{msgId}
 	}
-	return g.NatsConn.Publish(subject, b)
+	return b, header, nil
 }
 
+// NatsRequest sends the event on the given subject and waits for the reply
+// The latency, errors and number of requests in flight are monitored per subject
 func (g *Gaz) NatsRequest(ctx context.Context, subject string, e *stream.Event, opts ...NatsPublishOpt) (*stream.Event, error) {
-	if g.addEnvPrefixToNats {
-		subject = g.Env + "." + subject
+	if err := g.checkSubject(subject); err != nil {
+		return nil, err
 	}
+	return g.monitoredNatsRequest(ctx, subject, g.natsSubject(subject), e, opts...)
+}
+
+// monitoredNatsRequest sends the request on fullSubject, the metrics are labelled with subject
+func (g *Gaz) monitoredNatsRequest(ctx context.Context, subject, fullSubject string, e *stream.Event, opts ...NatsPublishOpt) (*stream.Event, error) {
+	m := natsRequestMonitoring(g, subject)
+	cb := g.dependency(ProtocolNats, subject)
+	if !cb.Allow() {
+		m.errorCounter.WithLabelValues(natsErrorCode(ErrCircuitOpen)).Inc()
+		return nil, ErrCircuitOpen
+	}
+	m.inFlightGauge.Inc()
+	defer m.inFlightGauge.Dec()
+
+	ctx, cancel, defaultDeadline := withDefaultDeadline(ctx, g.natsRequestDefaultDeadline())
+	defer cancel()
+
+	start := time.Now()
+	reply, err := g.natsRequest(ctx, fullSubject, e, opts...)
+	m.latencySummary.Observe(float64(time.Since(start).Nanoseconds()) / 1000000.0)
+	if err != nil {
+		code := natsErrorCode(err)
+		m.errorCounter.WithLabelValues(code).Inc()
+		if defaultDeadline && code == "deadline_exceeded" {
+			m.defaultDeadlineCounter.Inc()
+		}
+		if code == "canceled" {
+			cb.Canceled()
+		} else {
+			cb.Failure()
+		}
+	} else {
+		cb.Success()
+	}
+	return reply, err
+}
+
+func (g *Gaz) natsRequest(ctx context.Context, subject string, e *stream.Event, opts ...NatsPublishOpt) (*stream.Event, error) {
 	conf := &NatsPublishOpts{}
 
 	for _, opt := range opts {
@@ -341,10 +512,26 @@ func (g *Gaz) NatsRequest(ctx context.Context, subject string, e *stream.Event,
 	return msgToEvent(msg), nil
 }
 
+// natsHeaders returns the first value of each Nats header, nil if there is none
+func natsHeaders(header map[string][]string) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(header))
+	for k, v := range header {
+		if len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	return headers
+}
+
 func msgToEvent(msg *nats.Msg) *stream.Event {
 	var evt stream.StreamEvent
 	value := msg.Data
 	var key []byte
+	var headers map[string]string
+	var eventErr *stream.EventError
 	ctx := context.Background()
 
 	// try to deserialize object
@@ -353,8 +540,16 @@ func msgToEvent(msg *nats.Msg) *stream.Event {
 		key = evt.Key
 		value = evt.Value
 		ctx = stream.Ctx(evt.Metadata)
+		headers = stream.MetadataHeaders(evt.Metadata)
+		eventErr = stream.MetadataError(evt.Metadata)
+	} else if len(msg.Header) > 0 {
+		// the message was not published by gorillaz, its Nats headers are the event headers
+		headers = natsHeaders(msg.Header)
+	}
+	e := &stream.Event{Ctx: ctx, Key: key, Value: value, Headers: headers, Error: eventErr, AckFunc: func() error { return nil }}
+	if id := msg.Header.Get(natsMsgIdHeader); id != "" {
+		e.SetMsgId(id)
 	}
-	e := &stream.Event{Ctx: ctx, Key: key, Value: value, AckFunc: func() error { return nil }}
 	meta, err := msg.JetStreamMetaData()
 	if err == nil && meta != nil {
 		e.SetPending(meta.Pending)
@@ -386,8 +581,15 @@ func (n *NatsSubscription) Queue() string {
 // if successful, g.NatsConn is set
 func (g *Gaz) mustInitNats(addr string) {
 	timeout := time.Duration(g.Viper.GetUint64("nats.connect_timeout_ms")) * time.Millisecond
+	opts := []nats.Option{nats.Timeout(timeout)}
+	if b := g.natsPublishBuffer; b != nil {
+		// gorillaz buffers the messages itself, so Publish fails fast while reconnecting
+		opts = append(opts, nats.ReconnectBufSize(-1), nats.ReconnectHandler(func(nc *nats.Conn) {
+			b.flush(nc)
+		}))
+	}
 	var err error
-	g.NatsConn, err = nats.Connect(addr, nats.Timeout(timeout))
+	g.NatsConn, err = nats.Connect(addr, opts...)
 	if err != nil {
 		Log.Panic("failed to initialize nats connection", zap.Error(err))
 	}
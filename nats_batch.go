@@ -0,0 +1,171 @@
+package gorillaz
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+// ErrBatchPublisherClosed is returned when publishing with a closed NatsBatchPublisher
+var ErrBatchPublisherClosed = errors.New("batch publisher closed")
+
+type NatsBatchConfig struct {
+	Linger       time.Duration                   // Linger is how long an event waits for other events of its subject before its batch is published (default: 5ms)
+	MaxBatchSize int                             // MaxBatchSize is the number of events of a subject above which the batch is published without waiting (default: 100)
+	FlushTimeout time.Duration                   // FlushTimeout is how long the flush of a batch waits for the acknowledgement of the Nats server (default: 1s)
+	OnError      func(subject string, err error) // OnError is called when a batch could not be published (default: log)
+}
+
+type NatsBatchOpt func(c *NatsBatchConfig)
+
+// NatsBatchLinger sets how long an event waits for other events of its subject before its batch is published
+func NatsBatchLinger(linger time.Duration) NatsBatchOpt {
+	return func(c *NatsBatchConfig) {
+		c.Linger = linger
+	}
+}
+
+// NatsBatchMaxSize sets the number of events of a subject above which the batch is published without waiting
+func NatsBatchMaxSize(size int) NatsBatchOpt {
+	return func(c *NatsBatchConfig) {
+		c.MaxBatchSize = size
+	}
+}
+
+// NatsBatchOnError sets the function called when a batch could not be published
+func NatsBatchOnError(onError func(subject string, err error)) NatsBatchOpt {
+	return func(c *NatsBatchConfig) {
+		c.OnError = onError
+	}
+}
+
+type natsBatchMsg struct {
+	data   []byte
+	header map[string][]string
+}
+
+type natsBatch struct {
+	msgs  []natsBatchMsg
+	timer *time.Timer
+}
+
+// NatsBatchPublisher coalesces the events published on each subject, and publishes them together once the linger
+// of the first event has elapsed or the batch is full. The events of a batch are published in a row with a single flush,
+// so the subscribers receive them as individual messages, in order.
+// It improves the throughput of chatty producers at the cost of the linger latency.
+type NatsBatchPublisher struct {
+	config  *NatsBatchConfig
+	mu      sync.Mutex
+	batches map[string]*natsBatch
+	closed  bool
+	subject func(string) string
+	publish func(subject string, b []byte, header map[string][]string) error
+	flush   func(timeout time.Duration) error
+}
+
+// NewNatsBatchPublisher returns a publisher batching the events by subject, it must be closed to publish the last batches
+func (g *Gaz) NewNatsBatchPublisher(opts ...NatsBatchOpt) *NatsBatchPublisher {
+	return newNatsBatchPublisher(g.natsSubject, g.natsPublishPayload, func(timeout time.Duration) error {
+		return g.NatsConn.FlushTimeout(timeout)
+	}, opts...)
+}
+
+func newNatsBatchPublisher(subject func(string) string, publish func(string, []byte, map[string][]string) error, flush func(time.Duration) error, opts ...NatsBatchOpt) *NatsBatchPublisher {
+	config := &NatsBatchConfig{
+		Linger:       5 * time.Millisecond,
+		MaxBatchSize: 100,
+		FlushTimeout: time.Second,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return &NatsBatchPublisher{
+		config:  config,
+		batches: make(map[string]*natsBatch),
+		subject: subject,
+		publish: publish,
+		flush:   flush,
+	}
+}
+
+// Publish queues the event in the batch of its subject, like NatsPublish the subject is prefixed by the env and the tenant.
+// The errors of the publication of the batch are reported to the OnError function.
+func (p *NatsBatchPublisher) Publish(subject string, e *stream.Event, opts ...NatsPublishOpt) error {
+	conf := &NatsPublishOpts{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	b, header, err := natsPayload(e, conf.msgId)
+	if err != nil {
+		return err
+	}
+	subject = p.subject(subject)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrBatchPublisherClosed
+	}
+	batch, ok := p.batches[subject]
+	if !ok {
+		batch = &natsBatch{}
+		batch.timer = time.AfterFunc(p.config.Linger, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.batches[subject] == batch {
+				p.publishBatch(subject, batch)
+			}
+		})
+		p.batches[subject] = batch
+	}
+	batch.msgs = append(batch.msgs, natsBatchMsg{data: b, header: header})
+	if len(batch.msgs) >= p.config.MaxBatchSize {
+		batch.timer.Stop()
+		p.publishBatch(subject, batch)
+	}
+	return nil
+}
+
+// Flush publishes all the pending batches without waiting for their linger
+func (p *NatsBatchPublisher) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for subject, batch := range p.batches {
+		batch.timer.Stop()
+		p.publishBatch(subject, batch)
+	}
+}
+
+// Close publishes the pending batches, the events published afterwards are rejected
+func (p *NatsBatchPublisher) Close() {
+	p.Flush()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+// publishBatch publishes the events of the batch in a row then flushes the connection, p.mu must be held
+func (p *NatsBatchPublisher) publishBatch(subject string, batch *natsBatch) {
+	delete(p.batches, subject)
+	for i, m := range batch.msgs {
+		if err := p.publish(subject, m.data, m.header); err != nil {
+			p.onError(subject, err)
+			Log.Debug("batch partially published", zap.String("subject", subject), zap.Int("published", i), zap.Int("size", len(batch.msgs)))
+			return
+		}
+	}
+	if err := p.flush(p.config.FlushTimeout); err != nil {
+		p.onError(subject, err)
+	}
+}
+
+func (p *NatsBatchPublisher) onError(subject string, err error) {
+	if p.config.OnError != nil {
+		p.config.OnError(subject, err)
+		return
+	}
+	Log.Warn("could not publish Nats batch", zap.String("subject", subject), zap.Error(err))
+}
@@ -0,0 +1,94 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBatchConn struct {
+	mu        sync.Mutex
+	published []string
+	flushes   int
+	err       error
+}
+
+func (c *fakeBatchConn) publish(subject string, b []byte, _ map[string][]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.published = append(c.published, subject)
+	return nil
+}
+
+func (c *fakeBatchConn) flush(time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushes++
+	return nil
+}
+
+func (c *fakeBatchConn) state() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.published), c.flushes
+}
+
+func newTestBatchPublisher(conn *fakeBatchConn, opts ...NatsBatchOpt) *NatsBatchPublisher {
+	return newNatsBatchPublisher(func(s string) string { return "dev." + s }, conn.publish, conn.flush, opts...)
+}
+
+func TestNatsBatchMaxSize(t *testing.T) {
+	conn := &fakeBatchConn{}
+	p := newTestBatchPublisher(conn, NatsBatchLinger(time.Hour), NatsBatchMaxSize(3))
+	e := &stream.Event{Ctx: context.Background(), Value: []byte("v")}
+
+	assert.Nil(t, p.Publish("positions", e))
+	assert.Nil(t, p.Publish("positions", e))
+	assert.Nil(t, p.Publish("flights", e))
+	published, flushes := conn.state()
+	assert.Equal(t, 0, published)
+
+	assert.Nil(t, p.Publish("positions", e))
+	published, flushes = conn.state()
+	assert.Equal(t, 3, published)
+	assert.Equal(t, 1, flushes)
+	assert.Equal(t, "dev.positions", conn.published[0])
+
+	p.Close()
+	published, flushes = conn.state()
+	assert.Equal(t, 4, published)
+	assert.Equal(t, 2, flushes)
+	assert.Equal(t, ErrBatchPublisherClosed, p.Publish("flights", e))
+}
+
+func TestNatsBatchLinger(t *testing.T) {
+	conn := &fakeBatchConn{}
+	p := newTestBatchPublisher(conn, NatsBatchLinger(10*time.Millisecond))
+	e := &stream.Event{Ctx: context.Background(), Value: []byte("v")}
+
+	assert.Nil(t, p.Publish("positions", e))
+	assert.Nil(t, p.Publish("positions", e))
+	time.Sleep(100 * time.Millisecond)
+	published, flushes := conn.state()
+	assert.Equal(t, 2, published)
+	assert.Equal(t, 1, flushes)
+}
+
+func TestNatsBatchError(t *testing.T) {
+	conn := &fakeBatchConn{err: errors.New("disconnected")}
+	var failed []string
+	p := newTestBatchPublisher(conn, NatsBatchOnError(func(subject string, err error) {
+		failed = append(failed, subject)
+	}))
+	assert.Nil(t, p.Publish("positions", &stream.Event{Ctx: context.Background()}))
+	p.Flush()
+	assert.Equal(t, []string{"dev.positions"}, failed)
+}
@@ -0,0 +1,131 @@
+package gorillaz
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	NatsPublishBuffered      = "nats_publish_buffered"
+	NatsPublishBufferDropped = "nats_publish_buffer_dropped"
+	NatsPublishBufferFlushed = "nats_publish_buffer_flushed"
+)
+
+// NatsOverflowPolicy defines what happens when a message is published while the publish buffer is full
+type NatsOverflowPolicy int
+
+const (
+	DropNewest       NatsOverflowPolicy = iota // the published message is dropped
+	DropOldest                                 // the oldest buffered message is dropped to make room for the published one
+	RejectOnOverflow                           // the published message is dropped and NatsPublish returns an error
+)
+
+// WithNatsPublishBuffer queues up to size messages published while the Nats connection is down,
+// they are published once the connection is re-established.
+func WithNatsPublishBuffer(size int, policy NatsOverflowPolicy) Option {
+	return Option{func(g *Gaz) error {
+		if size <= 0 {
+			return fmt.Errorf("nats publish buffer size must be positive, got %d", size)
+		}
+		g.natsPublishBuffer = newNatsPublishBuffer(g, size, policy)
+		return nil
+	}}
+}
+
+// natsMsgPublisher is the part of the Nats connection used by the publish buffer
+type natsMsgPublisher interface {
+	IsConnected() bool
+	PublishMsg(m *nats.Msg) error
+}
+
+type natsPublishBuffer struct {
+	mu             sync.Mutex
+	size           int
+	policy         NatsOverflowPolicy
+	msgs           []*nats.Msg
+	bufferedGauge  prometheus.Gauge
+	droppedCounter prometheus.Counter
+	flushedCounter prometheus.Counter
+}
+
+func newNatsPublishBuffer(g *Gaz, size int, policy NatsOverflowPolicy) *natsPublishBuffer {
+	b := &natsPublishBuffer{
+		size:   size,
+		policy: policy,
+		msgs:   make([]*nats.Msg, 0, size),
+		bufferedGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: NatsPublishBuffered,
+			Help: "The number of messages waiting for the Nats connection to be re-established",
+		}),
+		droppedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: NatsPublishBufferDropped,
+			Help: "The total number of messages dropped because the publish buffer was full",
+		}),
+		flushedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: NatsPublishBufferFlushed,
+			Help: "The total number of buffered messages published once the Nats connection was re-established",
+		}),
+	}
+	g.prometheusRegistry.MustRegister(b.bufferedGauge)
+	g.prometheusRegistry.MustRegister(b.droppedCounter)
+	g.prometheusRegistry.MustRegister(b.flushedCounter)
+	return b
+}
+
+// publish sends the message, or buffers it if the connection is down.
+// As long as messages are buffered, new messages are buffered too to keep the publication order
+func (b *natsPublishBuffer) publish(nc natsMsgPublisher, m *nats.Msg) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.msgs) == 0 && nc.IsConnected() {
+		err := nc.PublishMsg(m)
+		if err == nil || nc.IsConnected() {
+			return err
+		}
+	}
+	if len(b.msgs) >= b.size {
+		b.droppedCounter.Inc()
+		switch b.policy {
+		case DropOldest:
+			b.msgs = b.msgs[1:]
+		case RejectOnOverflow:
+			return fmt.Errorf("nats publish buffer is full, message on subject %s dropped", m.Subject)
+		default:
+			return nil
+		}
+	}
+	b.msgs = append(b.msgs, m)
+	b.bufferedGauge.Set(float64(len(b.msgs)))
+	return nil
+}
+
+// flush publishes the buffered messages, it stops at the first error
+func (b *natsPublishBuffer) flush(nc natsMsgPublisher) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.msgs) > 0 {
+		Log.Info("publishing buffered Nats messages", zap.Int("count", len(b.msgs)))
+	}
+	for len(b.msgs) > 0 {
+		if err := nc.PublishMsg(b.msgs[0]); err != nil {
+			Log.Warn("could not publish buffered Nats message", zap.String("subject", b.msgs[0].Subject), zap.Error(err))
+			break
+		}
+		b.msgs[0] = nil
+		b.msgs = b.msgs[1:]
+		b.flushedCounter.Inc()
+	}
+	b.bufferedGauge.Set(float64(len(b.msgs)))
+}
+
+// natsPublishMsg publishes the message through the publish buffer if configured
+func (g *Gaz) natsPublishMsg(m *nats.Msg) error {
+	if g.natsPublishBuffer == nil {
+		return g.NatsConn.PublishMsg(m)
+	}
+	return g.natsPublishBuffer.publish(g.NatsConn, m)
+}
@@ -0,0 +1,108 @@
+package gorillaz
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeNatsConn struct {
+	connected bool
+	published []string
+}
+
+func (c *fakeNatsConn) IsConnected() bool {
+	return c.connected
+}
+
+func (c *fakeNatsConn) PublishMsg(m *nats.Msg) error {
+	if !c.connected {
+		return errors.New("not connected")
+	}
+	c.published = append(c.published, m.Subject)
+	return nil
+}
+
+func newTestPublishBuffer(size int, policy NatsOverflowPolicy) *natsPublishBuffer {
+	return newNatsPublishBuffer(&Gaz{prometheusRegistry: prometheus.NewRegistry()}, size, policy)
+}
+
+// publishAll publishes the messages on the given subjects, it returns the errors of the rejected ones
+func publishAll(b *natsPublishBuffer, nc natsMsgPublisher, subjects ...string) []error {
+	var errs []error
+	for _, s := range subjects {
+		if err := b.publish(nc, &nats.Msg{Subject: s}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func bufferedSubjects(b *natsPublishBuffer) []string {
+	var subjects []string
+	for _, m := range b.msgs {
+		subjects = append(subjects, m.Subject)
+	}
+	return subjects
+}
+
+func TestNatsPublishBufferDropNewest(t *testing.T) {
+	b := newTestPublishBuffer(2, DropNewest)
+	nc := &fakeNatsConn{}
+
+	assert.Empty(t, publishAll(b, nc, "a", "b", "c"))
+	assert.Equal(t, []string{"a", "b"}, bufferedSubjects(b))
+	assert.Empty(t, nc.published)
+}
+
+func TestNatsPublishBufferDropOldest(t *testing.T) {
+	b := newTestPublishBuffer(2, DropOldest)
+	nc := &fakeNatsConn{}
+
+	assert.Empty(t, publishAll(b, nc, "a", "b", "c"))
+	assert.Equal(t, []string{"b", "c"}, bufferedSubjects(b))
+}
+
+func TestNatsPublishBufferRejectOnOverflow(t *testing.T) {
+	b := newTestPublishBuffer(2, RejectOnOverflow)
+	nc := &fakeNatsConn{}
+
+	errs := publishAll(b, nc, "a", "b", "c")
+	assert.Len(t, errs, 1, "the message published on the full buffer is rejected")
+	assert.Equal(t, []string{"a", "b"}, bufferedSubjects(b))
+}
+
+func TestNatsPublishBufferFlushOrder(t *testing.T) {
+	b := newTestPublishBuffer(10, DropNewest)
+	nc := &fakeNatsConn{connected: true}
+
+	assert.Empty(t, publishAll(b, nc, "a"))
+	assert.Equal(t, []string{"a"}, nc.published, "published directly while connected")
+
+	nc.connected = false
+	assert.Empty(t, publishAll(b, nc, "b", "c"))
+
+	// reconnected but not flushed yet: the new messages are queued behind the buffered ones
+	nc.connected = true
+	assert.Empty(t, publishAll(b, nc, "d"))
+	assert.Equal(t, []string{"a"}, nc.published)
+
+	b.flush(nc)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, nc.published)
+	assert.Empty(t, b.msgs)
+
+	assert.Empty(t, publishAll(b, nc, "e"))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, nc.published)
+}
+
+func TestNatsPublishBufferFlushStopsOnError(t *testing.T) {
+	b := newTestPublishBuffer(10, DropNewest)
+	nc := &fakeNatsConn{}
+	assert.Empty(t, publishAll(b, nc, "a", "b"))
+
+	b.flush(nc)
+	assert.Equal(t, []string{"a", "b"}, bufferedSubjects(b), "the messages are kept until they are published")
+}
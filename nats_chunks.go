@@ -0,0 +1,173 @@
+package gorillaz
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	chunkIdHdr    = "Gorillaz-Chunk-Id"
+	chunkIndexHdr = "Gorillaz-Chunk-Index"
+	chunkCountHdr = "Gorillaz-Chunk-Count"
+	// room left in each chunk for the headers
+	chunkHeaderReserve = 1024
+	// time after which an incomplete chunked event is dropped
+	chunkTimeout = 30 * time.Second
+	// maximum size of a chunked event reassembled, the chunks of larger events are dropped
+	maxChunkedEventSize = 64 << 20
+)
+
+// needsChunks returns true if the payload is too big to be sent in a single Nats message
+func (g *Gaz) needsChunks(payload []byte) bool {
+	return int64(len(payload)) > g.NatsConn.MaxPayload()
+}
+
+// chunkSize returns the size of the payload of a chunk, the Nats max payload less the room left for the headers
+func chunkSize(maxPayload int64) int {
+	return int(maxPayload) - chunkHeaderReserve
+}
+
+// publishChunks splits the payload in numbered chunks small enough to fit the Nats max payload
+// every chunk carries the id of the event and the total number of chunks so it can be reassembled on the consumer side.
+// The Jetstream message id of the event is suffixed by the index of each chunk, so that the chunks are not discarded as duplicates.
+func (g *Gaz) publishChunks(subject string, payload []byte, header map[string][]string) error {
+	size := chunkSize(g.NatsConn.MaxPayload())
+	count := (len(payload) + size - 1) / size
+	if count > maxChunkedEventSize/size+1 {
+		return fmt.Errorf("event of %d bytes too large to be published in chunks", len(payload))
+	}
+	id := strings.TrimPrefix(nats.NewInbox(), nats.InboxPrefix)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		if err := g.natsPublishMsg(&nats.Msg{Subject: subject, Data: payload[i*size : end], Header: chunkHeader(header, id, i, count)}); err != nil {
+			return err
+		}
+	}
+	natsChunkedPublishedCounter(g, subject).Inc()
+	Log.Debug("event published in chunks", zap.String("subject", subject), zap.Int("chunks", count))
+	return nil
+}
+
+// chunkHeader returns the header of a chunk: the header of the event, with its message id suffixed by the index of the chunk,
+// and the chunk headers, written last so that the header of the event can't overwrite them
+func chunkHeader(header map[string][]string, id string, index, count int) map[string][]string {
+	h := make(map[string][]string, len(header)+3)
+	for k, v := range header {
+		h[k] = v
+	}
+	if msgId := header[natsMsgIdHeader]; len(msgId) > 0 {
+		h[natsMsgIdHeader] = []string{chunkMsgId(msgId[0], index)}
+	}
+	h[chunkIdHdr] = []string{id}
+	h[chunkIndexHdr] = []string{strconv.Itoa(index)}
+	h[chunkCountHdr] = []string{strconv.Itoa(count)}
+	return h
+}
+
+// chunkMsgId returns the Jetstream message id of a chunk of the event with the message id
+func chunkMsgId(msgId string, index int) string {
+	return msgId + "#" + strconv.Itoa(index)
+}
+
+type pendingChunks struct {
+	createdAt time.Time
+	received  int
+	chunks    [][]byte
+}
+
+// chunkAssembler reassembles the chunked events received on a subscription.
+// Nats calls the handler of a subscription sequentially, so it doesn't need to be synchronized
+type chunkAssembler struct {
+	subject   string
+	metrics   *natsHandlerMetrics
+	maxChunks int // maxChunks is the maximum number of chunks of an event, beyond it exceeds maxChunkedEventSize
+	pending   map[string]*pendingChunks
+	clock     Clock
+}
+
+func newChunkAssembler(subject string, metrics *natsHandlerMetrics, maxPayload int64, clock Clock) *chunkAssembler {
+	return &chunkAssembler{
+		subject:   subject,
+		metrics:   metrics,
+		maxChunks: maxChunkedEventSize/chunkSize(maxPayload) + 1,
+		pending:   make(map[string]*pendingChunks),
+		clock:     clock,
+	}
+}
+
+func isChunk(m *nats.Msg) bool {
+	return m.Header.Get(chunkIdHdr) != ""
+}
+
+// add stores the chunk, and returns the reassembled message once all chunks have been received, otherwise nil
+func (a *chunkAssembler) add(m *nats.Msg) *nats.Msg {
+	a.dropExpired()
+
+	id := m.Header.Get(chunkIdHdr)
+	index, err := strconv.Atoi(m.Header.Get(chunkIndexHdr))
+	if err != nil {
+		Log.Warn("invalid chunk index", zap.String("subject", a.subject), zap.String("id", id), zap.Error(err))
+		return nil
+	}
+	count, err := strconv.Atoi(m.Header.Get(chunkCountHdr))
+	if err != nil || index < 0 || index >= count || count > a.maxChunks {
+		Log.Warn("invalid chunk count", zap.String("subject", a.subject), zap.String("id", id), zap.Int("index", index), zap.Int("count", count), zap.Error(err))
+		return nil
+	}
+	p, ok := a.pending[id]
+	if !ok {
+		p = &pendingChunks{createdAt: a.clock.Now(), chunks: make([][]byte, count)}
+		a.pending[id] = p
+	} else if count != len(p.chunks) {
+		Log.Warn("chunk count differing from the other chunks of the event", zap.String("subject", a.subject), zap.String("id", id), zap.Int("count", count), zap.Int("expected", len(p.chunks)))
+		return nil
+	}
+	if p.chunks[index] == nil {
+		p.chunks[index] = m.Data
+		p.received++
+	}
+	if p.received < len(p.chunks) {
+		return nil
+	}
+	delete(a.pending, id)
+	size := 0
+	for _, c := range p.chunks {
+		size += len(c)
+	}
+	data := make([]byte, 0, size)
+	for _, c := range p.chunks {
+		data = append(data, c...)
+	}
+	a.metrics.chunkedCounter.Inc()
+	// the last chunk received carries the reply subject and the headers of the event
+	header := make(map[string][]string, len(m.Header))
+	for k, v := range m.Header {
+		header[k] = v
+	}
+	delete(header, chunkIdHdr)
+	delete(header, chunkIndexHdr)
+	delete(header, chunkCountHdr)
+	if msgId := header[natsMsgIdHeader]; len(msgId) > 0 {
+		header[natsMsgIdHeader] = []string{strings.TrimSuffix(msgId[0], chunkMsgId("", index))}
+	}
+	return &nats.Msg{Subject: m.Subject, Reply: m.Reply, Header: header, Data: data, Sub: m.Sub}
+}
+
+func (a *chunkAssembler) dropExpired() {
+	now := a.clock.Now()
+	for id, p := range a.pending {
+		if now.Sub(p.createdAt) > chunkTimeout {
+			Log.Warn("incomplete chunked event dropped", zap.String("subject", a.subject), zap.String("id", id), zap.Int("received", p.received), zap.Int("count", len(p.chunks)))
+			a.metrics.chunkedExpiredCounter.Inc()
+			delete(a.pending, id)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package gorillaz
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func chunk(id string, index, count int, data string) *nats.Msg {
+	return &nats.Msg{Subject: "subject", Data: []byte(data), Header: map[string][]string{
+		chunkIdHdr:    {id},
+		chunkIndexHdr: {strconv.Itoa(index)},
+		chunkCountHdr: {strconv.Itoa(count)},
+	}}
+}
+
+func TestChunkAssembler(t *testing.T) {
+	metrics := &natsHandlerMetrics{
+		chunkedCounter:        prometheus.NewCounter(prometheus.CounterOpts{Name: "chunked"}),
+		chunkedExpiredCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "expired"}),
+	}
+	a := newChunkAssembler("subject", metrics, 1024*1024, SystemClock)
+
+	assert.True(t, isChunk(chunk("a", 0, 3, "hel")))
+	assert.True(t, !isChunk(&nats.Msg{Subject: "subject"}))
+
+	// chunks can be received out of order and interleaved with other events
+	assert.True(t, a.add(chunk("a", 2, 3, "rld")) == nil)
+	assert.True(t, a.add(chunk("b", 0, 2, "foo")) == nil)
+	assert.True(t, a.add(chunk("a", 0, 3, "hello ")) == nil)
+	// duplicates are ignored
+	assert.True(t, a.add(chunk("a", 0, 3, "hello ")) == nil)
+
+	m := a.add(chunk("a", 1, 3, "wo"))
+	assert.NotNil(t, m)
+	assert.Equal(t, "hello world", string(m.Data))
+	assert.Equal(t, 1, len(a.pending))
+
+	m = a.add(chunk("b", 1, 2, "bar"))
+	assert.NotNil(t, m)
+	assert.Equal(t, "foobar", string(m.Data))
+	assert.Equal(t, 0, len(a.pending))
+}
+
+func TestChunkHeader(t *testing.T) {
+	header := map[string][]string{
+		natsMsgIdHeader: {"order-42"},
+		chunkCountHdr:   {"1"},
+		"Custom":        {"value"},
+	}
+	first := chunkHeader(header, "id", 0, 2)
+	second := chunkHeader(header, "id", 1, 2)
+	assert.Equal(t, []string{"order-42#0"}, first[natsMsgIdHeader], "the chunks are not discarded as duplicates by Jetstream")
+	assert.Equal(t, []string{"order-42#1"}, second[natsMsgIdHeader])
+	assert.Equal(t, []string{"2"}, second[chunkCountHdr], "the headers of the event do not overwrite the chunk headers")
+	assert.Equal(t, []string{"value"}, second["Custom"])
+	assert.Equal(t, []string{"order-42"}, header[natsMsgIdHeader], "the header of the event is not modified")
+
+	metrics := &natsHandlerMetrics{
+		chunkedCounter:        prometheus.NewCounter(prometheus.CounterOpts{Name: "chunked"}),
+		chunkedExpiredCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "expired"}),
+	}
+	a := newChunkAssembler("subject", metrics, 1024*1024, SystemClock)
+	assert.True(t, a.add(&nats.Msg{Subject: "subject", Data: []byte("hello "), Header: first}) == nil)
+	m := a.add(&nats.Msg{Subject: "subject", Data: []byte("world"), Header: second})
+	if assert.NotNil(t, m) {
+		assert.Equal(t, "hello world", string(m.Data))
+		assert.Equal(t, []string{"order-42"}, m.Header[natsMsgIdHeader], "the message id of the event is restored")
+		assert.False(t, isChunk(m))
+		assert.Equal(t, []string{"value"}, m.Header["Custom"])
+	}
+}
+
+func TestChunkAssemblerInvalidCount(t *testing.T) {
+	metrics := &natsHandlerMetrics{
+		chunkedCounter:        prometheus.NewCounter(prometheus.CounterOpts{Name: "chunked"}),
+		chunkedExpiredCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "expired"}),
+	}
+	a := newChunkAssembler("subject", metrics, 1024*1024, SystemClock)
+
+	// a count beyond the maximum size of an event is not allocated
+	assert.True(t, a.add(chunk("huge", 0, 1<<30, "x")) == nil)
+	assert.Empty(t, a.pending)
+
+	// a chunk whose count differs from the first chunk of the event is dropped, instead of indexing out of range
+	assert.True(t, a.add(chunk("a", 0, 2, "hello ")) == nil)
+	assert.True(t, a.add(chunk("a", 4, 5, "!")) == nil)
+	assert.Len(t, a.pending["a"].chunks, 2)
+	m := a.add(chunk("a", 1, 2, "world"))
+	if assert.NotNil(t, m) {
+		assert.Equal(t, "hello world", string(m.Data))
+	}
+}
+
+func TestChunkAssemblerExpiry(t *testing.T) {
+	metrics := &natsHandlerMetrics{
+		chunkedCounter:        prometheus.NewCounter(prometheus.CounterOpts{Name: "chunked"}),
+		chunkedExpiredCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "expired"}),
+	}
+	clock := NewManualClock(time.Date(2020, time.November, 13, 10, 0, 0, 0, time.UTC))
+	a := newChunkAssembler("subject", metrics, 1024*1024, clock)
+
+	assert.True(t, a.add(chunk("a", 0, 2, "hello ")) == nil)
+	clock.Advance(chunkTimeout / 2)
+	assert.True(t, a.add(chunk("b", 0, 2, "foo")) == nil)
+	assert.Len(t, a.pending, 2)
+
+	// the incomplete events are dropped once the timeout elapsed
+	clock.Advance(chunkTimeout/2 + time.Second)
+	assert.True(t, a.add(chunk("a", 1, 2, "world")) == nil)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.chunkedExpiredCounter))
+	assert.Len(t, a.pending, 2, "the late chunk starts a new event")
+	m := a.add(chunk("b", 1, 2, "bar"))
+	if assert.NotNil(t, m) {
+		assert.Equal(t, "foobar", string(m.Data))
+	}
+}
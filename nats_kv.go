@@ -0,0 +1,236 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/skysoft-atm/gorillaz/mux"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+const kvOperationHeader = "KV-Operation"
+const kvExpectedLastSubjSeqHeader = "Nats-Expected-Last-Subject-Sequence"
+
+// ErrKeyExists is returned when creating a key that already exists in a key value bucket
+var ErrKeyExists = errors.New("key already exists")
+
+type KeyValueWatchOpts struct {
+	provider *GetAndWatchStreamProvider
+}
+
+type KeyValueWatchOpt func(o *KeyValueWatchOpts)
+
+// WithKeyValueProvider also forwards the watched puts and deletes to the given GetAndWatch provider
+func WithKeyValueProvider(p *GetAndWatchStreamProvider) KeyValueWatchOpt {
+	return func(o *KeyValueWatchOpts) {
+		o.provider = p
+	}
+}
+
+// kvBucketName returns the name of the bucket prefixed by the env
+func (g *Gaz) kvBucketName(bucket string) string {
+	return g.AddStreamEnvIfMissing(bucket)
+}
+
+func kvStreamName(bucketName string) string {
+	return "KV_" + bucketName
+}
+
+func kvSubject(bucketName, key string) string {
+	return "$KV." + bucketName + "." + key
+}
+
+// ProvisionKeyValue creates the Jetstream key value bucket keeping the last value of every key, or updates it if it already exists.
+// The bucket name is prefixed by the env if missing, JetstreamMaxAge configures the time to live of the values.
+func (g *Gaz) ProvisionKeyValue(ctx context.Context, bucket string, opts ...JetstreamConfigOpt) error {
+	config := defaultJetstreamConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+	bucketName := g.kvBucketName(bucket)
+	return g.provisionStream(ctx, jsStreamConfig{
+		Name:           kvStreamName(bucketName),
+		Subjects:       []string{kvSubject(bucketName, ">")},
+		Retention:      "limits",
+		MaxConsumers:   -1,
+		MaxMsgs:        -1,
+		MaxBytes:       -1,
+		MaxMsgsPerSubj: 1,
+		Discard:        "new",
+		MaxAge:         config.MaxAge,
+		Storage:        config.Storage,
+		Replicas:       config.Replicas,
+	})
+}
+
+// errWrongRevision is returned when updating a key whose revision is not the expected one
+var errWrongRevision = errors.New("wrong revision")
+
+// kvEntry is the last message stored for a key in a key value bucket
+type kvEntry struct {
+	Value     []byte
+	Revision  uint64
+	Operation string // Operation is "DEL" or "PURGE" if the key was deleted, empty otherwise
+}
+
+type jsMsgGetRequest struct {
+	LastBySubject string `json:"last_by_subj"`
+}
+
+type jsMsgGetResponse struct {
+	jsApiResponse
+	Message struct {
+		Sequence uint64 `json:"seq"`
+		Header   []byte `json:"hdrs,omitempty"`
+		Data     []byte `json:"data,omitempty"`
+	} `json:"message"`
+}
+
+// kvCreate puts the value of the key only if the key does not exist yet, ErrKeyExists is returned otherwise.
+// It returns the revision of the key.
+func (g *Gaz) kvCreate(ctx context.Context, bucket, key string, value []byte) (uint64, error) {
+	rev, err := g.kvUpdate(ctx, bucket, key, value, 0)
+	if err == errWrongRevision {
+		return 0, ErrKeyExists
+	}
+	return rev, err
+}
+
+// kvUpdate puts the value of the key only if its current revision is the given one, errWrongRevision is returned otherwise.
+// It returns the new revision of the key.
+func (g *Gaz) kvUpdate(ctx context.Context, bucket, key string, value []byte, revision uint64) (uint64, error) {
+	return g.kvPublish(ctx, bucket, key, value, map[string][]string{
+		kvExpectedLastSubjSeqHeader: {strconv.FormatUint(revision, 10)},
+	})
+}
+
+// kvPut puts the value of the key whatever its current revision, it returns the new revision of the key
+func (g *Gaz) kvPut(ctx context.Context, bucket, key string, value []byte) (uint64, error) {
+	return g.kvPublish(ctx, bucket, key, value, nil)
+}
+
+// kvDelete deletes the key only if its current revision is the given one, errWrongRevision is returned otherwise
+func (g *Gaz) kvDelete(ctx context.Context, bucket, key string, revision uint64) error {
+	_, err := g.kvPublish(ctx, bucket, key, nil, map[string][]string{
+		kvOperationHeader:           {"DEL"},
+		kvExpectedLastSubjSeqHeader: {strconv.FormatUint(revision, 10)},
+	})
+	return err
+}
+
+func (g *Gaz) kvPublish(ctx context.Context, bucket, key string, value []byte, header map[string][]string) (uint64, error) {
+	rev, err := g.jsPublish(ctx, kvSubject(g.kvBucketName(bucket), key), value, header)
+	if apiErr, ok := err.(*JetstreamApiError); ok && apiErr.Code == 400 && strings.Contains(apiErr.Description, "wrong last sequence") {
+		return 0, errWrongRevision
+	}
+	return rev, err
+}
+
+// kvGet returns the last entry of the key, nil if the key was never set or has expired
+func (g *Gaz) kvGet(ctx context.Context, bucket, key string) (*kvEntry, error) {
+	bucketName := g.kvBucketName(bucket)
+	var resp jsMsgGetResponse
+	err := g.jsApiRequest(ctx, jsDefaultApiPrefix+".STREAM.MSG.GET."+kvStreamName(bucketName), jsMsgGetRequest{LastBySubject: kvSubject(bucketName, key)}, &resp)
+	if apiErr, ok := err.(*JetstreamApiError); ok && apiErr.Code == 404 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kvEntry{
+		Value:     resp.Message.Data,
+		Revision:  resp.Message.Sequence,
+		Operation: kvHeaderOperation(resp.Message.Header),
+	}, nil
+}
+
+// kvHeaderOperation returns the KV-Operation of a raw Nats header block ("NATS/1.0\r\nKey: Value\r\n...")
+func kvHeaderOperation(header []byte) string {
+	for _, line := range strings.Split(string(header), "\r\n") {
+		if i := strings.Index(line, ":"); i > 0 && strings.EqualFold(line[:i], kvOperationHeader) {
+			return strings.TrimSpace(line[i+1:])
+		}
+	}
+	return ""
+}
+
+// WatchKeyValue watches the Jetstream key value bucket and feeds the state broadcaster with its content.
+// The latest value of every key is submitted first, then every put is submitted as a *stream.Event with the string key,
+// deleted and purged keys are deleted from the state broadcaster.
+// The state broadcaster can be nil if the values are only forwarded to a provider, see WithKeyValueProvider.
+// The watch stops when ctx is done.
+func (g *Gaz) WatchKeyValue(ctx context.Context, bucket string, sb *mux.StateBroadcaster, opts ...KeyValueWatchOpt) error {
+	o := KeyValueWatchOpts{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if g.NatsConn == nil {
+		return fmt.Errorf("gorillaz nats connection is nil, cannot watch bucket %s", bucket)
+	}
+	bucketName := g.kvBucketName(bucket)
+	streamName := kvStreamName(bucketName)
+	prefix := kvSubject(bucketName, "")
+
+	sub, err := g.NatsConn.Subscribe(nats.NewInbox(), kvWatchHandler(bucketName, sb, o.provider))
+	if err != nil {
+		return err
+	}
+
+	req := jsCreateConsumerRequest{
+		Stream: streamName,
+		Config: jsConsumerConfig{
+			DeliverSubject: sub.Subject,
+			DeliverPolicy:  "last_per_subject",
+			AckPolicy:      "none",
+			FilterSubject:  prefix + ">",
+		},
+	}
+	err = g.jsApiRequest(ctx, jsDefaultApiPrefix+".CONSUMER.CREATE."+streamName, req, nil)
+	if err != nil {
+		if err := sub.Unsubscribe(); err != nil {
+			Log.Warn("Could not unsubscribe", zap.Error(err))
+		}
+		return fmt.Errorf("could not watch bucket %s: %w", bucketName, err)
+	}
+
+	g.goTracked("kv_watch", bucketName, func() {
+		<-ctx.Done()
+		// the ephemeral consumer is deleted by the server once there is no more interest on its deliver subject
+		if err := sub.Unsubscribe(); err != nil {
+			Log.Warn("Could not unsubscribe", zap.Error(err))
+		}
+	})
+	return nil
+}
+
+// kvWatchHandler applies the puts, deletes and purges of the bucket to the state broadcaster and the provider, if not nil.
+// The values are stored verbatim in the bucket, they are submitted as is with the headers of their message.
+func kvWatchHandler(bucketName string, sb *mux.StateBroadcaster, provider *GetAndWatchStreamProvider) nats.MsgHandler {
+	prefix := kvSubject(bucketName, "")
+	return func(m *nats.Msg) {
+		key := strings.TrimPrefix(m.Subject, prefix)
+		switch m.Header.Get(kvOperationHeader) {
+		case "DEL", "PURGE":
+			Log.Debug("key deleted", zap.String("bucket", bucketName), zap.String("key", key))
+			if sb != nil {
+				sb.Delete(key)
+			}
+			if provider != nil {
+				provider.Delete([]byte(key))
+			}
+		default:
+			e := &stream.Event{Ctx: context.Background(), Key: []byte(key), Value: m.Data, Headers: natsHeaders(m.Header), AckFunc: func() error { return nil }}
+			if sb != nil {
+				sb.Submit(key, e)
+			}
+			if provider != nil {
+				provider.Submit(e)
+			}
+		}
+	}
+}
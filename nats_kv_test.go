@@ -0,0 +1,50 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/skysoft-atm/gorillaz/mux"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestKvHeaderOperation(t *testing.T) {
+	assert.Equal(t, "DEL", kvHeaderOperation([]byte("NATS/1.0\r\nKV-Operation: DEL\r\n\r\n")))
+	assert.Equal(t, "PURGE", kvHeaderOperation([]byte("NATS/1.0\r\nNats-Msg-Id: 1\r\nkv-operation:PURGE\r\n\r\n")))
+	assert.Equal(t, "", kvHeaderOperation([]byte("NATS/1.0\r\nNats-Msg-Id: 1\r\n\r\n")))
+	assert.Equal(t, "", kvHeaderOperation(nil))
+}
+
+func TestKvWatchHandler(t *testing.T) {
+	sb := mux.NewNonBlockingStateBroadcaster(10, 0)
+	defer sb.Close()
+	handle := kvWatchHandler("dev-settings", sb, nil)
+
+	// the values are taken verbatim, even if they could be decoded as a gorillaz event
+	value, err := proto.Marshal(&stream.StreamEvent{Key: []byte("other"), Value: []byte("wrapped")})
+	assert.NoError(t, err)
+	handle(&nats.Msg{Subject: kvSubject("dev-settings", "color"), Data: []byte("blue"), Header: map[string][]string{"Origin": {"ui"}}})
+	handle(&nats.Msg{Subject: kvSubject("dev-settings", "proto"), Data: value})
+	handle(&nats.Msg{Subject: kvSubject("dev-settings", "size"), Data: []byte("42")})
+	waitUntil(t, time.Second, "the puts are submitted", func() bool {
+		return len(sb.GetCurrentState()) == 3
+	})
+	state := sb.GetCurrentState()
+	color := state["color"].(*stream.Event)
+	assert.Equal(t, "color", string(color.Key))
+	assert.Equal(t, "blue", string(color.Value))
+	assert.Equal(t, map[string]string{"Origin": "ui"}, color.Headers)
+	assert.Equal(t, value, state["proto"].(*stream.Event).Value)
+	assert.Equal(t, "proto", string(state["proto"].(*stream.Event).Key))
+
+	handle(&nats.Msg{Subject: kvSubject("dev-settings", "color"), Header: map[string][]string{kvOperationHeader: {"DEL"}}})
+	handle(&nats.Msg{Subject: kvSubject("dev-settings", "size"), Header: map[string][]string{kvOperationHeader: {"PURGE"}}})
+	waitUntil(t, time.Second, "the deleted and purged keys are deleted", func() bool {
+		return len(sb.GetCurrentState()) == 1
+	})
+	_, ok := sb.GetCurrentState()["proto"]
+	assert.True(t, ok)
+}
@@ -0,0 +1,174 @@
+package gorillaz
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	NatsRequestLatencyMs               = "nats_request_latency_ms"
+	NatsRequestErrors                  = "nats_request_errors"
+	NatsRequestInFlight                = "nats_request_in_flight"
+	NatsRequestDefaultDeadlineExceeded = "nats_request_default_deadline_exceeded"
+	NatsExpiredRequests                = "nats_expired_requests"
+	NatsChunkedPublished               = "nats_chunked_events_published"
+	NatsChunkedReceived                = "nats_chunked_events_received"
+	NatsChunkedExpired                 = "nats_chunked_events_expired"
+)
+
+const NatsSubjectLabel = "subject"
+const NatsErrorCodeLabel = "code"
+
+type natsRequestMetrics struct {
+	latencySummary         prometheus.Summary
+	errorCounter           *prometheus.CounterVec
+	inFlightGauge          prometheus.Gauge
+	defaultDeadlineCounter prometheus.Counter
+}
+
+// map of metrics registered to Prometheus, by subject
+// it's here because we cannot register twice to Prometheus the metrics with the same label
+var natsRequestMetricsMu sync.Mutex
+var natsRequestMonitorings = make(map[string]*natsRequestMetrics)
+
+func natsRequestMonitoring(g *Gaz, subject string) *natsRequestMetrics {
+	natsRequestMetricsMu.Lock()
+	defer natsRequestMetricsMu.Unlock()
+
+	if m, ok := natsRequestMonitorings[subject]; ok {
+		return m
+	}
+
+	m := &natsRequestMetrics{
+		latencySummary: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name:       NatsRequestLatencyMs,
+			Help:       "distribution of delay between when a request is sent and when its reply is received, in milliseconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			ConstLabels: prometheus.Labels{
+				NatsSubjectLabel: subject,
+			},
+		}),
+
+		errorCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: NatsRequestErrors,
+			Help: "The total number of failed requests, by error code",
+			ConstLabels: prometheus.Labels{
+				NatsSubjectLabel: subject,
+			},
+		}, []string{NatsErrorCodeLabel}),
+
+		inFlightGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: NatsRequestInFlight,
+			Help: "The number of requests waiting for a reply",
+			ConstLabels: prometheus.Labels{
+				NatsSubjectLabel: subject,
+			},
+		}),
+
+		defaultDeadlineCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: NatsRequestDefaultDeadlineExceeded,
+			Help: "The total number of requests sent without deadline that exceeded the default deadline",
+			ConstLabels: prometheus.Labels{
+				NatsSubjectLabel: subject,
+			},
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.latencySummary)
+	g.prometheusRegistry.MustRegister(m.errorCounter)
+	g.prometheusRegistry.MustRegister(m.inFlightGauge)
+	g.prometheusRegistry.MustRegister(m.defaultDeadlineCounter)
+	natsRequestMonitorings[subject] = m
+	return m
+}
+
+type natsHandlerMetrics struct {
+	expiredCounter        prometheus.Counter
+	chunkedCounter        prometheus.Counter
+	chunkedExpiredCounter prometheus.Counter
+}
+
+var natsHandlerMetricsMu sync.Mutex
+var natsHandlerMonitorings = make(map[string]*natsHandlerMetrics)
+
+func natsHandlerMonitoring(g *Gaz, subject string) *natsHandlerMetrics {
+	natsHandlerMetricsMu.Lock()
+	defer natsHandlerMetricsMu.Unlock()
+
+	if m, ok := natsHandlerMonitorings[subject]; ok {
+		return m
+	}
+
+	m := &natsHandlerMetrics{
+		expiredCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: NatsExpiredRequests,
+			Help: "The total number of received requests rejected because their deadline already passed",
+			ConstLabels: prometheus.Labels{
+				NatsSubjectLabel: subject,
+			},
+		}),
+
+		chunkedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: NatsChunkedReceived,
+			Help: "The total number of events received in chunks and reassembled",
+			ConstLabels: prometheus.Labels{
+				NatsSubjectLabel: subject,
+			},
+		}),
+
+		chunkedExpiredCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: NatsChunkedExpired,
+			Help: "The total number of events dropped because all their chunks were not received in time",
+			ConstLabels: prometheus.Labels{
+				NatsSubjectLabel: subject,
+			},
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.expiredCounter)
+	g.prometheusRegistry.MustRegister(m.chunkedCounter)
+	g.prometheusRegistry.MustRegister(m.chunkedExpiredCounter)
+	natsHandlerMonitorings[subject] = m
+	return m
+}
+
+var natsChunkedPublishedMu sync.Mutex
+var natsChunkedPublishedCounters = make(map[string]prometheus.Counter)
+
+func natsChunkedPublishedCounter(g *Gaz, subject string) prometheus.Counter {
+	natsChunkedPublishedMu.Lock()
+	defer natsChunkedPublishedMu.Unlock()
+
+	if c, ok := natsChunkedPublishedCounters[subject]; ok {
+		return c
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: NatsChunkedPublished,
+		Help: "The total number of events published in chunks because they exceed the Nats max payload",
+		ConstLabels: prometheus.Labels{
+			NatsSubjectLabel: subject,
+		},
+	})
+	g.prometheusRegistry.MustRegister(c)
+	natsChunkedPublishedCounters[subject] = c
+	return c
+}
+
+// natsErrorCode maps an error returned by a Nats request to a low cardinality code usable as a metric label
+func natsErrorCode(err error) string {
+	switch {
+	case err == nats.ErrTimeout:
+		return "timeout"
+	case err == context.DeadlineExceeded:
+		return "deadline_exceeded"
+	case err == context.Canceled:
+		return "canceled"
+	case err == nats.ErrConnectionClosed, err == nats.ErrInvalidConnection:
+		return "connection_closed"
+	case err == ErrCircuitOpen:
+		return "circuit_open"
+	default:
+		return "other"
+	}
+}
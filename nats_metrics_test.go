@@ -0,0 +1,18 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNatsErrorCode(t *testing.T) {
+	assert.Equal(t, "timeout", natsErrorCode(nats.ErrTimeout))
+	assert.Equal(t, "deadline_exceeded", natsErrorCode(context.DeadlineExceeded))
+	assert.Equal(t, "canceled", natsErrorCode(context.Canceled))
+	assert.Equal(t, "connection_closed", natsErrorCode(nats.ErrConnectionClosed))
+	assert.Equal(t, "other", natsErrorCode(errors.New("boom")))
+}
@@ -0,0 +1,95 @@
+package gorillaz
+
+import (
+	"context"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+// NatsRemote addresses the streams and subjects of another Jetstream domain or of another Nats account.
+// Streams and subjects are prefixed with the env of the remote, following the same rules as the local ones.
+type NatsRemote struct {
+	g            *Gaz
+	apiPrefix    string
+	env          string
+	addEnvPrefix bool
+}
+
+type NatsRemoteOpt func(r *NatsRemote)
+
+// WithRemoteEnv sets the env of the remote, used to prefix its streams and subjects (default: gorillaz env)
+func WithRemoteEnv(env string) NatsRemoteOpt {
+	return func(r *NatsRemote) {
+		r.env = env
+	}
+}
+
+// WithoutRemoteEnvPrefix disables the env prefix on the subjects of the remote
+func WithoutRemoteEnvPrefix() NatsRemoteOpt {
+	return func(r *NatsRemote) {
+		r.addEnvPrefix = false
+	}
+}
+
+// NatsDomain returns a NatsRemote addressing the streams of the given Jetstream domain
+func (g *Gaz) NatsDomain(domain string, opts ...NatsRemoteOpt) *NatsRemote {
+	return g.newNatsRemote("$JS."+domain+".API", opts...)
+}
+
+// NatsAccount returns a NatsRemote addressing the streams of another account,
+// apiPrefix is the subject prefix under which the Jetstream API of the other account is imported
+func (g *Gaz) NatsAccount(apiPrefix string, opts ...NatsRemoteOpt) *NatsRemote {
+	return g.newNatsRemote(apiPrefix, opts...)
+}
+
+func (g *Gaz) newNatsRemote(apiPrefix string, opts ...NatsRemoteOpt) *NatsRemote {
+	r := &NatsRemote{
+		g:            g,
+		apiPrefix:    apiPrefix,
+		env:          g.Env,
+		addEnvPrefix: g.addEnvPrefixToNats,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// StreamName returns the name of the stream in the remote, prefixed by the remote env if missing
+func (r *NatsRemote) StreamName(streamName string) string {
+	return addStreamEnvIfMissing(r.env, streamName)
+}
+
+// Subject returns the subject in the remote, prefixed by the remote env if configured
+func (r *NatsRemote) Subject(subject string) string {
+	if r.addEnvPrefix {
+		return r.env + "." + subject
+	}
+	return subject
+}
+
+// PullJetstream returns the next subject and event for the given stream and consumer of the remote, see Gaz.PullJetstream
+func (r *NatsRemote) PullJetstream(ctx context.Context, streamName string, consumer string) (subject string, event *stream.Event, err error) {
+	return r.g.pullJetstream(ctx, r.apiPrefix, r.StreamName(streamName), consumer)
+}
+
+// PullJetstreamBatch pulls messages from a stream of the remote by batch, see Gaz.PullJetstreamBatch
+func (r *NatsRemote) PullJetstreamBatch(ctx context.Context, streamName string, consumer string, options ...PullOption) (<-chan *stream.Event, <-chan error) {
+	return r.g.pullJetstreamBatch(ctx, r.apiPrefix, r.StreamName(streamName), addConsumerEnvIfMissing(r.env, consumer), options...)
+}
+
+// NatsPublish publishes the event on a subject of the remote
+func (r *NatsRemote) NatsPublish(subject string, e *stream.Event, opts ...NatsPublishOpt) error {
+	return r.g.natsPublish(r.Subject(subject), e, opts...)
+}
+
+// NatsRequest sends a request on a subject of the remote and waits for the reply
+func (r *NatsRemote) NatsRequest(ctx context.Context, subject string, e *stream.Event, opts ...NatsPublishOpt) (*stream.Event, error) {
+	full := r.Subject(subject)
+	return r.g.monitoredNatsRequest(ctx, full, full, e, opts...)
+}
+
+// SubscribeNatsSubject subscribes to a subject of the remote
+func (r *NatsRemote) SubscribeNatsSubject(subject string, handler MsgHandler, opts ...NatsConsumerOpt) (*NatsSubscription, error) {
+	return r.g.subscribeNatsSubject(r.Subject(subject), handler, opts...)
+}
@@ -0,0 +1,22 @@
+package gorillaz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNatsRemoteNames(t *testing.T) {
+	g := &Gaz{Env: "dev", addEnvPrefixToNats: true}
+
+	r := g.NatsDomain("hub")
+	assert.Equal(t, "$JS.hub.API", r.apiPrefix)
+	assert.Equal(t, "dev-orders", r.StreamName("orders"))
+	assert.Equal(t, "dev.orders.created", r.Subject("orders.created"))
+
+	r = g.NatsAccount("JS.billing.API", WithRemoteEnv("prod"), WithoutRemoteEnvPrefix())
+	assert.Equal(t, "JS.billing.API", r.apiPrefix)
+	assert.Equal(t, "prod-orders", r.StreamName("orders"))
+	assert.Equal(t, "prod-orders", r.StreamName("prod-orders"))
+	assert.Equal(t, "orders.created", r.Subject("orders.created"))
+}
@@ -0,0 +1,170 @@
+package gorillaz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	schedulerStream         = "gorillaz-scheduler"
+	schedulerConsumer       = "gorillaz-scheduler"
+	schedulerSubject        = "gorillaz.scheduled"
+	schedulerPublishAtHdr   = "Gorillaz-Publish-At"
+	schedulerTargetHdr      = "Gorillaz-Target-Subject"
+	schedulerAckWait        = 30 * time.Second
+	schedulerMaxHoldingTime = 10 * time.Minute
+)
+
+// NatsPublishDelayed publishes the event on the subject once the delay has elapsed.
+// The event is stored in a Jetstream stream until it is due, it is published by the Nats scheduler, see RunNatsScheduler.
+func (g *Gaz) NatsPublishDelayed(subject string, e *stream.Event, delay time.Duration, opts ...NatsPublishOpt) error {
+	if err := g.checkSubject(subject); err != nil {
+		return err
+	}
+	conf := &NatsPublishOpts{}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	metadata, err := stream.EventMetadata(e)
+	if err != nil {
+		return err
+	}
+	evt := stream.StreamEvent{Key: e.Key, Value: e.Value, Metadata: metadata}
+	b, err := proto.Marshal(&evt)
+	if err != nil {
+		return err
+	}
+	header := scheduledHeader(g.natsSubject(subject), g.Clock().Now().Add(delay), conf.msgId)
+	return g.NatsConn.PublishMsg(&nats.Msg{Subject: g.natsSubject(schedulerSubject), Data: b, Header: header})
+}
+
+// scheduledHeader is the header of an event stored in the scheduler stream until it is published on target at publishAt
+func scheduledHeader(target string, publishAt time.Time, msgId string) map[string][]string {
+	header := map[string][]string{
+		schedulerPublishAtHdr: {strconv.FormatInt(publishAt.UnixNano(), 10)},
+		schedulerTargetHdr:    {target},
+	}
+	if msgId != "" {
+		header[natsMsgIdHeader] = []string{msgId}
+	}
+	return header
+}
+
+// RunNatsScheduler provisions the Jetstream stream holding the delayed events and publishes them when they are due.
+// Several instances can run the scheduler concurrently, each delayed event is published by only one of them.
+// It blocks until ctx is done.
+func (g *Gaz) RunNatsScheduler(ctx context.Context) error {
+	err := g.ProvisionJetstream(ctx, schedulerStream, []string{schedulerSubject})
+	if err != nil {
+		return err
+	}
+	streamName := g.AddStreamEnvIfMissing(schedulerStream)
+	req := jsCreateConsumerRequest{
+		Stream: streamName,
+		Config: jsConsumerConfig{
+			Durable:       schedulerConsumer,
+			DeliverPolicy: "all",
+			AckPolicy:     "explicit",
+			AckWait:       schedulerAckWait,
+		},
+	}
+	err = g.jsApiRequest(ctx, jsDefaultApiPrefix+".CONSUMER.DURABLE.CREATE."+streamName+"."+schedulerConsumer, req, nil)
+	if err != nil {
+		return fmt.Errorf("could not create scheduler consumer: %w", err)
+	}
+
+	Log.Info("Nats scheduler started", zap.String("stream", streamName))
+	next := jsDefaultApiPrefix + ".CONSUMER.MSG.NEXT." + streamName + "." + schedulerConsumer
+	for ctx.Err() == nil {
+		pullCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		msg, err := g.NatsConn.RequestWithContext(pullCtx, next, nil)
+		cancel()
+		if err != nil {
+			if err != context.DeadlineExceeded && err != nats.ErrTimeout && ctx.Err() == nil {
+				Log.Warn("could not pull scheduled event", zap.Error(err))
+				select {
+				case <-ctx.Done():
+				case <-g.Clock().After(time.Second):
+				}
+			}
+			continue
+		}
+		g.handleScheduledMsg(msg)
+	}
+	Log.Info("Nats scheduler stopped", zap.String("stream", streamName))
+	return nil
+}
+
+func (g *Gaz) handleScheduledMsg(msg *nats.Msg) {
+	handleScheduled(msg.Header, msg.Data, g.Clock().Now(), g.NatsConn.Publish, func() error { return msg.Ack() }, func(delay time.Duration) error {
+		nak, _ := json.Marshal(struct {
+			Delay time.Duration `json:"delay"`
+		}{delay})
+		return msg.Respond(append([]byte("-NAK "), nak...))
+	})
+}
+
+// scheduledDelay returns the target subject of a scheduled event and how long it must still be held, 0 when it is due.
+// The delay is capped to schedulerMaxHoldingTime, the event is redelivered by Jetstream and held again if needed.
+func scheduledDelay(header map[string][]string, now time.Time) (string, time.Duration, error) {
+	target := headerValue(header, schedulerTargetHdr)
+	if target == "" {
+		return "", 0, fmt.Errorf("no %s header", schedulerTargetHdr)
+	}
+	publishAt, err := strconv.ParseInt(headerValue(header, schedulerPublishAtHdr), 10, 64)
+	if err != nil {
+		return target, 0, fmt.Errorf("invalid %s header: %w", schedulerPublishAtHdr, err)
+	}
+	remaining := time.Unix(0, publishAt).Sub(now)
+	switch {
+	case remaining < 0:
+		remaining = 0
+	case remaining > schedulerMaxHoldingTime:
+		remaining = schedulerMaxHoldingTime
+	}
+	return target, remaining, nil
+}
+
+// handleScheduled publishes a scheduled event on its target and acks it when it is due, otherwise it naks it
+// so that Jetstream redelivers it after the remaining delay
+func handleScheduled(header map[string][]string, data []byte, now time.Time, publish func(string, []byte) error, ack func() error, nak func(time.Duration) error) {
+	target, delay, err := scheduledDelay(header, now)
+	if err != nil {
+		Log.Error("invalid scheduled event, dropping it", zap.String("target", target), zap.Error(err))
+		if err := ack(); err != nil {
+			Log.Warn("could not ack scheduled event", zap.Error(err))
+		}
+		return
+	}
+
+	if delay > 0 {
+		if err := nak(delay); err != nil {
+			Log.Warn("could not nak scheduled event", zap.Error(err))
+		}
+		return
+	}
+
+	if err := publish(target, data); err != nil {
+		// not acknowledged, it will be redelivered after the ack wait
+		Log.Warn("could not publish scheduled event", zap.String("target", target), zap.Error(err))
+		return
+	}
+	if err := ack(); err != nil {
+		Log.Warn("could not ack scheduled event", zap.String("target", target), zap.Error(err))
+	}
+}
+
+func headerValue(header map[string][]string, key string) string {
+	if v := header[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
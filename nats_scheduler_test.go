@@ -0,0 +1,96 @@
+package gorillaz
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledHeader(t *testing.T) {
+	publishAt := time.Date(2020, time.November, 13, 10, 0, 0, 0, time.UTC)
+	header := scheduledHeader("dev.orders", publishAt, "order-42")
+	assert.Equal(t, []string{strconv.FormatInt(publishAt.UnixNano(), 10)}, header[schedulerPublishAtHdr])
+	assert.Equal(t, []string{"dev.orders"}, header[schedulerTargetHdr])
+	assert.Equal(t, []string{"order-42"}, header[natsMsgIdHeader])
+
+	header = scheduledHeader("dev.orders", publishAt, "")
+	_, ok := header[natsMsgIdHeader]
+	assert.False(t, ok, "no message id header without message id")
+}
+
+type scheduledMsgRecorder struct {
+	published map[string]string
+	acks      int
+	naks      []time.Duration
+}
+
+func (r *scheduledMsgRecorder) handle(header map[string][]string, now time.Time) {
+	handleScheduled(header, []byte("data"), now, func(subject string, data []byte) error {
+		if r.published == nil {
+			r.published = make(map[string]string)
+		}
+		r.published[subject] = string(data)
+		return nil
+	}, func() error {
+		r.acks++
+		return nil
+	}, func(delay time.Duration) error {
+		r.naks = append(r.naks, delay)
+		return nil
+	})
+}
+
+func TestHandleScheduled(t *testing.T) {
+	now := time.Date(2020, time.November, 13, 10, 0, 0, 0, time.UTC)
+
+	// due: published on its target and acknowledged
+	r := &scheduledMsgRecorder{}
+	r.handle(scheduledHeader("dev.orders", now, ""), now)
+	assert.Equal(t, map[string]string{"dev.orders": "data"}, r.published)
+	assert.Equal(t, 1, r.acks)
+	assert.Len(t, r.naks, 0)
+
+	// late: published as well
+	r = &scheduledMsgRecorder{}
+	r.handle(scheduledHeader("dev.orders", now.Add(-time.Minute), ""), now)
+	assert.Equal(t, map[string]string{"dev.orders": "data"}, r.published)
+	assert.Equal(t, 1, r.acks)
+
+	// not due: naked with the remaining delay, nothing published
+	r = &scheduledMsgRecorder{}
+	r.handle(scheduledHeader("dev.orders", now.Add(time.Minute), ""), now)
+	assert.Len(t, r.published, 0)
+	assert.Equal(t, 0, r.acks)
+	assert.Equal(t, []time.Duration{time.Minute}, r.naks)
+
+	// the delay is capped so that the event is not held longer than the maximum holding time
+	r = &scheduledMsgRecorder{}
+	r.handle(scheduledHeader("dev.orders", now.Add(time.Hour), ""), now)
+	assert.Equal(t, []time.Duration{schedulerMaxHoldingTime}, r.naks)
+
+	// invalid: dropped
+	r = &scheduledMsgRecorder{}
+	r.handle(map[string][]string{schedulerPublishAtHdr: {"not a time"}, schedulerTargetHdr: {"dev.orders"}}, now)
+	r.handle(map[string][]string{schedulerPublishAtHdr: {strconv.FormatInt(now.UnixNano(), 10)}}, now)
+	assert.Len(t, r.published, 0)
+	assert.Equal(t, 2, r.acks)
+	assert.Len(t, r.naks, 0)
+}
+
+func TestHandleScheduledPublishError(t *testing.T) {
+	now := time.Date(2020, time.November, 13, 10, 0, 0, 0, time.UTC)
+	acks := 0
+	handleScheduled(scheduledHeader("dev.orders", now, ""), []byte("data"), now, func(string, []byte) error {
+		return errors.New("not connected")
+	}, func() error {
+		acks++
+		return nil
+	}, func(time.Duration) error {
+		t.Fatal("unexpected nak")
+		return nil
+	})
+	assert.Equal(t, 0, acks, "not acknowledged, redelivered after the ack wait")
+}
@@ -0,0 +1,165 @@
+package gorillaz
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	StreamConsumerOrderingViolations = "stream_consumer_ordering_violations"
+)
+
+const OrderingViolationLabel = "violation"
+
+// kinds of ordering violations
+const (
+	StreamSeqViolation = "stream_seq" // the stream sequence of an event is not greater than the one of the previous event
+	EventTimeViolation = "event_time" // the event time of an event is before the one of the previous event with the same key
+)
+
+const defaultOrderingCheckMaxKeys = 100000
+
+// OrderingViolation describes an event received out of order
+type OrderingViolation struct {
+	Stream   string
+	Kind     string // Kind is StreamSeqViolation or EventTimeViolation
+	Key      []byte
+	Previous int64 // Previous is the stream sequence or the event timestamp of the previous event
+	Current  int64 // Current is the stream sequence or the event timestamp of the event out of order
+}
+
+type OrderingCheckConfig struct {
+	MaxKeys     int                     // MaxKeys is the number of keys whose event time is followed, the keys are forgotten beyond (default: 100000)
+	OnViolation func(OrderingViolation) // OnViolation is called for each event out of order (default: log)
+}
+
+// WithOrderingCheck verifies that the events are received in order: the stream sequences, when the events have one,
+// are increasing, and the event times of each key never go backwards.
+// The violations are logged and counted, it is meant to validate providers and broker configurations before incidents happen.
+func WithOrderingCheck(c OrderingCheckConfig) ConsumerConfigOpt {
+	return func(config *ConsumerConfig) {
+		config.OrderingCheck = &c
+	}
+}
+
+type orderingMetrics struct {
+	seqCounter       prometheus.Counter
+	eventTimeCounter prometheus.Counter
+}
+
+// map of metrics registered to Prometheus, by stream
+var orderingMetricsMu sync.Mutex
+var orderingMonitorings = make(map[string]*orderingMetrics)
+
+func orderingMonitoring(g *Gaz, streamName string) *orderingMetrics {
+	orderingMetricsMu.Lock()
+	defer orderingMetricsMu.Unlock()
+
+	if m, ok := orderingMonitorings[streamName]; ok {
+		return m
+	}
+	counter := func(kind string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamConsumerOrderingViolations,
+			Help:        "The total number of events received out of order",
+			ConstLabels: prometheus.Labels{StreamNameLabel: streamName, OrderingViolationLabel: kind},
+		})
+	}
+	m := &orderingMetrics{
+		seqCounter:       counter(StreamSeqViolation),
+		eventTimeCounter: counter(EventTimeViolation),
+	}
+	g.prometheusRegistry.MustRegister(m.seqCounter)
+	g.prometheusRegistry.MustRegister(m.eventTimeCounter)
+	orderingMonitorings[streamName] = m
+	return m
+}
+
+// OrderingChecker verifies the ordering of the events of a stream, a nil checker checks nothing.
+// The stream consumers check their events with WithOrderingCheck, OrderingChecker is for the other sources of events
+// such as Nats or Jetstream subscriptions.
+type OrderingChecker struct {
+	mu         sync.Mutex
+	streamName string
+	config     OrderingCheckConfig
+	metrics    *orderingMetrics
+	lastSeq    int64
+	eventTimes map[string]int64
+}
+
+// NewOrderingChecker returns a checker of the ordering of the events of the stream
+func (g *Gaz) NewOrderingChecker(streamName string, c OrderingCheckConfig) *OrderingChecker {
+	if c.MaxKeys <= 0 {
+		c.MaxKeys = defaultOrderingCheckMaxKeys
+	}
+	return &OrderingChecker{
+		streamName: streamName,
+		config:     c,
+		metrics:    orderingMonitoring(g, streamName),
+		eventTimes: make(map[string]int64),
+	}
+}
+
+func newConsumerOrderingChecker(g *Gaz, streamName string, c *OrderingCheckConfig) *OrderingChecker {
+	if c == nil {
+		return nil
+	}
+	return g.NewOrderingChecker(streamName, *c)
+}
+
+// Check verifies that the event is in order with the events previously checked
+func (o *OrderingChecker) Check(e *stream.Event) {
+	if o == nil {
+		return
+	}
+	o.check(e.Key, int64(e.StreamSeq()), stream.EventTimestamp(e))
+}
+
+// checkMetadata verifies the event received with the metadata, which has no stream sequence
+func (o *OrderingChecker) checkMetadata(key []byte, metadata *stream.Metadata) {
+	if o == nil || metadata == nil {
+		return
+	}
+	o.check(key, 0, metadata.EventTimestamp)
+}
+
+func (o *OrderingChecker) check(key []byte, seq int64, eventTime int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if seq > 0 {
+		if seq <= o.lastSeq {
+			o.violation(OrderingViolation{Stream: o.streamName, Kind: StreamSeqViolation, Key: key, Previous: o.lastSeq, Current: seq})
+		}
+		o.lastSeq = seq
+	}
+	if eventTime > 0 {
+		k := string(key)
+		previous, ok := o.eventTimes[k]
+		if ok && eventTime < previous {
+			o.violation(OrderingViolation{Stream: o.streamName, Kind: EventTimeViolation, Key: key, Previous: previous, Current: eventTime})
+			return
+		}
+		if !ok && len(o.eventTimes) >= o.config.MaxKeys {
+			// the keys are forgotten all at once, the first event of each key is never out of order
+			o.eventTimes = make(map[string]int64)
+		}
+		o.eventTimes[k] = eventTime
+	}
+}
+
+func (o *OrderingChecker) violation(v OrderingViolation) {
+	if v.Kind == StreamSeqViolation {
+		o.metrics.seqCounter.Inc()
+	} else {
+		o.metrics.eventTimeCounter.Inc()
+	}
+	if o.config.OnViolation != nil {
+		o.config.OnViolation(v)
+		return
+	}
+	Log.Warn("event received out of order", zap.String("stream", v.Stream), zap.String("violation", v.Kind), RedactedKey(v.Key),
+		zap.Int64("previous", v.Previous), zap.Int64("current", v.Current))
+}
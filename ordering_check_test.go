@@ -0,0 +1,67 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func orderedEvent(key string, seq int, eventTime int64) *stream.Event {
+	e := &stream.Event{Ctx: context.Background(), Key: []byte(key)}
+	if seq > 0 {
+		e.SetStreamSeq(seq)
+	}
+	e.SetEventTime(time.Unix(0, eventTime))
+	return e
+}
+
+func TestOrderingChecker(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	var violations []OrderingViolation
+	o := g.NewOrderingChecker("orders", OrderingCheckConfig{OnViolation: func(v OrderingViolation) {
+		violations = append(violations, v)
+	}})
+
+	o.Check(orderedEvent("a", 1, 10))
+	o.Check(orderedEvent("b", 2, 5))
+	o.Check(orderedEvent("a", 3, 10))
+	assert.Empty(t, violations)
+
+	o.Check(orderedEvent("a", 3, 20))
+	o.Check(orderedEvent("b", 4, 4))
+	if assert.Len(t, violations, 2) {
+		assert.Equal(t, OrderingViolation{Stream: "orders", Kind: StreamSeqViolation, Key: []byte("a"), Previous: 3, Current: 3}, violations[0])
+		assert.Equal(t, OrderingViolation{Stream: "orders", Kind: EventTimeViolation, Key: []byte("b"), Previous: 5, Current: 4}, violations[1])
+	}
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "orders", OrderingViolationLabel: StreamSeqViolation}, StreamConsumerOrderingViolations, 1)
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "orders", OrderingViolationLabel: EventTimeViolation}, StreamConsumerOrderingViolations, 1)
+}
+
+func TestOrderingCheckerMaxKeys(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	violations := 0
+	o := g.NewOrderingChecker("positions", OrderingCheckConfig{MaxKeys: 2, OnViolation: func(v OrderingViolation) {
+		violations++
+	}})
+
+	o.Check(orderedEvent("a", 0, 10))
+	o.Check(orderedEvent("b", 0, 10))
+	o.Check(orderedEvent("c", 0, 10))
+	assert.Len(t, o.eventTimes, 1)
+	o.Check(orderedEvent("a", 0, 5))
+	assert.Equal(t, 0, violations, "the event time of a was forgotten")
+
+	o.checkMetadata([]byte("c"), &stream.Metadata{EventTimestamp: 5})
+	assert.Equal(t, 1, violations)
+}
+
+func TestNilOrderingChecker(t *testing.T) {
+	var o *OrderingChecker
+	o.Check(orderedEvent("a", 1, 10))
+	o.checkMetadata([]byte("a"), &stream.Metadata{})
+	assert.Nil(t, newConsumerOrderingChecker(nil, "stream", nil))
+}
@@ -0,0 +1,61 @@
+package gorillaz
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	StreamLostOriginEvents = "stream_lost_origin_events"
+)
+
+// DerivedEvents declares that the events submitted to the provider are derived from consumed events,
+// so they must carry the origin stream timestamp of the events they are derived from, see stream.Event.Derive and stream.PropagateOrigin.
+// The events submitted without origin are counted and logged, since their origin delay only measures the last hop of the pipeline.
+func DerivedEvents() ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.DerivedEvents = true
+	}
+}
+
+// GetAndWatchDerivedEvents declares that the events submitted to the provider are derived from consumed events, see DerivedEvents
+func GetAndWatchDerivedEvents() GetAndWatchConfigOpt {
+	return func(p *GetAndWatchConfig) {
+		p.DerivedEvents = true
+	}
+}
+
+// map of counters registered to Prometheus, by stream
+var lostOriginMu sync.Mutex
+var lostOriginCounters = make(map[string]prometheus.Counter)
+
+func lostOriginCounter(g *Gaz, streamName string) prometheus.Counter {
+	lostOriginMu.Lock()
+	defer lostOriginMu.Unlock()
+
+	if c, ok := lostOriginCounters[streamName]; ok {
+		return c
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: StreamLostOriginEvents,
+		Help: "The total number of derived events submitted without the origin stream timestamp of the events they are derived from",
+		ConstLabels: prometheus.Labels{
+			StreamNameLabel: streamName,
+		},
+	})
+	g.prometheusRegistry.MustRegister(c)
+	lostOriginCounters[streamName] = c
+	return c
+}
+
+// checkOrigin counts the derived events submitted without origin stream timestamp
+func checkOrigin(g *Gaz, derived bool, streamName string, evt *stream.Event) {
+	if !derived || stream.OriginStreamTimestamp(evt) > 0 {
+		return
+	}
+	Log.Debug("derived event submitted without origin stream timestamp", zap.String("stream", streamName), RedactedKey(evt.Key))
+	lostOriginCounter(g, streamName).Inc()
+}
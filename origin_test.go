@@ -0,0 +1,38 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOriginOfDerivedEvents(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	received := &stream.Event{}
+	received.SetStreamTime(time.Unix(0, 100))
+
+	checkOrigin(g, true, "derived", received.Derive([]byte("k"), []byte("v")))
+	checkOrigin(g, true, "derived", &stream.Event{Key: []byte("k")})
+	checkOrigin(g, false, "derived", &stream.Event{Key: []byte("k")})
+
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "derived"}, StreamLostOriginEvents, 1)
+}
+
+func TestJoinedEventOrigin(t *testing.T) {
+	left := &stream.Event{}
+	left.SetOriginStreamTime(time.Unix(0, 200))
+	right := &stream.Event{}
+	right.SetStreamTime(time.Unix(0, 100))
+
+	d := JoinedEvent{Key: []byte("k"), Left: left, Right: right}.Derive([]byte("k"), []byte("v"))
+	assert.Equal(t, int64(100), stream.OriginStreamTimestamp(d))
+
+	d = JoinedEvent{Key: []byte("k"), Left: left}.Derive([]byte("k"), []byte("v"))
+	assert.Equal(t, int64(200), stream.OriginStreamTimestamp(d))
+
+	d = JoinedEvent{Key: []byte("k"), Left: &stream.Event{}, Right: right}.Derive([]byte("k"), []byte("v"))
+	assert.Equal(t, int64(100), stream.OriginStreamTimestamp(d))
+}
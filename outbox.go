@@ -0,0 +1,80 @@
+package gorillaz
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+// OutboxEvent is an event published by an OutboxHandler once the inbound event is handled
+type OutboxEvent struct {
+	Subject string // Subject is prefixed the same way as in NatsPublish
+	Event   *stream.Event
+}
+
+// OutboxHandler handles an inbound event and returns the events to publish as its outcome
+type OutboxHandler func(subject string, event *stream.Event) ([]OutboxEvent, error)
+
+type OutboxConfig struct {
+	// EventId returns the id of the inbound event from which the ids of the published events are derived (default: DefaultEventId)
+	EventId func(subject string, e *stream.Event) string
+}
+
+type OutboxOpt func(c *OutboxConfig)
+
+// OutboxEventId derives the ids of the published events from the given inbound event id
+func OutboxEventId(eventId func(subject string, e *stream.Event) string) OutboxOpt {
+	return func(c *OutboxConfig) {
+		c.EventId = eventId
+	}
+}
+
+// Outbox returns a MsgHandler publishing the events returned by the handler, then acknowledging the inbound event.
+// The subscription must not use WithAutoAck, the inbound event is acknowledged only once all the events are
+// stored by Jetstream, so if anything fails the inbound event is redelivered and handled again.
+// The events are published with message ids derived from the id of the inbound event: their subjects must be
+// captured by Jetstream streams with a duplicate window, see JetstreamDuplicateWindow, so that the events
+// published again on redelivery are discarded.
+func (g *Gaz) Outbox(handler OutboxHandler, opts ...OutboxOpt) MsgHandler {
+	c := &OutboxConfig{
+		EventId: DefaultEventId,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return func(subject string, e *stream.Event) (*stream.Event, error) {
+		events, err := handler(subject, e)
+		if err != nil {
+			return nil, err
+		}
+		id := c.EventId(subject, e)
+		if id == "" && len(events) > 0 {
+			Log.Debug("inbound event without id, the published events won't be deduplicated on redelivery", zap.String("subject", subject))
+		}
+		ctx := e.Ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		for i, out := range events {
+			if out.Event.Ctx == nil {
+				out.Event.Ctx = context.Background()
+			}
+			stream.FillTracingSpan(out.Event, e)
+			var msgId string
+			if id != "" {
+				msgId = id + "-" + strconv.Itoa(i)
+			}
+			b, header, err := natsPayload(out.Event, msgId)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := g.jsPublish(ctx, g.natsSubject(out.Subject), b, header); err != nil {
+				return nil, fmt.Errorf("could not publish outbox event %d on %s: %w", i, out.Subject, err)
+			}
+		}
+		return nil, e.Ack()
+	}
+}
@@ -0,0 +1,43 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxAcksOnlyOnceHandled(t *testing.T) {
+	g := &Gaz{Env: "dev"}
+	acked := 0
+	newEvent := func() *stream.Event {
+		return &stream.Event{Ctx: context.Background(), AckFunc: func() error {
+			acked++
+			return nil
+		}}
+	}
+
+	failing := g.Outbox(func(subject string, e *stream.Event) ([]OutboxEvent, error) {
+		return nil, errors.New("failed")
+	})
+	_, err := failing("orders", newEvent())
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, acked)
+
+	// without Nats connection the outbox events cannot be published
+	publishing := g.Outbox(func(subject string, e *stream.Event) ([]OutboxEvent, error) {
+		return []OutboxEvent{{Subject: "invoices", Event: &stream.Event{Key: []byte("1")}}}, nil
+	})
+	_, err = publishing("orders", newEvent())
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, acked)
+
+	nothing := g.Outbox(func(subject string, e *stream.Event) ([]OutboxEvent, error) {
+		return nil, nil
+	})
+	_, err = nothing("orders", newEvent())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, acked)
+}
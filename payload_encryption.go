@@ -0,0 +1,217 @@
+package gorillaz
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+// The values of a stream can be encrypted end to end: the provider encrypts them before they are sent,
+// only the consumers having the keys of the stream can decrypt them.
+// Relays, bridges and any other intermediary forward the values without being able to read them.
+
+// ProviderEncryption encrypts the values of the events submitted to the provider with the current key of kp
+func ProviderEncryption(kp KeyProvider) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.Encryption = kp
+	}
+}
+
+// GetAndWatchEncryption encrypts the values of the events submitted to the provider with the current key of kp
+func GetAndWatchEncryption(kp KeyProvider) GetAndWatchConfigOpt {
+	return func(p *GetAndWatchConfig) {
+		p.Encryption = kp
+	}
+}
+
+// WithDecryption decrypts the values of the encrypted events received by the consumer,
+// the events which cannot be decrypted are not delivered
+func WithDecryption(kp KeyProvider) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.Decryption = kp
+	}
+}
+
+func encryptSubmitted(g *Gaz, kp KeyProvider, streamName string, evt *stream.Event) (*stream.Event, error) {
+	if kp == nil {
+		return evt, nil
+	}
+	out, err := EncryptEvent(kp, evt)
+	if err != nil {
+		Log.Error("could not encrypt event, not submitted", zap.String("stream", streamName), RedactedKey(evt.Key), zap.Error(err))
+		invalidEventsCounter(g, StreamInvalidEvents, streamName).Inc()
+	}
+	return out, err
+}
+
+func decryptReceived(g *Gaz, kp KeyProvider, streamName string, evt *stream.Event) (*stream.Event, error) {
+	if kp == nil {
+		return evt, nil
+	}
+	out, err := DecryptEvent(kp, evt)
+	if err != nil {
+		Log.Warn("could not decrypt event, not delivered", zap.String("stream", streamName), RedactedKey(evt.Key), zap.Error(err))
+		invalidEventsCounter(g, StreamConsumerInvalidEvents, streamName).Inc()
+	}
+	return out, err
+}
+
+// decryptGetAndWatchEvent decrypts in place the value of a received GetAndWatchEvent
+func decryptGetAndWatchEvent(g *Gaz, kp KeyProvider, streamName string, gwEvt *stream.GetAndWatchEvent) error {
+	if kp == nil || gwEvt.Metadata == nil {
+		return nil
+	}
+	if _, ok := gwEvt.Metadata.KeyValue[stream.HeaderPrefix+EncryptionKeyIdHeader]; !ok {
+		return nil
+	}
+	evt := &stream.Event{
+		Key:     gwEvt.Key,
+		Value:   gwEvt.Value,
+		Headers: stream.MetadataHeaders(gwEvt.Metadata),
+	}
+	out, err := decryptReceived(g, kp, streamName, evt)
+	if err != nil {
+		return err
+	}
+	gwEvt.Value = out.Value
+	delete(gwEvt.Metadata.KeyValue, stream.HeaderPrefix+EncryptionKeyIdHeader)
+	return nil
+}
+
+// ConfigKeyProvider returns a KeyProvider reading the keys of the stream from the configuration.
+// stream.encryption.<stream>.keys lists the keys as <id>:<base64 key>, separated by spaces,
+// stream.encryption.<stream>.current is the id of the key encrypting the new values (default: the last key of the list).
+func (g *Gaz) ConfigKeyProvider(streamName string) (*StaticKeyProvider, error) {
+	prefix := "stream.encryption." + streamName
+	entries := g.Viper.GetStringSlice(prefix + ".keys")
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no encryption key configured in %s.keys", prefix)
+	}
+	keys := make(map[string][]byte, len(entries))
+	var last string
+	for _, entry := range entries {
+		i := strings.Index(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid encryption key entry in %s.keys, expected <id>:<base64 key>", prefix)
+		}
+		id := entry[:i]
+		key, err := base64.StdEncoding.DecodeString(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s in %s.keys: %w", id, prefix, err)
+		}
+		keys[id] = key
+		last = id
+	}
+	current := g.Viper.GetString(prefix + ".current")
+	if current == "" {
+		current = last
+	}
+	return NewStaticKeyProvider(current, keys)
+}
+
+// KeyValueKeyProvider is a KeyProvider reading the keys of a stream from a Jetstream key value bucket,
+// so that they are distributed to the providers and consumers of the stream and can be rotated without restarting them.
+// The keys are stored base64 encoded under <stream>.<id>, and the id of the current key under <stream>.current.
+// Keys are cached once read, the current key id is read again after the refresh interval.
+type KeyValueKeyProvider struct {
+	g       *Gaz
+	bucket  string
+	stream  string
+	refresh time.Duration
+
+	mu          sync.Mutex
+	keys        map[string][]byte
+	current     string
+	currentRead time.Time
+}
+
+// NewKeyValueKeyProvider returns a KeyProvider reading the keys of the stream from the bucket
+func (g *Gaz) NewKeyValueKeyProvider(bucket, streamName string, refresh time.Duration) *KeyValueKeyProvider {
+	return &KeyValueKeyProvider{
+		g:       g,
+		bucket:  bucket,
+		stream:  streamName,
+		refresh: refresh,
+		keys:    make(map[string][]byte),
+	}
+}
+
+// PutKey stores the key in the bucket, and makes it the current key if current is true
+func (p *KeyValueKeyProvider) PutKey(ctx context.Context, id string, key []byte, current bool) error {
+	if _, err := newAead(key); err != nil {
+		return fmt.Errorf("invalid encryption key %s: %w", id, err)
+	}
+	if _, err := p.g.kvPut(ctx, p.bucket, p.stream+"."+id, []byte(base64.StdEncoding.EncodeToString(key))); err != nil {
+		return err
+	}
+	if !current {
+		return nil
+	}
+	if _, err := p.g.kvPut(ctx, p.bucket, p.stream+".current", []byte(id)); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.current = id
+	p.currentRead = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *KeyValueKeyProvider) CurrentKey() (string, []byte, error) {
+	p.mu.Lock()
+	current, read := p.current, p.currentRead
+	p.mu.Unlock()
+	if current == "" || time.Since(read) >= p.refresh {
+		ctx, cancel := context.WithTimeout(context.Background(), jsApiTimeout)
+		defer cancel()
+		entry, err := p.g.kvGet(ctx, p.bucket, p.stream+".current")
+		switch {
+		case err != nil && current == "":
+			return "", nil, err
+		case err != nil:
+			Log.Warn("could not refresh the current encryption key, keeping the previous one", zap.String("stream", p.stream), zap.Error(err))
+		case entry == nil || entry.Operation != "":
+			return "", nil, fmt.Errorf("no current key for stream %s: %w", p.stream, ErrUnknownKey)
+		default:
+			current = string(entry.Value)
+		}
+		p.mu.Lock()
+		p.current = current
+		p.currentRead = time.Now()
+		p.mu.Unlock()
+	}
+	key, err := p.Key(current)
+	return current, key, err
+}
+
+func (p *KeyValueKeyProvider) Key(id string) ([]byte, error) {
+	p.mu.Lock()
+	key, ok := p.keys[id]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), jsApiTimeout)
+	defer cancel()
+	entry, err := p.g.kvGet(ctx, p.bucket, p.stream+"."+id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || entry.Operation != "" {
+		return nil, fmt.Errorf("key %s: %w", id, ErrUnknownKey)
+	}
+	key, err = base64.StdEncoding.DecodeString(string(entry.Value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %s: %w", id, err)
+	}
+	p.mu.Lock()
+	p.keys[id] = key
+	p.mu.Unlock()
+	return key, nil
+}
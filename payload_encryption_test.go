@@ -0,0 +1,57 @@
+package gorillaz
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigKeyProvider(t *testing.T) {
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	g := &Gaz{Viper: viper.New()}
+	g.Viper.Set("stream.encryption.positions.keys", "k1:"+base64.StdEncoding.EncodeToString(k1)+" k2:"+base64.StdEncoding.EncodeToString(k2))
+
+	kp, err := g.ConfigKeyProvider("positions")
+	assert.Nil(t, err)
+	id, key, err := kp.CurrentKey()
+	assert.Nil(t, err)
+	assert.Equal(t, "k2", id)
+	assert.Equal(t, k2, key)
+
+	g.Viper.Set("stream.encryption.positions.current", "k1")
+	kp, err = g.ConfigKeyProvider("positions")
+	assert.Nil(t, err)
+	id, _, _ = kp.CurrentKey()
+	assert.Equal(t, "k1", id)
+
+	_, err = g.ConfigKeyProvider("flights")
+	assert.NotNil(t, err)
+
+	g.Viper.Set("stream.encryption.flights.keys", "k1")
+	_, err = g.ConfigKeyProvider("flights")
+	assert.NotNil(t, err)
+}
+
+func TestDecryptGetAndWatchEvent(t *testing.T) {
+	kp, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptEvent(kp, &stream.Event{Ctx: context.Background(), Key: []byte("flight"), Value: []byte("secret position")})
+	assert.Nil(t, err)
+	metadata, err := stream.EventMetadata(encrypted)
+	assert.Nil(t, err)
+	gwEvt := &stream.GetAndWatchEvent{Key: encrypted.Key, Value: encrypted.Value, Metadata: metadata}
+
+	assert.Nil(t, decryptGetAndWatchEvent(nil, nil, "positions", gwEvt))
+	assert.Equal(t, encrypted.Value, gwEvt.Value, "without key provider, the value is delivered as received")
+
+	assert.Nil(t, decryptGetAndWatchEvent(nil, kp, "positions", gwEvt))
+	assert.Equal(t, "secret position", string(gwEvt.Value))
+	assert.Equal(t, "", stream.MetadataHeaders(gwEvt.Metadata)[EncryptionKeyIdHeader])
+}
@@ -0,0 +1,377 @@
+package gorillaz
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/encoding"
+)
+
+// A pipeline relays the events of a source to a sink through a chain of transforms, all declared in the configuration,
+// so that simple bridge services can be deployed without code:
+//
+//	pipelines=flights-to-grpc
+//	pipeline.flights-to-grpc.source.type=nats
+//	pipeline.flights-to-grpc.source.subject=flights
+//	pipeline.flights-to-grpc.transforms=europe
+//	pipeline.flights-to-grpc.transform.europe.type=key_prefix
+//	pipeline.flights-to-grpc.transform.europe.prefix=EU.
+//	pipeline.flights-to-grpc.sink.type=stream
+//	pipeline.flights-to-grpc.sink.stream=flights
+//
+// The type of a source, a transform or a sink names a component, either built in or registered with WithPipelineSource,
+// WithPipelineTransform or WithPipelineSink. The type of a transform defaults to its name.
+// Each component reads its own keys from the configuration subtree it is given.
+
+// PipelineSource sends the events of a pipeline to out, until ctx is done or it fails.
+// It must not block on out once ctx is done, the events it sent are acknowledged once published to the sink.
+type PipelineSource func(ctx context.Context, out chan<- *stream.Event) error
+
+// PipelineTransform transforms an event of a pipeline, an event transformed to nil is dropped and acknowledged
+type PipelineTransform func(e *stream.Event) (*stream.Event, error)
+
+type PipelineSourceFactory func(g *Gaz, conf *viper.Viper) (PipelineSource, error)
+
+type PipelineTransformFactory func(g *Gaz, conf *viper.Viper) (PipelineTransform, error)
+
+type PipelineSinkFactory func(g *Gaz, conf *viper.Viper) (RelayPublisher, error)
+
+// built-in pipeline components
+var (
+	pipelineSources = map[string]PipelineSourceFactory{
+		"stream": streamPipelineSource,
+		"nats":   natsPipelineSource,
+	}
+	pipelineTransforms = map[string]PipelineTransformFactory{
+		"key_prefix": keyPrefixPipelineTransform,
+	}
+	pipelineSinks = map[string]PipelineSinkFactory{
+		"stream": streamPipelineSink,
+		"nats":   natsPipelineSink,
+		"file":   filePipelineSink,
+	}
+)
+
+type pipelineComponents struct {
+	sources    map[string]PipelineSourceFactory
+	transforms map[string]PipelineTransformFactory
+	sinks      map[string]PipelineSinkFactory
+}
+
+func (g *Gaz) components() *pipelineComponents {
+	if g.pipelineComponents == nil {
+		g.pipelineComponents = &pipelineComponents{
+			sources:    make(map[string]PipelineSourceFactory),
+			transforms: make(map[string]PipelineTransformFactory),
+			sinks:      make(map[string]PipelineSinkFactory),
+		}
+	}
+	return g.pipelineComponents
+}
+
+// WithPipelineSource registers a source type usable by the pipelines, it replaces a built-in source of the same type
+func WithPipelineSource(sourceType string, f PipelineSourceFactory) Option {
+	return Option{func(g *Gaz) error {
+		g.components().sources[sourceType] = f
+		return nil
+	}}
+}
+
+// WithPipelineTransform registers a transform type usable by the pipelines, it replaces a built-in transform of the same type
+func WithPipelineTransform(transformType string, f PipelineTransformFactory) Option {
+	return Option{func(g *Gaz) error {
+		g.components().transforms[transformType] = f
+		return nil
+	}}
+}
+
+// WithPipelineSink registers a sink type usable by the pipelines, it replaces a built-in sink of the same type
+func WithPipelineSink(sinkType string, f PipelineSinkFactory) Option {
+	return Option{func(g *Gaz) error {
+		g.components().sinks[sinkType] = f
+		return nil
+	}}
+}
+
+type pipeline struct {
+	g          *Gaz
+	name       string
+	source     PipelineSource
+	transforms []PipelineTransform
+	sink       RelayPublisher
+}
+
+// StartPipelines builds the pipelines listed by the "pipelines" key and runs them in the background until gorillaz is
+// shut down, a failed pipeline is restarted. Run starts them, once Nats is connected.
+// An error is returned, and no pipeline is started, if one of them is misconfigured.
+func (g *Gaz) StartPipelines() error {
+	var pipelines []*pipeline
+	for _, name := range commaList(g.Viper.GetString("pipelines")) {
+		p, err := g.newPipeline(name)
+		if err != nil {
+			return fmt.Errorf("invalid pipeline %s: %w", name, err)
+		}
+		pipelines = append(pipelines, p)
+	}
+	for _, p := range pipelines {
+		Log.Info("starting pipeline", zap.String("pipeline", p.name))
+		g.Go("pipeline."+p.name, p.run, GoRestart(time.Second, time.Minute))
+	}
+	return nil
+}
+
+func (g *Gaz) newPipeline(name string) (*pipeline, error) {
+	prefix := "pipeline." + name
+	p := &pipeline{g: g, name: name}
+
+	conf := g.pipelineConf(prefix + ".source")
+	sourceFactory, ok := g.components().sources[conf.GetString("type")]
+	if !ok {
+		if sourceFactory, ok = pipelineSources[conf.GetString("type")]; !ok {
+			return nil, fmt.Errorf("unknown source type '%s'", conf.GetString("type"))
+		}
+	}
+	source, err := sourceFactory(g, conf)
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	p.source = source
+
+	for _, t := range commaList(g.Viper.GetString(prefix + ".transforms")) {
+		conf := g.pipelineConf(prefix + ".transform." + t)
+		transformType := conf.GetString("type")
+		if transformType == "" {
+			transformType = t
+		}
+		transformFactory, ok := g.components().transforms[transformType]
+		if !ok {
+			if transformFactory, ok = pipelineTransforms[transformType]; !ok {
+				return nil, fmt.Errorf("unknown transform type '%s'", transformType)
+			}
+		}
+		transform, err := transformFactory(g, conf)
+		if err != nil {
+			return nil, fmt.Errorf("transform %s: %w", t, err)
+		}
+		p.transforms = append(p.transforms, transform)
+	}
+
+	conf = g.pipelineConf(prefix + ".sink")
+	sinkFactory, ok := g.components().sinks[conf.GetString("type")]
+	if !ok {
+		if sinkFactory, ok = pipelineSinks[conf.GetString("type")]; !ok {
+			return nil, fmt.Errorf("unknown sink type '%s'", conf.GetString("type"))
+		}
+	}
+	sink, err := sinkFactory(g, conf)
+	if err != nil {
+		return nil, fmt.Errorf("sink: %w", err)
+	}
+	p.sink = sink
+	return p, nil
+}
+
+// pipelineConf returns the configuration subtree of key, empty if the key is not set
+func (g *Gaz) pipelineConf(key string) *viper.Viper {
+	if conf := g.Viper.Sub(key); conf != nil {
+		return conf
+	}
+	return viper.New()
+}
+
+// run relays the events of the source to the sink until ctx is done or the source stops, it returns the error of the source
+func (p *pipeline) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// not buffered, so that no event is left behind when the source stops
+	events := make(chan *stream.Event)
+	sourceErr := make(chan error, 1)
+	p.g.goTracked("pipeline_source", p.name, func() {
+		sourceErr <- p.source(ctx, events)
+		cancel()
+	})
+	_ = p.g.Relay(ctx, p.name, events, p.publish)
+	return <-sourceErr
+}
+
+func (p *pipeline) publish(e *stream.Event) error {
+	for _, t := range p.transforms {
+		var err error
+		if e, err = t(e); err != nil {
+			return err
+		}
+		if e == nil {
+			return nil
+		}
+	}
+	return p.sink(e)
+}
+
+// streamPipelineSource consumes the stream of the "stream" key, from the comma separated "endpoints",
+// or else from the discovered "service", or else from the discovered stream if its name is qualified by its service
+func streamPipelineSource(g *Gaz, conf *viper.Viper) (PipelineSource, error) {
+	streamName := conf.GetString("stream")
+	if streamName == "" {
+		return nil, fmt.Errorf("missing stream")
+	}
+	endpoints := commaList(conf.GetString("endpoints"))
+	service := conf.GetString("service")
+	return func(ctx context.Context, out chan<- *stream.Event) error {
+		var c StreamConsumer
+		var err error
+		switch {
+		case len(endpoints) > 0:
+			c, err = g.ConsumeStream(endpoints, streamName)
+		case service != "":
+			c, err = g.DiscoverAndConsumeServiceStream(service, streamName)
+		default:
+			c, err = g.DiscoverAndConsumeStream(streamName)
+		}
+		if err != nil {
+			return err
+		}
+		defer c.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case e, ok := <-c.EvtChan():
+				if !ok {
+					return c.Err()
+				}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}, nil
+}
+
+// natsPipelineSource subscribes to the Nats "subject", in the optional "queue" group
+func natsPipelineSource(g *Gaz, conf *viper.Viper) (PipelineSource, error) {
+	subject := conf.GetString("subject")
+	if subject == "" {
+		return nil, fmt.Errorf("missing subject")
+	}
+	var opts []NatsConsumerOpt
+	if queue := conf.GetString("queue"); queue != "" {
+		opts = append(opts, WithQueue(queue))
+	}
+	return func(ctx context.Context, out chan<- *stream.Event) error {
+		sub, err := g.SubscribeNatsSubject(subject, func(_ string, e *stream.Event) (*stream.Event, error) {
+			select {
+			case out <- e:
+				return nil, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}, opts...)
+		if err != nil {
+			return err
+		}
+		<-ctx.Done()
+		if err := sub.Unsubscribe(); err != nil {
+			Log.Warn("could not unsubscribe the pipeline source", zap.String("subject", subject), zap.Error(err))
+		}
+		return ctx.Err()
+	}, nil
+}
+
+// keyPrefixPipelineTransform drops the events whose key does not start with the "prefix"
+func keyPrefixPipelineTransform(_ *Gaz, conf *viper.Viper) (PipelineTransform, error) {
+	prefix := []byte(conf.GetString("prefix"))
+	return func(e *stream.Event) (*stream.Event, error) {
+		if !bytes.HasPrefix(e.Key, prefix) {
+			return nil, nil
+		}
+		return e, nil
+	}, nil
+}
+
+// streamPipelineSink submits the events to a new provider of the "stream", of the optional "data_type"
+func streamPipelineSink(g *Gaz, conf *viper.Viper) (RelayPublisher, error) {
+	streamName := conf.GetString("stream")
+	if streamName == "" {
+		return nil, fmt.Errorf("missing stream")
+	}
+	p, err := g.NewStreamProvider(streamName, conf.GetString("data_type"))
+	if err != nil {
+		return nil, err
+	}
+	return func(e *stream.Event) error {
+		p.Submit(e)
+		return nil
+	}, nil
+}
+
+// natsPipelineSink publishes the events to the Nats "subject"
+func natsPipelineSink(g *Gaz, conf *viper.Viper) (RelayPublisher, error) {
+	subject := conf.GetString("subject")
+	if subject == "" {
+		return nil, fmt.Errorf("missing subject")
+	}
+	return func(e *stream.Event) error {
+		return g.NatsPublish(subject, e)
+	}, nil
+}
+
+// ArchivedEvent is an event written by the file sink of the pipelines
+type ArchivedEvent struct {
+	Key       []byte            `json:"key"`
+	Value     []byte            `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	EventTime int64             `json:"event_time,omitempty"` // EventTime is the event timestamp in nanoseconds, if any
+}
+
+// filePipelineSink appends the events to the file at "path", one ArchivedEvent per line, serialized by the gRPC codec
+// named by "codec" (default: gorillaz-json). Custom codecs are registered with WithCodecs, they must not write new lines.
+func filePipelineSink(_ *Gaz, conf *viper.Viper) (RelayPublisher, error) {
+	path := conf.GetString("path")
+	if path == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	codecName := conf.GetString("codec")
+	if codecName == "" {
+		codecName = JSONCodecName
+	}
+	codec := encoding.GetCodec(codecName)
+	if codec == nil {
+		return nil, fmt.Errorf("unknown codec '%s'", codecName)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	return func(e *stream.Event) error {
+		b, err := codec.Marshal(&ArchivedEvent{Key: e.Key, Value: e.Value, Headers: e.Headers, EventTime: stream.EventTimestamp(e)})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, err = f.Write(append(b, '\n'))
+		return err
+	}, nil
+}
+
+// commaList splits a comma separated list, ignoring the blanks
+func commaList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
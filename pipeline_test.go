@@ -0,0 +1,77 @@
+package gorillaz
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipelineToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "archive.jsonl")
+
+	g := &Gaz{Viper: viper.New(), prometheusRegistry: prometheus.NewRegistry()}
+	assert.Nil(t, WithPipelineSource("test", func(_ *Gaz, _ *viper.Viper) (PipelineSource, error) {
+		return func(ctx context.Context, out chan<- *stream.Event) error {
+			for _, k := range []string{"EU.1", "US.1", "EU.2"} {
+				out <- &stream.Event{Key: []byte(k), Value: []byte("v-" + k)}
+			}
+			return nil
+		}, nil
+	}).apply(g))
+	g.Viper.Set("pipeline.archive.source.type", "test")
+	g.Viper.Set("pipeline.archive.transforms", "europe")
+	g.Viper.Set("pipeline.archive.transform.europe.type", "key_prefix")
+	g.Viper.Set("pipeline.archive.transform.europe.prefix", "EU.")
+	g.Viper.Set("pipeline.archive.sink.type", "file")
+	g.Viper.Set("pipeline.archive.sink.path", path)
+
+	p, err := g.newPipeline("archive")
+	assert.Nil(t, err)
+	assert.Nil(t, p.run(context.Background()))
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e ArchivedEvent
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+		assert.Equal(t, "v-"+string(e.Key), string(e.Value))
+		keys = append(keys, string(e.Key))
+	}
+	assert.Equal(t, []string{"EU.1", "EU.2"}, keys)
+}
+
+func TestPipelineMisconfigured(t *testing.T) {
+	g := &Gaz{Viper: viper.New(), prometheusRegistry: prometheus.NewRegistry()}
+	g.Viper.Set("pipelines", "broken")
+	g.Viper.Set("pipeline.broken.source.type", "nats")
+	g.Viper.Set("pipeline.broken.source.subject", "flights")
+	g.Viper.Set("pipeline.broken.sink.type", "unknown")
+
+	err := g.StartPipelines()
+	assert.EqualError(t, err, "invalid pipeline broken: unknown sink type 'unknown'")
+
+	g.Viper.Set("pipeline.broken.sink.type", "nats")
+	g.Viper.Set("pipeline.broken.source.subject", "")
+	err = g.StartPipelines()
+	assert.EqualError(t, err, "invalid pipeline broken: source: missing subject")
+}
+
+func TestCommaList(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, commaList(" a, ,b "))
+	assert.Nil(t, commaList(""))
+}
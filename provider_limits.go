@@ -0,0 +1,110 @@
+package gorillaz
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// RetryAfterHeader is the trailer telling a rejected subscriber how many seconds to wait before subscribing again
+const RetryAfterHeader = "retry-after"
+
+const defaultSubscriberRetryAfter = 5 * time.Second
+
+// subscriberLimiter limits the number of concurrent subscribers of a stream
+type subscriberLimiter struct {
+	sync.Mutex
+	max     int
+	current int
+}
+
+func newSubscriberLimiter(max int) *subscriberLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &subscriberLimiter{max: max}
+}
+
+// acquire returns false if the maximum number of subscribers is reached, a nil limiter accepts every subscriber
+func (l *subscriberLimiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.current >= l.max {
+		return false
+	}
+	l.current++
+	return true
+}
+
+func (l *subscriberLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	l.current--
+	l.Unlock()
+}
+
+// sendRateLimiter is a token bucket limiting the number of events sent per second to a subscriber
+type sendRateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newSendRateLimiter(rate float64) *sendRateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &sendRateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until an event can be sent or ctx is done, a nil limiter never waits
+func (l *sendRateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return nil
+	}
+	t := time.NewTimer(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func retryAfterTrailer(d time.Duration) metadata.MD {
+	return metadata.Pairs(RetryAfterHeader, strconv.Itoa(int(d.Seconds())))
+}
+
+// retryAfter returns the delay requested by the provider in the trailer md, or def if there is none
+func retryAfter(md metadata.MD, def time.Duration) time.Duration {
+	if v := md.Get(RetryAfterHeader); len(v) > 0 {
+		if s, err := strconv.Atoi(v[0]); err == nil && s > 0 {
+			return time.Duration(s) * time.Second
+		}
+	}
+	return def
+}
@@ -0,0 +1,40 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestSubscriberLimiter(t *testing.T) {
+	l := newSubscriberLimiter(2)
+	assert.True(t, l.acquire())
+	assert.True(t, l.acquire())
+	assert.False(t, l.acquire(), "the third subscriber must be rejected")
+	l.release()
+	assert.True(t, l.acquire())
+
+	var unlimited *subscriberLimiter
+	assert.True(t, unlimited.acquire())
+}
+
+func TestSendRateLimiter(t *testing.T) {
+	l := newSendRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 110; i++ {
+		failIf(t, l.wait(context.Background()))
+	}
+	assert.True(t, time.Since(start) >= 80*time.Millisecond, "events beyond the burst must be delayed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, l.wait(ctx))
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 7*time.Second, retryAfter(retryAfterTrailer(7*time.Second), time.Second))
+	assert.Equal(t, time.Second, retryAfter(metadata.MD{}, time.Second))
+}
@@ -0,0 +1,98 @@
+package gorillaz
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/mux"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+// SourceSwapHeader is the header of the marker event submitted when the source of a provider is swapped,
+// its value is the name of the new source, see StreamProvider.SwapSource
+const SourceSwapHeader = "gorillaz-source-swap"
+
+// sourceSwapTimeout is how long a subscriber is waited for to receive the marker of a source swap before being disconnected
+const sourceSwapTimeout = 5 * time.Second
+
+// providerSource is the upstream source of the events submitted to a provider
+type providerSource struct {
+	mu     sync.Mutex
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{} // done is closed once all the events of the source are submitted
+}
+
+// stop stops the source and waits for its last events to be submitted
+func (s *providerSource) stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+}
+
+// SwapSource replaces the upstream source of the provider, for instance to switch from a simulation feed to the live feed.
+// The current source is stopped first, and its events are all submitted. Then a marker event, with the SourceSwapHeader header,
+// is submitted before the events of the new source. The subscribers keep their connection and receive the events of both
+// sources in order, they can detect the swap with SourceSwap. The marker is not dropped on backpressure: a subscriber not
+// receiving it in time is disconnected, so that it does not miss the swap. It is neither validated nor filtered by the key filters
+// or the sampling of the subscribers. The source must stop sending when its context is done.
+func (p *StreamProvider) SwapSource(name string, source PipelineSource) {
+	s := p.source
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.name
+	s.stop()
+	Log.Info("swapping the source of the stream", zap.String("stream", p.streamDef.Name), zap.String("previous", previous), zap.String("source", name))
+	marker := &stream.Event{Ctx: context.Background()}
+	marker.SetHeader(SourceSwapHeader, name)
+	p.submitValidated(marker, true, func(b *mux.Broadcaster, v interface{}) {
+		if err := b.SubmitGuaranteed(v, sourceSwapTimeout); err != nil {
+			Log.Warn("marker of the source swap not submitted", zap.String("stream", p.streamDef.Name), zap.Error(err))
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.name, s.cancel, s.done = name, cancel, done
+	// not buffered, so that no event is left behind when the source stops
+	events := make(chan *stream.Event)
+	p.gaz.goTracked("provider_source", p.streamDef.Name, func() {
+		if err := source(ctx, events); err != nil && ctx.Err() == nil {
+			Log.Error("source of the stream failed", zap.String("stream", p.streamDef.Name), zap.String("source", name), zap.Error(err))
+		}
+		close(events)
+	})
+	p.gaz.goTracked("provider_source_relay", p.streamDef.Name, func() {
+		defer close(done)
+		for e := range events {
+			p.Submit(e)
+		}
+	})
+}
+
+// Source returns the name of the current upstream source of the provider, empty if it has none
+func (p *StreamProvider) Source() string {
+	p.source.mu.Lock()
+	defer p.source.mu.Unlock()
+	return p.source.name
+}
+
+// stopSource stops the upstream source of the closed provider
+func (p *StreamProvider) stopSource() {
+	p.source.mu.Lock()
+	defer p.source.mu.Unlock()
+	p.source.stop()
+	p.source.name = ""
+}
+
+// SourceSwap returns the name of the new source if the event is the marker of a source swap, see StreamProvider.SwapSource
+func SourceSwap(evt *stream.Event) (source string, ok bool) {
+	source, ok = evt.Headers[SourceSwapHeader]
+	return source, ok
+}
@@ -0,0 +1,108 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+// feed sends count events with the key prefix, then waits for ctx to be done
+func feed(prefix string, count int) PipelineSource {
+	return func(ctx context.Context, out chan<- *stream.Event) error {
+		for i := 0; i < count; i++ {
+			select {
+			case out <- &stream.Event{Ctx: context.Background(), Key: []byte(prefix)}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		<-ctx.Done()
+		return nil
+	}
+}
+
+func TestProviderSwapSource(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("swapped", "dummy.type", LazyBroadcast)
+	assert.Nil(t, err)
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not mater", "swapped")
+	assert.Nil(t, err)
+
+	provider.SwapSource("simulation", feed("simulation", 1000))
+	assert.Equal(t, "simulation", provider.Source())
+
+	deadline := time.After(10 * time.Second)
+	next := func() *stream.Event {
+		select {
+		case e := <-consumer.EvtChan():
+			return e
+		case <-deadline:
+			t.Fatal("events not received in time")
+			return nil
+		}
+	}
+	source, ok := SourceSwap(next())
+	assert.True(t, ok)
+	assert.Equal(t, "simulation", source)
+	assert.Equal(t, "simulation", string(next().Key))
+
+	provider.SwapSource("live", feed("live", 1000))
+	// the events of the simulation are all received before the marker, then only the live events
+	for {
+		e := next()
+		if source, ok := SourceSwap(e); ok {
+			assert.Equal(t, "live", source)
+			break
+		}
+		if !assert.Equal(t, "simulation", string(e.Key), "live event received before the marker") {
+			t.FailNow()
+		}
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "live", string(next().Key))
+	}
+	assert.Nil(t, consumer.Err())
+}
+
+func TestProviderSwapSourceFiltered(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider []ProviderConfigOpt
+		consumer []ConsumerConfigOpt
+	}{
+		{name: "provider requires a key", provider: []ProviderConfigOpt{ProviderValidator(RequireKey())}},
+		{name: "provider allows some event types", provider: []ProviderConfigOpt{ProviderValidator(AllowedEventTypes("flight"))}},
+		{name: "consumer requires a key", consumer: []ConsumerConfigOpt{WithValidator(RequireKey())}},
+		{name: "consumer watches a key prefix", consumer: []ConsumerConfigOpt{WithKeyPrefix("live")}},
+		{name: "consumer samples the events", consumer: []ConsumerConfigOpt{SampleEvery(1000)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+			defer g.Shutdown()
+			<-g.Run()
+
+			provider, err := g.NewStreamProvider("swapped", "dummy.type", append(tt.provider, LazyBroadcast)...)
+			assert.Nil(t, err)
+			consumer, err := g.DiscoverAndConsumeServiceStream("does not mater", "swapped", tt.consumer...)
+			assert.Nil(t, err)
+			defer consumer.Stop()
+
+			provider.SwapSource("simulation", feed("simulation", 10))
+			select {
+			case e := <-consumer.EvtChan():
+				source, ok := SourceSwap(e)
+				assert.True(t, ok, "the marker is received first")
+				assert.Equal(t, "simulation", source)
+			case <-time.After(10 * time.Second):
+				t.Fatal("marker not received")
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package gorillaz
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	StreamQuotaThrottledEvents = "stream_quota_throttled_events"
+	StreamQuotaRejections      = "stream_quota_rejections"
+	StreamQuotaStreams         = "stream_quota_streams"
+)
+
+// IdentityLabel is the label of the quota metrics, the identity of the consumers
+const IdentityLabel = "identity"
+
+// DefaultQuotaIdentity is the identity of the quota applied to the consumers without quota of their own
+const DefaultQuotaIdentity = "*"
+
+type QuotaBehavior int
+
+const (
+	// QuotaThrottle delays the events exceeding the rates, the events queued meanwhile are dropped by backpressure
+	QuotaThrottle QuotaBehavior = iota
+	// QuotaDisconnect closes the stream exceeding the rates with ResourceExhausted
+	QuotaDisconnect
+)
+
+// Quota limits the streams of a consumer identity, all its streams on the provider together
+type Quota struct {
+	EventsPerSecond float64       // EventsPerSecond is the maximum number of events per second sent to the identity (default: 0, unlimited)
+	BytesPerSecond  float64       // BytesPerSecond is the maximum number of bytes per second sent to the identity (default: 0, unlimited)
+	MaxStreams      int           // MaxStreams is the maximum number of concurrent streams of the identity, the others are rejected with ResourceExhausted (default: 0, unlimited)
+	Behavior        QuotaBehavior // Behavior is applied when the rates are exceeded (default: QuotaThrottle)
+}
+
+// WithQuotas limits the streams of the consumers by identity, the DefaultQuotaIdentity quota applies to each identity without quota.
+// The identity is the authenticated identity of the peer certificate (see PeerIdentity), or the host of the consumer address
+// if it is not authenticated.
+func WithQuotas(quotas map[string]Quota) Option {
+	return Option{func(g *Gaz) error {
+		g.quotas = newQuotas(g, quotas)
+		return nil
+	}}
+}
+
+// quotaIdentity returns the identity whose quota applies to the peer: its authenticated identity, or else the host of its address.
+// The requester name is declared by the consumer, it could change it to escape its quota, and the port changes with each connection.
+func quotaIdentity(identity string, authenticated bool, p Peer) string {
+	if authenticated {
+		return identity
+	}
+	if host, _, err := net.SplitHostPort(p.address); err == nil {
+		return host
+	}
+	return p.address
+}
+
+type quotas struct {
+	g      *Gaz
+	config map[string]Quota
+	mu     sync.Mutex
+	states map[string]*identityQuota
+}
+
+func newQuotas(g *Gaz, config map[string]Quota) *quotas {
+	return &quotas{g: g, config: config, states: make(map[string]*identityQuota)}
+}
+
+// forIdentity returns the quota of the identity, nil if it is unlimited
+func (q *quotas) forIdentity(identity string) *identityQuota {
+	if q == nil {
+		return nil
+	}
+	config, ok := q.config[identity]
+	if !ok {
+		if config, ok = q.config[DefaultQuotaIdentity]; !ok {
+			return nil
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	iq, ok := q.states[identity]
+	if !ok {
+		iq = &identityQuota{
+			identity: identity,
+			config:   config,
+			events:   newQuotaBucket(config.EventsPerSecond),
+			bytes:    newQuotaBucket(config.BytesPerSecond),
+			metrics:  quotaMonitoring(q.g, identity),
+		}
+		q.states[identity] = iq
+	}
+	return iq
+}
+
+// identityQuota is the state of the quota of an identity, shared by all its streams
+type identityQuota struct {
+	identity string
+	config   Quota
+	mu       sync.Mutex
+	streams  int
+	events   *quotaBucket
+	bytes    *quotaBucket
+	metrics  *quotaMetrics
+}
+
+// acquire returns an error if the identity has reached its maximum number of streams, a nil quota accepts every stream
+func (q *identityQuota) acquire(streamName string) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.config.MaxStreams > 0 && q.streams >= q.config.MaxStreams {
+		Log.Warn("too many streams for the identity, rejecting the stream consumer", zap.String("stream", streamName), zap.String("identity", q.identity))
+		q.metrics.rejectionsCounter.Inc()
+		return status.Errorf(codes.ResourceExhausted, "quota of %d streams exceeded by %s", q.config.MaxStreams, q.identity)
+	}
+	q.streams++
+	q.metrics.streamsGauge.Set(float64(q.streams))
+	return nil
+}
+
+func (q *identityQuota) release() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.streams--
+	q.metrics.streamsGauge.Set(float64(q.streams))
+}
+
+// wait takes an event of size bytes from the rates of the identity, it blocks until the event can be sent with QuotaThrottle
+// and returns ResourceExhausted with QuotaDisconnect if the event exceeds the rates. A nil quota never waits.
+func (q *identityQuota) wait(ctx context.Context, size int) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	now := time.Now()
+	delay := q.events.reserve(now, 1)
+	if d := q.bytes.reserve(now, float64(size)); d > delay {
+		delay = d
+	}
+	if delay > 0 && q.config.Behavior == QuotaDisconnect {
+		// the exceeding event is not sent, it does not consume the rates
+		q.events.cancel(1)
+		q.bytes.cancel(float64(size))
+		q.mu.Unlock()
+		Log.Warn("quota exceeded, disconnecting the stream consumer", zap.String("identity", q.identity))
+		q.metrics.rejectionsCounter.Inc()
+		return status.Errorf(codes.ResourceExhausted, "rate quota exceeded by %s", q.identity)
+	}
+	q.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	q.metrics.throttledCounter.Inc()
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// quotaBucket is a token bucket whose tokens can be reserved in advance, the reservations beyond the available tokens are delayed.
+// Unlike sendRateLimiter, it is shared by several streams, so it must be used with the lock of its quota.
+type quotaBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newQuotaBucket(rate float64) *quotaBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &quotaBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes n tokens and returns how long to wait for them, a nil bucket never waits
+func (b *quotaBucket) reserve(now time.Time, n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back n reserved tokens
+func (b *quotaBucket) cancel(n float64) {
+	if b == nil {
+		return
+	}
+	b.tokens += n
+}
+
+type quotaMetrics struct {
+	throttledCounter  prometheus.Counter
+	rejectionsCounter prometheus.Counter
+	streamsGauge      prometheus.Gauge
+}
+
+// map of metrics registered to Prometheus, by identity
+var quotaMetricsMu sync.Mutex
+var quotaMonitorings = make(map[string]*quotaMetrics)
+
+func quotaMonitoring(g *Gaz, identity string) *quotaMetrics {
+	quotaMetricsMu.Lock()
+	defer quotaMetricsMu.Unlock()
+
+	if m, ok := quotaMonitorings[identity]; ok {
+		return m
+	}
+	labels := prometheus.Labels{IdentityLabel: identity}
+	m := &quotaMetrics{
+		throttledCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamQuotaThrottledEvents,
+			Help:        "The total number of events delayed because the identity exceeded its rate quota",
+			ConstLabels: labels,
+		}),
+		rejectionsCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamQuotaRejections,
+			Help:        "The total number of streams of the identity rejected or disconnected because it exceeded its quota",
+			ConstLabels: labels,
+		}),
+		streamsGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        StreamQuotaStreams,
+			Help:        "The number of concurrent streams of the identity",
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.throttledCounter)
+	g.prometheusRegistry.MustRegister(m.rejectionsCounter)
+	g.prometheusRegistry.MustRegister(m.streamsGauge)
+	quotaMonitorings[identity] = m
+	return m
+}
@@ -0,0 +1,67 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuotaMaxStreams(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	q := newQuotas(g, map[string]Quota{
+		"quota-greedy": {MaxStreams: 1},
+	})
+	assert.Nil(t, q.forIdentity("quota-other"), "no default quota")
+
+	greedy := q.forIdentity("quota-greedy")
+	assert.True(t, greedy == q.forIdentity("quota-greedy"), "the quota is shared by the streams of the identity")
+	assert.Nil(t, greedy.acquire("orders"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(greedy.acquire("orders")))
+	greedy.release()
+	assert.Nil(t, greedy.acquire("orders"))
+
+	labels := map[string]string{IdentityLabel: "quota-greedy"}
+	assertCounterEquals(t, g, labels, StreamQuotaRejections, 1)
+}
+
+func TestQuotaDisconnect(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	q := newQuotas(g, map[string]Quota{
+		DefaultQuotaIdentity: {BytesPerSecond: 100, Behavior: QuotaDisconnect},
+	}).forIdentity("quota-disconnected")
+
+	assert.Nil(t, q.wait(context.Background(), 60))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(q.wait(context.Background(), 60)))
+	assert.Nil(t, q.wait(context.Background(), 30), "the rejected event does not consume the quota")
+}
+
+func TestQuotaThrottle(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	q := newQuotas(g, map[string]Quota{
+		DefaultQuotaIdentity: {EventsPerSecond: 20},
+	}).forIdentity("quota-throttled")
+
+	start := time.Now()
+	for i := 0; i < 22; i++ {
+		assert.Nil(t, q.wait(context.Background(), 1))
+	}
+	assert.True(t, time.Since(start) >= 80*time.Millisecond, "the events beyond the burst are delayed")
+	assertCounterEquals(t, g, map[string]string{IdentityLabel: "quota-throttled"}, StreamQuotaThrottledEvents, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, q.wait(ctx, 1))
+}
+
+func TestQuotaIdentity(t *testing.T) {
+	peer := Peer{address: "10.0.0.1:51234", serviceName: "orders-client"}
+	assert.Equal(t, "CN=orders", quotaIdentity("CN=orders", true, peer))
+	assert.Equal(t, "10.0.0.1", quotaIdentity("", false, peer), "the quota of an unauthenticated peer is shared by its connections")
+	assert.Equal(t, "::1", quotaIdentity("", false, Peer{address: "[::1]:51234"}))
+	assert.Equal(t, "no peer in context", quotaIdentity("", false, Peer{address: "no peer in context"}))
+}
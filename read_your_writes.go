@@ -0,0 +1,80 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+// ErrStateCacheStopped is returned when waiting on a StateCache which stopped watching its stream
+var ErrStateCacheStopped = errors.New("state cache stopped")
+
+// ErrEventNotSubmitted is returned by SubmitAndWait when the event was rejected by the provider, see SubmitSeq
+var ErrEventNotSubmitted = errors.New("event not submitted")
+
+// key of the sequence of an event submitted to a GetAndWatchStreamProvider in the context of the event
+type stateSeqKey struct{}
+
+// withStateSeq returns a copy of the event carrying its sequence, the event submitted is not modified
+func withStateSeq(evt *stream.Event, seq int) *stream.Event {
+	ctx := evt.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	e := *evt
+	e.Ctx = context.WithValue(ctx, stateSeqKey{}, seq)
+	return &e
+}
+
+// stampStateSeq sets the sequence of the event, if any, in the metadata sent to the consumers
+func stampStateSeq(evt *stream.Event, m *stream.Metadata) {
+	if evt.Ctx == nil || m == nil {
+		return
+	}
+	if seq, ok := evt.Ctx.Value(stateSeqKey{}).(int); ok {
+		if m.KeyValue == nil {
+			m.KeyValue = make(map[string]string)
+		}
+		stream.SetMetadataStreamSeq(m, seq)
+	}
+}
+
+// SubmitAndWait submits the event and waits until cache, watching the stream of the provider, holds its value or a later one,
+// so that the reads of cache following a write observe it. It returns the error of ctx if it is done before, use a context
+// with a timeout to bound the wait.
+func (p *GetAndWatchStreamProvider) SubmitAndWait(ctx context.Context, evt *stream.Event, cache *StateCache) (CacheEntry, error) {
+	seq := p.SubmitSeq(evt)
+	if seq == 0 {
+		return CacheEntry{}, ErrEventNotSubmitted
+	}
+	return cache.WaitForSeq(ctx, evt.Key, seq)
+}
+
+// WaitForSeq waits until the entry of the key holds the value of the sequence seq or a later one, and returns it.
+// It returns the error of ctx if it is done before, and ErrStateCacheStopped if the cache stops.
+// The sequences restart from 1 when the provider restarts, a value received before the restart may be considered later.
+func (c *StateCache) WaitForSeq(ctx context.Context, key []byte, seq int) (CacheEntry, error) {
+	for {
+		c.mu.RLock()
+		e, ok := c.entries[string(key)]
+		changed := c.changed
+		c.mu.RUnlock()
+		if ok && e.Seq >= seq {
+			return e.CacheEntry, nil
+		}
+		select {
+		case <-changed:
+		case <-c.done:
+			return CacheEntry{}, ErrStateCacheStopped
+		case <-ctx.Done():
+			return CacheEntry{}, ctx.Err()
+		}
+	}
+}
+
+// signalChange wakes up the readers waiting for a change of the cache, it must be called with the lock held
+func (c *StateCache) signalChange() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
@@ -0,0 +1,68 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForSeq(t *testing.T) {
+	events := make(chan *stream.GetAndWatchEvent)
+	c := &StateCache{}
+	c.init("seq", events, func() bool { return false })
+
+	evt := gwEvent(stream.EventType_UPDATE, "k", "1")
+	evt.Metadata.KeyValue = make(map[string]string)
+	stream.SetMetadataStreamSeq(evt.Metadata, 1)
+	c.apply(evt)
+
+	e, err := c.WaitForSeq(context.Background(), []byte("k"), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, e.Seq)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		evt := gwEvent(stream.EventType_UPDATE, "k", "2")
+		evt.Metadata.KeyValue = make(map[string]string)
+		stream.SetMetadataStreamSeq(evt.Metadata, 2)
+		c.apply(evt)
+	}()
+	e, err = c.WaitForSeq(context.Background(), []byte("k"), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(e.Value))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.WaitForSeq(ctx, []byte("k"), 3)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(events)
+	_, err = c.WaitForSeq(context.Background(), []byte("k"), 3)
+	assert.Equal(t, ErrStateCacheStopped, err)
+}
+
+func TestSubmitAndWait(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider := g.NewGetAndWatchStreamProvider("read-your-writes", "dummy.type")
+	cache, err := g.NewStateCache("does not matter", "read-your-writes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, v := range []string{"1", "2", "3"} {
+		e, err := provider.SubmitAndWait(ctx, &stream.Event{Key: []byte("k"), Value: []byte(v)}, cache)
+		assert.Nil(t, err)
+		assert.Equal(t, v, string(e.Value))
+		entry, _ := cache.Get([]byte("k"))
+		assert.Equal(t, v, string(entry.Value), "the read following the write observes it")
+	}
+}
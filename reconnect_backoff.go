@@ -0,0 +1,122 @@
+package gorillaz
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrReconnectAttemptsExhausted is the error of a consumer which stopped after ReconnectBackoff.MaxAttempts consecutive failed attempts
+var ErrReconnectAttemptsExhausted = errors.New("stream consumer stopped, maximum number of reconnection attempts reached")
+
+// ReconnectBackoff is how long a consumer waits before reconnecting to the providers after a failure.
+// The delay grows exponentially with the number of consecutive failures, and is reset once the stream is connected.
+// The fields left to zero take their default value.
+type ReconnectBackoff struct {
+	Initial     time.Duration // Initial is the delay after the first failure (default: 1 sec)
+	Multiplier  float64       // Multiplier is the factor applied to the delay after each failure, at least 1 (default: 1.6)
+	Jitter      float64       // Jitter randomizes the delays by +/- this fraction, to spread the reconnections of the consumers, negative to disable it (default: 0.2)
+	MaxDelay    time.Duration // MaxDelay caps the delay (default: 5 sec, or Initial if it is longer)
+	MaxAttempts int           // MaxAttempts is the number of consecutive failures after which the consumer stops with ErrReconnectAttemptsExhausted (default: 0, unlimited)
+}
+
+func defaultReconnectBackoff() ReconnectBackoff {
+	return ReconnectBackoff{
+		Initial:    time.Second,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+		MaxDelay:   5 * time.Second,
+	}
+}
+
+// withDefaults returns the policy with the default value of its fields left to zero
+func (b ReconnectBackoff) withDefaults() ReconnectBackoff {
+	d := defaultReconnectBackoff()
+	if b.Initial <= 0 {
+		b.Initial = d.Initial
+	}
+	if b.Multiplier == 0 {
+		b.Multiplier = d.Multiplier
+	}
+	if b.Jitter == 0 {
+		b.Jitter = d.Jitter
+	}
+	if b.MaxDelay <= 0 {
+		b.MaxDelay = d.MaxDelay
+		if b.MaxDelay < b.Initial {
+			b.MaxDelay = b.Initial
+		}
+	}
+	return b
+}
+
+// WithReconnectBackoff sets how long the consumer waits before reconnecting after a failure, the fields left to zero take their default value
+func WithReconnectBackoff(b ReconnectBackoff) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.ReconnectBackoff = b
+	}
+}
+
+// WithMaxReconnectAttempts stops the consumer after n consecutive failed attempts to connect, its Err is then ErrReconnectAttemptsExhausted
+func WithMaxReconnectAttempts(n int) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.ReconnectBackoff.MaxAttempts = n
+	}
+}
+
+// reconnectBackoff counts the consecutive failures of a consumer, it is only used by the goroutine of the consumer
+type reconnectBackoff struct {
+	policy   ReconnectBackoff
+	attempts int
+	clock    Clock
+	stopped  <-chan struct{} // stopped is closed when the consumer is stopped, it stops waiting
+}
+
+func newReconnectBackoff(policy ReconnectBackoff, clock Clock, stopped <-chan struct{}) *reconnectBackoff {
+	return &reconnectBackoff{policy: policy.withDefaults(), clock: clockOrSystem(clock), stopped: stopped}
+}
+
+// delay records a failure and returns the delay before the next attempt
+func (b *reconnectBackoff) delay() time.Duration {
+	b.attempts++
+	d := float64(b.policy.Initial) * math.Pow(math.Max(b.policy.Multiplier, 1), float64(b.attempts-1))
+	if b.policy.MaxDelay > 0 && d > float64(b.policy.MaxDelay) {
+		d = float64(b.policy.MaxDelay)
+	}
+	if b.policy.Jitter > 0 {
+		d *= 1 + b.policy.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// exhausted returns true if the maximum number of consecutive failures is reached
+func (b *reconnectBackoff) exhausted() bool {
+	return b.policy.MaxAttempts > 0 && b.attempts >= b.policy.MaxAttempts
+}
+
+// reset is called once the stream is connected
+func (b *reconnectBackoff) reset() {
+	b.attempts = 0
+}
+
+// wait records a failure and waits before the next attempt, at least min if the provider asked to wait longer, or until the consumer is stopped.
+// When the maximum number of attempts is reached, it fails the consumer instead.
+func (b *reconnectBackoff) wait(guard *evtChanGuard, streamName string, min time.Duration) {
+	d := b.delay()
+	if b.exhausted() {
+		Log.Error("maximum number of reconnection attempts reached, stopping the consumer", zap.String("stream", streamName), zap.Int("attempts", b.attempts))
+		guard.fail(ErrReconnectAttemptsExhausted)
+		return
+	}
+	if d < min {
+		d = min
+	}
+	Log.Debug("waiting before reconnecting", zap.String("stream", streamName), zap.Duration("delay", d), zap.Int("attempts", b.attempts))
+	select {
+	case <-b.clock.After(d):
+	case <-b.stopped:
+	}
+}
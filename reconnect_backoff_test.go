@@ -0,0 +1,90 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectBackoffDelays(t *testing.T) {
+	b := newReconnectBackoff(ReconnectBackoff{Initial: 100 * time.Millisecond, Multiplier: 2, Jitter: -1, MaxDelay: 500 * time.Millisecond}, nil, nil)
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, b.delay())
+	}
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond}, delays)
+	assert.False(t, b.exhausted(), "the attempts are unlimited by default")
+
+	b.reset()
+	assert.Equal(t, 100*time.Millisecond, b.delay())
+
+	b = newReconnectBackoff(ReconnectBackoff{Initial: time.Second, Multiplier: 1, Jitter: 0.2}, nil, nil)
+	for i := 0; i < 100; i++ {
+		d := b.delay()
+		assert.True(t, d >= 800*time.Millisecond && d <= 1200*time.Millisecond, "delay %s out of the jitter range", d)
+	}
+}
+
+func TestReconnectBackoffMaxAttempts(t *testing.T) {
+	config := defaultConsumerConfig()
+	WithReconnectBackoff(ReconnectBackoff{Initial: time.Millisecond})(config)
+	WithMaxReconnectAttempts(2)(config)
+	b := newReconnectBackoff(config.ReconnectBackoff, nil, nil)
+	guard := &evtChanGuard{}
+
+	b.wait(guard, "backoff", 0)
+	assert.Nil(t, guard.Err())
+	b.wait(guard, "backoff", 0)
+	assert.Equal(t, ErrReconnectAttemptsExhausted, guard.Err())
+}
+
+func TestReconnectBackoffDefaults(t *testing.T) {
+	b := newReconnectBackoff(ReconnectBackoff{MaxDelay: 10 * time.Second}, nil, nil)
+	assert.Equal(t, ReconnectBackoff{Initial: time.Second, Multiplier: 1.6, Jitter: 0.2, MaxDelay: 10 * time.Second}, b.policy)
+	d := b.delay()
+	assert.True(t, d >= 800*time.Millisecond && d <= 1200*time.Millisecond, "the initial delay %s is the default one", d)
+
+	b = newReconnectBackoff(ReconnectBackoff{Initial: 10 * time.Second}, nil, nil)
+	assert.Equal(t, 10*time.Second, b.policy.MaxDelay, "the default maximum delay is not below the initial delay")
+}
+
+func TestReconnectBackoffWait(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	stopped := make(chan struct{})
+	b := newReconnectBackoff(ReconnectBackoff{Initial: time.Minute, Jitter: -1}, clock, stopped)
+	guard := &evtChanGuard{}
+
+	waited := make(chan struct{})
+	go func() {
+		b.wait(guard, "backoff", 0)
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("the backoff does not wait for the clock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for {
+		clock.Advance(time.Minute)
+		select {
+		case <-waited:
+		case <-time.After(10 * time.Millisecond):
+			// the backoff may not be waiting for the clock yet
+			continue
+		}
+		break
+	}
+
+	waited = make(chan struct{})
+	go func() {
+		b.wait(guard, "backoff", 0)
+		close(waited)
+	}()
+	close(stopped)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("the backoff keeps waiting once the consumer is stopped")
+	}
+}
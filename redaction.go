@@ -0,0 +1,121 @@
+package gorillaz
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/opentracing/opentracing-go"
+	zlog "github.com/opentracing/opentracing-go/log"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+// Redactor transforms the keys and values of the events before they are logged or attached to spans,
+// so that the logs and the traces do not leak customer data
+type Redactor interface {
+	Redact(b []byte) string
+}
+
+// RedactorFunc is a function usable as Redactor
+type RedactorFunc func(b []byte) string
+
+func (f RedactorFunc) Redact(b []byte) string {
+	return f(b)
+}
+
+// RedactNone keeps the data as is
+func RedactNone() Redactor {
+	return RedactorFunc(func(b []byte) string {
+		return string(b)
+	})
+}
+
+// RedactTruncate keeps only the first n bytes of the data
+func RedactTruncate(n int) Redactor {
+	return RedactorFunc(func(b []byte) string {
+		if len(b) <= n {
+			return string(b)
+		}
+		return string(b[:n]) + "..."
+	})
+}
+
+// RedactHash replaces the data by a short hash, the same data can still be correlated across logs and spans
+func RedactHash() Redactor {
+	return RedactorFunc(func(b []byte) string {
+		if len(b) == 0 {
+			return ""
+		}
+		h := sha256.Sum256(b)
+		return "sha256:" + hex.EncodeToString(h[:8])
+	})
+}
+
+// RedactDrop replaces the data by a placeholder
+func RedactDrop() Redactor {
+	return RedactorFunc(func(b []byte) string {
+		return "[redacted]"
+	})
+}
+
+// ParseRedactor returns the redactor of the given strategy: none, hash, drop, truncate (8 bytes) or truncate:<n>
+func ParseRedactor(strategy string) (Redactor, error) {
+	s := strings.ToLower(strategy)
+	switch {
+	case s == "" || s == "none":
+		return RedactNone(), nil
+	case s == "hash":
+		return RedactHash(), nil
+	case s == "drop":
+		return RedactDrop(), nil
+	case s == "truncate":
+		return RedactTruncate(8), nil
+	case strings.HasPrefix(s, "truncate:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(s, "truncate:"))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid redaction strategy %s", strategy)
+		}
+		return RedactTruncate(n), nil
+	default:
+		return nil, fmt.Errorf("invalid redaction strategy %s", strategy)
+	}
+}
+
+type redactorHolder struct {
+	Redactor
+}
+
+var redactor atomic.Value
+
+func init() {
+	redactor.Store(redactorHolder{RedactNone()})
+}
+
+// SetRedactor sets the redactor applied to the keys and values of the events logged and traced by gorillaz (default: RedactNone)
+func SetRedactor(r Redactor) {
+	redactor.Store(redactorHolder{r})
+}
+
+// Redact applies the current redactor to the data
+func Redact(b []byte) string {
+	return redactor.Load().(redactorHolder).Redact(b)
+}
+
+// RedactedKey returns a log field with the redacted key of an event
+func RedactedKey(key []byte) zap.Field {
+	return zap.String("key", Redact(key))
+}
+
+// RedactedValue returns a log field with the redacted value of an event
+func RedactedValue(value []byte) zap.Field {
+	return zap.String("value", Redact(value))
+}
+
+// TraceEvent logs the redacted key and value of the event in the span
+func TraceEvent(span opentracing.Span, e *stream.Event) {
+	span.LogFields(zlog.String("event.key", Redact(e.Key)), zlog.String("event.value", Redact(e.Value)))
+}
@@ -0,0 +1,59 @@
+package gorillaz
+
+import (
+	"testing"
+
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactors(t *testing.T) {
+	data := []byte("john.doe@example.com")
+
+	assert.Equal(t, "john.doe@example.com", RedactNone().Redact(data))
+	assert.Equal(t, "john.doe...", RedactTruncate(8).Redact(data))
+	assert.Equal(t, "john", RedactTruncate(8).Redact([]byte("john")))
+	assert.Equal(t, "[redacted]", RedactDrop().Redact(data))
+
+	h := RedactHash().Redact(data)
+	assert.Equal(t, h, RedactHash().Redact([]byte("john.doe@example.com")), "the hash must be stable to correlate logs")
+	assert.False(t, h == RedactHash().Redact([]byte("jane.doe@example.com")))
+	assert.Equal(t, 23, len(h))
+}
+
+func TestParseRedactor(t *testing.T) {
+	for strategy, expected := range map[string]string{
+		"":           "john.doe@example.com",
+		"none":       "john.doe@example.com",
+		"drop":       "[redacted]",
+		"Truncate":   "john.doe...",
+		"truncate:4": "john...",
+	} {
+		r, err := ParseRedactor(strategy)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, r.Redact([]byte("john.doe@example.com")), strategy)
+	}
+	for _, strategy := range []string{"mask", "truncate:x", "truncate:-1"} {
+		_, err := ParseRedactor(strategy)
+		assert.NotNil(t, err, strategy)
+	}
+}
+
+func TestTraceEventRedacted(t *testing.T) {
+	SetRedactor(RedactDrop())
+	defer SetRedactor(RedactNone())
+
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("test")
+	TraceEvent(span, &stream.Event{Key: []byte("john.doe"), Value: []byte("secret")})
+	span.Finish()
+
+	logs := tracer.FinishedSpans()[0].Logs()
+	assert.Len(t, logs, 1)
+	for _, f := range logs[0].Fields {
+		assert.Equal(t, "[redacted]", f.ValueString)
+	}
+	assert.Equal(t, "key", RedactedKey([]byte("john.doe")).Key)
+	assert.Equal(t, "[redacted]", RedactedKey([]byte("john.doe")).String)
+}
@@ -0,0 +1,162 @@
+package gorillaz
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	RelayEvents        = "relay_events"
+	RelayLoopedEvents  = "relay_looped_events"
+	RelayPublishErrors = "relay_publish_errors"
+	RelayLagMs         = "relay_lag_ms"
+)
+
+const RelayLabel = "relay"
+
+const (
+	// RelayPathHeader lists the relays the event went through, separated by commas
+	RelayPathHeader = "Gorillaz-Relay-Path"
+	// RelaySourceStreamHeader is the Jetstream stream from which the event was relayed, if any
+	RelaySourceStreamHeader = "Gorillaz-Relay-Source-Stream"
+	// RelaySourceSeqHeader is the sequence of the event in its source stream, if any
+	RelaySourceSeqHeader = "Gorillaz-Relay-Source-Seq"
+)
+
+// RelayPublisher publishes a relayed event to the destination, such as StreamProvider.Submit
+// or the NatsPublish of a NatsRemote
+type RelayPublisher func(e *stream.Event) error
+
+type relayMetrics struct {
+	relayedCounter prometheus.Counter
+	loopedCounter  prometheus.Counter
+	errorCounter   prometheus.Counter
+	lagGauge       prometheus.Gauge
+}
+
+// map of metrics registered to Prometheus, by relay
+// it's here because we cannot register twice to Prometheus the metrics with the same label
+var relayMetricsMu sync.Mutex
+var relayMonitorings = make(map[string]*relayMetrics)
+
+func relayMonitoring(g *Gaz, name string) *relayMetrics {
+	relayMetricsMu.Lock()
+	defer relayMetricsMu.Unlock()
+
+	if m, ok := relayMonitorings[name]; ok {
+		return m
+	}
+	labels := prometheus.Labels{RelayLabel: name}
+	m := &relayMetrics{
+		relayedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        RelayEvents,
+			Help:        "The total number of events relayed",
+			ConstLabels: labels,
+		}),
+		loopedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        RelayLoopedEvents,
+			Help:        "The total number of events dropped because they already went through the relay",
+			ConstLabels: labels,
+		}),
+		errorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        RelayPublishErrors,
+			Help:        "The total number of events that could not be published to the destination",
+			ConstLabels: labels,
+		}),
+		lagGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        RelayLagMs,
+			Help:        "Delay between when the last relayed event was streamed by its first producer and when it was relayed, in milliseconds",
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.relayedCounter)
+	g.prometheusRegistry.MustRegister(m.loopedCounter)
+	g.prometheusRegistry.MustRegister(m.errorCounter)
+	g.prometheusRegistry.MustRegister(m.lagGauge)
+	relayMonitorings[name] = m
+	return m
+}
+
+// Relay republishes the events of the source to the destination, for instance from a stream consumer (see EvtChan)
+// or a Jetstream pull (see PullJetstreamBatch) to a StreamProvider or a NatsRemote of another environment or cluster.
+// The origin stream timestamp of the events is preserved, their Jetstream stream and sequence are carried in headers.
+// The name of the relay is appended to the RelayPathHeader of the events, the events which already went through
+// a relay with the same name are dropped to break loops between environments.
+// Events are acknowledged once published. Relay blocks until the source is closed or ctx is done.
+func (g *Gaz) Relay(ctx context.Context, name string, source <-chan *stream.Event, publish RelayPublisher) error {
+	metrics := relayMonitoring(g, name)
+	Log.Info("relay started", zap.String("relay", name))
+	defer Log.Info("relay stopped", zap.String("relay", name))
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-source:
+			if !ok {
+				return nil
+			}
+			g.relay(name, e, publish, metrics)
+		}
+	}
+}
+
+func (g *Gaz) relay(name string, e *stream.Event, publish RelayPublisher, metrics *relayMetrics) {
+	path := e.Header(RelayPathHeader)
+	if relayPathContains(path, name) {
+		Log.Debug("event already relayed, dropping it", zap.String("relay", name), zap.String("path", path))
+		metrics.loopedCounter.Inc()
+		if err := e.Ack(); err != nil {
+			Log.Warn("could not ack looped event", zap.String("relay", name), zap.Error(err))
+		}
+		return
+	}
+
+	out := e.Clone()
+	if out.Ctx == nil {
+		out.Ctx = context.Background()
+	}
+	if path == "" {
+		out.SetHeader(RelayPathHeader, name)
+	} else {
+		out.SetHeader(RelayPathHeader, path+","+name)
+	}
+	if seq := e.StreamSeq(); seq > 0 {
+		out.SetHeader(RelaySourceStreamHeader, e.Stream())
+		out.SetHeader(RelaySourceSeqHeader, strconv.Itoa(seq))
+	}
+	stream.PropagateOrigin(out, e)
+	out = out.StampLineage(e.Stream(), int64(e.StreamSeq()), g.ServiceName, e.Stream() != "")
+
+	if err := publish(out); err != nil {
+		// not acknowledged, it may be redelivered
+		Log.Warn("could not relay event", zap.String("relay", name), zap.Error(err))
+		metrics.errorCounter.Inc()
+		return
+	}
+	metrics.relayedCounter.Inc()
+	if origin := stream.OriginStreamTimestamp(out); origin > 0 {
+		metrics.lagGauge.Set(float64(time.Now().UnixNano()-origin) / 1000000.0)
+	}
+	if err := e.Ack(); err != nil {
+		Log.Warn("could not ack relayed event", zap.String("relay", name), zap.Error(err))
+	}
+}
+
+func relayPathContains(path, name string) bool {
+	if path == "" {
+		return false
+	}
+	for _, r := range strings.Split(path, ",") {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelay(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	source := make(chan *stream.Event, 3)
+	var relayed []*stream.Event
+	acked := 0
+	ack := func() error {
+		acked++
+		return nil
+	}
+
+	fromJetstream := &stream.Event{Ctx: context.Background(), Key: []byte("1"), AckFunc: ack}
+	fromJetstream.SetStream("dev-orders")
+	fromJetstream.SetStreamSeq(42)
+	source <- fromJetstream
+
+	relayedOnce := &stream.Event{Ctx: context.Background(), Key: []byte("2"), AckFunc: ack}
+	relayedOnce.SetHeader(RelayPathHeader, "prod-to-dev")
+	source <- relayedOnce
+
+	looping := &stream.Event{Ctx: context.Background(), Key: []byte("3"), AckFunc: ack}
+	looping.SetHeader(RelayPathHeader, "prod-to-dev,dev-to-prod")
+	source <- looping
+	close(source)
+
+	err := g.Relay(context.Background(), "dev-to-prod", source, func(e *stream.Event) error {
+		relayed = append(relayed, e)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, acked)
+	if assert.Len(t, relayed, 2) {
+		assert.Equal(t, "dev-to-prod", relayed[0].Header(RelayPathHeader))
+		assert.Equal(t, "dev-orders", relayed[0].Header(RelaySourceStreamHeader))
+		assert.Equal(t, "42", relayed[0].Header(RelaySourceSeqHeader))
+		assert.Equal(t, "prod-to-dev,dev-to-prod", relayed[1].Header(RelayPathHeader))
+		assert.Equal(t, "", relayed[1].Header(RelaySourceSeqHeader))
+	}
+	assert.Equal(t, "prod-to-dev", relayedOnce.Header(RelayPathHeader), "the source event must not be modified")
+}
@@ -0,0 +1,220 @@
+package gorillaz
+
+import (
+	"sync"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProviderReplay numbers the events submitted and keeps the last n of them, so that the consumers can ask to replay them
+// from a sequence or a time with WithStartSequence or WithStartTime, or resume from their last event when they reconnect.
+// The events are numbered in the order they are submitted, the sequence is the StreamSeq of the events received.
+// The events are kept in memory only, the sequence restarts from 1 when the provider restarts.
+func ProviderReplay(n int) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.ReplayBufferLen = n
+	}
+}
+
+// WithStartSequence asks the provider to replay the events from the sequence, inclusive, before the new events,
+// for instance from the sequence following the last one processed before a restart, see StreamConsumer.LastSequence
+func WithStartSequence(seq int) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.StartSequence = seq
+	}
+}
+
+// WithStartTime asks the provider to replay the events streamed since t, inclusive, before the new events
+func WithStartTime(t time.Time) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.StartTime = t
+	}
+}
+
+// requestStart asks to replay the events from the position in the stream request,
+// the providers not replaying their events ignore it and simply send the new events
+func requestStart(seq int, t time.Time, req *stream.StreamRequest) {
+	if seq > 0 {
+		req.StartSequence = int64(seq)
+	} else if !t.IsZero() {
+		req.StartTime = t.UnixNano()
+	}
+}
+
+// requestedStart returns the start position asked by the consumer in its stream request
+func requestedStart(np StreamRequest) (seq int, t time.Time) {
+	req, ok := np.(*stream.StreamRequest)
+	if !ok {
+		return 0, time.Time{}
+	}
+	if req.GetStartSequence() > 0 {
+		seq = int(req.GetStartSequence())
+	}
+	if req.GetStartTime() > 0 {
+		t = time.Unix(0, req.GetStartTime())
+	}
+	return seq, t
+}
+
+type replayedEvent struct {
+	seq             int
+	streamTimestamp int64
+	lane            stream.Priority // lane is the lane the event was submitted to, see StreamProvider
+	b               []byte
+	control         bool // control is true for a controlEvent
+}
+
+// value returns the event as it was submitted to the broadcasters
+func (e replayedEvent) value() interface{} {
+	if e.control {
+		return controlEvent(e.b)
+	}
+	return e.b
+}
+
+// replayBuffer numbers the events of a provider and keeps the last ones, a nil buffer does nothing.
+// The submitters hold its lock while they number and submit an event, so that the events are numbered in the order of submission.
+// The events are kept before being submitted, under the lock of the ring only: the subscribers can register while a submitter
+// waits for a lazy broadcaster, and the events kept but not submitted yet are skipped by the filter of those replaying them.
+type replayBuffer struct {
+	submit sync.Mutex      // submit is held by the submitters
+	mu     sync.Mutex      // mu guards the ring
+	events []replayedEvent // events is a ring of the last events
+	next   int             // next is the index in events of the next event
+	seq    int             // seq is the sequence of the last event
+}
+
+func newReplayBuffer(n int) *replayBuffer {
+	if n <= 0 {
+		return nil
+	}
+	return &replayBuffer{events: make([]replayedEvent, 0, n)}
+}
+
+func (r *replayBuffer) lock() {
+	if r != nil {
+		r.submit.Lock()
+	}
+}
+
+func (r *replayBuffer) unlock() {
+	if r != nil {
+		r.submit.Unlock()
+	}
+}
+
+// stamp sets the sequence of the next event in the metadata, it must be called with the lock held
+func (r *replayBuffer) stamp(m *stream.Metadata) {
+	if r != nil && m != nil {
+		stream.SetMetadataStreamSeq(m, r.seq+1)
+	}
+}
+
+// record keeps the marshalled event stamped with the next sequence, it must be called with the lock held, before the event is submitted
+func (r *replayBuffer) record(m *stream.Metadata, b []byte, control bool) {
+	if r == nil || m == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(m, b, control)
+}
+
+// recordSubmitted submits the event without blocking and keeps it if it was submitted, it must be called with the lock held
+func (r *replayBuffer) recordSubmitted(m *stream.Metadata, b []byte, submit func() error) error {
+	if r == nil || m == nil {
+		return submit()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := submit(); err != nil {
+		return err
+	}
+	r.add(m, b, false)
+	return nil
+}
+
+func (r *replayBuffer) add(m *stream.Metadata, b []byte, control bool) {
+	r.seq++
+	e := replayedEvent{seq: r.seq, streamTimestamp: m.StreamTimestamp, lane: laneOf(m), b: b, control: control}
+	if len(r.events) < cap(r.events) {
+		r.events = append(r.events, e)
+	} else {
+		r.events[r.next] = e
+	}
+	r.next = (r.next + 1) % cap(r.events)
+}
+
+// subscribe calls register with the lock of the ring held, and returns the events kept from the sequence or the time,
+// as they were submitted to the broadcasters, along with the filter of the new events already replayed
+func (r *replayBuffer) subscribe(streamName string, seq int, t time.Time, register func()) ([]interface{}, *replayFilter) {
+	if r == nil {
+		register()
+		return nil, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	register()
+	if seq <= 0 && t.IsZero() {
+		return nil, nil
+	}
+	oldest := r.next
+	if len(r.events) < cap(r.events) {
+		oldest = 0
+	}
+	var events []interface{}
+	last := make(map[stream.Priority]int)
+	for i := 0; i < len(r.events); i++ {
+		e := r.events[(oldest+i)%len(r.events)]
+		if (seq > 0 && e.seq < seq) || (seq <= 0 && e.streamTimestamp < t.UnixNano()) {
+			continue
+		}
+		if events == nil && seq > 0 && e.seq > seq {
+			Log.Warn("the events requested are not kept anymore, replaying from the oldest one", zap.String("stream", streamName), zap.Int("requested", seq), zap.Int("oldest", e.seq))
+		}
+		events = append(events, e.value())
+		last[e.lane] = e.seq
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	return events, &replayFilter{last: last}
+}
+
+// laneOf returns the lane of the event, stream.PriorityHigh for the priority lane
+func laneOf(m *stream.Metadata) stream.Priority {
+	if stream.MetadataPriority(m) > stream.PriorityNormal {
+		return stream.PriorityHigh
+	}
+	return stream.PriorityNormal
+}
+
+// replayFilter skips the new events already replayed to a subscriber: the events submitted before it was registered
+// may still be queued in the broadcaster. The events of a lane are checked until the first one which was not replayed.
+type replayFilter struct {
+	last map[stream.Priority]int // last is the sequence of the last event replayed of each lane still checked
+}
+
+// replayed returns true if the marshalled event was already replayed
+func (f *replayFilter) replayed(b []byte) bool {
+	if f == nil || len(f.last) == 0 {
+		return false
+	}
+	var evt stream.StreamEvent
+	if err := proto.Unmarshal(b, &evt); err != nil {
+		return false
+	}
+	lane := laneOf(evt.Metadata)
+	last, ok := f.last[lane]
+	if !ok {
+		return false
+	}
+	if stream.MetadataStreamSeq(evt.Metadata) <= last {
+		return true
+	}
+	delete(f.last, lane)
+	return false
+}
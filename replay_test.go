@@ -0,0 +1,152 @@
+package gorillaz
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReplayBuffer(t *testing.T) {
+	r := newReplayBuffer(3)
+	start := time.Unix(100, 0)
+	for i := 1; i <= 5; i++ {
+		m := &stream.Metadata{KeyValue: make(map[string]string), StreamTimestamp: start.Add(time.Duration(i) * time.Second).UnixNano()}
+		r.stamp(m)
+		b, err := proto.Marshal(&stream.StreamEvent{Metadata: m, Value: []byte(strconv.Itoa(i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.record(m, b, false)
+	}
+	values := func(events []interface{}) []string {
+		var res []string
+		for _, v := range events {
+			b, _ := broadcastEvent(v)
+			var evt stream.StreamEvent
+			if err := proto.Unmarshal(b, &evt); err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, string(evt.Value))
+		}
+		return res
+	}
+	registered := 0
+	register := func() { registered++ }
+
+	events, filter := r.subscribe("replay", 4, time.Time{}, register)
+	assert.Equal(t, []string{"4", "5"}, values(events))
+	assert.True(t, filter.replayed(events[0].([]byte)), "the events queued before the registration are skipped")
+	events, _ = r.subscribe("replay", 1, time.Time{}, register)
+	assert.Equal(t, []string{"3", "4", "5"}, values(events), "only the last events are kept")
+	events, _ = r.subscribe("replay", 0, start.Add(5*time.Second), register)
+	assert.Equal(t, []string{"5"}, values(events))
+	events, filter = r.subscribe("replay", 0, time.Time{}, register)
+	assert.Empty(t, events, "only the new events are sent by default")
+	assert.Nil(t, filter)
+	assert.Equal(t, 4, registered)
+}
+
+func TestReplayRequest(t *testing.T) {
+	req := &stream.StreamRequest{}
+	requestStart(0, time.Time{}, req)
+	seq, start := requestedStart(req)
+	assert.Equal(t, 0, seq)
+	assert.True(t, start.IsZero())
+
+	since := time.Unix(1600000000, 42)
+	requestStart(0, since, req)
+	seq, start = requestedStart(req)
+	assert.Equal(t, 0, seq)
+	assert.True(t, since.Equal(start))
+
+	req = &stream.StreamRequest{}
+	requestStart(12, since, req)
+	seq, start = requestedStart(req)
+	assert.Equal(t, 12, seq)
+	assert.True(t, start.IsZero(), "the sequence prevails over the time")
+
+	seq, _ = requestedStart(&stream.GetAndWatchRequest{})
+	assert.Equal(t, 0, seq, "the GetAndWatch streams are not replayed")
+}
+
+func TestStreamReplay(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("replayed", "dummy.type", ProviderReplay(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		provider.Submit(&stream.Event{Value: []byte(strconv.Itoa(i))})
+	}
+
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "replayed", WithStartSequence(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	for _, v := range []string{"2", "3"} {
+		select {
+		case evt := <-consumer.EvtChan():
+			assert.Equal(t, v, string(evt.Value))
+			assert.Equal(t, v, strconv.Itoa(evt.StreamSeq()))
+		case <-time.After(5 * time.Second):
+			t.Fatal("event not replayed")
+		}
+	}
+
+	provider.Submit(&stream.Event{Value: []byte("4")})
+	assertReceived(t, "replayed", consumer.EvtChan(), &stream.Event{Value: []byte("4")})
+	waitUntil(t, time.Second, "last sequence updated", func() bool {
+		return consumer.LastSequence() == 4
+	})
+}
+
+func TestLazyStreamReplay(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("lazy-replayed", "dummy.type", LazyBroadcast, ProviderReplay(10), func(p *ProviderConfig) {
+		p.InputBufferLen = 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the submitter waits for a consumer, which must be able to register meanwhile
+	submitted := make(chan struct{})
+	go func() {
+		defer close(submitted)
+		for i := 1; i <= 3; i++ {
+			provider.Submit(&stream.Event{Value: []byte(strconv.Itoa(i))})
+		}
+	}()
+
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "lazy-replayed", WithStartSequence(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("submitter blocked")
+	}
+	// the events kept before the registration are replayed, and not received twice
+	for _, v := range []string{"1", "2", "3"} {
+		select {
+		case evt := <-consumer.EvtChan():
+			assert.Equal(t, v, string(evt.Value))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %s not received", v)
+		}
+	}
+	provider.Submit(&stream.Event{Value: []byte("4")})
+	assertReceived(t, "lazy-replayed", consumer.EvtChan(), &stream.Event{Value: []byte("4")})
+}
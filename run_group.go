@@ -0,0 +1,168 @@
+package gorillaz
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	GoroutineRunning  = "goroutine_running"
+	GoroutineRestarts = "goroutine_restarts"
+)
+
+const GoroutineLabel = "goroutine"
+
+const defaultGoroutineStopTimeout = 5 * time.Second
+
+type GoConfig struct {
+	Restart    bool          // Restart runs the function again when it fails or panics, until gorillaz is shut down (default: false)
+	MinBackoff time.Duration // MinBackoff is the delay before the first restart, it doubles at each consecutive failure (default: 1s)
+	MaxBackoff time.Duration // MaxBackoff is the maximum delay between two restarts, the backoff is reset once a run lasts longer (default: 1 minute)
+}
+
+type GoOpt func(c *GoConfig)
+
+// GoRestart runs the function again when it fails or panics, with an exponential backoff between min and max
+func GoRestart(min, max time.Duration) GoOpt {
+	return func(c *GoConfig) {
+		c.Restart = true
+		c.MinBackoff = min
+		c.MaxBackoff = max
+	}
+}
+
+type goroutineMetrics struct {
+	runningGauge    prometheus.Gauge
+	restartsCounter prometheus.Counter
+}
+
+// map of metrics registered to Prometheus, by goroutine name
+var goroutineMetricsMu sync.Mutex
+var goroutineMonitorings = make(map[string]*goroutineMetrics)
+
+func goroutineMonitoring(g *Gaz, name string) *goroutineMetrics {
+	goroutineMetricsMu.Lock()
+	defer goroutineMetricsMu.Unlock()
+
+	if m, ok := goroutineMonitorings[name]; ok {
+		return m
+	}
+	labels := prometheus.Labels{GoroutineLabel: name}
+	m := &goroutineMetrics{
+		runningGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        GoroutineRunning,
+			Help:        "The number of background goroutines running with this name",
+			ConstLabels: labels,
+		}),
+		restartsCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        GoroutineRestarts,
+			Help:        "The total number of restarts of the background goroutines with this name after a failure",
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.runningGauge)
+	g.prometheusRegistry.MustRegister(m.restartsCounter)
+	goroutineMonitorings[name] = m
+	return m
+}
+
+// runGroup tracks the background goroutines started with Gaz.Go
+type runGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (g *Gaz) goroutines() *runGroup {
+	g.runGroupOnce.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.runGroup = &runGroup{ctx: ctx, cancel: cancel}
+	})
+	return g.runGroup
+}
+
+// Go runs f in a background goroutine, such as a consumer pump, a bridge or a scheduler loop.
+// The context of f is cancelled when gorillaz is shut down, Shutdown waits for f to return.
+// The exits of f are logged with their reason, and with GoRestart f is run again when it fails or panics.
+func (g *Gaz) Go(name string, f func(ctx context.Context) error, opts ...GoOpt) {
+	config := &GoConfig{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	rg := g.goroutines()
+	clock := g.Clock()
+	metrics := goroutineMonitoring(g, name)
+	rg.wg.Add(1)
+	done := g.trackGoroutine(name, "")
+	go func() {
+		defer rg.wg.Done()
+		defer done()
+		backoff := config.MinBackoff
+		for {
+			start := clock.Now()
+			err := runSafely(rg.ctx, f)
+			switch {
+			case rg.ctx.Err() != nil:
+				Log.Info("goroutine stopped", zap.String("goroutine", name), zap.NamedError("reason", err))
+				return
+			case err == nil:
+				Log.Info("goroutine exited", zap.String("goroutine", name))
+				return
+			case !config.Restart:
+				Log.Error("goroutine failed", zap.String("goroutine", name), zap.Error(err))
+				return
+			}
+			if clock.Now().Sub(start) > config.MaxBackoff {
+				backoff = config.MinBackoff
+			}
+			Log.Warn("goroutine failed, restarting it", zap.String("goroutine", name), zap.Error(err), zap.Duration("backoff", backoff))
+			select {
+			case <-rg.ctx.Done():
+				return
+			case <-clock.After(backoff):
+			}
+			metrics.restartsCounter.Inc()
+			backoff *= 2
+			if backoff > config.MaxBackoff {
+				backoff = config.MaxBackoff
+			}
+		}
+	}()
+}
+
+// runSafely runs f, a panic is returned as an error
+func runSafely(ctx context.Context, f func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return f(ctx)
+}
+
+// stopGoroutines cancels the context of the goroutines started with Go and waits for them until the timeout
+func (g *Gaz) stopGoroutines(timeout time.Duration) bool {
+	rg := g.goroutines()
+	rg.cancel()
+	done := make(chan struct{})
+	go func() {
+		rg.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		Log.Warn("background goroutines still running after shutdown", zap.Duration("timeout", timeout))
+		return false
+	}
+}
@@ -0,0 +1,74 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoStopsOnShutdown(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	started := make(chan struct{})
+	g.Go("test-go-shutdown", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+	assertGaugeValue(t, g, GoroutineRunning, 1)
+
+	assert.True(t, g.stopGoroutines(time.Second))
+	assertGaugeValue(t, g, GoroutineRunning, 0)
+}
+
+func TestGoRestartsWithBackoff(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry(), clock: clock}
+	runs := make(chan int, 10)
+	n := 0
+	g.Go("test-go-restart", func(ctx context.Context) error {
+		n++
+		runs <- n
+		if n == 2 {
+			panic("boom")
+		}
+		if n == 3 {
+			return nil
+		}
+		return errors.New("failed")
+	}, GoRestart(time.Second, 10*time.Second))
+
+	assert.Equal(t, 1, <-runs)
+	waitForWaiters(t, clock)
+	clock.Advance(time.Second)
+	assert.Equal(t, 2, <-runs)
+
+	waitForWaiters(t, clock)
+	clock.Advance(time.Second)
+	select {
+	case <-runs:
+		t.Fatal("restarted before the backoff elapsed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	assert.Equal(t, 3, <-runs)
+
+	assert.True(t, g.stopGoroutines(time.Second))
+	assertCounterEquals(t, g, map[string]string{GoroutineLabel: "test-go-restart"}, GoroutineRestarts, 2)
+}
+
+func TestGoWithoutRestart(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	runs := 0
+	g.Go("test-go-no-restart", func(ctx context.Context) error {
+		runs++
+		return errors.New("failed")
+	})
+	assert.True(t, g.stopGoroutines(time.Second))
+	assert.Equal(t, 1, runs)
+	assertCounterEquals(t, g, map[string]string{GoroutineLabel: "test-go-no-restart"}, GoroutineRestarts, 0)
+}
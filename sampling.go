@@ -0,0 +1,85 @@
+package gorillaz
+
+import (
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+// SampleEvery requests a sampled view of the stream: the provider sends only 1 event out of n.
+// It is meant for observers such as dashboards and debuggers, which do not need every event.
+func SampleEvery(n int) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.SampleEvery = n
+	}
+}
+
+// SampleMaxRate requests a sampled view of the stream: the provider sends at most the given number of events per second
+// and skips the others
+func SampleMaxRate(eventsPerSecond float64) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.SampleMaxRate = eventsPerSecond
+	}
+}
+
+// requestSampling sets the sampling of the consumer in its stream request,
+// the providers not supporting it ignore it and send the full stream
+func requestSampling(c *ConsumerConfig, req *stream.StreamRequest) {
+	if c.SampleEvery > 1 {
+		req.SampleEvery = uint32(c.SampleEvery)
+	}
+	if c.SampleMaxRate > 0 {
+		req.SampleMaxRate = c.SampleMaxRate
+	}
+}
+
+// requestedSampling returns the sampling asked by the consumer in its stream request
+func requestedSampling(np StreamRequest) (every int, maxRate float64) {
+	req, ok := np.(*stream.StreamRequest)
+	if !ok {
+		return 0, 0
+	}
+	return int(req.GetSampleEvery()), req.GetSampleMaxRate()
+}
+
+// eventSampler skips the events exceeding the sampling of a consumer, a nil sampler keeps every event
+type eventSampler struct {
+	every    int
+	count    int
+	interval time.Duration
+	last     time.Time
+	clock    Clock
+}
+
+func newEventSampler(every int, maxRate float64, clock Clock) *eventSampler {
+	if every <= 1 && maxRate <= 0 {
+		return nil
+	}
+	s := &eventSampler{every: every, clock: clock}
+	if maxRate > 0 {
+		s.interval = time.Duration(float64(time.Second) / maxRate)
+	}
+	return s
+}
+
+// keep returns true if the event must be sent to the consumer
+func (s *eventSampler) keep() bool {
+	if s == nil {
+		return true
+	}
+	if s.every > 1 {
+		s.count++
+		if s.count < s.every {
+			return false
+		}
+		s.count = 0
+	}
+	if s.interval > 0 {
+		now := s.clock.Now()
+		if now.Sub(s.last) < s.interval {
+			return false
+		}
+		s.last = now
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplingRequest(t *testing.T) {
+	req := &stream.StreamRequest{}
+	requestSampling(&ConsumerConfig{}, req)
+	every, maxRate := requestedSampling(req)
+	assert.Equal(t, 0, every)
+	assert.Equal(t, 0.0, maxRate)
+
+	requestSampling(&ConsumerConfig{SampleEvery: 10, SampleMaxRate: 2.5}, req)
+	every, maxRate = requestedSampling(req)
+	assert.Equal(t, 10, every)
+	assert.Equal(t, 2.5, maxRate)
+
+	every, _ = requestedSampling(&stream.GetAndWatchRequest{})
+	assert.Equal(t, 0, every, "the GetAndWatch streams are not sampled")
+}
+
+func TestEventSamplerEvery(t *testing.T) {
+	assert.Nil(t, newEventSampler(0, 0, nil))
+	var nilSampler *eventSampler
+	assert.True(t, nilSampler.keep())
+
+	s := newEventSampler(3, 0, nil)
+	kept := 0
+	for i := 0; i < 9; i++ {
+		if s.keep() {
+			kept++
+		}
+	}
+	assert.Equal(t, 3, kept)
+}
+
+func TestEventSamplerMaxRate(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	s := newEventSampler(0, 10, clock)
+	assert.True(t, s.keep())
+	assert.False(t, s.keep(), "the events exceeding the rate must be skipped")
+	clock.Advance(90 * time.Millisecond)
+	assert.False(t, s.keep())
+	clock.Advance(10 * time.Millisecond)
+	assert.True(t, s.keep())
+}
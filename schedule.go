@@ -0,0 +1,209 @@
+package gorillaz
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	zlog "github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	ScheduledJobRuns                 = "scheduled_job_runs"
+	ScheduledJobDurationMs           = "scheduled_job_duration_ms"
+	ScheduledJobLastSuccessTimestamp = "scheduled_job_last_success_timestamp"
+)
+
+const ScheduledJobLabel = "job"
+const ScheduledJobResultLabel = "result"
+
+// scheduleBucket is the key value bucket in which the instances claim the runs of the scheduled jobs
+const scheduleBucket = "gorillaz-schedules"
+
+// scheduleClaimTtl is the time during which the claims of the runs are kept in the bucket
+const scheduleClaimTtl = 24 * time.Hour
+
+type ScheduleConfig struct {
+	Timeout time.Duration // Timeout is the maximum duration of a run, the context of the job is cancelled after it (default: 0, no timeout)
+	Local   bool          // Local runs the job on every instance, without claiming the runs in the Jetstream key value bucket (default: false)
+	Clock   Clock         // Clock tells when the runs are due (default: the clock of gorillaz)
+}
+
+type ScheduleConfigOpt func(c *ScheduleConfig)
+
+// ScheduleTimeout cancels the context of the job if a run lasts longer than the timeout
+func ScheduleTimeout(timeout time.Duration) ScheduleConfigOpt {
+	return func(c *ScheduleConfig) {
+		c.Timeout = timeout
+	}
+}
+
+// ScheduleClock sets the clock telling when the runs are due, for instance a ManualClock in tests
+func ScheduleClock(clock Clock) ScheduleConfigOpt {
+	return func(c *ScheduleConfig) {
+		c.Clock = clock
+	}
+}
+
+// ScheduleLocal runs the job on every instance of the service, Nats is not needed in that case
+func ScheduleLocal() ScheduleConfigOpt {
+	return func(c *ScheduleConfig) {
+		c.Local = true
+	}
+}
+
+type scheduleMetrics struct {
+	runsCounter      *prometheus.CounterVec
+	durationSummary  prometheus.Summary
+	lastSuccessGauge prometheus.Gauge
+}
+
+// map of metrics registered to Prometheus, by job
+// it's here because we cannot register twice to Prometheus the metrics with the same label
+var scheduleMetricsMu sync.Mutex
+var scheduleMonitorings = make(map[string]*scheduleMetrics)
+
+func scheduleMonitoring(g *Gaz, job string) *scheduleMetrics {
+	scheduleMetricsMu.Lock()
+	defer scheduleMetricsMu.Unlock()
+
+	if m, ok := scheduleMonitorings[job]; ok {
+		return m
+	}
+
+	m := &scheduleMetrics{
+		runsCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: ScheduledJobRuns,
+			Help: "The total number of runs of the scheduled job by result: success, error, or skipped when another instance claimed the run",
+			ConstLabels: prometheus.Labels{
+				ScheduledJobLabel: job,
+			},
+		}, []string{ScheduledJobResultLabel}),
+
+		durationSummary: prometheus.NewSummary(prometheus.SummaryOpts{
+			Name:       ScheduledJobDurationMs,
+			Help:       "distribution of the duration of the runs of the scheduled job, in milliseconds",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+			ConstLabels: prometheus.Labels{
+				ScheduledJobLabel: job,
+			},
+		}),
+
+		lastSuccessGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: ScheduledJobLastSuccessTimestamp,
+			Help: "Timestamp of the last successful run of the scheduled job",
+			ConstLabels: prometheus.Labels{
+				ScheduledJobLabel: job,
+			},
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.runsCounter)
+	g.prometheusRegistry.MustRegister(m.durationSummary)
+	g.prometheusRegistry.MustRegister(m.lastSuccessGauge)
+	scheduleMonitorings[job] = m
+	return m
+}
+
+// Schedule runs the job according to the cron spec, see parseCron for the supported syntax.
+// Unless ScheduleLocal is used, every run is claimed in a Jetstream key value bucket so that only one instance
+// of the service runs it. The instances must therefore have synchronized clocks.
+// The name identifies the job across the instances, it must be a valid Nats subject token.
+// Each run is traced and monitored. The returned function stops the schedule and cancels the run in progress, if any.
+func (g *Gaz) Schedule(name, cronSpec string, job func(ctx context.Context) error, opts ...ScheduleConfigOpt) (stop func(), err error) {
+	schedule, err := parseCron(cronSpec)
+	if err != nil {
+		return nil, err
+	}
+	config := &ScheduleConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if !config.Local {
+		if err := g.ProvisionKeyValue(context.Background(), scheduleBucket, JetstreamMaxAge(scheduleClaimTtl)); err != nil {
+			return nil, fmt.Errorf("could not provision the schedule bucket: %w", err)
+		}
+	}
+	metrics := scheduleMonitoring(g, name)
+	if config.Clock == nil {
+		config.Clock = g.Clock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	g.goTracked("schedule", name, func() {
+		defer close(done)
+		Log.Info("job scheduled", zap.String("job", name), zap.String("spec", cronSpec))
+		for {
+			now := config.Clock.Now()
+			at := schedule.next(now)
+			if at.IsZero() {
+				Log.Warn("no next run for the scheduled job", zap.String("job", name), zap.String("spec", cronSpec))
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-config.Clock.After(at.Sub(now)):
+			}
+			if !config.Local && !g.claimRun(ctx, name, at) {
+				metrics.runsCounter.WithLabelValues("skipped").Inc()
+				continue
+			}
+			g.runJob(ctx, name, at, job, config, metrics)
+		}
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}, nil
+}
+
+// claimRun returns true if this instance is the first one to claim the run of the job at the given time
+func (g *Gaz) claimRun(ctx context.Context, name string, at time.Time) bool {
+	key := name + "." + strconv.FormatInt(at.Unix(), 10)
+	_, err := g.kvCreate(ctx, scheduleBucket, key, []byte(g.ServiceName+"@"+g.serviceAddress))
+	if err == ErrKeyExists {
+		Log.Debug("run claimed by another instance", zap.String("job", name), zap.Time("at", at))
+		return false
+	}
+	if err != nil {
+		Log.Warn("could not claim the run of the scheduled job, skipping it", zap.String("job", name), zap.Time("at", at), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+func (g *Gaz) runJob(ctx context.Context, name string, at time.Time, job func(ctx context.Context) error, config *ScheduleConfig, metrics *scheduleMetrics) {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	span, ctx := opentracing.StartSpanFromContext(ctx, "schedule."+name)
+	span.SetTag("job", name)
+	span.SetTag("scheduled.at", at.Format(time.RFC3339))
+	defer span.Finish()
+
+	start := time.Now()
+	err := job(ctx)
+	metrics.durationSummary.Observe(float64(time.Since(start).Nanoseconds()) / 1000000.0)
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(zlog.Error(err))
+		metrics.runsCounter.WithLabelValues("error").Inc()
+		Log.Warn("scheduled job failed", zap.String("job", name), zap.Time("at", at), zap.Error(err))
+		return
+	}
+	metrics.runsCounter.WithLabelValues("success").Inc()
+	metrics.lastSuccessGauge.SetToCurrentTime()
+}
@@ -52,12 +52,20 @@ func (g *gorillazResolverBuilder) Build(target resolver.Target, cc resolver.Clie
 			closeChan:        make(chan struct{}),
 			tick:             time.NewTicker(1 * time.Second),
 			env:              g.gaz.Env,
+			zone:             g.gaz.Viper.GetString("zone"),
 		}
 		go r.updater()
 
+		result = r
+	} else if r, scheme, ok := g.gaz.endpointResolver(strings.Split(target.Endpoint, ",")); ok {
+		pr := newPluggableResolver(cc, r, scheme, strings.Split(target.Endpoint, ","))
+		pr.start(g.gaz)
+		result = pr
+	} else if split := strings.Split(target.Endpoint, ","); hasHostNames(split) {
+		r := newDNSResolver(cc, split, g.gaz.dnsConfig())
+		go r.updater()
 		result = r
 	} else {
-		split := strings.Split(target.Endpoint, ",")
 		addrs := make([]resolver.Address, len(split))
 		for i, s := range split {
 			addrs[i] = resolver.Address{Addr: s}
@@ -83,6 +91,8 @@ type serviceDiscoveryResolver struct {
 	cc               resolver.ClientConn
 	tick             *time.Ticker
 	env              string
+	zone             string // zone is the zone of the service, the providers in the same zone are preferred by EndpointZoneAware
+	attributes       zoneAttributesCache
 }
 
 func (r *serviceDiscoveryResolver) updater() {
@@ -112,9 +122,12 @@ func (r *serviceDiscoveryResolver) sendUpdate() {
 		return
 	}
 	addrs := make([]resolver.Address, len(endpoints))
+	attributes := make(zoneAttributesCache, len(endpoints))
 	for i, e := range endpoints {
-		addrs[i] = resolver.Address{Addr: fmt.Sprintf("%s:%d", e.Addr, e.Port)}
+		addr := fmt.Sprintf("%s:%d", e.Addr, e.Port)
+		addrs[i] = resolver.Address{Addr: addr, Attributes: r.attributes.update(attributes, r.zone, addr, e.Meta)}
 	}
+	r.attributes = attributes
 	r.cc.UpdateState(resolver.State{Addresses: addrs})
 }
 
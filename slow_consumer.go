@@ -0,0 +1,149 @@
+package gorillaz
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	StreamSlowConsumersDetected = "stream_slow_consumers_detected"
+	StreamSlowConsumers         = "stream_slow_consumers"
+)
+
+// SlowConsumer describes a subscriber of a stream which does not keep up with the events
+type SlowConsumer struct {
+	Stream  string
+	Peer    string        // Peer is the address of the subscriber
+	Service string        // Service is the name of the service of the subscriber, if known
+	Backlog int           // Backlog is the number of events waiting to be sent to the subscriber
+	Latency time.Duration // Latency is the time it took to send the last event to the subscriber
+}
+
+type SlowConsumerConfig struct {
+	MaxBacklog     int                // MaxBacklog is the number of events waiting for a subscriber above which it is slow (default: 0, not checked)
+	MaxLatency     time.Duration      // MaxLatency is the time to send an event to a subscriber above which it is slow (default: 0, not checked)
+	For            time.Duration      // For is how long a subscriber must stay above the thresholds to be reported as slow
+	Disconnect     bool               // Disconnect disconnects the slow subscribers, they are asked to retry later
+	OnSlowConsumer func(SlowConsumer) // OnSlowConsumer is called when a subscriber is detected as slow (default: log)
+}
+
+// DetectSlowConsumers reports the subscribers of the provider staying above the thresholds of c
+func DetectSlowConsumers(c SlowConsumerConfig) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.SlowConsumer = &c
+	}
+}
+
+// GetAndWatchDetectSlowConsumers reports the subscribers of the provider staying above the thresholds of c
+func GetAndWatchDetectSlowConsumers(c SlowConsumerConfig) GetAndWatchConfigOpt {
+	return func(p *GetAndWatchConfig) {
+		p.SlowConsumer = &c
+	}
+}
+
+type slowConsumerMetrics struct {
+	detectedCounter prometheus.Counter
+	slowGauge       prometheus.Gauge
+}
+
+// map of metrics registered to Prometheus, by stream
+var slowConsumerMetricsMu sync.Mutex
+var slowConsumerMonitorings = make(map[string]*slowConsumerMetrics)
+
+func slowConsumerMonitoring(g *Gaz, streamName string) *slowConsumerMetrics {
+	slowConsumerMetricsMu.Lock()
+	defer slowConsumerMetricsMu.Unlock()
+
+	if m, ok := slowConsumerMonitorings[streamName]; ok {
+		return m
+	}
+	m := &slowConsumerMetrics{
+		detectedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        StreamSlowConsumersDetected,
+			Help:        "The total number of subscribers detected as not consuming fast enough",
+			ConstLabels: prometheus.Labels{StreamNameLabel: streamName},
+		}),
+		slowGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        StreamSlowConsumers,
+			Help:        "The number of connected subscribers currently not consuming fast enough",
+			ConstLabels: prometheus.Labels{StreamNameLabel: streamName},
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.detectedCounter)
+	g.prometheusRegistry.MustRegister(m.slowGauge)
+	slowConsumerMonitorings[streamName] = m
+	return m
+}
+
+// slowConsumerDetector follows the backlog and the latency of a subscriber, a nil detector detects nothing
+type slowConsumerDetector struct {
+	config     *SlowConsumerConfig
+	metrics    *slowConsumerMetrics
+	streamName string
+	peer       Peer
+	aboveSince time.Time
+	slow       bool
+}
+
+func newSlowConsumerDetector(g *Gaz, streamName string, peer Peer, c *SlowConsumerConfig) *slowConsumerDetector {
+	if c == nil || (c.MaxBacklog <= 0 && c.MaxLatency <= 0) {
+		return nil
+	}
+	return &slowConsumerDetector{
+		config:     c,
+		metrics:    slowConsumerMonitoring(g, streamName),
+		streamName: streamName,
+		peer:       peer,
+	}
+}
+
+// observe records the backlog of the subscriber and the latency of the last event sent.
+// It returns an error if the subscriber is slow and must be disconnected.
+func (d *slowConsumerDetector) observe(backlog int, latency time.Duration, now time.Time) error {
+	if d == nil {
+		return nil
+	}
+	above := (d.config.MaxBacklog > 0 && backlog > d.config.MaxBacklog) || (d.config.MaxLatency > 0 && latency > d.config.MaxLatency)
+	if !above {
+		d.aboveSince = time.Time{}
+		if d.slow {
+			d.slow = false
+			d.metrics.slowGauge.Dec()
+			Log.Info("subscriber caught up", zap.String("stream", d.streamName), zap.String("peer", d.peer.address), zap.String("peer service", d.peer.serviceName))
+		}
+		return nil
+	}
+	if d.aboveSince.IsZero() {
+		d.aboveSince = now
+	}
+	if d.slow || now.Sub(d.aboveSince) < d.config.For {
+		return nil
+	}
+	d.slow = true
+	d.metrics.detectedCounter.Inc()
+	d.metrics.slowGauge.Inc()
+	sc := SlowConsumer{Stream: d.streamName, Peer: d.peer.address, Service: d.peer.serviceName, Backlog: backlog, Latency: latency}
+	if d.config.OnSlowConsumer != nil {
+		d.config.OnSlowConsumer(sc)
+	} else {
+		Log.Warn("subscriber not consuming fast enough", zap.String("stream", d.streamName), zap.String("peer", sc.Peer), zap.String("peer service", sc.Service),
+			zap.Int("backlog", backlog), zap.Duration("latency", latency))
+	}
+	if d.config.Disconnect {
+		return status.Error(codes.ResourceExhausted, "not consuming fast enough")
+	}
+	return nil
+}
+
+// close must be called when the subscriber is disconnected
+func (d *slowConsumerDetector) close() {
+	if d != nil && d.slow {
+		d.slow = false
+		d.metrics.slowGauge.Dec()
+	}
+}
@@ -0,0 +1,73 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSlowConsumerDetector(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	assert.Nil(t, newSlowConsumerDetector(g, "slow", Peer{}, nil))
+	assert.Nil(t, newSlowConsumerDetector(g, "slow", Peer{}, &SlowConsumerConfig{For: time.Second}))
+
+	var reported []SlowConsumer
+	d := newSlowConsumerDetector(g, "slow", Peer{address: "10.0.0.1:1234", serviceName: "radar"}, &SlowConsumerConfig{
+		MaxBacklog: 10,
+		MaxLatency: 100 * time.Millisecond,
+		For:        time.Second,
+		OnSlowConsumer: func(c SlowConsumer) {
+			reported = append(reported, c)
+		},
+	})
+	now := time.Now()
+	assert.Nil(t, d.observe(20, 0, now))
+	assert.Nil(t, d.observe(20, 0, now.Add(500*time.Millisecond)))
+	assert.Len(t, reported, 0, "not slow until above the thresholds for 1s")
+	assert.Nil(t, d.observe(0, 0, now.Add(600*time.Millisecond)))
+	assert.Nil(t, d.observe(0, time.Second, now.Add(700*time.Millisecond)))
+	assert.Nil(t, d.observe(0, time.Second, now.Add(1600*time.Millisecond)))
+	assert.Len(t, reported, 0, "the subscriber caught up in between")
+	assert.Nil(t, d.observe(0, time.Second, now.Add(1700*time.Millisecond)))
+	assert.Nil(t, d.observe(30, 0, now.Add(2*time.Second)))
+	assert.Len(t, reported, 1)
+	assert.Equal(t, "10.0.0.1:1234", reported[0].Peer)
+	assert.Equal(t, "radar", reported[0].Service)
+	assert.Equal(t, time.Second, reported[0].Latency)
+	assertGaugeValue(t, g, StreamSlowConsumers, 1)
+
+	assert.Nil(t, d.observe(0, 0, now.Add(3*time.Second)))
+	assertGaugeValue(t, g, StreamSlowConsumers, 0)
+	d.close()
+	assertGaugeValue(t, g, StreamSlowConsumers, 0)
+
+	m, err := findMetric(g, StreamSlowConsumersDetected, map[string]string{StreamNameLabel: "slow"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, *m.Counter.Value)
+}
+
+func TestSlowConsumerDisconnected(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	d := newSlowConsumerDetector(g, "slow-disconnected", Peer{}, &SlowConsumerConfig{MaxBacklog: 1, Disconnect: true})
+	err := d.observe(2, 0, time.Now())
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	d.close()
+	assertGaugeValue(t, g, StreamSlowConsumers, 0)
+}
+
+func assertGaugeValue(t *testing.T, g *Gaz, name string, value float64) {
+	t.Helper()
+	metricFamilies, err := g.prometheusRegistry.Gather()
+	assert.Nil(t, err)
+	for _, mf := range metricFamilies {
+		if mf.GetName() == name {
+			assert.Equal(t, value, mf.Metric[0].GetGauge().GetValue())
+			return
+		}
+	}
+	t.Errorf("metric %s not found", name)
+}
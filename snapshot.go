@@ -0,0 +1,198 @@
+package gorillaz
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrNoSnapshot is returned by a SnapshotStore which has no snapshot for the stream
+var ErrNoSnapshot = errors.New("no snapshot")
+
+// SnapshotStore persists the snapshots of the state of GetAndWatch providers, for instance on disk or in an object storage
+type SnapshotStore interface {
+	// Save replaces the snapshot of the stream
+	Save(ctx context.Context, streamName string, snapshot []byte) error
+	// Load returns the last snapshot of the stream, or ErrNoSnapshot
+	Load(ctx context.Context, streamName string) ([]byte, error)
+}
+
+// FileSnapshotStore is a SnapshotStore keeping the snapshots in files of a directory
+type FileSnapshotStore struct {
+	Dir string
+}
+
+func (s FileSnapshotStore) path(streamName string) string {
+	return filepath.Join(s.Dir, streamName+".snapshot")
+}
+
+func (s FileSnapshotStore) Save(_ context.Context, streamName string, snapshot []byte) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	// the snapshot is written aside then renamed, so that a crash while saving doesn't corrupt the previous snapshot
+	tmp, err := ioutil.TempFile(s.Dir, streamName+".snapshot.*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(snapshot); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(streamName))
+}
+
+func (s FileSnapshotStore) Load(_ context.Context, streamName string) ([]byte, error) {
+	b, err := ioutil.ReadFile(s.path(streamName))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSnapshot
+	}
+	return b, err
+}
+
+type SnapshotConfig struct {
+	Store    SnapshotStore
+	Interval time.Duration // Interval is the delay between two snapshots, the state is also saved when the provider is closed (default: 1 minute)
+}
+
+// GetAndWatchSnapshot persists the state of the provider in the store at every interval, and when the provider is closed.
+// The provider is created with the last snapshot of the store, so the sources of the state don't need
+// to replay all of it when the provider restarts.
+func GetAndWatchSnapshot(store SnapshotStore, interval time.Duration) GetAndWatchConfigOpt {
+	return func(p *GetAndWatchConfig) {
+		p.Snapshot = &SnapshotConfig{Store: store, Interval: interval}
+	}
+}
+
+// encodeSnapshot serializes the events as length delimited stream.StreamEvent
+func encodeSnapshot(events []*stream.Event) ([]byte, error) {
+	var buf bytes.Buffer
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, e := range events {
+		metadata, err := stream.EventMetadata(e)
+		if err != nil {
+			return nil, err
+		}
+		b, err := proto.Marshal(&stream.StreamEvent{Metadata: metadata, Key: e.Key, Value: e.Value})
+		if err != nil {
+			return nil, err
+		}
+		n := binary.PutUvarint(lenBuf, uint64(len(b)))
+		buf.Write(lenBuf[:n])
+		buf.Write(b)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSnapshot(snapshot []byte) ([]*stream.Event, error) {
+	var events []*stream.Event
+	r := bufio.NewReader(bytes.NewReader(snapshot))
+	for {
+		l, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, l)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("truncated snapshot: %w", err)
+		}
+		var se stream.StreamEvent
+		if err := proto.Unmarshal(b, &se); err != nil {
+			return nil, err
+		}
+		events = append(events, &stream.Event{
+			Ctx:     stream.Ctx(se.Metadata),
+			Key:     se.Key,
+			Value:   se.Value,
+			Headers: stream.MetadataHeaders(se.Metadata),
+		})
+	}
+}
+
+// restoreSnapshot submits the events of the last snapshot of the stream
+func (p *GetAndWatchStreamProvider) restoreSnapshot() {
+	c := p.config.Snapshot
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	snapshot, err := c.Store.Load(ctx, p.streamDef.Name)
+	if err == ErrNoSnapshot {
+		Log.Info("no snapshot to restore", zap.String("stream", p.streamDef.Name))
+		return
+	}
+	if err != nil {
+		Log.Error("could not load snapshot, the state starts empty", zap.String("stream", p.streamDef.Name), zap.Error(err))
+		return
+	}
+	events, err := decodeSnapshot(snapshot)
+	if err != nil {
+		Log.Error("invalid snapshot, the state starts empty", zap.String("stream", p.streamDef.Name), zap.Error(err))
+		return
+	}
+	for _, e := range events {
+		p.broadcaster.Submit(stateKey(e.Key), e)
+	}
+	Log.Info("snapshot restored", zap.String("stream", p.streamDef.Name), zap.Int("keys", len(events)))
+}
+
+// saveSnapshot persists the current state of the provider
+func (p *GetAndWatchStreamProvider) saveSnapshot() {
+	state := p.broadcaster.GetCurrentState()
+	events := make([]*stream.Event, 0, len(state))
+	for _, v := range state {
+		if e, ok := v.(*stream.Event); ok {
+			events = append(events, e)
+		}
+	}
+	snapshot, err := encodeSnapshot(events)
+	if err != nil {
+		Log.Error("could not encode snapshot", zap.String("stream", p.streamDef.Name), zap.Error(err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := p.config.Snapshot.Store.Save(ctx, p.streamDef.Name, snapshot); err != nil {
+		Log.Error("could not save snapshot", zap.String("stream", p.streamDef.Name), zap.Error(err))
+		return
+	}
+	Log.Debug("snapshot saved", zap.String("stream", p.streamDef.Name), zap.Int("keys", len(events)))
+}
+
+// snapshotLoop saves the state at every interval until the provider is closed, then saves it a last time
+func (p *GetAndWatchStreamProvider) snapshotLoop(stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	interval := p.config.Snapshot.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.saveSnapshot()
+		case <-stop:
+			p.saveSnapshot()
+			return
+		}
+	}
+}
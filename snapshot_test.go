@@ -0,0 +1,60 @@
+package gorillaz
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotEncoding(t *testing.T) {
+	e1 := &stream.Event{Ctx: context.Background(), Key: []byte("k1"), Value: []byte("v1")}
+	e1.SetEventTypeStr("position")
+	e1.SetEventTime(time.Unix(0, 42))
+	e1.SetHeader("source", "radar")
+	e2 := &stream.Event{Ctx: context.Background(), Key: []byte("k2"), Value: make([]byte, 1000)}
+
+	b, err := encodeSnapshot([]*stream.Event{e1, e2})
+	assert.Nil(t, err)
+	events, err := decodeSnapshot(b)
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "k1", string(events[0].Key))
+	assert.Equal(t, "v1", string(events[0].Value))
+	assert.Equal(t, "position", events[0].EventTypeStr())
+	assert.Equal(t, int64(42), stream.EventTimestamp(events[0]))
+	assert.Equal(t, "radar", events[0].Header("source"))
+	assert.Equal(t, 1000, len(events[1].Value))
+
+	_, err = decodeSnapshot(b[:len(b)-1])
+	assert.NotNil(t, err)
+	events, err = decodeSnapshot(nil)
+	assert.Nil(t, err)
+	assert.Len(t, events, 0)
+}
+
+func TestFileSnapshotStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := FileSnapshotStore{Dir: dir}
+	ctx := context.Background()
+
+	_, err = s.Load(ctx, "flights")
+	assert.Equal(t, ErrNoSnapshot, err)
+	assert.Nil(t, s.Save(ctx, "flights", []byte("first")))
+	assert.Nil(t, s.Save(ctx, "flights", []byte("second")))
+	b, err := s.Load(ctx, "flights")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(b))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
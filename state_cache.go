@@ -0,0 +1,297 @@
+package gorillaz
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// CacheEntry is the value of a key in a StateCache, along with its freshness.
+// The value is shared by the readers of the cache, it must not be modified.
+type CacheEntry struct {
+	Key             []byte
+	Value           []byte
+	StreamTimestamp int64     // StreamTimestamp is when the provider sent the value, in nanoseconds since Epoch
+	EventTimestamp  int64     // EventTimestamp is when the value was created, in nanoseconds since Epoch, 0 if unknown
+	ReceivedAt      time.Time // ReceivedAt is when the value was received by the cache
+	Seq             int       // Seq is the sequence of the value in the provider, 0 if unknown, see GetAndWatchStreamProvider.SubmitSeq
+
+	message proto.Message // message is the value decoded by a TypedStateCache, shared by its readers
+}
+
+// CacheUpdate is a change of a key in a StateCache
+type CacheUpdate struct {
+	Entry   CacheEntry
+	Deleted bool
+}
+
+type cacheEntry struct {
+	CacheEntry
+	// generation is the synchronization of the state which last received the entry
+	generation uint64
+}
+
+type cacheSubscriber struct {
+	prefix []byte
+	ch     chan CacheUpdate
+}
+
+// StateCache mirrors locally the state of a GetAndWatch stream, so that the services watching a state
+// don't need to maintain their own copy of it.
+// On reconnection the provider sends its state again, which is diffed with the cache: the keys it doesn't send anymore
+// are removed from the cache, and only the keys whose value changed are notified to the subscribers.
+type StateCache struct {
+	connections int64 // number of connections to the provider, accessed atomically
+	connected   int32 // 1 while connected to the provider, accessed atomically
+
+	streamName  string
+	clock       Clock
+	stop        func() bool
+	mu          sync.RWMutex
+	entries     map[string]*cacheEntry
+	generation  uint64
+	syncedConn  int64
+	resyncing   bool
+	lastEventAt time.Time
+	subscribers map[*cacheSubscriber]struct{}
+	changed     chan struct{} // changed is closed and replaced when an event is applied
+	done        chan struct{}
+
+	decode       func(value []byte) (proto.Message, error) // decode decodes the values on ingestion, nil to keep them encoded
+	decodeErrors prometheus.Counter
+}
+
+// NewStateCache watches the stream of the service and maintains its state locally
+func (g *Gaz) NewStateCache(service, streamName string, opts ...ConsumerConfigOpt) (*StateCache, error) {
+	c := &StateCache{clock: g.Clock()}
+	opts = append(opts, c.connectionHooks)
+	consumer, err := g.GetAndWatchStream(service, streamName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.init(consumer.StreamName(), consumer.EvtChan(), consumer.Stop)
+	return c, nil
+}
+
+// ConsumeStateCache watches the stream of the service endpoints and maintains its state locally
+func (g *Gaz) ConsumeStateCache(endpoints []string, streamName string, opts ...ConsumerConfigOpt) (*StateCache, error) {
+	c := &StateCache{clock: g.Clock()}
+	opts = append(opts, c.connectionHooks)
+	consumer, err := g.ConsumeGetAndWatchStream(endpoints, streamName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.init(consumer.StreamName(), consumer.EvtChan(), consumer.Stop)
+	return c, nil
+}
+
+// connectionHooks follows the connections of the consumer, keeping the hooks already configured
+func (c *StateCache) connectionHooks(config *ConsumerConfig) {
+	onConnected, onDisconnected := config.OnConnected, config.OnDisconnected
+	config.OnConnected = func(streamName string) {
+		atomic.AddInt64(&c.connections, 1)
+		atomic.StoreInt32(&c.connected, 1)
+		if onConnected != nil {
+			onConnected(streamName)
+		}
+	}
+	config.OnDisconnected = func(streamName string) {
+		atomic.StoreInt32(&c.connected, 0)
+		if onDisconnected != nil {
+			onDisconnected(streamName)
+		}
+	}
+}
+
+func (c *StateCache) init(streamName string, events <-chan *stream.GetAndWatchEvent, stop func() bool) {
+	c.streamName = streamName
+	c.stop = stop
+	c.entries = make(map[string]*cacheEntry)
+	c.subscribers = make(map[*cacheSubscriber]struct{})
+	c.changed = make(chan struct{})
+	c.done = make(chan struct{})
+	go func() {
+		defer c.closeSubscribers()
+		for evt := range events {
+			c.apply(evt)
+		}
+	}()
+}
+
+func (c *StateCache) apply(evt *stream.GetAndWatchEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.signalChange()
+	now := clockOrSystem(c.clock).Now()
+	c.lastEventAt = now
+
+	// a new connection means a new synchronization of the state
+	if conn := atomic.LoadInt64(&c.connections); conn != c.syncedConn {
+		c.syncedConn = conn
+		c.generation++
+		c.resyncing = true
+	}
+	if evt.EventType != stream.EventType_INITIAL_STATE && c.resyncing {
+		// the provider sent its whole state, the keys it didn't send were deleted while disconnected
+		c.resyncing = false
+		for k, e := range c.entries {
+			if e.generation < c.generation {
+				delete(c.entries, k)
+				c.notify(CacheUpdate{Entry: e.CacheEntry, Deleted: true})
+			}
+		}
+	}
+
+	key := string(evt.Key)
+	if evt.EventType == stream.EventType_DELETE {
+		if e, ok := c.entries[key]; ok {
+			delete(c.entries, key)
+			c.notify(CacheUpdate{Entry: e.CacheEntry, Deleted: true})
+		}
+		return
+	}
+	e := &cacheEntry{
+		CacheEntry: CacheEntry{
+			Key:        evt.Key,
+			Value:      evt.Value,
+			ReceivedAt: now,
+		},
+		generation: c.generation,
+	}
+	if evt.Metadata != nil {
+		e.StreamTimestamp = evt.Metadata.StreamTimestamp
+		e.EventTimestamp = evt.Metadata.EventTimestamp
+		e.Seq = stream.MetadataStreamSeq(evt.Metadata)
+	}
+	if c.decode != nil {
+		m, err := c.decode(evt.Value)
+		if err != nil {
+			c.decodeErrors.Inc()
+			Log.Debug("cannot decode state value", zap.String("stream", c.streamName), RedactedKey(evt.Key), zap.Error(err))
+		}
+		e.message = m
+	}
+	previous, ok := c.entries[key]
+	c.entries[key] = e
+	if ok && evt.EventType == stream.EventType_INITIAL_STATE && bytes.Equal(previous.Value, e.Value) {
+		// sent again on reconnection, unchanged
+		return
+	}
+	c.notify(CacheUpdate{Entry: e.CacheEntry})
+}
+
+// notify sends the update to the subscribers of its key, the subscribers not consuming fast enough miss it
+func (c *StateCache) notify(u CacheUpdate) {
+	for s := range c.subscribers {
+		if !bytes.HasPrefix(u.Entry.Key, s.prefix) {
+			continue
+		}
+		select {
+		case s.ch <- u:
+		default:
+			Log.Warn("state cache subscriber not consuming fast enough, update dropped", zap.String("stream", c.streamName), RedactedKey(u.Entry.Key))
+		}
+	}
+}
+
+func (c *StateCache) closeSubscribers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for s := range c.subscribers {
+		close(s.ch)
+		delete(c.subscribers, s)
+	}
+	close(c.done)
+}
+
+// Get returns the entry of the key
+func (c *StateCache) Get(key []byte) (CacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[string(key)]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	return e.CacheEntry, true
+}
+
+// List returns the entries whose key starts with keyPrefix, sorted by key. An empty prefix lists all the entries.
+func (c *StateCache) List(keyPrefix []byte) []CacheEntry {
+	c.mu.RLock()
+	entries := make([]CacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		if bytes.HasPrefix(e.Key, keyPrefix) {
+			entries = append(entries, e.CacheEntry)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+	return entries
+}
+
+// Snapshot returns a copy of all the entries, by key
+func (c *StateCache) Snapshot() map[string]CacheEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	snapshot := make(map[string]CacheEntry, len(c.entries))
+	for k, e := range c.entries {
+		snapshot[k] = e.CacheEntry
+	}
+	return snapshot
+}
+
+// Len returns the number of entries
+func (c *StateCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Subscribe returns a channel receiving the updates of the keys starting with keyPrefix, from now on.
+// Updates are dropped when the channel is full. The channel is closed when the cache is stopped or cancel is called.
+func (c *StateCache) Subscribe(keyPrefix []byte, bufLen int) (updates <-chan CacheUpdate, cancel func()) {
+	s := &cacheSubscriber{prefix: keyPrefix, ch: make(chan CacheUpdate, bufLen)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		close(s.ch)
+		return s.ch, func() {}
+	default:
+	}
+	c.subscribers[s] = struct{}{}
+	return s.ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.subscribers[s]; ok {
+			delete(c.subscribers, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Connected returns true while the cache is connected to the provider of the stream
+func (c *StateCache) Connected() bool {
+	return atomic.LoadInt32(&c.connected) == 1
+}
+
+// LastEventAt returns when the cache received its last event, zero if it received none
+func (c *StateCache) LastEventAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastEventAt
+}
+
+// Stop stops watching the stream, the entries stay available
+func (c *StateCache) Stop() {
+	c.stop()
+}
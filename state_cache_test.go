@@ -0,0 +1,126 @@
+package gorillaz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func gwEvent(eventType stream.EventType, key, value string) *stream.GetAndWatchEvent {
+	return &stream.GetAndWatchEvent{
+		EventType: eventType,
+		Key:       []byte(key),
+		Value:     []byte(value),
+		Metadata:  &stream.Metadata{StreamTimestamp: 42},
+	}
+}
+
+func TestStateCache(t *testing.T) {
+	events := make(chan *stream.GetAndWatchEvent)
+	c := &StateCache{}
+	config := &ConsumerConfig{}
+	c.connectionHooks(config)
+	c.init("flights", events, func() bool { return false })
+
+	config.OnConnected("flights")
+	assert.True(t, c.Connected())
+	c.apply(gwEvent(stream.EventType_INITIAL_STATE, "eu.af123", "1"))
+	c.apply(gwEvent(stream.EventType_INITIAL_STATE, "eu.lh456", "2"))
+	c.apply(gwEvent(stream.EventType_INITIAL_STATE, "us.aa789", "3"))
+
+	updates, cancel := c.Subscribe([]byte("eu."), 10)
+	c.apply(gwEvent(stream.EventType_UPDATE, "eu.af123", "4"))
+	c.apply(gwEvent(stream.EventType_UPDATE, "us.aa789", "5"))
+	c.apply(gwEvent(stream.EventType_DELETE, "eu.lh456", ""))
+
+	u := <-updates
+	assert.Equal(t, "eu.af123", string(u.Entry.Key))
+	assert.Equal(t, "4", string(u.Entry.Value))
+	u = <-updates
+	assert.Equal(t, "eu.lh456", string(u.Entry.Key))
+	assert.True(t, u.Deleted)
+
+	e, ok := c.Get([]byte("eu.af123"))
+	assert.True(t, ok)
+	assert.Equal(t, "4", string(e.Value))
+	assert.Equal(t, int64(42), e.StreamTimestamp)
+	assert.False(t, e.ReceivedAt.IsZero())
+	_, ok = c.Get([]byte("eu.lh456"))
+	assert.False(t, ok)
+	list := c.List(nil)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "eu.af123", string(list[0].Key))
+	assert.Equal(t, "us.aa789", string(list[1].Key))
+	assert.Len(t, c.List([]byte("us.")), 1)
+
+	// the provider does not send us.aa789 anymore after a reconnection
+	config.OnDisconnected("flights")
+	assert.False(t, c.Connected())
+	config.OnConnected("flights")
+	c.apply(gwEvent(stream.EventType_INITIAL_STATE, "eu.af123", "4"))
+	assert.Equal(t, 2, c.Len())
+	c.apply(gwEvent(stream.EventType_UPDATE, "eu.kl000", "6"))
+	assert.Equal(t, 2, c.Len())
+	_, ok = c.Get([]byte("us.aa789"))
+	assert.False(t, ok)
+	assert.True(t, time.Since(c.LastEventAt()) < time.Minute)
+
+	cancel()
+	_, open := <-updates
+	for open {
+		_, open = <-updates
+	}
+
+	close(events)
+	closed, _ := c.Subscribe(nil, 1)
+	for i := 0; i < 100; i++ {
+		select {
+		case _, open := <-closed:
+			assert.False(t, open)
+			return
+		default:
+			time.Sleep(10 * time.Millisecond)
+			closed, _ = c.Subscribe(nil, 1)
+		}
+	}
+	t.Error("the subscriptions of a stopped cache must be closed")
+}
+
+func TestStateCacheResyncDiff(t *testing.T) {
+	events := make(chan *stream.GetAndWatchEvent)
+	defer close(events)
+	c := &StateCache{}
+	config := &ConsumerConfig{}
+	c.connectionHooks(config)
+	c.init("aircrafts", events, func() bool { return false })
+
+	config.OnConnected("aircrafts")
+	c.apply(gwEvent(stream.EventType_INITIAL_STATE, "f-gkxa", "a320"))
+	c.apply(gwEvent(stream.EventType_INITIAL_STATE, "d-aiab", "a321"))
+	c.apply(gwEvent(stream.EventType_INITIAL_STATE, "g-euua", "a319"))
+	updates, cancel := c.Subscribe(nil, 10)
+	defer cancel()
+
+	config.OnDisconnected("aircrafts")
+	config.OnConnected("aircrafts")
+	c.apply(gwEvent(stream.EventType_INITIAL_STATE, "f-gkxa", "a320"))
+	c.apply(gwEvent(stream.EventType_INITIAL_STATE, "d-aiab", "a321neo"))
+	c.apply(gwEvent(stream.EventType_UPDATE, "f-gkxa", "a320neo"))
+
+	u := <-updates
+	assert.Equal(t, "d-aiab", string(u.Entry.Key), "the unchanged keys are not notified")
+	assert.Equal(t, "a321neo", string(u.Entry.Value))
+	u = <-updates
+	assert.Equal(t, "g-euua", string(u.Entry.Key))
+	assert.True(t, u.Deleted)
+	u = <-updates
+	assert.Equal(t, "f-gkxa", string(u.Entry.Key))
+	assert.Equal(t, "a320neo", string(u.Entry.Value))
+
+	snapshot := c.Snapshot()
+	assert.Len(t, snapshot, 2)
+	assert.Equal(t, "a320neo", string(snapshot["f-gkxa"].Value))
+	assert.Equal(t, "a321neo", string(snapshot["d-aiab"].Value))
+}
@@ -0,0 +1,82 @@
+package stream
+
+import "context"
+
+// Clone returns a deep copy of the event, which can be modified without affecting the original.
+// The context is shared as it is immutable.
+func (evt *Event) Clone() *Event {
+	if evt == nil {
+		return nil
+	}
+	c := &Event{
+		Ctx:     evt.Ctx,
+		Key:     cloneBytes(evt.Key),
+		Value:   cloneBytes(evt.Value),
+		AckFunc: evt.AckFunc,
+	}
+	if evt.Headers != nil {
+		c.Headers = make(map[string]string, len(evt.Headers))
+		for k, v := range evt.Headers {
+			c.Headers[k] = v
+		}
+	}
+	if evt.Error != nil {
+		e := *evt.Error
+		c.Error = &e
+	}
+	return c
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// EventView is a read-only view of an event, to share an event with several consumers without copying it upfront.
+// The getters return copies of the mutable parts of the event.
+type EventView struct {
+	evt *Event
+}
+
+// ReadOnly returns a read-only view of the event
+func (evt *Event) ReadOnly() EventView {
+	return EventView{evt: evt}
+}
+
+func (v EventView) Ctx() context.Context {
+	return v.evt.Ctx
+}
+
+func (v EventView) Key() []byte {
+	return cloneBytes(v.evt.Key)
+}
+
+func (v EventView) Value() []byte {
+	return cloneBytes(v.evt.Value)
+}
+
+func (v EventView) Header(name string) string {
+	return v.evt.Header(name)
+}
+
+func (v EventView) Error() *EventError {
+	if v.evt.Error == nil {
+		return nil
+	}
+	e := *v.evt.Error
+	return &e
+}
+
+// Ack acknowledges the underlying event
+func (v EventView) Ack() error {
+	return v.evt.Ack()
+}
+
+// Event returns a copy of the event that the caller is free to modify
+func (v EventView) Event() *Event {
+	return v.evt.Clone()
+}
@@ -0,0 +1,40 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	evt := &Event{Ctx: context.Background(), Key: []byte("key"), Value: []byte("value")}
+	evt.SetHeader("tenant", "acme")
+	evt.SetError(1, "failure")
+
+	c := evt.Clone()
+	c.Key[0] = 'K'
+	c.Value[0] = 'V'
+	c.SetHeader("tenant", "other")
+	c.Error.Message = "changed"
+
+	if !bytes.Equal(evt.Key, []byte("key")) || !bytes.Equal(evt.Value, []byte("value")) {
+		t.Errorf("the original key and value must not be modified, got %s %s", evt.Key, evt.Value)
+	}
+	if evt.Header("tenant") != "acme" {
+		t.Errorf("the original headers must not be modified, got %v", evt.Headers)
+	}
+	if evt.Error.Message != "failure" {
+		t.Errorf("the original error must not be modified, got %v", evt.Error)
+	}
+}
+
+func TestReadOnlyView(t *testing.T) {
+	evt := &Event{Ctx: context.Background(), Key: []byte("key"), Value: []byte("value")}
+	view := evt.ReadOnly()
+	view.Value()[0] = 'V'
+	view.Event().Key[0] = 'K'
+
+	if !bytes.Equal(evt.Key, []byte("key")) || !bytes.Equal(evt.Value, []byte("value")) {
+		t.Errorf("the event must not be modified through its view, got %s %s", evt.Key, evt.Value)
+	}
+}
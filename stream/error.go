@@ -0,0 +1,51 @@
+package stream
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// keys of the event error in the Metadata key values
+const errorCodeKey = "error.code"
+const errorMessageKey = "error.message"
+
+// EventError is a failure signaled by an event, typically a reply telling the request could not be processed
+type EventError struct {
+	Code    int32  // Code is an application defined error code
+	Message string // Message describes the error
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("event error %d: %s", e.Code, e.Message)
+}
+
+// ErrorEvent returns an event signaling a failure, without value
+func ErrorEvent(code int32, message string) *Event {
+	return &Event{Error: &EventError{Code: code, Message: message}}
+}
+
+// SetError marks the event as signaling a failure
+func (evt *Event) SetError(code int32, message string) {
+	evt.Error = &EventError{Code: code, Message: message}
+}
+
+// MetadataError returns the event error carried in metadata, nil if there is none
+func MetadataError(m *Metadata) *EventError {
+	if m == nil {
+		return nil
+	}
+	code, ok := m.KeyValue[errorCodeKey]
+	if !ok {
+		return nil
+	}
+	c, err := strconv.ParseInt(code, 10, 32)
+	if err != nil {
+		return &EventError{Message: m.KeyValue[errorMessageKey]}
+	}
+	return &EventError{Code: int32(c), Message: m.KeyValue[errorMessageKey]}
+}
+
+func setMetadataError(m *Metadata, e *EventError) {
+	m.KeyValue[errorCodeKey] = strconv.FormatInt(int64(e.Code), 10)
+	m.KeyValue[errorMessageKey] = e.Message
+}
@@ -0,0 +1,23 @@
+package stream
+
+import (
+	"context"
+	"testing"
+)
+
+func TestErrorSerialization(t *testing.T) {
+	evt := ErrorEvent(404, "unknown flight")
+	evt.Ctx = context.Background()
+
+	metadata, err := EventMetadata(evt)
+	if err != nil {
+		t.Fatalf("failed to create event metadata from event, %+v", err)
+	}
+	e := MetadataError(metadata)
+	if e == nil || e.Code != 404 || e.Message != "unknown flight" {
+		t.Errorf("unexpected event error %v", e)
+	}
+	if e := MetadataError(&Metadata{KeyValue: map[string]string{}}); e != nil {
+		t.Errorf("expected no event error but got %v", e)
+	}
+}
@@ -0,0 +1,36 @@
+package stream
+
+import "strings"
+
+// HeaderPrefix prefixes the event headers in the Metadata key values, so they are not mistaken for tracing headers
+const HeaderPrefix = "header."
+
+// SetHeader sets a custom attribute of the event, such as its tenant or source system
+func (evt *Event) SetHeader(name, value string) {
+	if evt.Headers == nil {
+		evt.Headers = make(map[string]string)
+	}
+	evt.Headers[name] = value
+}
+
+// Header returns the value of a custom attribute of the event, empty if it is not set
+func (evt *Event) Header(name string) string {
+	return evt.Headers[name]
+}
+
+// MetadataHeaders returns the event headers carried in metadata, nil if there is none
+func MetadataHeaders(m *Metadata) map[string]string {
+	if m == nil {
+		return nil
+	}
+	var headers map[string]string
+	for k, v := range m.KeyValue {
+		if strings.HasPrefix(k, HeaderPrefix) {
+			if headers == nil {
+				headers = make(map[string]string)
+			}
+			headers[strings.TrimPrefix(k, HeaderPrefix)] = v
+		}
+	}
+	return headers
+}
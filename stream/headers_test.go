@@ -0,0 +1,35 @@
+package stream
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHeadersSerialization(t *testing.T) {
+	evt := &Event{Ctx: context.Background()}
+	evt.SetHeader("tenant", "acme")
+	evt.SetHeader("source", "radar")
+
+	metadata, err := EventMetadata(evt)
+	if err != nil {
+		t.Fatalf("failed to create event metadata from event, %+v", err)
+	}
+	headers := MetadataHeaders(metadata)
+	if len(headers) != 2 || headers["tenant"] != "acme" || headers["source"] != "radar" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+	received := &Event{Ctx: Ctx(metadata), Headers: headers}
+	if received.Header("tenant") != "acme" {
+		t.Errorf("expected tenant header to be acme but is %s", received.Header("tenant"))
+	}
+}
+
+func TestNoHeaders(t *testing.T) {
+	metadata, err := EventMetadata(&Event{Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("failed to create event metadata from event, %+v", err)
+	}
+	if headers := MetadataHeaders(metadata); headers != nil {
+		t.Errorf("expected no headers but got %v", headers)
+	}
+}
@@ -0,0 +1,74 @@
+package stream
+
+import "strconv"
+
+// The lineage of an event is carried in headers, so it goes through the hops which forward the headers
+const (
+	LineageSourceStreamHeader = "Gorillaz-Lineage-Source-Stream"
+	LineageSourceSeqHeader    = "Gorillaz-Lineage-Source-Seq"
+	LineageHopsHeader         = "Gorillaz-Lineage-Hops"
+	LineageServiceHeader      = "Gorillaz-Lineage-Service"
+)
+
+// Lineage tells where an event of a multi-hop topology comes from
+type Lineage struct {
+	SourceStream string // SourceStream is the stream on which the event, or the event it is derived from, was first published
+	SourceSeq    int64  // SourceSeq is the sequence of the event in its source stream, 0 if unknown
+	Hops         int    // Hops is the number of streams, relays and bridges the event went through after its source stream
+	Service      string // Service is the last service which processed the event
+}
+
+// Lineage returns the lineage of the event, false if it has none
+func (evt *Event) Lineage() (Lineage, bool) {
+	source := evt.Header(LineageSourceStreamHeader)
+	if source == "" {
+		return Lineage{}, false
+	}
+	seq, _ := strconv.ParseInt(evt.Header(LineageSourceSeqHeader), 10, 64)
+	hops, _ := strconv.Atoi(evt.Header(LineageHopsHeader))
+	return Lineage{
+		SourceStream: source,
+		SourceSeq:    seq,
+		Hops:         hops,
+		Service:      evt.Header(LineageServiceHeader),
+	}, true
+}
+
+// SetLineage sets the lineage of the event
+func (evt *Event) SetLineage(l Lineage) {
+	evt.SetHeader(LineageSourceStreamHeader, l.SourceStream)
+	evt.SetHeader(LineageSourceSeqHeader, strconv.FormatInt(l.SourceSeq, 10))
+	evt.SetHeader(LineageHopsHeader, strconv.Itoa(l.Hops))
+	evt.SetHeader(LineageServiceHeader, l.Service)
+}
+
+// StampLineage returns a copy of the event, sharing its key and value, with its lineage updated by the service
+// which publishes it on the stream: the event without lineage gets the stream as source, if start is true,
+// the lineage of the other events is incremented by one hop.
+// It returns the event itself if its lineage is left unchanged.
+func (evt *Event) StampLineage(streamName string, seq int64, service string, start bool) *Event {
+	l, ok := evt.Lineage()
+	if !ok && !start {
+		return evt
+	}
+	if ok {
+		l.Hops++
+	} else {
+		l = Lineage{SourceStream: streamName, SourceSeq: seq}
+	}
+	l.Service = service
+	c := *evt
+	c.Headers = make(map[string]string, len(evt.Headers)+4)
+	for k, v := range evt.Headers {
+		c.Headers[k] = v
+	}
+	c.SetLineage(l)
+	return &c
+}
+
+// propagateLineage carries the lineage of the parent to the event derived from it
+func propagateLineage(e, parent *Event) {
+	if l, ok := parent.Lineage(); ok {
+		e.SetLineage(l)
+	}
+}
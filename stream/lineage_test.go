@@ -0,0 +1,37 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStampLineage(t *testing.T) {
+	e := &Event{Key: []byte("k")}
+	assert.True(t, e == e.StampLineage("orders", 0, "gateway", false), "no lineage is started")
+	_, ok := e.Lineage()
+	assert.False(t, ok)
+
+	source := e.StampLineage("orders", 7, "gateway", true)
+	_, ok = e.Lineage()
+	assert.False(t, ok, "the stamped event must not be modified")
+	l, ok := source.Lineage()
+	assert.True(t, ok)
+	assert.Equal(t, Lineage{SourceStream: "orders", SourceSeq: 7, Service: "gateway"}, l)
+
+	hop := source.Derive([]byte("k2"), nil).StampLineage("enriched-orders", 1, "enricher", true)
+	l, _ = hop.Lineage()
+	assert.Equal(t, Lineage{SourceStream: "orders", SourceSeq: 7, Hops: 1, Service: "enricher"}, l)
+}
+
+func TestLineageSerialization(t *testing.T) {
+	e := &Event{}
+	e.SetLineage(Lineage{SourceStream: "orders", SourceSeq: 7, Hops: 2, Service: "enricher"})
+	m, err := EventMetadata(e)
+	assert.Nil(t, err)
+
+	received := &Event{Ctx: Ctx(m), Headers: MetadataHeaders(m)}
+	l, ok := received.Lineage()
+	assert.True(t, ok)
+	assert.Equal(t, Lineage{SourceStream: "orders", SourceSeq: 7, Hops: 2, Service: "enricher"}, l)
+}
@@ -0,0 +1,66 @@
+package stream
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// Origin returns the time when the event was sent from the first producer of the pipeline in Epoch in nanoseconds:
+// its origin stream timestamp, or its stream timestamp if it was received from the first producer, 0 if it was never streamed
+func Origin(e *Event) int64 {
+	if e == nil {
+		return 0
+	}
+	if ts := OriginStreamTimestamp(e); ts > 0 {
+		return ts
+	}
+	return StreamTimestamp(e)
+}
+
+// PropagateOrigin carries the origin of the parents to the event derived from them, the oldest one if there are several parents.
+// The origin stream timestamp already set on the event is kept.
+// Without it, the event is considered as sent from the first producer when it is streamed, and its origin delay
+// only measures the last hop of the pipeline.
+func PropagateOrigin(e *Event, parents ...*Event) {
+	if OriginStreamTimestamp(e) > 0 {
+		return
+	}
+	var origin int64
+	for _, p := range parents {
+		if ts := Origin(p); ts > 0 && (origin == 0 || ts < origin) {
+			origin = ts
+		}
+	}
+	if origin == 0 {
+		return
+	}
+	if e.Ctx == nil {
+		e.Ctx = context.Background()
+	}
+	e.Ctx = context.WithValue(e.Ctx, originStreamTimestampNs, origin)
+}
+
+// Derive returns a new event with the key and the value, derived from the event: it carries its origin, its event time,
+// its deadline, its tracing span and its lineage. The other attributes, such as the headers or the event type, are not carried.
+func (evt *Event) Derive(key, value []byte) *Event {
+	d := &Event{
+		Ctx:   context.Background(),
+		Key:   key,
+		Value: value,
+	}
+	if evt.Ctx != nil {
+		if ts := EventTimestamp(evt); ts > 0 {
+			d.Ctx = context.WithValue(d.Ctx, eventTimeNs, ts)
+		}
+		if deadline, ok := evt.Deadline(); ok {
+			d.Ctx = context.WithValue(d.Ctx, deadlineKey, deadline)
+		}
+		if sp := opentracing.SpanFromContext(evt.Ctx); sp != nil {
+			d.Ctx = opentracing.ContextWithSpan(d.Ctx, sp)
+		}
+	}
+	PropagateOrigin(d, evt)
+	propagateLineage(d, evt)
+	return d
+}
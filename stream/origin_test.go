@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropagateOrigin(t *testing.T) {
+	first := &Event{}
+	first.SetStreamTime(time.Unix(0, 300))
+	second := &Event{}
+	second.SetStreamTime(time.Unix(0, 500))
+	second.SetOriginStreamTime(time.Unix(0, 200))
+
+	e := &Event{Key: []byte("k")}
+	PropagateOrigin(e, first, second, nil)
+	assert.Equal(t, int64(200), OriginStreamTimestamp(e), "the oldest origin is propagated")
+
+	PropagateOrigin(e, first)
+	assert.Equal(t, int64(200), OriginStreamTimestamp(e), "the origin of the event is kept")
+
+	unstreamed := &Event{}
+	PropagateOrigin(unstreamed, &Event{})
+	assert.Nil(t, unstreamed.Ctx)
+}
+
+func TestOriginSurvivesSerialization(t *testing.T) {
+	received := &Event{Ctx: Ctx(&Metadata{StreamTimestamp: 1000, OriginStreamTimestamp: 0, EventTimestamp: 10})}
+	derived := received.Derive([]byte("k2"), []byte("v2"))
+	assert.Equal(t, int64(1000), OriginStreamTimestamp(derived))
+	assert.Equal(t, int64(10), EventTimestamp(derived))
+
+	m, err := EventMetadata(derived)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), m.OriginStreamTimestamp)
+	assert.True(t, m.StreamTimestamp > 1000)
+}
+
+func TestDerive(t *testing.T) {
+	deadline := time.Now().Add(time.Minute).UnixNano()
+	parent := &Event{Ctx: context.WithValue(context.Background(), deadlineKey, deadline), Headers: map[string]string{"h": "v"}}
+	parent.SetOriginStreamTime(time.Unix(0, 42))
+	parent.SetEventTypeStr("parent")
+
+	d := parent.Derive([]byte("k"), []byte("v"))
+	assert.Equal(t, []byte("k"), d.Key)
+	assert.Equal(t, []byte("v"), d.Value)
+	assert.Equal(t, int64(42), OriginStreamTimestamp(d))
+	dl, ok := d.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, deadline, dl)
+	assert.Equal(t, "", d.EventTypeStr())
+	assert.Nil(t, d.Headers)
+}
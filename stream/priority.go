@@ -0,0 +1,56 @@
+package stream
+
+import (
+	"context"
+	"strconv"
+)
+
+// Priority of an event, providers deliver the events of higher priority before the others
+type Priority int32
+
+const (
+	PriorityNormal Priority = 0 // PriorityNormal is the priority of bulk data
+	PriorityHigh   Priority = 1 // PriorityHigh is the priority of control and alert events
+)
+
+// key of the event priority in the Metadata key values, absent for PriorityNormal
+const priorityKey = "priority"
+
+const priorityCtxKey = key("priority")
+
+// SetPriority sets the priority of the event
+func (evt *Event) SetPriority(p Priority) {
+	if evt.Ctx == nil {
+		evt.Ctx = context.Background()
+	}
+	evt.Ctx = context.WithValue(evt.Ctx, priorityCtxKey, p)
+}
+
+// Priority returns the priority of the event, PriorityNormal if it is not set
+func (evt *Event) Priority() Priority {
+	if evt.Ctx == nil {
+		return PriorityNormal
+	}
+	if p, ok := evt.Ctx.Value(priorityCtxKey).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// MetadataPriority returns the event priority carried in metadata, PriorityNormal if there is none
+func MetadataPriority(m *Metadata) Priority {
+	if m == nil {
+		return PriorityNormal
+	}
+	p, err := strconv.ParseInt(m.KeyValue[priorityKey], 10, 32)
+	if err != nil {
+		return PriorityNormal
+	}
+	return Priority(p)
+}
+
+func setMetadataPriority(m *Metadata, p Priority) {
+	if p != PriorityNormal {
+		m.KeyValue[priorityKey] = strconv.FormatInt(int64(p), 10)
+	}
+}
@@ -0,0 +1,33 @@
+package stream
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPrioritySerialization(t *testing.T) {
+	evt := &Event{Ctx: context.Background()}
+	if p := evt.Priority(); p != PriorityNormal {
+		t.Errorf("expected normal priority by default but got %d", p)
+	}
+	metadata, err := EventMetadata(evt)
+	if err != nil {
+		t.Fatalf("failed to create event metadata from event, %+v", err)
+	}
+	if _, ok := metadata.KeyValue[priorityKey]; ok {
+		t.Errorf("the normal priority must not be streamed")
+	}
+
+	evt.SetPriority(PriorityHigh)
+	metadata, err = EventMetadata(evt)
+	if err != nil {
+		t.Fatalf("failed to create event metadata from event, %+v", err)
+	}
+	if p := MetadataPriority(metadata); p != PriorityHigh {
+		t.Errorf("expected high priority in metadata but got %d", p)
+	}
+	received := &Event{Ctx: Ctx(metadata)}
+	if p := received.Priority(); p != PriorityHigh {
+		t.Errorf("expected high priority on the received event but got %d", p)
+	}
+}
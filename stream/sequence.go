@@ -0,0 +1,25 @@
+package stream
+
+import "strconv"
+
+// key of the stream sequence in the Metadata key values, absent if the event has no sequence
+const streamSeqMetadataKey = "stream_seq"
+
+// MetadataStreamSeq returns the stream sequence carried in metadata, 0 if there is none
+func MetadataStreamSeq(m *Metadata) int {
+	if m == nil {
+		return 0
+	}
+	seq, err := strconv.Atoi(m.KeyValue[streamSeqMetadataKey])
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// SetMetadataStreamSeq sets the stream sequence carried in metadata, the providers replaying their events number them
+func SetMetadataStreamSeq(m *Metadata, seq int) {
+	if seq > 0 {
+		m.KeyValue[streamSeqMetadataKey] = strconv.Itoa(seq)
+	}
+}
@@ -0,0 +1,25 @@
+package stream
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStreamSeqSerialization(t *testing.T) {
+	metadata, err := EventMetadata(&Event{Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("failed to create event metadata from event, %+v", err)
+	}
+	if seq := MetadataStreamSeq(metadata); seq != 0 {
+		t.Errorf("expected no sequence by default but got %d", seq)
+	}
+
+	SetMetadataStreamSeq(metadata, 42)
+	if seq := MetadataStreamSeq(metadata); seq != 42 {
+		t.Errorf("expected sequence 42 in metadata but got %d", seq)
+	}
+	received := &Event{Ctx: Ctx(metadata)}
+	if seq := received.StreamSeq(); seq != 42 {
+		t.Errorf("expected sequence 42 on the received event but got %d", seq)
+	}
+}
@@ -9,6 +9,8 @@ type Event struct {
 	Ctx        context.Context
 	Key, Value []byte
 	AckFunc    func() error
+	Headers    map[string]string // Headers are custom attributes streamed along with the event
+	Error      *EventError       // Error is set if the event signals a failure
 }
 
 func (e *Event) Ack() error {
@@ -33,6 +35,7 @@ const subjectKey = key("subject")
 const streamKey = key("stream")
 const consumerSeqKey = key("consumerSeq")
 const streamSeqKey = key("streamSeq")
+const msgIdKey = key("msgId")
 
 // StreamTimestamp returns the time when the event was sent from the producer in Epoch in nanoseconds
 func StreamTimestamp(e *Event) int64 {
@@ -288,3 +291,22 @@ func (evt *Event) Stream() string {
 	}
 	return ""
 }
+
+// SetMsgId sets the Nats message id of the event, used by Jetstream to discard duplicates
+func (evt *Event) SetMsgId(id string) {
+	if evt.Ctx == nil {
+		evt.Ctx = context.Background()
+	}
+	evt.Ctx = context.WithValue(evt.Ctx, msgIdKey, id)
+}
+
+// MsgId returns the Nats message id of the event, empty if it was published without id
+func (evt *Event) MsgId() string {
+	if evt.Ctx == nil {
+		return ""
+	}
+	if id, ok := evt.Ctx.Value(msgIdKey).(string); ok {
+		return id
+	}
+	return ""
+}
@@ -178,3 +178,111 @@ var _Stream_serviceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "stream.proto",
 }
+
+// AckClient is the client API for Ack service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AckClient interface {
+	// Streams the events to a consumer acknowledging them, the events not acknowledged are sent again
+	AckStream(ctx context.Context, opts ...grpc.CallOption) (Ack_AckStreamClient, error)
+}
+
+type ackClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAckClient(cc grpc.ClientConnInterface) AckClient {
+	return &ackClient{cc}
+}
+
+func (c *ackClient) AckStream(ctx context.Context, opts ...grpc.CallOption) (Ack_AckStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Ack_serviceDesc.Streams[0], "/stream.Ack/AckStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ackAckStreamClient{stream}
+	return x, nil
+}
+
+type Ack_AckStreamClient interface {
+	Send(*AckRequest) error
+	Recv() (*StreamEvent, error)
+	grpc.ClientStream
+}
+
+type ackAckStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *ackAckStreamClient) Send(m *AckRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *ackAckStreamClient) Recv() (*StreamEvent, error) {
+	m := new(StreamEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AckServer is the server API for Ack service.
+// All implementations should embed UnimplementedAckServer
+// for forward compatibility
+type AckServer interface {
+	// Streams the events to a consumer acknowledging them, the events not acknowledged are sent again
+	AckStream(Ack_AckStreamServer) error
+}
+
+// UnimplementedAckServer should be embedded to have forward compatible implementations.
+type UnimplementedAckServer struct {
+}
+
+func (*UnimplementedAckServer) AckStream(Ack_AckStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method AckStream not implemented")
+}
+
+func RegisterAckServer(s *grpc.Server, srv AckServer) {
+	s.RegisterService(&_Ack_serviceDesc, srv)
+}
+
+func _Ack_AckStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AckServer).AckStream(&ackAckStreamServer{stream})
+}
+
+type Ack_AckStreamServer interface {
+	Send(*StreamEvent) error
+	Recv() (*AckRequest, error)
+	grpc.ServerStream
+}
+
+type ackAckStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *ackAckStreamServer) Send(m *StreamEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *ackAckStreamServer) Recv() (*AckRequest, error) {
+	m := new(AckRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _Ack_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "stream.Ack",
+	HandlerType: (*AckServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AckStream",
+			Handler:       _Ack_AckStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "stream.proto",
+}
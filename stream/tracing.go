@@ -77,6 +77,13 @@ func EventMetadata(e *Event) (*Metadata, error) {
 	metadata.EventType = eventType
 	metadata.EventTypeVersion = eventTypeVersion
 	metadata.Deadline = ts
+	for k, v := range e.Headers {
+		metadata.KeyValue[HeaderPrefix+k] = v
+	}
+	if e.Error != nil {
+		setMetadataError(metadata, e.Error)
+	}
+	setMetadataPriority(metadata, e.Priority())
 
 	if ctx == nil {
 		ctx = context.Background()
@@ -103,6 +110,12 @@ func Ctx(metadata *Metadata) context.Context {
 	ctx = context.WithValue(ctx, eventTypeKey, metadata.EventType)
 	ctx = context.WithValue(ctx, eventTypeVersionKey, metadata.EventTypeVersion)
 	ctx = context.WithValue(ctx, deadlineKey, metadata.Deadline)
+	if p := MetadataPriority(metadata); p != PriorityNormal {
+		ctx = context.WithValue(ctx, priorityCtxKey, p)
+	}
+	if seq := MetadataStreamSeq(metadata); seq > 0 {
+		ctx = context.WithValue(ctx, streamSeqKey, seq)
+	}
 
 	spCtx, _ := opentracing.GlobalTracer().Extract(opentracing.TextMap, metadata)
 
@@ -116,3 +129,30 @@ func Ctx(metadata *Metadata) context.Context {
 	ctx = opentracing.ContextWithSpan(ctx, span)
 	return ctx
 }
+
+// SpanContext returns the span context carried by the event, nil if there is none
+func (evt *Event) SpanContext() opentracing.SpanContext {
+	if evt.Ctx == nil {
+		return nil
+	}
+	if sp := opentracing.SpanFromContext(evt.Ctx); sp != nil {
+		return sp.Context()
+	}
+	return nil
+}
+
+// StartSpan starts a span child of the span carried by the event, or a root span if there is none.
+// The event context is updated with the new span, so events created from this event are traced as its children.
+// The caller must finish the span.
+func (evt *Event) StartSpan(name string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	if sc := evt.SpanContext(); sc != nil {
+		opts = append(opts, opentracing.ChildOf(sc))
+	}
+	span := opentracing.StartSpan(name, opts...)
+	ctx := evt.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	evt.Ctx = opentracing.ContextWithSpan(ctx, span)
+	return span
+}
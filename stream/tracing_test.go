@@ -46,3 +46,30 @@ func TestTracingSerialization(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestStartSpanFromEvent(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+
+	parent := opentracing.StartSpan("parent")
+	evt := &Event{Ctx: opentracing.ContextWithSpan(context.Background(), parent)}
+
+	span := evt.StartSpan("handler")
+	span.Finish()
+	parent.Finish()
+
+	child := span.(*mocktracer.MockSpan)
+	if child.ParentID != parent.(*mocktracer.MockSpan).SpanContext.SpanID {
+		t.Errorf("the span must be a child of the event span")
+	}
+	evtSpan, ok := evt.SpanContext().(mocktracer.MockSpanContext)
+	if !ok || evtSpan.TraceID != child.SpanContext.TraceID || evtSpan.SpanID != child.SpanContext.SpanID {
+		t.Errorf("the event must carry the new span")
+	}
+
+	root := (&Event{}).StartSpan("root")
+	root.Finish()
+	if root.(*mocktracer.MockSpan).ParentID != 0 {
+		t.Errorf("the span of an event without span must be a root span")
+	}
+}
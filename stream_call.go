@@ -0,0 +1,56 @@
+package gorillaz
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// WithStreamMetadata sends md in the metadata of the stream requests, for instance an auth token or a tenant id,
+// the provider reads it with metadata.FromIncomingContext on the context of the stream
+func WithStreamMetadata(md metadata.MD) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.Metadata = metadata.Join(c.Metadata, md)
+	}
+}
+
+// WithStreamMetadataFunc calls f before each connection attempt and sends the metadata returned in the stream request,
+// for instance a short-lived token refreshed when the consumer reconnects.
+// If f returns an error, the attempt fails and the consumer retries after the reconnection backoff.
+func WithStreamMetadataFunc(f func() (metadata.MD, error)) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.MetadataFunc = f
+	}
+}
+
+// WithConnectTimeout abandons a connection attempt when the stream is not established within d, the consumer retries
+// after the reconnection backoff. It only limits the establishment of the stream, not the stream once connected.
+func WithConnectTimeout(d time.Duration) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.ConnectTimeout = d
+	}
+}
+
+// callMetadata returns the metadata of the application to send in the stream request
+func (c *ConsumerConfig) callMetadata() (metadata.MD, error) {
+	if c.MetadataFunc == nil {
+		return c.Metadata, nil
+	}
+	md, err := c.MetadataFunc()
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Join(c.Metadata, md), nil
+}
+
+// connectDeadline cancels the connection attempt of ctx if it is not established within the connect timeout.
+// established must be called once the stream is established, and cancel once the stream is done.
+func (c *ConsumerConfig) connectDeadline(ctx context.Context) (_ context.Context, cancel context.CancelFunc, established func()) {
+	if c.ConnectTimeout <= 0 {
+		return ctx, func() {}, func() {}
+	}
+	ctx, cancel = context.WithCancel(ctx)
+	t := time.AfterFunc(c.ConnectTimeout, cancel)
+	return ctx, cancel, func() { t.Stop() }
+}
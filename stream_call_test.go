@@ -0,0 +1,102 @@
+package gorillaz
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCallMetadata(t *testing.T) {
+	c := &ConsumerConfig{}
+	WithStreamMetadata(metadata.Pairs("tenant-id", "acme"))(c)
+	md, err := c.callMetadata()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"acme"}, md.Get("tenant-id"))
+
+	WithStreamMetadataFunc(func() (metadata.MD, error) {
+		return metadata.Pairs("authorization", "Bearer token"), nil
+	})(c)
+	md, err = c.callMetadata()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"acme"}, md.Get("tenant-id"))
+	assert.Equal(t, []string{"Bearer token"}, md.Get("authorization"))
+
+	WithStreamMetadataFunc(func() (metadata.MD, error) {
+		return nil, errors.New("no token")
+	})(c)
+	_, err = c.callMetadata()
+	assert.Error(t, err)
+}
+
+func TestConnectDeadline(t *testing.T) {
+	c := &ConsumerConfig{}
+	WithConnectTimeout(50 * time.Millisecond)(c)
+
+	ctx, cancel, _ := c.connectDeadline(context.Background())
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the attempt is not cancelled after the connect timeout")
+	}
+
+	ctx, cancel, established := c.connectDeadline(context.Background())
+	defer cancel()
+	established()
+	select {
+	case <-ctx.Done():
+		t.Fatal("the established stream is cancelled")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// without timeout, the context of the attempt is the parent context
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel, _ = (&ConsumerConfig{}).connectDeadline(parent)
+	cancel()
+	assert.NoError(t, ctx.Err())
+	cancelParent()
+	assert.Error(t, ctx.Err())
+}
+
+func TestStreamMetadata(t *testing.T) {
+	var mu sync.Mutex
+	var received metadata.MD
+	interceptor := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod == "/stream.Stream/Stream" {
+			md, _ := metadata.FromIncomingContext(ss.Context())
+			mu.Lock()
+			received = md
+			mu.Unlock()
+		}
+		return handler(srv, ss)
+	}
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery(), WithGrpcServerOptions(grpc.StreamInterceptor(interceptor)))
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("stream-metadata", "dummy.type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := g.DiscoverAndConsumeServiceStream("does not matter", "stream-metadata", WithStreamMetadata(metadata.Pairs("tenant-id", "acme")), WithConnectTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+	waitUntil(t, 5*time.Second, "consumer connected", func() bool {
+		return len(g.StreamConsumers()["stream-metadata"]) == 1
+	})
+
+	provider.Submit(&stream.Event{Key: []byte("k"), Value: []byte("v")})
+	assertReceived(t, "stream-metadata", consumer.EvtChan(), &stream.Event{Key: []byte("k"), Value: []byte("v")})
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"acme"}, received.Get("tenant-id"))
+}
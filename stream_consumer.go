@@ -2,8 +2,11 @@ package gorillaz
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
 	"math"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -11,14 +14,16 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/mux"
 	"github.com/skysoft-atm/gorillaz/stream"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
-	"google.golang.org/grpc/encoding/gzip"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -34,6 +39,9 @@ const (
 	StreamConsumerDelayMs                = "stream_consumer_delay_ms"
 	StreamConsumerOriginDelayMs          = "stream_consumer_origin_delay_ms"
 	StreamConsumerEventDelayMs           = "stream_consumer_event_delay_ms"
+	StreamConsumerDroppedEvents          = "stream_consumer_dropped_events"
+	StreamConsumerLastEventAge           = "stream_consumer_last_event_age_seconds"
+	StreamConsumerLostStateUpdates       = "stream_consumer_lost_state_updates"
 )
 
 const StreamEndpointsLabel = "endpoints"
@@ -42,24 +50,77 @@ type ConsumerConfig struct {
 	BufferLen                int // BufferLen is the size of the channel of the consumer
 	OnConnected              func(streamName string)
 	OnDisconnected           func(streamName string)
-	UseGzip                  bool
+	UseGzip                  bool // UseGzip compresses the stream with gzip, like WithCompression(gzip.Name) (default: false)
 	DisconnectOnBackpressure bool
+	Validator                Validator                   // Validator rejects the invalid events received, they are not delivered
+	SampleEvery              int                         // SampleEvery asks the provider to send only 1 event out of SampleEvery (default: 0, every event)
+	SampleMaxRate            float64                     // SampleMaxRate asks the provider to send at most SampleMaxRate events per second (default: 0, unlimited)
+	Decryption               KeyProvider                 // Decryption decrypts the values of the encrypted events received (default: nil, values delivered as received)
+	EventTypeMetrics         []string                    // EventTypeMetrics are the event types broken down in the metrics, the others are counted together (default: nil, no breakdown)
+	DeltaEncoding            bool                        // DeltaEncoding asks the provider of a GetAndWatch stream to send the updates as deltas, see WithDeltaEncoding
+	WatchKeys                [][]byte                    // WatchKeys restricts the consumer to these keys, see WatchKeys (default: nil, all the keys)
+	WatchKeyPrefixes         [][]byte                    // WatchKeyPrefixes restricts the consumer to the keys with these prefixes, see WatchKeyPrefixes (default: nil, all the keys)
+	KeyExpression            string                      // KeyExpression restricts the consumer to the keys matching this regular expression, see WithKeyExpression (default: empty, all the keys)
+	OrderingCheck            *OrderingCheckConfig        // OrderingCheck verifies that the events are received in order (default: nil, not checked)
+	Backpressure             BackpressureStrategy        // Backpressure is what the consumer does when its channel is full, see WithBackpressure (default: BackpressureBlock)
+	KeepMetrics              bool                        // KeepMetrics keeps the metrics of the stream once its last consumer stops, they are reused instead of reset if it is consumed again (default: false, unregistered)
+	ReconnectBackoff         ReconnectBackoff            // ReconnectBackoff is how long the consumer waits before reconnecting after a failure (default: from 1 sec to 5 sec, unlimited attempts)
+	ErrorEvents              bool                        // ErrorEvents emits the failures on ErrChan instead of closing EvtChan, see WithErrorEvents (default: false)
+	MaxEventSize             int                         // MaxEventSize drops the events received whose marshalled size exceeds it, see WithMaxEventSize (default: 0, unlimited)
+	StartSequence            int                         // StartSequence asks the provider to replay the events from this sequence, see WithStartSequence (default: 0, new events only)
+	StartTime                time.Time                   // StartTime asks the provider to replay the events streamed since this time, see WithStartTime (default: zero, new events only)
+	Ack                      bool                        // Ack consumes the stream with acknowledgements, the events must be acknowledged, see WithAck (default: false)
+	AckConsumerId            string                      // AckConsumerId identifies the consumer WithAck across its restarts, see WithAckConsumerId (default: empty, a random id per consumer)
+	Metadata                 metadata.MD                 // Metadata is sent in the stream requests, see WithStreamMetadata (default: nil)
+	MetadataFunc             func() (metadata.MD, error) // MetadataFunc returns metadata sent in the stream request of each connection attempt, see WithStreamMetadataFunc (default: nil)
+	Interceptors             []ConsumerInterceptor       // Interceptors wrap the delivery of the events, see WithInterceptors (default: nil)
+	Compression              []string                    // Compression are the compressions accepted, in order of preference, see WithCompression (default: nil, gzip if UseGzip, uncompressed otherwise)
+	ConnectTimeout           time.Duration               // ConnectTimeout abandons a connection attempt if the stream is not established in time, see WithConnectTimeout (default: 0, no timeout)
 }
 
 type StreamEndpointConfig struct {
-	backoffMaxDelay time.Duration
+	backoffMaxDelay   time.Duration
+	tls               *tls.Config                   // tls is the configuration of the TLS connections to the providers, nil to connect without TLS
+	tlsKey            string                        // tlsKey identifies the TLS configuration, the connections are shared between the endpoints with the same one
+	keepalive         keepalive.ClientParameters    // keepalive is how the dead connections to the providers are detected (default: ping every 15 sec)
+	zoneAware         bool                          // zoneAware prefers the providers in the zone of the service, see EndpointZoneAware
+	connections       int                           // connections is the number of connections the streams are spread over, see EndpointConnections (default: 1)
+	endpointType      EndpointType                  // endpointType is how the endpoints are resolved, see WithEndpointType (default: IPEndpoint)
+	dnsAddr           string                        // dnsAddr is the DNS server resolving the DNS and SRV endpoints, see SetDNSAddr (default: the DNS of the system)
+	err               error                         // err is the error of an option, such as a certificate that could not be loaded
+	perRPCCredentials credentials.PerRPCCredentials // perRPCCredentials authenticate the stream requests, see WithPerRPCCredentials
+	interceptors      []ConsumerInterceptor         // interceptors wrap the delivery of the events of all the consumers, see EndpointInterceptors
 }
 
 type StreamConsumer interface {
 	streamConsumer
+	// EvtChan returns the channel of the events, it is closed by gorillaz when the consumer stops and must not be closed by the application
 	EvtChan() chan *stream.Event
 	Stop() bool //return previous 'stopped' state
+	// Err returns the error that stopped the consumer, such as ErrEvtChanClosed, nil if it has not failed
+	Err() error
+	// Broadcast submits the events to the broadcaster, see BridgeConfig
+	Broadcast(b *mux.Broadcaster, opts ...BridgeOpt) (stop func())
+	// Handle calls the handler with the events, see HandlerConfig
+	Handle(h EventHandler, opts ...HandlerOpt) (stop func())
+	// Capabilities returns the capabilities negotiated with the provider of the stream, version 0 before the first connection
+	Capabilities() Capabilities
+	// LastEventTime returns when the last event of the stream was received, the zero time if none was received
+	LastEventTime() time.Time
+	// ErrChan returns the channel of the failures of a consumer created WithErrorEvents, nil otherwise
+	ErrChan() <-chan *ConsumerErrorEvent
+	// Resubscribe consumes the stream again after a failure emitted on ErrChan, it returns false if the consumer is not waiting for it
+	Resubscribe() bool
+	// LastSequence returns the sequence of the last event delivered, 0 if none was delivered or the provider does not number its events.
+	// The consumer resumes from the following one when it reconnects, see ProviderReplay.
+	LastSequence() int
 }
 
 type streamConsumer interface {
 	metrics() *consumerMetrics
 	StreamName() string
 	streamEndpoint() *streamEndpoint
+	clientConn() *grpc.ClientConn
 }
 
 type StoppableStream interface {
@@ -84,18 +145,44 @@ func (c *registeredConsumer) Stop() bool {
 }
 
 type consumer struct {
-	endpoint   *streamEndpoint
-	streamName string
-	evtChan    chan *stream.Event
-	config     *ConsumerConfig
-	stopped    *int32
-	cMetrics   *consumerMetrics
+	endpoint     *streamEndpoint
+	conn         *grpc.ClientConn // conn is the connection of the endpoint the stream is consumed on
+	streamName   string
+	evtChan      chan *stream.Event
+	config       *ConsumerConfig
+	stopped      *int32
+	done         chan struct{} // done is closed when the consumer is stopped
+	cMetrics     *consumerMetrics
+	tMetrics     *eventTypeMetrics
+	traffic      *trafficMetrics
+	ordering     *OrderingChecker
+	guard        *evtChanGuard
+	backoff      *reconnectBackoff
+	peerCaps     *peerCapabilities
+	errEvents    *errorEvents
+	lastSeq      int64 // lastSeq is the sequence of the last event delivered
+	authRetried  bool  // authRetried is true after retrying with a refreshed token, until the stream connects
+	interceptors *interceptorChain
+	compression  *compressionNegotiation
+	ackSession   string // ackSession identifies the consumer across its reconnections, see WithAck
 }
 
 func (c *consumer) streamEndpoint() *streamEndpoint {
 	return c.endpoint
 }
 
+func (c *consumer) clientConn() *grpc.ClientConn {
+	return c.conn
+}
+
+func (c *consumer) LastEventTime() time.Time {
+	return c.cMetrics.lastEventTime()
+}
+
+func (c *consumer) Capabilities() Capabilities {
+	return c.peerCaps.get()
+}
+
 func (c *consumer) StreamName() string {
 	return c.streamName
 }
@@ -104,8 +191,60 @@ func (c *consumer) EvtChan() chan *stream.Event {
 	return c.evtChan
 }
 
+func (c *consumer) Err() error {
+	return c.guard.Err()
+}
+
+func (c *consumer) ErrChan() <-chan *ConsumerErrorEvent {
+	return c.errEvents.errChan()
+}
+
+func (c *consumer) Resubscribe() bool {
+	return c.errEvents.requestResubscribe()
+}
+
+func (c *consumer) LastSequence() int {
+	return int(atomic.LoadInt64(&c.lastSeq))
+}
+
+// startPosition returns the position the events are replayed from: the event following the last one delivered,
+// or the start position of the configuration if none was delivered
+func (c *consumer) startPosition() (int, time.Time) {
+	if last := c.LastSequence(); last > 0 {
+		return last + 1, time.Time{}
+	}
+	return c.config.StartSequence, c.config.StartTime
+}
+
+// send delivers the event to the application, it returns false if the application closed the event channel
+func (c *consumer) send(evt *stream.Event) (sent bool) {
+	defer c.guard.recoverClosed(c.streamName)
+	c.evtChan <- evt
+	return true
+}
+
+func (c *consumer) closeEvtChan() {
+	defer c.guard.recoverClosed(c.streamName)
+	close(c.evtChan)
+}
+
+// checkEventSize returns false if the event received exceeds the maximum event size of the consumer
+func (c *consumer) checkEventSize(size int, key []byte) bool {
+	if err := checkEventSize(size, c.config.MaxEventSize); err != nil {
+		Log.Warn("oversize event not delivered", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), RedactedKey(key), zap.Error(err))
+		oversizeEventsCounter(c.endpoint.g, StreamConsumerOversizeEvents, StreamNameLabel, c.streamName).Inc()
+		return false
+	}
+	return true
+}
+
 func (c *consumer) Stop() bool {
-	return atomic.SwapInt32(c.stopped, 1) == 1
+	if atomic.SwapInt32(c.stopped, 1) == 1 {
+		return true
+	}
+	close(c.done)
+	c.errEvents.stopped()
+	return false
 }
 
 func (c *consumer) isStopped() bool {
@@ -121,18 +260,79 @@ type streamEndpoint struct {
 	target    string
 	endpoints []string
 	config    *StreamEndpointConfig
-	conn      *grpc.ClientConn
+	conn      *grpc.ClientConn   // conn is the first connection of the endpoint, shared with the gRPC clients of the same endpoints
+	conns     []*grpc.ClientConn // conns are the connections the streams are spread over, see EndpointConnections
+	nextConn  uint32
+	breaker   *dependency
+	stopWatch context.CancelFunc // stopWatch stops the monitoring of the connection state
 }
 
 func defaultConsumerConfig() *ConsumerConfig {
 	return &ConsumerConfig{
-		BufferLen: 256,
+		BufferLen:        256,
+		ReconnectBackoff: defaultReconnectBackoff(),
 	}
 }
 
 func defaultStreamEndpointConfig() *StreamEndpointConfig {
 	return &StreamEndpointConfig{
 		backoffMaxDelay: 5 * time.Second,
+		connections:     1,
+		keepalive: keepalive.ClientParameters{
+			Time:                15 * time.Second,
+			Timeout:             20 * time.Second,
+			PermitWithoutStream: true,
+		},
+	}
+}
+
+// transport returns the dial option of the transport credentials of the endpoint
+func (config *StreamEndpointConfig) transport() grpc.DialOption {
+	if config.tls == nil {
+		return grpc.WithInsecure()
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config.tls))
+}
+
+// transportKey identifies the transport credentials of the endpoint
+func (config *StreamEndpointConfig) transportKey() string {
+	if config.tls == nil {
+		return "insecure"
+	}
+	return "tls(" + config.tlsKey + ")"
+}
+
+// connKey identifies the options of the connections of the endpoint, the connections are shared between the endpoints
+// with the same options. With the default options it is the transport key only, the key of the connections of GrpcServiceConn.
+func (config *StreamEndpointConfig) connKey() string {
+	key := config.transportKey()
+	defaults := defaultStreamEndpointConfig()
+	if config.keepalive != defaults.keepalive {
+		key += fmt.Sprintf(",keepalive=%+v", config.keepalive)
+	}
+	if config.backoffMaxDelay != defaults.backoffMaxDelay {
+		key += fmt.Sprintf(",backoff=%v", config.backoffMaxDelay)
+	}
+	if config.zoneAware {
+		key += ",zoneAware"
+	}
+	return key
+}
+
+// dialOptions returns the dial options of the connections of the endpoint, except the zone aware balancing
+func (config *StreamEndpointConfig) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		config.transport(),
+		grpc.WithKeepaliveParams(config.keepalive),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			MinConnectTimeout: 2 * time.Second,
+			Backoff: backoff.Config{
+				BaseDelay:  100 * time.Millisecond,
+				Multiplier: 1.6,
+				MaxDelay:   config.backoffMaxDelay,
+				Jitter:     0.2,
+			},
+		}),
 	}
 }
 
@@ -143,12 +343,55 @@ func BackoffMaxDelay(duration time.Duration) StreamEndpointConfigOpt {
 
 }
 
+// EndpointConnections spreads the streams consumed from the endpoint over n connections to each provider.
+// By default all the streams of an endpoint are multiplexed on a single HTTP/2 connection, whose flow control caps
+// their aggregate bandwidth; more connections increase the throughput of high volume streams.
+func EndpointConnections(n int) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		if n > 0 {
+			config.connections = n
+		}
+	}
+}
+
+// KeepaliveTime is the period of inactivity after which the connection is pinged to check it is alive (default: 15 sec).
+// It cannot be lower than 10 sec, which is the minimum accepted by gorillaz providers and gRPC.
+func KeepaliveTime(d time.Duration) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.keepalive.Time = d
+	}
+}
+
+// KeepaliveTimeout is how long a ping waits for its acknowledgement before the connection is closed and the consumers reconnect (default: 20 sec)
+func KeepaliveTimeout(d time.Duration) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.keepalive.Timeout = d
+	}
+}
+
+// KeepalivePermitWithoutStream allows pinging the connection when no stream is consumed on it (default: true)
+func KeepalivePermitWithoutStream(permit bool) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.keepalive.PermitWithoutStream = permit
+	}
+}
+
 type ConsumerConfigOpt func(*ConsumerConfig)
 
 type StreamEndpointConfigOpt func(config *StreamEndpointConfig)
 
+// EndpointType is how the endpoints of a stream endpoint are resolved
 type EndpointType uint8
 
+const (
+	// IPEndpoint endpoints are "host:port", the host names are resolved by the system
+	IPEndpoint EndpointType = iota
+	// DNSEndpoint endpoints are "host:port", the host names are resolved with the DNS server set by SetDNSAddr
+	DNSEndpoint
+	// SRVEndpoint endpoints are the names of SRV records, such as the ones of a Kubernetes headless service
+	SRVEndpoint
+)
+
 // Add options for the stream endpoint creation, this can be used when stream endpoints are created under the hood by the methods below.
 func WithStreamEndpointOptions(opts ...StreamEndpointConfigOpt) Option {
 	return Option{Opt: func(gaz *Gaz) error {
@@ -169,13 +412,13 @@ func (g *Gaz) DiscoverAndConsumeStream(fullStreamName string, opts ...ConsumerCo
 // The service name is resolved via service discovery
 // Under the hood we make sure that only 1 subscription is done for a service, even if multiple streams are created on the same service
 func (g *Gaz) DiscoverAndConsumeServiceStream(service, stream string, opts ...ConsumerConfigOpt) (StreamConsumer, error) {
-	return g.createConsumer([]string{SdPrefix + service}, stream, opts...)
+	return g.createConsumer([]string{SdPrefix + service}, g.tenantStreamName(stream), opts...)
 }
 
 // Call this method to create a stream consumer with the service endpoints and the stream name
 // Under the hood we make sure that only 1 subscription is done for a service, even if multiple streams are created on the same service
 func (g *Gaz) ConsumeStream(endpoints []string, stream string, opts ...ConsumerConfigOpt) (StreamConsumer, error) {
-	return g.createConsumer(endpoints, stream, opts...)
+	return g.createConsumer(endpoints, g.tenantStreamName(stream), opts...)
 }
 
 func (g *Gaz) createConsumer(endpoints []string, streamName string, opts ...ConsumerConfigOpt) (StreamConsumer, error) {
@@ -231,38 +474,80 @@ func (g *Gaz) deregister(c StoppableStream) {
 
 func (g *Gaz) newStreamEndpoint(endpoints []string, opts ...StreamEndpointConfigOpt) (*streamEndpoint, error) {
 	config := defaultStreamEndpointConfig()
+	if g.Viper != nil {
+		opts = append(g.endpointTLSFromConfig(), opts...)
+	}
 	for _, opt := range opts {
 		opt(config)
 	}
+	if config.err != nil {
+		return nil, config.err
+	}
+	dialOpts := config.dialOptions()
+	if config.zoneAware {
+		serviceConfig, err := g.grpcServiceConfigWithPolicy(ZoneAwareBalancerName)
+		if err != nil {
+			return nil, err
+		}
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(serviceConfig))
+	}
 
 	target := strings.Join(endpoints, ",")
-	conn, err := g.GrpcDial(target, grpc.WithInsecure(),
-		grpc.WithConnectParams(grpc.ConnectParams{
-			MinConnectTimeout: 2 * time.Second,
-			Backoff: backoff.Config{
-				BaseDelay:  100 * time.Millisecond,
-				Multiplier: 1.6,
-				MaxDelay:   config.backoffMaxDelay,
-				Jitter:     0.2,
-			},
-		}),
-	)
-
-	if err != nil {
-		return nil, err
+	dialTarget := strings.Join(dnsEndpoints(endpoints, config.endpointType, config.dnsAddr), ",")
+	dial := func() (*grpc.ClientConn, error) {
+		return g.GrpcDial(dialTarget, dialOpts...)
+	}
+	// the connections are shared with the other stream endpoints targeting the same endpoints with the same options
+	conns := make([]*grpc.ClientConn, 0, config.connections)
+	for i := 0; i < config.connections; i++ {
+		key := grpcConnKey{target: dialTarget, options: config.connKey()}
+		if i > 0 {
+			key.target = dialTarget + "#" + strconv.Itoa(i)
+		}
+		conn, err := g.acquireGrpcConn(key, dial)
+		if err != nil {
+			for _, c := range conns {
+				_ = g.ReleaseGrpcConn(c)
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
 	}
 	endpoint := &streamEndpoint{
 		g:         g,
 		config:    config,
 		endpoints: endpoints,
 		target:    target,
-		conn:      conn,
+		conn:      conns[0],
+		conns:     conns,
+		breaker:   g.dependency(ProtocolGrpc, target),
 	}
+	ctx, stopWatch := context.WithCancel(context.Background())
+	endpoint.stopWatch = stopWatch
+	g.goTracked("endpoint_state", target, func() {
+		endpoint.watchState(ctx)
+	})
 	return endpoint, nil
 }
 
 func (se *streamEndpoint) close() error {
-	return se.conn.Close()
+	se.stopWatch()
+	var err error
+	for _, conn := range se.conns {
+		if e := se.g.ReleaseGrpcConn(conn); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// pickConn returns the connection of a new stream, the streams are spread over the connections in turn
+func (se *streamEndpoint) pickConn() *grpc.ClientConn {
+	if len(se.conns) <= 1 {
+		return se.conn
+	}
+	n := atomic.AddUint32(&se.nextConn, 1)
+	return se.conns[int(n-1)%len(se.conns)]
 }
 
 func (se *streamEndpoint) consumeStream(streamName string, opts ...ConsumerConfigOpt) StreamConsumer {
@@ -275,27 +560,54 @@ func (se *streamEndpoint) consumeStream(streamName string, opts ...ConsumerConfi
 
 	c := &consumer{
 		endpoint:   se,
+		conn:       se.pickConn(),
 		streamName: streamName,
 		evtChan:    ch,
 		config:     config,
 		stopped:    new(int32),
+		done:       make(chan struct{}),
 		cMetrics:   consumerMonitoring(se.g, streamName, se.endpoints),
+		tMetrics:   consumerEventTypeMonitoring(se.g, streamName, se.endpoints, config.EventTypeMetrics),
+		traffic:    consumerTrafficMonitoring(se.g, streamName),
+		ordering:   newConsumerOrderingChecker(se.g, streamName, config.OrderingCheck),
+		guard:      &evtChanGuard{},
+		peerCaps:   &peerCapabilities{},
+		errEvents:  newErrorEvents(config),
 	}
+	c.backoff = newReconnectBackoff(config.ReconnectBackoff, se.g.Clock(), c.done)
+	c.compression = newCompressionNegotiation(config)
+	if config.Ack {
+		c.ackSession = config.AckConsumerId
+		if c.ackSession == "" {
+			c.ackSession = newAckSession()
+		}
+	}
+	c.interceptors = newInterceptorChain(c.deliver, se.config.interceptors, config.Interceptors)
 
-	go func() {
+	se.g.goTracked("stream_consumer", streamName, func() {
 		c.reconnectWhileNotStopped()
+		for c.errEvents.await(se.g, streamName, c.conn, c.guard, c.isStopped) {
+			c.backoff.reset()
+			c.reconnectWhileNotStopped()
+		}
 		Log.Info("Stream closed", zap.String("stream", c.streamName))
-		close(c.evtChan)
-	}()
+		c.closeEvtChan()
+		c.errEvents.close()
+		releaseConsumerMonitoring(se.g, streamName, config.KeepMetrics)
+	})
 	return c
 }
 
 func (c *consumer) reconnectWhileNotStopped() {
-	for c.endpoint.conn.GetState() != connectivity.Shutdown && !c.isStopped() {
+	for c.conn.GetState() != connectivity.Shutdown && !c.isStopped() && c.Err() == nil {
+		if !c.endpoint.breaker.Allow() {
+			c.endpoint.waitForCircuit(c.streamName, c.done)
+			continue
+		}
 		c.cMetrics.conGauge.Set(0)
 		c.cMetrics.conAttemptCounter.Inc()
 		waitTillConnReadyOrShutdown(c)
-		if c.endpoint.conn.GetState() == connectivity.Shutdown {
+		if c.conn.GetState() == connectivity.Shutdown {
 			break
 		}
 		retry := c.readStream()
@@ -306,37 +618,75 @@ func (c *consumer) reconnectWhileNotStopped() {
 }
 
 func (c *consumer) readStream() (retry bool) {
-	client := stream.NewStreamClient(c.endpoint.conn)
+	client := stream.NewStreamClient(c.conn)
 	req := &stream.StreamRequest{
 		Name:                     c.streamName,
 		RequesterName:            c.endpoint.g.ServiceName,
 		ExpectHello:              true,
 		DisconnectOnBackpressure: c.config.DisconnectOnBackpressure,
 	}
-
-	var callOpts []grpc.CallOption
-	if c.config.UseGzip {
-		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
-	}
-	callOpts = append(callOpts, grpc.CallContentSubtype(StreamEncoding))
+	requestSampling(c.config, req)
+	seq, start := c.startPosition()
+	requestStart(seq, start, req)
+	req.WatchKeys, req.WatchKeyPrefixes, req.KeyExpression = c.config.WatchKeys, c.config.WatchKeyPrefixes, c.config.KeyExpression
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	ctx, callOpts, compressionMd := c.compression.callOptions(ctx)
+	callOpts = append(callOpts, grpc.CallContentSubtype(StreamEncoding))
+	callOpts = append(callOpts, c.endpoint.credentialsCallOptions()...)
 
-	st, err := client.Stream(ctx, req, callOpts...)
+	md, err := c.config.callMetadata()
 	if err != nil {
+		c.cMetrics.failedConCounter.Inc()
+		Log.Warn("Error while getting the metadata of the stream request", zap.String("stream", c.streamName), zap.Error(err))
+		c.backoff.wait(c.guard, c.streamName, 0)
+		return true
+	}
+	ctx, cancelConnect, established := c.config.connectDeadline(ctx)
+	defer cancelConnect()
+	defer established()
+	ctx = metadata.NewOutgoingContext(ctx, metadata.Join(md, supportedCapabilities.metadata(), compressionMd))
+
+	var st stream.Stream_StreamClient
+	if c.config.Ack {
+		st, err = openAckStream(ctx, c.conn, req, c.ackSession, callOpts...)
+	} else {
+		st, err = client.Stream(ctx, req, callOpts...)
+	}
+	if err != nil {
+		c.endpoint.breaker.Failure()
 		c.cMetrics.failedConCounter.Inc()
 		cancel()
 		Log.Warn("Error while creating stream", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
+		if !c.endpoint.retryWithRefreshedToken(err, &c.authRetried) {
+			c.backoff.wait(c.guard, c.streamName, 0)
+		}
 		return true
 	}
+	var end func(ended <-chan struct{})
+	if ack, ok := st.(*ackStreamClient); ok && c.config.AckConsumerId == "" {
+		// the events not acknowledged by a consumer without id cannot be sent again once it stops
+		end = func(ended <-chan struct{}) {
+			if ack.closeSend() == nil {
+				select {
+				case <-ended:
+				case <-c.endpoint.g.Clock().After(ackEndTimeout):
+				}
+			}
+		}
+	}
+	defer c.endpoint.cancelOnStop(c.streamName, c.done, cancel, end)()
 	//without this hack we do not know if the stream is really connected
 	mds, err := st.Header()
 	if err == nil && mds != nil {
+		c.peerCaps.set(capabilitiesFromMetadata(mds))
+		c.compression.update(mds)
 		var cs connectionStatus
 		if mds.Get("expectHello") != nil && len(mds.Get("expectHello")) > 0 {
 			cs = c.endpoint.waitForHelloMessage(c, c.streamName, st)
 			if cs == closed {
+				c.endpoint.breaker.Success()
 				c.cMetrics.conGauge.Set(0)
 				c.cMetrics.failedConCounter.Inc()
 				Log.Warn("Stream closed after Hello message", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target))
@@ -346,7 +696,15 @@ func (c *consumer) readStream() (retry bool) {
 			cs = connected
 		}
 
+		if cs == notConnected {
+			c.endpoint.breaker.Failure()
+		}
+
 		if cs == connected {
+			established()
+			c.authRetried = false
+			c.endpoint.breaker.Success()
+			c.backoff.reset()
 			if c.config.OnConnected != nil {
 				c.config.OnConnected(c.streamName)
 			}
@@ -361,9 +719,16 @@ func (c *consumer) readStream() (retry bool) {
 					c.cMetrics.conGauge.Set(0)
 					c.cMetrics.disconnectionCounter.Inc()
 
-					if err == io.EOF {
+					if err == io.EOF || c.isStopped() {
 						return false
 					}
+					// a provider accepting streams then failing them is flapping
+					c.endpoint.breaker.Failure()
+					// the provider rejects the streams with a compression it does not accept once they are established
+					c.compression.update(st.Trailer())
+					if c.endpoint.retryWithRefreshedToken(err, &c.authRetried) {
+						break
+					}
 					c.backOffOnError(err)
 					break
 				}
@@ -379,15 +744,60 @@ func (c *consumer) readStream() (retry bool) {
 					}
 				}
 
-				Log.Debug("event received", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target))
+				Log.Debug("event received", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), RedactedKey(streamEvt.Key))
 				monitorDelays(c, streamEvt)
+				c.tMetrics.received(streamEvt.Metadata)
+				c.traffic.payload.Add(float64(len(streamEvt.Value)))
+				var ackFunc func() error
+				if ack, ok := st.(*ackStreamClient); ok {
+					ackFunc = ack.ackFunc(streamEvt.Metadata)
+				}
+				if c.config.MaxEventSize > 0 && !c.checkEventSize(proto.Size(streamEvt), streamEvt.Key) {
+					ackDropped(ackFunc)
+					continue
+				}
 
 				ctx := stream.Ctx(streamEvt.Metadata)
-				evt := &stream.Event{Ctx: ctx, Key: streamEvt.Key, Value: streamEvt.Value}
-				c.evtChan <- evt
+				evt := &stream.Event{
+					Ctx:     ctx,
+					Key:     streamEvt.Key,
+					Value:   streamEvt.Value,
+					Headers: stream.MetadataHeaders(streamEvt.Metadata),
+					Error:   stream.MetadataError(streamEvt.Metadata),
+				}
+				evt, err = decryptReceived(c.endpoint.g, c.config.Decryption, c.streamName, evt)
+				if err != nil {
+					ackDropped(ackFunc)
+					continue
+				}
+				evt.AckFunc = ackFunc
+				// the marker of a source swap is delivered to every consumer, see StreamProvider.SwapSource
+				if _, swap := SourceSwap(evt); !swap && c.config.Validator != nil {
+					if err := c.config.Validator.Validate(evt); err != nil {
+						Log.Warn("invalid event not delivered", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
+						invalidEventsCounter(c.endpoint.g, StreamConsumerInvalidEvents, c.streamName).Inc()
+						ackDropped(ackFunc)
+						continue
+					}
+				}
+				c.ordering.Check(evt)
+				if c.mustReconnect() {
+					Log.Warn("consumer channel full, reconnecting", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target))
+					c.cMetrics.droppedCounter.Inc()
+					c.cMetrics.conGauge.Set(0)
+					c.cMetrics.disconnectionCounter.Inc()
+					break
+				}
+				if !c.intercept(evt, ackFunc) {
+					return false
+				}
+				if seq := stream.MetadataStreamSeq(streamEvt.Metadata); seq > 0 {
+					atomic.StoreInt64(&c.lastSeq, int64(seq))
+				}
 			}
 		}
 	} else {
+		c.endpoint.breaker.Failure()
 		c.cMetrics.conGauge.Set(0)
 		c.cMetrics.failedConCounter.Inc()
 		if mds == nil {
@@ -395,7 +805,12 @@ func (c *consumer) readStream() (retry bool) {
 		} else {
 			Log.Warn("Stream created but not connected", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
 		}
-		time.Sleep(5 * time.Second)
+		// the provider may ask to use another compression if it rejected the stream
+		c.compression.update(st.Trailer())
+		// the provider may ask to wait longer if it rejected the stream
+		if !c.endpoint.retryWithRefreshedToken(err, &c.authRetried) {
+			c.backoff.wait(c.guard, c.streamName, retryAfter(st.Trailer(), 0))
+		}
 	}
 	if c.config.OnDisconnected != nil {
 		c.config.OnDisconnected(c.streamName)
@@ -419,28 +834,64 @@ func (se *streamEndpoint) waitForHelloMessage(c *consumer, streamName string, st
 	} else if err == io.EOF {
 		return closed //standard error for closed stream
 	} else {
+		c.compression.update(st.Trailer())
 		c.backOffOnError(err)
 		return notConnected
 	}
 }
 
-func (c *consumer) backOffOnError(err error) {
-	Log.Warn("received error on stream", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
-	if e, ok := status.FromError(err); ok {
-		switch e.Code() {
-		case codes.PermissionDenied, codes.ResourceExhausted, codes.Unavailable,
-			codes.Unimplemented, codes.NotFound, codes.Unauthenticated, codes.Unknown:
-			time.Sleep(5 * time.Second)
+// cancelOnStop cancels the stream when the consumer is stopped, instead of when it receives its next event.
+// end, if not nil, is called first to end the stream gracefully, it returns once the stream ended or must be cancelled.
+// The function returned must be called once the stream is not read anymore.
+func (se *streamEndpoint) cancelOnStop(streamName string, stopped <-chan struct{}, cancel context.CancelFunc, end func(ended <-chan struct{})) (release func()) {
+	ended := make(chan struct{})
+	se.g.goTracked("stream_consumer_stop", streamName, func() {
+		select {
+		case <-stopped:
+		case <-ended:
+			return
 		}
+		if end != nil {
+			end(ended)
+		}
+		cancel()
+	})
+	return func() {
+		close(ended)
+	}
+}
+
+// waitForCircuit waits while the circuit breaker of the endpoint rejects the connections, or until the consumer is stopped
+func (se *streamEndpoint) waitForCircuit(streamName string, stopped <-chan struct{}) {
+	d := se.breaker.RetryAfter()
+	if d < time.Second {
+		d = time.Second
+	}
+	Log.Debug("circuit breaker open, waiting before reconnecting", zap.String("stream", streamName), zap.String("target", se.target), zap.Duration("delay", d))
+	select {
+	case <-se.g.Clock().After(d):
+	case <-stopped:
 	}
 }
 
+func (c *consumer) backOffOnError(err error) {
+	Log.Warn("received error on stream", zap.String("stream", c.streamName), zap.String("target", c.endpoint.target), zap.Error(err))
+	c.backoff.wait(c.guard, c.streamName, 0)
+}
+
 func WithDisconnectOnBackpressure() ConsumerConfigOpt {
 	return func(c *ConsumerConfig) {
 		c.DisconnectOnBackpressure = true
 	}
 }
 
+// WithKeepMetrics keeps the metrics of the stream once its last consumer stops, so that its counters go on if it is consumed again
+func WithKeepMetrics() ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.KeepMetrics = true
+	}
+}
+
 type metadataProvider interface {
 	GetMetadata() *stream.Metadata
 }
@@ -448,7 +899,9 @@ type metadataProvider interface {
 func monitorDelays(c streamConsumer, evt metadataProvider) {
 	metrics := c.metrics()
 	metrics.receivedCounter.Inc()
-	nowMs := float64(time.Now().UnixNano()) / 1000000.0
+	now := c.streamEndpoint().g.Clock().Now().UnixNano()
+	atomic.StoreInt64(&metrics.lastEvent, now)
+	nowMs := float64(now) / 1000000.0
 	metadata := evt.GetMetadata()
 	streamTimestamp := metadata.StreamTimestamp
 	if streamTimestamp > 0 {
@@ -468,7 +921,7 @@ func monitorDelays(c streamConsumer, evt metadataProvider) {
 func waitTillConnReadyOrShutdown(c streamConsumer) {
 	metrics := c.metrics()
 	streamName := c.StreamName()
-	conn := c.streamEndpoint().conn
+	conn := c.clientConn()
 
 	metrics.checkConnStatusCounter.Inc()
 	var state = conn.GetState()
@@ -497,6 +950,8 @@ func waitTillConnReadyOrShutdown(c streamConsumer) {
 }
 
 type consumerMetrics struct {
+	lastEvent              int64 // lastEvent is the time the last event was received in unix nanoseconds, 0 if none was received
+	created                time.Time
 	receivedCounter        prometheus.Counter
 	conAttemptCounter      prometheus.Counter
 	checkConnStatusCounter prometheus.Counter
@@ -508,6 +963,16 @@ type consumerMetrics struct {
 	delaySummary           prometheus.Summary
 	originDelaySummary     prometheus.Summary
 	eventDelaySummary      prometheus.Summary
+	droppedCounter         prometheus.Counter
+	lostUpdatesCounter     prometheus.Counter // lostUpdatesCounter counts the state updates of a GetAndWatch stream received but not delivered
+	lastEventAge           prometheus.Gauge
+	stopLastEventAge       func()
+	refs                   int // refs is the number of consumers using the metrics
+}
+
+func (m *consumerMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.receivedCounter, m.conAttemptCounter, m.checkConnStatusCounter, m.connStatus, m.conGauge,
+		m.successConCounter, m.disconnectionCounter, m.failedConCounter, m.delaySummary, m.originDelaySummary, m.eventDelaySummary, m.droppedCounter, m.lostUpdatesCounter, m.lastEventAge}
 }
 
 // map of metrics registered to Prometheus
@@ -521,10 +986,12 @@ func consumerMonitoring(g *Gaz, streamName string, endpoints []string) *consumer
 	defer consumerMetricsMu.Unlock()
 
 	if m, ok := consumerMonitorings[streamName]; ok {
+		m.refs++
 		return m
 	}
 
 	m := &consumerMetrics{
+		created: g.Clock().Now(),
 		receivedCounter: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: StreamConsumerReceivedEvents,
 			Help: "The total number of events received",
@@ -625,18 +1092,57 @@ func consumerMonitoring(g *Gaz, streamName string, endpoints []string) *consumer
 				StreamEndpointsLabel: strings.Join(endpoints, ","),
 			},
 		}),
+
+		droppedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: StreamConsumerDroppedEvents,
+			Help: "The total number of events received and dropped because the application did not read them fast enough",
+			ConstLabels: prometheus.Labels{
+				StreamNameLabel:      streamName,
+				StreamEndpointsLabel: strings.Join(endpoints, ","),
+			},
+		}),
+
+		lostUpdatesCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: StreamConsumerLostStateUpdates,
+			Help: "The total number of state updates of a GetAndWatch stream received and not delivered, because they were too large or could not be decrypted",
+			ConstLabels: prometheus.Labels{
+				StreamNameLabel:      streamName,
+				StreamEndpointsLabel: strings.Join(endpoints, ","),
+			},
+		}),
+
+		lastEventAge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: StreamConsumerLastEventAge,
+			Help: "The number of seconds since the last event was received, or since the stream is consumed if no event was received",
+			ConstLabels: prometheus.Labels{
+				StreamNameLabel:      streamName,
+				StreamEndpointsLabel: strings.Join(endpoints, ","),
+			},
+		}),
 	}
-	g.prometheusRegistry.MustRegister(m.receivedCounter)
-	g.prometheusRegistry.MustRegister(m.conAttemptCounter)
-	g.prometheusRegistry.MustRegister(m.checkConnStatusCounter)
-	g.prometheusRegistry.MustRegister(m.connStatus)
-	g.prometheusRegistry.MustRegister(m.conGauge)
-	g.prometheusRegistry.MustRegister(m.successConCounter)
-	g.prometheusRegistry.MustRegister(m.disconnectionCounter)
-	g.prometheusRegistry.MustRegister(m.failedConCounter)
-	g.prometheusRegistry.MustRegister(m.delaySummary)
-	g.prometheusRegistry.MustRegister(m.originDelaySummary)
-	g.prometheusRegistry.MustRegister(m.eventDelaySummary)
+	g.prometheusRegistry.MustRegister(m.collectors()...)
+	m.stopLastEventAge = watchLastEventAge(g, streamName, m)
+	m.refs = 1
 	consumerMonitorings[streamName] = m
 	return m
 }
+
+// releaseConsumerMonitoring is called when a consumer of the stream has stopped,
+// the metrics of the stream are unregistered once it has no more consumers, unless they are kept
+func releaseConsumerMonitoring(g *Gaz, streamName string, keep bool) {
+	consumerMetricsMu.Lock()
+	defer consumerMetricsMu.Unlock()
+
+	m, ok := consumerMonitorings[streamName]
+	if !ok {
+		return
+	}
+	if m.refs--; m.refs > 0 || keep {
+		return
+	}
+	m.stopLastEventAge()
+	for _, c := range m.collectors() {
+		g.prometheusRegistry.Unregister(c)
+	}
+	delete(consumerMonitorings, streamName)
+}
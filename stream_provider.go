@@ -1,9 +1,11 @@
 package gorillaz
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/skysoft-atm/gorillaz/mux"
@@ -22,11 +24,21 @@ const (
 	StreamBackpressureDropped = "stream_backpressure_dropped"
 	StreamConnectedClients    = "stream_connected_clients"
 	StreamLastEventTimestamp  = "stream_last_evt_timestamp"
+	StreamRejectedSubscribers = "stream_rejected_subscribers"
 )
 
 // NewStreamProvider returns a new provider ready to be used.
 // only one instance of provider should be created for a given streamName
+// The stream name is prefixed by the tenant of the service, if any
+// An error wrapping ErrInvalidName is returned if it does not follow the stream name policy, see WithStreamNamePolicy
 func (g *Gaz) NewStreamProvider(streamName, dataType string, opts ...ProviderConfigOpt) (*StreamProvider, error) {
+	if err := g.checkStreamName(streamName); err != nil {
+		return nil, err
+	}
+	return g.newStreamProvider(g.tenantStreamName(streamName), dataType, opts...)
+}
+
+func (g *Gaz) newStreamProvider(streamName, dataType string, opts ...ProviderConfigOpt) (*StreamProvider, error) {
 	Log.Info("creating stream", zap.String("stream", streamName))
 
 	config := defaultProviderConfig()
@@ -34,30 +46,52 @@ func (g *Gaz) NewStreamProvider(streamName, dataType string, opts ...ProviderCon
 		opt(config)
 	}
 
-	var broadcaster *mux.Broadcaster
+	var broadcaster, priorityBroadcaster *mux.Broadcaster
 
 	if config.LazyBroadcast {
 		broadcaster = mux.NewNonBlockingBroadcaster(config.InputBufferLen, mux.LazyBroadcast)
+		priorityBroadcaster = mux.NewNonBlockingBroadcaster(config.InputBufferLen, mux.LazyBroadcast)
 	} else {
 		broadcaster = mux.NewNonBlockingBroadcaster(config.InputBufferLen)
+		priorityBroadcaster = mux.NewNonBlockingBroadcaster(config.InputBufferLen)
 	}
 	p := &StreamProvider{
-		streamDef:   &StreamDefinition{Name: streamName, DataType: dataType},
-		config:      config,
-		broadcaster: broadcaster,
-		metrics:     pMetricHolder(g, streamName),
-		gaz:         g,
+		streamDef:           &StreamDefinition{Name: streamName, DataType: dataType},
+		config:              config,
+		broadcaster:         broadcaster,
+		priorityBroadcaster: priorityBroadcaster,
+		metrics:             pMetricHolder(g, streamName),
+		typeMetrics:         providerEventTypeMonitoring(g, streamName, config.EventTypeMetrics),
+		traffic:             providerTrafficMonitoring(g, streamName),
+		gaz:                 g,
+		limiter:             newSubscriberLimiter(config.MaxSubscribers),
+		lineage:             newLineageStamper(g, streamName, config.StampLineage),
+		source:              &providerSource{},
+		replay:              newReplayBuffer(config.ReplayBufferLen),
+		unacked:             newUnackedEvents(config.AckRetention, config.ackRetainedEvents(), g.Clock()),
 	}
 	g.streamRegistry.register(p)
 	return p, nil
 }
 
+// StreamProvider streams the submitted events to its subscribers.
+// Events with a priority above stream.PriorityNormal go through a separate lane, with its own buffers, which is always
+// sent first: under backpressure they overtake the bulk events and are not dropped because the bulk lane is full.
+// The order of the events is preserved within a lane, not across lanes.
 type StreamProvider struct {
-	streamDef   *StreamDefinition
-	config      *ProviderConfig
-	broadcaster *mux.Broadcaster
-	metrics     providerMetricsHolder
-	gaz         *Gaz
+	streamDef           *StreamDefinition
+	config              *ProviderConfig
+	broadcaster         *mux.Broadcaster
+	priorityBroadcaster *mux.Broadcaster
+	metrics             providerMetricsHolder
+	typeMetrics         *eventTypeMetrics
+	traffic             *trafficMetrics
+	gaz                 *Gaz
+	limiter             *subscriberLimiter
+	lineage             *lineageStamper
+	source              *providerSource
+	replay              *replayBuffer
+	unacked             *unackedEvents
 }
 
 func (p *StreamProvider) streamDefinition() *StreamDefinition {
@@ -68,13 +102,35 @@ func (p *StreamProvider) streamType() stream.StreamType {
 	return stream.StreamType_STREAM
 }
 
+func (p *StreamProvider) consumers() []mux.ConsumerStats {
+	return p.broadcaster.Consumers()
+}
+
+func (p *StreamProvider) subscriberLimiter() *subscriberLimiter {
+	return p.limiter
+}
+
+func (p *StreamProvider) subscriberRetryAfter() time.Duration {
+	return p.config.SubscriberRetryAfter
+}
+
+func (p *StreamProvider) auditSink() AuditSink {
+	return p.config.Audit
+}
+
+func (p *StreamProvider) compressions() []string {
+	return p.config.Compression
+}
+
 var pMetricHolderMu sync.Mutex
 var pMetrics = make(map[string]providerMetricsHolder)
+var pMetricRefs = make(map[string]int)
 
 func pMetricHolder(g *Gaz, streamName string) providerMetricsHolder {
 	pMetricHolderMu.Lock()
 	defer pMetricHolderMu.Unlock()
 	if h, ok := pMetrics[streamName]; ok {
+		pMetricRefs[streamName]++
 		return h
 	}
 
@@ -110,20 +166,49 @@ func pMetricHolder(g *Gaz, streamName string) providerMetricsHolder {
 				StreamNameLabel: streamName,
 			},
 		}),
+
+		rejectedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: StreamRejectedSubscribers,
+			Help: "The total number of subscriptions rejected because the maximum number of subscribers is reached",
+			ConstLabels: prometheus.Labels{
+				StreamNameLabel: streamName,
+			},
+		}),
 	}
-	g.prometheusRegistry.MustRegister(h.sentCounter)
-	g.prometheusRegistry.MustRegister(h.backPressureCounter)
-	g.prometheusRegistry.MustRegister(h.clientCounter)
-	g.prometheusRegistry.MustRegister(h.lastEventTimestamp)
+	g.prometheusRegistry.MustRegister(h.collectors()...)
 	pMetrics[streamName] = h
+	pMetricRefs[streamName] = 1
 	return h
 }
 
+// releasePMetricHolder is called when a provider of the stream is closed, the metrics are unregistered once no provider uses them
+func releasePMetricHolder(g *Gaz, streamName string) {
+	pMetricHolderMu.Lock()
+	defer pMetricHolderMu.Unlock()
+	h, ok := pMetrics[streamName]
+	if !ok {
+		return
+	}
+	if pMetricRefs[streamName]--; pMetricRefs[streamName] > 0 {
+		return
+	}
+	for _, c := range h.collectors() {
+		g.prometheusRegistry.Unregister(c)
+	}
+	delete(pMetrics, streamName)
+	delete(pMetricRefs, streamName)
+}
+
 type providerMetricsHolder struct {
 	sentCounter         prometheus.Counter
 	backPressureCounter prometheus.Counter
 	clientCounter       prometheus.Gauge
 	lastEventTimestamp  prometheus.Gauge
+	rejectedCounter     prometheus.Counter
+}
+
+func (h providerMetricsHolder) collectors() []prometheus.Collector {
+	return []prometheus.Collector{h.sentCounter, h.backPressureCounter, h.clientCounter, h.lastEventTimestamp, h.rejectedCounter}
 }
 
 // ProviderConfig is the configuration that will be applied for the stream StreamProvider
@@ -133,6 +218,23 @@ type ProviderConfig struct {
 	OnBackPressure           func(streamName string) // OnBackPressure is the function called when a customer cannot consume fast enough and event are dropped. (default: log)
 	LazyBroadcast            bool                    // if lazy broadcaster, then the provider doesn't consume messages as long as there is no consumer
 	TracingEnabled           bool
+	MaxSubscribers           int                 // MaxSubscribers is the maximum number of concurrent subscribers, the others are rejected with ResourceExhausted (default: 0, unlimited)
+	MaxSendRate              float64             // MaxSendRate is the maximum number of events per second sent to each subscriber (default: 0, unlimited)
+	SubscriberRetryAfter     time.Duration       // SubscriberRetryAfter is the delay rejected subscribers are asked to wait before subscribing again (default: 5s)
+	Validator                Validator           // Validator rejects the invalid events submitted (default: nil, no validation)
+	Encryption               KeyProvider         // Encryption encrypts the values of the events submitted end to end (default: nil, values in clear)
+	EventTypeMetrics         []string            // EventTypeMetrics are the event types broken down in the metrics, the others are counted together (default: nil, no breakdown)
+	SlowConsumer             *SlowConsumerConfig // SlowConsumer detects the subscribers not keeping up with the events (default: nil, no detection)
+	DerivedEvents            bool                // DerivedEvents counts the events submitted without origin stream timestamp, see DerivedEvents (default: false)
+	StampLineage             bool                // StampLineage starts the lineage of the events submitted without lineage, see StampLineage (default: false)
+	Audit                    AuditSink           // Audit records the subscriptions to the stream (default: nil, not recorded)
+	MaxEventSize             int                 // MaxEventSize rejects the events whose marshalled size exceeds it, see ProviderMaxEventSize (default: 0, unlimited)
+	ReplayBufferLen          int                 // ReplayBufferLen is the number of events kept to be replayed to the consumers, see ProviderReplay (default: 0, no replay)
+	AckWindow                int                 // AckWindow is the maximum number of events not acknowledged by a consumer WithAck, see ProviderAckWindow (default: 256)
+	AckRedeliveryDelay       time.Duration       // AckRedeliveryDelay is the delay after which an event not acknowledged is sent again, see ProviderAckWindow (default: 5s)
+	AckRetention             time.Duration       // AckRetention is how long the events not acknowledged by a consumer which disconnected are kept, see ProviderAckRetention (default: 1 min)
+	AckRetainedEvents        int                 // AckRetainedEvents is the maximum number of events kept for a consumer which disconnected, see ProviderAckRetention (default: AckWindow)
+	Compression              []string            // Compression are the compressions accepted, in order of preference, see ProviderCompression (default: nil, any compression registered)
 }
 
 func defaultProviderConfig() *ProviderConfig {
@@ -142,11 +244,25 @@ func defaultProviderConfig() *ProviderConfig {
 		OnBackPressure: func(streamName string) {
 			Log.Warn("backpressure applied, an event won't be delivered because it can't consume fast enough", zap.String("stream", streamName))
 		},
-		LazyBroadcast:  false,
-		TracingEnabled: true,
+		LazyBroadcast:        false,
+		TracingEnabled:       true,
+		SubscriberRetryAfter: defaultSubscriberRetryAfter,
+		AckWindow:            defaultAckWindow,
+		AckRedeliveryDelay:   defaultAckRedeliveryDelay,
+		AckRetention:         defaultAckRetention,
 	}
 }
 
+func (p *ProviderConfig) ackRetainedEvents() int {
+	if p.AckRetainedEvents > 0 {
+		return p.AckRetainedEvents
+	}
+	if p.AckWindow > 0 {
+		return p.AckWindow
+	}
+	return defaultAckWindow
+}
+
 // ProviderConfigOpt is a ProviderConfig option function to modify the ProviderConfig used by the stream StreamProvider
 type ProviderConfigOpt func(p *ProviderConfig)
 
@@ -158,40 +274,137 @@ var TracingDisabled = func(p *ProviderConfig) {
 	p.TracingEnabled = false
 }
 
+// MaxSubscribers limits the number of concurrent subscribers of the stream
+func MaxSubscribers(n int) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.MaxSubscribers = n
+	}
+}
+
+// MaxSendRate limits the number of events per second sent to each subscriber,
+// the events of a subscriber exceeding the rate are buffered then dropped by backpressure
+func MaxSendRate(eventsPerSecond float64) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.MaxSendRate = eventsPerSecond
+	}
+}
+
 // Submit pushes the event to all subscribers
 func (p *StreamProvider) Submit(evt *stream.Event) {
-	b, err := p.marshal(evt)
+	p.submit(evt, (*mux.Broadcaster).SubmitBlocking)
+}
+
+// submit pushes the event to all subscribers with the submit function of its broadcaster
+func (p *StreamProvider) submit(evt *stream.Event, submit func(*mux.Broadcaster, interface{})) {
+	if err := p.validate(evt); err != nil {
+		return
+	}
+	p.submitValidated(evt, false, submit)
+}
+
+// submitValidated pushes the valid event to all subscribers, a control event is sent to every subscriber, see controlEvent
+func (p *StreamProvider) submitValidated(evt *stream.Event, control bool, submit func(*mux.Broadcaster, interface{})) {
+	evt, err := encryptSubmitted(p.gaz, p.config.Encryption, p.streamDef.Name, p.lineage.stamp(evt))
 	if err != nil {
-		Log.Error("failed to marshal event", zap.String("key", string(evt.Key)), zap.Error(err))
 		return
 	}
-	p.broadcaster.SubmitBlocking(b)
+	p.replay.lock()
+	defer p.replay.unlock()
+	b, metadata, err := p.marshal(evt)
+	if err != nil {
+		if !errors.Is(err, ErrEventTooLarge) {
+			Log.Error("failed to marshal event", RedactedKey(evt.Key), zap.Error(err))
+		}
+		return
+	}
+	p.replay.record(metadata, b, control)
+	if control {
+		submit(p.lane(evt), controlEvent(b))
+		return
+	}
+	submit(p.lane(evt), b)
+}
+
+// controlEvent is a marshalled event sent to every subscriber whatever its key filter or its sampling, such as the marker of
+// a source swap. The other events are submitted to the broadcasters as []byte.
+type controlEvent []byte
+
+// broadcastEvent returns the marshalled event of a value submitted to the broadcasters, control is true for a controlEvent
+func broadcastEvent(v interface{}) (b []byte, control bool) {
+	if c, ok := v.(controlEvent); ok {
+		return c, true
+	}
+	return v.([]byte), false
 }
 
 // Submit pushes the event to all subscribers
 func (p *StreamProvider) SubmitNonBlocking(evt *stream.Event) error {
-	b, err := p.marshal(evt)
+	if err := p.validate(evt); err != nil {
+		return err
+	}
+	evt, err := encryptSubmitted(p.gaz, p.config.Encryption, p.streamDef.Name, p.lineage.stamp(evt))
+	if err != nil {
+		return err
+	}
+	p.replay.lock()
+	defer p.replay.unlock()
+	b, metadata, err := p.marshal(evt)
 	if err != nil {
 		return err
 	}
-	return p.broadcaster.SubmitNonBlocking(b)
+	return p.replay.recordSubmitted(metadata, b, func() error {
+		return p.lane(evt).SubmitNonBlocking(b)
+	})
+}
+
+// lane returns the broadcaster of the event according to its priority
+func (p *StreamProvider) lane(evt *stream.Event) *mux.Broadcaster {
+	if evt.Priority() > stream.PriorityNormal {
+		return p.priorityBroadcaster
+	}
+	return p.broadcaster
+}
+
+func (p *StreamProvider) validate(evt *stream.Event) error {
+	if p.config.Validator == nil {
+		return nil
+	}
+	err := p.config.Validator.Validate(evt)
+	if err != nil {
+		Log.Warn("invalid event not submitted", zap.String("stream", p.streamDef.Name), RedactedKey(evt.Key), zap.Error(err))
+		invalidEventsCounter(p.gaz, StreamInvalidEvents, p.streamDef.Name).Inc()
+	}
+	return err
 }
 
-func (p *StreamProvider) marshal(evt *stream.Event) ([]byte, error) {
+func (p *StreamProvider) marshal(evt *stream.Event) ([]byte, *stream.Metadata, error) {
+	checkOrigin(p.gaz, p.config.DerivedEvents, p.streamDef.Name, evt)
 	metadata, err := stream.EventMetadata(evt)
 	if err != nil {
-		Log.Error("error while creating Metadata from event", zap.String("key", string(evt.Key)), zap.Error(err))
+		Log.Error("error while creating Metadata from event", RedactedKey(evt.Key), zap.Error(err))
 	}
+	p.replay.stamp(metadata)
 	streamEvent := &stream.StreamEvent{
 		Metadata: metadata,
 		Key:      evt.Key,
 		Value:    evt.Value,
 	}
+	b, err := proto.Marshal(streamEvent)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkEventSize(len(b), p.config.MaxEventSize); err != nil {
+		Log.Warn("oversize event not submitted", zap.String("stream", p.streamDef.Name), RedactedKey(evt.Key), zap.Error(err))
+		oversizeEventsCounter(p.gaz, StreamOversizeEvents, StreamNameLabel, p.streamDef.Name).Inc()
+		return nil, nil, err
+	}
 
 	p.metrics.sentCounter.Inc()
 	p.metrics.lastEventTimestamp.SetToCurrentTime()
+	p.typeMetrics.sent(evt.EventTypeStr())
+	p.traffic.payload.Add(float64(len(evt.Value)))
 
-	return proto.Marshal(streamEvent)
+	return b, metadata, nil
 }
 
 func (p *StreamProvider) sendHelloMessage(strm grpc.ServerStream, peer Peer) error {
@@ -219,8 +432,8 @@ func (p *StreamProvider) sendLoop(strm grpc.ServerStream, peer Peer, opts sendLo
 		p.metrics.clientCounter.Dec()
 	}()
 	broadcaster := p.broadcaster
-	streamCh := make(chan interface{}, p.config.SubscriberInputBufferLen)
-	broadcaster.Register(streamCh, func(config *mux.ConsumerConfig) error {
+	consumerOptions := func(config *mux.ConsumerConfig) error {
+		config.Name(peer.name())
 		config.OnBackpressure(func(interface{}) {
 			p.config.OnBackPressure(streamName)
 			p.metrics.backPressureCounter.Inc()
@@ -229,32 +442,104 @@ func (p *StreamProvider) sendLoop(strm grpc.ServerStream, peer Peer, opts sendLo
 			config.DisconnectOnBackpressure()
 		}
 		return nil
+	}
+	streamCh := make(chan interface{}, p.config.SubscriberInputBufferLen)
+	priorityCh := make(chan interface{}, p.config.SubscriberInputBufferLen)
+	replayed, filter := p.replay.subscribe(streamName, opts.startSequence, opts.startTime, func() {
+		broadcaster.Register(streamCh, consumerOptions)
+		p.priorityBroadcaster.Register(priorityCh, consumerOptions)
 	})
 
 	defer func() {
 		broadcaster.Unregister(streamCh)
+		p.priorityBroadcaster.Unregister(priorityCh)
 	}()
+	rateLimiter := newSendRateLimiter(p.config.MaxSendRate)
+	clock := p.gaz.Clock()
+	sampler := newEventSampler(opts.sampleEvery, opts.sampleMaxRate, clock)
+	slow := newSlowConsumerDetector(p.gaz, streamName, peer, p.config.SlowConsumer)
+	defer slow.close()
+	// ctx is done when the consumer disconnects, or when a consumer WithAck ends the stream
+	ctx := strm.Context()
+	send := func(evt []byte) error {
+		return strm.SendMsg(evt)
+	}
+	if opts.ack {
+		w, err := newAckWindow(p, strm, peer, opts.ackSession)
+		if err != nil {
+			return err
+		}
+		defer w.close()
+		send = w.send
+		ctx = w.ctx
+	}
+
+	if len(replayed) > 0 {
+		Log.Info("replaying events", zap.String("stream", streamName), zap.String("peer", peer.address), zap.Int("events", len(replayed)))
+	}
+	for _, v := range replayed {
+		evt, control := broadcastEvent(v)
+		if !control && opts.keys != nil && !opts.keys.containsEvent(evt) {
+			continue
+		}
+		if !control && !sampler.keep() {
+			continue
+		}
+		if err := rateLimiter.wait(ctx); err != nil {
+			return err
+		}
+		if err := opts.quota.wait(ctx, len(evt)); err != nil {
+			return err
+		}
+		if err := send(evt); err != nil {
+			Log.Info("consumer disconnected", zap.Error(err), zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("peer service", peer.serviceName))
+			return err
+		}
+	}
 
 	for {
-		select {
-		case val, ok := <-streamCh:
-			if !ok {
-				// if the broadcaster is closed, then there are no more values to be sent, there is no error
-				if broadcaster.Closed() {
-					return nil
-				}
-				// otherwise, the consumer gets disconnected because it's not consuming fast enough
-				return status.Error(codes.DataLoss, "not consuming fast enough")
-			}
-			evt := val.([]byte)
-			if err := strm.SendMsg(evt); err != nil {
-				Log.Info("consumer disconnected", zap.Error(err), zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("peer service", peer.serviceName))
-				return err
+		val, ok := receiveByPriority(ctx.Done(), priorityCh, streamCh)
+		if ctx.Err() != nil {
+			if strm.Context().Err() == nil {
+				Log.Info("consumer ended the stream", zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("peer service", peer.serviceName))
+				return nil
 			}
-		case <-strm.Context().Done():
 			Log.Info("consumer disconnected", zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("peer service", peer.serviceName))
 			return strm.Context().Err()
 		}
+		if !ok {
+			// if the broadcaster is closed, then there are no more values to be sent, there is no error
+			if broadcaster.Closed() || p.priorityBroadcaster.Closed() {
+				return nil
+			}
+			// otherwise, the consumer gets disconnected because it's not consuming fast enough
+			return status.Error(codes.DataLoss, "not consuming fast enough")
+		}
+		evt, control := broadcastEvent(val)
+		if filter.replayed(evt) {
+			continue
+		}
+		if !control && opts.keys != nil && !opts.keys.containsEvent(evt) {
+			continue
+		}
+		if !control && !sampler.keep() {
+			continue
+		}
+		if err := rateLimiter.wait(ctx); err != nil {
+			return err
+		}
+		if err := opts.quota.wait(ctx, len(evt)); err != nil {
+			return err
+		}
+		sendStart := clock.Now()
+		if err := send(evt); err != nil {
+			Log.Info("consumer disconnected", zap.Error(err), zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("peer service", peer.serviceName))
+			return err
+		}
+		now := clock.Now()
+		if err := slow.observe(len(streamCh)+len(priorityCh), now.Sub(sendStart), now); err != nil {
+			return err
+		}
 	}
 }
 
@@ -263,7 +548,25 @@ func (p *StreamProvider) CloseStream() error {
 }
 
 func (p *StreamProvider) close() {
+	p.stopSource()
 	p.broadcaster.Close()
+	p.priorityBroadcaster.Close()
+}
+
+// receiveByPriority returns the next value of the high priority lane if there is one, otherwise the next value of either lane.
+// ok is false if a lane is closed or if done is closed.
+func receiveByPriority(done <-chan struct{}, high, normal <-chan interface{}) (val interface{}, ok bool) {
+	select {
+	case val, ok = <-high:
+		return val, ok
+	default:
+	}
+	select {
+	case val, ok = <-high:
+	case val, ok = <-normal:
+	case <-done:
+	}
+	return val, ok
 }
 
 func GetFullStreamName(serviceName, streamName string) string {
@@ -281,13 +584,21 @@ func ParseStreamName(fullStreamName string) (string, string) {
 
 // registers the gorillaz stream encoding at startup
 func init() {
-	encoding.RegisterCodec(&binaryCodec{fallback: encoding.GetCodec("proto")})
+	encoding.RegisterCodec(&binaryCodec{fallback: registeredProtoCodec})
 }
 
 // binaryCodec takes the received binary data and directly returns it, without serializing it with proto.
 // the main reason to use this is in case of 100s of subscribers, encode the data only once and just forward it without re-encoding it for each subscriber
+// It is only used by the calls of the Stream service, which set the StreamEncoding content subtype,
+// the other services of the same server or connection use the codec of their own content subtype, proto by default.
 type binaryCodec struct {
-	fallback encoding.Codec
+	fallback func() encoding.Codec
+}
+
+// registeredProtoCodec returns the proto codec registered when the codec is used,
+// so that a proto codec registered by the application, for instance with WithCodecs, is used for the other messages
+func registeredProtoCodec() encoding.Codec {
+	return encoding.GetCodec(protoCodecName)
 }
 
 const StreamEncoding = "bytes"
@@ -302,11 +613,14 @@ func (c *binaryCodec) Marshal(v interface{}) ([]byte, error) {
 	if ok {
 		return encoded, nil
 	}
+	if tc := streamTypeCodecs.get(v); tc != nil {
+		return tc.Marshal(v)
+	}
 	msg, ok := v.(proto.Message)
 	if ok {
 		return proto.Marshal(msg)
 	}
-	return c.fallback.Marshal(v)
+	return c.fallback().Marshal(v)
 }
 
 // Unmarshal parses the wire format into v.
@@ -315,11 +629,14 @@ func (c *binaryCodec) Unmarshal(data []byte, v interface{}) error {
 	if ok {
 		return proto.Unmarshal(data, evt)
 	}
+	if tc := streamTypeCodecs.get(v); tc != nil {
+		return tc.Unmarshal(data, v)
+	}
 	msg, ok := v.(proto.Message)
 	if ok {
 		return proto.Unmarshal(data, msg)
 	}
-	return c.fallback.Unmarshal(data, v)
+	return c.fallback().Unmarshal(data, v)
 }
 
 // Name returns the name of the Codec implementation. The returned string
@@ -2,16 +2,23 @@ package gorillaz
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/skysoft-atm/gorillaz/mux"
 	"github.com/skysoft-atm/gorillaz/stream"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"net"
+	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -30,10 +37,25 @@ type provider interface {
 	sendLoop(strm grpc.ServerStream, peer Peer, opts sendLoopOpts) error
 	streamType() stream.StreamType
 	sendHelloMessage(strm grpc.ServerStream, peer Peer) error
+	consumers() []mux.ConsumerStats
+	subscriberLimiter() *subscriberLimiter
+	subscriberRetryAfter() time.Duration
+	auditSink() AuditSink
+	compressions() []string
 }
 
 type sendLoopOpts struct {
 	disconnectOnBackpressure bool
+	sampleEvery              int            // only 1 event out of sampleEvery is sent, if greater than 1
+	sampleMaxRate            float64        // at most sampleMaxRate events per second are sent, if positive
+	delta                    bool           // GetAndWatch updates are sent as deltas against the previous values
+	keys                     *keySubset     // only the state of these keys is sent, if not nil
+	quota                    *identityQuota // the rates of the consumer identity, unlimited if nil
+	capabilities             Capabilities   // the capabilities negotiated with the consumer
+	ack                      bool           // the events are kept until the consumer acknowledges them, see WithAck
+	ackSession               string         // the session of the consumer acknowledging the events, see ackRequester
+	startSequence            int            // the events are replayed from this sequence, if positive
+	startTime                time.Time      // the events are replayed from this time, if not zero and startSequence is not positive
 }
 
 type streamRegistry struct {
@@ -66,6 +88,7 @@ func (g *Gaz) closeStream(p provider) error {
 	}
 	g.streamRegistry.unregister(streamName)
 	prov.close()
+	releasePMetricHolder(g, streamName)
 	return nil
 }
 
@@ -114,19 +137,37 @@ type StreamRequest interface {
 // Stream implements streaming.proto Stream.
 // should not be called by the client
 func (sr *streamRegistry) Stream(req *stream.StreamRequest, strm stream.Stream_StreamServer) error {
-	return sr.publishOnStream(req, strm)
+	return sr.publishOnStream(req, strm, nil)
 }
 
-func (sr *streamRegistry) publishOnStream(np StreamRequest, strm grpc.ServerStream) error {
+// publishOnStream sends the stream to the consumer, ack is the first message of an AckStream, nil for the other streams
+func (sr *streamRegistry) publishOnStream(np StreamRequest, strm grpc.ServerStream, ack *stream.AckRequest) (err error) {
 	peer := getPeer(strm, np)
 	streamName := np.GetName()
 	requester := np.GetRequesterName()
 
 	opts := sendLoopOpts{
 		disconnectOnBackpressure: np.GetDisconnectOnBackpressure(),
+		ack:                      ack != nil,
+		ackSession:               ack.GetSession(),
+	}
+	opts.sampleEvery, opts.sampleMaxRate = requestedSampling(np)
+	opts.startSequence, opts.startTime = requestedStart(np)
+	if opts.keys, err = requestedKeySubset(np); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	md, _ := metadata.FromIncomingContext(strm.Context())
+	if md != nil {
+		opts.delta = requestedDelta(md)
+		opts.capabilities = supportedCapabilities.negotiate(capabilitiesFromMetadata(md))
 	}
 
 	Log.Info("new stream consumer", zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("requester", requester))
+	if sr.g.authorizer != nil {
+		if err := sr.g.authorizer.authorize(strm.Context(), StreamResourcePrefix+streamName); err != nil {
+			return err
+		}
+	}
 	sr.RLock()
 	provider, ok := sr.providers[streamName]
 	sr.RUnlock()
@@ -134,9 +175,59 @@ func (sr *streamRegistry) publishOnStream(np StreamRequest, strm grpc.ServerStre
 		Log.Warn("unknown stream", zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("requester", requester))
 		return fmt.Errorf("unknown stream %s", streamName)
 	}
+	if _, ok := provider.(*StreamProvider); ack != nil && !ok {
+		return status.Errorf(codes.Unimplemented, "stream %s cannot be consumed with acknowledgements", streamName)
+	}
+	limiter := provider.subscriberLimiter()
+	if !limiter.acquire() {
+		Log.Warn("too many subscribers, rejecting the stream consumer", zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("requester", requester))
+		retryAfter := provider.subscriberRetryAfter()
+		strm.SetTrailer(retryAfterTrailer(retryAfter))
+		return status.Errorf(codes.ResourceExhausted, "too many subscribers on stream %s, retry after %s", streamName, retryAfter)
+	}
+	defer limiter.release()
+	compressionHeader, err := checkCompression(provider, streamName, md, strm)
+	if err != nil {
+		Log.Warn("compression not accepted, rejecting the stream consumer", zap.String("stream", streamName), zap.String("peer", peer.address), zap.String("requester", requester), zap.Error(err))
+		return err
+	}
+	identity, authenticated := PeerIdentity(strm.Context())
+	opts.quota = sr.g.quotas.forIdentity(quotaIdentity(identity, authenticated, peer))
+	if err := opts.quota.acquire(streamName); err != nil {
+		strm.SetTrailer(retryAfterTrailer(provider.subscriberRetryAfter()))
+		return err
+	}
+	defer opts.quota.release()
+	if sink := provider.auditSink(); sink != nil {
+		audited := &auditedStream{ServerStream: strm}
+		strm = audited
+		a := SubscriptionAudit{
+			Action:    AuditSubscribe,
+			At:        time.Now(),
+			Stream:    streamName,
+			Identity:  identity,
+			Requester: requester,
+			Peer:      peer.address,
+			Filters:   auditFilters(opts),
+		}
+		sink.Audit(a)
+		defer func() {
+			start := a.At
+			a.Action = AuditUnsubscribe
+			a.At = time.Now()
+			a.Duration = a.At.Sub(start)
+			a.Events = atomic.LoadUint64(&audited.events)
+			a.Bytes = atomic.LoadUint64(&audited.bytes)
+			if err != nil {
+				a.Error = err.Error()
+			}
+			sink.Audit(a)
+		}()
+	}
 	// we send some metadata for backward compatibility, it was previously used on the client side to check if the stream connection is really established
 	header := metadata.Pairs("name", streamName, "expectHello", strconv.FormatBool(np.GetExpectHello()))
-	err := strm.SendHeader(header)
+	header = metadata.Join(header, opts.capabilities.metadata(), compressionHeader)
+	err = strm.SendHeader(header)
 	if err != nil {
 		Log.Error("client might be disconnected %s", zap.Error(err), zap.String("peer", peer.address), zap.String("requester", requester))
 		return err
@@ -155,7 +246,7 @@ func getPeer(strm grpc.ServerStream, np StreamRequest) Peer {
 }
 
 func (sr *streamRegistry) GetAndWatch(req *stream.GetAndWatchRequest, strm stream.Stream_GetAndWatchServer) error {
-	return sr.publishOnStream(req, strm)
+	return sr.publishOnStream(req, strm, nil)
 }
 
 type Peer struct {
@@ -163,6 +254,42 @@ type Peer struct {
 	serviceName string
 }
 
+// name identifies the peer in the stream consumer stats
+func (p Peer) name() string {
+	return p.serviceName + "@" + p.address
+}
+
+// StreamConsumers returns the stats of the consumers of every stream provided, by stream name
+func (g *Gaz) StreamConsumers() map[string][]mux.ConsumerStats {
+	sr := g.streamRegistry
+	sr.RLock()
+	providers := make(map[string]provider, len(sr.providers))
+	for name, p := range sr.providers {
+		providers[name] = p
+	}
+	sr.RUnlock()
+
+	result := make(map[string][]mux.ConsumerStats, len(providers))
+	for name, p := range providers {
+		result[name] = p.consumers()
+	}
+	return result
+}
+
+func streamConsumersHandler(g *Gaz) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.MarshalIndent(g.StreamConsumers(), "", " ")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if _, err := w.Write(b); err != nil {
+			Log.Error("failed to write response", zap.Error(err))
+		}
+	}
+}
+
 func GetGrpcClientAddress(ctx context.Context) string {
 	pr, ok := peer.FromContext(ctx)
 	if !ok {
@@ -175,5 +302,5 @@ func GetGrpcClientAddress(ctx context.Context) string {
 }
 
 func (g *Gaz) DiscoverStreamDefinitions(serviceName string) (GetAndWatchStreamConsumer, error) {
-	return g.GetAndWatchStream(serviceName, streamDefinitions)
+	return g.createGetAndWatchConsumer([]string{SdPrefix + serviceName}, streamDefinitions)
 }
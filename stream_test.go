@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	prom_client "github.com/prometheus/client_model/go"
 	"github.com/skysoft-atm/gorillaz/stream"
 	"google.golang.org/grpc"
@@ -32,6 +33,39 @@ func assertEquals(t *testing.T, got, expected, comment string) {
 	}
 }
 
+func TestKeepaliveOptions(t *testing.T) {
+	config := defaultStreamEndpointConfig()
+	if config.keepalive.Time != 15*time.Second || !config.keepalive.PermitWithoutStream {
+		t.Errorf("unexpected default keepalive %+v", config.keepalive)
+	}
+	KeepaliveTime(30 * time.Second)(config)
+	KeepaliveTimeout(5 * time.Second)(config)
+	KeepalivePermitWithoutStream(false)(config)
+	expected := keepalive.ClientParameters{Time: 30 * time.Second, Timeout: 5 * time.Second}
+	if config.keepalive != expected {
+		t.Errorf("expected keepalive %+v, got %+v", expected, config.keepalive)
+	}
+}
+
+func TestEndpointConnections(t *testing.T) {
+	config := defaultStreamEndpointConfig()
+	if config.connections != 1 {
+		t.Errorf("expected a single connection by default, got %d", config.connections)
+	}
+	EndpointConnections(0)(config)
+	if config.connections != 1 {
+		t.Errorf("expected the invalid number of connections to be ignored, got %d", config.connections)
+	}
+
+	conns := []*grpc.ClientConn{{}, {}, {}}
+	se := &streamEndpoint{conn: conns[0], conns: conns}
+	for i := 0; i < 6; i++ {
+		if c := se.pickConn(); c != conns[i%3] {
+			t.Errorf("stream %d expected on connection %d", i, i%3)
+		}
+	}
+}
+
 func TestStreamLazy(t *testing.T) {
 	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
 	defer g.Shutdown()
@@ -424,3 +458,89 @@ func TestDisconnectOnBackpressure(t *testing.T) {
 		}
 	}
 }
+
+func TestStreamPriority(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	provider, err := g.NewStreamProvider("alerts", "dummy.type")
+	if err != nil {
+		t.Fatalf("cannot register provider, %+v", err)
+	}
+	consumer := createConsumer(t, g, "alerts")
+
+	alert := &stream.Event{Value: []byte("alert")}
+	alert.SetPriority(stream.PriorityHigh)
+	provider.Submit(alert)
+
+	select {
+	case evt := <-consumer.EvtChan():
+		if evt.Priority() != stream.PriorityHigh {
+			t.Errorf("expected high priority but got %d", evt.Priority())
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("no event received after 5 sec")
+	}
+}
+
+func TestReceiveByPriority(t *testing.T) {
+	high := make(chan interface{}, 2)
+	normal := make(chan interface{}, 3)
+	done := make(chan struct{})
+	normal <- "bulk1"
+	normal <- "bulk2"
+	high <- "alert1"
+	high <- "alert2"
+
+	var received []interface{}
+	for i := 0; i < 4; i++ {
+		val, ok := receiveByPriority(done, high, normal)
+		if !ok {
+			t.Fatalf("expected a value")
+		}
+		received = append(received, val)
+	}
+	expected := []interface{}{"alert1", "alert2", "bulk1", "bulk2"}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Errorf("expected %v but got %v", expected, received)
+			break
+		}
+	}
+
+	close(done)
+	if _, ok := receiveByPriority(done, high, normal); ok {
+		t.Errorf("expected no value once done")
+	}
+	close(normal)
+	if _, ok := receiveByPriority(make(chan struct{}), high, normal); ok {
+		t.Errorf("expected no value once a lane is closed")
+	}
+}
+
+func TestConsumerMetricsUnregistered(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	const streamName = "test-metrics-unregistered"
+	labels := map[string]string{StreamNameLabel: streamName, StreamEndpointsLabel: "localhost:1"}
+
+	m := consumerMonitoring(g, streamName, []string{"localhost:1"})
+	consumerMonitoring(g, streamName, []string{"localhost:1"})
+	m.receivedCounter.Inc()
+	releaseConsumerMonitoring(g, streamName, false)
+	assertCounterEquals(t, g, labels, StreamConsumerReceivedEvents, 1)
+
+	releaseConsumerMonitoring(g, streamName, false)
+	if _, err := findMetric(g, StreamConsumerReceivedEvents, labels); err == nil {
+		t.Errorf("the metrics must be unregistered once the last consumer has stopped")
+	}
+
+	// the stream is consumed again, its metrics are reset and kept
+	m = consumerMonitoring(g, streamName, []string{"localhost:1"})
+	assertCounterEquals(t, g, labels, StreamConsumerReceivedEvents, 0)
+	m.receivedCounter.Inc()
+	releaseConsumerMonitoring(g, streamName, true)
+	consumerMonitoring(g, streamName, []string{"localhost:1"})
+	assertCounterEquals(t, g, labels, StreamConsumerReceivedEvents, 1)
+	releaseConsumerMonitoring(g, streamName, false)
+}
@@ -0,0 +1,383 @@
+package gorillaz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// The supervisor runs the processing nodes of a multi-stage service, each consuming some streams or subjects,
+// transforming their events and producing other streams or subjects. The nodes are linked in a DAG by what they
+// consume and produce, they are restarted according to their restart policy, their state is reported to the gRPC
+// health server as the "processing.<node>" service, and the DAG is served by the admin API at /processing/dag.
+
+const (
+	ProcessingNodeUp       = "processing_node_up"
+	ProcessingNodeRestarts = "processing_node_restarts"
+
+	ProcessingNodeLabel = "node"
+)
+
+// processingHealthService is the gRPC health service of all the processing nodes, it is not serving once a node failed
+const processingHealthService = "processing"
+
+// errTooManyRestarts is the failure of a node restarted MaxRestarts times in a row
+var errTooManyRestarts = errors.New("too many restarts")
+
+// ProcessingNode consumes events with its source, transforms them and produces them with its sink
+type ProcessingNode struct {
+	Name       string
+	Consumes   []string            // Consumes are the streams or subjects consumed, they link the node to the nodes producing them
+	Produces   []string            // Produces are the streams or subjects produced
+	Source     PipelineSource      // Source sends the events consumed, see PipelineSource
+	Transforms []PipelineTransform // Transforms are applied in order, an event transformed to nil is dropped
+	Sink       RelayPublisher      // Sink produces the events transformed
+}
+
+type RestartPolicy int
+
+const (
+	RestartOnFailure RestartPolicy = iota // RestartOnFailure restarts the node when it fails or panics
+	RestartAlways                         // RestartAlways restarts the node when it stops, even without error, for instance when its source is closed
+	RestartNever                          // RestartNever lets the node fail
+)
+
+type NodeConfig struct {
+	Restart     RestartPolicy // Restart is when the node is restarted (default: RestartOnFailure)
+	MinBackoff  time.Duration // MinBackoff is the delay before the first restart, it doubles at each consecutive restart (default: 1s)
+	MaxBackoff  time.Duration // MaxBackoff is the maximum delay between two restarts, the backoff is reset once a run lasts longer (default: 1 minute)
+	MaxRestarts int           // MaxRestarts is the number of consecutive restarts after which the node is failed (default: 0, unlimited)
+}
+
+type NodeOpt func(c *NodeConfig)
+
+// NodeRestart restarts the node according to the policy, with an exponential backoff between min and max
+func NodeRestart(policy RestartPolicy, min, max time.Duration) NodeOpt {
+	return func(c *NodeConfig) {
+		c.Restart = policy
+		c.MinBackoff = min
+		c.MaxBackoff = max
+	}
+}
+
+// NodeMaxRestarts fails the node once it is restarted n times in a row, the runs lasting longer than the maximum backoff reset the count
+func NodeMaxRestarts(n int) NodeOpt {
+	return func(c *NodeConfig) {
+		c.MaxRestarts = n
+	}
+}
+
+type NodeState string
+
+const (
+	NodePending    NodeState = "pending"    // NodePending is declared but not running yet
+	NodeRunning    NodeState = "running"    // NodeRunning is processing events
+	NodeRestarting NodeState = "restarting" // NodeRestarting stopped and waits for its backoff before restarting
+	NodeCompleted  NodeState = "completed"  // NodeCompleted stopped without error and is not restarted
+	NodeFailed     NodeState = "failed"     // NodeFailed failed and is not restarted
+	NodeStopped    NodeState = "stopped"    // NodeStopped was stopped by the shutdown of gorillaz
+)
+
+// NodeStatus describes a processing node and its position in the DAG
+type NodeStatus struct {
+	Name      string    `json:"name"`
+	Consumes  []string  `json:"consumes,omitempty"`
+	Produces  []string  `json:"produces,omitempty"`
+	Upstream  []string  `json:"upstream,omitempty"` // Upstream are the nodes producing what the node consumes
+	State     NodeState `json:"state"`
+	Since     time.Time `json:"since"` // Since is when the node entered its state
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Supervisor runs the processing nodes of gorillaz, see Gaz.Supervisor
+type Supervisor struct {
+	g     *Gaz
+	mu    sync.Mutex
+	nodes []*supervisedNode // nodes are in declaration order
+}
+
+// Supervisor returns the supervisor of the processing nodes of gorillaz
+func (g *Gaz) Supervisor() *Supervisor {
+	g.supervisorOnce.Do(func() {
+		g.supervisor = &Supervisor{g: g}
+	})
+	return g.supervisor
+}
+
+// Declare adds the node to the DAG and runs it in the background until gorillaz is shut down.
+// An error is returned if the node is invalid, if its name is already declared, or if it would make a cycle in the DAG.
+func (s *Supervisor) Declare(node ProcessingNode, opts ...NodeOpt) error {
+	if node.Name == "" {
+		return errors.New("missing node name")
+	}
+	if node.Source == nil || node.Sink == nil {
+		return fmt.Errorf("node %s: missing source or sink", node.Name)
+	}
+	config := &NodeConfig{
+		Restart:    RestartOnFailure,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	s.mu.Lock()
+	for _, n := range s.nodes {
+		if n.node.Name == node.Name {
+			s.mu.Unlock()
+			return fmt.Errorf("node %s already declared", node.Name)
+		}
+	}
+	if s.reachable(node, node.Name) {
+		s.mu.Unlock()
+		return fmt.Errorf("node %s would make a cycle", node.Name)
+	}
+	n := &supervisedNode{s: s, node: node, config: config, metrics: processingNodeMonitoring(s.g, node.Name), state: NodePending, since: s.g.Clock().Now()}
+	s.nodes = append(s.nodes, n)
+	s.mu.Unlock()
+
+	s.updateHealth(n, NodePending)
+	Log.Info("starting processing node", zap.String("node", node.Name))
+	s.g.Go("processing."+node.Name, n.supervise)
+	return nil
+}
+
+// reachable returns true if the node named target is downstream of from, from not being declared yet
+func (s *Supervisor) reachable(from ProcessingNode, target string) bool {
+	nodes := make([]ProcessingNode, 0, len(s.nodes)+1)
+	for _, n := range s.nodes {
+		nodes = append(nodes, n.node)
+	}
+	nodes = append(nodes, from)
+	visited := make(map[string]bool)
+	var visit func(produces []string) bool
+	visit = func(produces []string) bool {
+		for _, n := range nodes {
+			if !intersects(produces, n.Consumes) {
+				continue
+			}
+			if n.Name == target {
+				return true
+			}
+			if !visited[n.Name] {
+				visited[n.Name] = true
+				if visit(n.Produces) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return visit(from.Produces)
+}
+
+func intersects(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Status returns the status of the nodes, in declaration order
+func (s *Supervisor) Status() []NodeStatus {
+	s.mu.Lock()
+	nodes := append([]*supervisedNode(nil), s.nodes...)
+	s.mu.Unlock()
+	res := make([]NodeStatus, 0, len(nodes))
+	for _, n := range nodes {
+		st := n.status()
+		for _, up := range nodes {
+			if up != n && intersects(up.node.Produces, n.node.Consumes) {
+				st.Upstream = append(st.Upstream, up.node.Name)
+			}
+		}
+		res = append(res, st)
+	}
+	return res
+}
+
+// Healthy returns false if a node failed
+func (s *Supervisor) Healthy() bool {
+	for _, st := range s.Status() {
+		if st.State == NodeFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// updateHealth reports the state of the node, and of all the nodes, to the gRPC health server
+func (s *Supervisor) updateHealth(n *supervisedNode, state NodeState) {
+	hs := s.g.healthServer
+	if hs == nil {
+		return
+	}
+	hs.SetServingStatus(processingHealthService+"."+n.node.Name, servingStatus(state == NodeRunning))
+	hs.SetServingStatus(processingHealthService, servingStatus(s.Healthy()))
+}
+
+func servingStatus(serving bool) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if serving {
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+}
+
+type supervisedNode struct {
+	s       *Supervisor
+	node    ProcessingNode
+	config  *NodeConfig
+	metrics *processingNodeMetrics
+
+	mu       sync.Mutex
+	state    NodeState
+	since    time.Time
+	restarts int
+	lastErr  error
+}
+
+func (n *supervisedNode) setState(state NodeState, err error) {
+	n.mu.Lock()
+	n.state = state
+	n.since = n.s.g.Clock().Now()
+	if err != nil {
+		n.lastErr = err
+	}
+	n.mu.Unlock()
+	if state == NodeRunning {
+		n.metrics.upGauge.Set(1)
+	} else {
+		n.metrics.upGauge.Set(0)
+	}
+	n.s.updateHealth(n, state)
+}
+
+func (n *supervisedNode) status() NodeStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	st := NodeStatus{
+		Name:     n.node.Name,
+		Consumes: n.node.Consumes,
+		Produces: n.node.Produces,
+		State:    n.state,
+		Since:    n.since,
+		Restarts: n.restarts,
+	}
+	if n.lastErr != nil {
+		st.LastError = n.lastErr.Error()
+	}
+	return st
+}
+
+// supervise runs the node and restarts it according to its restart policy, until ctx is done
+func (n *supervisedNode) supervise(ctx context.Context) error {
+	p := &pipeline{g: n.s.g, name: n.node.Name, source: n.node.Source, transforms: n.node.Transforms, sink: n.node.Sink}
+	clock := n.s.g.Clock()
+	backoff := n.config.MinBackoff
+	restarts := 0
+	for {
+		n.setState(NodeRunning, nil)
+		start := clock.Now()
+		err := runSafely(ctx, p.run)
+		switch {
+		case ctx.Err() != nil:
+			n.setState(NodeStopped, nil)
+			return ctx.Err()
+		case err == nil && n.config.Restart != RestartAlways:
+			n.setState(NodeCompleted, nil)
+			return nil
+		case err != nil && n.config.Restart == RestartNever:
+			n.setState(NodeFailed, err)
+			return err
+		}
+		if clock.Now().Sub(start) > n.config.MaxBackoff {
+			backoff = n.config.MinBackoff
+			restarts = 0
+		}
+		if n.config.MaxRestarts > 0 && restarts >= n.config.MaxRestarts {
+			if err == nil {
+				err = errTooManyRestarts
+			}
+			n.setState(NodeFailed, err)
+			return fmt.Errorf("%w: %v", errTooManyRestarts, err)
+		}
+		n.setState(NodeRestarting, err)
+		Log.Warn("processing node stopped, restarting it", zap.String("node", n.node.Name), zap.Error(err), zap.Duration("backoff", backoff))
+		select {
+		case <-ctx.Done():
+			n.setState(NodeStopped, nil)
+			return ctx.Err()
+		case <-clock.After(backoff):
+		}
+		restarts++
+		n.mu.Lock()
+		n.restarts++
+		n.mu.Unlock()
+		n.metrics.restartsCounter.Inc()
+		backoff *= 2
+		if backoff > n.config.MaxBackoff {
+			backoff = n.config.MaxBackoff
+		}
+	}
+}
+
+func processingDAGHandler(g *Gaz) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.MarshalIndent(g.Supervisor().Status(), "", " ")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if _, err := w.Write(b); err != nil {
+			Log.Error("failed to write response", zap.Error(err))
+		}
+	}
+}
+
+type processingNodeMetrics struct {
+	upGauge         prometheus.Gauge
+	restartsCounter prometheus.Counter
+}
+
+// map of metrics registered to Prometheus, by node
+var processingNodeMetricsMu sync.Mutex
+var processingNodeMonitorings = make(map[string]*processingNodeMetrics)
+
+func processingNodeMonitoring(g *Gaz, name string) *processingNodeMetrics {
+	processingNodeMetricsMu.Lock()
+	defer processingNodeMetricsMu.Unlock()
+
+	if m, ok := processingNodeMonitorings[name]; ok {
+		return m
+	}
+	labels := prometheus.Labels{ProcessingNodeLabel: name}
+	m := &processingNodeMetrics{
+		upGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        ProcessingNodeUp,
+			Help:        "1 if the processing node is running, 0 otherwise",
+			ConstLabels: labels,
+		}),
+		restartsCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        ProcessingNodeRestarts,
+			Help:        "The total number of restarts of the processing node",
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.upGauge, m.restartsCounter)
+	processingNodeMonitorings[name] = m
+	return m
+}
@@ -0,0 +1,162 @@
+package gorillaz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// chanSource sends the events of in until ctx is done
+func chanSource(in <-chan *stream.Event) PipelineSource {
+	return func(ctx context.Context, out chan<- *stream.Event) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case e := <-in:
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+func chanSink(out chan<- *stream.Event) RelayPublisher {
+	return func(e *stream.Event) error {
+		out <- e
+		return nil
+	}
+}
+
+func nodeState(s *Supervisor, name string) NodeState {
+	for _, st := range s.Status() {
+		if st.Name == name {
+			return st.State
+		}
+	}
+	return ""
+}
+
+func TestSupervisorDAG(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry(), healthServer: health.NewServer()}
+	raw := make(chan *stream.Event)
+	clean := make(chan *stream.Event)
+	enriched := make(chan *stream.Event, 1)
+	s := g.Supervisor()
+
+	err := s.Declare(ProcessingNode{
+		Name:     "dag-clean",
+		Consumes: []string{"raw"},
+		Produces: []string{"clean"},
+		Source:   chanSource(raw),
+		Sink:     chanSink(clean),
+	})
+	assert.NoError(t, err)
+	err = s.Declare(ProcessingNode{
+		Name:     "dag-enrich",
+		Consumes: []string{"clean"},
+		Produces: []string{"enriched"},
+		Source:   chanSource(clean),
+		Transforms: []PipelineTransform{func(e *stream.Event) (*stream.Event, error) {
+			e.Value = append(e.Value, []byte("+enriched")...)
+			return e, nil
+		}},
+		Sink: chanSink(enriched),
+	})
+	assert.NoError(t, err)
+
+	assert.EqualError(t, s.Declare(ProcessingNode{Name: "dag-clean", Source: chanSource(raw), Sink: chanSink(clean)}), "node dag-clean already declared")
+	assert.EqualError(t, s.Declare(ProcessingNode{Name: "dag-loop", Consumes: []string{"enriched"}, Produces: []string{"raw"}, Source: chanSource(raw), Sink: chanSink(clean)}), "node dag-loop would make a cycle")
+	assert.EqualError(t, s.Declare(ProcessingNode{Name: "dag-sinkless", Source: chanSource(raw)}), "node dag-sinkless: missing source or sink")
+
+	raw <- &stream.Event{Key: []byte("k"), Value: []byte("v")}
+	select {
+	case e := <-enriched:
+		assert.Equal(t, "v+enriched", string(e.Value))
+	case <-time.After(time.Second):
+		t.Fatal("event not processed")
+	}
+
+	status := s.Status()
+	assert.Len(t, status, 2)
+	assert.Equal(t, "dag-clean", status[0].Name)
+	assert.Empty(t, status[0].Upstream)
+	assert.Equal(t, NodeRunning, status[0].State)
+	assert.Equal(t, []string{"dag-clean"}, status[1].Upstream)
+	res, err := g.healthServer.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "processing.dag-enrich"})
+	assert.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, res.Status)
+
+	rec := httptest.NewRecorder()
+	processingDAGHandler(g)(rec, httptest.NewRequest("GET", "/processing/dag", nil))
+	var served []NodeStatus
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Len(t, served, 2)
+	assert.Equal(t, []string{"clean"}, served[1].Consumes)
+
+	assert.True(t, g.stopGoroutines(time.Second))
+	assert.Equal(t, NodeStopped, nodeState(s, "dag-clean"))
+	assert.Equal(t, NodeStopped, nodeState(s, "dag-enrich"))
+	assert.True(t, s.Healthy())
+}
+
+func TestSupervisorRestartPolicy(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry(), healthServer: health.NewServer(), clock: clock}
+	defer g.stopGoroutines(time.Second)
+	runs := make(chan int, 10)
+	n := 0
+	err := g.Supervisor().Declare(ProcessingNode{
+		Name: "restart-failing",
+		Source: func(ctx context.Context, out chan<- *stream.Event) error {
+			n++
+			runs <- n
+			return errors.New("broken source")
+		},
+		Sink: chanSink(make(chan *stream.Event)),
+	}, NodeRestart(RestartOnFailure, time.Second, 10*time.Second), NodeMaxRestarts(1))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, <-runs)
+	waitForWaiters(t, clock)
+	assert.Equal(t, NodeRestarting, nodeState(g.Supervisor(), "restart-failing"))
+	clock.Advance(time.Second)
+	assert.Equal(t, 2, <-runs)
+
+	waitUntil(t, time.Second, "node failed", func() bool {
+		return nodeState(g.Supervisor(), "restart-failing") == NodeFailed
+	})
+	status := g.Supervisor().Status()[0]
+	assert.Equal(t, 1, status.Restarts)
+	assert.Equal(t, "broken source", status.LastError)
+	assert.False(t, g.Supervisor().Healthy())
+	res, err := g.healthServer.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "processing"})
+	assert.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, res.Status)
+	assertCounterEquals(t, g, map[string]string{ProcessingNodeLabel: "restart-failing"}, ProcessingNodeRestarts, 1)
+
+	// a node completing without error is not restarted unless RestartAlways
+	err = g.Supervisor().Declare(ProcessingNode{
+		Name: "restart-completed",
+		Source: func(ctx context.Context, out chan<- *stream.Event) error {
+			return nil
+		},
+		Sink: chanSink(make(chan *stream.Event)),
+	})
+	assert.NoError(t, err)
+	waitUntil(t, time.Second, "node completed", func() bool {
+		return nodeState(g.Supervisor(), "restart-completed") == NodeCompleted
+	})
+}
@@ -0,0 +1,110 @@
+package gorillaz
+
+import (
+	"context"
+	"strings"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+// TenantScope addresses the Nats subjects, Jetstream streams and gRPC streams of a given tenant,
+// independently of the tenant configured for the service
+type TenantScope struct {
+	g      *Gaz
+	tenant string
+}
+
+// ForTenant returns a TenantScope for the given tenant, an empty tenant addresses the unscoped names
+func (g *Gaz) ForTenant(tenant string) *TenantScope {
+	return &TenantScope{g: g, tenant: tenant}
+}
+
+// tenantStreamName returns the gRPC stream name prefixed by the tenant of the service, if any
+func (g *Gaz) tenantStreamName(streamName string) string {
+	return addTenantIfMissing(g.Tenant, streamName)
+}
+
+func addTenantIfMissing(tenant, name string) string {
+	if tenant == "" || strings.HasPrefix(name, tenant+"-") {
+		return name
+	}
+	return tenant + "-" + name
+}
+
+// addStreamScopeIfMissing prefixes the Jetstream stream name by the env and the tenant, each one only if missing
+func addStreamScopeIfMissing(env, tenant, streamName string) string {
+	if env == "" {
+		return addTenantIfMissing(tenant, streamName)
+	}
+	return env + "-" + addTenantIfMissing(tenant, strings.TrimPrefix(streamName, env+"-"))
+}
+
+// Tenant returns the tenant of the scope
+func (t *TenantScope) Tenant() string {
+	return t.tenant
+}
+
+// Subject returns the Nats subject of the tenant, prefixed by the env if configured
+func (t *TenantScope) Subject(subject string) string {
+	return t.g.tenantNatsSubject(t.tenant, subject)
+}
+
+// JetstreamName returns the name of the Jetstream stream of the tenant
+func (t *TenantScope) JetstreamName(streamName string) string {
+	return addStreamScopeIfMissing(t.g.Env, t.tenant, streamName)
+}
+
+// StreamName returns the name of the gRPC stream of the tenant
+func (t *TenantScope) StreamName(streamName string) string {
+	return addTenantIfMissing(t.tenant, streamName)
+}
+
+// NatsPublish publishes the event on a subject of the tenant
+func (t *TenantScope) NatsPublish(subject string, e *stream.Event, opts ...NatsPublishOpt) error {
+	return t.g.natsPublish(t.Subject(subject), e, opts...)
+}
+
+// NatsRequest sends a request on a subject of the tenant and waits for the reply
+func (t *TenantScope) NatsRequest(ctx context.Context, subject string, e *stream.Event, opts ...NatsPublishOpt) (*stream.Event, error) {
+	return t.g.monitoredNatsRequest(ctx, subject, t.Subject(subject), e, opts...)
+}
+
+// SubscribeNatsSubject subscribes to a subject of the tenant
+func (t *TenantScope) SubscribeNatsSubject(subject string, handler MsgHandler, opts ...NatsConsumerOpt) (*NatsSubscription, error) {
+	return t.g.subscribeNatsSubject(t.Subject(subject), handler, opts...)
+}
+
+// PullJetstream returns the next subject and event for the given stream of the tenant, see Gaz.PullJetstream
+func (t *TenantScope) PullJetstream(ctx context.Context, streamName string, consumer string) (subject string, event *stream.Event, err error) {
+	return t.g.pullJetstream(ctx, jsDefaultApiPrefix, t.JetstreamName(streamName), consumer)
+}
+
+// PullJetstreamBatch pulls messages from a stream of the tenant by batch, see Gaz.PullJetstreamBatch
+func (t *TenantScope) PullJetstreamBatch(ctx context.Context, streamName string, consumer string, options ...PullOption) (<-chan *stream.Event, <-chan error) {
+	return t.g.pullJetstreamBatch(ctx, jsDefaultApiPrefix, t.JetstreamName(streamName), t.g.AddConsumerEnvIfMissing(consumer), options...)
+}
+
+// NewStreamProvider returns a new provider for a stream of the tenant, see Gaz.NewStreamProvider
+func (t *TenantScope) NewStreamProvider(streamName, dataType string, opts ...ProviderConfigOpt) (*StreamProvider, error) {
+	return t.g.newStreamProvider(t.StreamName(streamName), dataType, opts...)
+}
+
+// NewGetAndWatchStreamProvider returns a new provider for a get and watch stream of the tenant, see Gaz.NewGetAndWatchStreamProvider
+func (t *TenantScope) NewGetAndWatchStreamProvider(streamName, dataType string, opts ...GetAndWatchConfigOpt) *GetAndWatchStreamProvider {
+	return t.g.newGetAndWatchStreamProvider(t.StreamName(streamName), dataType, opts...)
+}
+
+// DiscoverAndConsumeServiceStream consumes a stream of the tenant, see Gaz.DiscoverAndConsumeServiceStream
+func (t *TenantScope) DiscoverAndConsumeServiceStream(service, stream string, opts ...ConsumerConfigOpt) (StreamConsumer, error) {
+	return t.g.createConsumer([]string{SdPrefix + service}, t.StreamName(stream), opts...)
+}
+
+// ConsumeStream consumes a stream of the tenant on the given endpoints, see Gaz.ConsumeStream
+func (t *TenantScope) ConsumeStream(endpoints []string, stream string, opts ...ConsumerConfigOpt) (StreamConsumer, error) {
+	return t.g.createConsumer(endpoints, t.StreamName(stream), opts...)
+}
+
+// GetAndWatchStream consumes a get and watch stream of the tenant, see Gaz.GetAndWatchStream
+func (t *TenantScope) GetAndWatchStream(service, stream string, opts ...ConsumerConfigOpt) (GetAndWatchStreamConsumer, error) {
+	return t.g.createGetAndWatchConsumer([]string{SdPrefix + service}, t.StreamName(stream), opts...)
+}
@@ -0,0 +1,48 @@
+package gorillaz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantNames(t *testing.T) {
+	g := &Gaz{Env: "dev", Tenant: "acme", addEnvPrefixToNats: true}
+
+	assert.Equal(t, "dev.acme.orders.created", g.natsSubject("orders.created"))
+	assert.Equal(t, "dev-acme-orders", g.AddStreamEnvIfMissing("orders"))
+	assert.Equal(t, "dev-acme-orders", g.AddStreamEnvIfMissing("dev-orders"), "the tenant is added even if the env is present")
+	assert.Equal(t, "dev-acme-orders", g.AddStreamEnvIfMissing("dev-acme-orders"))
+	assert.Equal(t, "dev-acme-devices", g.AddStreamEnvIfMissing("devices"), "a name starting like the env is scoped")
+	assert.Equal(t, "acme-orders", g.tenantStreamName("orders"))
+	assert.Equal(t, "acme-orders", g.tenantStreamName("acme-orders"))
+
+	s := g.ForTenant("globex")
+	assert.Equal(t, "dev.globex.orders.created", s.Subject("orders.created"))
+	assert.Equal(t, "dev-globex-orders", s.JetstreamName("orders"))
+	assert.Equal(t, "globex-orders", s.StreamName("orders"))
+
+	s = g.ForTenant("")
+	assert.Equal(t, "dev.orders.created", s.Subject("orders.created"))
+	assert.Equal(t, "dev-orders", s.JetstreamName("orders"))
+	assert.Equal(t, "orders", s.StreamName("orders"))
+}
+
+func TestNoTenant(t *testing.T) {
+	g := &Gaz{Env: "dev"}
+
+	assert.Equal(t, "orders.created", g.natsSubject("orders.created"))
+	assert.Equal(t, "dev-orders", g.AddStreamEnvIfMissing("orders"))
+	assert.Equal(t, "orders", g.tenantStreamName("orders"))
+	assert.Equal(t, "acme.orders.created", g.ForTenant("acme").Subject("orders.created"))
+	assert.Equal(t, "dev-acme-orders", g.ForTenant("acme").JetstreamName("orders"))
+}
+
+func TestTenantWithoutEnv(t *testing.T) {
+	g := &Gaz{Tenant: "acme"}
+
+	assert.Equal(t, "acme-orders", g.AddStreamEnvIfMissing("orders"))
+	assert.Equal(t, "acme-orders", g.AddStreamEnvIfMissing("acme-orders"))
+	assert.Equal(t, "globex-orders", g.ForTenant("globex").JetstreamName("orders"))
+	assert.Equal(t, "orders", g.ForTenant("").JetstreamName("orders"))
+}
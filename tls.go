@@ -0,0 +1,94 @@
+package gorillaz
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// EndpointTLSConfig dials the stream providers over TLS with the given configuration
+func EndpointTLSConfig(c *tls.Config) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.tls = c.Clone()
+		// the configuration can't be compared, the connections are shared between the endpoints given the same one
+		config.tlsKey = fmt.Sprintf("config=%p;", c)
+	}
+}
+
+// EndpointServerCA dials the stream providers over TLS, verifying their certificates with the CA in caFile
+// instead of the system CAs
+func EndpointServerCA(caFile string) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			config.err = err
+			return
+		}
+		config.tlsConfig().RootCAs = pool
+		config.tlsKey += "ca=" + caFile + ";"
+	}
+}
+
+// EndpointInsecureSkipVerify dials the stream providers over TLS without verifying their certificates, for tests only
+func EndpointInsecureSkipVerify() StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.tlsConfig().InsecureSkipVerify = true
+		config.tlsKey += "insecure-skip-verify;"
+	}
+}
+
+// EndpointMutualTLS dials the stream providers over TLS, authenticating with the client certificate,
+// and verifying their certificates with the CA in caFile, the system CAs if caFile is empty.
+// The providers started with the grpc.server.tls.* keys require it, the identity of the certificate is used for the authorization.
+func EndpointMutualTLS(certFile, keyFile, caFile string) StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			config.err = err
+			return
+		}
+		c := config.tlsConfig()
+		c.Certificates = []tls.Certificate{cert}
+		config.tlsKey += "cert=" + certFile + "," + keyFile + ";"
+		if caFile != "" {
+			EndpointServerCA(caFile)(config)
+		}
+	}
+}
+
+// tlsConfig returns the TLS configuration of the endpoint, creating it so that the endpoint dials over TLS
+func (config *StreamEndpointConfig) tlsConfig() *tls.Config {
+	if config.tls == nil {
+		config.tls = &tls.Config{}
+	}
+	return config.tls
+}
+
+// endpointTLSFromConfig returns the TLS options configured by the grpc.client.tls.* keys, applied before the StreamEndpointConfigOpt
+func (g *Gaz) endpointTLSFromConfig() []StreamEndpointConfigOpt {
+	var opts []StreamEndpointConfigOpt
+	certFile, caFile := g.Viper.GetString("grpc.client.tls.cert"), g.Viper.GetString("grpc.client.tls.ca")
+	switch {
+	case certFile != "":
+		opts = append(opts, EndpointMutualTLS(certFile, g.Viper.GetString("grpc.client.tls.key"), caFile))
+	case caFile != "":
+		opts = append(opts, EndpointServerCA(caFile))
+	}
+	if g.Viper.GetBool("grpc.client.tls.insecure.skip.verify") {
+		opts = append(opts, EndpointInsecureSkipVerify())
+	}
+	return opts
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
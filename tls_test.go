@@ -0,0 +1,80 @@
+package gorillaz
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeSelfSignedCert(t, dir)
+
+	config := defaultStreamEndpointConfig()
+	EndpointMutualTLS(certFile, keyFile, certFile)(config)
+	assert.Nil(t, config.err)
+	assert.NotNil(t, config.tls)
+	assert.Len(t, config.tls.Certificates, 1)
+	assert.NotNil(t, config.tls.RootCAs)
+	assert.False(t, config.tls.InsecureSkipVerify)
+}
+
+func TestEndpointTLSErrors(t *testing.T) {
+	config := defaultStreamEndpointConfig()
+	EndpointServerCA("missing-ca.pem")(config)
+	assert.NotNil(t, config.err)
+
+	g := &Gaz{Viper: viper.New()}
+	g.Viper.Set("grpc.client.tls.ca", "missing-ca.pem")
+	_, err := g.newStreamEndpoint([]string{"localhost:0"})
+	assert.NotNil(t, err, "the endpoint must not be created without its CA")
+}
+
+func TestEndpointTLSFromConfig(t *testing.T) {
+	g := &Gaz{Viper: viper.New()}
+	assert.Empty(t, g.endpointTLSFromConfig(), "no TLS by default")
+
+	g.Viper.Set("grpc.client.tls.insecure.skip.verify", true)
+	config := defaultStreamEndpointConfig()
+	for _, opt := range g.endpointTLSFromConfig() {
+		opt(config)
+	}
+	assert.True(t, config.tls.InsecureSkipVerify)
+}
+
+func writeSelfSignedCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gorillaz-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
@@ -0,0 +1,135 @@
+package gorillaz
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/stats"
+)
+
+const (
+	StreamSentPayloadBytes             = "stream_sent_payload_bytes"
+	StreamSentBytes                    = "stream_sent_bytes"
+	StreamSentWireBytes                = "stream_sent_wire_bytes"
+	StreamConsumerReceivedPayloadBytes = "stream_consumer_received_payload_bytes"
+	StreamConsumerReceivedBytes        = "stream_consumer_received_bytes"
+	StreamConsumerReceivedWireBytes    = "stream_consumer_received_wire_bytes"
+)
+
+// trafficMetrics accounts the bytes of a stream, on the provider or on the consumer side:
+// the payload is the values of the events, the messages are the serialized events and their metadata,
+// the wire is the messages as sent on the network, once compressed if the stream is compressed
+type trafficMetrics struct {
+	payload prometheus.Counter
+	message prometheus.Counter
+	wire    prometheus.Counter
+}
+
+// map of metrics registered to Prometheus, by stream
+var trafficMetricsMu sync.Mutex
+var providerTraffics = make(map[string]*trafficMetrics)
+var consumerTraffics = make(map[string]*trafficMetrics)
+
+func providerTrafficMonitoring(g *Gaz, streamName string) *trafficMetrics {
+	return trafficMonitoring(g, providerTraffics, streamName, [3]string{StreamSentPayloadBytes, StreamSentBytes, StreamSentWireBytes}, "sent")
+}
+
+func consumerTrafficMonitoring(g *Gaz, streamName string) *trafficMetrics {
+	return trafficMonitoring(g, consumerTraffics, streamName, [3]string{StreamConsumerReceivedPayloadBytes, StreamConsumerReceivedBytes, StreamConsumerReceivedWireBytes}, "received")
+}
+
+func trafficMonitoring(g *Gaz, traffics map[string]*trafficMetrics, streamName string, names [3]string, direction string) *trafficMetrics {
+	trafficMetricsMu.Lock()
+	defer trafficMetricsMu.Unlock()
+
+	if m, ok := traffics[streamName]; ok {
+		return m
+	}
+	labels := prometheus.Labels{StreamNameLabel: streamName}
+	m := &trafficMetrics{
+		payload: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        names[0],
+			Help:        "The total number of bytes of event values " + direction,
+			ConstLabels: labels,
+		}),
+		message: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        names[1],
+			Help:        "The total number of bytes of serialized events " + direction + ", before compression",
+			ConstLabels: labels,
+		}),
+		wire: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        names[2],
+			Help:        "The total number of bytes of events " + direction + " on the wire, after compression",
+			ConstLabels: labels,
+		}),
+	}
+	g.prometheusRegistry.MustRegister(m.payload)
+	g.prometheusRegistry.MustRegister(m.message)
+	g.prometheusRegistry.MustRegister(m.wire)
+	traffics[streamName] = m
+	return m
+}
+
+// streamTrafficHandler is a gRPC stats handler accounting the messages and wire bytes of the streams,
+// the other gRPC calls are ignored
+type streamTrafficHandler struct {
+	g *Gaz
+}
+
+type trafficTagKey struct{}
+
+// trafficTag holds the metrics of a stream call, they are known once its request is sent or received
+type trafficTag struct {
+	metrics     atomic.Value
+	compression atomic.Value // compression holds the compression metrics of a stream consumed with a compression
+}
+
+func (h *streamTrafficHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	if !strings.HasPrefix(info.FullMethodName, "/stream.Stream/") {
+		return ctx
+	}
+	return context.WithValue(ctx, trafficTagKey{}, &trafficTag{})
+}
+
+func (h *streamTrafficHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	tag, ok := ctx.Value(trafficTagKey{}).(*trafficTag)
+	if !ok {
+		return
+	}
+	switch p := s.(type) {
+	case *stats.InPayload:
+		if p.Client {
+			if m, ok := tag.metrics.Load().(*trafficMetrics); ok {
+				m.message.Add(float64(p.Length))
+				m.wire.Add(float64(p.WireLength))
+			}
+			if m, ok := tag.compression.Load().(*compressionMetrics); ok {
+				m.decompressed.Add(float64(p.Length))
+				m.compressed.Add(float64(p.WireLength))
+			}
+		} else if req, ok := p.Payload.(StreamRequest); ok {
+			tag.metrics.Store(providerTrafficMonitoring(h.g, req.GetName()))
+		}
+	case *stats.OutPayload:
+		if !p.Client {
+			if m, ok := tag.metrics.Load().(*trafficMetrics); ok {
+				m.message.Add(float64(p.Length))
+				m.wire.Add(float64(p.WireLength))
+			}
+		} else if req, ok := p.Payload.(StreamRequest); ok {
+			tag.metrics.Store(consumerTrafficMonitoring(h.g, req.GetName()))
+			if compressedCall(ctx) {
+				tag.compression.Store(compressionMonitoring(h.g, req.GetName()))
+			}
+		}
+	}
+}
+
+func (h *streamTrafficHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *streamTrafficHandler) HandleConn(context.Context, stats.ConnStats) {}
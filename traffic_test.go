@@ -0,0 +1,38 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/stats"
+)
+
+func TestStreamTrafficHandler(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	h := &streamTrafficHandler{g: g}
+
+	// provider side
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/stream.Stream/Stream"})
+	h.HandleRPC(ctx, &stats.OutPayload{Payload: []byte{}, Length: 10, WireLength: 10})
+	h.HandleRPC(ctx, &stats.InPayload{Payload: &stream.StreamRequest{Name: "traffic"}})
+	h.HandleRPC(ctx, &stats.OutPayload{Payload: []byte{}, Length: 100, WireLength: 40})
+	h.HandleRPC(ctx, &stats.OutPayload{Payload: []byte{}, Length: 100, WireLength: 40})
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "traffic"}, StreamSentBytes, 200)
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "traffic"}, StreamSentWireBytes, 80)
+
+	// consumer side
+	ctx = h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/stream.Stream/GetAndWatch"})
+	h.HandleRPC(ctx, &stats.OutPayload{Client: true, Payload: &stream.GetAndWatchRequest{Name: "traffic"}})
+	h.HandleRPC(ctx, &stats.InPayload{Client: true, Length: 100, WireLength: 40})
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "traffic"}, StreamConsumerReceivedBytes, 100)
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "traffic"}, StreamConsumerReceivedWireBytes, 40)
+
+	// other calls are ignored
+	ctx = h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/test.TestService/Ping"})
+	assert.Nil(t, ctx.Value(trafficTagKey{}))
+	h.HandleRPC(ctx, &stats.OutPayload{Length: 100, WireLength: 40})
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "traffic"}, StreamSentBytes, 200)
+}
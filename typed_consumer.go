@@ -0,0 +1,153 @@
+package gorillaz
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	StreamConsumerDecodeErrors = "stream_consumer_decode_errors"
+)
+
+// Decoder decodes the values of the events of a stream into the type of the application
+type Decoder interface {
+	Decode(value []byte) (interface{}, error)
+}
+
+// DecoderFunc is a function implementing Decoder
+type DecoderFunc func(value []byte) (interface{}, error)
+
+func (f DecoderFunc) Decode(value []byte) (interface{}, error) {
+	return f(value)
+}
+
+// ProtoDecoder decodes the values into new messages of the same type as msg
+func ProtoDecoder(msg proto.Message) Decoder {
+	return DecoderFunc(func(value []byte) (interface{}, error) {
+		m := msg.ProtoReflect().New().Interface()
+		if err := proto.Unmarshal(value, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	})
+}
+
+// JSONDecoder decodes the JSON values into new values of the same type as v, v being a pointer such as &Flight{}
+func JSONDecoder(v interface{}) Decoder {
+	t := reflect.TypeOf(v).Elem()
+	return DecoderFunc(func(value []byte) (interface{}, error) {
+		d := reflect.New(t).Interface()
+		if err := json.Unmarshal(value, d); err != nil {
+			return nil, err
+		}
+		return d, nil
+	})
+}
+
+// TypedEvent is an event of a stream with its decoded value
+type TypedEvent struct {
+	*stream.Event
+	Decoded interface{} // Decoded is the value returned by the Decoder, such as a *Flight for ProtoDecoder(&Flight{})
+}
+
+// DecodeError is an event which could not be decoded
+type DecodeError struct {
+	Event *stream.Event
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return "cannot decode event: " + e.Err.Error()
+}
+
+type TypedConsumer interface {
+	StoppableStream
+	// EvtChan returns the channel of the decoded events, it is closed by gorillaz when the consumer stops
+	EvtChan() <-chan *TypedEvent
+	// Errors returns the channel of the events which could not be decoded, they are dropped if it is not read
+	Errors() <-chan *DecodeError
+}
+
+type typedConsumer struct {
+	StreamConsumer
+	evtChan chan *TypedEvent
+	errors  chan *DecodeError
+}
+
+func (c *typedConsumer) EvtChan() <-chan *TypedEvent {
+	return c.evtChan
+}
+
+func (c *typedConsumer) Errors() <-chan *DecodeError {
+	return c.errors
+}
+
+// ConsumeTyped consumes a stream like ConsumeStream, and decodes the values of its events with the decoder before
+// delivering them. The events which cannot be decoded are counted, and sent to the Errors channel.
+func (g *Gaz) ConsumeTyped(endpoints []string, streamName string, decoder Decoder, opts ...ConsumerConfigOpt) (TypedConsumer, error) {
+	sc, err := g.ConsumeStream(endpoints, streamName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	config := defaultConsumerConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+	c := &typedConsumer{
+		StreamConsumer: sc,
+		evtChan:        make(chan *TypedEvent, config.BufferLen),
+		errors:         make(chan *DecodeError, config.BufferLen),
+	}
+	g.goTracked("typed_consumer", sc.StreamName(), func() {
+		decodeEvents(g, sc.StreamName(), sc.EvtChan(), decoder, c.evtChan, c.errors)
+	})
+	return c, nil
+}
+
+// decodeEvents decodes the events until in is closed, then closes out and errs
+func decodeEvents(g *Gaz, streamName string, in <-chan *stream.Event, decoder Decoder, out chan<- *TypedEvent, errs chan<- *DecodeError) {
+	defer close(out)
+	defer close(errs)
+	decodeErrors := decodeErrorsCounter(g, streamName)
+	for e := range in {
+		v, err := decoder.Decode(e.Value)
+		if err != nil {
+			decodeErrors.Inc()
+			Log.Debug("cannot decode event", zap.String("stream", streamName), RedactedKey(e.Key), zap.Error(err))
+			select {
+			case errs <- &DecodeError{Event: e, Err: err}:
+			default:
+			}
+			continue
+		}
+		out <- &TypedEvent{Event: e, Decoded: v}
+	}
+}
+
+var decodeErrorsMu sync.Mutex
+var decodeErrorsCounters = make(map[string]prometheus.Counter)
+
+func decodeErrorsCounter(g *Gaz, streamName string) prometheus.Counter {
+	decodeErrorsMu.Lock()
+	defer decodeErrorsMu.Unlock()
+
+	if c, ok := decodeErrorsCounters[streamName]; ok {
+		return c
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: StreamConsumerDecodeErrors,
+		Help: "The total number of events received whose value could not be decoded",
+		ConstLabels: prometheus.Labels{
+			StreamNameLabel: streamName,
+		},
+	})
+	g.prometheusRegistry.MustRegister(c)
+	decodeErrorsCounters[streamName] = c
+	return c
+}
@@ -0,0 +1,45 @@
+package gorillaz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDecodeEvents(t *testing.T) {
+	g := &Gaz{prometheusRegistry: prometheus.NewRegistry()}
+	value, err := proto.Marshal(&stream.Metadata{EventType: "flight"})
+	assert.Nil(t, err)
+
+	in := make(chan *stream.Event, 2)
+	in <- &stream.Event{Ctx: context.Background(), Key: []byte("ok"), Value: value}
+	in <- &stream.Event{Ctx: context.Background(), Key: []byte("invalid"), Value: []byte{0xff}}
+	close(in)
+	out := make(chan *TypedEvent, 2)
+	errs := make(chan *DecodeError, 2)
+	decodeEvents(g, "typed", in, ProtoDecoder(&stream.Metadata{}), out, errs)
+
+	e := <-out
+	assert.Equal(t, "ok", string(e.Key))
+	assert.Equal(t, "flight", e.Decoded.(*stream.Metadata).EventType)
+	_, open := <-out
+	assert.False(t, open)
+
+	de := <-errs
+	assert.Equal(t, "invalid", string(de.Event.Key))
+	assert.NotNil(t, de.Err)
+	assertCounterEquals(t, g, prometheus.Labels{StreamNameLabel: "typed"}, StreamConsumerDecodeErrors, 1)
+}
+
+func TestJSONDecoder(t *testing.T) {
+	type flight struct {
+		Callsign string `json:"callsign"`
+	}
+	v, err := JSONDecoder(&flight{}).Decode([]byte(`{"callsign":"AFR123"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, &flight{Callsign: "AFR123"}, v)
+}
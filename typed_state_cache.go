@@ -0,0 +1,110 @@
+package gorillaz
+
+import (
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// TypedCacheEntry is the entry of a key in a TypedStateCache, along with its decoded value
+type TypedCacheEntry struct {
+	CacheEntry
+	Message proto.Message // Message is the decoded value, a copy owned by the reader, nil if the value could not be decoded
+}
+
+// TypedCacheUpdate is a change of a key in a TypedStateCache
+type TypedCacheUpdate struct {
+	Entry   TypedCacheEntry
+	Deleted bool
+}
+
+// TypedStateCache is a StateCache whose values are protobuf messages, decoded once when they are received
+// instead of by every reader. The messages read are copies, the readers may modify them.
+// The values which cannot be decoded are counted in stream_consumer_decode_errors, their entries have no Message.
+type TypedStateCache struct {
+	*StateCache
+}
+
+// NewTypedStateCache watches the stream of the service and maintains its state locally, decoding the values
+// into messages of the same type as msg
+func (g *Gaz) NewTypedStateCache(service, streamName string, msg proto.Message, opts ...ConsumerConfigOpt) (*TypedStateCache, error) {
+	return g.newTypedStateCache(msg, opts, func(opts ...ConsumerConfigOpt) (GetAndWatchStreamConsumer, error) {
+		return g.GetAndWatchStream(service, streamName, opts...)
+	})
+}
+
+// ConsumeTypedStateCache watches the stream of the service endpoints and maintains its state locally, decoding the values
+// into messages of the same type as msg
+func (g *Gaz) ConsumeTypedStateCache(endpoints []string, streamName string, msg proto.Message, opts ...ConsumerConfigOpt) (*TypedStateCache, error) {
+	return g.newTypedStateCache(msg, opts, func(opts ...ConsumerConfigOpt) (GetAndWatchStreamConsumer, error) {
+		return g.ConsumeGetAndWatchStream(endpoints, streamName, opts...)
+	})
+}
+
+func (g *Gaz) newTypedStateCache(msg proto.Message, opts []ConsumerConfigOpt, consume func(opts ...ConsumerConfigOpt) (GetAndWatchStreamConsumer, error)) (*TypedStateCache, error) {
+	c := &StateCache{clock: g.Clock(), decode: protoMessageDecoder(msg)}
+	opts = append(opts, c.connectionHooks)
+	consumer, err := consume(opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.decodeErrors = decodeErrorsCounter(g, consumer.StreamName())
+	c.init(consumer.StreamName(), consumer.EvtChan(), consumer.Stop)
+	return &TypedStateCache{StateCache: c}, nil
+}
+
+// protoMessageDecoder decodes the values into new messages of the same type as msg
+func protoMessageDecoder(msg proto.Message) func(value []byte) (proto.Message, error) {
+	return func(value []byte) (proto.Message, error) {
+		m := msg.ProtoReflect().New().Interface()
+		if err := proto.Unmarshal(value, m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+}
+
+// typed returns the entry with a copy of its decoded value
+func typed(e CacheEntry) TypedCacheEntry {
+	t := TypedCacheEntry{CacheEntry: e}
+	if e.message != nil {
+		t.Message = proto.Clone(e.message)
+	}
+	return t
+}
+
+// Get returns the entry of the key
+func (c *TypedStateCache) Get(key []byte) (TypedCacheEntry, bool) {
+	e, ok := c.StateCache.Get(key)
+	if !ok {
+		return TypedCacheEntry{}, false
+	}
+	return typed(e), true
+}
+
+// List returns the entries whose key starts with keyPrefix, sorted by key. An empty prefix lists all the entries.
+func (c *TypedStateCache) List(keyPrefix []byte) []TypedCacheEntry {
+	entries := c.StateCache.List(keyPrefix)
+	res := make([]TypedCacheEntry, len(entries))
+	for i, e := range entries {
+		res[i] = typed(e)
+	}
+	return res
+}
+
+// Watch returns a channel receiving the updates of the keys starting with keyPrefix, from now on, see StateCache.Subscribe.
+// Updates are dropped when the channel is full. The channel is closed when the cache is stopped or cancel is called.
+func (c *TypedStateCache) Watch(keyPrefix []byte, bufLen int) (updates <-chan TypedCacheUpdate, cancel func()) {
+	in, cancel := c.StateCache.Subscribe(keyPrefix, bufLen)
+	out := make(chan TypedCacheUpdate, bufLen)
+	go func() {
+		defer close(out)
+		for u := range in {
+			select {
+			case out <- TypedCacheUpdate{Entry: typed(u.Entry), Deleted: u.Deleted}:
+			default:
+				Log.Warn("state cache subscriber not consuming fast enough, update dropped", zap.String("stream", c.streamName), RedactedKey(u.Entry.Key))
+			}
+		}
+	}()
+	return out, cancel
+}
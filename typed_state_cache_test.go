@@ -0,0 +1,51 @@
+package gorillaz
+
+import (
+	"testing"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTypedStateCache(t *testing.T) {
+	g := New(WithServiceName("test"), WithMockedServiceDiscovery())
+	defer g.Shutdown()
+	<-g.Run()
+
+	events := make(chan *stream.GetAndWatchEvent)
+	c := &StateCache{decode: protoMessageDecoder(&stream.StreamRequest{}), decodeErrors: decodeErrorsCounter(g, "typed-state-cache")}
+	c.init("typed-state-cache", events, func() bool { return false })
+	cache := &TypedStateCache{StateCache: c}
+	updates, cancel := cache.Watch([]byte("eu."), 10)
+	defer cancel()
+
+	value, err := proto.Marshal(&stream.StreamRequest{Name: "af123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.apply(gwEvent(stream.EventType_UPDATE, "eu.af123", string(value)))
+	c.apply(gwEvent(stream.EventType_UPDATE, "eu.invalid", "\xff"))
+
+	e, ok := cache.Get([]byte("eu.af123"))
+	assert.True(t, ok)
+	assert.Equal(t, "af123", e.Message.(*stream.StreamRequest).Name)
+
+	// the messages read are copies
+	e.Message.(*stream.StreamRequest).Name = "modified"
+	e, _ = cache.Get([]byte("eu.af123"))
+	assert.Equal(t, "af123", e.Message.(*stream.StreamRequest).Name)
+
+	e, ok = cache.Get([]byte("eu.invalid"))
+	assert.True(t, ok)
+	assert.Nil(t, e.Message)
+	assertCounterEquals(t, g, map[string]string{StreamNameLabel: "typed-state-cache"}, StreamConsumerDecodeErrors, 1)
+
+	list := cache.List([]byte("eu."))
+	assert.Len(t, list, 2)
+	assert.Equal(t, "af123", list[0].Message.(*stream.StreamRequest).Name)
+
+	u := <-updates
+	assert.Equal(t, "eu.af123", string(u.Entry.Key))
+	assert.Equal(t, "af123", u.Entry.Message.(*stream.StreamRequest).Name)
+}
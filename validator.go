@@ -0,0 +1,111 @@
+package gorillaz
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/skysoft-atm/gorillaz/stream"
+)
+
+const (
+	StreamInvalidEvents         = "stream_invalid_events"
+	StreamConsumerInvalidEvents = "stream_consumer_invalid_events"
+)
+
+// Validator checks events before they are submitted by a provider or delivered by a consumer
+type Validator interface {
+	Validate(e *stream.Event) error
+}
+
+// ValidatorFunc is a function usable as Validator
+type ValidatorFunc func(e *stream.Event) error
+
+func (f ValidatorFunc) Validate(e *stream.Event) error {
+	return f(e)
+}
+
+// Validators returns a Validator rejecting the events rejected by any of validators
+func Validators(validators ...Validator) Validator {
+	return ValidatorFunc(func(e *stream.Event) error {
+		for _, v := range validators {
+			if err := v.Validate(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RequireKey rejects the events without key
+func RequireKey() Validator {
+	return ValidatorFunc(func(e *stream.Event) error {
+		if len(e.Key) == 0 {
+			return errors.New("event has no key")
+		}
+		return nil
+	})
+}
+
+// MaxValueSize rejects the events whose value is bigger than maxBytes
+func MaxValueSize(maxBytes int) Validator {
+	return ValidatorFunc(func(e *stream.Event) error {
+		if len(e.Value) > maxBytes {
+			return fmt.Errorf("event value of %d bytes exceeds the maximum of %d bytes", len(e.Value), maxBytes)
+		}
+		return nil
+	})
+}
+
+// AllowedEventTypes rejects the events whose type is not one of eventTypes
+func AllowedEventTypes(eventTypes ...string) Validator {
+	allowed := make(map[string]struct{}, len(eventTypes))
+	for _, t := range eventTypes {
+		allowed[t] = struct{}{}
+	}
+	return ValidatorFunc(func(e *stream.Event) error {
+		if _, ok := allowed[e.EventTypeStr()]; !ok {
+			return fmt.Errorf("unknown event type %q", e.EventTypeStr())
+		}
+		return nil
+	})
+}
+
+// ProviderValidator validates the events submitted to the provider, invalid events are not sent
+func ProviderValidator(v Validator) ProviderConfigOpt {
+	return func(p *ProviderConfig) {
+		p.Validator = v
+	}
+}
+
+// WithValidator validates the events received by the consumer, invalid events are not delivered
+func WithValidator(v Validator) ConsumerConfigOpt {
+	return func(c *ConsumerConfig) {
+		c.Validator = v
+	}
+}
+
+var invalidEventsMu sync.Mutex
+var invalidEventsCounters = make(map[string]prometheus.Counter)
+
+// invalidEventsCounter returns the counter of invalid events named name for the stream
+func invalidEventsCounter(g *Gaz, name, streamName string) prometheus.Counter {
+	invalidEventsMu.Lock()
+	defer invalidEventsMu.Unlock()
+
+	k := name + "/" + streamName
+	if c, ok := invalidEventsCounters[k]; ok {
+		return c
+	}
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: name,
+		Help: "The total number of events rejected by the stream validator",
+		ConstLabels: prometheus.Labels{
+			StreamNameLabel: streamName,
+		},
+	})
+	g.prometheusRegistry.MustRegister(c)
+	invalidEventsCounters[k] = c
+	return c
+}
@@ -0,0 +1,28 @@
+package gorillaz
+
+import (
+	"testing"
+
+	"github.com/skysoft-atm/gorillaz/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidators(t *testing.T) {
+	v := Validators(RequireKey(), MaxValueSize(4), AllowedEventTypes("flight"))
+
+	valid := &stream.Event{Key: []byte("k"), Value: []byte("v")}
+	valid.SetEventTypeStr("flight")
+	assert.Nil(t, v.Validate(valid))
+
+	noKey := valid.Clone()
+	noKey.Key = nil
+	assert.NotNil(t, v.Validate(noKey))
+
+	tooBig := valid.Clone()
+	tooBig.Value = []byte("value")
+	assert.NotNil(t, v.Validate(tooBig))
+
+	unknownType := valid.Clone()
+	unknownType.SetEventTypeStr("airport")
+	assert.NotNil(t, v.Validate(unknownType))
+}
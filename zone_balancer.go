@@ -0,0 +1,129 @@
+package gorillaz
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+// ZoneAwareBalancerName is the gRPC load balancing policy preferring the providers in the zone of the service
+const ZoneAwareBalancerName = "gorillaz_zone_aware"
+
+// the zone and the weight of a service are registered in the metadata of its service definition
+const (
+	zoneMetadata   = "zone"
+	weightMetadata = "weight"
+)
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(ZoneAwareBalancerName, &zoneAwarePickerBuilder{}, base.Config{}))
+}
+
+// EndpointZoneAware sends the streams of the endpoint to the providers in the zone of the service, given by the "zone" key,
+// to reduce the cross-zone traffic. When none of them is available, the streams spill over to the providers of the other zones,
+// in proportion of their weight. The zone and the weight of the providers are found in the service discovery.
+func EndpointZoneAware() StreamEndpointConfigOpt {
+	return func(config *StreamEndpointConfig) {
+		config.zoneAware = true
+	}
+}
+
+// addressZone is the zone and the weight of a provider address, set in its attributes by the service discovery resolver
+type addressZone struct {
+	local  bool // local is true if the provider is in the zone of the service
+	weight int
+}
+
+type addressZoneKey struct{}
+
+// zoneAttributes returns the attributes of the address of a provider registered with the metadata
+func zoneAttributes(localZone string, meta map[string]string) *attributes.Attributes {
+	z := addressZone{weight: 1}
+	if zone, ok := meta[zoneMetadata]; ok && zone != "" {
+		z.local = zone == localZone
+	}
+	if w, err := strconv.Atoi(meta[weightMetadata]); err == nil && w > 0 {
+		z.weight = w
+	}
+	return attributes.New(addressZoneKey{}, z)
+}
+
+// zoneAttributesCache reuses the attributes of the addresses across the updates of the resolver,
+// gRPC compares the addresses with their attributes and would otherwise reconnect to the providers on each update
+type zoneAttributesCache map[string]*attributes.Attributes
+
+// update returns the attributes of the address of a provider, and keeps them for the next update
+func (c zoneAttributesCache) update(next zoneAttributesCache, localZone, addr string, meta map[string]string) *attributes.Attributes {
+	k := addr + "/" + meta[zoneMetadata] + "/" + meta[weightMetadata]
+	a, ok := c[k]
+	if !ok {
+		a = zoneAttributes(localZone, meta)
+	}
+	next[k] = a
+	return a
+}
+
+func zoneOf(addr resolver.Address) addressZone {
+	if addr.Attributes != nil {
+		if z, ok := addr.Attributes.Value(addressZoneKey{}).(addressZone); ok {
+			return z
+		}
+	}
+	return addressZone{weight: 1}
+}
+
+type zoneAwarePickerBuilder struct{}
+
+func (*zoneAwarePickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	var local, all weightedSubConns
+	for sc, sci := range info.ReadySCs {
+		z := zoneOf(sci.Address)
+		all.add(sc, z.weight)
+		if z.local {
+			local.add(sc, z.weight)
+		}
+	}
+	if len(local.subConns) > 0 {
+		return &weightedPicker{subConns: local, rand: rand.New(rand.NewSource(rand.Int63()))}
+	}
+	return &weightedPicker{subConns: all, rand: rand.New(rand.NewSource(rand.Int63()))}
+}
+
+type weightedSubConns struct {
+	subConns []balancer.SubConn
+	weights  []int // weights are the cumulated weights of the sub connections
+	total    int
+}
+
+func (w *weightedSubConns) add(sc balancer.SubConn, weight int) {
+	w.total += weight
+	w.subConns = append(w.subConns, sc)
+	w.weights = append(w.weights, w.total)
+}
+
+// weightedPicker picks the sub connections at random, in proportion of their weight
+type weightedPicker struct {
+	subConns weightedSubConns
+	mu       sync.Mutex
+	rand     *rand.Rand
+}
+
+func (p *weightedPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	n := p.rand.Intn(p.subConns.total)
+	p.mu.Unlock()
+	for i, w := range p.subConns.weights {
+		if n < w {
+			return balancer.PickResult{SubConn: p.subConns.subConns[i]}, nil
+		}
+	}
+	return balancer.PickResult{SubConn: p.subConns.subConns[len(p.subConns.subConns)-1]}, nil
+}
@@ -0,0 +1,67 @@
+package gorillaz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func zoneAddress(localZone, zone, weight string) base.SubConnInfo {
+	meta := map[string]string{zoneMetadata: zone, weightMetadata: weight}
+	return base.SubConnInfo{Address: resolver.Address{Attributes: zoneAttributes(localZone, meta)}}
+}
+
+func pickCounts(p balancer.Picker, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		r, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			panic(err)
+		}
+		counts[r.SubConn.(*fakeSubConn).name]++
+	}
+	return counts
+}
+
+func TestZoneAwarePicker(t *testing.T) {
+	local, remote1, remote2 := &fakeSubConn{name: "local"}, &fakeSubConn{name: "remote1"}, &fakeSubConn{name: "remote2"}
+	b := &zoneAwarePickerBuilder{}
+
+	p := b.Build(base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+		local:   zoneAddress("eu-1", "eu-1", "1"),
+		remote1: zoneAddress("eu-1", "eu-2", "1"),
+	}})
+	assert.Equal(t, map[string]int{"local": 100}, pickCounts(p, 100))
+
+	// spill over the other zones by weight
+	p = b.Build(base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+		remote1: zoneAddress("eu-1", "eu-2", "3"),
+		remote2: zoneAddress("eu-1", "eu-3", "1"),
+	}})
+	counts := pickCounts(p, 4000)
+	assert.InDelta(t, 3000, counts["remote1"], 200)
+	assert.InDelta(t, 1000, counts["remote2"], 200)
+
+	_, err := b.Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{})
+	assert.Equal(t, balancer.ErrNoSubConnAvailable, err)
+}
+
+func TestZoneAttributesCache(t *testing.T) {
+	meta := map[string]string{zoneMetadata: "eu-1"}
+	var cache zoneAttributesCache
+	next := make(zoneAttributesCache)
+	a := cache.update(next, "eu-1", "10.0.0.1:8080", meta)
+	assert.True(t, zoneOf(resolver.Address{Attributes: a}).local)
+
+	cache, next = next, make(zoneAttributesCache)
+	assert.True(t, a == cache.update(next, "eu-1", "10.0.0.1:8080", meta), "the attributes must be reused")
+	assert.False(t, a == cache.update(next, "eu-1", "10.0.0.1:8080", map[string]string{zoneMetadata: "eu-2"}))
+}